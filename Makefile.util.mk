@@ -1,4 +1,4 @@
-.PHONY: lint test race acceptance bench cover list deps-check deps-download changelog
+.PHONY: lint test race acceptance bench fuzz cover list deps-check deps-download changelog
 
 OUT_FORMAT ?= colored-line-number
 LINT_FLAGS ?=  $(if $V,-v)
@@ -24,6 +24,14 @@ acceptance: .GOPATH/.ok .GOPATH/.ok bin/gotestsum gitlab-pages
 bench: .GOPATH/.ok gitlab-pages
 	go test -bench=. -run=^$$ $(allpackages)
 
+FUZZTIME ?= 30s
+
+# The seed corpora are in the testdata/fuzz directories of the packages
+fuzz: .GOPATH/.ok
+	go test -run=^$$ -fuzz=FuzzRedirects -fuzztime=$(FUZZTIME) ./internal/redirects
+	go test -run=^$$ -fuzz=FuzzEntryName -fuzztime=$(FUZZTIME) ./internal/vfs/zip
+	go test -run=^$$ -fuzz=FuzzHeaders -fuzztime=$(FUZZTIME) ./internal/headers
+
 # The acceptance tests cannot count for coverage
 cover: gitlab-pages
 	@echo "NOTE: make cover does not exit 1 on failure, don't use it to check for tests success!"
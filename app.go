@@ -3,15 +3,21 @@ package main
 import (
 	"context"
 	cryptotls "crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
+	"path"
+	"strings"
 	"sync"
 	"time"
 
 	ghandlers "github.com/gorilla/handlers"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/cors"
 	"gitlab.com/gitlab-org/labkit/log"
 
@@ -22,30 +28,49 @@ import (
 	"gitlab.com/gitlab-org/labkit/monitoring"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/acme"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/analytics"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/artifact"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/auth"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/bandwidth"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/cachedump"
 	cfg "gitlab.com/gitlab-org/gitlab-pages/internal/config"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/config/tls"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/customheaders"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/debugtrace"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/diagnostics"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/egress"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/feature"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/forwarded"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/handlers"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/hooks"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httperrors"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/httptransport"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/logging"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/lru"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/metricsauth"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/microcache"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/netutil"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/rejectmethods"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/request"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/requestfilter"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/routing"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/service"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving/disk/local"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/serving/disk/zip"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/slo"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/auto"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/client"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/hostname"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/tenantmetrics"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/urilimiter"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/urlpath"
 	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
-const (
-	xForwardedHost = "X-Forwarded-Host"
-)
-
 var (
 	corsHandler = cors.New(cors.Options{AllowedMethods: []string{http.MethodGet, http.MethodHead}})
 )
@@ -57,10 +82,68 @@ type theApp struct {
 	Auth           *auth.Auth
 	Handlers       *handlers.Handlers
 	AcmeMiddleware *acme.Middleware
-	CustomHeaders  http.Header
+	CustomHeaders  *customheaders.Headers
+	Hooks          *hooks.Hooks
+	Analytics      *analytics.Collector
+	TenantMetrics  *tenantmetrics.Counter
+	MicroCache     *microcache.Cache
+	RequestFilter  *requestfilter.Filter
+	Bandwidth      *bandwidth.Limiter
+	// trustedProxies forward the host clients requested to HTTP(S) listeners
+	trustedProxies forwarded.Proxies
+	// sloWindow measures the requests against the service level objectives
+	sloWindow *slo.Window
+	// instanceName is the pages domain of a virtual instance, it is empty
+	// for the main instance
+	instanceName string
+	// instances are the virtual instances serving other pages domains from
+	// the same listeners
+	instances []*theApp
+	// instanceMatches caches the instance serving each host when there are
+	// virtual instances
+	instanceMatches *lru.Cache
+	// rootCertificate is the wildcard certificate of a virtual instance, the
+	// one of the main instance is served when nil
+	rootCertificate *cryptotls.Certificate
+	// servers are the HTTP servers of the listeners, they are shut down
+	// gracefully when Pages stops
+	serversMu sync.Mutex
+	servers   []*http.Server
+	listening bool
+	stopping  bool
+	// main is the instance serving the listeners of a virtual instance, it is
+	// nil for the main instance
+	main *theApp
+	// stopped is closed once Pages stopped gracefully, Run waits for it
+	// when it is set
+	stopped chan struct{}
 }
 
+// isReady reports whether Pages serves requests: its listeners accept
+// connections and the domains source is ready
 func (a *theApp) isReady() bool {
+	return a.isListening() && a.isSourceReady()
+}
+
+// isListening reports whether the listeners accept connections, they stop
+// when Pages is stopping
+func (a *theApp) isListening() bool {
+	if a.main != nil {
+		return a.main.isListening()
+	}
+
+	a.serversMu.Lock()
+	defer a.serversMu.Unlock()
+
+	return a.listening && !a.stopping
+}
+
+// isSourceReady reports whether the domains source is ready to serve domains
+func (a *theApp) isSourceReady() bool {
+	if r, ok := a.source.(source.Readiness); ok {
+		return r.IsReady()
+	}
+
 	return true
 }
 
@@ -69,23 +152,155 @@ func (a *theApp) ServeTLS(ch *cryptotls.ClientHelloInfo) (*cryptotls.Certificate
 		return nil, nil
 	}
 
-	if domain, _ := a.domain(context.Background(), ch.ServerName); domain != nil {
-		tls, _ := domain.EnsureCertificate()
-		return tls, nil
+	instance, err := a.instance(context.Background(), ch.ServerName)
+	if err != nil {
+		return nil, err
+	}
+
+	if domain, _ := instance.domain(context.Background(), ch.ServerName); domain != nil {
+		cert, err := domainCertificate(domain, instance.config.TLS.InvalidCertificatePolicy, time.Now())
+		if cert != nil || err != nil {
+			return cert, err
+		}
+	}
+
+	return instance.rootCertificate, nil
+}
+
+// domainCertificate returns the certificate of a custom domain, applying
+// policy when it fails to load, is self-signed or expired. A nil certificate
+// falls back to the wildcard certificate of the instance.
+func domainCertificate(d *domain.Domain, policy string, now time.Time) (*cryptotls.Certificate, error) {
+	if !d.HasCertificate() {
+		return nil, nil
+	}
+
+	cert, err := d.VerifyCertificate(now)
+	reason := "invalid"
+
+	switch {
+	case err == nil:
+		return cert, nil
+	case errors.Is(err, domain.ErrCertificateSelfSigned):
+		reason = "self_signed"
+	case errors.Is(err, domain.ErrCertificateExpired):
+		reason = "expired"
+	}
+
+	d.ReportCertificateError(func() {
+		metrics.TLSInvalidCertificates.WithLabelValues(reason, policy).Inc()
+		log.WithFields(log.Fields{
+			"pages_domain": d.Name,
+			"reason":       reason,
+			"policy":       policy,
+		}).WithError(err).Warn("invalid custom domain certificate")
+	})
+
+	switch policy {
+	case cfg.TLSInvalidCertificateReject:
+		return nil, fmt.Errorf("invalid certificate for %q: %w", d.Name, err)
+	case cfg.TLSInvalidCertificateServe:
+		// certificates which fail to load can not be served
+		if reason != "invalid" {
+			return cert, nil
+		}
 	}
 
 	return nil, nil
 }
 
+// getConfigForClient returns a function that applies the domain TLS policy on
+// top of the instance TLS config
+func (a *theApp) getConfigForClient(base *cryptotls.Config) tls.GetConfigForClientFunc {
+	return func(ch *cryptotls.ClientHelloInfo) (*cryptotls.Config, error) {
+		if ch.ServerName == "" {
+			return nil, nil
+		}
+
+		instance, err := a.instance(context.Background(), ch.ServerName)
+		if err != nil {
+			return nil, err
+		}
+
+		domain, _ := instance.domain(context.Background(), ch.ServerName)
+		if domain == nil || domain.TLSPolicy == nil {
+			return nil, nil
+		}
+
+		return tls.OverrideConfig(base, domain.TLSPolicy.MinVersion, domain.TLSPolicy.DisableHTTP2), nil
+	}
+}
+
 func (a *theApp) redirectToHTTPS(w http.ResponseWriter, r *http.Request, statusCode int) {
 	u := *r.URL
 	u.Scheme = request.SchemeHTTPS
-	u.Host = r.Host
+	u.Host = request.GetCanonicalHost(r)
 	u.User = nil
 
 	http.Redirect(w, r, u.String(), statusCode)
 }
 
+// redirectToPrimaryDomain redirects requests to an alias to the same URL on
+// the primary domain, keeping the scheme and port of the request
+func redirectToPrimaryDomain(w http.ResponseWriter, r *http.Request, https bool, primaryDomain string) {
+	u := *r.URL
+	u.Scheme = request.SchemeHTTP
+	if https {
+		u.Scheme = request.SchemeHTTPS
+	}
+
+	u.Host = primaryDomain
+	if _, port, err := net.SplitHostPort(request.GetCanonicalHost(r)); err == nil {
+		u.Host = net.JoinHostPort(primaryDomain, port)
+	}
+	u.User = nil
+
+	http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+}
+
+// redirectToCanonicalDomain redirects requests to a project on the pages
+// domain to the same page on its canonical domain, e.g.
+// group.gitlab.io/project/page.html to www.example.com/page.html
+func redirectToCanonicalDomain(w http.ResponseWriter, r *http.Request, https bool, lookupPath *serving.LookupPath) {
+	u := *r.URL
+	u.Scheme = request.SchemeHTTP
+	if https || lookupPath.IsHTTPSOnly {
+		u.Scheme = request.SchemeHTTPS
+	}
+
+	u.Host = lookupPath.CanonicalDomain
+	u.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(lookupPath.Prefix, "/")), "/")
+	u.RawPath = ""
+	u.User = nil
+
+	http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+}
+
+// artifactsAllowed returns false when r requests the artifacts of a project
+// whose IP access list does not allow the client
+func artifactsAllowed(d *domain.Domain, r *http.Request) bool {
+	projectPath, ok := artifact.ProjectPath(r.URL.Path)
+	if !ok || d == nil {
+		return true
+	}
+
+	projectRequest := r.Clone(r.Context())
+	projectRequest.URL.Path = "/" + projectPath + "/"
+
+	lookupPath, err := d.GetLookupPath(projectRequest)
+	if err != nil {
+		return true
+	}
+
+	return lookupPath.IPAccess.Allows(r)
+}
+
+// isCanonicalDomain returns true if host, without a port, is the canonical
+// domain of the lookup path or the lookup path has none
+func isCanonicalDomain(host string, lookupPath *serving.LookupPath) bool {
+	return lookupPath.CanonicalDomain == "" || strings.EqualFold(host, lookupPath.CanonicalDomain)
+}
+
 func (a *theApp) domain(ctx context.Context, host string) (*domain.Domain, error) {
 	return a.source.GetDomain(ctx, host)
 }
@@ -103,23 +318,50 @@ func (a *theApp) checkAuthAndServeNotFound(domain *domain.Domain, w http.Respons
 	domain.ServeNotFoundAuthFailed(w, r)
 }
 
-func (a *theApp) tryAuxiliaryHandlers(w http.ResponseWriter, r *http.Request, https bool, host string, domain *domain.Domain) bool {
+func (a *theApp) tryAuxiliaryHandlers(w http.ResponseWriter, r *http.Request, https bool, host string, d *domain.Domain) bool {
 	// Add auto redirect
-	if !https && a.config.General.RedirectHTTP {
+	if !https && a.config.General.RedirectHTTP && !a.isRedirectHTTPExcluded(r.URL.Path) {
 		a.redirectToHTTPS(w, r, http.StatusTemporaryRedirect)
 		return true
 	}
 
+	// the artifacts of a project are restricted to the clients allowed to
+	// view its pages
+	if !artifactsAllowed(d, r) {
+		httperrors.Serve403(w)
+		return true
+	}
+
 	if a.Handlers.HandleArtifactRequest(host, w, r) {
 		return true
 	}
 
-	if !a.isReady() {
+	// the requests are served by the listeners, only the source can be not
+	// ready yet
+	if !a.isSourceReady() {
 		httperrors.Serve503(w)
 		return true
 	}
 
-	if _, err := domain.GetLookupPath(r); err != nil {
+	// the domain was deleted from GitLab, so clients like crawlers stop
+	// requesting its pages
+	if d == nil && domain.IsDeleted(r) {
+		httperrors.Serve410(w, a.config.General.GonePage)
+		return true
+	}
+
+	if d != nil && d.Unverified {
+		d.ServeUnverifiedHTTP(w, r)
+		return true
+	}
+
+	if d != nil && d.PrimaryDomain != "" {
+		redirectToPrimaryDomain(w, r, https, d.PrimaryDomain)
+		return true
+	}
+
+	lookupPath, err := d.GetLookupPath(r)
+	if err != nil {
 		if errors.Is(err, gitlab.ErrDiskDisabled) {
 			errortracking.Capture(err, errortracking.WithStackTrace())
 			httperrors.Serve500(w)
@@ -127,11 +369,33 @@ func (a *theApp) tryAuxiliaryHandlers(w http.ResponseWriter, r *http.Request, ht
 		}
 
 		// redirect to auth and serve not found
-		a.checkAuthAndServeNotFound(domain, w, r)
+		a.checkAuthAndServeNotFound(d, w, r)
+		return true
+	}
+
+	// the clients outside of the IP ranges of the project can not view any
+	// of its pages
+	if !lookupPath.IPAccess.Allows(r) {
+		httperrors.Serve403(w)
 		return true
 	}
 
-	if !https && domain.IsHTTPSOnly(r) {
+	// the pages of the project are only served on its custom domain, so
+	// search engines do not index them twice
+	if !isCanonicalDomain(host, lookupPath) {
+		redirectToCanonicalDomain(w, r, https, lookupPath)
+		return true
+	}
+
+	if !https && a.config.General.RedirectUncertifiedDomains {
+		if target := d.PagesDomainURL(r); target != "" {
+			// not permanent, the domain is served once it has a certificate
+			http.Redirect(w, r, target, http.StatusFound)
+			return true
+		}
+	}
+
+	if !https && d.IsHTTPSOnly(r) {
 		a.redirectToHTTPS(w, r, http.StatusMovedPermanently)
 		return true
 	}
@@ -139,6 +403,34 @@ func (a *theApp) tryAuxiliaryHandlers(w http.ResponseWriter, r *http.Request, ht
 	return false
 }
 
+// isRedirectHTTPExcluded returns true for the paths served over HTTP when
+// redirect-http is enabled: ACME challenges, which are validated over HTTP
+// before the domain has a certificate, and the configured path prefixes
+func (a *theApp) isRedirectHTTPExcluded(urlPath string) bool {
+	if acme.IsChallenge(urlPath) {
+		return true
+	}
+
+	urlPath = path.Clean("/" + urlPath)
+	for _, prefix := range a.config.General.RedirectHTTPExclude {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cacheDumper dumps the domains cached by the source and the archives cached
+// by the zip serving
+func (a *theApp) cacheDumper() *cachedump.Dumper {
+	domains, _ := a.source.(cachedump.DomainsDumper)
+	archives, _ := zip.Instance().(cachedump.ArchivesDumper)
+
+	return cachedump.New(domains, archives, a.config.GitLab.APISecretKey)
+}
+
 // healthCheckMiddleware is serving the application status check
 func (a *theApp) healthCheckMiddleware(handler http.Handler) (http.Handler, error) {
 	healthCheck := http.HandlerFunc(func(w http.ResponseWriter, _r *http.Request) {
@@ -176,6 +468,12 @@ func (a *theApp) auxiliaryMiddleware(handler http.Handler) http.Handler {
 			return
 		}
 
+		// the stale responses are served before the IP access of the project
+		// is checked, so the responses of restricted projects are not cached
+		if lookupPath, err := domain.GetLookupPath(r); err == nil && lookupPath.IPAccess != nil {
+			r = microcache.WithoutCache(r)
+		}
+
 		handler.ServeHTTP(w, r)
 	})
 }
@@ -206,20 +504,54 @@ func (a *theApp) serveFileOrNotFoundHandler() http.Handler {
 	})
 }
 
+// allowedRoutes returns the routes handled by Pages itself whose methods are
+// allowed even when they are not in allowed-http-methods
+func (a *theApp) allowedRoutes() []rejectmethods.Route {
+	var routes []rejectmethods.Route
+
+	if a.config.Authentication.QueryToken {
+		routes = append(routes, rejectmethods.Route{Method: http.MethodPost, Path: auth.HandoffPath})
+	}
+
+	if a.config.General.DeploymentHooks {
+		routes = append(routes, rejectmethods.Route{Method: http.MethodPost, Path: hooks.DeploymentPath})
+	}
+
+	return routes
+}
+
 // httpInitialMiddleware sets up HTTP requests
 func (a *theApp) httpInitialMiddleware(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handler.ServeHTTP(w, setRequestScheme(r))
+		r = setRequestScheme(r)
+		r = request.WithListener(r, r.URL.Scheme)
+		r = request.WithClientIP(r, forwarded.ClientIP(r, a.trustedProxies))
+
+		// The host is only used to build URLs, domains are still looked up
+		// by the Host header
+		if a.trustedProxies.Trusts(r) {
+			if forwardedHost := forwarded.Host(r); forwardedHost != "" {
+				r = request.WithCanonicalHost(r, forwardedHost)
+			}
+		}
+
+		handler.ServeHTTP(w, r)
 	})
 }
 
 // proxyInitialMiddleware sets up proxy requests
 func (a *theApp) proxyInitialMiddleware(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if forwardedHost := r.Header.Get(xForwardedHost); forwardedHost != "" {
+		if forwardedHost := forwarded.Host(r); forwardedHost != "" {
 			r.Host = forwardedHost
 		}
 
+		r = request.WithListener(r, request.ListenerProxy)
+		// the client IP is resolved before ProxyHeaders replaces the remote
+		// address with the leftmost X-Forwarded-For address, which can be
+		// spoofed
+		r = request.WithClientIP(r, forwarded.ProxiedClientIP(r, a.trustedProxies))
+
 		handler.ServeHTTP(w, r)
 	})
 }
@@ -237,9 +569,11 @@ func setRequestScheme(r *http.Request) *http.Request {
 }
 
 // TODO: move the pipeline configuration to internal/pipeline https://gitlab.com/gitlab-org/gitlab-pages/-/issues/670
-func (a *theApp) buildHandlerPipeline() (http.Handler, error) {
+func (a *theApp) buildHandlerPipeline(metricsMiddleware labmetrics.HandlerFactory) (http.Handler, error) {
 	// Handlers should be applied in a reverse order
 	handler := a.serveFileOrNotFoundHandler()
+	// after the authorization, the responses of private projects are never cached
+	handler = microcache.NewMiddleware(handler, a.MicroCache)
 	if !a.config.General.DisableCrossOriginRequests {
 		handler = corsHandler.Handler(handler)
 	}
@@ -253,12 +587,23 @@ func (a *theApp) buildHandlerPipeline() (http.Handler, error) {
 	}
 
 	// Metrics
-	metricsMiddleware := labmetrics.NewHandlerFactory(labmetrics.WithNamespace("gitlab_pages"))
 	handler = metricsMiddleware(handler)
+	handler = analytics.NewMiddleware(handler, a.Analytics)
+	handler = tenantmetrics.NewMiddleware(handler, a.TenantMetrics)
+	handler = bandwidth.NewMiddleware(handler, a.Bandwidth)
+	handler = logging.NewBytesServedMiddleware(handler, domain.ServingType)
+	if a.config.General.ProjectHeaders {
+		handler = domain.NewProjectHeadersMiddleware(handler)
+	}
 
 	handler = routing.NewMiddleware(handler, a.source)
+	// the service level objectives include the domain lookups and their errors
+	handler = slo.NewMiddleware(handler, a.sloWindow)
+	// stale responses are served when the domain cannot be looked up too
+	handler = microcache.NewStaleIfErrorMiddleware(handler, a.MicroCache)
+	handler = debugtrace.NewMiddleware(handler, a.config.GitLab.APISecretKey)
 
-	handler = handlers.Ratelimiter(handler, &a.config.RateLimit)
+	handler = handlers.Ratelimiter(handler, a.instanceName, &a.config.RateLimit)
 
 	// Health Check
 	handler, err = a.healthCheckMiddleware(handler)
@@ -266,9 +611,22 @@ func (a *theApp) buildHandlerPipeline() (http.Handler, error) {
 		return nil, err
 	}
 
+	// Custom domains diagnostics API
+	handler = diagnostics.NewMiddleware(handler, a.config.General.DiagnosticsPath,
+		diagnostics.New(a.source, a.config.General.Domain, a.config.GitLab.APISecretKey))
+
+	// Domains and archives caches dump
+	handler = cachedump.NewMiddleware(handler, a.config.General.CacheDumpPath, a.cacheDumper())
+
+	// Deployment webhooks
+	handler = hooks.NewMiddleware(handler, a.Hooks)
+
 	// Custom response headers
 	handler = customheaders.NewMiddleware(handler, a.CustomHeaders)
 
+	// Operator rules blocking, allowing or rate limiting requests
+	handler = requestfilter.NewMiddleware(handler, a.RequestFilter)
+
 	// Correlation ID injection middleware
 	var correlationOpts []correlation.InboundHandlerOption
 	if a.config.General.PropagateCorrelationID {
@@ -280,8 +638,15 @@ func (a *theApp) buildHandlerPipeline() (http.Handler, error) {
 	// These middlewares MUST be added in the end.
 	// Being last means they will be evaluated first
 	// preventing any operation on bogus requests.
+	handler = urlpath.NewMiddleware(handler)
 	handler = urilimiter.NewMiddleware(handler, a.config.General.MaxURILength)
-	handler = rejectmethods.NewMiddleware(handler)
+	handler = rejectmethods.NewMiddleware(handler, a.config.General.AllowedHTTPMethods, a.allowedRoutes()...)
+
+	// Server header of every response, including the rejected requests
+	handler = customheaders.NewServerMiddleware(handler, a.config.General.ServerHeader)
+	if a.config.General.ForceNoIndex {
+		handler = customheaders.NewNoIndexMiddleware(handler)
+	}
 
 	return handler, nil
 }
@@ -299,13 +664,26 @@ func (a *theApp) Run() {
 		)
 	}
 
+	// the metrics are registered once and shared by the pipelines of the
+	// virtual instances
+	metricsMiddleware := labmetrics.NewHandlerFactory(labmetrics.WithNamespace("gitlab_pages"))
+
 	// Use a common pipeline to use a single instance of each handler,
 	// instead of making two nearly identical pipelines
-	commonHandlerPipeline, err := a.buildHandlerPipeline()
+	commonHandlerPipeline, err := a.buildHandlerPipeline(metricsMiddleware)
 	if err != nil {
 		log.WithError(err).Fatal("Unable to configure pipeline")
 	}
 
+	instanceHandlers := make(map[*theApp]http.Handler, len(a.instances))
+	for _, instance := range a.instances {
+		instanceHandlers[instance], err = instance.buildHandlerPipeline(metricsMiddleware)
+		if err != nil {
+			log.WithError(err).WithField("pages_domain", instance.config.General.Domain).Fatal("Unable to configure pipeline")
+		}
+	}
+	commonHandlerPipeline = a.instancesMiddleware(commonHandlerPipeline, instanceHandlers)
+
 	proxyHandler := a.proxyInitialMiddleware(ghandlers.ProxyHeaders(commonHandlerPipeline))
 
 	httpHandler := a.httpInitialMiddleware(commonHandlerPipeline)
@@ -330,19 +708,39 @@ func (a *theApp) Run() {
 		a.ListenHTTPSProxyv2FD(&wg, fd, httpHandler, limiter)
 	}
 
+	// Listen for both HTTP and HTTPS requests on the same port
+	for _, fd := range a.config.Listeners.HTTPAndHTTPS {
+		a.listenHTTPAndHTTPSFD(&wg, fd, httpHandler, limiter)
+	}
+
 	// Serve metrics for Prometheus
 	if a.config.ListenMetrics != 0 {
 		a.listenMetricsFD(&wg, a.config.ListenMetrics)
 	}
 
+	// the listeners were opened before, so requests are accepted from now on
+	a.serversMu.Lock()
+	a.listening = true
+	a.serversMu.Unlock()
+
+	if err := service.Ready(); err != nil {
+		log.WithError(err).Error("failed to notify the service manager that Pages is ready")
+	}
+
+	go service.Watchdog(context.Background(), a.isReady)
+
 	wg.Wait()
+
+	if a.stopped != nil {
+		<-a.stopped
+	}
 }
 
 func (a *theApp) listenHTTPFD(wg *sync.WaitGroup, fd uintptr, httpHandler http.Handler, limiter *netutil.Limiter) {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		if err := a.listenAndServe(listenerConfig{fd: fd, handler: httpHandler, limiter: limiter}); err != nil {
+		if err := a.listenAndServe(listenerConfig{name: "http", fd: fd, handler: httpHandler, limiter: limiter}); err != nil {
 			capturingFatal(err, errortracking.WithField("listener", request.SchemeHTTP))
 		}
 	}()
@@ -357,7 +755,7 @@ func (a *theApp) listenHTTPSFD(wg *sync.WaitGroup, fd uintptr, httpHandler http.
 			capturingFatal(err, errortracking.WithField("listener", request.SchemeHTTPS))
 		}
 
-		if err := a.listenAndServe(listenerConfig{fd: fd, handler: httpHandler, limiter: limiter, tlsConfig: tlsConfig}); err != nil {
+		if err := a.listenAndServe(listenerConfig{name: "https", fd: fd, handler: httpHandler, limiter: limiter, tlsConfig: tlsConfig}); err != nil {
 			capturingFatal(err, errortracking.WithField("listener", request.SchemeHTTPS))
 		}
 	}()
@@ -369,7 +767,7 @@ func (a *theApp) listenProxyFD(wg *sync.WaitGroup, fd uintptr, proxyHandler http
 		wg.Add(1)
 		go func(fd uintptr) {
 			defer wg.Done()
-			if err := a.listenAndServe(listenerConfig{fd: fd, handler: proxyHandler, limiter: limiter}); err != nil {
+			if err := a.listenAndServe(listenerConfig{name: "proxy", fd: fd, handler: proxyHandler, limiter: limiter}); err != nil {
 				capturingFatal(err, errortracking.WithField("listener", "http proxy"))
 			}
 		}(fd)
@@ -386,12 +784,27 @@ func (a *theApp) ListenHTTPSProxyv2FD(wg *sync.WaitGroup, fd uintptr, httpHandle
 			capturingFatal(err, errortracking.WithField("listener", request.SchemeHTTPS))
 		}
 
-		if err := a.listenAndServe(listenerConfig{fd: fd, handler: httpHandler, limiter: limiter, tlsConfig: tlsConfig, isProxyV2: true}); err != nil {
+		if err := a.listenAndServe(listenerConfig{name: "https-proxyv2", fd: fd, handler: httpHandler, limiter: limiter, tlsConfig: tlsConfig, isProxyV2: true}); err != nil {
 			capturingFatal(err, errortracking.WithField("listener", request.SchemeHTTPS))
 		}
 	}()
 }
 
+func (a *theApp) listenHTTPAndHTTPSFD(wg *sync.WaitGroup, fd uintptr, httpHandler http.Handler, limiter *netutil.Limiter) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tlsConfig, err := a.TLSConfig()
+		if err != nil {
+			capturingFatal(err, errortracking.WithField("listener", "http and https"))
+		}
+
+		if err := a.listenAndServe(listenerConfig{name: "http-https", fd: fd, handler: httpHandler, limiter: limiter, tlsConfig: tlsConfig, sniffTLS: true}); err != nil {
+			capturingFatal(err, errortracking.WithField("listener", "http and https"))
+		}
+	}()
+}
+
 func (a *theApp) listenMetricsFD(wg *sync.WaitGroup, fd uintptr) {
 	wg.Add(1)
 	go func() {
@@ -402,20 +815,92 @@ func (a *theApp) listenMetricsFD(wg *sync.WaitGroup, fd uintptr) {
 			capturingFatal(fmt.Errorf("failed to listen on FD %d: %v", fd, err), errortracking.WithField("listener", "metrics"))
 		}
 
-		monitoringOpts := []monitoring.Option{
-			monitoring.WithBuildInformation(VERSION, ""),
-			monitoring.WithListener(l),
+		// without a listener, monitoring only starts the continuous profiler
+		// and metrics are served below to protect them
+		if err := monitoring.Start(monitoring.WithBuildInformation(VERSION, "")); err != nil {
+			capturingFatal(err, errortracking.WithField("listener", "metrics"))
 		}
 
-		err = monitoring.Start(monitoringOpts...)
+		metrics.BuildInfo.WithLabelValues(VERSION, "").Set(1)
+		metrics.PagesBuildInfo.WithLabelValues(VERSION, REVISION).Set(1)
+
+		handler, err := metricsauth.NewMiddleware(metricsHandler(a.config.Listeners.Addresses), &a.config.Metrics)
 		if err != nil {
 			capturingFatal(err, errortracking.WithField("listener", "metrics"))
 		}
+
+		server := &http.Server{Handler: handler}
+		if !a.trackServer(server) {
+			return
+		}
+
+		if err := server.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+			capturingFatal(err, errortracking.WithField("listener", "metrics"))
+		}
 	}()
 }
 
+// metricsHandler serves the Prometheus metrics, the pprof profiles and the
+// addresses of the listeners
+func metricsHandler(listeners []cfg.ListenerAddress) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/listeners", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(listeners); err != nil {
+			log.WithError(err).Error("failed to write listeners response")
+		}
+	})
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return mux
+}
+
+// newSource creates the domains source, the GitLab API unless domains are
+// derived from their hostname. In auto mode, the GitLab API is used while it
+// is healthy and domains are derived from their hostname otherwise. The
+// background work of the source stops when ctx is done.
+func newSource(ctx context.Context, config *cfg.Config) (source.Source, error) {
+	if config.HostnameSource.Template == "" {
+		return gitlab.New(ctx, &config.GitLab)
+	}
+
+	hostnameSource, err := hostname.New(&config.HostnameSource)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.HostnameSource.Mode != cfg.DomainSourceAuto {
+		return hostnameSource, nil
+	}
+
+	gitlabSource, err := gitlab.New(ctx, &config.GitLab)
+	if err != nil {
+		return nil, err
+	}
+
+	checker, err := client.NewFromConfig(ctx, &config.GitLab)
+	if err != nil {
+		return nil, err
+	}
+
+	autoSource := auto.New(gitlabSource, hostnameSource, checker)
+	go autoSource.Run(ctx, auto.DefaultCheckInterval)
+
+	return autoSource, nil
+}
+
 func runApp(config *cfg.Config) {
-	source, err := gitlab.New(&config.GitLab)
+	// ctx stops the background work of the domains sources and the GitLab API
+	// clients once Pages stopped
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source, err := newSource(ctx, config)
 	if err != nil {
 		log.WithError(err).Fatal("could not create domains config source")
 	}
@@ -427,14 +912,65 @@ func runApp(config *cfg.Config) {
 		log.WithError(err).Fatal("Failed to initialize logging")
 	}
 
-	if config.ArtifactsServer.URL != "" {
-		a.Artifact = artifact.New(config.ArtifactsServer.URL, config.ArtifactsServer.TimeoutSeconds, config.General.Domain)
+	if len(config.ArtifactsServer.URLs) > 0 {
+		egressPolicy, err := egress.NewPolicy(config.General.EgressAllowlist)
+		if err != nil {
+			log.WithError(err).Fatal("could not create egress policy")
+		}
+
+		servers, err := config.ArtifactsServer.Backends()
+		if err != nil {
+			log.WithError(err).Fatal("could not parse artifacts servers")
+		}
+
+		a.Artifact = artifact.New(servers, config.ArtifactsServer.TimeoutSeconds, config.General.Domain, egressPolicy)
+	}
+
+	a.trustedProxies, err = forwarded.NewProxies(config.General.TrustedProxies)
+	if err != nil {
+		log.WithError(err).Fatal("could not parse trusted proxies")
+	}
+
+	rollouts, err := config.General.Rollouts()
+	if err != nil {
+		log.WithError(err).Fatal("could not parse feature rollouts")
+	}
+
+	if err := feature.SetRollouts(rollouts); err != nil {
+		log.WithError(err).Fatal("could not roll out features")
 	}
 
+	a.sloWindow = slo.NewWindow()
+	prometheus.MustRegister(a.sloWindow)
+
 	a.setAuth(config)
 
 	a.Handlers = handlers.New(a.Auth, a.Artifact)
 
+	// only the sources caching domains, like GitLab, can refresh them
+	if refresher, ok := source.(hooks.Refresher); ok && config.General.DeploymentHooks {
+		a.Hooks = hooks.New(refresher, config.GitLab.APISecretKey, hooks.DefaultTimeout)
+	}
+
+	if config.Analytics.ReportInterval > 0 {
+		reporter, err := client.NewFromConfig(ctx, &config.GitLab)
+		if err != nil {
+			log.WithError(err).Fatal("could not create the GitLab API client reporting access summaries")
+		}
+
+		a.Analytics = analytics.New(reporter, config.Analytics.TopPaths, config.Analytics.MaxDomains)
+		go a.Analytics.Run(ctx, config.Analytics.ReportInterval)
+	}
+
+	if config.Metrics.TenantLabelsEnabled() {
+		a.TenantMetrics = tenantmetrics.New(config.General.Domain, config.Metrics.TenantLabels,
+			config.Metrics.TenantLabelsAllow, config.Metrics.TenantLabelsDeny)
+		go a.TenantMetrics.Run(context.Background(), tenantmetrics.DefaultRotateInterval)
+	}
+
+	a.MicroCache = microcache.New(config.MicroCache.TTL, config.MicroCache.StaleIfError, config.MicroCache.MaxSize, config.MicroCache.MaxEntries)
+	a.Bandwidth = bandwidth.New(config.Bandwidth.ConnectionLimit, config.Bandwidth.DomainLimit)
+
 	// TODO: This if was introduced when `gitlab-server` wasn't a required parameter
 	// once we completely remove support for legacy architecture and make it required
 	// we can just remove this if statement https://gitlab.com/gitlab-org/gitlab-pages/-/issues/581
@@ -443,13 +979,38 @@ func runApp(config *cfg.Config) {
 	}
 
 	if len(config.General.CustomHeaders) != 0 {
-		customHeaders, err := customheaders.ParseHeaderString(config.General.CustomHeaders)
+		customHeaders, err := customheaders.New(config.General.CustomHeaders, config.General.Domain)
 		if err != nil {
 			log.WithError(err).Fatal("Unable to parse header string")
 		}
 		a.CustomHeaders = customHeaders
 	}
 
+	if config.RequestFilter.RulesFile != "" {
+		requestFilter, err := requestfilter.New(config.RequestFilter.RulesFile)
+		if err != nil {
+			log.WithError(err).Fatal("Unable to load the request filter rules")
+		}
+		a.RequestFilter = requestFilter
+
+		if config.RequestFilter.ReloadInterval > 0 {
+			go requestFilter.Run(context.Background(), config.RequestFilter.ReloadInterval)
+		}
+	}
+
+	for _, instanceConfig := range config.Instances {
+		instance, err := a.newInstance(ctx, instanceConfig)
+		if err != nil {
+			log.WithError(err).WithField("pages_domain", instanceConfig.General.Domain).Fatal("could not create virtual instance")
+		}
+
+		a.instances = append(a.instances, instance)
+	}
+
+	if len(a.instances) > 0 {
+		a.instanceMatches = newInstanceMatches()
+	}
+
 	if err := mimedb.LoadTypes(); err != nil {
 		log.WithError(err).Warn("Loading extended MIME database failed")
 	}
@@ -460,6 +1021,16 @@ func runApp(config *cfg.Config) {
 		fatal(err, "failed to reconfigure zip VFS")
 	}
 
+	if err := local.Instance().Reconfigure(config); err != nil {
+		fatal(err, "failed to reconfigure local VFS")
+	}
+
+	if path := config.General.CacheHandoffFile; path != "" {
+		a.importHandoff(path)
+	}
+
+	a.stopGracefully(config.General.CacheHandoffFile)
+
 	a.Run()
 }
 
@@ -468,9 +1039,17 @@ func (a *theApp) setAuth(config *cfg.Config) {
 		return
 	}
 
-	var err error
+	proxy, err := httptransport.NewProxyFunc(config.Authentication.ProxyURL)
+	if err != nil {
+		log.WithError(err).Fatal("could not initialize auth proxy")
+	}
+
 	a.Auth, err = auth.New(config.General.Domain, config.Authentication.Secret, config.Authentication.ClientID, config.Authentication.ClientSecret,
-		config.Authentication.RedirectURI, config.GitLab.InternalServer, config.GitLab.PublicServer, config.Authentication.Scope)
+		config.Authentication.RedirectURI, config.GitLab.InternalServer, config.GitLab.PublicServer, config.Authentication.Scope,
+		auth.WithCookieName(config.Authentication.CookieName), auth.WithCookieScope(config.Authentication.CookieScope),
+		auth.WithTokenTimeout(config.Authentication.TokenTimeout), auth.WithAccessCheckTimeout(config.Authentication.AccessCheckTimeout),
+		auth.WithAPIRetries(config.Authentication.APIRetries), auth.WithCodeExpiry(config.Authentication.CodeExpiry),
+		auth.WithQueryToken(config.Authentication.QueryToken), auth.WithProxy(proxy))
 	if err != nil {
 		log.WithError(err).Fatal("could not initialize auth package")
 	}
@@ -482,8 +1061,19 @@ func fatal(err error, message string) {
 }
 
 func (a *theApp) TLSConfig() (*cryptotls.Config, error) {
-	return tls.Create(a.config.General.RootCertificate, a.config.General.RootKey, a.ServeTLS,
+	tlsConfig, err := tls.Create(a.config.General.RootCertificate, a.config.General.RootKey, a.ServeTLS,
 		a.config.General.InsecureCiphers, a.config.TLS.MinVersion, a.config.TLS.MaxVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tls.ConfigureECH(tlsConfig, a.config.TLS.ECHKeys); err != nil {
+		return nil, err
+	}
+
+	tlsConfig.GetConfigForClient = a.getConfigForClient(tlsConfig)
+
+	return tlsConfig, nil
 }
 
 // handlePanicMiddleware logs and captures the recover() information from any panic
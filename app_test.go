@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,10 +13,15 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
+	labmetrics "gitlab.com/gitlab-org/labkit/metrics"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/forwarded"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/request"
-	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/slo"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/testhelpers"
 	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
@@ -53,6 +60,46 @@ func Test_setRequestScheme(t *testing.T) {
 	}
 }
 
+func TestHTTPInitialMiddlewareCanonicalHost(t *testing.T) {
+	proxies, err := forwarded.NewProxies([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	a := &theApp{trustedProxies: proxies}
+
+	tests := map[string]struct {
+		remoteAddr   string
+		expectedHost string
+	}{
+		"trusted_proxy": {
+			remoteAddr:   "10.0.0.1:1234",
+			expectedHost: "group.example.com",
+		},
+		"untrusted_client": {
+			remoteAddr:   "192.0.2.1:1234",
+			expectedHost: "pages.internal:8090",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			handler := a.httpInitialMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "pages.internal:8090", r.Host)
+				a.redirectToHTTPS(w, r, http.StatusMovedPermanently)
+			}))
+
+			r := httptest.NewRequest("GET", "http://pages.internal:8090/project/?q=1", nil)
+			r.RemoteAddr = tt.remoteAddr
+			r.Header.Set("X-Forwarded-Host", "Group.Example.com")
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			require.Equal(t, http.StatusMovedPermanently, w.Code)
+			require.Equal(t, "https://"+tt.expectedHost+"/project/?q=1", w.Header().Get("Location"))
+		})
+	}
+}
+
 func newGetRequestWithScheme(t *testing.T, scheme string, withTLS bool) *http.Request {
 	t.Helper()
 
@@ -66,48 +113,109 @@ func newGetRequestWithScheme(t *testing.T, scheme string, withTLS bool) *http.Re
 	return req
 }
 
+func TestRedirectToCanonicalDomain(t *testing.T) {
+	tests := map[string]struct {
+		url              string
+		https            bool
+		prefix           string
+		httpsOnly        bool
+		expectedLocation string
+	}{
+		"project": {
+			url:              "http://group.gitlab.io/project/path/index.html?q=1",
+			prefix:           "/project/",
+			expectedLocation: "http://www.example.com/path/index.html?q=1",
+		},
+		"project_root": {
+			url:              "https://group.gitlab.io/project",
+			https:            true,
+			prefix:           "/project/",
+			expectedLocation: "https://www.example.com/",
+		},
+		"namespace_project": {
+			url:              "http://group.gitlab.io/path/",
+			prefix:           "/",
+			expectedLocation: "http://www.example.com/path/",
+		},
+		"https_only": {
+			url:              "http://group.gitlab.io/project/",
+			prefix:           "/project/",
+			httpsOnly:        true,
+			expectedLocation: "https://www.example.com/",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+
+			redirectToCanonicalDomain(w, r, tt.https, &serving.LookupPath{
+				Prefix:          tt.prefix,
+				IsHTTPSOnly:     tt.httpsOnly,
+				CanonicalDomain: "www.example.com",
+			})
+
+			require.Equal(t, http.StatusMovedPermanently, w.Code)
+			require.Equal(t, tt.expectedLocation, w.Header().Get("Location"))
+		})
+	}
+}
+
 func TestHealthCheckMiddleware(t *testing.T) {
 	tests := []struct {
-		name   string
-		path   string
-		status int
-		body   string
+		name        string
+		path        string
+		listening   bool
+		stopping    bool
+		sourceReady bool
+		status      int
+		body        string
 	}{
 		{
-			name:   "Not a healthcheck request",
-			path:   "/foo/bar",
-			status: http.StatusOK,
-			body:   "Hello from inner handler",
+			name:        "Not a healthcheck request",
+			path:        "/foo/bar",
+			listening:   true,
+			sourceReady: true,
+			status:      http.StatusOK,
+			body:        "Hello from inner handler",
 		},
 		{
-			name:   "Healthcheck request",
-			path:   "/-/healthcheck",
-			status: http.StatusOK,
-			body:   "success\n",
+			name:        "Healthcheck request",
+			path:        "/-/healthcheck",
+			listening:   true,
+			sourceReady: true,
+			status:      http.StatusOK,
+			body:        "success\n",
+		},
+		{
+			name:        "Healthcheck request before the listeners accept connections",
+			path:        "/-/healthcheck",
+			sourceReady: true,
+			status:      http.StatusServiceUnavailable,
+		},
+		{
+			name:        "Healthcheck request while stopping",
+			path:        "/-/healthcheck",
+			listening:   true,
+			stopping:    true,
+			sourceReady: true,
+			status:      http.StatusServiceUnavailable,
+		},
+		{
+			name:      "Healthcheck request before the source is ready",
+			path:      "/-/healthcheck",
+			listening: true,
+			status:    http.StatusServiceUnavailable,
 		},
 	}
 
-	validCfg := config.GitLab{
-		InternalServer:     "server",
-		APISecretKey:       []byte("secret"),
-		ClientHTTPTimeout:  time.Second,
-		JWTTokenExpiration: time.Second,
-	}
-
-	source, err := gitlab.New(&validCfg)
-	require.NoError(t, err)
-
 	cfg := config.Config{
 		General: config.General{
 			StatusPath: "/-/healthcheck",
 		},
 	}
 
-	app := theApp{
-		config: &cfg,
-		source: source,
-	}
-
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		io.WriteString(w, "Hello from inner handler")
@@ -115,6 +223,13 @@ func TestHealthCheckMiddleware(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
+			app := theApp{
+				config:    &cfg,
+				source:    readinessSource(tc.sourceReady),
+				listening: tc.listening,
+				stopping:  tc.stopping,
+			}
+
 			r := httptest.NewRequest("GET", tc.path, nil)
 			rr := httptest.NewRecorder()
 
@@ -123,11 +238,37 @@ func TestHealthCheckMiddleware(t *testing.T) {
 			middleware.ServeHTTP(rr, r)
 
 			require.Equal(t, tc.status, rr.Code)
-			require.Equal(t, tc.body, rr.Body.String())
+			if tc.body != "" {
+				require.Equal(t, tc.body, rr.Body.String())
+			}
 		})
 	}
 }
 
+func TestIsReadyOfVirtualInstance(t *testing.T) {
+	main := &theApp{source: readinessSource(true)}
+	instance := &theApp{source: readinessSource(true), main: main}
+
+	require.False(t, instance.isReady(), "the listeners of the main instance do not accept connections yet")
+
+	main.listening = true
+	require.True(t, instance.isReady())
+
+	instance.source = readinessSource(false)
+	require.False(t, instance.isReady())
+	require.True(t, main.isReady(), "the readiness of the sources of the instances is independent")
+}
+
+type readinessSource bool
+
+func (readinessSource) GetDomain(context.Context, string) (*domain.Domain, error) {
+	return nil, nil
+}
+
+func (r readinessSource) IsReady() bool {
+	return bool(r)
+}
+
 func TestHandlePanicMiddleware(t *testing.T) {
 	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		panic("on purpose")
@@ -147,3 +288,107 @@ func TestHandlePanicMiddleware(t *testing.T) {
 	counterCount := testutil.ToFloat64(metrics.PanicRecoveredCount)
 	require.Equal(t, float64(1), counterCount, "metric not updated")
 }
+
+func TestDomainCertificate(t *testing.T) {
+	now := time.Now()
+
+	validCert, validKey := testhelpers.GenerateCertificate(t, "valid.com", now.Add(-time.Hour), now.Add(time.Hour), false)
+	selfSignedCert, selfSignedKey := testhelpers.GenerateCertificate(t, "self-signed.com", now.Add(-time.Hour), now.Add(time.Hour), true)
+	expiredCert, expiredKey := testhelpers.GenerateCertificate(t, "expired.com", now.Add(-2*time.Hour), now.Add(-time.Hour), false)
+
+	tests := map[string]struct {
+		domain         *domain.Domain
+		policy         string
+		reason         string
+		expectedCert   bool
+		expectedErr    bool
+		expectedMetric float64
+	}{
+		"no_certificate": {
+			domain: &domain.Domain{Name: "no-cert.com"},
+			policy: config.TLSInvalidCertificateReject,
+		},
+		"valid": {
+			domain:       &domain.Domain{Name: "valid.com", CertificateCert: validCert, CertificateKey: validKey},
+			policy:       config.TLSInvalidCertificateReject,
+			expectedCert: true,
+		},
+		"self_signed_serve": {
+			domain:         &domain.Domain{Name: "self-signed.com", CertificateCert: selfSignedCert, CertificateKey: selfSignedKey},
+			policy:         config.TLSInvalidCertificateServe,
+			reason:         "self_signed",
+			expectedCert:   true,
+			expectedMetric: 1,
+		},
+		"self_signed_wildcard": {
+			domain:         &domain.Domain{Name: "self-signed.com", CertificateCert: selfSignedCert, CertificateKey: selfSignedKey},
+			policy:         config.TLSInvalidCertificateWildcard,
+			reason:         "self_signed",
+			expectedMetric: 1,
+		},
+		"expired_reject": {
+			domain:         &domain.Domain{Name: "expired.com", CertificateCert: expiredCert, CertificateKey: expiredKey},
+			policy:         config.TLSInvalidCertificateReject,
+			reason:         "expired",
+			expectedErr:    true,
+			expectedMetric: 1,
+		},
+		"invalid_serve": {
+			domain:         &domain.Domain{Name: "invalid.com", CertificateCert: validCert, CertificateKey: expiredKey},
+			policy:         config.TLSInvalidCertificateServe,
+			reason:         "invalid",
+			expectedMetric: 1,
+		},
+		"invalid_reject": {
+			domain:         &domain.Domain{Name: "invalid.com", CertificateCert: "invalid", CertificateKey: "invalid"},
+			policy:         config.TLSInvalidCertificateReject,
+			reason:         "invalid",
+			expectedErr:    true,
+			expectedMetric: 1,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			metrics.TLSInvalidCertificates.Reset()
+
+			cert, err := domainCertificate(tt.domain, tt.policy, now)
+			if tt.expectedErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			require.Equal(t, tt.expectedCert, cert != nil)
+
+			if tt.reason != "" {
+				require.Equal(t, tt.expectedMetric, testutil.ToFloat64(metrics.TLSInvalidCertificates.WithLabelValues(tt.reason, tt.policy)))
+			}
+		})
+	}
+}
+
+// unavailableSource fails to look up any domain
+type unavailableSource struct{}
+
+func (unavailableSource) GetDomain(context.Context, string) (*domain.Domain, error) {
+	return nil, errors.New("source unavailable")
+}
+
+func TestSLOIncludesDomainLookupErrors(t *testing.T) {
+	app := &theApp{
+		config:    &config.Config{General: config.General{AllowedHTTPMethods: []string{http.MethodGet}}},
+		source:    unavailableSource{},
+		sloWindow: slo.NewWindow(),
+	}
+
+	handler, err := app.buildHandlerPipeline(labmetrics.NewHandlerFactory(labmetrics.WithNamespace("test_slo")))
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://group.gitlab.io/", nil))
+	require.Equal(t, http.StatusBadGateway, w.Code)
+
+	availability, _ := app.sloWindow.Ratios()
+	require.Zero(t, availability, "the failed lookup is measured")
+}
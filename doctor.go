@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/doctor"
+)
+
+const doctorCommand = "doctor"
+
+const doctorUsage = `Usage: gitlab-pages doctor [options]
+
+Checks that a running GitLab Pages daemon is ready to serve requests.
+The exit status is 1 when any of the checks fails.
+
+Options:
+`
+
+// runDoctor runs the doctor subcommand and returns the exit status
+func runDoctor(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet(doctorCommand, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, doctorUsage)
+		flags.PrintDefaults()
+	}
+
+	baseURL := flags.String("url", "http://127.0.0.1", "URL of a Pages listener")
+	statusPath := flags.String("status-path", "", "The url path of the status page, e.g., /@status")
+	domain := flags.String("domain", "", "A known domain used for the synthetic request")
+	path := flags.String("path", "/", "The url path requested on the domain")
+	expectedStatus := flags.Int("expected-status", http.StatusOK, "The status code expected for the synthetic request")
+	metricsURL := flags.String("metrics-url", "", "URL of the Prometheus metrics endpoint, e.g., http://127.0.0.1:9235/metrics")
+	diagnosticsPath := flags.String("diagnostics-path", "", "The url path of the domain diagnostics API")
+	apiSecretFile := flags.String("api-secret-key", "", "File with secret key used to authenticate with the diagnostics API")
+	insecure := flags.Bool("insecure-skip-verify", false, "Do not verify the TLS certificate of the listener")
+	timeout := flags.Duration("timeout", 5*time.Second, "Timeout of each check")
+	jsonOutput := flags.Bool("json", false, "Print the report as JSON")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	opts := []doctor.Option{
+		doctor.WithTimeout(*timeout),
+		doctor.WithStatusPath(*statusPath),
+		doctor.WithSyntheticRequest(*domain, *path, *expectedStatus),
+		doctor.WithMetricsURL(*metricsURL),
+	}
+
+	if *insecure {
+		opts = append(opts, doctor.WithHTTPClient(&http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		}))
+	}
+
+	if *diagnosticsPath != "" {
+		secret, err := config.ReadGitLabAPISecretKey(*apiSecretFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "failed to read API secret: %v\n", err)
+			return 2
+		}
+
+		opts = append(opts, doctor.WithDiagnostics(*diagnosticsPath, secret))
+	}
+
+	d, err := doctor.New(*baseURL, opts...)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid URL: %v\n", err)
+		return 2
+	}
+
+	report := d.Run(context.Background())
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			fmt.Fprintf(stderr, "failed to encode report: %v\n", err)
+			return 1
+		}
+	} else {
+		printDoctorReport(stdout, report)
+	}
+
+	if !report.Ready {
+		return 1
+	}
+
+	return 0
+}
+
+func printDoctorReport(w io.Writer, report *doctor.Report) {
+	fmt.Fprintf(w, "Pages instance: %s\n", report.URL)
+
+	for _, result := range report.Results {
+		fmt.Fprintf(w, "%-18s %-8s", result.Name, result.Status)
+		if result.Status != doctor.StatusSkipped {
+			fmt.Fprintf(w, " %8.2fms", result.LatencyMs)
+		}
+		if result.Message != "" {
+			fmt.Fprintf(w, "  %s", result.Message)
+		}
+		fmt.Fprintln(w)
+	}
+
+	if report.Ready {
+		fmt.Fprintln(w, "Ready")
+	} else {
+		fmt.Fprintln(w, "Not ready")
+	}
+}
@@ -2,8 +2,9 @@ module gitlab.com/gitlab-org/gitlab-pages
 
 // before bumping this:
 // - update the minimum version used in ci
-// - make sure the internal/vfs/serving package is synced
-//   with upstream
+// - make sure internal/vfs/serving/serving.go is synced
+//   with upstream, the additions of Pages are kept in the
+//   other files of the package and its callers
 go 1.16
 
 require (
@@ -19,6 +20,7 @@ require (
 	github.com/patrickmn/go-cache v2.1.0+incompatible
 	github.com/pires/go-proxyproto v0.2.0
 	github.com/prometheus/client_golang v1.11.0
+	github.com/prometheus/client_model v0.2.0
 	github.com/rs/cors v1.7.0
 	github.com/sirupsen/logrus v1.8.1
 	github.com/stretchr/testify v1.7.0
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/cachedump"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/handoff"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving/disk/zip"
+)
+
+// all returns a and its virtual instances
+func (a *theApp) all() []*theApp {
+	return append([]*theApp{a}, a.instances...)
+}
+
+// handoffState returns the caches of a and its virtual instances to hand them
+// off to the next process
+func (a *theApp) handoffState() *handoff.State {
+	state := &handoff.State{Archives: openedArchives()}
+
+	for _, instance := range a.all() {
+		caches := handoff.Instance{PagesDomain: instance.config.General.Domain}
+
+		if c, ok := instance.source.(handoff.DomainsCache); ok {
+			caches.Domains = c.ExportDomains()
+		}
+
+		if instance.Auth != nil {
+			caches.Nonces = instance.Auth.ExportNonces()
+		}
+
+		state.Instances = append(state.Instances, caches)
+	}
+
+	return state
+}
+
+// openedArchives returns the cache keys of the zip archives opened
+func openedArchives() []string {
+	archives, ok := zip.Instance().(cachedump.ArchivesDumper)
+	if !ok {
+		return nil
+	}
+
+	var keys []string
+	for _, archive := range archives.DumpArchives() {
+		if archive.Status == "opened" {
+			keys = append(keys, archive.Key)
+		}
+	}
+
+	return keys
+}
+
+// importHandoff loads the caches handed off by the previous process through
+// the file at path, the instances not served by the previous process start
+// with empty caches. The archives opened by the previous process are opened
+// again in the background.
+func (a *theApp) importHandoff(path string) {
+	state, err := handoff.Read(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+
+	if err != nil {
+		log.WithError(err).Warn("could not load the handed off caches")
+		return
+	}
+
+	for _, instance := range a.all() {
+		caches := state.Instance(instance.config.General.Domain)
+		if caches == nil {
+			continue
+		}
+
+		imported := 0
+		if c, ok := instance.source.(handoff.DomainsCache); ok {
+			imported = c.ImportDomains(caches.Domains)
+		}
+
+		if instance.Auth != nil {
+			instance.Auth.ImportNonces(caches.Nonces)
+		}
+
+		if p, ok := instance.source.(handoff.ArchivesPreloader); ok && len(state.Archives) > 0 {
+			go p.PreloadArchives(context.Background(), caches.Domains, state.Archives)
+		}
+
+		log.WithFields(log.Fields{
+			"pages_domain": instance.config.General.Domain,
+			"domains":      imported,
+			"handed_off":   state.Created,
+		}).Info("loaded the handed off caches")
+	}
+}
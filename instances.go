@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	cryptotls "crypto/tls"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/acme"
+	cfg "gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/handlers"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/hooks"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/httperrors"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/lru"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/microcache"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/pageserrors"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/request"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+const (
+	// instanceMatchTTL is the time the instance serving a host is cached,
+	// a new custom domain of a virtual instance is served by it after it
+	instanceMatchTTL = time.Minute
+	// instanceMatchCacheSize is the maximum number of hosts whose instance
+	// is cached
+	instanceMatchCacheSize = 10000
+)
+
+// newInstanceMatches returns the cache of the instances serving the hosts
+func newInstanceMatches() *lru.Cache {
+	return lru.New("instances", lru.WithMaxSize(instanceMatchCacheSize), lru.WithExpirationInterval(instanceMatchTTL))
+}
+
+// newInstance returns a virtual instance of a serving the pages domain of
+// config, with its own domains source, authentication, rate limits and
+// micro-cache. The operator settings, like custom headers, request filter
+// rules and bandwidth limits, are shared with a, while the artifacts server
+// and access summaries are only available on a. The background work of its
+// domains source stops when ctx is done.
+func (a *theApp) newInstance(ctx context.Context, config *cfg.Config) (*theApp, error) {
+	source, err := newSource(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	instance := &theApp{
+		config:         config,
+		instanceName:   config.General.Domain,
+		main:           a,
+		source:         source,
+		CustomHeaders:  a.CustomHeaders,
+		TenantMetrics:  a.TenantMetrics,
+		RequestFilter:  a.RequestFilter,
+		Bandwidth:      a.Bandwidth,
+		trustedProxies: a.trustedProxies,
+		sloWindow:      a.sloWindow,
+	}
+
+	if len(config.General.RootCertificate) > 0 {
+		cert, err := cryptotls.X509KeyPair(config.General.RootCertificate, config.General.RootKey)
+		if err != nil {
+			return nil, err
+		}
+
+		instance.rootCertificate = &cert
+	}
+
+	instance.setAuth(config)
+	instance.Handlers = handlers.New(instance.Auth, instance.Artifact)
+
+	if refresher, ok := source.(hooks.Refresher); ok && config.General.DeploymentHooks {
+		instance.Hooks = hooks.New(refresher, config.GitLab.APISecretKey, hooks.DefaultTimeout)
+	}
+
+	instance.MicroCache = microcache.New(config.MicroCache.TTL, config.MicroCache.StaleIfError, config.MicroCache.MaxSize, config.MicroCache.MaxEntries)
+
+	if config.GitLab.PublicServer != "" {
+		instance.AcmeMiddleware = &acme.Middleware{GitlabURL: config.GitLab.PublicServer}
+	}
+
+	return instance, nil
+}
+
+// instance returns the instance serving host: the one with the longest pages
+// domain host belongs to, otherwise the first one serving host as a custom
+// domain. It returns a when no virtual instance serves host, and the error of
+// the first domains source which fails to look host up.
+//
+// The matches are cached for instanceMatchTTL, so the custom domains are not
+// looked up in each domains source on every request.
+func (a *theApp) instance(ctx context.Context, host string) (*theApp, error) {
+	if len(a.instances) == 0 {
+		return a, nil
+	}
+
+	host = strings.ToLower(host)
+
+	match, err := a.instanceMatches.FindOrFetch("", host, func() (interface{}, error) {
+		return a.matchInstance(ctx, host)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return match.(*theApp), nil
+}
+
+func (a *theApp) matchInstance(ctx context.Context, host string) (*theApp, error) {
+	all := a.all()
+
+	var match *theApp
+	for _, instance := range all {
+		pagesDomain := instance.config.General.Domain
+		if host != pagesDomain && !strings.HasSuffix(host, "."+pagesDomain) {
+			continue
+		}
+
+		if match == nil || len(pagesDomain) > len(match.config.General.Domain) {
+			match = instance
+		}
+	}
+
+	if match != nil {
+		return match, nil
+	}
+
+	// the domains sources cache the custom domains they do not serve too
+	for _, instance := range all {
+		d, err := instance.domain(ctx, host)
+		if err != nil && !errors.Is(err, domain.ErrDomainDoesNotExist) {
+			return nil, err
+		}
+
+		if d != nil {
+			return instance, nil
+		}
+	}
+
+	return a, nil
+}
+
+// instancesMiddleware serves the requests to the virtual instances with
+// their handler, and the other requests with handler
+func (a *theApp) instancesMiddleware(handler http.Handler, instanceHandlers map[*theApp]http.Handler) http.Handler {
+	if len(a.instances) == 0 {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		instance, err := a.instance(r.Context(), request.GetHostWithoutPort(r))
+		if err != nil {
+			metrics.DomainsSourceFailures.Inc()
+
+			httperrors.ServeError(w, r, "could not fetch domain information from a source", pageserrors.Wrap(pageserrors.SourceFailed, err))
+			return
+		}
+
+		if h, ok := instanceHandlers[instance]; ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/pageserrors"
+)
+
+// domainsSource serves the domains of the map
+type domainsSource map[string]*domain.Domain
+
+func (s domainsSource) GetDomain(_ context.Context, name string) (*domain.Domain, error) {
+	if d, ok := s[name]; ok {
+		return d, nil
+	}
+
+	return nil, domain.ErrDomainDoesNotExist
+}
+
+// failingSource fails to look up every domain
+type failingSource struct{}
+
+func (failingSource) GetDomain(context.Context, string) (*domain.Domain, error) {
+	return nil, errors.New("source unavailable")
+}
+
+func newTestInstance(pagesDomain string, customDomains ...string) *theApp {
+	source := domainsSource{}
+	for _, name := range customDomains {
+		source[name] = &domain.Domain{Name: name}
+	}
+
+	return &theApp{
+		config: &config.Config{General: config.General{Domain: pagesDomain}},
+		source: source,
+	}
+}
+
+func TestInstance(t *testing.T) {
+	primary := newTestInstance("gitlab.io", "example.com")
+	staging := newTestInstance("staging.gitlab.io", "staging.example.com")
+	other := newTestInstance("pages.example.org", "example.net")
+	primary.instances = []*theApp{staging, other}
+	primary.instanceMatches = newInstanceMatches()
+
+	tests := map[string]struct {
+		host     string
+		expected *theApp
+	}{
+		"main_pages_domain":      {host: "group.gitlab.io", expected: primary},
+		"instance_pages_domain":  {host: "Group.Pages.Example.org", expected: other},
+		"nested_pages_domain":    {host: "group.staging.gitlab.io", expected: staging},
+		"main_custom_domain":     {host: "example.com", expected: primary},
+		"instance_custom_domain": {host: "example.net", expected: other},
+		"unknown_custom_domain":  {host: "unknown.com", expected: primary},
+		"first_instance_custom":  {host: "staging.example.com", expected: staging},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			instance, err := primary.instance(context.Background(), tt.host)
+			require.NoError(t, err)
+			require.Same(t, tt.expected, instance)
+		})
+	}
+
+	t.Run("cached_match", func(t *testing.T) {
+		staging.source.(domainsSource)["new.example.com"] = &domain.Domain{Name: "new.example.com"}
+
+		instance, err := primary.instance(context.Background(), "new.example.com")
+		require.NoError(t, err)
+		require.Same(t, staging, instance)
+
+		delete(staging.source.(domainsSource), "new.example.com")
+
+		instance, err = primary.instance(context.Background(), "new.example.com")
+		require.NoError(t, err)
+		require.Same(t, staging, instance, "the match is cached")
+	})
+
+	t.Run("source_error", func(t *testing.T) {
+		failing := newTestInstance("failing.example.org")
+		failing.source = failingSource{}
+		primary := newTestInstance("gitlab.io")
+		primary.instances = []*theApp{failing}
+		primary.instanceMatches = newInstanceMatches()
+
+		_, err := primary.instance(context.Background(), "unknown.com")
+		require.Error(t, err)
+
+		instance, err := primary.instance(context.Background(), "group.failing.example.org")
+		require.NoError(t, err, "pages domains are matched without a lookup")
+		require.Same(t, failing, instance)
+	})
+}
+
+func TestInstancesMiddleware(t *testing.T) {
+	primary := newTestInstance("gitlab.io")
+	staging := newTestInstance("staging.gitlab.io")
+
+	handler := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		})
+	}
+
+	primary.instances = []*theApp{staging}
+	primary.instanceMatches = newInstanceMatches()
+	middleware := primary.instancesMiddleware(handler("main"), map[*theApp]http.Handler{staging: handler("staging")})
+
+	for host, expected := range map[string]string{
+		"group.gitlab.io":              "main",
+		"group.staging.gitlab.io:8080": "staging",
+	} {
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://"+host+"/", nil))
+
+		require.Equal(t, expected, w.Body.String(), host)
+	}
+}
+
+func TestInstancesMiddlewareSourceError(t *testing.T) {
+	failing := newTestInstance("failing.example.org")
+	failing.source = failingSource{}
+	primary := newTestInstance("gitlab.io")
+	primary.instances = []*theApp{failing}
+	primary.instanceMatches = newInstanceMatches()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("the request must not be served")
+	})
+	middleware := primary.instancesMiddleware(handler, map[*theApp]http.Handler{failing: handler})
+
+	w := httptest.NewRecorder()
+	middleware.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://unknown.com/", nil))
+
+	// like the domains which cannot be fetched by the routing middleware
+	require.Equal(t, http.StatusBadGateway, w.Code)
+	require.Contains(t, w.Body.String(), pageserrors.SourceFailed.Code)
+}
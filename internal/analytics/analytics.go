@@ -0,0 +1,179 @@
+// Package analytics aggregates the requests served for each domain and
+// periodically reports the summaries to GitLab, so project owners can see
+// the traffic of their Pages without third-party services
+package analytics
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+// maxPathsPerDomain limits the number of distinct paths counted for a domain
+// during an interval, the requests to other paths are still part of the
+// totals of the domain
+const maxPathsPerDomain = 1000
+
+// results of a report counted by metrics.AnalyticsReports
+const (
+	resultReported = "reported"
+	resultFailed   = "failed"
+)
+
+// Reporter sends the access summaries to GitLab, it is implemented by the
+// GitLab API client
+type Reporter interface {
+	ReportAccessSummaries(ctx context.Context, summaries []api.AccessSummary) error
+}
+
+type domainStats struct {
+	requests    uint64
+	bytes       uint64
+	statusCodes map[int]uint64
+	paths       map[string]uint64
+}
+
+// Collector counts the requests served for each domain until they are
+// reported
+type Collector struct {
+	reporter   Reporter
+	topPaths   int
+	maxDomains int
+
+	mu      sync.Mutex
+	start   time.Time
+	domains map[string]*domainStats
+	now     func() time.Time
+}
+
+// New returns a Collector reporting with reporter the topPaths most requested
+// paths of at most maxDomains domains per interval
+func New(reporter Reporter, topPaths, maxDomains int) *Collector {
+	return &Collector{
+		reporter:   reporter,
+		topPaths:   topPaths,
+		maxDomains: maxDomains,
+		start:      time.Now(),
+		domains:    make(map[string]*domainStats),
+		now:        time.Now,
+	}
+}
+
+// Observe records a request to path of domain answered with status and a
+// body of size bytes. Requests of new domains are dropped once maxDomains
+// domains were served during the interval.
+func (c *Collector) Observe(domain, path string, status int, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats, ok := c.domains[domain]
+	if !ok {
+		if len(c.domains) >= c.maxDomains {
+			metrics.AnalyticsDroppedRequests.Inc()
+			return
+		}
+
+		stats = &domainStats{
+			statusCodes: make(map[int]uint64),
+			paths:       make(map[string]uint64),
+		}
+		c.domains[domain] = stats
+	}
+
+	stats.requests++
+	stats.bytes += uint64(size)
+	stats.statusCodes[status]++
+
+	if _, ok := stats.paths[path]; ok || len(stats.paths) < maxPathsPerDomain {
+		stats.paths[path]++
+	}
+}
+
+// Flush returns the summaries of the domains served since the previous
+// flush and resets the counters
+func (c *Collector) Flush() []api.AccessSummary {
+	c.mu.Lock()
+	domains, start := c.domains, c.start
+	c.domains = make(map[string]*domainStats, len(domains))
+	c.start = c.now()
+	end := c.start
+	c.mu.Unlock()
+
+	summaries := make([]api.AccessSummary, 0, len(domains))
+	for name, stats := range domains {
+		summaries = append(summaries, api.AccessSummary{
+			Domain:      name,
+			Start:       start,
+			End:         end,
+			Requests:    stats.requests,
+			Bytes:       stats.bytes,
+			StatusCodes: stats.statusCodes,
+			TopPaths:    topPaths(stats.paths, c.topPaths),
+		})
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Domain < summaries[j].Domain
+	})
+
+	return summaries
+}
+
+// Run reports the summaries every interval until ctx is done
+func (c *Collector) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.report(ctx)
+		}
+	}
+}
+
+// report sends the summaries of the interval, they are dropped when GitLab
+// can not be reached so the memory used by the collector stays bounded
+func (c *Collector) report(ctx context.Context) {
+	summaries := c.Flush()
+	if len(summaries) == 0 {
+		return
+	}
+
+	if err := c.reporter.ReportAccessSummaries(ctx, summaries); err != nil {
+		metrics.AnalyticsReports.WithLabelValues(resultFailed).Inc()
+		log.WithError(err).WithField("domains", len(summaries)).Warn("failed to report access summaries")
+		return
+	}
+
+	metrics.AnalyticsReports.WithLabelValues(resultReported).Inc()
+}
+
+func topPaths(paths map[string]uint64, n int) []api.PathCount {
+	counts := make([]api.PathCount, 0, len(paths))
+	for path, requests := range paths {
+		counts = append(counts, api.PathCount{Path: path, Requests: requests})
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Requests != counts[j].Requests {
+			return counts[i].Requests > counts[j].Requests
+		}
+
+		return counts[i].Path < counts[j].Path
+	})
+
+	if len(counts) > n {
+		counts = counts[:n]
+	}
+
+	return counts
+}
@@ -0,0 +1,163 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+type reporterFunc func(ctx context.Context, summaries []api.AccessSummary) error
+
+func (f reporterFunc) ReportAccessSummaries(ctx context.Context, summaries []api.AccessSummary) error {
+	return f(ctx, summaries)
+}
+
+func TestCollectorFlush(t *testing.T) {
+	start := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	end := start.Add(time.Minute)
+
+	c := New(nil, 2, 10)
+	c.start = start
+	c.now = func() time.Time { return end }
+
+	c.Observe("b.gitlab.io", "/", http.StatusOK, 100)
+	c.Observe("a.gitlab.io", "/index.html", http.StatusOK, 10)
+	c.Observe("a.gitlab.io", "/missing", http.StatusNotFound, 5)
+	c.Observe("a.gitlab.io", "/index.html", http.StatusNotModified, 0)
+	c.Observe("a.gitlab.io", "/about.html", http.StatusOK, 20)
+
+	expected := []api.AccessSummary{
+		{
+			Domain:      "a.gitlab.io",
+			Start:       start,
+			End:         end,
+			Requests:    4,
+			Bytes:       35,
+			StatusCodes: map[int]uint64{http.StatusOK: 2, http.StatusNotModified: 1, http.StatusNotFound: 1},
+			TopPaths: []api.PathCount{
+				{Path: "/index.html", Requests: 2},
+				{Path: "/about.html", Requests: 1},
+			},
+		},
+		{
+			Domain:      "b.gitlab.io",
+			Start:       start,
+			End:         end,
+			Requests:    1,
+			Bytes:       100,
+			StatusCodes: map[int]uint64{http.StatusOK: 1},
+			TopPaths:    []api.PathCount{{Path: "/", Requests: 1}},
+		},
+	}
+
+	require.Equal(t, expected, c.Flush())
+	require.Empty(t, c.Flush(), "counters are reset")
+	require.Equal(t, end, c.start)
+}
+
+func TestCollectorMaxDomains(t *testing.T) {
+	dropped := testutil.ToFloat64(metrics.AnalyticsDroppedRequests)
+
+	c := New(nil, 10, 1)
+	c.Observe("a.gitlab.io", "/", http.StatusOK, 1)
+	c.Observe("b.gitlab.io", "/", http.StatusOK, 1)
+	c.Observe("a.gitlab.io", "/", http.StatusOK, 1)
+
+	summaries := c.Flush()
+	require.Len(t, summaries, 1)
+	require.Equal(t, "a.gitlab.io", summaries[0].Domain)
+	require.Equal(t, uint64(2), summaries[0].Requests)
+	require.Equal(t, dropped+1, testutil.ToFloat64(metrics.AnalyticsDroppedRequests))
+}
+
+func TestCollectorMaxPathsPerDomain(t *testing.T) {
+	c := New(nil, maxPathsPerDomain+1, 1)
+	for i := 0; i <= maxPathsPerDomain; i++ {
+		c.Observe("a.gitlab.io", "/"+time.Duration(i).String(), http.StatusOK, 1)
+	}
+
+	summaries := c.Flush()
+	require.Equal(t, uint64(maxPathsPerDomain+1), summaries[0].Requests)
+	require.Len(t, summaries[0].TopPaths, maxPathsPerDomain)
+}
+
+func TestCollectorReport(t *testing.T) {
+	tests := map[string]struct {
+		observe        bool
+		err            error
+		expectedCalls  int
+		expectedResult string
+	}{
+		"reported": {
+			observe:        true,
+			expectedCalls:  1,
+			expectedResult: resultReported,
+		},
+		"failed": {
+			observe:        true,
+			err:            errors.New("unavailable"),
+			expectedCalls:  1,
+			expectedResult: resultFailed,
+		},
+		"nothing_to_report": {},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var calls int
+			c := New(reporterFunc(func(ctx context.Context, summaries []api.AccessSummary) error {
+				calls++
+				require.Len(t, summaries, 1)
+				return tt.err
+			}), 10, 10)
+
+			if tt.observe {
+				c.Observe("a.gitlab.io", "/", http.StatusOK, 1)
+			}
+
+			var before float64
+			if tt.expectedResult != "" {
+				before = testutil.ToFloat64(metrics.AnalyticsReports.WithLabelValues(tt.expectedResult))
+			}
+
+			c.report(context.Background())
+
+			require.Equal(t, tt.expectedCalls, calls)
+			require.Empty(t, c.Flush(), "summaries are not kept after a report")
+			if tt.expectedResult != "" {
+				require.Equal(t, before+1, testutil.ToFloat64(metrics.AnalyticsReports.WithLabelValues(tt.expectedResult)))
+			}
+		})
+	}
+}
+
+func TestCollectorRun(t *testing.T) {
+	reported := make(chan []api.AccessSummary, 1)
+
+	c := New(reporterFunc(func(ctx context.Context, summaries []api.AccessSummary) error {
+		reported <- summaries
+		return nil
+	}), 10, 10)
+	c.Observe("a.gitlab.io", "/", http.StatusOK, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx, time.Millisecond)
+		close(done)
+	}()
+
+	summaries := <-reported
+	require.Equal(t, "a.gitlab.io", summaries[0].Domain)
+
+	cancel()
+	<-done
+}
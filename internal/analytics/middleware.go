@@ -0,0 +1,30 @@
+package analytics
+
+import (
+	"net/http"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/logging"
+)
+
+// NewMiddleware returns middleware recording the requests served for the
+// domain resolved by the routing middleware in c. It returns handler when c
+// is nil.
+func NewMiddleware(handler http.Handler, c *Collector) http.Handler {
+	if c == nil {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w, r, resp := logging.TrackResponse(w, r)
+
+		handler.ServeHTTP(w, r)
+
+		d := domain.FromRequest(r)
+		if d == nil || d.Name == "" {
+			return
+		}
+
+		c.Observe(d.Name, r.URL.Path, resp.Status(), resp.BytesWritten())
+	})
+}
@@ -0,0 +1,74 @@
+package analytics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+)
+
+func TestMiddleware(t *testing.T) {
+	tests := map[string]struct {
+		domain          *domain.Domain
+		status          int
+		expectedSummary bool
+	}{
+		"domain": {
+			domain:          &domain.Domain{Name: "group.gitlab.io"},
+			status:          http.StatusOK,
+			expectedSummary: true,
+		},
+		"error_status": {
+			domain:          &domain.Domain{Name: "group.gitlab.io"},
+			status:          http.StatusNotFound,
+			expectedSummary: true,
+		},
+		"unknown_domain": {
+			status: http.StatusNotFound,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := New(nil, 10, 10)
+
+			handler := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte("body"))
+			}), c)
+
+			r := httptest.NewRequest(http.MethodGet, "/path", nil)
+			r = domain.ReqWithHostAndDomain(r, "group.gitlab.io", tt.domain)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			require.Equal(t, tt.status, w.Code)
+
+			summaries := c.Flush()
+			if !tt.expectedSummary {
+				require.Empty(t, summaries)
+				return
+			}
+
+			require.Len(t, summaries, 1)
+			require.Equal(t, "group.gitlab.io", summaries[0].Domain)
+			require.Equal(t, uint64(len("body")), summaries[0].Bytes)
+			require.Equal(t, map[int]uint64{tt.status: 1}, summaries[0].StatusCodes)
+			require.Equal(t, "/path", summaries[0].TopPaths[0].Path)
+		})
+	}
+}
+
+func TestMiddlewareDisabled(t *testing.T) {
+	handler := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("body"))
+	}), nil)
+
+	// without a domain in the context, which would be required to count it
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, "body", w.Body.String())
+}
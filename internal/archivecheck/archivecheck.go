@@ -0,0 +1,278 @@
+// Package archivecheck inspects a Pages deployment archive with the same zip
+// VFS used for serving and reports the problems that would affect serving it.
+package archivecheck
+
+import (
+	stdzip "archive/zip"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/headers"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/redirects"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs/zip"
+)
+
+const (
+	publicDirectory  = "public/"
+	indexFile        = "index.html"
+	defaultCacheTime = time.Minute
+)
+
+// Severity of an Issue
+type Severity string
+
+const (
+	// SeverityError is used for issues that prevent the archive, or part of
+	// it, from being served
+	SeverityError Severity = "error"
+	// SeverityWarning is used for issues that might be unexpected
+	SeverityWarning Severity = "warning"
+)
+
+// Issue is a problem found in the archive
+type Issue struct {
+	Severity Severity `json:"severity"`
+	Path     string   `json:"path,omitempty"`
+	Message  string   `json:"message"`
+}
+
+// Report is the result of checking an archive
+type Report struct {
+	Archive         string  `json:"archive"`
+	PublicDirectory string  `json:"public_directory,omitempty"`
+	Files           int     `json:"files"`
+	Directories     int     `json:"directories"`
+	Symlinks        int     `json:"symlinks"`
+	TotalSize       int64   `json:"total_size"`
+	Issues          []Issue `json:"issues"`
+}
+
+// Valid returns true when the report does not contain any error
+func (r *Report) Valid() bool {
+	for _, issue := range r.Issues {
+		if issue.Severity == SeverityError {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r *Report) addIssue(severity Severity, path, format string, args ...interface{}) {
+	r.Issues = append(r.Issues, Issue{
+		Severity: severity,
+		Path:     path,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+type checker struct {
+	maxSize     int64
+	openTimeout time.Duration
+}
+
+// Option to configure the checks
+type Option func(*checker)
+
+// WithMaxSize reports an error when the total uncompressed size of the public
+// directory exceeds maxSize bytes. A zero value disables the check.
+func WithMaxSize(maxSize int64) Option {
+	return func(c *checker) {
+		c.maxSize = maxSize
+	}
+}
+
+// WithOpenTimeout sets the timeout to open the archive
+func WithOpenTimeout(timeout time.Duration) Option {
+	return func(c *checker) {
+		c.openTimeout = timeout
+	}
+}
+
+// Check opens the archive at archivePath and inspects its public directory.
+// It returns an error if the archive cannot be opened.
+func Check(ctx context.Context, archivePath string, opts ...Option) (*Report, error) {
+	c := &checker{openTimeout: time.Minute}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	root, err := c.openRoot(ctx, archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Archive: archivePath}
+
+	err = zip.Walk(root, func(name string, fi os.FileInfo) error {
+		c.checkEntry(ctx, root, report, name, fi)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if report.Files+report.Directories+report.Symlinks == 0 {
+		report.addIssue(SeverityError, "", "archive does not contain a %s directory%s",
+			publicDirectory, topLevelDirectoriesHint(archivePath))
+
+		return report, nil
+	}
+
+	report.PublicDirectory = publicDirectory
+
+	if c.maxSize > 0 && report.TotalSize > c.maxSize {
+		report.addIssue(SeverityError, "", "total size of %d bytes exceeds the maximum of %d bytes",
+			report.TotalSize, c.maxSize)
+	}
+
+	if _, err := root.Lstat(ctx, indexFile); err != nil {
+		report.addIssue(SeverityWarning, indexFile, "file is missing, the site root will not be served")
+	}
+
+	checkRedirects(ctx, root, report)
+	checkHeaders(ctx, root, report)
+
+	return report, nil
+}
+
+func (c *checker) openRoot(ctx context.Context, archivePath string) (vfs.Root, error) {
+	absPath, err := filepath.Abs(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := os.Stat(absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", archivePath)
+	}
+
+	cfg := &config.Config{
+		Zip: config.ZipServing{
+			ExpirationInterval: defaultCacheTime,
+			CleanupInterval:    defaultCacheTime,
+			RefreshInterval:    defaultCacheTime,
+			OpenTimeout:        c.openTimeout,
+			AllowedPaths:       []string{filepath.Dir(absPath)},
+		},
+	}
+
+	zipVFS := zip.New(&cfg.Zip)
+	if err := zipVFS.Reconfigure(cfg); err != nil {
+		return nil, err
+	}
+
+	archiveURL := url.URL{Scheme: "file", Path: absPath}
+
+	return zipVFS.Root(ctx, archiveURL.String(), absPath)
+}
+
+func (c *checker) checkEntry(ctx context.Context, root vfs.Root, report *Report, name string, fi os.FileInfo) {
+	switch {
+	case fi.IsDir():
+		report.Directories++
+
+	case fi.Mode()&os.ModeSymlink != 0:
+		report.Symlinks++
+		checkSymlink(ctx, root, report, name)
+
+	case fi.Mode().IsRegular():
+		report.Files++
+		report.TotalSize += fi.Size()
+
+		file, err := root.Open(ctx, name)
+		if err != nil {
+			report.addIssue(SeverityError, name, "file cannot be read: %v", err)
+			return
+		}
+		file.Close()
+
+	default:
+		report.addIssue(SeverityWarning, name, "unsupported file mode %s, the file will not be served", fi.Mode())
+	}
+}
+
+func checkSymlink(ctx context.Context, root vfs.Root, report *Report, name string) {
+	target, err := root.Readlink(ctx, name)
+	if err != nil {
+		report.addIssue(SeverityError, name, "symlink cannot be read: %v", err)
+		return
+	}
+
+	// absolute targets are resolved from the public directory while serving
+	resolved := path.Join(path.Dir(name), target)
+	if path.IsAbs(target) {
+		resolved = strings.TrimPrefix(path.Clean(target), "/")
+	}
+
+	if resolved == ".." || strings.HasPrefix(resolved, "../") {
+		report.addIssue(SeverityError, name, "symlink target %q points outside of the public directory and will not be served", target)
+		return
+	}
+
+	if _, err := root.Lstat(ctx, resolved); err != nil {
+		report.addIssue(SeverityWarning, name, "symlink target %q does not exist", target)
+	}
+}
+
+func checkRedirects(ctx context.Context, root vfs.Root, report *Report) {
+	if _, err := root.Lstat(ctx, redirects.ConfigFile); err != nil {
+		return
+	}
+
+	for _, err := range redirects.ParseRedirects(ctx, root, nil).Errors() {
+		report.addIssue(SeverityError, redirects.ConfigFile, "%v", err)
+	}
+}
+
+func checkHeaders(ctx context.Context, root vfs.Root, report *Report) {
+	if _, err := root.Lstat(ctx, headers.ConfigFile); err != nil {
+		return
+	}
+
+	for _, err := range headers.ParseHeaders(ctx, root).Errors() {
+		report.addIssue(SeverityError, headers.ConfigFile, "%v", err)
+	}
+}
+
+// topLevelDirectoriesHint lists the top level directories of the archive to
+// help finding a misnamed public directory
+func topLevelDirectoriesHint(archivePath string) string {
+	reader, err := stdzip.OpenReader(archivePath)
+	if err != nil {
+		return ""
+	}
+	defer reader.Close()
+
+	directories := make(map[string]struct{})
+	for _, file := range reader.File {
+		if i := strings.Index(file.Name, "/"); i > 0 {
+			directories[file.Name[:i+1]] = struct{}{}
+		}
+	}
+
+	if len(directories) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(directories))
+	for name := range directories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return fmt.Sprintf(", found: %s", strings.Join(names, ", "))
+}
@@ -0,0 +1,181 @@
+package archivecheck
+
+import (
+	stdzip "archive/zip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type entry struct {
+	name    string
+	content string
+	symlink bool
+}
+
+func createArchive(t *testing.T, entries ...entry) string {
+	t.Helper()
+
+	archivePath := filepath.Join(t.TempDir(), "public.zip")
+
+	f, err := os.Create(archivePath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	w := stdzip.NewWriter(f)
+	for _, e := range entries {
+		header := &stdzip.FileHeader{Name: e.name, Method: stdzip.Deflate}
+		if e.symlink {
+			header.SetMode(os.ModeSymlink | 0755)
+		}
+
+		fw, err := w.CreateHeader(header)
+		require.NoError(t, err)
+
+		_, err = fw.Write([]byte(e.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	return archivePath
+}
+
+func TestCheck(t *testing.T) {
+	tests := map[string]struct {
+		entries        []entry
+		opts           []Option
+		expectedFiles  int
+		expectedIssues []Issue
+		expectedValid  bool
+	}{
+		"valid_archive": {
+			entries: []entry{
+				{name: "public/index.html", content: "index"},
+				{name: "public/subdir/page.html", content: "page"},
+				{name: "public/_redirects", content: "/old.html /new.html 301"},
+			},
+			expectedFiles: 3,
+			expectedValid: true,
+		},
+		"missing_public_directory": {
+			entries: []entry{
+				{name: "dist/index.html", content: "index"},
+				{name: "src/main.go", content: "package main"},
+			},
+			expectedIssues: []Issue{
+				{Severity: SeverityError, Message: "archive does not contain a public/ directory, found: dist/, src/"},
+			},
+		},
+		"missing_index": {
+			entries: []entry{
+				{name: "public/page.html", content: "page"},
+			},
+			expectedFiles: 1,
+			expectedIssues: []Issue{
+				{Severity: SeverityWarning, Path: "index.html", Message: "file is missing, the site root will not be served"},
+			},
+			expectedValid: true,
+		},
+		"max_size_exceeded": {
+			entries: []entry{
+				{name: "public/index.html", content: "0123456789"},
+			},
+			opts:          []Option{WithMaxSize(5)},
+			expectedFiles: 1,
+			expectedIssues: []Issue{
+				{Severity: SeverityError, Message: "total size of 10 bytes exceeds the maximum of 5 bytes"},
+			},
+		},
+		"invalid_redirects": {
+			entries: []entry{
+				{name: "public/index.html", content: "index"},
+				{name: "public/_redirects", content: "/old.html /new.html 418"},
+			},
+			expectedFiles: 2,
+			expectedIssues: []Issue{
+				{Severity: SeverityError, Path: "_redirects", Message: "rule 1: status not supported"},
+			},
+		},
+		"headers_file": {
+			entries: []entry{
+				{name: "public/index.html", content: "index"},
+				{name: "public/_headers", content: "/*\n  X-Test: 1"},
+			},
+			expectedFiles: 2,
+			expectedValid: true,
+		},
+		"invalid_headers": {
+			entries: []entry{
+				{name: "public/index.html", content: "index"},
+				{name: "public/_headers", content: "/*\n  Set-Cookie: session=1"},
+			},
+			expectedFiles: 2,
+			expectedIssues: []Issue{
+				{Severity: SeverityError, Path: "_headers", Message: "line 2: Set-Cookie: header cannot be set by projects"},
+			},
+		},
+		"symlinks": {
+			entries: []entry{
+				{name: "public/index.html", content: "index"},
+				{name: "public/valid.html", content: "index.html", symlink: true},
+				{name: "public/absolute.html", content: "/index.html", symlink: true},
+				{name: "public/broken.html", content: "missing.html", symlink: true},
+				{name: "public/outside.html", content: "../secret.txt", symlink: true},
+			},
+			expectedFiles: 1,
+			expectedIssues: []Issue{
+				{Severity: SeverityWarning, Path: "broken.html", Message: `symlink target "missing.html" does not exist`},
+				{Severity: SeverityError, Path: "outside.html", Message: `symlink target "../secret.txt" points outside of the public directory and will not be served`},
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			archivePath := createArchive(t, tt.entries...)
+
+			report, err := Check(context.Background(), archivePath, tt.opts...)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.expectedFiles, report.Files)
+			require.Equal(t, tt.expectedIssues, report.Issues)
+			require.Equal(t, tt.expectedValid, report.Valid())
+		})
+	}
+}
+
+func TestCheckFixture(t *testing.T) {
+	report, err := Check(context.Background(), "../../shared/pages/group/zip.gitlab.io/public.zip")
+	require.NoError(t, err)
+
+	require.Equal(t, "public/", report.PublicDirectory)
+	require.Equal(t, 5, report.Files)
+	require.Equal(t, 1, report.Directories)
+	require.Equal(t, 2, report.Symlinks)
+	require.Equal(t, []Issue{
+		{Severity: SeverityError, Path: "bad_symlink.html", Message: "symlink cannot be read: symlink too long"},
+	}, report.Issues)
+}
+
+func TestCheckInvalidArchive(t *testing.T) {
+	t.Run("missing_file", func(t *testing.T) {
+		_, err := Check(context.Background(), filepath.Join(t.TempDir(), "missing.zip"))
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("not_a_zip_file", func(t *testing.T) {
+		archivePath := filepath.Join(t.TempDir(), "public.zip")
+		require.NoError(t, os.WriteFile(archivePath, []byte("not a zip"), 0600))
+
+		_, err := Check(context.Background(), archivePath)
+		require.EqualError(t, err, "zip: not a valid zip file")
+	})
+
+	t.Run("directory", func(t *testing.T) {
+		_, err := Check(context.Background(), t.TempDir())
+		require.Error(t, err)
+	})
+}
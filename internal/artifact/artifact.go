@@ -3,7 +3,6 @@ package artifact
 import (
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"path"
@@ -14,21 +13,38 @@ import (
 
 	"gitlab.com/gitlab-org/labkit/errortracking"
 
+	"gitlab.com/gitlab-org/gitlab-pages/internal/bufferpool"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/egress"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httperrors"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httptransport"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/logging"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/request"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
 const (
-	// Format a non-/-suffixed URL, an escaped project full_path, a job ID and
-	// a /-prefixed file path into an URL string
-	apiURLTemplate = "%s/projects/%s/jobs/%s/artifacts%s"
+	// Format an escaped project full_path, a job ID and a /-prefixed file path
+	// into an API path, appended to the non-/-suffixed URL of a server
+	apiPathTemplate = "/projects/%s/jobs/%s/artifacts%s"
 
 	minStatusCode = 200
 	maxStatusCode = 299
 
 	createArtifactRequestErrMsg = "failed to create the artifact request"
 	artifactRequestErrMsg       = "failed to request the artifact"
+	artifactRetryMsg            = "artifacts server failed, retrying with another one"
+)
+
+// forwardedHeaders are the headers of the artifacts server responses sent to
+// clients
+var forwardedHeaders = []string{"Content-Type"}
+
+// results of the requests to artifacts servers reported by metrics.ArtifactsServerRequests
+const (
+	resultSuccess     = "success"
+	resultUnavailable = "unavailable"
+	resultError       = "error"
 )
 
 var (
@@ -39,22 +55,49 @@ var (
 
 // Artifact proxies requests for artifact files to the GitLab artifacts API
 type Artifact struct {
-	server string
-	suffix string
-	client *http.Client
+	balancer *balancer
+	suffix   string
+	client   *http.Client
 }
 
 // New when provided the arguments defined herein, returns a pointer to an
-// Artifact that is used to proxy requests.
-func New(server string, timeoutSeconds int, pagesDomain string) *Artifact {
-	return &Artifact{
-		server: strings.TrimRight(server, "/"),
-		suffix: "." + strings.ToLower(pagesDomain),
+// Artifact that is used to proxy requests. Requests are spread across the
+// servers by weight, and retried on another server when one is unavailable.
+// The artifacts servers are only connected to when egressPolicy allows it.
+// Servers with the PROXY protocol enabled get a connection per request, with
+// a header carrying the address of the client.
+func New(servers []config.ArtifactsBackend, timeoutSeconds int, pagesDomain string, egressPolicy *egress.Policy) *Artifact {
+	timeout := time.Second * time.Duration(timeoutSeconds)
+	a := &Artifact{
+		balancer: newBalancer(servers),
+		suffix:   "." + strings.ToLower(pagesDomain),
 		client: &http.Client{
-			Timeout:   time.Second * time.Duration(timeoutSeconds),
-			Transport: httptransport.DefaultTransport,
+			Timeout:   timeout,
+			Transport: httptransport.NewTransportWithDialContext(egressPolicy.DialContext(nil), nil),
 		},
 	}
+
+	var proxyClient *http.Client
+
+	for _, be := range a.balancer.backends {
+		if !be.proxyProtocol {
+			continue
+		}
+
+		if proxyClient == nil {
+			transport := httptransport.NewTransportWithDialContext(proxyProtocolDial(egressPolicy.DialContext(nil)), nil)
+			// connections carry the address of a single client, and the
+			// header must reach the load balancer of the server
+			transport.DisableKeepAlives = true
+			transport.Proxy = nil
+
+			proxyClient = &http.Client{Timeout: timeout, Transport: transport}
+		}
+
+		be.client = proxyClient
+	}
+
+	return a
 }
 
 // TryMakeRequest will attempt to proxy a request and write it to the argument
@@ -62,41 +105,113 @@ func New(server string, timeoutSeconds int, pagesDomain string) *Artifact {
 // http.ResponseWriter has been written to in any capacity. Additional handler func
 // may be given which should return true if it did handle the response.
 func (a *Artifact) TryMakeRequest(host string, w http.ResponseWriter, r *http.Request, token string, additionalHandler func(*http.Response) bool) bool {
-	if a == nil || a.server == "" || host == "" {
+	if a == nil || len(a.balancer.backends) == 0 || host == "" {
 		return false
 	}
 
-	reqURL, ok := a.BuildURL(host, r.URL.Path)
+	apiPath, ok := a.buildAPIPath(host, r.URL.Path)
 	if !ok {
 		return false
 	}
 
-	a.makeRequest(w, r, reqURL, token, additionalHandler)
+	logging.SetBackend(r, "artifacts")
+	a.makeRequest(w, r, apiPath, token, additionalHandler)
 
 	return true
 }
 
-func (a *Artifact) makeRequest(w http.ResponseWriter, r *http.Request, reqURL *url.URL, token string, additionalHandler func(*http.Response) bool) {
-	req, err := http.NewRequestWithContext(r.Context(), "GET", reqURL.String(), nil)
-	if err != nil {
-		logging.LogRequest(r).WithError(err).Error(createArtifactRequestErrMsg)
-		errortracking.Capture(err, errortracking.WithRequest(r), errortracking.WithStackTrace())
-		httperrors.Serve500(w)
+func (a *Artifact) makeRequest(w http.ResponseWriter, r *http.Request, apiPath string, token string, additionalHandler func(*http.Response) bool) {
+	tried := make(map[*backend]bool, len(a.balancer.backends))
+
+	for {
+		be := a.balancer.next(tried)
+		tried[be] = true
+		canRetry := len(tried) < len(a.balancer.backends)
+
+		reqURL, err := url.Parse(be.url + apiPath)
+		if err != nil {
+			logging.LogRequest(r).WithError(err).Error(createArtifactRequestErrMsg)
+			errortracking.Capture(err, errortracking.WithRequest(r), errortracking.WithStackTrace())
+			httperrors.Serve500(w)
+			return
+		}
+
+		resp, err := a.request(be, r, reqURL, token)
+		if err != nil {
+			metrics.ArtifactsServerRequests.WithLabelValues(be.label, resultError).Inc()
+
+			// the client going away says nothing about the health of the server
+			if r.Context().Err() == nil {
+				a.balancer.markUnhealthy(be)
+
+				if canRetry {
+					logging.LogRequest(r).WithError(err).WithField("artifacts_server", be.label).Warn(artifactRetryMsg)
+					continue
+				}
+			}
+
+			if request.IsClientDisconnect(r, err) {
+				logging.LogRequest(r).WithError(err).WithField("client_disconnect", true).Info(artifactRequestErrMsg)
+			} else {
+				logging.LogRequest(r).WithError(err).Error(artifactRequestErrMsg)
+				errortracking.Capture(err, errortracking.WithRequest(r), errortracking.WithStackTrace())
+			}
+
+			httperrors.Serve502(w)
+			return
+		}
+
+		if isUnavailable(resp.StatusCode) {
+			metrics.ArtifactsServerRequests.WithLabelValues(be.label, resultUnavailable).Inc()
+			a.balancer.markUnhealthy(be)
+
+			if canRetry {
+				resp.Body.Close()
+				logging.LogRequest(r).WithField("artifacts_server", be.label).WithField("status", resp.StatusCode).Warn(artifactRetryMsg)
+				continue
+			}
+		} else {
+			metrics.ArtifactsServerRequests.WithLabelValues(be.label, resultSuccess).Inc()
+		}
+
+		serveResponse(w, r, resp, token, additionalHandler)
 		return
 	}
+}
+
+func (a *Artifact) request(be *backend, r *http.Request, reqURL *url.URL, token string) (*http.Response, error) {
+	ctx := r.Context()
+	client := a.client
+
+	if be.client != nil {
+		ctx = withProxyAddrs(ctx, r)
+		client = be.client
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
 
 	if token != "" {
 		req.Header.Add("Authorization", "Bearer "+token)
 	}
-	resp, err := a.client.Do(req)
 
-	if err != nil {
-		logging.LogRequest(r).WithError(err).Error(artifactRequestErrMsg)
-		errortracking.Capture(err, errortracking.WithRequest(r), errortracking.WithStackTrace())
-		httperrors.Serve502(w)
-		return
+	return client.Do(req)
+}
+
+// isUnavailable returns true for the status codes of servers which cannot
+// handle the request at the moment
+func isUnavailable(statusCode int) bool {
+	switch statusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
 	}
 
+	return false
+}
+
+func serveResponse(w http.ResponseWriter, r *http.Request, resp *http.Response, token string, additionalHandler func(*http.Response) bool) {
 	defer resp.Body.Close()
 
 	if additionalHandler(resp) {
@@ -120,10 +235,25 @@ func (a *Artifact) makeRequest(w http.ResponseWriter, r *http.Request, reqURL *u
 		addCacheHeader(w, resp)
 	}
 
-	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
-	w.Header().Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	copyHeaders(w.Header(), resp)
 	w.WriteHeader(resp.StatusCode)
-	io.Copy(w, resp.Body)
+	bufferpool.Copy(w, resp.Body)
+}
+
+// copyHeaders copies the allowed headers of the artifacts server response.
+// Other headers, e.g. hop-by-hop headers, cookies or headers disclosing the
+// software of the server, are never sent to clients.
+func copyHeaders(h http.Header, resp *http.Response) {
+	for _, name := range forwardedHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			h.Set(name, value)
+		}
+	}
+
+	// the Content-Length header of the response is removed by net/http
+	if resp.ContentLength >= 0 {
+		h.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
 }
 
 func addCacheHeader(w http.ResponseWriter, resp *http.Response) {
@@ -148,40 +278,62 @@ func encodePathSegments(path string) string {
 	return strings.Join(encoded, "/")
 }
 
-// BuildURL returns a pointer to a url.URL for where the request should be
-// proxied to. The returned bool will indicate if there is some sort of issue
-// with the url while it is being generated.
-//
-// The URL is generated from the host (which contains the top-level group and
-// ends with the pagesDomain) and the path (which contains any subgroups, the
-// project, a job ID and a path
-// for the artifact file we want to download)
+// BuildURL returns a pointer to a url.URL on the first artifacts server for
+// where the request should be proxied to. The returned bool will indicate if
+// there is some sort of issue with the url while it is being generated.
 func (a *Artifact) BuildURL(host, requestPath string) (*url.URL, bool) {
-	if !strings.HasSuffix(strings.ToLower(host), a.suffix) {
+	if len(a.balancer.backends) == 0 {
 		return nil, false
 	}
 
-	topGroup := host[0 : len(host)-len(a.suffix)]
+	apiPath, ok := a.buildAPIPath(host, requestPath)
+	if !ok {
+		return nil, false
+	}
 
+	u, err := url.Parse(a.balancer.backends[0].url + apiPath)
+	if err != nil {
+		return nil, false
+	}
+	return u, true
+}
+
+// buildAPIPath returns the path of the artifacts API the request should be
+// proxied to, appended to the URL of an artifacts server.
+//
+// The path is generated from the host (which contains the top-level group and
+// ends with the pagesDomain) and the path (which contains any subgroups, the
+// project, a job ID and a path
+// for the artifact file we want to download)
+// ProjectPath returns the path of the project, relative to its namespace,
+// whose artifacts are requested at requestPath
+func ProjectPath(requestPath string) (string, bool) {
 	parts := pathExtractor.FindAllStringSubmatch(requestPath, 1)
 	if len(parts) != 1 || len(parts[0]) != 4 {
-		return nil, false
+		return "", false
 	}
 
-	restOfPath := strings.TrimLeft(strings.TrimRight(parts[0][1], "/"), "/")
-	if len(restOfPath) == 0 {
-		return nil, false
+	projectPath := strings.Trim(parts[0][1], "/")
+
+	return projectPath, projectPath != ""
+}
+
+func (a *Artifact) buildAPIPath(host, requestPath string) (string, bool) {
+	if !strings.HasSuffix(strings.ToLower(host), a.suffix) {
+		return "", false
+	}
+
+	topGroup := host[0 : len(host)-len(a.suffix)]
+
+	restOfPath, ok := ProjectPath(requestPath)
+	if !ok {
+		return "", false
 	}
 
+	parts := pathExtractor.FindAllStringSubmatch(requestPath, 1)
 	jobID := parts[0][2]
 	artifactPath := encodePathSegments(parts[0][3])
 
 	projectID := url.PathEscape(path.Join(topGroup, restOfPath))
-	generated := fmt.Sprintf(apiURLTemplate, a.server, projectID, jobID, artifactPath)
-
-	u, err := url.Parse(generated)
-	if err != nil {
-		return nil, false
-	}
-	return u, true
+	return fmt.Sprintf(apiPathTemplate, projectID, jobID, artifactPath), true
 }
@@ -1,15 +1,20 @@
 package artifact_test
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
 
+	"github.com/pires/go-proxyproto"
 	"github.com/stretchr/testify/require"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/artifact"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/egress"
 )
 
 func TestTryMakeRequest(t *testing.T) {
@@ -76,7 +81,7 @@ func TestTryMakeRequest(t *testing.T) {
 			reqURL, err := url.Parse("/-/subgroup/project/-/jobs/1/artifacts" + c.Path)
 			require.NoError(t, err)
 			r := &http.Request{URL: reqURL}
-			art := artifact.New(testServer.URL, 1, "gitlab-example.io")
+			art := artifact.New([]config.ArtifactsBackend{{URL: testServer.URL, Weight: 1}}, 1, "gitlab-example.io", &egress.Policy{})
 
 			require.True(t, art.TryMakeRequest("group.gitlab-example.io", result, r, c.Token, func(resp *http.Response) bool { return false }))
 			require.Equal(t, c.Status, result.Code)
@@ -88,6 +93,166 @@ func TestTryMakeRequest(t *testing.T) {
 	}
 }
 
+func TestTryMakeRequestSanitizesHeaders(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Server", "nginx")
+		w.Header().Set("X-Powered-By", "Rails")
+		w.Header().Set("Set-Cookie", "_gitlab_session=secret")
+		w.Header().Set("X-Request-Id", "backend-id")
+		w.Header().Set("Connection", "X-Backend-Hop")
+		w.Header().Set("X-Backend-Hop", "value")
+		w.WriteHeader(http.StatusOK)
+
+		// flushing sends the response chunked, without Content-Length
+		fmt.Fprint(w, "chunked ")
+		w.(http.Flusher).Flush()
+		fmt.Fprint(w, "content")
+	}))
+	defer testServer.Close()
+
+	reqURL, err := url.Parse("/-/subgroup/project/-/jobs/1/artifacts/file.txt")
+	require.NoError(t, err)
+
+	result := httptest.NewRecorder()
+	art := artifact.New([]config.ArtifactsBackend{{URL: testServer.URL, Weight: 1}}, 1, "gitlab-example.io", &egress.Policy{})
+	require.True(t, art.TryMakeRequest("group.gitlab-example.io", result, &http.Request{URL: reqURL}, "", func(resp *http.Response) bool { return false }))
+
+	require.Equal(t, http.StatusOK, result.Code)
+	require.Equal(t, "chunked content", result.Body.String())
+	require.Equal(t, http.Header{
+		"Content-Type":  []string{"text/plain"},
+		"Cache-Control": []string{"max-age=3600"},
+	}, result.Header())
+}
+
+func TestTryMakeRequestProxyProtocol(t *testing.T) {
+	testServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the PROXY protocol listener reports the address of the header
+		fmt.Fprint(w, r.RemoteAddr)
+	}))
+	testServer.Listener = &proxyproto.Listener{
+		Listener: testServer.Listener,
+		Policy: func(upstream net.Addr) (proxyproto.Policy, error) {
+			return proxyproto.REQUIRE, nil
+		},
+	}
+	testServer.Start()
+	defer testServer.Close()
+
+	art := artifact.New([]config.ArtifactsBackend{{URL: testServer.URL, Weight: 1, ProxyProtocol: true}}, 1, "gitlab-example.io", &egress.Policy{})
+
+	// every request gets a connection with the address of its own client
+	for _, clientAddr := range []string{"203.0.113.7:4321", "198.51.100.2:1234", "[2001:db8::1]:5678"} {
+		reqURL, err := url.Parse("/-/subgroup/project/-/jobs/1/artifacts/file.txt")
+		require.NoError(t, err)
+
+		ctx := context.WithValue(context.Background(), http.LocalAddrContextKey, &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 443})
+		r := (&http.Request{URL: reqURL, RemoteAddr: clientAddr}).WithContext(ctx)
+
+		result := httptest.NewRecorder()
+		require.True(t, art.TryMakeRequest("group.gitlab-example.io", result, r, "", func(resp *http.Response) bool { return false }))
+
+		require.Equal(t, http.StatusOK, result.Code)
+		require.Equal(t, clientAddr, result.Body.String())
+	}
+}
+
+func TestTryMakeRequestWeightedServers(t *testing.T) {
+	var first, second int
+	firstServer := makeCountingServerStub(&first, http.StatusOK)
+	defer firstServer.Close()
+	secondServer := makeCountingServerStub(&second, http.StatusOK)
+	defer secondServer.Close()
+
+	art := artifact.New([]config.ArtifactsBackend{
+		{URL: firstServer.URL, Weight: 1},
+		{URL: secondServer.URL, Weight: 3},
+	}, 1, "gitlab-example.io", &egress.Policy{})
+
+	for i := 0; i < 8; i++ {
+		result := httptest.NewRecorder()
+		require.True(t, art.TryMakeRequest("group.gitlab-example.io", result, artifactRequest(t), "", func(resp *http.Response) bool { return false }))
+		require.Equal(t, http.StatusOK, result.Code)
+	}
+
+	require.Equal(t, 2, first)
+	require.Equal(t, 6, second)
+}
+
+func TestTryMakeRequestUnavailableServers(t *testing.T) {
+	closedServer := httptest.NewServer(http.NotFoundHandler())
+	closedServer.Close()
+
+	var unavailable, healthy int
+	unavailableServer := makeCountingServerStub(&unavailable, http.StatusServiceUnavailable)
+	defer unavailableServer.Close()
+	healthyServer := makeCountingServerStub(&healthy, http.StatusOK)
+	defer healthyServer.Close()
+
+	tests := map[string]struct {
+		servers        []config.ArtifactsBackend
+		expectedStatus int
+	}{
+		"server_down": {
+			servers:        []config.ArtifactsBackend{{URL: closedServer.URL, Weight: 1}, {URL: healthyServer.URL, Weight: 1}},
+			expectedStatus: http.StatusOK,
+		},
+		"server_unavailable": {
+			servers:        []config.ArtifactsBackend{{URL: unavailableServer.URL, Weight: 1}, {URL: healthyServer.URL, Weight: 1}},
+			expectedStatus: http.StatusOK,
+		},
+		"all_servers_down": {
+			servers:        []config.ArtifactsBackend{{URL: closedServer.URL, Weight: 1}},
+			expectedStatus: http.StatusBadGateway,
+		},
+		"all_servers_unavailable": {
+			servers:        []config.ArtifactsBackend{{URL: unavailableServer.URL, Weight: 1}},
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			art := artifact.New(tt.servers, 1, "gitlab-example.io", &egress.Policy{})
+
+			// the failing servers are skipped once they failed
+			for i := 0; i < 3; i++ {
+				unavailable, healthy = 0, 0
+
+				result := httptest.NewRecorder()
+				require.True(t, art.TryMakeRequest("group.gitlab-example.io", result, artifactRequest(t), "", func(resp *http.Response) bool { return false }))
+				require.Equal(t, tt.expectedStatus, result.Code)
+
+				if tt.expectedStatus == http.StatusOK {
+					require.Equal(t, 1, healthy)
+				}
+
+				if tt.expectedStatus == http.StatusOK && i > 0 {
+					require.Zero(t, unavailable)
+				}
+			}
+		})
+	}
+}
+
+func artifactRequest(t *testing.T) *http.Request {
+	t.Helper()
+
+	reqURL, err := url.Parse("/-/subgroup/project/-/jobs/1/artifacts/200.html")
+	require.NoError(t, err)
+
+	return &http.Request{URL: reqURL}
+}
+
+// makeCountingServerStub counts the requests and responds to them with status
+func makeCountingServerStub(count *int, status int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*count++
+		w.WriteHeader(status)
+	}))
+}
+
 // provide stub for testing different artifact responses
 func makeArtifactServerStub(t *testing.T, content string, contentType string) *httptest.Server {
 	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -260,7 +425,7 @@ func TestBuildURL(t *testing.T) {
 
 	for _, c := range cases {
 		t.Run(c.Description, func(t *testing.T) {
-			a := artifact.New(c.RawServer, 1, c.PagesDomain)
+			a := artifact.New([]config.ArtifactsBackend{{URL: c.RawServer, Weight: 1}}, 1, c.PagesDomain, &egress.Policy{})
 			u, ok := a.BuildURL(c.Host, c.Path)
 
 			msg := c.Description + " - generated URL: "
@@ -275,3 +440,24 @@ func TestBuildURL(t *testing.T) {
 		})
 	}
 }
+
+func TestProjectPath(t *testing.T) {
+	tests := map[string]struct {
+		path       string
+		expected   string
+		expectedOk bool
+	}{
+		"project":         {path: "/-/project/-/jobs/1/artifacts/file.txt", expected: "project", expectedOk: true},
+		"subgroup":        {path: "/-/subgroup/project/-/jobs/1/artifacts/", expected: "subgroup/project", expectedOk: true},
+		"empty_project":   {path: "/-//-/jobs/1/artifacts/"},
+		"not_an_artifact": {path: "/project/index.html"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			projectPath, ok := artifact.ProjectPath(tt.path)
+			require.Equal(t, tt.expectedOk, ok)
+			require.Equal(t, tt.expected, projectPath)
+		})
+	}
+}
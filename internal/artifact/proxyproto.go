@@ -0,0 +1,57 @@
+package artifact
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/pires/go-proxyproto"
+)
+
+type proxyAddrsKey struct{}
+
+// proxyAddrs are the addresses of the connection of a client, sent in the
+// PROXY protocol header of the connections to the artifacts servers
+type proxyAddrs struct {
+	client net.Addr
+	local  net.Addr
+}
+
+// withProxyAddrs saves the addresses of the connection of the client of r
+// in ctx, for proxyProtocolDial to send them
+func withProxyAddrs(ctx context.Context, r *http.Request) context.Context {
+	addrs := proxyAddrs{}
+
+	if host, port, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		addrs.client, _ = net.ResolveTCPAddr("tcp", net.JoinHostPort(host, port))
+	}
+
+	if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		addrs.local = local
+	}
+
+	return context.WithValue(ctx, proxyAddrsKey{}, addrs)
+}
+
+// proxyProtocolDial wraps dial so a PROXY protocol v2 header with the
+// addresses saved by withProxyAddrs is sent first on every connection. The
+// header is unspecified when the addresses are not TCP addresses, e.g. for
+// requests which did not come from a client.
+func proxyProtocolDial(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		addrs, _ := ctx.Value(proxyAddrsKey{}).(proxyAddrs)
+
+		header := proxyproto.HeaderProxyFromAddrs(2, addrs.client, addrs.local)
+		if _, err := header.WriteTo(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return conn, nil
+	}
+}
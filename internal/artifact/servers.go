@@ -0,0 +1,110 @@
+package artifact
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+// unhealthyPeriod is the time a server is skipped after a failed request
+const unhealthyPeriod = 30 * time.Second
+
+// backend tracks the weight and health of an artifacts server
+type backend struct {
+	url   string
+	label string
+
+	// proxyProtocol servers are requested with client, which sends a PROXY
+	// protocol header on its connections
+	proxyProtocol bool
+	client        *http.Client
+
+	weight        int
+	currentWeight int
+	unhealthyTill time.Time
+}
+
+func newBackend(server config.ArtifactsBackend) *backend {
+	label := server.URL
+	if u, err := url.Parse(server.URL); err == nil && u.Host != "" {
+		label = u.Host
+	}
+
+	return &backend{
+		url:           strings.TrimRight(server.URL, "/"),
+		label:         label,
+		weight:        server.Weight,
+		proxyProtocol: server.ProxyProtocol,
+	}
+}
+
+// balancer picks the backends requests are proxied to using a smooth
+// weighted round-robin, skipping the backends which recently failed
+type balancer struct {
+	mu       sync.Mutex
+	backends []*backend
+}
+
+func newBalancer(servers []config.ArtifactsBackend) *balancer {
+	b := &balancer{}
+
+	for _, server := range servers {
+		b.backends = append(b.backends, newBackend(server))
+	}
+
+	return b
+}
+
+// next returns the backend to use for a request, excluding the backends
+// already tried for it. Unhealthy backends are only returned when all the
+// remaining ones are unhealthy.
+func (b *balancer) next(tried map[*backend]bool) *backend {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+
+	if selected := b.pick(tried, func(be *backend) bool { return !now.Before(be.unhealthyTill) }); selected != nil {
+		return selected
+	}
+
+	return b.pick(tried, func(*backend) bool { return true })
+}
+
+func (b *balancer) pick(tried map[*backend]bool, eligible func(*backend) bool) *backend {
+	var selected *backend
+	total := 0
+
+	for _, be := range b.backends {
+		if tried[be] || !eligible(be) {
+			continue
+		}
+
+		be.currentWeight += be.weight
+		total += be.weight
+
+		if selected == nil || be.currentWeight > selected.currentWeight {
+			selected = be
+		}
+	}
+
+	if selected != nil {
+		selected.currentWeight -= total
+	}
+
+	return selected
+}
+
+// markUnhealthy skips be for the unhealthyPeriod
+func (b *balancer) markUnhealthy(be *backend) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	be.unhealthyTill = time.Now().Add(unhealthyPeriod)
+	metrics.ArtifactsServerUnhealthy.WithLabelValues(be.label).Inc()
+}
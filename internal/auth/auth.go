@@ -11,21 +11,28 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
+	"github.com/patrickmn/go-cache"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/hkdf"
 
 	"gitlab.com/gitlab-org/labkit/errortracking"
 
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	domainCfg "gitlab.com/gitlab-org/gitlab-pages/internal/domain"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httperrors"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httptransport"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/logging"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/pageserrors"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/request"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/security"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
 // nolint: gosec
@@ -39,6 +46,9 @@ const (
 	callbackPath           = "/auth"
 	authorizeProxyTemplate = "%s?domain=%s&state=%s"
 	authSessionMaxAge      = 60 * 10 // 10 minutes
+	maxAuthRedirects       = 5
+	defaultCookieName      = "gitlab-pages"
+	hostCookiePrefix       = "__Host-"
 
 	failAuthErrMsg         = "failed to authenticate request"
 	fetchAccessTokenErrMsg = "fetching access token failed"
@@ -46,8 +56,51 @@ const (
 	saveSessionErrMsg      = "failed to save the session"
 )
 
+// stages and outcomes of the OAuth flow reported by metrics.AuthFlow
+const (
+	flowStageRedirect         = "redirect"
+	flowStageCallback         = "callback"
+	flowStageStateValidation  = "state_validation"
+	flowStageTokenFetch       = "token_fetch"
+	flowStageAccess           = "access"
+	flowStageSessionDestroyed = "session_destroyed"
+	flowStageHandoff          = "handoff"
+
+	flowOutcomeSuccess = "success"
+	flowOutcomeFailure = "failure"
+	flowOutcomeGranted = "granted"
+	flowOutcomeDenied  = "denied"
+	flowOutcomeLoop    = "loop"
+)
+
+// GitLab API endpoints reported by metrics.AuthAPICallDuration
+const (
+	apiEndpointToken   = "token"
+	apiEndpointProject = "project"
+	apiEndpointUser    = "user"
+
+	defaultAPITimeout = 5 * time.Second
+
+	// defaultMaxUsedNonces bounds the nonces of the codes exchanged before
+	// they expire, which are kept in memory
+	defaultMaxUsedNonces = 100000
+
+	// defaultAPIRetryBackoff is the wait before the first retry of a GitLab
+	// API call, it doubles with every further retry
+	defaultAPIRetryBackoff = 100 * time.Millisecond
+)
+
 var (
-	errResponseNotOk     = errors.New("response was not ok")
+	// callbackParamLimits limits the length of the attacker-controlled query
+	// parameters read while handling the OAuth callback
+	callbackParamLimits = map[string]int{
+		"state":  security.MaxStateLength,
+		"code":   security.MaxCodeLength,
+		"domain": security.MaxDomainLength,
+		"error":  security.MaxErrorLength,
+	}
+
+	errResponseNotOk     = pageserrors.New(pageserrors.AuthFailed, "response was not ok")
 	errAuthNotConfigured = errors.New("authentication is not configured")
 	errGenerateKeys      = errors.New("could not generate auth keys")
 )
@@ -64,11 +117,88 @@ type Auth struct {
 	authScope            string
 	jwtSigningKey        []byte
 	jwtExpiry            time.Duration
+	usedNonces           *cache.Cache // nonces of the codes already exchanged, kept in memory until the codes expire
+	maxUsedNonces        int
 	apiClient            *http.Client
 	store                sessions.Store
+	cookieName           string
+	cookieScope          string
+	tokenTimeout         time.Duration
+	accessCheckTimeout   time.Duration
+	apiRetries           int
+	apiRetryBackoff      time.Duration
+	queryToken           bool             // exchange the handoff tokens passed in the query of requests
 	now                  func() time.Time // allows to stub time.Now() easily in tests
 }
 
+// Option to configure Auth
+type Option func(*Auth)
+
+// WithCookieName sets the name of the session cookie
+func WithCookieName(name string) Option {
+	return func(a *Auth) {
+		a.cookieName = name
+	}
+}
+
+// WithCookieScope sets the scope of the session cookie to one of the
+// config.AuthCookieScope* values
+func WithCookieScope(scope string) Option {
+	return func(a *Auth) {
+		a.cookieScope = scope
+	}
+}
+
+// WithTokenTimeout sets the timeout of exchanging an OAuth code for a token
+func WithTokenTimeout(timeout time.Duration) Option {
+	return func(a *Auth) {
+		a.tokenTimeout = timeout
+	}
+}
+
+// WithAccessCheckTimeout sets the timeout of checking the access of a user
+// to a project
+func WithAccessCheckTimeout(timeout time.Duration) Option {
+	return func(a *Auth) {
+		a.accessCheckTimeout = timeout
+	}
+}
+
+// WithCodeExpiry sets the lifetime of the signed OAuth codes handed to custom
+// domains, which must be exchanged for a token before they expire
+func WithCodeExpiry(expiry time.Duration) Option {
+	return func(a *Auth) {
+		a.jwtExpiry = expiry
+	}
+}
+
+// WithQueryToken enables the handoff tokens authenticating the clients which
+// can not follow the OAuth flow with cookies through a query parameter
+func WithQueryToken(enabled bool) Option {
+	return func(a *Auth) {
+		a.queryToken = enabled
+	}
+}
+
+// WithAPIRetries sets the number of times an idempotent GitLab API call is
+// retried after a connection error or a 502, 503 or 504 response
+func WithAPIRetries(retries int) Option {
+	return func(a *Auth) {
+		a.apiRetries = retries
+	}
+}
+
+// WithProxy sends the requests to GitLab through the forward proxy selected by
+// proxy, e.g. when GitLab can only be reached through a proxy
+func WithProxy(proxy func(*http.Request) (*url.URL, error)) Option {
+	return func(a *Auth) {
+		transport := httptransport.NewTransport()
+		transport.Proxy = httptransport.InstrumentProxy(proxy, metrics.AuthProxyRequests)
+
+		a.apiClient.Transport = transport
+	}
+}
+
 type tokenResponse struct {
 	AccessToken  string `json:"access_token"`
 	TokenType    string `json:"token_type"`
@@ -86,19 +216,53 @@ type domain interface {
 }
 
 func (a *Auth) getSessionFromStore(r *http.Request) (*sessions.Session, error) {
-	session, err := a.store.Get(r, "gitlab-pages")
+	session, err := a.store.Get(r, a.sessionCookieName(r))
 
 	if session != nil {
-		// Cookie just for this domain
+		// Cookie just for this domain unless it is shared with the pages domain
 		session.Options.Path = "/"
+		session.Options.Domain = a.sessionCookieDomain(r)
 		session.Options.HttpOnly = true
 		session.Options.Secure = request.IsHTTPS(r)
 		session.Options.MaxAge = authSessionMaxAge
+
+		// Browsers only send the cookie to frames embedded by other sites
+		// with SameSite=None, which requires the cookie to be Secure
+		if session.Options.Secure && domainCfg.EmbeddingPolicyFromRequest(r) != nil {
+			session.Options.SameSite = http.SameSiteNoneMode
+		}
 	}
 
 	return session, err
 }
 
+// sessionCookieName returns the name of the session cookie. Browsers only
+// accept cookies with the __Host- prefix over HTTPS.
+func (a *Auth) sessionCookieName(r *http.Request) string {
+	if a.cookieScope == config.AuthCookieScopeHostPrefix && request.IsHTTPS(r) {
+		return hostCookiePrefix + a.cookieName
+	}
+
+	return a.cookieName
+}
+
+// sessionCookieDomain returns the pages domain when the session cookie is
+// shared between its subdomains and r is for one of them, otherwise the
+// cookie is only sent to the host of r
+func (a *Auth) sessionCookieDomain(r *http.Request) string {
+	if a.cookieScope != config.AuthCookieScopePagesDomain {
+		return ""
+	}
+
+	host := strings.ToLower(request.GetHostWithoutPort(r))
+	pagesDomain := strings.ToLower(a.pagesDomain)
+	if host == pagesDomain || strings.HasSuffix(host, "."+pagesDomain) {
+		return pagesDomain
+	}
+
+	return ""
+}
+
 func (a *Auth) checkSession(w http.ResponseWriter, r *http.Request) (*sessions.Session, error) {
 	// Create or get session
 	session, errsession := a.getSessionFromStore(r)
@@ -113,7 +277,7 @@ func (a *Auth) checkSession(w http.ResponseWriter, r *http.Request) (*sessions.S
 			return nil, errsave
 		}
 
-		http.Redirect(w, r, getRequestAddress(r), http.StatusFound)
+		redirect(w, r, getRequestAddress(r))
 		return nil, errsession
 	}
 
@@ -126,18 +290,33 @@ func (a *Auth) TryAuthenticate(w http.ResponseWriter, r *http.Request, domains s
 		return false
 	}
 
-	session, err := a.checkSession(w, r)
-	if err != nil {
+	if a.queryToken && r.URL.Path == HandoffPath {
+		a.serveHandoffToken(w, r)
 		return true
 	}
 
-	// Request is for auth
+	// Only callbacks are handled here, the session of other requests is
+	// checked when they reach a project with access control, so public
+	// projects never read nor set the session cookie
 	if r.URL.Path != callbackPath {
 		return false
 	}
 
+	session, err := a.checkSession(w, r)
+	if err != nil {
+		return true
+	}
+
 	logRequest(r).Info("Receive OAuth authentication callback")
 
+	if err := security.ValidateParamLengths(r.URL.Query(), callbackParamLimits); err != nil {
+		logRequest(r).WithError(err).Warn("Invalid OAuth callback parameters")
+		observeFlow(flowStageCallback, flowOutcomeFailure)
+
+		httperrors.Serve401(w)
+		return true
+	}
+
 	if a.handleProxyingAuth(session, w, r, domains) {
 		return true
 	}
@@ -146,6 +325,7 @@ func (a *Auth) TryAuthenticate(w http.ResponseWriter, r *http.Request, domains s
 	errorParam := r.URL.Query().Get("error")
 	if errorParam != "" {
 		logRequest(r).WithField("error", errorParam).Warn("OAuth endpoint returned error")
+		observeFlow(flowStageCallback, flowOutcomeFailure)
 
 		httperrors.Serve401(w)
 		return true
@@ -163,6 +343,7 @@ func (a *Auth) checkAuthenticationResponse(session *sessions.Session, w http.Res
 	if !validateState(r, session) {
 		// State is NOT ok
 		logRequest(r).Warn("Authentication state did not match expected")
+		observeFlow(flowStageStateValidation, flowOutcomeFailure)
 
 		httperrors.Serve401(w)
 		return
@@ -171,14 +352,18 @@ func (a *Auth) checkAuthenticationResponse(session *sessions.Session, w http.Res
 	redirectURI, ok := session.Values["uri"].(string)
 	if !ok {
 		logRequest(r).Error("Can not extract redirect uri from session")
+		observeFlow(flowStageStateValidation, flowOutcomeFailure)
 		httperrors.Serve500(w)
 		return
 	}
 
+	observeFlow(flowStageStateValidation, flowOutcomeSuccess)
+
 	decryptedCode, err := a.DecryptCode(r.URL.Query().Get("code"), getRequestDomain(r))
 	if err != nil {
 		logRequest(r).WithError(err).Error("failed to decrypt secure code")
 		captureErrWithReqAndStackTrace(err, r)
+		observeFlow(flowStageTokenFetch, flowOutcomeFailure)
 		httperrors.Serve500(w)
 		return
 	}
@@ -196,11 +381,14 @@ func (a *Auth) checkAuthenticationResponse(session *sessions.Session, w http.Res
 			errortracking.WithField("redirect_uri", redirectURI),
 			errortracking.WithStackTrace(),
 		)
+		observeFlow(flowStageTokenFetch, flowOutcomeFailure)
 
-		httperrors.Serve503(w)
+		httperrors.ServeErrorCategory(w, pageserrors.AuthFailed)
 		return
 	}
 
+	observeFlow(flowStageTokenFetch, flowOutcomeSuccess)
+
 	// Store access token
 	session.Values["access_token"] = token.AccessToken
 	err = session.Save(r, w)
@@ -217,7 +405,7 @@ func (a *Auth) checkAuthenticationResponse(session *sessions.Session, w http.Res
 		"redirect_uri", redirectURI,
 	).Info("Authentication was successful, redirecting user back to requested page")
 
-	http.Redirect(w, r, redirectURI, http.StatusFound)
+	redirect(w, r, redirectURI)
 }
 
 func (a *Auth) domainAllowed(ctx context.Context, name string, domains source.Source) bool {
@@ -247,6 +435,7 @@ func (a *Auth) handleProxyingAuth(session *sessions.Session, w http.ResponseWrit
 				errortracking.WithField("domain", domain),
 				errortracking.WithStackTrace(),
 			)
+			observeFlow(flowStageRedirect, flowOutcomeFailure)
 
 			httperrors.Serve500(w)
 			return true
@@ -258,6 +447,7 @@ func (a *Auth) handleProxyingAuth(session *sessions.Session, w http.ResponseWrit
 
 		if !a.domainAllowed(r.Context(), host, domains) {
 			logRequest(r).WithField("domain", host).Warn("Domain is not configured")
+			observeFlow(flowStageRedirect, flowOutcomeFailure)
 			httperrors.Serve401(w)
 			return true
 		}
@@ -270,6 +460,7 @@ func (a *Auth) handleProxyingAuth(session *sessions.Session, w http.ResponseWrit
 		if err != nil {
 			logRequest(r).WithError(err).Error(saveSessionErrMsg)
 			captureErrWithReqAndStackTrace(err, r)
+			observeFlow(flowStageRedirect, flowOutcomeFailure)
 
 			httperrors.Serve500(w)
 			return true
@@ -281,8 +472,9 @@ func (a *Auth) handleProxyingAuth(session *sessions.Session, w http.ResponseWrit
 			"public_gitlab_server": a.publicGitlabServer,
 			"pages_domain":         domain,
 		}).Info("Redirecting user to gitlab for oauth")
+		observeFlow(flowStageRedirect, flowOutcomeSuccess)
 
-		http.Redirect(w, r, url, http.StatusFound)
+		redirect(w, r, url)
 
 		return true
 	}
@@ -301,6 +493,7 @@ func (a *Auth) handleProxyingAuth(session *sessions.Session, w http.ResponseWrit
 		if err != nil {
 			logRequest(r).WithError(err).Error(saveSessionErrMsg)
 			captureErrWithReqAndStackTrace(err, r)
+			observeFlow(flowStageRedirect, flowOutcomeFailure)
 
 			httperrors.Serve500(w)
 			return true
@@ -315,6 +508,7 @@ func (a *Auth) handleProxyingAuth(session *sessions.Session, w http.ResponseWrit
 		if err != nil {
 			logRequest(r).WithError(err).Error(saveSessionErrMsg)
 			captureErrWithReqAndStackTrace(err, r)
+			observeFlow(flowStageRedirect, flowOutcomeFailure)
 
 			httperrors.Serve503(w)
 			return true
@@ -329,25 +523,44 @@ func (a *Auth) handleProxyingAuth(session *sessions.Session, w http.ResponseWrit
 
 		// Redirect pages to originating domain with code and state to finish
 		// authentication process
-		http.Redirect(w, r, proxyDomain+r.URL.Path+"?"+query.Encode(), http.StatusFound)
+		observeFlow(flowStageRedirect, flowOutcomeSuccess)
+		redirect(w, r, proxyDomain+r.URL.Path+"?"+query.Encode())
 		return true
 	}
 
 	return false
 }
 
+// redirect redirects the request to url, the redirects of the authentication
+// flow depend on the session so caches must not store them
+func redirect(w http.ResponseWriter, r *http.Request, url string) {
+	httperrors.DisableCaching(w)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
 func getRequestAddress(r *http.Request) string {
 	if request.IsHTTPS(r) {
-		return "https://" + r.Host + r.RequestURI
+		return "https://" + request.GetCanonicalHost(r) + r.RequestURI
 	}
-	return "http://" + r.Host + r.RequestURI
+	return "http://" + request.GetCanonicalHost(r) + r.RequestURI
 }
 
 func getRequestDomain(r *http.Request) string {
 	if request.IsHTTPS(r) {
-		return "https://" + r.Host
+		return "https://" + request.GetCanonicalHost(r)
+	}
+	return "http://" + request.GetCanonicalHost(r)
+}
+
+// isEmbeddedRequest returns true when r loads a page in a frame and its domain
+// allows embedding
+func isEmbeddedRequest(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Dest") {
+	case "iframe", "frame":
+		return domainCfg.EmbeddingPolicyFromRequest(r) != nil
 	}
-	return "http://" + r.Host
+
+	return false
 }
 
 func shouldProxyAuthToGitlab(r *http.Request) bool {
@@ -366,7 +579,8 @@ func validateState(r *http.Request, session *sessions.Session) bool {
 	}
 
 	// Check state
-	if session.Values["state"] == nil || session.Values["state"].(string) != state {
+	expectedState, ok := session.Values["state"].(string)
+	if !ok || !security.SecureCompare(state, expectedState) {
 		// State does not match
 		return false
 	}
@@ -395,13 +609,10 @@ func (a *Auth) fetchAccessToken(ctx context.Context, code string) (tokenResponse
 	content.Set("grant_type", "authorization_code")
 	content.Set("redirect_uri", a.redirectURI)
 
-	req, err := http.NewRequestWithContext(ctx, "POST", fetchURL.String(), strings.NewReader(content.Encode()))
-	if err != nil {
-		return token, err
-	}
-
 	// Request token
-	resp, err := a.apiClient.Do(req)
+	resp, err := a.doAPIRequest(ctx, apiEndpointToken, a.tokenTimeout, func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "POST", fetchURL.String(), strings.NewReader(content.Encode()))
+	})
 
 	if err != nil {
 		return token, err
@@ -411,7 +622,7 @@ func (a *Auth) fetchAccessToken(ctx context.Context, code string) (tokenResponse
 
 	if resp.StatusCode != 200 {
 		err = errResponseNotOk
-		captureErrWithReqAndStackTrace(err, req)
+		captureErrWithReqAndStackTrace(err, resp.Request)
 		return token, err
 	}
 
@@ -439,12 +650,38 @@ func (a *Auth) checkSessionIsValid(w http.ResponseWriter, r *http.Request) *sess
 }
 
 func (a *Auth) checkTokenExists(session *sessions.Session, w http.ResponseWriter, r *http.Request) bool {
+	if session.Values["access_token"] == nil && a.authorizeHandoffToken(session, w, r) {
+		return true
+	}
+
 	// If no access token redirect to OAuth login page
 	if session.Values["access_token"] == nil {
+		if isEmbeddedRequest(r) {
+			// GitLab cannot be displayed in frames so the user has to sign in
+			// from the top-level window. The flow starts over from there, so it
+			// does not depend on the browser storing cookies of embedded sites.
+			logRequest(r).Debug("No access token exists, asking user to sign in from the top-level window")
+			httperrors.Serve401SignIn(w, getRequestAddress(r))
+
+			return true
+		}
+
+		// The state of the previous flow is kept until the user is granted
+		// access, a new flow before that follows it in the same loop
+		redirects := 0
+		if previous, ok := session.Values["state"].(string); ok {
+			redirects = stateRedirects(previous) + 1
+		}
+
+		if redirects >= maxAuthRedirects {
+			a.breakRedirectLoop(session, w, r, redirects)
+			return true
+		}
+
 		logRequest(r).Debug("No access token exists, redirecting user to OAuth2 login")
 
 		// Generate state hash and store requested address
-		state := base64.URLEncoding.EncodeToString(securecookie.GenerateRandomKey(16))
+		state := newState(redirects)
 		session.Values["state"] = state
 		session.Values["uri"] = getRequestAddress(r)
 
@@ -455,20 +692,72 @@ func (a *Auth) checkTokenExists(session *sessions.Session, w http.ResponseWriter
 		if err != nil {
 			logRequest(r).WithError(err).Error(saveSessionErrMsg)
 			captureErrWithReqAndStackTrace(err, r)
+			observeFlow(flowStageRedirect, flowOutcomeFailure)
 
 			httperrors.Serve500(w)
 			return true
 		}
 
+		observeFlow(flowStageRedirect, flowOutcomeSuccess)
+
 		// Because the pages domain might be in public suffix list, we have to
 		// redirect to pages domain to trigger authorization flow
-		http.Redirect(w, r, a.getProxyAddress(r, state), http.StatusFound)
+		redirect(w, r, a.getProxyAddress(r, state))
 
 		return true
 	}
 	return false
 }
 
+// newState generates the state of an OAuth flow that follows redirects other
+// flows without the user being granted access in between. The count is
+// carried by the state, so it is kept when the browser rejects the cookie
+// storing the token, and it only grows while the flows do not end.
+func newState(redirects int) string {
+	return base64.URLEncoding.EncodeToString(securecookie.GenerateRandomKey(16)) + "." + strconv.Itoa(redirects)
+}
+
+// stateRedirects returns the number of flows the flow of state follows
+func stateRedirects(state string) int {
+	i := strings.LastIndexByte(state, '.')
+	if i < 0 {
+		return 0
+	}
+
+	redirects, err := strconv.Atoi(state[i+1:])
+	if err != nil || redirects < 0 {
+		return 0
+	}
+
+	return redirects
+}
+
+// breakRedirectLoop serves an error page instead of redirecting the session
+// to the OAuth flow again, e.g. because the tokens are rejected right after
+// they are issued or the redirect URI is misconfigured. The state is cleared,
+// so the user can try again.
+func (a *Auth) breakRedirectLoop(session *sessions.Session, w http.ResponseWriter, r *http.Request, redirects int) {
+	logRequest(r).WithFields(logrus.Fields{
+		"auth_redirects":    redirects,
+		"auth_redirect_uri": a.redirectURI,
+		"session_cookie":    a.sessionCookieName(r),
+		"session_domain":    a.sessionCookieDomain(r),
+	}).Error("authentication redirect loop detected, check the auth-redirect-uri and the clocks of Pages and GitLab")
+	observeFlow(flowStageRedirect, flowOutcomeLoop)
+
+	delete(session.Values, "state")
+
+	if err := session.Save(r, w); err != nil {
+		logRequest(r).WithError(err).Error(saveSessionErrMsg)
+		captureErrWithReqAndStackTrace(err, r)
+
+		httperrors.Serve500(w)
+		return
+	}
+
+	httperrors.Serve401RedirectLoop(w, getRequestAddress(r))
+}
+
 func (a *Auth) getProxyAddress(r *http.Request, state string) string {
 	return fmt.Sprintf(authorizeProxyTemplate, a.redirectURI, getRequestDomain(r), state)
 }
@@ -482,12 +771,15 @@ func destroySession(session *sessions.Session, w http.ResponseWriter, r *http.Re
 	if err != nil {
 		logRequest(r).WithError(err).Error(saveSessionErrMsg)
 		captureErrWithReqAndStackTrace(err, r)
+		observeFlow(flowStageSessionDestroyed, flowOutcomeFailure)
 
 		httperrors.Serve500(w)
 		return
 	}
 
-	http.Redirect(w, r, getRequestAddress(r), http.StatusFound)
+	observeFlow(flowStageSessionDestroyed, flowOutcomeSuccess)
+
+	redirect(w, r, getRequestAddress(r))
 }
 
 // IsAuthSupported checks if pages is running with the authentication support
@@ -503,28 +795,40 @@ func (a *Auth) checkAuthentication(w http.ResponseWriter, r *http.Request, domai
 
 	projectID := domain.GetProjectID(r)
 	// Access token exists, authorize request
-	var url string
+	var url, endpoint string
 	if projectID > 0 {
 		url = fmt.Sprintf(apiURLProjectTemplate, a.internalGitlabServer, projectID)
+		endpoint = apiEndpointProject
 	} else {
 		url = fmt.Sprintf(apiURLUserTemplate, a.internalGitlabServer)
+		endpoint = apiEndpointUser
 	}
-	req, err := http.NewRequestWithContext(r.Context(), "GET", url, nil)
 
-	if err != nil {
+	var errNewRequest error
+	resp, err := a.doAPIRequest(r.Context(), endpoint, a.accessCheckTimeout, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			errNewRequest = err
+			return nil, err
+		}
+
+		req.Header.Add("Authorization", "Bearer "+session.Values["access_token"].(string))
+		return req, nil
+	})
+
+	if errNewRequest != nil {
 		logRequest(r).WithError(err).Error(failAuthErrMsg)
 		captureErrWithReqAndStackTrace(err, r)
+		observeFlow(flowStageAccess, flowOutcomeFailure)
 
 		httperrors.Serve500(w)
 		return true
 	}
 
-	req.Header.Add("Authorization", "Bearer "+session.Values["access_token"].(string))
-	resp, err := a.apiClient.Do(req)
-
 	if err != nil {
 		logRequest(r).WithError(err).Error("Failed to retrieve info with token")
 		captureErrWithReqAndStackTrace(err, r)
+		observeFlow(flowStageAccess, flowOutcomeFailure)
 		// call serve404 handler when auth fails
 		domain.ServeNotFoundAuthFailed(w, r)
 		return true
@@ -533,6 +837,7 @@ func (a *Auth) checkAuthentication(w http.ResponseWriter, r *http.Request, domai
 	defer resp.Body.Close()
 
 	if checkResponseForInvalidToken(resp, session, w, r) {
+		observeFlow(flowStageAccess, flowOutcomeDenied)
 		return true
 	}
 
@@ -541,10 +846,22 @@ func (a *Auth) checkAuthentication(w http.ResponseWriter, r *http.Request, domai
 		err := fmt.Errorf("unexpected response fetching access token status: %d", resp.StatusCode)
 		logRequest(r).WithError(err).WithField("status", resp.Status).Error("Unexpected response fetching access token")
 		captureErrWithReqAndStackTrace(err, r)
+		observeFlow(flowStageAccess, flowOutcomeDenied)
 		domain.ServeNotFoundAuthFailed(w, r)
 		return true
 	}
 
+	observeFlow(flowStageAccess, flowOutcomeGranted)
+
+	// The flow ended, the next one does not follow it
+	if _, ok := session.Values["state"]; ok {
+		delete(session.Values, "state")
+		if err := session.Save(r, w); err != nil {
+			logRequest(r).WithError(err).Error(saveSessionErrMsg)
+			captureErrWithReqAndStackTrace(err, r)
+		}
+	}
+
 	return false
 }
 
@@ -641,6 +958,107 @@ func checkResponseForInvalidToken(resp *http.Response, session *sessions.Session
 	return false
 }
 
+// doAPIRequest sends the request built by newRequest to the GitLab API.
+// Idempotent requests are retried up to apiRetries times after connection
+// errors and 502, 503 or 504 responses, waiting apiRetryBackoff before the
+// first retry and twice as long before every further one. Other requests,
+// like the exchange of an OAuth code which can only be used once, are never
+// retried. The timeout applies to each attempt, including reading the body
+// of the response, and the latency of every attempt is observed by endpoint.
+func (a *Auth) doAPIRequest(ctx context.Context, endpoint string, timeout time.Duration, newRequest func(context.Context) (*http.Request, error)) (*http.Response, error) {
+	backoff := a.apiRetryBackoff
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if err := sleepContext(ctx, backoff); err != nil {
+				return nil, err
+			}
+			backoff *= 2
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+
+		req, err := newRequest(attemptCtx)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+
+		start := time.Now()
+		resp, err := a.apiClient.Do(req)
+		status := "error"
+		if err == nil {
+			status = strconv.Itoa(resp.StatusCode)
+		}
+		metrics.AuthAPICallDuration.WithLabelValues(endpoint, status).Observe(time.Since(start).Seconds())
+
+		retry := attempt < a.apiRetries && idempotent(req.Method) && ctx.Err() == nil
+		if err != nil {
+			cancel()
+			if retry {
+				continue
+			}
+			return nil, err
+		}
+
+		if retry && retryableStatus(resp.StatusCode) {
+			resp.Body.Close()
+			cancel()
+			continue
+		}
+
+		resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+		return resp, nil
+	}
+}
+
+// sleepContext waits for d unless ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	return false
+}
+
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// cancelBody releases the context of a request once its response is read
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	defer b.cancel()
+
+	return b.ReadCloser.Close()
+}
+
+func observeFlow(stage, outcome string) {
+	metrics.AuthFlow.WithLabelValues(stage, outcome).Inc()
+}
+
 func logRequest(r *http.Request) *logrus.Entry {
 	return logging.LogRequest(r).WithField("state", r.URL.Query().Get("state"))
 }
@@ -668,14 +1086,14 @@ func generateKeys(secret string, count int) ([][]byte, error) {
 }
 
 // New when authentication supported this will be used to create authentication handler
-func New(pagesDomain, storeSecret, clientID, clientSecret, redirectURI, internalGitlabServer, publicGitlabServer, authScope string) (*Auth, error) {
+func New(pagesDomain, storeSecret, clientID, clientSecret, redirectURI, internalGitlabServer, publicGitlabServer, authScope string, opts ...Option) (*Auth, error) {
 	// generate 3 keys, 2 for the cookie store and 1 for JWT signing
 	keys, err := generateKeys(storeSecret, 3)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Auth{
+	a := &Auth{
 		pagesDomain:          pagesDomain,
 		clientID:             clientID,
 		clientSecret:         clientSecret,
@@ -683,16 +1101,29 @@ func New(pagesDomain, storeSecret, clientID, clientSecret, redirectURI, internal
 		internalGitlabServer: strings.TrimRight(internalGitlabServer, "/"),
 		publicGitlabServer:   strings.TrimRight(publicGitlabServer, "/"),
 		apiClient: &http.Client{
-			Timeout:   5 * time.Second,
 			Transport: httptransport.DefaultTransport,
 		},
-		store:         sessions.NewCookieStore(keys[0], keys[1]),
-		authSecret:    storeSecret,
-		authScope:     authScope,
-		jwtSigningKey: keys[2],
-		jwtExpiry:     time.Minute,
-		now:           time.Now,
-	}, nil
+		store:              sessions.NewCookieStore(keys[0], keys[1]),
+		authSecret:         storeSecret,
+		authScope:          authScope,
+		jwtSigningKey:      keys[2],
+		jwtExpiry:          time.Minute,
+		cookieName:         defaultCookieName,
+		cookieScope:        config.AuthCookieScopeHost,
+		tokenTimeout:       defaultAPITimeout,
+		accessCheckTimeout: defaultAPITimeout,
+		apiRetryBackoff:    defaultAPIRetryBackoff,
+		maxUsedNonces:      defaultMaxUsedNonces,
+		now:                time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	a.usedNonces = cache.New(a.jwtExpiry, a.jwtExpiry)
+
+	return a, nil
 }
 
 func captureErrWithReqAndStackTrace(err error, r *http.Request) {
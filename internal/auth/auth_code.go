@@ -9,10 +9,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/gorilla/securecookie"
+	"github.com/patrickmn/go-cache"
 	"golang.org/x/crypto/hkdf"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/handoff"
 )
 
 var (
@@ -20,12 +24,34 @@ var (
 	errEmptyDomainOrCode = errors.New("empty domain or code")
 	errInvalidNonce      = errors.New("invalid nonce")
 	errInvalidCode       = errors.New("invalid code")
+	errInvalidDomain     = errors.New("code was issued for another domain")
+	errCodeReplayed      = errors.New("code was already used")
+	errTooManyCodes      = errors.New("too many codes were exchanged, try again later")
+	errInvalidPurpose    = errors.New("token was issued for another purpose")
+)
+
+// purposes of the signed tokens, so a token issued for one of them can not be
+// used for the other
+const (
+	purposeCode    = "code"
+	purposeHandoff = "handoff"
 )
 
 // EncryptAndSignCode encrypts the OAuth code deriving the key from the domain.
 // It adds the code and domain as JWT token claims and signs it using signingKey derived from
 // the Auth secret.
 func (a *Auth) EncryptAndSignCode(domain, code string) (string, error) {
+	return a.encryptAndSign(domain, code, purposeCode)
+}
+
+// DecryptCode decodes the secureCode as a JWT token and validates its signature,
+// expiry and domain. It then decrypts the code from the token claims and returns it.
+// Each code can only be decrypted once, so an intercepted code can not be replayed.
+func (a *Auth) DecryptCode(jwt, domain string) (string, error) {
+	return a.decrypt(jwt, domain, purposeCode)
+}
+
+func (a *Auth) encryptAndSign(domain, code, purpose string) (string, error) {
 	if domain == "" || code == "" {
 		return "", errEmptyDomainOrCode
 	}
@@ -47,49 +73,133 @@ func (a *Auth) EncryptAndSignCode(domain, code string) (string, error) {
 		"iat": a.now().Unix(),
 		"exp": a.now().Add(a.jwtExpiry).Unix(),
 		// custom claims
-		"domain": domain, // pass the domain so we can validate the signed domain matches the requested domain
-		"code":   hex.EncodeToString(encryptedCode),
-		"nonce":  nonce,
+		"domain":  domain, // pass the domain so we can validate the signed domain matches the requested domain
+		"code":    hex.EncodeToString(encryptedCode),
+		"nonce":   nonce,
+		"purpose": purpose,
 	}
 
 	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(a.jwtSigningKey)
 }
 
-// DecryptCode decodes the secureCode as a JWT token and validates its signature.
-// It then decrypts the code from the token claims and returns it.
-func (a *Auth) DecryptCode(jwt, domain string) (string, error) {
-	claims, err := a.parseJWTClaims(jwt)
+// decrypt decrypts the code of the token, which is only valid once. The used
+// nonces are not shared between instances, so each other instance still
+// accepts a code exchanged by this one once until it expires.
+func (a *Auth) decrypt(jwt, domain, purpose string) (string, error) {
+	code, nonce, err := a.decryptToken(jwt, domain, purpose)
+	if err != nil {
+		return "", err
+	}
+
+	// the nonces can not be forgotten before their codes expire, so the codes
+	// are rejected while too many were exchanged instead of being replayable
+	if a.usedNonces.ItemCount() >= a.maxUsedNonces {
+		return "", errTooManyCodes
+	}
+
+	// the nonce is only remembered until the code expires, after which the
+	// expiry rejects the code
+	if err := a.usedNonces.Add(nonce, struct{}{}, cache.DefaultExpiration); err != nil {
+		return "", errCodeReplayed
+	}
+
+	return code, nil
+}
+
+// decryptReusable decrypts the code of the token, which is valid until it
+// expires unless it was decrypted by decrypt
+func (a *Auth) decryptReusable(jwt, domain, purpose string) (string, error) {
+	code, nonce, err := a.decryptToken(jwt, domain, purpose)
 	if err != nil {
 		return "", err
 	}
 
+	if _, used := a.usedNonces.Get(nonce); used {
+		return "", errCodeReplayed
+	}
+
+	return code, nil
+}
+
+// decryptToken validates the token and returns its decrypted code and nonce
+func (a *Auth) decryptToken(jwt, domain, purpose string) (string, string, error) {
+	claims, err := a.parseJWTClaims(jwt)
+	if err != nil {
+		return "", "", err
+	}
+
+	// jwt.Parse only validates the iat and exp claims when they are present
+	now := a.now().Unix()
+	if !claims.VerifyIssuedAt(now, true) || !claims.VerifyExpiresAt(now, true) {
+		return "", "", errInvalidToken
+	}
+
+	if signedDomain, _ := claims["domain"].(string); signedDomain != domain {
+		return "", "", errInvalidDomain
+	}
+
+	signedPurpose, _ := claims["purpose"].(string)
+	if signedPurpose == "" {
+		// the codes signed by older versions have no purpose
+		signedPurpose = purposeCode
+	}
+
+	if signedPurpose != purpose {
+		return "", "", errInvalidPurpose
+	}
+
 	// get nonce and encryptedCode from the JWT claims
 	nonce, ok := claims["nonce"].(string)
 	if !ok {
-		return "", errInvalidNonce
+		return "", "", errInvalidNonce
 	}
 
 	encryptedCode, ok := claims["code"].(string)
 	if !ok {
-		return "", errInvalidCode
+		return "", "", errInvalidCode
 	}
 
 	cipherText, err := hex.DecodeString(encryptedCode)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
 	aesGcm, err := a.newAesGcmCipher(domain, nonce)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
 	decryptedCode, err := aesGcm.Open(nil, []byte(nonce), cipherText, nil)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	return string(decryptedCode), nil
+	return string(decryptedCode), nonce, nil
+}
+
+// ExportNonces returns the nonces of the codes already exchanged, to hand them
+// off to the next process
+func (a *Auth) ExportNonces() []handoff.Nonce {
+	var nonces []handoff.Nonce
+	for nonce, item := range a.usedNonces.Items() {
+		nonces = append(nonces, handoff.Nonce{Value: []byte(nonce), ExpiresAt: time.Unix(0, item.Expiration)})
+	}
+
+	return nonces
+}
+
+// ImportNonces remembers the nonces of the codes exchanged by the previous
+// process until their codes expire
+func (a *Auth) ImportNonces(nonces []handoff.Nonce) {
+	for _, nonce := range nonces {
+		if a.usedNonces.ItemCount() >= a.maxUsedNonces {
+			return
+		}
+
+		if expiration := time.Until(nonce.ExpiresAt); expiration > 0 {
+			a.usedNonces.Set(string(nonce.Value), struct{}{}, expiration)
+		}
+	}
 }
 
 func (a *Auth) codeKey(domain string) ([]byte, error) {
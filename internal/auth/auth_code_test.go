@@ -4,7 +4,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/handoff"
 )
 
 func TestEncryptAndDecryptSignedCode(t *testing.T) {
@@ -41,7 +44,7 @@ func TestEncryptAndDecryptSignedCode(t *testing.T) {
 			encDomain:         "domain",
 			decDomain:         "another",
 			code:              "code",
-			expectedDecErrMsg: "cipher: message authentication failed",
+			expectedDecErrMsg: "code was issued for another domain",
 		},
 		"expired_token": {
 			auth: func() *Auth {
@@ -97,3 +100,97 @@ func TestDecryptCodeWithInvalidJWT(t *testing.T) {
 	require.EqualError(t, err, "signature is invalid")
 	require.Empty(t, decCode)
 }
+
+func TestDecryptCodeReplayed(t *testing.T) {
+	auth := createTestAuth(t, "", "")
+
+	encCode, err := auth.EncryptAndSignCode("domain", "code")
+	require.NoError(t, err)
+
+	decCode, err := auth.DecryptCode(encCode, "domain")
+	require.NoError(t, err)
+	require.Equal(t, "code", decCode)
+
+	decCode, err = auth.DecryptCode(encCode, "domain")
+	require.EqualError(t, err, "code was already used")
+	require.Empty(t, decCode)
+}
+
+func TestDecryptCodeWithoutExpiry(t *testing.T) {
+	auth := createTestAuth(t, "", "")
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss":    "gitlab-pages",
+		"domain": "domain",
+		"code":   "code",
+		"nonce":  "nonce",
+	}).SignedString(auth.jwtSigningKey)
+	require.NoError(t, err)
+
+	decCode, err := auth.DecryptCode(token, "domain")
+	require.EqualError(t, err, "invalid token")
+	require.Empty(t, decCode)
+}
+
+func TestWithCodeExpiry(t *testing.T) {
+	auth, err := New("pages.gitlab-example.com", "something-very-secret", "id", "secret",
+		"http://pages.gitlab-example.com/auth", "", "", "scope", WithCodeExpiry(time.Second))
+	require.NoError(t, err)
+
+	now := time.Now()
+	auth.now = func() time.Time { return now }
+
+	encCode, err := auth.EncryptAndSignCode("domain", "code")
+	require.NoError(t, err)
+
+	claims, err := auth.parseJWTClaims(encCode)
+	require.NoError(t, err)
+	require.Equal(t, float64(now.Add(time.Second).Unix()), claims["exp"])
+}
+
+func TestHandoffNonces(t *testing.T) {
+	auth := createTestAuth(t, "", "")
+
+	encCode, err := auth.EncryptAndSignCode("domain", "code")
+	require.NoError(t, err)
+
+	_, err = auth.DecryptCode(encCode, "domain")
+	require.NoError(t, err)
+
+	nonces := auth.ExportNonces()
+	require.Len(t, nonces, 1)
+
+	next := createTestAuth(t, "", "")
+	next.ImportNonces(append(nonces, handoff.Nonce{Value: []byte("expired"), ExpiresAt: time.Now().Add(-time.Second)}))
+	require.Equal(t, 1, next.usedNonces.ItemCount(), "expired nonces are not imported")
+
+	_, err = next.DecryptCode(encCode, "domain")
+	require.EqualError(t, err, "code was already used", "codes can not be replayed against the next process")
+}
+
+func TestDecryptCodeMaxUsedNonces(t *testing.T) {
+	auth := createTestAuth(t, "", "")
+	auth.maxUsedNonces = 1
+
+	first, err := auth.EncryptAndSignCode("domain", "code")
+	require.NoError(t, err)
+	second, err := auth.EncryptAndSignCode("domain", "code")
+	require.NoError(t, err)
+
+	_, err = auth.DecryptCode(first, "domain")
+	require.NoError(t, err)
+
+	_, err = auth.DecryptCode(second, "domain")
+	require.ErrorIs(t, err, errTooManyCodes, "the codes are rejected while too many nonces are kept")
+
+	_, err = auth.DecryptCode(first, "domain")
+	require.Error(t, err, "the exchanged codes still can not be replayed")
+
+	next := createTestAuth(t, "", "")
+	next.maxUsedNonces = 1
+	next.ImportNonces([]handoff.Nonce{
+		{Value: []byte("first"), ExpiresAt: time.Now().Add(time.Minute)},
+		{Value: []byte("second"), ExpiresAt: time.Now().Add(time.Minute)},
+	})
+	require.Equal(t, 1, next.usedNonces.ItemCount())
+}
@@ -9,16 +9,38 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/gorilla/sessions"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/require"
 
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	domainCfg "gitlab.com/gitlab-org/gitlab-pages/internal/domain"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/mocks"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/request"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/security"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
+// requireFlowObserved checks that fn increments the auth flow metric of stage
+// and outcome exactly once
+func requireFlowObserved(t *testing.T, stage, outcome string, fn func()) {
+	t.Helper()
+
+	counter := metrics.AuthFlow.WithLabelValues(stage, outcome)
+	before := testutil.ToFloat64(counter)
+
+	fn()
+
+	require.Equal(t, before+1, testutil.ToFloat64(counter))
+}
+
 func createTestAuth(t *testing.T, internalServer string, publicServer string) *Auth {
 	t.Helper()
 
@@ -90,6 +112,37 @@ func TestTryAuthenticate(t *testing.T) {
 	require.False(t, auth.TryAuthenticate(result, r, mockSource))
 }
 
+func TestTryAuthenticateSkipsSessionOfPublicRequests(t *testing.T) {
+	auth := createTestAuth(t, "", "")
+
+	result := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "https://group.gitlab-example.com/index.html", nil)
+	// a session cookie which cannot be decoded
+	r.AddCookie(&http.Cookie{Name: "gitlab-pages", Value: "invalid"})
+
+	mockCtrl := gomock.NewController(t)
+
+	mockSource := mocks.NewMockSource(mockCtrl)
+	require.False(t, auth.TryAuthenticate(result, r, mockSource))
+	require.Equal(t, http.StatusOK, result.Code)
+	require.Empty(t, result.Header().Get("Set-Cookie"))
+}
+
+func BenchmarkTryAuthenticatePublicRequest(b *testing.B) {
+	auth, err := New("pages.gitlab-example.com", "something-very-secret", "id", "secret",
+		"http://pages.gitlab-example.com/auth", "", "", "scope")
+	require.NoError(b, err)
+
+	r := httptest.NewRequest(http.MethodGet, "https://group.gitlab-example.com/index.html", nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		auth.TryAuthenticate(httptest.NewRecorder(), r, nil)
+	}
+}
+
 func TestTryAuthenticateWithError(t *testing.T) {
 	auth := createTestAuth(t, "", "")
 
@@ -125,10 +178,36 @@ func TestTryAuthenticateWithCodeButInvalidState(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 
 	mockSource := mocks.NewMockSource(mockCtrl)
-	require.True(t, auth.TryAuthenticate(result, r, mockSource))
+	requireFlowObserved(t, flowStageStateValidation, flowOutcomeFailure, func() {
+		require.True(t, auth.TryAuthenticate(result, r, mockSource))
+	})
 	require.Equal(t, http.StatusUnauthorized, result.Code)
 }
 
+func TestTryAuthenticateWithTooLongParams(t *testing.T) {
+	auth := createTestAuth(t, "", "")
+
+	for _, param := range []string{"state", "code", "domain", "error"} {
+		t.Run(param, func(t *testing.T) {
+			result := httptest.NewRecorder()
+			reqURL, err := url.Parse("/auth")
+			require.NoError(t, err)
+
+			query := reqURL.Query()
+			query.Set(param, strings.Repeat("a", 5000))
+			reqURL.RawQuery = query.Encode()
+			reqURL.Scheme = request.SchemeHTTPS
+			r := &http.Request{URL: reqURL}
+
+			mockCtrl := gomock.NewController(t)
+
+			mockSource := mocks.NewMockSource(mockCtrl)
+			require.True(t, auth.TryAuthenticate(result, r, mockSource))
+			require.Equal(t, http.StatusUnauthorized, result.Code)
+		})
+	}
+}
+
 func TestTryAuthenticateRemoveTokenFromRedirect(t *testing.T) {
 	auth := createTestAuth(t, "", "")
 
@@ -152,6 +231,8 @@ func TestTryAuthenticateRemoveTokenFromRedirect(t *testing.T) {
 	mockSource := mocks.NewMockSource(mockCtrl)
 	require.True(t, auth.TryAuthenticate(result, r, mockSource))
 	require.Equal(t, http.StatusFound, result.Code)
+	require.Equal(t, "no-store", result.Header().Get("Cache-Control"), "caches never store the redirects of the authentication flow")
+	require.Equal(t, "Cookie", result.Header().Get("Vary"))
 
 	redirect, err := url.Parse(result.Header().Get("Location"))
 	require.NoError(t, err)
@@ -169,7 +250,9 @@ func TestTryAuthenticateWithDomainAndState(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 
 	mockSource := mocks.NewMockSource(mockCtrl)
-	require.True(t, auth.TryAuthenticate(result, r, mockSource))
+	requireFlowObserved(t, flowStageRedirect, flowOutcomeSuccess, func() {
+		require.True(t, auth.TryAuthenticate(result, r, mockSource))
+	})
 	require.Equal(t, http.StatusFound, result.Code)
 	redirect, err := url.Parse(result.Header().Get("Location"))
 	require.NoError(t, err)
@@ -229,7 +312,9 @@ func testTryAuthenticateWithCodeAndState(t *testing.T, https bool) {
 	mockCtrl := gomock.NewController(t)
 
 	mockSource := mocks.NewMockSource(mockCtrl)
-	require.True(t, auth.TryAuthenticate(result, r, mockSource))
+	requireFlowObserved(t, flowStageTokenFetch, flowOutcomeSuccess, func() {
+		require.True(t, auth.TryAuthenticate(result, r, mockSource))
+	})
 
 	res := result.Result()
 	defer res.Body.Close()
@@ -277,13 +362,196 @@ func TestCheckAuthenticationWhenAccess(t *testing.T) {
 
 	session.Values["access_token"] = "abc"
 	session.Save(r, result)
-	contentServed := auth.CheckAuthentication(result, r, &domainMock{projectID: 1000})
-	require.False(t, contentServed)
+	requireFlowObserved(t, flowStageAccess, flowOutcomeGranted, func() {
+		contentServed := auth.CheckAuthentication(result, r, &domainMock{projectID: 1000})
+		require.False(t, contentServed)
+	})
 
 	// notFoundContent wasn't served so the default response from CheckAuthentication should be 200
 	require.Equal(t, http.StatusOK, result.Code)
 }
 
+func TestDoAPIRequest(t *testing.T) {
+	tests := map[string]struct {
+		method           string
+		retries          int
+		timeout          time.Duration
+		handler          func(attempt int32, w http.ResponseWriter)
+		expectedStatus   int
+		expectedErr      bool
+		expectedAttempts int32
+	}{
+		"success": {
+			timeout: time.Second,
+			handler: func(_ int32, w http.ResponseWriter) {
+				w.WriteHeader(http.StatusOK)
+			},
+			expectedStatus:   http.StatusOK,
+			expectedAttempts: 1,
+		},
+		"retried_after_bad_gateway": {
+			retries: 2,
+			timeout: time.Second,
+			handler: func(attempt int32, w http.ResponseWriter) {
+				if attempt < 3 {
+					w.WriteHeader(http.StatusBadGateway)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			},
+			expectedStatus:   http.StatusOK,
+			expectedAttempts: 3,
+		},
+		"retries_exhausted": {
+			retries: 1,
+			timeout: time.Second,
+			handler: func(_ int32, w http.ResponseWriter) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+			expectedStatus:   http.StatusServiceUnavailable,
+			expectedAttempts: 2,
+		},
+		"not_retried_after_unauthorized": {
+			retries: 2,
+			timeout: time.Second,
+			handler: func(_ int32, w http.ResponseWriter) {
+				w.WriteHeader(http.StatusUnauthorized)
+			},
+			expectedStatus:   http.StatusUnauthorized,
+			expectedAttempts: 1,
+		},
+		"timeout": {
+			timeout: 10 * time.Millisecond,
+			handler: func(_ int32, w http.ResponseWriter) {
+				time.Sleep(100 * time.Millisecond)
+			},
+			expectedErr:      true,
+			expectedAttempts: 1,
+		},
+		"post_not_retried": {
+			method:  http.MethodPost,
+			retries: 2,
+			timeout: time.Second,
+			handler: func(_ int32, w http.ResponseWriter) {
+				w.WriteHeader(http.StatusBadGateway)
+			},
+			expectedStatus:   http.StatusBadGateway,
+			expectedAttempts: 1,
+		},
+		"post_not_retried_after_timeout": {
+			method:  http.MethodPost,
+			retries: 1,
+			timeout: 10 * time.Millisecond,
+			handler: func(_ int32, w http.ResponseWriter) {
+				time.Sleep(100 * time.Millisecond)
+			},
+			expectedErr:      true,
+			expectedAttempts: 1,
+		},
+		"retried_after_timeout": {
+			retries: 1,
+			timeout: 50 * time.Millisecond,
+			handler: func(attempt int32, w http.ResponseWriter) {
+				if attempt == 1 {
+					time.Sleep(200 * time.Millisecond)
+				}
+				w.WriteHeader(http.StatusOK)
+			},
+			expectedStatus:   http.StatusOK,
+			expectedAttempts: 2,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var attempts int32
+			apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tt.handler(atomic.AddInt32(&attempts, 1), w)
+			}))
+			defer apiServer.Close()
+
+			auth, err := New("pages.gitlab-example.com", "something-very-secret", "id", "secret",
+				"http://pages.gitlab-example.com/auth", apiServer.URL, "", "scope",
+				WithAccessCheckTimeout(tt.timeout), WithAPIRetries(tt.retries))
+			require.NoError(t, err)
+			auth.apiRetryBackoff = time.Millisecond
+
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+
+			before := observedAPICalls(t, apiEndpointUser)
+
+			resp, err := auth.doAPIRequest(context.Background(), apiEndpointUser, auth.accessCheckTimeout, func(ctx context.Context) (*http.Request, error) {
+				return http.NewRequestWithContext(ctx, method, apiServer.URL+"/api/v4/user", nil)
+			})
+			require.Equal(t, tt.expectedAttempts, atomic.LoadInt32(&attempts))
+			require.Equal(t, before+uint64(tt.expectedAttempts), observedAPICalls(t, apiEndpointUser))
+
+			if tt.expectedErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, tt.expectedStatus, resp.StatusCode)
+		})
+	}
+}
+
+func TestDoAPIRequestBackoff(t *testing.T) {
+	var attempts int32
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer apiServer.Close()
+
+	auth, err := New("pages.gitlab-example.com", "something-very-secret", "id", "secret",
+		"http://pages.gitlab-example.com/auth", apiServer.URL, "", "scope", WithAPIRetries(2))
+	require.NoError(t, err)
+	auth.apiRetryBackoff = 20 * time.Millisecond
+
+	newRequest := func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "GET", apiServer.URL+"/api/v4/user", nil)
+	}
+
+	start := time.Now()
+	resp, err := auth.doAPIRequest(context.Background(), apiEndpointUser, time.Second, newRequest)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(60*time.Millisecond), "waits 20ms and then 40ms")
+
+	// the wait is cut short when the request is canceled
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	auth.apiRetryBackoff = time.Minute
+	_, err = auth.doAPIRequest(ctx, apiEndpointUser, time.Second, newRequest)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, int32(4), atomic.LoadInt32(&attempts))
+}
+
+// observedAPICalls returns the number of calls to endpoint observed by
+// metrics.AuthAPICallDuration
+func observedAPICalls(t *testing.T, endpoint string) uint64 {
+	t.Helper()
+
+	var count uint64
+	for _, status := range []string{"error", "200", "401", "502", "503"} {
+		m := &dto.Metric{}
+		observer := metrics.AuthAPICallDuration.WithLabelValues(endpoint, status)
+		require.NoError(t, observer.(prometheus.Histogram).Write(m))
+
+		count += m.GetHistogram().GetSampleCount()
+	}
+
+	return count
+}
+
 func TestCheckAuthenticationWhenNoAccess(t *testing.T) {
 	apiServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
@@ -315,8 +583,10 @@ func TestCheckAuthenticationWhenNoAccess(t *testing.T) {
 	session.Values["access_token"] = "abc"
 	session.Save(r, w)
 
-	contentServed := auth.CheckAuthentication(w, r, &domainMock{projectID: 1000, notFoundContent: "Generic 404"})
-	require.True(t, contentServed)
+	requireFlowObserved(t, flowStageAccess, flowOutcomeDenied, func() {
+		contentServed := auth.CheckAuthentication(w, r, &domainMock{projectID: 1000, notFoundContent: "Generic 404"})
+		require.True(t, contentServed)
+	})
 	res := w.Result()
 	defer res.Body.Close()
 
@@ -358,8 +628,10 @@ func TestCheckAuthenticationWhenInvalidToken(t *testing.T) {
 	err = session.Save(r, result)
 	require.NoError(t, err)
 
-	contentServed := auth.CheckAuthentication(result, r, &domainMock{projectID: 1000})
-	require.True(t, contentServed)
+	requireFlowObserved(t, flowStageSessionDestroyed, flowOutcomeSuccess, func() {
+		contentServed := auth.CheckAuthentication(result, r, &domainMock{projectID: 1000})
+		require.True(t, contentServed)
+	})
 	require.Equal(t, http.StatusFound, result.Code)
 }
 
@@ -433,6 +705,156 @@ func TestCheckAuthenticationWithoutProjectWhenInvalidToken(t *testing.T) {
 	require.Equal(t, http.StatusFound, result.Code)
 }
 
+func TestSessionCookie(t *testing.T) {
+	tests := map[string]struct {
+		opts             []Option
+		url              string
+		embedding        bool
+		expectedName     string
+		expectedDomain   string
+		expectedSameSite http.SameSite
+	}{
+		"default": {
+			url:          "https://group.pages.gitlab-example.com/project/",
+			expectedName: "gitlab-pages",
+		},
+		"custom_name": {
+			opts:         []Option{WithCookieName("pages-session")},
+			url:          "https://group.pages.gitlab-example.com/project/",
+			expectedName: "pages-session",
+		},
+		"pages_domain_scope": {
+			opts:           []Option{WithCookieScope(config.AuthCookieScopePagesDomain)},
+			url:            "https://group.pages.gitlab-example.com/project/",
+			expectedName:   "gitlab-pages",
+			expectedDomain: "pages.gitlab-example.com",
+		},
+		"pages_domain_scope_custom_domain": {
+			opts:         []Option{WithCookieScope(config.AuthCookieScopePagesDomain)},
+			url:          "https://custom.example.com/",
+			expectedName: "gitlab-pages",
+		},
+		"host_prefix_scope": {
+			opts:         []Option{WithCookieScope(config.AuthCookieScopeHostPrefix)},
+			url:          "https://group.pages.gitlab-example.com/project/",
+			expectedName: "__Host-gitlab-pages",
+		},
+		"host_prefix_scope_over_http": {
+			opts:         []Option{WithCookieScope(config.AuthCookieScopeHostPrefix)},
+			url:          "http://group.pages.gitlab-example.com/project/",
+			expectedName: "gitlab-pages",
+		},
+		"embedding": {
+			url:              "https://custom.example.com/",
+			embedding:        true,
+			expectedName:     "gitlab-pages",
+			expectedSameSite: http.SameSiteNoneMode,
+		},
+		"embedding_over_http": {
+			url:          "http://custom.example.com/",
+			embedding:    true,
+			expectedName: "gitlab-pages",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			auth, err := New("pages.gitlab-example.com", "something-very-secret", "id", "secret",
+				"http://pages.gitlab-example.com/auth", "", "", "scope", tt.opts...)
+			require.NoError(t, err)
+
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.embedding {
+				r = embeddingRequest(r)
+			}
+
+			session, err := auth.getSessionFromStore(r)
+			require.NoError(t, err)
+
+			w := httptest.NewRecorder()
+			require.NoError(t, session.Save(r, w))
+
+			res := w.Result()
+			defer res.Body.Close()
+
+			cookies := res.Cookies()
+			require.Len(t, cookies, 1)
+			require.Equal(t, tt.expectedName, cookies[0].Name)
+			require.Equal(t, tt.expectedDomain, cookies[0].Domain)
+			require.Equal(t, "/", cookies[0].Path)
+			require.Equal(t, tt.expectedSameSite, cookies[0].SameSite)
+			require.Equal(t, r.URL.Scheme == request.SchemeHTTPS, cookies[0].Secure)
+		})
+	}
+}
+
+// embeddingRequest returns a copy of r for a domain embedded by tool.example.com
+func embeddingRequest(r *http.Request) *http.Request {
+	d := &domainCfg.Domain{
+		Name:            r.Host,
+		EmbeddingPolicy: &domainCfg.EmbeddingPolicy{FrameAncestors: []string{"https://tool.example.com"}},
+	}
+
+	return domainCfg.ReqWithHostAndDomain(r, r.Host, d)
+}
+
+func TestCheckAuthenticationInFrame(t *testing.T) {
+	tests := map[string]struct {
+		embedding        bool
+		fetchDest        string
+		expectedStatus   int
+		expectedLocation string
+	}{
+		"embedding_allowed": {
+			embedding:      true,
+			fetchDest:      "iframe",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"embedding_allowed_top_level": {
+			embedding:        true,
+			fetchDest:        "document",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "http://pages.gitlab-example.com/auth?domain=https://custom.example.com&state=",
+		},
+		"embedding_not_allowed": {
+			fetchDest:        "iframe",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "http://pages.gitlab-example.com/auth?domain=https://custom.example.com&state=",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			auth := createTestAuth(t, "", "")
+
+			r := httptest.NewRequest(http.MethodGet, "https://custom.example.com/page.html", nil)
+			r.RequestURI = "/page.html"
+			r.Header.Set("Sec-Fetch-Dest", tt.fetchDest)
+			if tt.embedding {
+				r = embeddingRequest(r)
+			}
+
+			result := httptest.NewRecorder()
+			require.True(t, auth.CheckAuthentication(result, r, &domainMock{projectID: 1000}))
+
+			res := result.Result()
+			defer res.Body.Close()
+
+			require.Equal(t, tt.expectedStatus, res.StatusCode)
+
+			if tt.expectedLocation != "" {
+				require.True(t, strings.HasPrefix(res.Header.Get("Location"), tt.expectedLocation))
+				return
+			}
+
+			body, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+			require.Contains(t, string(body), `<a href="https://custom.example.com/page.html" target="_top">Sign in</a>`)
+			require.Empty(t, res.Cookies(), "the flow starts over from the top-level window")
+		})
+	}
+}
+
 func TestGenerateKeys(t *testing.T) {
 	keys, err := generateKeys("something-very-secret", 3)
 	require.NoError(t, err)
@@ -563,3 +985,146 @@ func TestDomainAllowed(t *testing.T) {
 		})
 	}
 }
+
+func TestGetRequestAddressUsesCanonicalHost(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "https://pages.internal:8443/project/?q=1", nil)
+	r.RequestURI = "/project/?q=1"
+
+	require.Equal(t, "https://pages.internal:8443/project/?q=1", getRequestAddress(r))
+	require.Equal(t, "https://pages.internal:8443", getRequestDomain(r))
+
+	r = request.WithCanonicalHost(r, "group.example.com")
+
+	require.Equal(t, "https://group.example.com/project/?q=1", getRequestAddress(r))
+	require.Equal(t, "https://group.example.com", getRequestDomain(r))
+}
+
+func TestCheckAuthenticationThroughProxy(t *testing.T) {
+	var proxiedHost string
+
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// forward proxies receive the absolute URL of the requests
+		proxiedHost = r.URL.Host
+		require.Equal(t, "/api/v4/projects/1000/pages_access", r.URL.Path)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxyServer.Close()
+
+	proxyURL, err := url.Parse(proxyServer.URL)
+	require.NoError(t, err)
+
+	auth, err := New("pages.gitlab-example.com", "something-very-secret", "id", "secret",
+		"http://pages.gitlab-example.com/auth", "http://gitlab.example.internal", "", "scope",
+		WithProxy(http.ProxyURL(proxyURL)))
+	require.NoError(t, err)
+
+	proxied := testutil.ToFloat64(metrics.AuthProxyRequests.WithLabelValues("proxied"))
+
+	result := httptest.NewRecorder()
+	reqURL, err := url.Parse("/auth?code=1&state=state")
+	require.NoError(t, err)
+	reqURL.Scheme = request.SchemeHTTPS
+	r := &http.Request{URL: reqURL}
+
+	session, err := auth.store.Get(r, "gitlab-pages")
+	require.NoError(t, err)
+
+	session.Values["access_token"] = "abc"
+	session.Save(r, result)
+
+	contentServed := auth.CheckAuthentication(result, r, &domainMock{projectID: 1000})
+	require.False(t, contentServed)
+	require.Equal(t, "gitlab.example.internal", proxiedHost)
+	require.Equal(t, proxied+1, testutil.ToFloat64(metrics.AuthProxyRequests.WithLabelValues("proxied")))
+}
+
+func TestCheckAuthenticationRedirectLoop(t *testing.T) {
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer apiServer.Close()
+
+	auth := createTestAuth(t, apiServer.URL, "")
+
+	var cookies []*http.Cookie
+	newRequest := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "https://custom.example.com/page.html", nil)
+		r.RequestURI = "/page.html"
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+
+		return r
+	}
+
+	checkAuthentication := func() *http.Response {
+		t.Helper()
+
+		result := httptest.NewRecorder()
+		auth.CheckAuthentication(result, newRequest(), &domainMock{projectID: 1000})
+
+		res := result.Result()
+		t.Cleanup(func() { res.Body.Close() })
+		if len(res.Cookies()) > 0 {
+			cookies = res.Cookies()
+		}
+
+		return res
+	}
+
+	requireRedirects := func(redirects int) {
+		t.Helper()
+
+		res := checkAuthentication()
+		require.Equal(t, http.StatusFound, res.StatusCode)
+
+		location, err := url.Parse(res.Header.Get("Location"))
+		require.NoError(t, err)
+		require.Equal(t, redirects, stateRedirects(location.Query().Get("state")))
+	}
+
+	setAccessToken := func(token interface{}) {
+		t.Helper()
+
+		r := newRequest()
+		session, err := auth.store.Get(r, auth.sessionCookieName(r))
+		require.NoError(t, err)
+
+		session.Values["access_token"] = token
+		result := httptest.NewRecorder()
+		require.NoError(t, session.Save(r, result))
+		cookies = result.Result().Cookies()
+	}
+
+	for i := 0; i < maxAuthRedirects; i++ {
+		requireRedirects(i)
+	}
+
+	requireFlowObserved(t, flowStageRedirect, flowOutcomeLoop, func() {
+		res := checkAuthentication()
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.Contains(t, string(body), `<a href="https://custom.example.com/page.html" target="_top">Try again</a>`)
+	})
+
+	requireRedirects(0)
+	requireRedirects(1)
+
+	setAccessToken("abc")
+	require.Equal(t, http.StatusOK, checkAuthentication().StatusCode)
+
+	setAccessToken(nil)
+	requireRedirects(0)
+}
+
+func TestStateRedirects(t *testing.T) {
+	require.Equal(t, 0, stateRedirects(newState(0)))
+	require.Equal(t, 3, stateRedirects(newState(3)))
+	require.LessOrEqual(t, len(newState(maxAuthRedirects)), security.MaxStateLength)
+
+	require.Equal(t, 0, stateRedirects("state"))
+	require.Equal(t, 0, stateRedirects("state.-1"))
+	require.Equal(t, 0, stateRedirects("state.invalid"))
+}
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/httperrors"
+)
+
+// HandoffPath is the path issuing the handoff tokens, with POST requests
+const HandoffPath = "/auth/handoff"
+
+const (
+	handoffTokenParam  = "pages_token"
+	handoffTokenScheme = "Bearer "
+)
+
+type handoffResponse struct {
+	Token     string `json:"token"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
+// serveHandoffToken serves a signed token of the access token of the session,
+// for clients that can not follow the OAuth flow with cookies, like some
+// embedded webviews. They pass it in the Authorization header of their
+// requests to the same domain, or once in the pages_token query parameter.
+//
+// Tokens are only issued for same-origin POST requests, so other sites can
+// not have them issued with the cookies of the user.
+func (a *Auth) serveHandoffToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !isSameOriginRequest(r) {
+		logRequest(r).Warn("Cross-origin handoff token request")
+		observeFlow(flowStageHandoff, flowOutcomeFailure)
+
+		httperrors.Serve403(w)
+		return
+	}
+
+	session, err := a.checkSession(w, r)
+	if err != nil {
+		return
+	}
+
+	accessToken, _ := session.Values["access_token"].(string)
+	if accessToken == "" {
+		logRequest(r).Debug("No access token exists, can not issue a handoff token")
+		observeFlow(flowStageHandoff, flowOutcomeFailure)
+
+		httperrors.Serve401(w)
+		return
+	}
+
+	token, err := a.encryptAndSign(getRequestDomain(r), accessToken, purposeHandoff)
+	if err != nil {
+		logRequest(r).WithError(err).Error("failed to sign the handoff token")
+		captureErrWithReqAndStackTrace(err, r)
+		observeFlow(flowStageHandoff, flowOutcomeFailure)
+
+		httperrors.Serve500(w)
+		return
+	}
+
+	observeFlow(flowStageHandoff, flowOutcomeSuccess)
+
+	httperrors.DisableCaching(w)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(handoffResponse{
+		Token:     token,
+		ExpiresIn: int(a.jwtExpiry / time.Second),
+	})
+}
+
+// isSameOriginRequest returns false when the Origin or Sec-Fetch-Site headers
+// of r show that it was sent by another site
+func isSameOriginRequest(r *http.Request) bool {
+	if origin := r.Header.Get("Origin"); origin != "" && origin != getRequestDomain(r) {
+		return false
+	}
+
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+		return true
+	}
+
+	return false
+}
+
+// authorizeHandoffToken authenticates r with the handoff token of its
+// Authorization header or pages_token query parameter. The token is only valid
+// for the domain it was issued for and until it expires.
+//
+// The token of the header authenticates r alone, so clients without cookies
+// pass it with each request. The token of the query parameter is only valid
+// once: it is exchanged for a session and r is redirected to its URL without
+// the parameter, so the token does not stay in the history and logs.
+//
+// It returns true when it served a response.
+func (a *Auth) authorizeHandoffToken(session *sessions.Session, w http.ResponseWriter, r *http.Request) bool {
+	if !a.queryToken {
+		return false
+	}
+
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, handoffTokenScheme) {
+		accessToken, err := a.decryptReusable(strings.TrimPrefix(header, handoffTokenScheme), getRequestDomain(r), purposeHandoff)
+		if err != nil {
+			logRequest(r).WithError(err).Warn("Invalid handoff token")
+			observeFlow(flowStageHandoff, flowOutcomeFailure)
+
+			httperrors.Serve401(w)
+			return true
+		}
+
+		// the session is not saved, the token only authenticates r
+		session.Values["access_token"] = accessToken
+
+		// the response is authenticated by a header, so shared caches must
+		// not store it
+		httperrors.DisableCaching(w)
+
+		return false
+	}
+
+	token := r.URL.Query().Get(handoffTokenParam)
+	if token == "" {
+		return false
+	}
+
+	accessToken, err := a.decrypt(token, getRequestDomain(r), purposeHandoff)
+	if err != nil {
+		logRequest(r).WithError(err).Warn("Invalid handoff token")
+		observeFlow(flowStageHandoff, flowOutcomeFailure)
+
+		httperrors.Serve401(w)
+		return true
+	}
+
+	session.Values["access_token"] = accessToken
+
+	if err := session.Save(r, w); err != nil {
+		logRequest(r).WithError(err).Error(saveSessionErrMsg)
+		captureErrWithReqAndStackTrace(err, r)
+		observeFlow(flowStageHandoff, flowOutcomeFailure)
+
+		httperrors.Serve500(w)
+		return true
+	}
+
+	observeFlow(flowStageHandoff, flowOutcomeSuccess)
+
+	redirect(w, r, addressWithoutHandoffToken(r))
+
+	return true
+}
+
+// addressWithoutHandoffToken returns the address of r without the pages_token
+// query parameter
+func addressWithoutHandoffToken(r *http.Request) string {
+	u := *r.URL
+
+	query := u.Query()
+	query.Del(handoffTokenParam)
+	u.RawQuery = query.Encode()
+
+	return getRequestDomain(r) + u.RequestURI()
+}
@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeHandoffToken(t *testing.T) {
+	tests := map[string]struct {
+		queryToken     bool
+		method         string
+		header         http.Header
+		accessToken    string
+		expectedServed bool
+		expectedStatus int
+	}{
+		"disabled": {
+			method:      http.MethodPost,
+			accessToken: "abc",
+		},
+		"with_session": {
+			queryToken:     true,
+			method:         http.MethodPost,
+			accessToken:    "abc",
+			expectedServed: true,
+			expectedStatus: http.StatusOK,
+		},
+		"same_origin": {
+			queryToken:     true,
+			method:         http.MethodPost,
+			header:         http.Header{"Origin": []string{"https://custom.example.com"}, "Sec-Fetch-Site": []string{"same-origin"}},
+			accessToken:    "abc",
+			expectedServed: true,
+			expectedStatus: http.StatusOK,
+		},
+		"without_session": {
+			queryToken:     true,
+			method:         http.MethodPost,
+			expectedServed: true,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"get": {
+			queryToken:     true,
+			method:         http.MethodGet,
+			accessToken:    "abc",
+			expectedServed: true,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		"cross_origin": {
+			queryToken:     true,
+			method:         http.MethodPost,
+			header:         http.Header{"Origin": []string{"https://evil.example.com"}},
+			accessToken:    "abc",
+			expectedServed: true,
+			expectedStatus: http.StatusForbidden,
+		},
+		"cross_site": {
+			queryToken:     true,
+			method:         http.MethodPost,
+			header:         http.Header{"Sec-Fetch-Site": []string{"cross-site"}},
+			accessToken:    "abc",
+			expectedServed: true,
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			auth := createTestAuth(t, "", "")
+			auth.queryToken = tt.queryToken
+
+			r := httptest.NewRequest(tt.method, "https://custom.example.com/auth/handoff", nil)
+			for name, values := range tt.header {
+				r.Header[name] = values
+			}
+			if tt.accessToken != "" {
+				addSessionCookie(t, auth, r, tt.accessToken)
+			}
+
+			result := httptest.NewRecorder()
+			require.Equal(t, tt.expectedServed, auth.TryAuthenticate(result, r, nil))
+			if !tt.expectedServed {
+				return
+			}
+
+			require.Equal(t, tt.expectedStatus, result.Code)
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			require.Equal(t, "application/json", result.Header().Get("Content-Type"))
+			require.Contains(t, result.Header().Get("Cache-Control"), "no-store")
+
+			var response handoffResponse
+			require.NoError(t, json.NewDecoder(result.Body).Decode(&response))
+			require.Equal(t, 60, response.ExpiresIn)
+
+			accessToken, err := auth.decrypt(response.Token, "https://custom.example.com", purposeHandoff)
+			require.NoError(t, err)
+			require.Equal(t, tt.accessToken, accessToken)
+		})
+	}
+}
+
+func TestCheckAuthenticationWithHandoffToken(t *testing.T) {
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v4/projects/1000/pages_access" && r.Header.Get("Authorization") == "Bearer abc" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer apiServer.Close()
+
+	auth := createTestAuth(t, apiServer.URL, "")
+	auth.queryToken = true
+
+	token, err := auth.encryptAndSign("https://custom.example.com", "abc", purposeHandoff)
+	require.NoError(t, err)
+
+	code, err := auth.EncryptAndSignCode("https://custom.example.com", "abc")
+	require.NoError(t, err)
+
+	otherDomain, err := auth.encryptAndSign("https://other.example.com", "abc", purposeHandoff)
+	require.NoError(t, err)
+
+	checkAuthentication := func(token string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodGet, "https://custom.example.com/page.html?a=b&"+handoffTokenParam+"="+token, nil)
+		r.RequestURI = "/page.html"
+
+		result := httptest.NewRecorder()
+		auth.CheckAuthentication(result, r, &domainMock{projectID: 1000})
+
+		return result
+	}
+
+	requireFlowObserved(t, flowStageHandoff, flowOutcomeSuccess, func() {
+		result := checkAuthentication(token)
+		require.Equal(t, http.StatusFound, result.Code)
+		require.Equal(t, "https://custom.example.com/page.html?a=b", result.Header().Get("Location"), "the token is removed from the URL")
+		require.NotEmpty(t, result.Result().Cookies(), "the session is stored")
+		require.Contains(t, result.Header().Get("Cache-Control"), "no-store")
+	})
+
+	require.Equal(t, http.StatusUnauthorized, checkAuthentication(token).Code, "the token can only be used once")
+	require.Equal(t, http.StatusUnauthorized, checkAuthentication(code).Code, "OAuth codes are not handoff tokens")
+	require.Equal(t, http.StatusUnauthorized, checkAuthentication(otherDomain).Code)
+
+	auth.queryToken = false
+	token, err = auth.encryptAndSign("https://custom.example.com", "abc", purposeHandoff)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusFound, checkAuthentication(token).Code, "the token is ignored when disabled")
+	require.Contains(t, checkAuthentication(token).Header().Get("Location"), "/auth?domain=", "the user signs in with OAuth")
+}
+
+func TestCheckAuthenticationWithHandoffTokenHeader(t *testing.T) {
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v4/projects/1000/pages_access" && r.Header.Get("Authorization") == "Bearer abc" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer apiServer.Close()
+
+	auth := createTestAuth(t, apiServer.URL, "")
+	auth.queryToken = true
+
+	checkAuthentication := func(token string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodGet, "https://custom.example.com/page.html", nil)
+		r.RequestURI = "/page.html"
+		r.Header.Set("Authorization", "Bearer "+token)
+
+		result := httptest.NewRecorder()
+		auth.CheckAuthentication(result, r, &domainMock{projectID: 1000})
+
+		return result
+	}
+
+	token, err := auth.encryptAndSign("https://custom.example.com", "abc", purposeHandoff)
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		result := checkAuthentication(token)
+		require.Equal(t, http.StatusOK, result.Code, "the token authenticates each request")
+		require.Empty(t, result.Result().Cookies(), "no session is stored")
+		require.Contains(t, result.Header().Get("Cache-Control"), "no-store")
+	}
+
+	otherDomain, err := auth.encryptAndSign("https://other.example.com", "abc", purposeHandoff)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, checkAuthentication(otherDomain).Code)
+
+	_, err = auth.decrypt(token, "https://custom.example.com", purposeHandoff)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, checkAuthentication(token).Code, "the token exchanged in a query is not valid anymore")
+}
+
+func addSessionCookie(t *testing.T, auth *Auth, r *http.Request, accessToken string) {
+	t.Helper()
+
+	session, err := auth.getSessionFromStore(r)
+	require.NoError(t, err)
+
+	session.Values["access_token"] = accessToken
+
+	w := httptest.NewRecorder()
+	require.NoError(t, session.Save(r, w))
+
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+}
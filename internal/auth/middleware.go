@@ -23,6 +23,11 @@ func (a *Auth) AuthorizationMiddleware(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		domain := domainCfg.FromRequest(r)
 
+		// Restrict the sites allowed to embed domains which opted in
+		if domain != nil && domain.EmbeddingPolicy != nil {
+			w.Header().Add("Content-Security-Policy", domain.EmbeddingPolicy.ContentSecurityPolicy())
+		}
+
 		// Only for projects that have access control enabled
 		if domain.IsAccessControlEnabled(r) {
 			// accessControlMiddleware
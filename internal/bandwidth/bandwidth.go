@@ -0,0 +1,206 @@
+// Package bandwidth throttles the responses to the bytes per second allowed
+// for their connection and their domain, so the large downloads of a project
+// can not saturate the egress of a Pages node shared with other projects
+package bandwidth
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/lru"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+const (
+	// DefaultDomainCacheSize is the number of domains whose bandwidth is
+	// tracked, the least recently served ones start over
+	DefaultDomainCacheSize = 4000
+
+	// minBurst is the minimum number of bytes written at once, so the
+	// limits are not enforced on small responses
+	minBurst = 32 * 1024
+)
+
+// names of the limits reported by metrics.BandwidthThrottledBytes
+const (
+	limitConnection = "connection"
+	limitDomain     = "domain"
+)
+
+type connectionKey struct{}
+
+// Limiter limits the bandwidth of the connections and domains, a nil Limiter
+// does not limit it
+type Limiter struct {
+	connectionLimit rate.Limit
+	domainLimit     rate.Limit
+	domains         *lru.Cache
+}
+
+// New returns a Limiter of connectionLimit bytes per second for each
+// connection and domainLimit bytes per second for each domain, 0 does not
+// limit them. It returns nil when neither are limited.
+func New(connectionLimit, domainLimit int64) *Limiter {
+	if connectionLimit <= 0 && domainLimit <= 0 {
+		return nil
+	}
+
+	l := &Limiter{
+		connectionLimit: rate.Limit(connectionLimit),
+		domainLimit:     rate.Limit(domainLimit),
+	}
+
+	if domainLimit > 0 {
+		l.domains = lru.New("bandwidth_domain", lru.WithMaxSize(DefaultDomainCacheSize))
+	}
+
+	return l
+}
+
+// ConnContext returns ctx with the limiter of the connection, it is the
+// http.Server.ConnContext of the listeners. The streams of HTTP/2 connections
+// share their limit.
+func (l *Limiter) ConnContext(ctx context.Context, _ net.Conn) context.Context {
+	if l == nil || l.connectionLimit <= 0 {
+		return ctx
+	}
+
+	return context.WithValue(ctx, connectionKey{}, newLimiter(l.connectionLimit))
+}
+
+// NewMiddleware throttles the responses of handler to the limits of their
+// connection and of the domain resolved by the routing middleware. The
+// requests to hosts which are not served only have the limit of their
+// connection, so they can not fill the domains cache.
+func NewMiddleware(handler http.Handler, l *Limiter) http.Handler {
+	if l == nil {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limiters := l.limiters(r)
+		if len(limiters) == 0 {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		handler.ServeHTTP(&throttledWriter{ResponseWriter: w, ctx: r.Context(), limiters: limiters}, r)
+	})
+}
+
+func (l *Limiter) limiters(r *http.Request) []namedLimiter {
+	var limiters []namedLimiter
+
+	if limiter, ok := r.Context().Value(connectionKey{}).(*rate.Limiter); ok {
+		limiters = append(limiters, namedLimiter{limitConnection, limiter})
+	}
+
+	if d := domain.FromRequest(r); l.domains != nil && d != nil && d.Name != "" {
+		limiter, _ := l.domains.FindOrFetch(d.Name, d.Name, func() (interface{}, error) {
+			return newLimiter(l.domainLimit), nil
+		})
+
+		limiters = append(limiters, namedLimiter{limitDomain, limiter.(*rate.Limiter)})
+	}
+
+	return limiters
+}
+
+// newLimiter returns a limiter of limit bytes per second, which allows
+// writing a second worth of bytes at once
+func newLimiter(limit rate.Limit) *rate.Limiter {
+	burst := int(limit)
+	if burst < minBurst {
+		burst = minBurst
+	}
+
+	return rate.NewLimiter(limit, burst)
+}
+
+type namedLimiter struct {
+	name string
+	*rate.Limiter
+}
+
+// throttledWriter writes the response in chunks allowed by all its limiters
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx      context.Context
+	limiters []namedLimiter
+}
+
+func (w *throttledWriter) Write(data []byte) (int, error) {
+	written := 0
+
+	for written < len(data) {
+		chunk := len(data) - written
+		for _, limiter := range w.limiters {
+			if burst := limiter.Burst(); chunk > burst {
+				chunk = burst
+			}
+		}
+
+		if err := w.wait(chunk); err != nil {
+			return written, err
+		}
+
+		n, err := w.ResponseWriter.Write(data[written : written+chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// wait waits until all the limiters allow writing n bytes, or until the
+// request is canceled
+func (w *throttledWriter) wait(n int) error {
+	now := time.Now()
+
+	var delay time.Duration
+	var throttledBy string
+	reservations := make([]*rate.Reservation, 0, len(w.limiters))
+
+	for _, limiter := range w.limiters {
+		reservation := limiter.ReserveN(now, n)
+		reservations = append(reservations, reservation)
+
+		if d := reservation.DelayFrom(now); d > delay {
+			delay = d
+			throttledBy = limiter.name
+		}
+	}
+
+	if delay == 0 {
+		return nil
+	}
+
+	metrics.BandwidthThrottledBytes.WithLabelValues(throttledBy).Add(float64(n))
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-w.ctx.Done():
+		// the bytes which were not written are given back
+		for _, reservation := range reservations {
+			reservation.Cancel()
+		}
+
+		return w.ctx.Err()
+	}
+}
+
+// Unwrap returns the http.ResponseWriter the throttled chunks are written to
+func (w *throttledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
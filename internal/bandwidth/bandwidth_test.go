@@ -0,0 +1,134 @@
+package bandwidth
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+func serve(t *testing.T, handler http.Handler, ctx context.Context, host string) (*httptest.ResponseRecorder, time.Duration) {
+	t.Helper()
+
+	return serveDomain(t, handler, ctx, host, &domain.Domain{Name: strings.ToLower(host)})
+}
+
+// serveDomain serves a request to host routed to d, a nil d is an unknown
+// host
+func serveDomain(t *testing.T, handler http.Handler, ctx context.Context, host string, d *domain.Domain) (*httptest.ResponseRecorder, time.Duration) {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, "http://"+host+"/", nil).WithContext(ctx)
+	if d != nil {
+		r = domain.ReqWithHostAndDomain(r, host, d)
+	}
+	w := httptest.NewRecorder()
+
+	start := time.Now()
+	handler.ServeHTTP(w, r)
+
+	return w, time.Since(start)
+}
+
+func contentHandler(size int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("a"), size))
+	})
+}
+
+func TestNewDisabled(t *testing.T) {
+	require.Nil(t, New(0, 0))
+
+	var l *Limiter
+	ctx := context.Background()
+	require.Equal(t, ctx, l.ConnContext(ctx, nil))
+
+	handler := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.IsType(t, &httptest.ResponseRecorder{}, w, "responses are not throttled")
+	}), l)
+	serve(t, handler, ctx, "group.gitlab.io")
+}
+
+func TestMiddlewareConnectionLimit(t *testing.T) {
+	l := New(minBurst*10, 0)
+	handler := NewMiddleware(contentHandler(minBurst*6), l)
+
+	before := testutil.ToFloat64(metrics.BandwidthThrottledBytes.WithLabelValues(limitConnection))
+
+	ctx := l.ConnContext(context.Background(), nil)
+	w, elapsed := serve(t, handler, ctx, "group.gitlab.io")
+	require.Equal(t, minBurst*6, w.Body.Len())
+	require.Less(t, elapsed, 100*time.Millisecond)
+
+	_, elapsed = serve(t, handler, ctx, "other.gitlab.io")
+	require.GreaterOrEqual(t, elapsed, 150*time.Millisecond, "the requests of a connection share its limit")
+	require.Equal(t, before+minBurst*6, testutil.ToFloat64(metrics.BandwidthThrottledBytes.WithLabelValues(limitConnection)))
+
+	_, elapsed = serve(t, handler, l.ConnContext(context.Background(), nil), "group.gitlab.io")
+	require.Less(t, elapsed, 100*time.Millisecond, "other connections have their own limit")
+}
+
+func TestMiddlewareDomainLimit(t *testing.T) {
+	l := New(0, minBurst*10)
+	handler := NewMiddleware(contentHandler(minBurst*6), l)
+
+	_, elapsed := serve(t, handler, context.Background(), "group.gitlab.io")
+	require.Less(t, elapsed, 100*time.Millisecond)
+
+	_, elapsed = serve(t, handler, context.Background(), "Group.gitlab.io")
+	require.GreaterOrEqual(t, elapsed, 150*time.Millisecond, "the requests to a domain share its limit")
+
+	_, elapsed = serve(t, handler, context.Background(), "other.gitlab.io")
+	require.Less(t, elapsed, 100*time.Millisecond, "other domains have their own limit")
+}
+
+func TestMiddlewareDomainLimitUnknownHost(t *testing.T) {
+	l := New(0, minBurst*10)
+	handler := NewMiddleware(contentHandler(minBurst*6), l)
+
+	for i := 0; i < 2; i++ {
+		w, elapsed := serveDomain(t, handler, context.Background(), "unknown.gitlab.io", nil)
+		require.Equal(t, minBurst*6, w.Body.Len())
+		require.Less(t, elapsed, 100*time.Millisecond, "unknown hosts are not limited")
+	}
+
+	fetched := false
+	l.domains.FindOrFetch("unknown.gitlab.io", "unknown.gitlab.io", func() (interface{}, error) {
+		fetched = true
+		return nil, nil
+	})
+	require.True(t, fetched, "unknown hosts are not cached")
+}
+
+func TestMiddlewareCanceledRequest(t *testing.T) {
+	l := New(minBurst, 0)
+
+	var err error
+	handler := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err = w.Write(bytes.Repeat([]byte("a"), minBurst*10))
+	}), l)
+
+	ctx, cancel := context.WithTimeout(l.ConnContext(context.Background(), nil), 100*time.Millisecond)
+	defer cancel()
+
+	w, elapsed := serve(t, handler, ctx, "group.gitlab.io")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, minBurst, w.Body.Len(), "only the burst is written")
+	require.Less(t, elapsed, time.Second)
+}
+
+func TestThrottledWriterUnwrap(t *testing.T) {
+	w := httptest.NewRecorder()
+	tw := &throttledWriter{ResponseWriter: w}
+
+	require.Same(t, w, tw.Unwrap())
+}
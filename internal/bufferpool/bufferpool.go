@@ -0,0 +1,41 @@
+// Package bufferpool reuses the buffers copying responses to clients.
+// Middleware wraps the http.ResponseWriter, hiding its io.ReaderFrom, so
+// io.Copy would otherwise allocate a new 32KB buffer for every response.
+package bufferpool
+
+import (
+	"io"
+	"sync"
+)
+
+// Size is the size of the pooled buffers, the same as io.Copy uses
+const Size = 32 * 1024
+
+var pool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, Size)
+		return &buf
+	},
+}
+
+// Copy copies from src to dst like io.Copy, using a pooled buffer
+func Copy(dst io.Writer, src io.Reader) (int64, error) {
+	buf := pool.Get().(*[]byte)
+	defer pool.Put(buf)
+
+	return io.CopyBuffer(dst, src, *buf)
+}
+
+// CopyN copies n bytes from src to dst like io.CopyN, using a pooled buffer
+func CopyN(dst io.Writer, src io.Reader, n int64) (int64, error) {
+	written, err := Copy(dst, io.LimitReader(src, n))
+	if written == n {
+		return n, nil
+	}
+	if written < n && err == nil {
+		// src stopped early; must have been EOF
+		err = io.EOF
+	}
+
+	return written, err
+}
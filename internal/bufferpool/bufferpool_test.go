@@ -0,0 +1,81 @@
+package bufferpool
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writer hides the io.ReaderFrom of bytes.Buffer, like the middleware
+// wrapping http.ResponseWriter
+type writer struct {
+	io.Writer
+}
+
+// reader hides the io.WriterTo of bytes.Reader, like the files of archives
+type reader struct {
+	io.Reader
+}
+
+func TestCopy(t *testing.T) {
+	content := strings.Repeat("content", Size)
+
+	var buf bytes.Buffer
+	n, err := Copy(writer{&buf}, strings.NewReader(content))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(content)), n)
+	require.Equal(t, content, buf.String())
+}
+
+func TestCopyN(t *testing.T) {
+	tests := map[string]struct {
+		n           int64
+		expected    string
+		expectedErr error
+	}{
+		"part": {
+			n:        4,
+			expected: "cont",
+		},
+		"all": {
+			n:        7,
+			expected: "content",
+		},
+		"more_than_available": {
+			n:           10,
+			expected:    "content",
+			expectedErr: io.EOF,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			n, err := CopyN(writer{&buf}, strings.NewReader("content"), tt.n)
+			require.Equal(t, tt.expectedErr, err)
+			require.Equal(t, int64(len(tt.expected)), n)
+			require.Equal(t, tt.expected, buf.String())
+		})
+	}
+}
+
+func BenchmarkCopy(b *testing.B) {
+	content := []byte(strings.Repeat("content", 1024))
+
+	b.Run("io.Copy", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			io.Copy(writer{io.Discard}, reader{bytes.NewReader(content)})
+		}
+	})
+
+	b.Run("bufferpool.Copy", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			Copy(writer{io.Discard}, reader{bytes.NewReader(content)})
+		}
+	})
+}
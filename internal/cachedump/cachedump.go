@@ -0,0 +1,184 @@
+// Package cachedump serves the contents of the domains and archives caches
+// as JSON, to debug why a new deployment is not served yet
+package cachedump
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/security"
+)
+
+// Dump is the content of the caches
+type Dump struct {
+	Domains  []Domain  `json:"domains"`
+	Archives []Archive `json:"archives"`
+}
+
+// Domain is a cached domain configuration
+type Domain struct {
+	Name string `json:"name"`
+	// Aliases are the cached hostnames sharing the entry of the domain
+	Aliases []string `json:"aliases,omitempty"`
+
+	Created   time.Time `json:"created"`
+	RefreshAt time.Time `json:"refresh_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Retrieved is false while the configuration is retrieved from GitLab
+	Retrieved bool `json:"retrieved"`
+	Exists    bool `json:"exists"`
+	// Deleted is true when GitLab reported that the domain was deleted
+	Deleted bool   `json:"deleted,omitempty"`
+	Error   string `json:"error,omitempty"`
+
+	Certificate *Certificate `json:"certificate,omitempty"`
+	LookupPaths []LookupPath `json:"lookup_paths,omitempty"`
+}
+
+// Certificate identifies the custom certificate of a domain
+type Certificate struct {
+	// SHA256Fingerprint is the hex encoded SHA-256 hash of the leaf certificate
+	SHA256Fingerprint string    `json:"sha256_fingerprint,omitempty"`
+	NotAfter          time.Time `json:"not_after"`
+	Error             string    `json:"error,omitempty"`
+}
+
+// LookupPath is a project served by a domain. The path of the source is left
+// out as it can be a signed object storage URL.
+type LookupPath struct {
+	Prefix     string `json:"prefix"`
+	ProjectID  int    `json:"project_id"`
+	SourceType string `json:"source_type"`
+	// ArchiveKey is the key of the archive of the deployment in the archives
+	// cache
+	ArchiveKey string    `json:"archive_key,omitempty"`
+	DeployedAt time.Time `json:"deployed_at"`
+}
+
+// Archive is an archive cached by the zip VFS
+type Archive struct {
+	Key       string    `json:"key"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Status    string    `json:"status"`
+	Entries   int       `json:"entries"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// DomainsDumper is implemented by the domains sources caching domains, match
+// returns true for the hostnames of the domains to dump
+type DomainsDumper interface {
+	DumpDomains(match func(name string) bool) []Domain
+}
+
+// ArchivesDumper is implemented by the serving drivers caching archives
+type ArchivesDumper interface {
+	DumpArchives() []Archive
+}
+
+// Dumper serves the contents of the caches
+type Dumper struct {
+	domains  DomainsDumper
+	archives ArchivesDumper
+	secret   []byte
+}
+
+// New returns a Dumper of the caches of domains and archives, any of which
+// can be nil. Requests must be authenticated with a JWT token signed with
+// secret.
+func New(domains DomainsDumper, archives ArchivesDumper, secret []byte) *Dumper {
+	return &Dumper{
+		domains:  domains,
+		archives: archives,
+		secret:   secret,
+	}
+}
+
+// NewMiddleware returns middleware which serves the dump of the caches on
+// path. It returns handler when path is empty.
+func NewMiddleware(handler http.Handler, path string, d *Dumper) http.Handler {
+	if path == "" {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		d.ServeHTTP(w, r)
+	})
+}
+
+// ServeHTTP authenticates the request and writes the JSON dump. The `host`
+// query parameter filters the domains with a glob pattern, e.g.
+// `*.example.com`, and the archives to the ones of the matching domains.
+func (d *Dumper) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := security.VerifyAPIToken(r.Header.Get(security.APIRequestHeader), d.secret); err != nil {
+		log.WithError(err).Warn("unauthorized cache dump request")
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	pattern := strings.ToLower(r.URL.Query().Get("host"))
+	if _, err := path.Match(pattern, ""); err != nil || len(pattern) > security.MaxDomainLength {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "the host parameter must be a valid glob pattern"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, d.Dump(pattern))
+}
+
+// Dump returns the contents of the caches, filtered by the glob pattern
+// matching hostnames when it is not empty
+func (d *Dumper) Dump(pattern string) *Dump {
+	dump := &Dump{Domains: []Domain{}, Archives: []Archive{}}
+
+	match := func(name string) bool {
+		matched, _ := path.Match(pattern, name)
+		return pattern == "" || matched
+	}
+
+	if d.domains != nil {
+		dump.Domains = append(dump.Domains, d.domains.DumpDomains(match)...)
+	}
+
+	if d.archives != nil {
+		keys := archiveKeys(dump.Domains)
+		for _, archive := range d.archives.DumpArchives() {
+			if pattern == "" || keys[archive.Key] {
+				dump.Archives = append(dump.Archives, archive)
+			}
+		}
+	}
+
+	return dump
+}
+
+func archiveKeys(domains []Domain) map[string]bool {
+	keys := make(map[string]bool)
+	for _, domain := range domains {
+		for _, lp := range domain.LookupPaths {
+			if lp.ArchiveKey != "" {
+				keys[lp.ArchiveKey] = true
+			}
+		}
+	}
+
+	return keys
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.WithError(err).Error("failed to write cache dump response")
+	}
+}
@@ -0,0 +1,155 @@
+package cachedump_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/cachedump"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/security"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/testhelpers"
+)
+
+type stubDomains []cachedump.Domain
+
+func (s stubDomains) DumpDomains(match func(name string) bool) []cachedump.Domain {
+	var domains []cachedump.Domain
+	for _, d := range s {
+		if match(d.Name) {
+			domains = append(domains, d)
+		}
+	}
+
+	return domains
+}
+
+type stubArchives []cachedump.Archive
+
+func (s stubArchives) DumpArchives() []cachedump.Archive {
+	return s
+}
+
+func newTestDumper() *cachedump.Dumper {
+	domains := stubDomains{
+		{Name: "group.example.com", LookupPaths: []cachedump.LookupPath{{Prefix: "/project/", ArchiveKey: "sha-1"}}},
+		{Name: "custom.com", LookupPaths: []cachedump.LookupPath{{Prefix: "/", ArchiveKey: "sha-2"}}},
+	}
+	archives := stubArchives{
+		{Key: "sha-1", Status: "opened"},
+		{Key: "sha-2", Status: "opening"},
+	}
+
+	return cachedump.New(domains, archives, []byte("secret"))
+}
+
+func TestNewMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := cachedump.NewMiddleware(next, "/@cache", newTestDumper())
+
+	tests := map[string]struct {
+		url              string
+		token            string
+		expectedStatus   int
+		expectedDomains  []string
+		expectedArchives []string
+	}{
+		"other_path": {
+			url:            "/index.html",
+			expectedStatus: http.StatusTeapot,
+		},
+		"missing_token": {
+			url:            "/@cache",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"wrong_secret": {
+			url:            "/@cache",
+			token:          testhelpers.SignAPIToken(t, []byte("other"), jwt.NewNumericDate(time.Now().Add(time.Minute))),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"invalid_pattern": {
+			url:            "/@cache?host=%5B",
+			token:          testhelpers.SignAPIToken(t, []byte("secret"), jwt.NewNumericDate(time.Now().Add(time.Minute))),
+			expectedStatus: http.StatusBadRequest,
+		},
+		"all": {
+			url:              "/@cache",
+			token:            testhelpers.SignAPIToken(t, []byte("secret"), jwt.NewNumericDate(time.Now().Add(time.Minute))),
+			expectedStatus:   http.StatusOK,
+			expectedDomains:  []string{"group.example.com", "custom.com"},
+			expectedArchives: []string{"sha-1", "sha-2"},
+		},
+		"filtered": {
+			url:              "/@cache?host=*.Example.com",
+			token:            testhelpers.SignAPIToken(t, []byte("secret"), jwt.NewNumericDate(time.Now().Add(time.Minute))),
+			expectedStatus:   http.StatusOK,
+			expectedDomains:  []string{"group.example.com"},
+			expectedArchives: []string{"sha-1"},
+		},
+		"no_match": {
+			url:              "/@cache?host=missing.com",
+			token:            testhelpers.SignAPIToken(t, []byte("secret"), jwt.NewNumericDate(time.Now().Add(time.Minute))),
+			expectedStatus:   http.StatusOK,
+			expectedDomains:  []string{},
+			expectedArchives: []string{},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.token != "" {
+				req.Header.Set(security.APIRequestHeader, tt.token)
+			}
+
+			ww := httptest.NewRecorder()
+			handler.ServeHTTP(ww, req)
+
+			require.Equal(t, tt.expectedStatus, ww.Code)
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			require.Equal(t, "application/json", ww.Header().Get("Content-Type"))
+			require.Equal(t, "no-store", ww.Header().Get("Cache-Control"))
+
+			var dump cachedump.Dump
+			require.NoError(t, json.NewDecoder(ww.Body).Decode(&dump))
+
+			domains := []string{}
+			for _, d := range dump.Domains {
+				domains = append(domains, d.Name)
+			}
+			archives := []string{}
+			for _, a := range dump.Archives {
+				archives = append(archives, a.Key)
+			}
+
+			require.Equal(t, tt.expectedDomains, domains)
+			require.Equal(t, tt.expectedArchives, archives)
+		})
+	}
+}
+
+func TestDumpWithoutCaches(t *testing.T) {
+	dump := cachedump.New(nil, nil, nil).Dump("")
+
+	require.Empty(t, dump.Domains)
+	require.Empty(t, dump.Archives)
+}
+
+func TestNewMiddlewareDisabled(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	handler := cachedump.NewMiddleware(next, "", nil)
+
+	ww := httptest.NewRecorder()
+	handler.ServeHTTP(ww, httptest.NewRequest(http.MethodGet, "/@cache", nil))
+	require.Equal(t, http.StatusOK, ww.Code)
+}
@@ -1,9 +1,13 @@
 package config
 
 import (
+	"bytes"
 	"encoding/base64"
 	"fmt"
+	"net/url"
 	"os"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,6 +21,7 @@ import (
 type Config struct {
 	General         General
 	RateLimit       RateLimit
+	Bandwidth       Bandwidth
 	ArtifactsServer ArtifactsServer
 	Authentication  Auth
 	GitLab          GitLab
@@ -24,7 +29,18 @@ type Config struct {
 	Log             Log
 	Sentry          Sentry
 	TLS             TLS
+	HTTP2           HTTP2
 	Zip             ZipServing
+	HostnameSource  HostnameSource
+	Metrics         Metrics
+	Analytics       Analytics
+	MicroCache      MicroCache
+	RequestFilter   RequestFilter
+
+	// Instances are the virtual instances serving other pages domains from
+	// the same listeners, with their own GitLab API, authentication and rate
+	// limits, see loadInstance
+	Instances []*Config
 
 	// Fields used to share information between files. These are not directly
 	// set by command line flags, but rather populated based on info from them.
@@ -33,13 +49,14 @@ type Config struct {
 	ListenMetrics uintptr
 
 	// These fields contain the raw strings passed for listen-http,
-	// listen-https, listen-proxy and listen-https-proxyv2 settings. It is used
-	// by appmain() to create listeners, and the pointers to these listeners
-	// gets assigned to Config.Listeners.* fields
+	// listen-https, listen-proxy, listen-https-proxyv2 and listen-http-https
+	// settings. It is used by appmain() to create listeners, and the pointers
+	// to these listeners gets assigned to Config.Listeners.* fields
 	ListenHTTPStrings         MultiStringFlag
 	ListenHTTPSStrings        MultiStringFlag
 	ListenProxyStrings        MultiStringFlag
 	ListenHTTPSProxyv2Strings MultiStringFlag
+	ListenHTTPAndHTTPSStrings MultiStringFlag
 }
 
 // General groups settings that are general to GitLab Pages and can not
@@ -54,14 +71,204 @@ type General struct {
 	RootDir         string
 	RootKey         []byte
 	StatusPath      string
+	DiagnosticsPath string
+	CacheDumpPath   string
+	// CacheHandoffFile is the path of the file the domains and
+	// authentication caches are handed off through to the next process
+	CacheHandoffFile string
+	// ShutdownTimeout is the maximum time the requests in flight are waited
+	// for when Pages stops gracefully
+	ShutdownTimeout time.Duration
+	// WriteTimeout is the maximum time each write of a response to a client
+	// can take, 0 means no timeout
+	WriteTimeout time.Duration
+
+	// GonePage is the HTML page served with a 410 status for the domains
+	// deleted from GitLab, a default page is served when empty
+	GonePage []byte
+
+	// RedirectHTTPExclude are the path prefixes served over HTTP when
+	// RedirectHTTP is enabled, e.g. for health probes of load balancers
+	RedirectHTTPExclude []string
+
+	// DirectoryRedirectStatus is the status of the redirects of directories
+	// requested without a trailing slash to the URL with a slash
+	DirectoryRedirectStatus int
+
+	// TrailingSlash is the policy applied to the trailing slashes of the
+	// paths of directories and extensionless pages, one of the TrailingSlash*
+	// values
+	TrailingSlash string
+
+	// CleanURLs serves the directories requested without a trailing slash
+	// from the page with their name and the .html extension, then from their
+	// index, instead of redirecting them to their path with a slash
+	CleanURLs bool
+
+	// DirectoryListing serves a generated index of the files of the
+	// directories without an index.html
+	DirectoryListing bool
+
+	// IndexFiles are the names of the files served for directories, in
+	// order of preference
+	IndexFiles []string
+
+	// Dotfiles is the policy applied to the files and directories starting
+	// with a dot, other than .well-known
+	Dotfiles string
+
+	// BlockedExtensions are the lowercase extensions of the files which are
+	// not served, e.g. private keys deployed by mistake
+	BlockedExtensions []string
+
+	// CacheControl are the Cache-Control values of the public files by glob
+	// pattern, as patterns: value rules, see CacheControlRules
+	CacheControl []string
 
 	DisableCrossOriginRequests bool
 	InsecureCiphers            bool
 	PropagateCorrelationID     bool
 
+	// RedirectUncertifiedDomains redirects HTTP requests to custom domains
+	// without a certificate to the URL of their project on the pages domain
+	RedirectUncertifiedDomains bool
+
+	// DeploymentHooks enables the webhook GitLab calls after deployments to
+	// refresh the cached configuration of their domains
+	DeploymentHooks bool
+
 	ShowVersion bool
 
-	CustomHeaders []string
+	CustomHeaders      []string
+	AllowedHTTPMethods []string
+
+	// ServerHeader is the value of the Server header of the responses, the
+	// header is removed when empty
+	ServerHeader string
+
+	// ForceNoIndex adds an X-Robots-Tag header preventing search engines from
+	// indexing every response
+	ForceNoIndex bool
+
+	// ProjectHeaders adds the ID and the namespace of the project serving
+	// a request to its response
+	ProjectHeaders bool
+
+	// EgressAllowlist restricts the hosts of the artifacts server and object
+	// storage Pages connects to, all hosts are allowed when empty
+	EgressAllowlist []string
+
+	// TrustedProxies are the reverse proxies whose forwarded host is used to
+	// build redirect URLs for requests to the HTTP and HTTPS listeners
+	TrustedProxies []string
+
+	// FeatureRollouts are the features enabled for a percentage of the
+	// domains, as name=percentage pairs, see Rollouts
+	FeatureRollouts []string
+}
+
+// Rollouts parses the FeatureRollouts, e.g. redirects_placeholders=10, into
+// the percentage of the domains for which the features are enabled, keyed by
+// the feature name
+func (g *General) Rollouts() (map[string]int, error) {
+	rollouts := make(map[string]int, len(g.FeatureRollouts))
+
+	for _, entry := range g.FeatureRollouts {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidFeatureRollout, entry)
+		}
+
+		name := strings.TrimSpace(parts[0])
+		if name == "" {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidFeatureRollout, entry)
+		}
+
+		percentage, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(parts[1]), "%"))
+		if err != nil || percentage < 0 || percentage > 100 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidFeatureRollout, entry)
+		}
+
+		rollouts[name] = percentage
+	}
+
+	return rollouts, nil
+}
+
+// CacheControlRule is the Cache-Control value of the files matching one of
+// its patterns. Patterns containing a slash match the path of the file in the
+// project, e.g. /assets/*, other patterns match its name, e.g. *.css.
+type CacheControlRule struct {
+	Patterns []string
+	Value    string
+}
+
+// Match returns true if the file at path, relative to the project root,
+// matches one of the patterns of the rule
+func (r CacheControlRule) Match(filePath string) bool {
+	filePath = "/" + strings.TrimPrefix(filePath, "/")
+
+	for _, pattern := range r.Patterns {
+		name := path.Base(filePath)
+		if strings.Contains(pattern, "/") {
+			name = filePath
+		}
+
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CacheControlRules parses the CacheControl, e.g. *.css,*.js: max-age=3600,
+// into rules, in order of precedence
+func (g *General) CacheControlRules() ([]CacheControlRule, error) {
+	rules := make([]CacheControlRule, 0, len(g.CacheControl))
+
+	for _, entry := range g.CacheControl {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidCacheControl, entry)
+		}
+
+		value := strings.TrimSpace(parts[1])
+		if value == "" || strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidCacheControl, entry)
+		}
+
+		var patterns []string
+		for _, pattern := range strings.Split(parts[0], ",") {
+			if pattern = strings.TrimSpace(pattern); pattern == "" {
+				continue
+			}
+
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidCacheControl, entry)
+			}
+
+			patterns = append(patterns, pattern)
+		}
+
+		if len(patterns) == 0 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidCacheControl, entry)
+		}
+
+		rules = append(rules, CacheControlRule{Patterns: patterns, Value: value})
+	}
+
+	return rules, nil
 }
 
 // RateLimit config struct
@@ -70,15 +277,100 @@ type RateLimit struct {
 	SourceIPBurst          int
 	DomainLimitPerSecond   float64
 	DomainBurst            int
+
+	// RedisURL of the Redis server storing the rate limits shared by all
+	// instances, the rate limits are kept in memory when empty
+	RedisURL     string
+	RedisTimeout time.Duration
+}
+
+// Bandwidth groups the limits of the bytes per second written to the
+// responses, 0 does not limit the bandwidth
+type Bandwidth struct {
+	// ConnectionLimit is shared by the requests of a connection, including
+	// the concurrent streams of HTTP/2 connections
+	ConnectionLimit int64
+	DomainLimit     int64
+}
+
+// HTTP2 groups the settings of HTTP/2 connections served on the HTTPS listeners
+type HTTP2 struct {
+	// MaxConcurrentStreams and MaxReadFrameSize are the values of the flags,
+	// which are only converted to the uint32 of the HTTP/2 settings once
+	// they are validated
+	MaxConcurrentStreams uint64
+	MaxReadFrameSize     uint64
+	IdleTimeout          time.Duration
 }
 
 // ArtifactsServer groups settings related to configuring Artifacts
 // server
 type ArtifactsServer struct {
-	URL            string
+	// URLs of the artifacts servers, optionally followed by their weight and
+	// PROXY protocol version, e.g.
+	// https://gitlab.example.com/api/v4;weight=2;proxy-protocol=v2
+	URLs           []string
 	TimeoutSeconds int
 }
 
+// ArtifactsBackend is an artifacts server requests are proxied to
+type ArtifactsBackend struct {
+	URL string
+	// Weight is the share of requests proxied to the server relative to the
+	// other servers
+	Weight int
+	// ProxyProtocol sends a PROXY protocol v2 header with the address of the
+	// client on every connection to the server, e.g. when the server is
+	// behind a L4 load balancer requiring it
+	ProxyProtocol bool
+}
+
+// Backends parses the URLs of the artifacts servers, which can be followed
+// by their weight and PROXY protocol version, e.g.
+// https://gitlab.example.com/api/v4;weight=2;proxy-protocol=v2
+func (a *ArtifactsServer) Backends() ([]ArtifactsBackend, error) {
+	backends := make([]ArtifactsBackend, 0, len(a.URLs))
+
+	for _, entry := range a.URLs {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ";")
+		backend := ArtifactsBackend{URL: strings.TrimSpace(parts[0]), Weight: 1}
+
+		for _, param := range parts[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("%w: %q", ErrArtifactsServerInvalidParameter, entry)
+			}
+
+			switch kv[0] {
+			case "weight":
+				weight, err := strconv.Atoi(kv[1])
+				if err != nil || weight < 1 {
+					return nil, fmt.Errorf("%w: %q", ErrArtifactsServerInvalidWeight, entry)
+				}
+
+				backend.Weight = weight
+			case "proxy-protocol":
+				if kv[1] != "v2" {
+					return nil, fmt.Errorf("%w: %q", ErrArtifactsServerInvalidParameter, entry)
+				}
+
+				backend.ProxyProtocol = true
+			default:
+				return nil, fmt.Errorf("%w: %q", ErrArtifactsServerInvalidParameter, entry)
+			}
+		}
+
+		backends = append(backends, backend)
+	}
+
+	return backends, nil
+}
+
 // Auth groups settings related to configuring Authentication with
 // GitLab
 type Auth struct {
@@ -87,8 +379,39 @@ type Auth struct {
 	ClientSecret string
 	RedirectURI  string
 	Scope        string
+	CookieName   string
+	CookieScope  string
+
+	// TokenTimeout and AccessCheckTimeout limit the requests to GitLab
+	// exchanging OAuth codes for tokens and checking the access of users
+	TokenTimeout       time.Duration
+	AccessCheckTimeout time.Duration
+	// CodeExpiry is the lifetime of the signed OAuth codes handed to custom
+	// domains
+	CodeExpiry time.Duration
+	// QueryToken enables the handoff tokens passed in the Authorization
+	// header, or once in the query, of the requests of clients which can not
+	// follow the OAuth flow with cookies
+	QueryToken bool
+	// APIRetries is the number of times failed requests to GitLab are retried
+	APIRetries int
+	// ProxyURL is the forward proxy of the requests to GitLab, separate from
+	// the proxy of object storage
+	ProxyURL string
 }
 
+// Scopes of the auth session cookie
+const (
+	// AuthCookieScopeHost sets a cookie for each host
+	AuthCookieScopeHost = "host"
+	// AuthCookieScopePagesDomain shares the cookie between all the subdomains
+	// of the pages domain, custom domains still get a cookie for each host
+	AuthCookieScopePagesDomain = "pages-domain"
+	// AuthCookieScopeHostPrefix sets a cookie for each host with the __Host-
+	// prefix on HTTPS so it cannot be overwritten by other subdomains
+	AuthCookieScopeHostPrefix = "host-prefix"
+)
+
 // Cache configuration for GitLab API
 type Cache struct {
 	CacheExpiry          time.Duration
@@ -97,6 +420,9 @@ type Cache struct {
 	RetrievalTimeout     time.Duration
 	MaxRetrievalInterval time.Duration
 	MaxRetrievalRetries  int
+	// MaxEntries limits the number of domains and aliases cached, the least
+	// recently used ones are evicted first. It is unlimited when 0.
+	MaxEntries int
 }
 
 // GitLab groups settings related to configuring GitLab client used to
@@ -109,15 +435,156 @@ type GitLab struct {
 	JWTTokenExpiration time.Duration
 	Cache              Cache
 	EnableDisk         bool
+
+	// InternalServerSRV is a DNS SRV record whose targets serve the API of
+	// InternalServer, they are resolved every InternalServerSRVInterval
+	InternalServerSRV         string
+	InternalServerSRVInterval time.Duration
+
+	// ProxyURL is the forward proxy of the requests to the GitLab API, the
+	// proxy environment variables are used when empty
+	ProxyURL string
+}
+
+// Domains configuration sources
+const (
+	// DomainSourceGitLab uses the GitLab API, or the hostname source when
+	// its template is set
+	DomainSourceGitLab = "gitlab"
+	// DomainSourceAuto uses the GitLab API and falls back to the hostname
+	// source while the API is unhealthy
+	DomainSourceAuto = "auto"
+)
+
+// Placeholders of the hostname source template
+const (
+	HostnameGroupPlaceholder   = "{group}"
+	HostnameProjectPlaceholder = "{project}"
+)
+
+// HostnameSource configures the domains source deriving the group and
+// project of domains from their hostname, instead of using the GitLab API
+type HostnameSource struct {
+	// Template of the hostnames, e.g. {project}.{group}.pages.local. The
+	// source is disabled when it is empty
+	Template string
+	// Mode is DomainSourceAuto when the source is only used while the
+	// GitLab API is unhealthy
+	Mode string
+}
+
+// Labels splits the template into its DNS labels, placeholders have to be
+// full labels. The template has to include the {group} placeholder and can
+// include the {project} placeholder, each of them only once.
+func (h *HostnameSource) Labels() ([]string, error) {
+	labels := strings.Split(strings.ToLower(strings.TrimSpace(h.Template)), ".")
+	placeholders := map[string]int{}
+
+	for _, label := range labels {
+		switch {
+		case label == HostnameGroupPlaceholder || label == HostnameProjectPlaceholder:
+			placeholders[label]++
+		case label == "" || strings.ContainsAny(label, "{}"):
+			return nil, fmt.Errorf("%w: %q", ErrHostnameSourceInvalidTemplate, h.Template)
+		}
+	}
+
+	if placeholders[HostnameGroupPlaceholder] != 1 || placeholders[HostnameProjectPlaceholder] > 1 {
+		return nil, fmt.Errorf("%w: %q", ErrHostnameSourceInvalidTemplate, h.Template)
+	}
+
+	return labels, nil
 }
 
 // Listeners groups settings related to configuring various listeners
-// (HTTP, HTTPS, Proxy, HTTPSProxyv2)
+// (HTTP, HTTPS, Proxy, HTTPSProxyv2, HTTPAndHTTPS)
 type Listeners struct {
 	HTTP         []uintptr
 	HTTPS        []uintptr
 	Proxy        []uintptr
 	HTTPSProxyv2 []uintptr
+	// HTTPAndHTTPS serve both HTTP and HTTPS on the same port
+	HTTPAndHTTPS []uintptr
+	// Addresses are the addresses all the listeners, including the metrics
+	// listener, are bound to
+	Addresses []ListenerAddress
+}
+
+// ListenerAddress is the address a listener is bound to, which is only known
+// once bound when listening on port 0
+type ListenerAddress struct {
+	Type    string `json:"type"`
+	Address string `json:"address"`
+}
+
+// Metrics groups settings protecting the metrics listener, which exposes
+// per-domain information
+type Metrics struct {
+	// Token is accepted as a bearer token when set
+	Token []byte
+	// Username and Password are accepted as basic auth credentials when set
+	Username string
+	Password []byte
+	// AllowedIPs are the IP addresses and CIDR ranges of the clients allowed
+	// to request metrics, any client is allowed when empty
+	AllowedIPs []string
+	// TenantLabels is the number of most requested domains and namespaces
+	// counted with their own label
+	TenantLabels int
+	// TenantLabelsAllow are the domains and namespaces always counted with
+	// their own label
+	TenantLabelsAllow []string
+	// TenantLabelsDeny are the domains and namespaces never counted with
+	// their own label
+	TenantLabelsDeny []string
+}
+
+// TenantLabelsEnabled returns true when the requests are counted by domain
+// and namespace
+func (m *Metrics) TenantLabelsEnabled() bool {
+	return m.TenantLabels > 0 || len(m.TenantLabelsAllow) > 0
+}
+
+// AuthEnabled returns true when requests to the metrics listener must be
+// authenticated
+func (m *Metrics) AuthEnabled() bool {
+	return len(m.Token) > 0 || m.Username != ""
+}
+
+// Analytics groups settings of the access summaries reported to GitLab
+type Analytics struct {
+	// ReportInterval is the interval at which the summaries are reported,
+	// reporting is disabled when it is 0
+	ReportInterval time.Duration
+	// TopPaths is the number of most requested paths reported per domain
+	TopPaths int
+	// MaxDomains limits the number of domains summarized per interval
+	MaxDomains int
+}
+
+// MicroCache groups settings of the in-memory cache of HTML responses
+type MicroCache struct {
+	// TTL is the maximum time a response is cached for, the cache is
+	// disabled when it is 0
+	TTL time.Duration
+	// MaxSize is the maximum size in bytes of the cached response bodies
+	MaxSize int64
+	// MaxEntries is the maximum number of cached responses
+	MaxEntries int64
+	// StaleIfError is the maximum time an expired response is served for,
+	// when serving the request again fails with a server error
+	StaleIfError time.Duration
+}
+
+// RequestFilter groups settings of the rules blocking, allowing or rate
+// limiting requests before they are served
+type RequestFilter struct {
+	// RulesFile is the path of the JSON file of the rules, requests are not
+	// filtered when it is empty
+	RulesFile string
+	// ReloadInterval is the interval at which RulesFile is checked for
+	// changes, the rules are not reloaded when it is 0
+	ReloadInterval time.Duration
 }
 
 // Log groups settings related to configuring logging
@@ -136,8 +603,48 @@ type Sentry struct {
 type TLS struct {
 	MinVersion uint16
 	MaxVersion uint16
+	// ECHKeys are the PEM encoded Encrypted Client Hello keys, the first one
+	// is the current key and the rest are previous keys kept during rotation
+	ECHKeys [][]byte
+	// InvalidCertificatePolicy is applied to custom domains with invalid
+	// certificates, one of the TLSInvalidCertificate* values
+	InvalidCertificatePolicy string
 }
 
+// Policies applied to the files and directories starting with a dot
+const (
+	// DotfilesAllow serves them like any other file
+	DotfilesAllow = "allow"
+	// DotfilesIgnore serves them as if they did not exist
+	DotfilesIgnore = "ignore"
+	// DotfilesDeny serves a 403 Forbidden
+	DotfilesDeny = "deny"
+)
+
+// Policies applied to the trailing slashes of the paths of directories and of
+// the pages served without their .html extension
+const (
+	// TrailingSlashPreserve serves the pages with and without a slash, and
+	// redirects the directories to their path with a slash
+	TrailingSlashPreserve = "preserve"
+	// TrailingSlashAlways redirects the directories and pages to their path
+	// with a slash
+	TrailingSlashAlways = "always"
+	// TrailingSlashNever redirects the directories with an index and the
+	// pages to their path without a slash
+	TrailingSlashNever = "never"
+)
+
+// Policies applied to custom domains with an invalid certificate
+const (
+	// TLSInvalidCertificateServe serves self-signed and expired certificates
+	TLSInvalidCertificateServe = "serve"
+	// TLSInvalidCertificateWildcard serves the certificate of the pages domain
+	TLSInvalidCertificateWildcard = "wildcard"
+	// TLSInvalidCertificateReject fails the TLS handshake
+	TLSInvalidCertificateReject = "reject"
+)
+
 // ZipServing groups settings to be used by the zip VFS opening and caching
 type ZipServing struct {
 	ExpirationInterval time.Duration
@@ -145,6 +652,32 @@ type ZipServing struct {
 	RefreshInterval    time.Duration
 	OpenTimeout        time.Duration
 	AllowedPaths       []string
+	// ReadAheadChunkSize and ReadAheadMaxPrefetch configure the chunks fetched
+	// ahead when serving large files from archives in object storage, files
+	// are read ahead from ReadAheadMinSize bytes and ReadAheadConcurrency caps
+	// the chunks fetched concurrently for all files, 0 means no cap
+	ReadAheadChunkSize   int64
+	ReadAheadMaxPrefetch int
+	ReadAheadMinSize     int64
+	ReadAheadConcurrency int
+	// ShadowSampleRate is the fraction of the requests served from disk which
+	// are served again from the public.zip archive next to their public
+	// directory to compare their responses, 0 disables it
+	ShadowSampleRate float64
+	// CACertificates are PEM encoded certificates trusted, in addition to the
+	// system certificate pool, to connect to object storage
+	CACertificates []byte
+	// InsecureSkipVerify disables the verification of the certificates of
+	// object storage, for test environments only
+	InsecureSkipVerify bool
+	// ProxyURL is the forward proxy of the requests to object storage, the
+	// proxy environment variables are used when empty
+	ProxyURL string
+	// FileCacheSize is the maximum size in bytes of the decompressed files
+	// kept in memory, only files of at most FileCacheMaxFileSize bytes are
+	// kept and 0 disables the cache
+	FileCacheSize        int64
+	FileCacheMaxFileSize int64
 }
 
 func internalGitlabServerFromFlags() string {
@@ -155,28 +688,85 @@ func internalGitlabServerFromFlags() string {
 	return *publicGitLabServer
 }
 
+// parseHTTPMethods splits a comma separated list of HTTP methods and
+// normalizes them to upper case
+func parseHTTPMethods(methods string) []string {
+	var result []string
+
+	for _, method := range strings.Split(methods, ",") {
+		if method = strings.ToUpper(strings.TrimSpace(method)); method != "" {
+			result = append(result, method)
+		}
+	}
+
+	return result
+}
+
+// parseExtensions returns the lowercase extensions of a comma separated list,
+// with a leading dot
+func parseExtensions(extensions string) []string {
+	var result []string
+
+	for _, ext := range strings.Split(extensions, ",") {
+		if ext = strings.ToLower(strings.TrimSpace(ext)); ext == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+
+		result = append(result, ext)
+	}
+
+	return result
+}
+
+func parseIndexFiles(names string) []string {
+	var result []string
+
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			result = append(result, name)
+		}
+	}
+
+	return result
+}
+
 func setGitLabAPISecretKey(secretFile string, config *Config) error {
 	if secretFile == "" {
 		return nil
 	}
 
+	secret, err := ReadGitLabAPISecretKey(secretFile)
+	if err != nil {
+		return err
+	}
+
+	config.GitLab.APISecretKey = secret
+	return nil
+}
+
+// ReadGitLabAPISecretKey reads and decodes the base64 encoded GitLab API
+// secret from secretFile
+func ReadGitLabAPISecretKey(secretFile string) ([]byte, error) {
 	encoded, err := os.ReadFile(secretFile)
 	if err != nil {
-		return fmt.Errorf("reading secret file: %w", err)
+		return nil, fmt.Errorf("reading secret file: %w", err)
 	}
 
 	decoded := make([]byte, base64.StdEncoding.DecodedLen(len(encoded)))
 	secretLength, err := base64.StdEncoding.Decode(decoded, encoded)
 	if err != nil {
-		return fmt.Errorf("decoding GitLab API secret: %w", err)
+		return nil, fmt.Errorf("decoding GitLab API secret: %w", err)
 	}
 
 	if secretLength != 32 {
-		return fmt.Errorf("expected 32 bytes GitLab API secret but got %d bytes", secretLength)
+		return nil, fmt.Errorf("expected 32 bytes GitLab API secret but got %d bytes", secretLength)
 	}
 
-	config.GitLab.APISecretKey = decoded
-	return nil
+	return decoded, nil
 }
 
 func loadConfig() (*Config, error) {
@@ -185,14 +775,37 @@ func loadConfig() (*Config, error) {
 			Domain:                     strings.ToLower(*pagesDomain),
 			MaxConns:                   *maxConns,
 			MaxURILength:               *maxURILength,
+			WriteTimeout:               *writeTimeout,
 			MetricsAddress:             *metricsAddress,
 			RedirectHTTP:               *redirectHTTP,
+			RedirectHTTPExclude:        redirectHTTPExclude.Split(),
+			DirectoryRedirectStatus:    *directoryRedirectStatus,
+			TrailingSlash:              *trailingSlash,
+			CleanURLs:                  *cleanURLs,
+			DirectoryListing:           *directoryListing,
+			IndexFiles:                 parseIndexFiles(*indexFiles),
+			Dotfiles:                   *dotfiles,
+			BlockedExtensions:          parseExtensions(*blockedExtensions),
+			CacheControl:               cacheControl.Split(),
+			RedirectUncertifiedDomains: *redirectUncertified,
 			RootDir:                    *pagesRoot,
 			StatusPath:                 *pagesStatus,
+			DiagnosticsPath:            *pagesDiagnostics,
+			CacheDumpPath:              *pagesCacheDump,
+			CacheHandoffFile:           *cacheHandoffFile,
+			ShutdownTimeout:            *shutdownTimeout,
 			DisableCrossOriginRequests: *disableCrossOriginRequests,
 			InsecureCiphers:            *insecureCiphers,
 			PropagateCorrelationID:     *propagateCorrelationID,
+			DeploymentHooks:            *deploymentHooks,
 			CustomHeaders:              header.Split(),
+			AllowedHTTPMethods:         parseHTTPMethods(*allowedHTTPMethods),
+			ServerHeader:               *serverHeader,
+			ForceNoIndex:               *forceNoIndex,
+			ProjectHeaders:             *projectHeaders,
+			EgressAllowlist:            egressAllowlist.Split(),
+			TrustedProxies:             trustedProxies.Split(),
+			FeatureRollouts:            featureRollout.Split(),
 			ShowVersion:                *showVersion,
 		},
 		RateLimit: RateLimit{
@@ -200,11 +813,18 @@ func loadConfig() (*Config, error) {
 			SourceIPBurst:          *rateLimitSourceIPBurst,
 			DomainLimitPerSecond:   *rateLimitDomain,
 			DomainBurst:            *rateLimitDomainBurst,
+			RedisURL:               *rateLimitRedisURL,
+			RedisTimeout:           *rateLimitRedisTimeout,
+		},
+		Bandwidth: Bandwidth{
+			ConnectionLimit: *bandwidthConnection,
+			DomainLimit:     *bandwidthDomain,
 		},
 		GitLab: GitLab{
 			ClientHTTPTimeout:  *gitlabClientHTTPTimeout,
 			JWTTokenExpiration: *gitlabClientJWTExpiry,
 			EnableDisk:         *enableDisk,
+			ProxyURL:           *objectStorageProxy,
 			Cache: Cache{
 				CacheExpiry:          *gitlabCacheExpiry,
 				CacheCleanupInterval: *gitlabCacheCleanup,
@@ -212,11 +832,12 @@ func loadConfig() (*Config, error) {
 				RetrievalTimeout:     *gitlabRetrievalTimeout,
 				MaxRetrievalInterval: *gitlabRetrievalInterval,
 				MaxRetrievalRetries:  *gitlabRetrievalRetries,
+				MaxEntries:           *gitlabCacheMaxEntries,
 			},
 		},
 		ArtifactsServer: ArtifactsServer{
 			TimeoutSeconds: *artifactsServerTimeout,
-			URL:            *artifactsServer,
+			URLs:           artifactsServer.Split(),
 		},
 		Authentication: Auth{
 			Secret:       *secret,
@@ -224,6 +845,15 @@ func loadConfig() (*Config, error) {
 			ClientSecret: *clientSecret,
 			RedirectURI:  *redirectURI,
 			Scope:        *authScope,
+			CookieName:   *authCookieName,
+			CookieScope:  *authCookieScope,
+
+			TokenTimeout:       *authTokenTimeout,
+			AccessCheckTimeout: *authAccessCheckTimeout,
+			CodeExpiry:         *authCodeExpiry,
+			QueryToken:         *authQueryToken,
+			APIRetries:         *authAPIRetries,
+			ProxyURL:           *authProxy,
 		},
 		Log: Log{
 			Format:  *logFormat,
@@ -236,13 +866,55 @@ func loadConfig() (*Config, error) {
 		TLS: TLS{
 			MinVersion: tls.AllTLSVersions[*tlsMinVersion],
 			MaxVersion: tls.AllTLSVersions[*tlsMaxVersion],
+
+			InvalidCertificatePolicy: *tlsInvalidCertPolicy,
+		},
+		HTTP2: HTTP2{
+			MaxConcurrentStreams: uint64(*http2MaxConcurrentStreams),
+			MaxReadFrameSize:     uint64(*http2MaxReadFrameSize),
+			IdleTimeout:          *http2IdleTimeout,
 		},
 		Zip: ZipServing{
-			ExpirationInterval: *zipCacheExpiration,
-			CleanupInterval:    *zipCacheCleanup,
-			RefreshInterval:    *zipCacheRefresh,
-			OpenTimeout:        *zipOpenTimeout,
-			AllowedPaths:       []string{*pagesRoot},
+			ExpirationInterval:   *zipCacheExpiration,
+			CleanupInterval:      *zipCacheCleanup,
+			RefreshInterval:      *zipCacheRefresh,
+			OpenTimeout:          *zipOpenTimeout,
+			AllowedPaths:         []string{*pagesRoot},
+			ReadAheadChunkSize:   *zipReadAheadChunk,
+			ReadAheadMaxPrefetch: *zipReadAheadChunks,
+			ReadAheadMinSize:     *zipReadAheadMin,
+			ReadAheadConcurrency: *zipReadAheadLimit,
+			ShadowSampleRate:     *zipShadowSampleRate,
+			FileCacheSize:        *zipFileCacheSize,
+			FileCacheMaxFileSize: *zipFileCacheMaxFileSize,
+			InsecureSkipVerify:   *objectStorageInsecureSkipVerify,
+			ProxyURL:             *objectStorageProxy,
+		},
+		HostnameSource: HostnameSource{
+			Template: *hostnameSourceTemplate,
+			Mode:     *domainConfigSource,
+		},
+		Metrics: Metrics{
+			Username:          *metricsAuthUsername,
+			AllowedIPs:        metricsAllowedIPs.Split(),
+			TenantLabels:      *metricsTenantLabels,
+			TenantLabelsAllow: metricsTenantLabelsAllow.Split(),
+			TenantLabelsDeny:  metricsTenantLabelsDeny.Split(),
+		},
+		Analytics: Analytics{
+			ReportInterval: *analyticsReportInterval,
+			TopPaths:       *analyticsTopPaths,
+			MaxDomains:     *analyticsMaxDomains,
+		},
+		MicroCache: MicroCache{
+			TTL:          *microCacheTTL,
+			MaxSize:      *microCacheMaxSize,
+			MaxEntries:   *microCacheMaxEntries,
+			StaleIfError: *microCacheStaleIfError,
+		},
+		RequestFilter: RequestFilter{
+			RulesFile:      *requestFilterRules,
+			ReloadInterval: *requestFilterReloadInterval,
 		},
 
 		// Actual listener pointers will be populated in appMain. We populate the
@@ -251,6 +923,7 @@ func loadConfig() (*Config, error) {
 		ListenHTTPSStrings:        listenHTTPS,
 		ListenProxyStrings:        listenProxy,
 		ListenHTTPSProxyv2Strings: listenHTTPSProxyv2,
+		ListenHTTPAndHTTPSStrings: listenHTTPAndHTTPS,
 		Listeners:                 Listeners{},
 	}
 
@@ -263,6 +936,10 @@ func loadConfig() (*Config, error) {
 	}{
 		{&config.General.RootCertificate, *pagesRootCert},
 		{&config.General.RootKey, *pagesRootKey},
+		{&config.General.GonePage, *gonePageFile},
+		{&config.Metrics.Token, *metricsAuthTokenFile},
+		{&config.Metrics.Password, *metricsAuthPasswordFile},
+		{&config.Zip.CACertificates, *objectStorageCAFile},
 	} {
 		if file.path != "" {
 			if *file.contents, err = os.ReadFile(file.path); err != nil {
@@ -271,55 +948,170 @@ func loadConfig() (*Config, error) {
 		}
 	}
 
+	// files often end with a newline which is not part of the secret
+	config.Metrics.Token = bytes.TrimSpace(config.Metrics.Token)
+	config.Metrics.Password = bytes.TrimSpace(config.Metrics.Password)
+
+	for _, path := range tlsECHKeys.value {
+		key, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		config.TLS.ECHKeys = append(config.TLS.ECHKeys, key)
+	}
+
 	// Populating remaining GitLab settings
 	config.GitLab.PublicServer = *publicGitLabServer
 
 	config.GitLab.InternalServer = internalGitlabServerFromFlags()
+	config.GitLab.InternalServerSRV = *internalGitLabServerSRV
+	config.GitLab.InternalServerSRVInterval = *internalGitLabServerSRVInterval
 
 	if err = setGitLabAPISecretKey(*gitLabAPISecretKey, config); err != nil {
 		return nil, err
 	}
 
+	if config.Instances, err = loadInstances(config, instanceConfigs.Split()); err != nil {
+		return nil, err
+	}
+
 	return config, nil
 }
 
 func LogConfig(config *Config) {
 	log.WithFields(log.Fields{
-		"artifacts-server":              *artifactsServer,
+		"artifacts-server":              config.ArtifactsServer.URLs,
 		"artifacts-server-timeout":      *artifactsServerTimeout,
 		"default-config-filename":       flag.DefaultConfigFlagname,
 		"disable-cross-origin-requests": *disableCrossOriginRequests,
 		"domain":                        config.General.Domain,
+		"egress-allowlist":              config.General.EgressAllowlist,
+		"trusted-proxies":               config.General.TrustedProxies,
+		"feature-rollout":               config.General.FeatureRollouts,
+		"http2-max-concurrent-streams":  config.HTTP2.MaxConcurrentStreams,
+		"http2-max-read-frame-size":     config.HTTP2.MaxReadFrameSize,
+		"http2-idle-timeout":            config.HTTP2.IdleTimeout,
 		"insecure-ciphers":              config.General.InsecureCiphers,
 		"listen-http":                   listenHTTP,
 		"listen-https":                  listenHTTPS,
 		"listen-proxy":                  listenProxy,
 		"listen-https-proxyv2":          listenHTTPSProxyv2,
+		"listen-http-https":             listenHTTPAndHTTPS,
 		"log-format":                    *logFormat,
 		"metrics-address":               *metricsAddress,
+		"metrics-auth-username":         config.Metrics.Username,
+		"metrics-allowed-ips":           config.Metrics.AllowedIPs,
+		"metrics-tenant-labels":         config.Metrics.TenantLabels,
+		"metrics-tenant-labels-allow":   config.Metrics.TenantLabelsAllow,
+		"metrics-tenant-labels-deny":    config.Metrics.TenantLabelsDeny,
 		"pages-domain":                  *pagesDomain,
 		"pages-root":                    *pagesRoot,
 		"pages-status":                  *pagesStatus,
+		"pages-diagnostics":             *pagesDiagnostics,
+		"gone-page-file":                *gonePageFile,
+		"pages-cache-dump":              *pagesCacheDump,
+		"cache-handoff-file":            *cacheHandoffFile,
+		"shutdown-timeout":              *shutdownTimeout,
 		"propagate-correlation-id":      *propagateCorrelationID,
+		"enable-deployment-hooks":       *deploymentHooks,
+		"analytics-report-interval":     config.Analytics.ReportInterval,
+		"analytics-top-paths":           config.Analytics.TopPaths,
+		"analytics-max-domains":         config.Analytics.MaxDomains,
+		"micro-cache-ttl":               config.MicroCache.TTL,
+		"micro-cache-max-size":          config.MicroCache.MaxSize,
+		"micro-cache-max-entries":       config.MicroCache.MaxEntries,
+		"micro-cache-stale-if-error":    config.MicroCache.StaleIfError,
+		"rate-limit-redis-url":          redactURL(config.RateLimit.RedisURL),
+		"rate-limit-redis-timeout":      config.RateLimit.RedisTimeout,
+		"bandwidth-limit-connection":    config.Bandwidth.ConnectionLimit,
+		"bandwidth-limit-domain":        config.Bandwidth.DomainLimit,
 		"redirect-http":                 config.General.RedirectHTTP,
+		"redirect-http-exclude":         config.General.RedirectHTTPExclude,
+		"directory-redirect-status":     config.General.DirectoryRedirectStatus,
+		"trailing-slash":                config.General.TrailingSlash,
+		"clean-urls":                    config.General.CleanURLs,
+		"directory-listing":             config.General.DirectoryListing,
+		"index-files":                   config.General.IndexFiles,
+		"dotfiles":                      config.General.Dotfiles,
+		"blocked-extensions":            config.General.BlockedExtensions,
+		"cache-control":                 config.General.CacheControl,
+		"redirect-uncertified-domains":  config.General.RedirectUncertifiedDomains,
 		"root-cert":                     *pagesRootKey,
 		"root-key":                      *pagesRootCert,
 		"status_path":                   config.General.StatusPath,
 		"tls-min-version":               *tlsMinVersion,
 		"tls-max-version":               *tlsMaxVersion,
+		"tls-ech-key":                   tlsECHKeys,
+		"tls-invalid-cert-policy":       config.TLS.InvalidCertificatePolicy,
 		"gitlab-server":                 config.GitLab.PublicServer,
 		"internal-gitlab-server":        config.GitLab.InternalServer,
 		"api-secret-key":                *gitLabAPISecretKey,
 		"enable-disk":                   config.GitLab.EnableDisk,
+		"hostname-source-template":      config.HostnameSource.Template,
+		"domain-config-source":          config.HostnameSource.Mode,
 		"auth-redirect-uri":             config.Authentication.RedirectURI,
 		"auth-scope":                    config.Authentication.Scope,
+		"auth-cookie-name":              config.Authentication.CookieName,
+		"auth-cookie-scope":             config.Authentication.CookieScope,
+		"auth-token-timeout":            config.Authentication.TokenTimeout,
+		"auth-access-check-timeout":     config.Authentication.AccessCheckTimeout,
+		"auth-code-expiry":              config.Authentication.CodeExpiry,
+		"auth-query-token":              config.Authentication.QueryToken,
+		"auth-api-retries":              config.Authentication.APIRetries,
+		"auth-proxy":                    redactURL(config.Authentication.ProxyURL),
 		"max-conns":                     config.General.MaxConns,
 		"max-uri-length":                config.General.MaxURILength,
+		"write-timeout":                 config.General.WriteTimeout,
+		"allowed-http-methods":          config.General.AllowedHTTPMethods,
+		"server-header":                 config.General.ServerHeader,
+		"force-noindex":                 config.General.ForceNoIndex,
+		"project-headers":               config.General.ProjectHeaders,
 		"zip-cache-expiration":          config.Zip.ExpirationInterval,
 		"zip-cache-cleanup":             config.Zip.CleanupInterval,
 		"zip-cache-refresh":             config.Zip.RefreshInterval,
 		"zip-open-timeout":              config.Zip.OpenTimeout,
+		"zip-read-ahead-chunk-size":     config.Zip.ReadAheadChunkSize,
+		"zip-read-ahead-max-prefetch":   config.Zip.ReadAheadMaxPrefetch,
+		"zip-read-ahead-min-size":       config.Zip.ReadAheadMinSize,
+		"zip-read-ahead-concurrency":    config.Zip.ReadAheadConcurrency,
+		"zip-shadow-sample-rate":        config.Zip.ShadowSampleRate,
+		"zip-file-cache-size":           config.Zip.FileCacheSize,
+		"zip-file-cache-max-file-size":  config.Zip.FileCacheMaxFileSize,
+
+		"object-storage-ca-file":              *objectStorageCAFile,
+		"object-storage-insecure-skip-verify": config.Zip.InsecureSkipVerify,
+		"object-storage-proxy":                redactURL(config.Zip.ProxyURL),
+
+		"internal-gitlab-server-srv":          config.GitLab.InternalServerSRV,
+		"internal-gitlab-server-srv-interval": config.GitLab.InternalServerSRVInterval,
+
+		"request-filter-rules":           config.RequestFilter.RulesFile,
+		"request-filter-reload-interval": config.RequestFilter.ReloadInterval,
+
+		"instance-config": instanceConfigs,
 	}).Debug("Start Pages with configuration")
+
+	for _, instance := range config.Instances {
+		log.WithFields(log.Fields{
+			"pages-domain":           instance.General.Domain,
+			"gitlab-server":          instance.GitLab.PublicServer,
+			"internal-gitlab-server": instance.GitLab.InternalServer,
+			"auth-redirect-uri":      instance.Authentication.RedirectURI,
+			"auth-scope":             instance.Authentication.Scope,
+			"rate-limit-redis-url":   redactURL(instance.RateLimit.RedisURL),
+		}).Debug("Start Pages virtual instance with configuration")
+	}
+}
+
+// redactURL hides the password of rawURL so it can be logged
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	return u.Redacted()
 }
 
 // LoadConfig parses configuration settings passed as command line arguments or
@@ -0,0 +1,238 @@
+package config
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArtifactsServerBackends(t *testing.T) {
+	tests := map[string]struct {
+		urls        []string
+		expected    []ArtifactsBackend
+		expectedErr error
+	}{
+		"no_servers": {
+			expected: []ArtifactsBackend{},
+		},
+		"servers": {
+			urls: []string{"https://gitlab.example.com/api/v4", " https://replica.example.com/api/v4;weight=3 ", ""},
+			expected: []ArtifactsBackend{
+				{URL: "https://gitlab.example.com/api/v4", Weight: 1},
+				{URL: "https://replica.example.com/api/v4", Weight: 3},
+			},
+		},
+		"invalid_weight": {
+			urls:        []string{"https://gitlab.example.com/api/v4;weight=heavy"},
+			expectedErr: ErrArtifactsServerInvalidWeight,
+		},
+		"zero_weight": {
+			urls:        []string{"https://gitlab.example.com/api/v4;weight=0"},
+			expectedErr: ErrArtifactsServerInvalidWeight,
+		},
+		"proxy_protocol": {
+			urls: []string{"https://gitlab.example.com/api/v4;proxy-protocol=v2", "https://replica.example.com/api/v4; weight=2 ;proxy-protocol=v2"},
+			expected: []ArtifactsBackend{
+				{URL: "https://gitlab.example.com/api/v4", Weight: 1, ProxyProtocol: true},
+				{URL: "https://replica.example.com/api/v4", Weight: 2, ProxyProtocol: true},
+			},
+		},
+		"unsupported_proxy_protocol_version": {
+			urls:        []string{"https://gitlab.example.com/api/v4;proxy-protocol=v1"},
+			expectedErr: ErrArtifactsServerInvalidParameter,
+		},
+		"unknown_parameter": {
+			urls:        []string{"https://gitlab.example.com/api/v4;priority=1"},
+			expectedErr: ErrArtifactsServerInvalidParameter,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := ArtifactsServer{URLs: tt.urls}
+
+			backends, err := cfg.Backends()
+			if tt.expectedErr != nil {
+				require.True(t, errors.Is(err, tt.expectedErr))
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, backends)
+		})
+	}
+}
+
+func TestGeneralRollouts(t *testing.T) {
+	tests := map[string]struct {
+		rollouts    []string
+		expected    map[string]int
+		expectedErr error
+	}{
+		"no_rollouts": {
+			expected: map[string]int{},
+		},
+		"rollouts": {
+			rollouts: []string{"redirects_placeholders=10", " debug_header = 5% ", ""},
+			expected: map[string]int{"redirects_placeholders": 10, "debug_header": 5},
+		},
+		"missing_percentage": {
+			rollouts:    []string{"redirects_placeholders"},
+			expectedErr: ErrInvalidFeatureRollout,
+		},
+		"missing_name": {
+			rollouts:    []string{"=10"},
+			expectedErr: ErrInvalidFeatureRollout,
+		},
+		"percentage_above_100": {
+			rollouts:    []string{"redirects_placeholders=101"},
+			expectedErr: ErrInvalidFeatureRollout,
+		},
+		"negative_percentage": {
+			rollouts:    []string{"redirects_placeholders=-1"},
+			expectedErr: ErrInvalidFeatureRollout,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := General{FeatureRollouts: tt.rollouts}
+
+			rollouts, err := cfg.Rollouts()
+			if tt.expectedErr != nil {
+				require.True(t, errors.Is(err, tt.expectedErr))
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, rollouts)
+		})
+	}
+}
+
+func TestGeneralCacheControlRules(t *testing.T) {
+	tests := map[string]struct {
+		cacheControl []string
+		expected     []CacheControlRule
+		expectedErr  error
+	}{
+		"no_rules": {
+			expected: []CacheControlRule{},
+		},
+		"rules": {
+			cacheControl: []string{"*.css, *.js: max-age=31536000, immutable", " *.html:no-cache ", ""},
+			expected: []CacheControlRule{
+				{Patterns: []string{"*.css", "*.js"}, Value: "max-age=31536000, immutable"},
+				{Patterns: []string{"*.html"}, Value: "no-cache"},
+			},
+		},
+		"missing_value": {
+			cacheControl: []string{"*.css:"},
+			expectedErr:  ErrInvalidCacheControl,
+		},
+		"missing_separator": {
+			cacheControl: []string{"*.css max-age=60"},
+			expectedErr:  ErrInvalidCacheControl,
+		},
+		"missing_patterns": {
+			cacheControl: []string{" , : max-age=60"},
+			expectedErr:  ErrInvalidCacheControl,
+		},
+		"invalid_pattern": {
+			cacheControl: []string{"[*.css: max-age=60"},
+			expectedErr:  ErrInvalidCacheControl,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := General{CacheControl: tt.cacheControl}
+
+			rules, err := cfg.CacheControlRules()
+			if tt.expectedErr != nil {
+				require.True(t, errors.Is(err, tt.expectedErr))
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, rules)
+		})
+	}
+}
+
+func TestCacheControlRuleMatch(t *testing.T) {
+	rule := CacheControlRule{Patterns: []string{"*.css", "/assets/*"}}
+
+	require.True(t, rule.Match("style.css"))
+	require.True(t, rule.Match("nested/dir/style.css"), "patterns without a slash match the file name")
+	require.True(t, rule.Match("assets/logo.png"))
+	require.False(t, rule.Match("nested/assets/logo.png"), "patterns with a slash match the path in the project")
+	require.False(t, rule.Match("index.html"))
+}
+
+func TestHostnameSourceLabels(t *testing.T) {
+	tests := map[string]struct {
+		template    string
+		expected    []string
+		expectedErr error
+	}{
+		"group_and_project": {
+			template: "{project}.{group}.pages.local",
+			expected: []string{"{project}", "{group}", "pages", "local"},
+		},
+		"group_only": {
+			template: "{group}.Pages.Local",
+			expected: []string{"{group}", "pages", "local"},
+		},
+		"missing_group": {
+			template:    "{project}.pages.local",
+			expectedErr: ErrHostnameSourceInvalidTemplate,
+		},
+		"duplicated_group": {
+			template:    "{group}.{group}.pages.local",
+			expectedErr: ErrHostnameSourceInvalidTemplate,
+		},
+		"duplicated_project": {
+			template:    "{project}.{project}.{group}.pages.local",
+			expectedErr: ErrHostnameSourceInvalidTemplate,
+		},
+		"placeholder_not_a_full_label": {
+			template:    "{project}-{group}.pages.local",
+			expectedErr: ErrHostnameSourceInvalidTemplate,
+		},
+		"unknown_placeholder": {
+			template:    "{namespace}.{group}.pages.local",
+			expectedErr: ErrHostnameSourceInvalidTemplate,
+		},
+		"empty_label": {
+			template:    "{group}..pages.local",
+			expectedErr: ErrHostnameSourceInvalidTemplate,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := HostnameSource{Template: tt.template}
+
+			labels, err := cfg.Labels()
+			if tt.expectedErr != nil {
+				require.True(t, errors.Is(err, tt.expectedErr))
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, labels)
+		})
+	}
+}
+
+func TestParseExtensions(t *testing.T) {
+	require.Equal(t, []string{".pem", ".key", ".env"}, parseExtensions(" .PEM, key,,.env"))
+	require.Empty(t, parseExtensions(""))
+}
+
+func TestParseIndexFiles(t *testing.T) {
+	require.Equal(t, []string{"index.html", "Default.htm"}, parseIndexFiles(" index.html,,Default.htm "))
+	require.Empty(t, parseIndexFiles(""))
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"net/http"
 	"time"
 
 	"github.com/namsral/flag"
@@ -12,6 +13,14 @@ var (
 	pagesRootCert           = flag.String("root-cert", "", "The default path to file certificate to serve static pages")
 	pagesRootKey            = flag.String("root-key", "", "The default path to file certificate to serve static pages")
 	redirectHTTP            = flag.Bool("redirect-http", false, "Redirect pages from HTTP to HTTPS")
+	directoryRedirectStatus = flag.Int("directory-redirect-status", http.StatusMovedPermanently, "Status of the redirects of directories requested without a trailing slash: 301, 302, 307 or 308")
+	trailingSlash           = flag.String("trailing-slash", TrailingSlashPreserve, "Trailing slashes of the paths of directories and of pages served without their .html extension: 'preserve' to serve pages with and without a slash, 'always' to redirect to the path with a slash or 'never' to redirect to the path without a slash")
+	cleanURLs               = flag.Bool("clean-urls", false, "Serve /page from /page.html, then from /page/index.html, when /page is a directory, instead of redirecting to /page/. Can not be used with -trailing-slash=always")
+	directoryListing        = flag.Bool("directory-listing", false, "Serve a generated HTML index of the files of directories without an index.html, projects can also enable it with the GitLab API")
+	indexFiles              = flag.String("index-files", "index.html", "Comma separated list of the file names served for directories, in order of preference, e.g. index.html,index.htm,default.html. Projects can override it with the GitLab API")
+	dotfiles                = flag.String("dotfiles", DotfilesAllow, "How files and directories starting with a dot, like .git, are handled, except .well-known: 'allow' to serve them, 'ignore' to serve a 404 or 'deny' to serve a 403")
+	blockedExtensions       = flag.String("blocked-extensions", ".env,.key,.pem,.p12,.pfx,.php", "Comma separated list of file extensions which are never served, requests for them get a 404. Domains can opt out with the GitLab API, an empty list serves all the files")
+	redirectUncertified     = flag.Bool("redirect-uncertified-domains", false, "Redirect HTTP requests to custom domains without a certificate to the HTTPS URL of the project on the pages domain")
 	_                       = flag.Bool("use-http2", true, "DEPRECATED: HTTP2 is always enabled for pages")
 	pagesRoot               = flag.String("pages-root", "shared/pages", "The directory where pages are stored")
 	pagesDomain             = flag.String("pages-domain", "gitlab-example.com", "The domain to serve static pages")
@@ -19,10 +28,21 @@ var (
 	rateLimitSourceIPBurst  = flag.Int("rate-limit-source-ip-burst", 100, "Rate limit per source IP maximum burst allowed per second")
 	rateLimitDomain         = flag.Float64("rate-limit-domain", 0.0, "Rate limit per domain in number of requests per second, 0 means is disabled")
 	rateLimitDomainBurst    = flag.Int("rate-limit-domain-burst", 100, "Rate limit per domain maximum burst allowed per second")
-	artifactsServer         = flag.String("artifacts-server", "", "API URL to proxy artifact requests to, e.g.: 'https://gitlab.com/api/v4'")
+	rateLimitRedisURL       = flag.String("rate-limit-redis-url", "", "Redis URL to share the rate limits between instances, e.g.: 'redis://:password@localhost:6379/0'. Rate limits are kept in memory when empty")
+	rateLimitRedisTimeout   = flag.Duration("rate-limit-redis-timeout", 100*time.Millisecond, "Timeout of a Redis rate limit request, local rate limits are used when it is exceeded")
+	bandwidthConnection     = flag.Int64("bandwidth-limit-connection", 0, "Maximum bytes per second written to the responses of a connection, 0 means is disabled")
+	bandwidthDomain         = flag.Int64("bandwidth-limit-domain", 0, "Maximum bytes per second written to the responses of a domain, 0 means is disabled")
 	artifactsServerTimeout  = flag.Int("artifacts-server-timeout", 10, "Timeout (in seconds) for a proxied request to the artifacts server")
 	pagesStatus             = flag.String("pages-status", "", "The url path for a status page, e.g., /@status")
+	pagesDiagnostics        = flag.String("pages-diagnostics", "", "The url path for the custom domain diagnostics API authenticated with the api-secret-key, e.g., /@diagnostics")
+	gonePageFile            = flag.String("gone-page-file", "", "HTML file served with a 410 Gone status for the domains deleted from GitLab, a default page is served when empty")
+	pagesCacheDump          = flag.String("pages-cache-dump", "", "The url path for the dump of the domains and archives caches authenticated with the api-secret-key, e.g., /@cache")
+	cacheHandoffFile        = flag.String("cache-handoff-file", "", "Path of the file the cached domain configurations and authentication state are written to when Pages stops on SIGTERM or SIGINT, and read from on startup, so a restart does not retrieve every domain again")
 	metricsAddress          = flag.String("metrics-address", "", "The address to listen on for metrics requests")
+	metricsAuthTokenFile    = flag.String("metrics-auth-token-file", "", "File containing the bearer token required to request metrics")
+	metricsAuthUsername     = flag.String("metrics-auth-username", "", "Username required with basic auth to request metrics, used with metrics-auth-password-file")
+	metricsAuthPasswordFile = flag.String("metrics-auth-password-file", "", "File containing the password required with basic auth to request metrics")
+	metricsTenantLabels     = flag.Int("metrics-tenant-labels", 0, "The number of most requested domains and namespaces whose requests are counted with their own label, the others are counted with the 'other' label. 0 disables the per-domain metrics unless metrics-tenant-labels-allow is set")
 	sentryDSN               = flag.String("sentry-dsn", "", "The address for sending sentry crash reporting to")
 	sentryEnvironment       = flag.String("sentry-environment", "", "The environment for sentry crash reporting")
 	_                       = flag.Uint("daemon-uid", 0, "DEPRECATED and ignored, will be removed in 15.0")
@@ -30,6 +50,14 @@ var (
 	_                       = flag.Bool("daemon-enable-jail", false, "DEPRECATED and ignored, will be removed in 15.0")
 	_                       = flag.Bool("daemon-inplace-chroot", false, "DEPRECATED and ignored, will be removed in 15.0") // TODO: https://gitlab.com/gitlab-org/gitlab-pages/-/issues/599
 	propagateCorrelationID  = flag.Bool("propagate-correlation-id", false, "Reuse existing Correlation-ID from the incoming request header `X-Request-ID` if present")
+	deploymentHooks         = flag.Bool("enable-deployment-hooks", false, "Accept deployment webhooks authenticated with the api-secret-key on /-/hooks/deployment to refresh domains right after a deployment")
+	analyticsReportInterval = flag.Duration("analytics-report-interval", 0, "The interval at which per-domain access summaries are reported to the GitLab API, 0 disables reporting")
+	analyticsTopPaths       = flag.Int("analytics-top-paths", 10, "The number of most requested paths included in the access summary of a domain")
+	analyticsMaxDomains     = flag.Int("analytics-max-domains", 10000, "The maximum number of domains summarized per report interval, the requests to other domains are not counted")
+	microCacheTTL           = flag.Duration("micro-cache-ttl", 0, "The maximum time HTML responses are cached in memory for, e.g. 5s to absorb spikes of requests to a page, 0 disables the cache")
+	microCacheMaxSize       = flag.Int64("micro-cache-max-size", 64*1024, "The maximum size in bytes of the HTML responses cached in memory")
+	microCacheMaxEntries    = flag.Int64("micro-cache-max-entries", 1000, "The maximum number of HTML responses cached in memory")
+	microCacheStaleIfError  = flag.Duration("micro-cache-stale-if-error", 0, "The maximum time HTML responses are served from the memory cache after they expired, when serving them again fails with a 5xx error, 0 to never serve stale responses")
 	logFormat               = flag.String("log-format", "json", "The log output format: 'text' or 'json'")
 	logVerbose              = flag.Bool("log-verbose", false, "Verbose logging")
 	secret                  = flag.String("auth-secret", "", "Cookie store hash key, should be at least 32 bytes long")
@@ -41,26 +69,67 @@ var (
 	gitlabCacheExpiry       = flag.Duration("gitlab-cache-expiry", 10*time.Minute, "The maximum time a domain's configuration is stored in the cache")
 	gitlabCacheRefresh      = flag.Duration("gitlab-cache-refresh", time.Minute, "The interval at which a domain's configuration is set to be due to refresh")
 	gitlabCacheCleanup      = flag.Duration("gitlab-cache-cleanup", time.Minute, "The interval at which expired items are removed from the cache")
+	gitlabCacheMaxEntries   = flag.Int("gitlab-cache-max-entries", 0, "The maximum number of domains cached, the least recently used ones are evicted first, 0 for no limit")
 	gitlabRetrievalTimeout  = flag.Duration("gitlab-retrieval-timeout", 30*time.Second, "The maximum time to wait for a response from the GitLab API per request")
 	gitlabRetrievalInterval = flag.Duration("gitlab-retrieval-interval", time.Second, "The interval to wait before retrying to resolve a domain's configuration via the GitLab API")
 	gitlabRetrievalRetries  = flag.Int("gitlab-retrieval-retries", 3, "The maximum number of times to retry to resolve a domain's configuration via the API")
 
-	_          = flag.String("domain-config-source", "gitlab", "DEPRECATED and has not affect, see https://gitlab.com/gitlab-org/gitlab-pages/-/merge_requests/541")
-	enableDisk = flag.Bool("enable-disk", true, "Enable disk access, shall be disabled in environments where shared disk storage isn't available")
-
-	clientID           = flag.String("auth-client-id", "", "GitLab application Client ID")
-	clientSecret       = flag.String("auth-client-secret", "", "GitLab application Client Secret")
-	redirectURI        = flag.String("auth-redirect-uri", "", "GitLab application redirect URI")
-	authScope          = flag.String("auth-scope", "api", "Scope to be used for authentication (must match GitLab Pages OAuth application settings)")
-	maxConns           = flag.Int("max-conns", 0, "Limit on the number of concurrent connections to the HTTP, HTTPS or proxy listeners, 0 for no limit")
-	maxURILength       = flag.Int("max-uri-length", 1024, "Limit the length of URI, 0 for unlimited.")
-	insecureCiphers    = flag.Bool("insecure-ciphers", false, "Use default list of cipher suites, may contain insecure ones like 3DES and RC4")
-	tlsMinVersion      = flag.String("tls-min-version", "tls1.2", tls.FlagUsage("min"))
-	tlsMaxVersion      = flag.String("tls-max-version", "", tls.FlagUsage("max"))
+	internalGitLabServerSRV         = flag.String("internal-gitlab-server-srv", "", "DNS SRV record, e.g. _gitlab._tcp.gitlab.service.consul, whose targets serve the API of internal-gitlab-server, instead of its host")
+	internalGitLabServerSRVInterval = flag.Duration("internal-gitlab-server-srv-interval", 30*time.Second, "The interval at which internal-gitlab-server-srv is resolved again, and the time a failing target is not used for")
+
+	domainConfigSource = flag.String("domain-config-source", DomainSourceGitLab, "Source of the domains configuration: 'gitlab' for the GitLab API, or the hostname source when hostname-source-template is set, or 'auto' for the GitLab API falling back to the hostname source while the API is unhealthy")
+	enableDisk         = flag.Bool("enable-disk", true, "Enable disk access, shall be disabled in environments where shared disk storage isn't available")
+
+	requestFilterRules          = flag.String("request-filter-rules", "", "Path of a JSON file of rules matching the method, path, host and headers of requests to block, allow or rate limit them before they are served, for mitigating targeted abuse")
+	requestFilterReloadInterval = flag.Duration("request-filter-reload-interval", 30*time.Second, "The interval at which request-filter-rules is checked for changes and reloaded, 0 disables reloading")
+
+	hostnameSourceTemplate = flag.String("hostname-source-template", "", "Serve domains from pages-root without the GitLab API, deriving the group and project from hostnames matching this template, e.g. {project}.{group}.pages.local")
+
+	clientID                  = flag.String("auth-client-id", "", "GitLab application Client ID")
+	clientSecret              = flag.String("auth-client-secret", "", "GitLab application Client Secret")
+	redirectURI               = flag.String("auth-redirect-uri", "", "GitLab application redirect URI")
+	authScope                 = flag.String("auth-scope", "api", "Scope to be used for authentication (must match GitLab Pages OAuth application settings)")
+	authCookieName            = flag.String("auth-cookie-name", "gitlab-pages", "Name of the auth session cookie")
+	authTokenTimeout          = flag.Duration("auth-token-timeout", 5*time.Second, "Timeout of the requests to GitLab exchanging OAuth codes for access tokens")
+	authAccessCheckTimeout    = flag.Duration("auth-access-check-timeout", 5*time.Second, "Timeout of the requests to GitLab checking the access of users to projects")
+	authCodeExpiry            = flag.Duration("auth-code-expiry", time.Minute, "Time during which the signed OAuth codes handed to custom domains can be exchanged for a token, each code can only be exchanged once by each instance")
+	authQueryToken            = flag.Bool("auth-query-token", false, "Issue tokens with POST requests to /auth/handoff authenticating the clients which can not follow the OAuth flow with cookies, like some embedded webviews, through the Authorization header or once through the pages_token query parameter")
+	authAPIRetries            = flag.Int("auth-api-retries", 0, "Number of times the access checks of the authentication are retried after network errors and 502, 503 or 504 responses, the OAuth code exchange is never retried")
+	authProxy                 = flag.String("auth-proxy", "", "URL of the http, https or socks5 forward proxy of the authentication requests to GitLab, hosts listed in NO_PROXY are not proxied. The HTTPS_PROXY and HTTP_PROXY environment variables are used when empty")
+	authCookieScope           = flag.String("auth-cookie-scope", "host", "Scope of the auth session cookie: 'host' for a cookie per host, 'pages-domain' to share it between the subdomains of the pages domain or 'host-prefix' for a cookie per host with the __Host- prefix on HTTPS")
+	maxConns                  = flag.Int("max-conns", 0, "Limit on the number of concurrent connections to the HTTP, HTTPS or proxy listeners, 0 for no limit")
+	maxURILength              = flag.Int("max-uri-length", 1024, "Limit the length of URI, 0 for unlimited.")
+	allowedHTTPMethods        = flag.String("allowed-http-methods", "GET,HEAD,OPTIONS", "Comma separated list of HTTP methods that are served, other methods get a 405 Method Not Allowed response")
+	serverHeader              = flag.String("server-header", "", "Value of the Server header of the responses, the header is not sent when empty. The X-Powered-By header is never sent")
+	projectHeaders            = flag.Bool("project-headers", false, "Add X-Gitlab-Pages-Project-Id and X-Gitlab-Pages-Namespace headers to the responses of projects, for the logging and WAF layers of trusted networks, which must remove them before the responses reach clients")
+	forceNoIndex              = flag.Bool("force-noindex", false, "Add an 'X-Robots-Tag: noindex, nofollow' header to every response, for staging or disaster recovery instances which must never be indexed by search engines")
+	insecureCiphers           = flag.Bool("insecure-ciphers", false, "Use default list of cipher suites, may contain insecure ones like 3DES and RC4")
+	tlsMinVersion             = flag.String("tls-min-version", "tls1.2", tls.FlagUsage("min"))
+	tlsMaxVersion             = flag.String("tls-max-version", "", tls.FlagUsage("max"))
+	tlsInvalidCertPolicy      = flag.String("tls-invalid-cert-policy", TLSInvalidCertificateServe, "What to do when the certificate of a custom domain is self-signed or expired: 'serve' it anyway, serve the 'wildcard' certificate of the pages domain or 'reject' the handshake. Certificates which fail to load fall back to the wildcard certificate unless the policy is 'reject'")
+	http2MaxConcurrentStreams = flag.Uint("http2-max-concurrent-streams", 250, "Maximum number of concurrent HTTP/2 streams per connection")
+	http2MaxReadFrameSize     = flag.Uint("http2-max-read-frame-size", 1<<20, "Maximum size in bytes of the HTTP/2 frames read from clients, between 16384 and 16777215")
+	http2IdleTimeout          = flag.Duration("http2-idle-timeout", 0, "Timeout after which idle HTTP/2 connections are closed, 0 means no timeout")
+	shutdownTimeout           = flag.Duration("shutdown-timeout", 30*time.Second, "Maximum time the requests in flight are waited for when Pages stops gracefully, with -cache-handoff-file or as a Windows service")
+	writeTimeout              = flag.Duration("write-timeout", 0, "Maximum time each write of a response to a client can take, so clients reading too slowly are disconnected while large files are still served to clients reading fast enough. 0 means no timeout")
+
 	zipCacheExpiration = flag.Duration("zip-cache-expiration", 60*time.Second, "Zip serving archive cache expiration interval")
 	zipCacheCleanup    = flag.Duration("zip-cache-cleanup", 30*time.Second, "Zip serving archive cache cleanup interval")
 	zipCacheRefresh    = flag.Duration("zip-cache-refresh", 30*time.Second, "Zip serving archive cache refresh interval")
 	zipOpenTimeout     = flag.Duration("zip-open-timeout", 30*time.Second, "Zip archive open timeout")
+	zipReadAheadChunk  = flag.Int64("zip-read-ahead-chunk-size", 0, "Size in bytes of the chunks fetched ahead when serving large files from zip archives, 0 to disable read-ahead")
+	zipReadAheadChunks = flag.Int("zip-read-ahead-max-prefetch", 0, "Maximum number of chunks fetched ahead when serving large files from zip archives, 0 to disable read-ahead")
+	zipReadAheadMin    = flag.Int64("zip-read-ahead-min-size", 0, "Minimum size in bytes of the files read ahead, smaller files are fetched with a single request")
+	zipReadAheadLimit  = flag.Int("zip-read-ahead-concurrency", 0, "Maximum number of chunks fetched concurrently for all the files read ahead, 0 for no limit")
+
+	zipFileCacheSize        = flag.Int64("zip-file-cache-size", 32*1024*1024, "Maximum size in bytes of the decompressed files of zip archives kept in memory, so the small files requested often are not read and inflated again, 0 to disable")
+	zipFileCacheMaxFileSize = flag.Int64("zip-file-cache-max-file-size", 64*1024, "Maximum size in bytes of the decompressed files of zip archives kept in memory")
+
+	zipShadowSampleRate = flag.Float64("zip-shadow-sample-rate", 0, "Fraction between 0 and 1 of the requests served from disk which are served again in the background from the public.zip archive next to their public directory to compare the responses, 0 to disable")
+
+	objectStorageCAFile             = flag.String("object-storage-ca-file", "", "Path to a PEM file with the CA certificates of object storage, trusted in addition to the system certificates, e.g. for private S3 or MinIO endpoints")
+	objectStorageInsecureSkipVerify = flag.Bool("object-storage-insecure-skip-verify", false, "Do not verify the certificates of object storage, for test environments only")
+	objectStorageProxy              = flag.String("object-storage-proxy", "", "URL of the http, https or socks5 forward proxy of the requests to object storage and the GitLab API, hosts listed in NO_PROXY are not proxied. The HTTPS_PROXY and HTTP_PROXY environment variables are used when empty")
 
 	disableCrossOriginRequests = flag.Bool("disable-cross-origin-requests", false, "Disable cross-origin requests")
 
@@ -71,8 +140,27 @@ var (
 	listenHTTPS        = MultiStringFlag{separator: ","}
 	listenProxy        = MultiStringFlag{separator: ","}
 	listenHTTPSProxyv2 = MultiStringFlag{separator: ","}
+	listenHTTPAndHTTPS = MultiStringFlag{separator: ","}
+
+	header       = MultiStringFlag{separator: ";;"}
+	cacheControl = MultiStringFlag{separator: ";;"}
+
+	tlsECHKeys = MultiStringFlag{separator: ","}
+
+	egressAllowlist = MultiStringFlag{separator: ","}
+	trustedProxies  = MultiStringFlag{separator: ","}
+	artifactsServer = MultiStringFlag{separator: ","}
+
+	redirectHTTPExclude = MultiStringFlag{separator: ","}
+
+	featureRollout = MultiStringFlag{separator: ","}
+
+	instanceConfigs = MultiStringFlag{separator: ","}
+
+	metricsAllowedIPs = MultiStringFlag{separator: ","}
 
-	header = MultiStringFlag{separator: ";;"}
+	metricsTenantLabelsAllow = MultiStringFlag{separator: ","}
+	metricsTenantLabelsDeny  = MultiStringFlag{separator: ","}
 )
 
 // initFlags will be called from LoadConfig
@@ -81,7 +169,19 @@ func initFlags() {
 	flag.Var(&listenHTTPS, "listen-https", "The address(es) to listen on for HTTPS requests")
 	flag.Var(&listenProxy, "listen-proxy", "The address(es) to listen on for proxy requests")
 	flag.Var(&listenHTTPSProxyv2, "listen-https-proxyv2", "The address(es) to listen on for HTTPS PROXYv2 requests (https://www.haproxy.org/download/1.8/doc/proxy-protocol.txt)")
-	flag.Var(&header, "header", "The additional http header(s) that should be send to the client")
+	flag.Var(&listenHTTPAndHTTPS, "listen-http-https", "The address(es) to listen on for both HTTP and HTTPS requests, told apart by the first byte sent by clients")
+	flag.Var(&cacheControl, "cache-control", "The Cache-Control value of the public files matching glob patterns, e.g. '*.css,*.js: max-age=31536000, immutable', the first matching rule applies. Patterns with a slash match the path of the file in the project. Files matching no rule are cached for 10 minutes")
+	flag.Var(&header, "header", "The additional http header(s) that should be send to the client, -Name removes a header and a [http,https,proxy,pages,custom] prefix scopes it to listeners and domain classes")
+	flag.Var(&artifactsServer, "artifacts-server", "API URL(s) to proxy artifact requests to, e.g.: 'https://gitlab.com/api/v4', optionally followed by a ';weight=N' to spread requests across several servers and a ';proxy-protocol=v2' to send the address of the client with the PROXY protocol")
+	flag.Var(&redirectHTTPExclude, "redirect-http-exclude", "Path prefixes served over HTTP when redirect-http is enabled, e.g. /healthz. ACME challenges are never redirected")
+	flag.Var(&trustedProxies, "trusted-proxies", "IP addresses or CIDR ranges of the reverse proxies in front of the HTTP and HTTPS listeners whose X-Forwarded-Host and Forwarded headers are used to build redirect URLs")
+	flag.Var(&egressAllowlist, "egress-allowlist", "Host names, *.wildcard domains, IP addresses or CIDR ranges the artifacts server and object storage URLs must match, any host is allowed when empty. Link-local and metadata addresses are always blocked")
+	flag.Var(&metricsAllowedIPs, "metrics-allowed-ips", "IP addresses or CIDR ranges of the clients allowed to request metrics, any client is allowed when empty")
+	flag.Var(&metricsTenantLabelsAllow, "metrics-tenant-labels-allow", "Domains and namespaces whose requests are always counted with their own label")
+	flag.Var(&metricsTenantLabelsDeny, "metrics-tenant-labels-deny", "Domains and namespaces whose requests are always counted with the 'other' label")
+	flag.Var(&featureRollout, "feature-rollout", "Features enabled for a percentage of the domains, as name=percentage pairs, e.g. redirects_placeholders=10. The GitLab API and FF_* environment variables take precedence")
+	flag.Var(&instanceConfigs, "instance-config", "Path(s) to the config file(s) of virtual instances serving other pages domains from the same listeners, which can set pages-domain, the GitLab server and API secret, auth-*, rate-limit-* and the root certificate")
+	flag.Var(&tlsECHKeys, "tls-ech-key", "EXPERIMENTAL: path(s) to PEM file(s) with an X25519 PRIVATE KEY and its ECHCONFIG to enable Encrypted Client Hello, the first key is advertised to clients and the others are only used to decrypt during key rotation")
 
 	// read from -config=/path/to/gitlab-pages-config
 	flag.String(flag.DefaultConfigFlagname, "", "path to config file")
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/namsral/flag"
+)
+
+// loadInstances loads the configuration of the virtual instances from the
+// files at paths, see loadInstance
+func loadInstances(main *Config, paths []string) ([]*Config, error) {
+	instances := make([]*Config, 0, len(paths))
+
+	for _, path := range paths {
+		instance, err := loadInstance(main, path)
+		if err != nil {
+			return nil, fmt.Errorf("loading instance config %q: %w", path, err)
+		}
+
+		instances = append(instances, instance)
+	}
+
+	return instances, nil
+}
+
+// loadInstance returns the configuration of a virtual instance serving
+// another pages domain from the same listeners. It is a copy of main with the
+// settings of the file at path, which uses the same format as -config and can
+// only set the pages domain, the GitLab API, authentication, rate limits and
+// the wildcard certificate. Authentication and the wildcard certificate are
+// not inherited from main, as they are specific to the pages domain.
+func loadInstance(main *Config, path string) (*Config, error) {
+	fs := flag.NewFlagSet("instance", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	var (
+		domain         = fs.String("pages-domain", "", "")
+		publicServer   = fs.String("gitlab-server", main.GitLab.PublicServer, "")
+		internalServer = fs.String("internal-gitlab-server", "", "")
+		apiSecretKey   = fs.String("api-secret-key", "", "")
+		rootCert       = fs.String("root-cert", "", "")
+		rootKey        = fs.String("root-key", "", "")
+		clientID       = fs.String("auth-client-id", "", "")
+		clientSecret   = fs.String("auth-client-secret", "", "")
+		secret         = fs.String("auth-secret", "", "")
+		redirectURI    = fs.String("auth-redirect-uri", "", "")
+		scope          = fs.String("auth-scope", main.Authentication.Scope, "")
+		sourceIP       = fs.Float64("rate-limit-source-ip", main.RateLimit.SourceIPLimitPerSecond, "")
+		sourceIPBurst  = fs.Int("rate-limit-source-ip-burst", main.RateLimit.SourceIPBurst, "")
+		domainLimit    = fs.Float64("rate-limit-domain", main.RateLimit.DomainLimitPerSecond, "")
+		domainBurst    = fs.Int("rate-limit-domain-burst", main.RateLimit.DomainBurst, "")
+		redisURL       = fs.String("rate-limit-redis-url", main.RateLimit.RedisURL, "")
+	)
+
+	if err := fs.ParseFile(path); err != nil {
+		return nil, err
+	}
+
+	instance := main.clone()
+
+	instance.General.Domain = strings.ToLower(*domain)
+	instance.General.RootCertificate = nil
+	instance.General.RootKey = nil
+
+	// domains are only derived from their hostname for the main instance
+	instance.HostnameSource = HostnameSource{Mode: DomainSourceGitLab}
+
+	// the internal server of main is inherited with its GitLab server
+	if *publicServer != main.GitLab.PublicServer || *internalServer != "" {
+		instance.GitLab.PublicServer = *publicServer
+		instance.GitLab.InternalServer = *internalServer
+		if instance.GitLab.InternalServer == "" {
+			instance.GitLab.InternalServer = *publicServer
+		}
+		instance.GitLab.InternalServerSRV = ""
+	}
+
+	if *apiSecretKey != "" {
+		key, err := ReadGitLabAPISecretKey(*apiSecretKey)
+		if err != nil {
+			return nil, err
+		}
+
+		instance.GitLab.APISecretKey = key
+	}
+
+	for _, file := range []struct {
+		contents *[]byte
+		path     string
+	}{
+		{&instance.General.RootCertificate, *rootCert},
+		{&instance.General.RootKey, *rootKey},
+	} {
+		if file.path != "" {
+			contents, err := os.ReadFile(file.path)
+			if err != nil {
+				return nil, err
+			}
+
+			*file.contents = contents
+		}
+	}
+
+	instance.Authentication.ClientID = *clientID
+	instance.Authentication.ClientSecret = *clientSecret
+	instance.Authentication.Secret = *secret
+	instance.Authentication.RedirectURI = *redirectURI
+	instance.Authentication.Scope = *scope
+
+	instance.RateLimit.SourceIPLimitPerSecond = *sourceIP
+	instance.RateLimit.SourceIPBurst = *sourceIPBurst
+	instance.RateLimit.DomainLimitPerSecond = *domainLimit
+	instance.RateLimit.DomainBurst = *domainBurst
+	instance.RateLimit.RedisURL = *redisURL
+
+	return instance, nil
+}
+
+// clone returns a copy of c that shares no slices with it, so that settings
+// changed on a virtual instance never leak into main. The copy has no
+// listeners or instances of its own, as these belong to main.
+func (c *Config) clone() *Config {
+	clone := *c
+	clone.Instances = nil
+	clone.Listeners = Listeners{}
+	clone.ListenHTTPStrings = MultiStringFlag{}
+	clone.ListenHTTPSStrings = MultiStringFlag{}
+	clone.ListenProxyStrings = MultiStringFlag{}
+	clone.ListenHTTPSProxyv2Strings = MultiStringFlag{}
+	clone.ListenHTTPAndHTTPSStrings = MultiStringFlag{}
+
+	clone.General.RootCertificate = cloneBytes(c.General.RootCertificate)
+	clone.General.RootKey = cloneBytes(c.General.RootKey)
+	clone.General.GonePage = cloneBytes(c.General.GonePage)
+	clone.General.RedirectHTTPExclude = cloneStrings(c.General.RedirectHTTPExclude)
+	clone.General.IndexFiles = cloneStrings(c.General.IndexFiles)
+	clone.General.BlockedExtensions = cloneStrings(c.General.BlockedExtensions)
+	clone.General.CacheControl = cloneStrings(c.General.CacheControl)
+	clone.General.CustomHeaders = cloneStrings(c.General.CustomHeaders)
+	clone.General.AllowedHTTPMethods = cloneStrings(c.General.AllowedHTTPMethods)
+	clone.General.EgressAllowlist = cloneStrings(c.General.EgressAllowlist)
+	clone.General.TrustedProxies = cloneStrings(c.General.TrustedProxies)
+	clone.General.FeatureRollouts = cloneStrings(c.General.FeatureRollouts)
+	clone.ArtifactsServer.URLs = cloneStrings(c.ArtifactsServer.URLs)
+	clone.GitLab.APISecretKey = cloneBytes(c.GitLab.APISecretKey)
+	clone.Metrics.Token = cloneBytes(c.Metrics.Token)
+	clone.Metrics.Password = cloneBytes(c.Metrics.Password)
+	clone.Metrics.AllowedIPs = cloneStrings(c.Metrics.AllowedIPs)
+	clone.Metrics.TenantLabelsAllow = cloneStrings(c.Metrics.TenantLabelsAllow)
+	clone.Metrics.TenantLabelsDeny = cloneStrings(c.Metrics.TenantLabelsDeny)
+	clone.Zip.AllowedPaths = cloneStrings(c.Zip.AllowedPaths)
+	clone.Zip.CACertificates = cloneBytes(c.Zip.CACertificates)
+
+	if c.TLS.ECHKeys != nil {
+		clone.TLS.ECHKeys = make([][]byte, 0, len(c.TLS.ECHKeys))
+		for _, key := range c.TLS.ECHKeys {
+			clone.TLS.ECHKeys = append(clone.TLS.ECHKeys, cloneBytes(key))
+		}
+	}
+
+	return &clone
+}
+
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+
+	return append([]byte{}, b...)
+}
+
+func cloneStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+
+	return append([]string{}, s...)
+}
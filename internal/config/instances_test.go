@@ -0,0 +1,92 @@
+package config
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadInstance(t *testing.T) {
+	dir := t.TempDir()
+
+	secretFile := filepath.Join(dir, "secret")
+	require.NoError(t, os.WriteFile(secretFile, []byte(base64.StdEncoding.EncodeToString([]byte(strings.Repeat("s", 32)))), 0600))
+
+	main := validConfig()
+	main.General.Domain = "gitlab.io"
+	main.GitLab.InternalServer = "https://gitlab.internal"
+	main.GitLab.APISecretKey = []byte("main secret")
+	main.RateLimit.SourceIPLimitPerSecond = 10
+	main.RateLimit.DomainLimitPerSecond = 100
+
+	write := func(t *testing.T, content string) string {
+		t.Helper()
+
+		path := filepath.Join(dir, "instance.conf")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+		return path
+	}
+
+	t.Run("overrides", func(t *testing.T) {
+		instance, err := loadInstance(&main, write(t, strings.Join([]string{
+			"# staging",
+			"pages-domain=Staging.gitlab.io",
+			"gitlab-server=https://staging.gitlab.com",
+			"api-secret-key=" + secretFile,
+			"auth-client-id=staging-id",
+			"rate-limit-domain=5",
+		}, "\n")))
+		require.NoError(t, err)
+
+		require.Equal(t, "staging.gitlab.io", instance.General.Domain)
+		require.Equal(t, "https://staging.gitlab.com", instance.GitLab.PublicServer)
+		require.Equal(t, "https://staging.gitlab.com", instance.GitLab.InternalServer)
+		secret, err := ReadGitLabAPISecretKey(secretFile)
+		require.NoError(t, err)
+		require.Equal(t, secret, instance.GitLab.APISecretKey)
+		require.Equal(t, "staging-id", instance.Authentication.ClientID)
+		require.Empty(t, instance.Authentication.Secret, "authentication is not inherited")
+		require.Equal(t, float64(5), instance.RateLimit.DomainLimitPerSecond)
+		require.Equal(t, float64(10), instance.RateLimit.SourceIPLimitPerSecond, "rate limits are inherited")
+		require.Equal(t, "gitlab.io", main.General.Domain, "the main config is not modified")
+	})
+
+	t.Run("inherited_gitlab_server", func(t *testing.T) {
+		instance, err := loadInstance(&main, write(t, "pages-domain=other.example.com"))
+		require.NoError(t, err)
+
+		require.Equal(t, main.GitLab.PublicServer, instance.GitLab.PublicServer)
+		require.Equal(t, "https://gitlab.internal", instance.GitLab.InternalServer)
+		require.Equal(t, []byte("main secret"), instance.GitLab.APISecretKey)
+	})
+
+	t.Run("shares_no_slices", func(t *testing.T) {
+		main := main
+		main.General.CustomHeaders = []string{"X-Main: true"}
+
+		instance, err := loadInstance(&main, write(t, "pages-domain=other.example.com"))
+		require.NoError(t, err)
+
+		instance.General.CustomHeaders[0] = "X-Instance: true"
+		instance.GitLab.APISecretKey[0] = 'M'
+
+		require.Equal(t, []string{"X-Main: true"}, main.General.CustomHeaders)
+		require.Equal(t, []byte("main secret"), main.GitLab.APISecretKey)
+		require.Empty(t, instance.Listeners.Addresses)
+	})
+
+	t.Run("unknown_setting", func(t *testing.T) {
+		_, err := loadInstance(&main, write(t, "pages-domain=other.example.com\nlisten-http=:80"))
+		require.Error(t, err)
+	})
+
+	t.Run("missing_file", func(t *testing.T) {
+		_, err := loadInstances(&main, []string{filepath.Join(dir, "missing.conf")})
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,141 @@
+//go:build go1.24
+// +build go1.24
+
+package tls
+
+import (
+	"crypto/ecdh"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+const (
+	echPrivateKeyBlock = "PRIVATE KEY"
+	echConfigBlock     = "ECHCONFIG"
+)
+
+// ConfigureECH enables Encrypted Client Hello for the given keys. Each key is
+// a PEM file holding a PKCS#8 X25519 "PRIVATE KEY" and the matching
+// "ECHCONFIG" list. Only the configs of the first key are sent to clients as
+// retry configs, the rest are kept to decrypt hellos during a key rotation.
+func ConfigureECH(tlsConfig *tls.Config, keys [][]byte) error {
+	echKeys, err := parseECHKeys(keys)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig.EncryptedClientHelloKeys = echKeys
+
+	return nil
+}
+
+// ValidateECHKeys returns an error if any of the ECH keys can't be parsed
+func ValidateECHKeys(keys [][]byte) error {
+	_, err := parseECHKeys(keys)
+	return err
+}
+
+func parseECHKeys(keys [][]byte) ([]tls.EncryptedClientHelloKey, error) {
+	var echKeys []tls.EncryptedClientHelloKey
+
+	for i, key := range keys {
+		privateKey, configs, err := parseECHKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ECH key #%d: %w", i+1, err)
+		}
+
+		for _, config := range configs {
+			echKeys = append(echKeys, tls.EncryptedClientHelloKey{
+				Config:      config,
+				PrivateKey:  privateKey,
+				SendAsRetry: i == 0,
+			})
+		}
+	}
+
+	return echKeys, nil
+}
+
+func parseECHKey(data []byte) ([]byte, [][]byte, error) {
+	var privateKey []byte
+	var configs [][]byte
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		var err error
+		switch block.Type {
+		case echPrivateKeyBlock:
+			privateKey, err = parseECHPrivateKey(block.Bytes)
+		case echConfigBlock:
+			configs, err = splitECHConfigList(block.Bytes)
+		}
+
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if privateKey == nil {
+		return nil, nil, fmt.Errorf("missing %s PEM block", echPrivateKeyBlock)
+	}
+	if len(configs) == 0 {
+		return nil, nil, fmt.Errorf("missing %s PEM block", echConfigBlock)
+	}
+
+	return privateKey, configs, nil
+}
+
+func parseECHPrivateKey(der []byte) ([]byte, error) {
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	ecdhKey, ok := key.(*ecdh.PrivateKey)
+	if !ok || ecdhKey.Curve() != ecdh.X25519() {
+		return nil, errors.New("ECH private key must be an X25519 key")
+	}
+
+	return ecdhKey.Bytes(), nil
+}
+
+// splitECHConfigList splits an ECHConfigList into the serialized ECHConfig
+// structures, as expected by tls.EncryptedClientHelloKey
+func splitECHConfigList(list []byte) ([][]byte, error) {
+	errMalformed := errors.New("malformed ECHConfigList")
+
+	if len(list) < 2 || int(binary.BigEndian.Uint16(list)) != len(list)-2 {
+		return nil, errMalformed
+	}
+
+	var configs [][]byte
+	for rest := list[2:]; len(rest) > 0; {
+		// every ECHConfig starts with a 2 bytes version and a 2 bytes length
+		if len(rest) < 4 {
+			return nil, errMalformed
+		}
+
+		size := 4 + int(binary.BigEndian.Uint16(rest[2:]))
+		if len(rest) < size {
+			return nil, errMalformed
+		}
+
+		configs = append(configs, rest[:size])
+		rest = rest[size:]
+	}
+
+	if len(configs) == 0 {
+		return nil, errMalformed
+	}
+
+	return configs, nil
+}
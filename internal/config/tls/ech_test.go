@@ -0,0 +1,134 @@
+//go:build go1.24
+// +build go1.24
+
+package tls
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigureECH(t *testing.T) {
+	current, currentConfigList := generateECHKey(t, 1)
+	previous, _ := generateECHKey(t, 2)
+
+	tlsConfig, err := Create(cert, key, getCertificate, false, tls.VersionTLS13, 0)
+	require.NoError(t, err)
+	require.NoError(t, ConfigureECH(tlsConfig, [][]byte{current, previous}))
+
+	require.Len(t, tlsConfig.EncryptedClientHelloKeys, 2)
+	require.True(t, tlsConfig.EncryptedClientHelloKeys[0].SendAsRetry)
+	require.False(t, tlsConfig.EncryptedClientHelloKeys[1].SendAsRetry)
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	go tls.Server(serverConn, tlsConfig).Handshake()
+
+	client := tls.Client(clientConn, &tls.Config{
+		ServerName:                     "example.com",
+		InsecureSkipVerify:             true,
+		MinVersion:                     tls.VersionTLS13,
+		EncryptedClientHelloConfigList: currentConfigList,
+	})
+	require.NoError(t, client.Handshake())
+	require.True(t, client.ConnectionState().ECHAccepted)
+}
+
+func TestValidateECHKeys(t *testing.T) {
+	valid, configList := generateECHKey(t, 1)
+	privateKey, _ := pem.Decode(valid)
+
+	p256Key, err := ecdh.P256().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	p256DER, err := x509.MarshalPKCS8PrivateKey(p256Key)
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		keys [][]byte
+		err  string
+	}{
+		"no_keys": {},
+		"valid_key": {
+			keys: [][]byte{valid},
+		},
+		"missing_config": {
+			keys: [][]byte{pem.EncodeToMemory(privateKey)},
+			err:  "invalid ECH key #1: missing ECHCONFIG PEM block",
+		},
+		"missing_private_key": {
+			keys: [][]byte{pem.EncodeToMemory(&pem.Block{Type: echConfigBlock, Bytes: configList})},
+			err:  "invalid ECH key #1: missing PRIVATE KEY PEM block",
+		},
+		"malformed_config_list": {
+			keys: [][]byte{valid, append(pem.EncodeToMemory(privateKey), pem.EncodeToMemory(&pem.Block{Type: echConfigBlock, Bytes: []byte{0, 4, 0xfe, 0x0d}})...)},
+			err:  "invalid ECH key #2: malformed ECHConfigList",
+		},
+		"not_a_x25519_key": {
+			keys: [][]byte{pem.EncodeToMemory(&pem.Block{Type: echPrivateKeyBlock, Bytes: p256DER})},
+			err:  "invalid ECH key #1: ECH private key must be an X25519 key",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateECHKeys(tc.keys)
+			if tc.err == "" {
+				require.NoError(t, err)
+				return
+			}
+
+			require.EqualError(t, err, tc.err)
+		})
+	}
+}
+
+// generateECHKey returns a PEM encoded ECH key and the ECHConfigList clients
+// should use to encrypt their hello
+func generateECHKey(t *testing.T, configID uint8) ([]byte, []byte) {
+	t.Helper()
+
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+
+	publicKey := privateKey.PublicKey().Bytes()
+	publicName := "public.example.com"
+
+	var contents []byte
+	contents = append(contents, configID)
+	contents = binary.BigEndian.AppendUint16(contents, 0x0020) // DHKEM(X25519, HKDF-SHA256)
+	contents = binary.BigEndian.AppendUint16(contents, uint16(len(publicKey)))
+	contents = append(contents, publicKey...)
+	contents = binary.BigEndian.AppendUint16(contents, 4)
+	contents = binary.BigEndian.AppendUint16(contents, 0x0001) // HKDF-SHA256
+	contents = binary.BigEndian.AppendUint16(contents, 0x0001) // AES-128-GCM
+	contents = append(contents, 0, uint8(len(publicName)))
+	contents = append(contents, publicName...)
+	contents = binary.BigEndian.AppendUint16(contents, 0) // no extensions
+
+	var config []byte
+	config = binary.BigEndian.AppendUint16(config, 0xfe0d)
+	config = binary.BigEndian.AppendUint16(config, uint16(len(contents)))
+	config = append(config, contents...)
+
+	var configList []byte
+	configList = binary.BigEndian.AppendUint16(configList, uint16(len(config)))
+	configList = append(configList, config...)
+
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: echPrivateKeyBlock, Bytes: der})
+	pemKey = append(pemKey, pem.EncodeToMemory(&pem.Block{Type: echConfigBlock, Bytes: configList})...)
+
+	return pemKey, configList
+}
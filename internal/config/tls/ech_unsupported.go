@@ -0,0 +1,27 @@
+//go:build !go1.24
+// +build !go1.24
+
+package tls
+
+import (
+	"crypto/tls"
+	"errors"
+)
+
+var errECHNotSupported = errors.New("ECH requires gitlab-pages to be built with Go 1.24 or newer")
+
+// ConfigureECH returns an error when ECH keys are given because Encrypted
+// Client Hello is not supported by this Go version
+func ConfigureECH(tlsConfig *tls.Config, keys [][]byte) error {
+	return ValidateECHKeys(keys)
+}
+
+// ValidateECHKeys returns an error when ECH keys are given because Encrypted
+// Client Hello is not supported by this Go version
+func ValidateECHKeys(keys [][]byte) error {
+	if len(keys) > 0 {
+		return errECHNotSupported
+	}
+
+	return nil
+}
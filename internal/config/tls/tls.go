@@ -10,6 +10,9 @@ import (
 // GetCertificateFunc returns the certificate to be used for given domain
 type GetCertificateFunc func(*tls.ClientHelloInfo) (*tls.Certificate, error)
 
+// GetConfigForClientFunc returns the tls.Config to be used for given domain
+type GetConfigForClientFunc func(*tls.ClientHelloInfo) (*tls.Config, error)
+
 var (
 	preferredCipherSuites = []uint16{
 		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
@@ -83,6 +86,30 @@ func ValidateTLSVersions(min, max string) error {
 	return nil
 }
 
+// OverrideConfig returns a copy of base with domain specific overrides applied.
+// minVersion can only make the config stricter: it is ignored when it is lower
+// than the instance minimum or higher than the instance maximum.
+// disableHTTP2 removes "h2" from the advertised protocols.
+func OverrideConfig(base *tls.Config, minVersion uint16, disableHTTP2 bool) *tls.Config {
+	config := base.Clone()
+
+	if minVersion > config.MinVersion && (config.MaxVersion == 0 || minVersion <= config.MaxVersion) {
+		config.MinVersion = minVersion
+	}
+
+	if disableHTTP2 {
+		nextProtos := make([]string, 0, len(config.NextProtos))
+		for _, proto := range config.NextProtos {
+			if proto != "h2" {
+				nextProtos = append(nextProtos, proto)
+			}
+		}
+		config.NextProtos = nextProtos
+	}
+
+	return config
+}
+
 func configureCertificate(tlsConfig *tls.Config, cert, key []byte) error {
 	certificate, err := tls.X509KeyPair(cert, key)
 	if err != nil {
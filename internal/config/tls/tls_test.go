@@ -69,3 +69,62 @@ func TestCreate(t *testing.T) {
 	require.Equal(t, uint16(tls.VersionTLS11), tlsConfig.MinVersion)
 	require.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MaxVersion)
 }
+
+func TestOverrideConfig(t *testing.T) {
+	tests := map[string]struct {
+		baseMin            uint16
+		baseMax            uint16
+		minVersion         uint16
+		disableHTTP2       bool
+		expectedMin        uint16
+		expectedNextProtos []string
+	}{
+		"no_overrides": {
+			baseMin:            tls.VersionTLS12,
+			expectedMin:        tls.VersionTLS12,
+			expectedNextProtos: []string{"http/1.1", "h2"},
+		},
+		"raise_min_version": {
+			baseMin:            tls.VersionTLS12,
+			minVersion:         tls.VersionTLS13,
+			expectedMin:        tls.VersionTLS13,
+			expectedNextProtos: []string{"http/1.1", "h2"},
+		},
+		"lower_min_version_is_ignored": {
+			baseMin:            tls.VersionTLS13,
+			minVersion:         tls.VersionTLS12,
+			expectedMin:        tls.VersionTLS13,
+			expectedNextProtos: []string{"http/1.1", "h2"},
+		},
+		"min_version_above_max_is_ignored": {
+			baseMin:            tls.VersionTLS11,
+			baseMax:            tls.VersionTLS12,
+			minVersion:         tls.VersionTLS13,
+			expectedMin:        tls.VersionTLS11,
+			expectedNextProtos: []string{"http/1.1", "h2"},
+		},
+		"disable_http2": {
+			baseMin:            tls.VersionTLS12,
+			disableHTTP2:       true,
+			expectedMin:        tls.VersionTLS12,
+			expectedNextProtos: []string{"http/1.1"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			base, err := Create(cert, key, getCertificate, false, tc.baseMin, tc.baseMax)
+			require.NoError(t, err)
+			base.NextProtos = []string{"http/1.1", "h2"}
+
+			config := OverrideConfig(base, tc.minVersion, tc.disableHTTP2)
+			require.Equal(t, tc.expectedMin, config.MinVersion)
+			require.Equal(t, tc.baseMax, config.MaxVersion)
+			require.Equal(t, tc.expectedNextProtos, config.NextProtos)
+
+			// the instance config must not be modified
+			require.Equal(t, tc.baseMin, base.MinVersion)
+			require.Equal(t, []string{"http/1.1", "h2"}, base.NextProtos)
+		})
+	}
+}
@@ -1,12 +1,20 @@
 package config
 
 import (
+	cryptotls "crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
+	"math"
+	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/hashicorp/go-multierror"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/config/tls"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/egress"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/forwarded"
 )
 
 var (
@@ -16,10 +24,71 @@ var (
 	ErrAuthNoClientSecret               = errors.New("auth-client-secret must be defined if authentication is supported")
 	ErrAuthNoGitlabServer               = errors.New("gitlab-server must be defined if authentication is supported")
 	ErrAuthNoRedirect                   = errors.New("auth-redirect-uri must be defined if authentication is supported")
+	ErrAuthInvalidCookieName            = errors.New("auth-cookie-name must be a valid cookie name")
+	ErrAuthInvalidCookieScope           = errors.New("auth-cookie-scope must be one of host, pages-domain or host-prefix")
+	ErrAuthInvalidTimeout               = errors.New("auth-token-timeout and auth-access-check-timeout must be greater than 0")
+	ErrAuthInvalidRetries               = errors.New("auth-api-retries must not be negative")
+	ErrAuthInvalidCodeExpiry            = errors.New("auth-code-expiry must be greater than 0")
 	ErrArtifactsServerUnsupportedScheme = errors.New("artifacts-server scheme must be either http:// or https://")
 	ErrArtifactsServerInvalidTimeout    = errors.New("artifacts-server-timeout must be greater than or equal to 1")
+	ErrArtifactsServerInvalidWeight     = errors.New("artifacts-server weight must be a positive integer")
+	ErrArtifactsServerInvalidParameter  = errors.New("artifacts-server URLs can only be followed by ;weight=N and ;proxy-protocol=v2")
+	ErrNoAllowedHTTPMethods             = errors.New("allowed-http-methods must contain at least one method")
+	ErrInvalidHTTPMethod                = errors.New("allowed-http-methods contains an unknown method")
+	ErrRateLimitRedisUnsupportedScheme  = errors.New("rate-limit-redis-url scheme must be either redis:// or rediss://")
+	ErrRateLimitRedisInvalidTimeout     = errors.New("rate-limit-redis-timeout must be greater than 0")
+	ErrInvalidBandwidthLimit            = errors.New("bandwidth-limit-connection and bandwidth-limit-domain can not be negative")
+	ErrInvalidWriteTimeout              = errors.New("write-timeout can not be negative")
+	ErrInvalidShutdownTimeout           = errors.New("shutdown-timeout can not be negative")
+	ErrHTTP2InvalidMaxReadFrameSize     = errors.New("http2-max-read-frame-size must be between 16384 and 16777215")
+	ErrHTTP2InvalidMaxConcurrentStreams = errors.New("http2-max-concurrent-streams must be between 1 and 4294967295")
+	ErrECHRequiresTLS13                 = errors.New("tls-ech-key requires tls-max-version to allow TLS 1.3")
+	ErrTLSInvalidCertificatePolicy      = errors.New("tls-invalid-cert-policy must be one of serve, wildcard or reject")
+	ErrMetricsAuthIncomplete            = errors.New("metrics-auth-username and metrics-auth-password-file must be set together")
+	ErrMetricsInvalidAllowedIP          = errors.New("metrics-allowed-ips must contain IP addresses or CIDR ranges")
+	ErrMetricsInvalidTenantLabels       = errors.New("metrics-tenant-labels must not be negative")
+	ErrZipInvalidReadAhead              = errors.New("zip-read-ahead-chunk-size, zip-read-ahead-max-prefetch, zip-read-ahead-min-size and zip-read-ahead-concurrency must not be negative")
+	ErrZipInvalidShadowSampleRate       = errors.New("zip-shadow-sample-rate must be between 0 and 1")
+	ErrZipInvalidFileCache              = errors.New("zip-file-cache-size and zip-file-cache-max-file-size must not be negative")
+	ErrZipInvalidCACertificates         = errors.New("object-storage-ca-file must contain PEM encoded certificates")
+	ErrZipInvalidProxy                  = errors.New("object-storage-proxy must be an http://, https:// or socks5:// URL")
+	ErrAuthInvalidProxy                 = errors.New("auth-proxy must be an http://, https:// or socks5:// URL")
+	ErrHostnameSourceInvalidTemplate    = errors.New("hostname-source-template must include {group} and can include {project} once, as full labels")
+	ErrHostnameSourceDiskDisabled       = errors.New("hostname-source-template serves pages from disk and requires enable-disk")
+	ErrHostnameSourceDeploymentHooks    = errors.New("enable-deployment-hooks cannot be used with hostname-source-template")
+	ErrInvalidDomainConfigSource        = errors.New("domain-config-source must be one of gitlab or auto")
+	ErrAutoDomainSourceNoTemplate       = errors.New("domain-config-source auto falls back to the hostname source and requires hostname-source-template")
+	ErrInternalServerSRVInvalidInterval = errors.New("internal-gitlab-server-srv-interval must be greater than 0")
+	ErrCacheInvalidMaxEntries           = errors.New("gitlab-cache-max-entries must not be negative")
+	ErrAnalyticsInvalidInterval         = errors.New("analytics-report-interval must not be negative")
+	ErrRedirectHTTPInvalidExclude       = errors.New("redirect-http-exclude must contain absolute paths other than /")
+	ErrInvalidDirectoryRedirectStatus   = errors.New("directory-redirect-status must be one of 301, 302, 307 or 308")
+	ErrInvalidDotfilesPolicy            = errors.New("dotfiles must be one of allow, ignore or deny")
+	ErrInvalidTrailingSlashPolicy       = errors.New("trailing-slash must be one of preserve, always or never")
+	ErrCleanURLsTrailingSlash           = errors.New("clean-urls can not be used with trailing-slash always, which redirects directories to their path with a slash")
+	ErrInvalidBlockedExtension          = errors.New("blocked-extensions must only contain file extensions, like .pem")
+	ErrInvalidIndexFile                 = errors.New("index-files must be a list of file names, like index.html")
+	ErrInvalidCacheControl              = errors.New("cache-control must contain patterns: value rules, like *.css,*.js: max-age=3600")
+	ErrAnalyticsInvalidLimits           = errors.New("analytics-top-paths and analytics-max-domains must be greater than 0")
+	ErrMicroCacheInvalidLimits          = errors.New("micro-cache-ttl and micro-cache-stale-if-error must not be negative, micro-cache-max-size and micro-cache-max-entries must be greater than 0")
+	ErrRequestFilterInvalidInterval     = errors.New("request-filter-reload-interval must not be negative")
+	ErrInvalidFeatureRollout            = errors.New("feature-rollout must contain name=percentage pairs with a percentage between 0 and 100")
+	ErrInstanceNoDomain                 = errors.New("instance-config must set the pages-domain of the instance")
+	ErrInstanceDuplicateDomain          = errors.New("instance-config must set a pages-domain served by no other instance")
 )
 
+var knownHTTPMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
 // Validate values populated in Config
 func Validate(config *Config) error {
 	var result *multierror.Error
@@ -28,16 +97,156 @@ func Validate(config *Config) error {
 		validateListeners(config),
 		validateAuthConfig(config),
 		validateArtifactsServerConfig(config),
+		validateAllowedHTTPMethods(config),
+		validateDirectoryRedirectStatus(config),
+		validateRedirectHTTPConfig(config),
+		validateRateLimitConfig(config),
+		validateBandwidthConfig(config),
+		validateEgressConfig(config),
+		validateTrustedProxies(config),
+		validateMetricsConfig(config),
+		validateHTTP2Config(config),
+		validateWriteTimeout(config),
+		validateShutdownTimeout(config),
 		tls.ValidateTLSVersions(*tlsMinVersion, *tlsMaxVersion),
+		validateECHConfig(config),
+		validateTLSInvalidCertificatePolicy(config),
+		validateDotfilesPolicy(config),
+		validateTrailingSlashPolicy(config),
+		validateCleanURLs(config),
+		validateBlockedExtensions(config),
+		validateIndexFiles(config),
+		validateCacheControl(config),
+		validateZipConfig(config),
+		validateHostnameSourceConfig(config),
+		validateAnalyticsConfig(config),
+		validateMicroCacheConfig(config),
+		validateCacheConfig(config),
+		validateInternalServerSRVConfig(config),
+		validateFeatureRollouts(config),
+		validateRequestFilterConfig(config),
+		validateInstances(config),
 	)
 
 	return result.ErrorOrNil()
 }
 
+// validateInstances checks the virtual instances serve distinct pages domains
+// and the settings they can override
+func validateInstances(config *Config) error {
+	domains := map[string]bool{config.General.Domain: true}
+
+	var result *multierror.Error
+	for _, instance := range config.Instances {
+		switch {
+		case instance.General.Domain == "":
+			result = multierror.Append(result, ErrInstanceNoDomain)
+		case domains[instance.General.Domain]:
+			result = multierror.Append(result, fmt.Errorf("%w: %q", ErrInstanceDuplicateDomain, instance.General.Domain))
+		}
+		domains[instance.General.Domain] = true
+
+		result = multierror.Append(result,
+			validateAuthConfig(instance),
+			validateRateLimitConfig(instance),
+		)
+	}
+
+	return result.ErrorOrNil()
+}
+
+func validateFeatureRollouts(config *Config) error {
+	_, err := config.General.Rollouts()
+	return err
+}
+
+func validateTLSInvalidCertificatePolicy(config *Config) error {
+	switch config.TLS.InvalidCertificatePolicy {
+	case TLSInvalidCertificateServe, TLSInvalidCertificateWildcard, TLSInvalidCertificateReject:
+		return nil
+	default:
+		return ErrTLSInvalidCertificatePolicy
+	}
+}
+
+func validateDotfilesPolicy(config *Config) error {
+	switch config.General.Dotfiles {
+	case DotfilesAllow, DotfilesIgnore, DotfilesDeny:
+		return nil
+	default:
+		return ErrInvalidDotfilesPolicy
+	}
+}
+
+func validateTrailingSlashPolicy(config *Config) error {
+	switch config.General.TrailingSlash {
+	case TrailingSlashPreserve, TrailingSlashNever, TrailingSlashAlways:
+		return nil
+	default:
+		return ErrInvalidTrailingSlashPolicy
+	}
+}
+
+func validateCleanURLs(config *Config) error {
+	if config.General.CleanURLs && config.General.TrailingSlash == TrailingSlashAlways {
+		return ErrCleanURLsTrailingSlash
+	}
+
+	return nil
+}
+
+func validateBlockedExtensions(config *Config) error {
+	for _, ext := range config.General.BlockedExtensions {
+		if ext == "." || strings.Count(ext, ".") != 1 || strings.ContainsAny(ext, "/\\*? ") {
+			return fmt.Errorf("%w: %q", ErrInvalidBlockedExtension, ext)
+		}
+	}
+
+	return nil
+}
+
+func validateIndexFiles(config *Config) error {
+	if len(config.General.IndexFiles) == 0 {
+		return ErrInvalidIndexFile
+	}
+
+	for _, name := range config.General.IndexFiles {
+		if !ValidIndexFile(name) {
+			return fmt.Errorf("%w: %q", ErrInvalidIndexFile, name)
+		}
+	}
+
+	return nil
+}
+
+func validateCacheControl(config *Config) error {
+	_, err := config.General.CacheControlRules()
+	return err
+}
+
+// ValidIndexFile returns true if name can be served as the index file of a
+// directory, it must be a file name without a path
+func ValidIndexFile(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\")
+}
+
+func validateECHConfig(config *Config) error {
+	if len(config.TLS.ECHKeys) == 0 {
+		return nil
+	}
+
+	if config.TLS.MaxVersion != 0 && config.TLS.MaxVersion < cryptotls.VersionTLS13 {
+		return ErrECHRequiresTLS13
+	}
+
+	return tls.ValidateECHKeys(config.TLS.ECHKeys)
+}
+
 func validateListeners(config *Config) error {
 	if config.ListenHTTPStrings.Len() == 0 &&
 		config.ListenHTTPSStrings.Len() == 0 &&
 		config.ListenHTTPSProxyv2Strings.Len() == 0 &&
+		config.ListenHTTPAndHTTPSStrings.Len() == 0 &&
 		config.ListenProxyStrings.Len() == 0 {
 		return ErrNoListener
 	}
@@ -67,24 +276,77 @@ func validateAuthConfig(config *Config) error {
 	if config.Authentication.RedirectURI == "" {
 		result = multierror.Append(result, ErrAuthNoRedirect)
 	}
+	if !validCookieName(config.Authentication.CookieName) {
+		result = multierror.Append(result, ErrAuthInvalidCookieName)
+	}
+	switch config.Authentication.CookieScope {
+	case AuthCookieScopeHost, AuthCookieScopePagesDomain, AuthCookieScopeHostPrefix:
+	default:
+		result = multierror.Append(result, ErrAuthInvalidCookieScope)
+	}
+	if config.Authentication.TokenTimeout <= 0 || config.Authentication.AccessCheckTimeout <= 0 {
+		result = multierror.Append(result, ErrAuthInvalidTimeout)
+	}
+	if config.Authentication.CodeExpiry <= 0 {
+		result = multierror.Append(result, ErrAuthInvalidCodeExpiry)
+	}
+	if config.Authentication.APIRetries < 0 {
+		result = multierror.Append(result, ErrAuthInvalidRetries)
+	}
+	if config.Authentication.ProxyURL != "" && !validProxyURL(config.Authentication.ProxyURL) {
+		result = multierror.Append(result, ErrAuthInvalidProxy)
+	}
 	return result.ErrorOrNil()
 }
 
-func validateArtifactsServerConfig(config *Config) error {
-	if config.ArtifactsServer.URL == "" {
-		return nil
+// validProxyURL checks proxyURL is the URL of an http, https or socks5 proxy
+func validProxyURL(proxyURL string) bool {
+	u, err := url.Parse(proxyURL)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	return u.Scheme == "http" || u.Scheme == "https" || u.Scheme == "socks5"
+}
+
+// validCookieName checks name is a token as defined by RFC 6265
+func validCookieName(name string) bool {
+	if name == "" {
+		return false
 	}
 
-	u, err := url.Parse(config.ArtifactsServer.URL)
+	for _, c := range name {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune(`()<>@,;:\"/[]?={}`, c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func validateArtifactsServerConfig(config *Config) error {
+	servers, err := config.ArtifactsServer.Backends()
 	if err != nil {
 		return err
 	}
 
+	if len(servers) == 0 {
+		return nil
+	}
+
 	var result *multierror.Error
 
-	// url.Parse ensures that the Scheme attribute is always lower case.
-	if u.Scheme != "http" && u.Scheme != "https" {
-		result = multierror.Append(result, ErrArtifactsServerUnsupportedScheme)
+	for _, server := range servers {
+		u, err := url.Parse(server.URL)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+
+		// url.Parse ensures that the Scheme attribute is always lower case.
+		if u.Scheme != "http" && u.Scheme != "https" {
+			result = multierror.Append(result, ErrArtifactsServerUnsupportedScheme)
+		}
 	}
 
 	if config.ArtifactsServer.TimeoutSeconds < 1 {
@@ -93,3 +355,252 @@ func validateArtifactsServerConfig(config *Config) error {
 
 	return result.ErrorOrNil()
 }
+
+func validateRateLimitConfig(config *Config) error {
+	if config.RateLimit.RedisURL == "" {
+		return nil
+	}
+
+	u, err := url.Parse(config.RateLimit.RedisURL)
+	if err != nil {
+		return err
+	}
+
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return ErrRateLimitRedisUnsupportedScheme
+	}
+
+	if config.RateLimit.RedisTimeout <= 0 {
+		return ErrRateLimitRedisInvalidTimeout
+	}
+
+	return nil
+}
+
+func validateBandwidthConfig(config *Config) error {
+	if config.Bandwidth.ConnectionLimit < 0 || config.Bandwidth.DomainLimit < 0 {
+		return ErrInvalidBandwidthLimit
+	}
+
+	return nil
+}
+
+// validateEgressConfig checks the allowlist and that the artifacts server is
+// allowed by it
+func validateEgressConfig(config *Config) error {
+	policy, err := egress.NewPolicy(config.General.EgressAllowlist)
+	if err != nil {
+		return err
+	}
+
+	servers, err := config.ArtifactsServer.Backends()
+	if err != nil {
+		// reported by validateArtifactsServerConfig
+		return nil
+	}
+
+	for _, server := range servers {
+		if err := policy.CheckURL(server.URL); err != nil {
+			return fmt.Errorf("artifacts-server: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func validateTrustedProxies(config *Config) error {
+	_, err := forwarded.NewProxies(config.General.TrustedProxies)
+	return err
+}
+
+func validateMetricsConfig(config *Config) error {
+	var result *multierror.Error
+
+	if (config.Metrics.Username == "") != (len(config.Metrics.Password) == 0) {
+		result = multierror.Append(result, ErrMetricsAuthIncomplete)
+	}
+
+	if _, err := forwarded.NewProxies(config.Metrics.AllowedIPs); err != nil {
+		result = multierror.Append(result, fmt.Errorf("%w: %v", ErrMetricsInvalidAllowedIP, err))
+	}
+
+	if config.Metrics.TenantLabels < 0 {
+		result = multierror.Append(result, ErrMetricsInvalidTenantLabels)
+	}
+
+	return result.ErrorOrNil()
+}
+
+func validateHTTP2Config(config *Config) error {
+	var result *multierror.Error
+
+	if config.HTTP2.MaxConcurrentStreams == 0 || config.HTTP2.MaxConcurrentStreams > math.MaxUint32 {
+		result = multierror.Append(result, ErrHTTP2InvalidMaxConcurrentStreams)
+	}
+
+	// frame size limits defined by https://httpwg.org/specs/rfc7540.html#SETTINGS_MAX_FRAME_SIZE
+	if config.HTTP2.MaxReadFrameSize < 1<<14 || config.HTTP2.MaxReadFrameSize > 1<<24-1 {
+		result = multierror.Append(result, ErrHTTP2InvalidMaxReadFrameSize)
+	}
+
+	return result.ErrorOrNil()
+}
+
+func validateWriteTimeout(config *Config) error {
+	if config.General.WriteTimeout < 0 {
+		return ErrInvalidWriteTimeout
+	}
+
+	return nil
+}
+
+func validateShutdownTimeout(config *Config) error {
+	if config.General.ShutdownTimeout < 0 {
+		return ErrInvalidShutdownTimeout
+	}
+
+	return nil
+}
+
+func validateZipConfig(config *Config) error {
+	var result *multierror.Error
+
+	if config.Zip.ReadAheadChunkSize < 0 || config.Zip.ReadAheadMaxPrefetch < 0 ||
+		config.Zip.ReadAheadMinSize < 0 || config.Zip.ReadAheadConcurrency < 0 {
+		result = multierror.Append(result, ErrZipInvalidReadAhead)
+	}
+
+	if config.Zip.ShadowSampleRate < 0 || config.Zip.ShadowSampleRate > 1 {
+		result = multierror.Append(result, ErrZipInvalidShadowSampleRate)
+	}
+
+	if config.Zip.FileCacheSize < 0 || config.Zip.FileCacheMaxFileSize < 0 {
+		result = multierror.Append(result, ErrZipInvalidFileCache)
+	}
+
+	if len(config.Zip.CACertificates) > 0 && !x509.NewCertPool().AppendCertsFromPEM(config.Zip.CACertificates) {
+		result = multierror.Append(result, ErrZipInvalidCACertificates)
+	}
+
+	if config.Zip.ProxyURL != "" && !validProxyURL(config.Zip.ProxyURL) {
+		result = multierror.Append(result, ErrZipInvalidProxy)
+	}
+
+	return result.ErrorOrNil()
+}
+
+func validateCacheConfig(config *Config) error {
+	if config.GitLab.Cache.MaxEntries < 0 {
+		return ErrCacheInvalidMaxEntries
+	}
+
+	return nil
+}
+
+func validateInternalServerSRVConfig(config *Config) error {
+	if config.GitLab.InternalServerSRV != "" && config.GitLab.InternalServerSRVInterval <= 0 {
+		return ErrInternalServerSRVInvalidInterval
+	}
+
+	return nil
+}
+
+func validateRequestFilterConfig(config *Config) error {
+	if config.RequestFilter.ReloadInterval < 0 {
+		return ErrRequestFilterInvalidInterval
+	}
+
+	return nil
+}
+
+func validateAnalyticsConfig(config *Config) error {
+	if config.Analytics.ReportInterval < 0 {
+		return ErrAnalyticsInvalidInterval
+	}
+
+	if config.Analytics.ReportInterval > 0 && (config.Analytics.TopPaths < 1 || config.Analytics.MaxDomains < 1) {
+		return ErrAnalyticsInvalidLimits
+	}
+
+	return nil
+}
+
+func validateMicroCacheConfig(config *Config) error {
+	if config.MicroCache.TTL < 0 || config.MicroCache.StaleIfError < 0 {
+		return ErrMicroCacheInvalidLimits
+	}
+
+	if config.MicroCache.TTL > 0 && (config.MicroCache.MaxSize < 1 || config.MicroCache.MaxEntries < 1) {
+		return ErrMicroCacheInvalidLimits
+	}
+
+	return nil
+}
+
+func validateHostnameSourceConfig(config *Config) error {
+	switch config.HostnameSource.Mode {
+	case DomainSourceGitLab:
+	case DomainSourceAuto:
+		if config.HostnameSource.Template == "" {
+			return ErrAutoDomainSourceNoTemplate
+		}
+	default:
+		return ErrInvalidDomainConfigSource
+	}
+
+	if config.HostnameSource.Template == "" {
+		return nil
+	}
+
+	var result *multierror.Error
+
+	if _, err := config.HostnameSource.Labels(); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if !config.GitLab.EnableDisk {
+		result = multierror.Append(result, ErrHostnameSourceDiskDisabled)
+	}
+	// the domains of the GitLab API can be refreshed in auto mode
+	if config.General.DeploymentHooks && config.HostnameSource.Mode != DomainSourceAuto {
+		result = multierror.Append(result, ErrHostnameSourceDeploymentHooks)
+	}
+
+	return result.ErrorOrNil()
+}
+
+func validateDirectoryRedirectStatus(config *Config) error {
+	switch config.General.DirectoryRedirectStatus {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return nil
+	default:
+		return ErrInvalidDirectoryRedirectStatus
+	}
+}
+
+func validateRedirectHTTPConfig(config *Config) error {
+	var result *multierror.Error
+
+	for _, prefix := range config.General.RedirectHTTPExclude {
+		// excluding / would serve every path over HTTP
+		if !strings.HasPrefix(prefix, "/") || prefix == "/" {
+			result = multierror.Append(result, fmt.Errorf("%w: %q", ErrRedirectHTTPInvalidExclude, prefix))
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+func validateAllowedHTTPMethods(config *Config) error {
+	if len(config.General.AllowedHTTPMethods) == 0 {
+		return ErrNoAllowedHTTPMethods
+	}
+
+	var result *multierror.Error
+	for _, method := range config.General.AllowedHTTPMethods {
+		if !knownHTTPMethods[method] {
+			result = multierror.Append(result, fmt.Errorf("%w: %q", ErrInvalidHTTPMethod, method))
+		}
+	}
+
+	return result.ErrorOrNil()
+}
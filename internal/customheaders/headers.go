@@ -0,0 +1,216 @@
+package customheaders
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/request"
+)
+
+// Domain classes a header can be scoped to
+const (
+	// ClassPages is the class of the pages domain and its subdomains
+	ClassPages = "pages"
+	// ClassCustom is the class of the custom domains
+	ClassCustom = "custom"
+)
+
+var (
+	listeners = []string{request.SchemeHTTP, request.SchemeHTTPS, request.ListenerProxy}
+	classes   = []string{ClassPages, ClassCustom}
+)
+
+// rule is a header added to or removed from the responses in its scope
+type rule struct {
+	listeners map[string]bool
+	classes   map[string]bool
+	name      string
+	value     string
+	remove    bool
+}
+
+// specificity is the number of dimensions a rule is scoped to, the values of
+// the most specific rules of a header replace the ones of less specific rules
+func (r *rule) specificity() int {
+	s := 0
+	if len(r.listeners) > 0 {
+		s++
+	}
+	if len(r.classes) > 0 {
+		s++
+	}
+
+	return s
+}
+
+func (r *rule) matches(listener, class string) bool {
+	return (len(r.listeners) == 0 || r.listeners[listener]) &&
+		(len(r.classes) == 0 || r.classes[class])
+}
+
+// headerSet are the headers of the responses of a listener and domain class
+type headerSet struct {
+	add    http.Header
+	remove []string
+}
+
+// Headers are the custom headers of the responses, resolved for each
+// listener and domain class
+type Headers struct {
+	pagesDomain string
+	sets        map[string]map[string]*headerSet
+}
+
+// New parses the custom headers of the -header flag. Each of them is either
+// `Name: value` to add a header, or `-Name` to remove a header from the
+// responses, including the ones set by Pages. Both can be prefixed with a
+// scope, e.g. `[https,custom] Name: value`, listing the listeners (http,
+// https, proxy) and the domain classes (pages, custom) they apply to.
+//
+// For each header the values of the most specific matching rules are used,
+// in the order of the flags. Handlers, e.g. serving the project headers, can
+// replace the added headers, while removals are applied to every response.
+func New(customHeaders []string, pagesDomain string) (*Headers, error) {
+	rules := make([]*rule, 0, len(customHeaders))
+	for _, customHeader := range customHeaders {
+		r, err := parseRule(customHeader)
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, r)
+	}
+
+	h := &Headers{
+		pagesDomain: strings.ToLower(pagesDomain),
+		sets:        make(map[string]map[string]*headerSet, len(listeners)),
+	}
+
+	for _, listener := range listeners {
+		h.sets[listener] = make(map[string]*headerSet, len(classes))
+		for _, class := range classes {
+			h.sets[listener][class] = resolve(rules, listener, class)
+		}
+	}
+
+	return h, nil
+}
+
+func parseRule(customHeader string) (*rule, error) {
+	r := &rule{}
+
+	header := strings.TrimSpace(customHeader)
+	if strings.HasPrefix(header, "[") {
+		end := strings.Index(header, "]")
+		if end < 0 {
+			return nil, fmt.Errorf("%w: unterminated scope in %q", errInvalidHeaderParameter, customHeader)
+		}
+
+		if err := r.parseScope(header[1:end]); err != nil {
+			return nil, fmt.Errorf("%w: %s in %q", errInvalidHeaderParameter, err, customHeader)
+		}
+
+		header = strings.TrimSpace(header[end+1:])
+	}
+
+	if strings.HasPrefix(header, "-") {
+		r.remove = true
+		r.name = http.CanonicalHeaderKey(strings.TrimSpace(header[1:]))
+		if r.name == "" || strings.Contains(r.name, ":") {
+			return nil, fmt.Errorf("%w: %q", errInvalidHeaderParameter, customHeader)
+		}
+
+		return r, nil
+	}
+
+	headers, err := ParseHeaderString([]string{header})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", err, customHeader)
+	}
+
+	for name, values := range headers {
+		r.name = http.CanonicalHeaderKey(name)
+		r.value = values[0]
+	}
+
+	return r, nil
+}
+
+func (r *rule) parseScope(scope string) error {
+	for _, s := range strings.Split(scope, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+
+		switch {
+		case contains(listeners, s):
+			if r.listeners == nil {
+				r.listeners = make(map[string]bool)
+			}
+			r.listeners[s] = true
+		case contains(classes, s):
+			if r.classes == nil {
+				r.classes = make(map[string]bool)
+			}
+			r.classes[s] = true
+		default:
+			return fmt.Errorf("unknown scope %q", s)
+		}
+	}
+
+	return nil
+}
+
+// resolve merges the rules matching the listener and domain class
+func resolve(rules []*rule, listener, class string) *headerSet {
+	specificity := make(map[string]int)
+	for _, r := range rules {
+		if !r.remove && r.matches(listener, class) && r.specificity() > specificity[r.name] {
+			specificity[r.name] = r.specificity()
+		}
+	}
+
+	set := &headerSet{add: http.Header{}}
+	for _, r := range rules {
+		if !r.matches(listener, class) {
+			continue
+		}
+
+		if r.remove {
+			set.remove = append(set.remove, r.name)
+		} else if r.specificity() == specificity[r.name] {
+			set.add[r.name] = append(set.add[r.name], r.value)
+		}
+	}
+
+	for _, name := range set.remove {
+		delete(set.add, name)
+	}
+
+	return set
+}
+
+// set returns the headers of the request
+func (h *Headers) set(r *http.Request) *headerSet {
+	class := ClassCustom
+	host := strings.ToLower(request.GetHostWithoutPort(r))
+	if host == h.pagesDomain || strings.HasSuffix(host, "."+h.pagesDomain) {
+		class = ClassPages
+	}
+
+	sets, ok := h.sets[request.GetListener(r)]
+	if !ok {
+		sets = h.sets[request.SchemeHTTP]
+	}
+
+	return sets[class]
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
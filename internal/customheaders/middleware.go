@@ -4,11 +4,108 @@ import (
 	"net/http"
 )
 
+// poweredByHeader discloses the software of backends, it is never sent
+const poweredByHeader = "X-Powered-By"
+
 // NewMiddleware returns middleware which inject custom headers into the response
-func NewMiddleware(handler http.Handler, headers http.Header) http.Handler {
+// and removes the headers configured to be removed before they are written
+func NewMiddleware(handler http.Handler, headers *Headers) http.Handler {
+	if headers == nil {
+		return handler
+	}
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		AddCustomHeaders(w, headers)
+		set := headers.set(r)
+
+		AddCustomHeaders(w, set.add)
+
+		if len(set.remove) > 0 {
+			w = &finalizingWriter{ResponseWriter: w, finalize: func(h http.Header) {
+				for _, name := range set.remove {
+					h.Del(name)
+				}
+			}}
+		}
 
 		handler.ServeHTTP(w, r)
 	})
 }
+
+// NewServerMiddleware returns middleware which sets the Server header of every
+// response to server, or removes it when server is empty, and removes the
+// X-Powered-By header
+func NewServerMiddleware(handler http.Handler, server string) http.Handler {
+	finalize := func(h http.Header) {
+		h.Del(poweredByHeader)
+
+		if server == "" {
+			h.Del("Server")
+		} else {
+			h.Set("Server", server)
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(&finalizingWriter{ResponseWriter: w, finalize: finalize}, r)
+	})
+}
+
+// robotsTagHeader and noIndex prevent search engines from indexing a response
+// and following its links
+const (
+	robotsTagHeader = "X-Robots-Tag"
+	noIndex         = "noindex, nofollow"
+)
+
+// NewNoIndexMiddleware returns middleware which adds an X-Robots-Tag header
+// preventing search engines from indexing every response, in addition to the
+// X-Robots-Tag headers set by handlers
+func NewNoIndexMiddleware(handler http.Handler) http.Handler {
+	finalize := func(h http.Header) {
+		for _, value := range h.Values(robotsTagHeader) {
+			if value == noIndex {
+				return
+			}
+		}
+
+		h.Add(robotsTagHeader, noIndex)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// set before serving the request for the responses without a
+		// body, which are written by net/http
+		w.Header().Add(robotsTagHeader, noIndex)
+
+		handler.ServeHTTP(&finalizingWriter{ResponseWriter: w, finalize: finalize}, r)
+	})
+}
+
+// finalizingWriter calls finalize with the response headers right before they
+// are written, so the headers set by handlers can be changed too
+type finalizingWriter struct {
+	http.ResponseWriter
+	finalize    func(http.Header)
+	wroteHeader bool
+}
+
+func (w *finalizingWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.finalize(w.Header())
+	}
+
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *finalizingWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return w.ResponseWriter.Write(data)
+}
+
+// Unwrap returns the http.ResponseWriter the finalized headers are sent with
+func (w *finalizingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,218 @@
+package customheaders_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/customheaders"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/request"
+)
+
+func TestNew(t *testing.T) {
+	tests := map[string]struct {
+		headers []string
+		valid   bool
+	}{
+		"unscoped":            {headers: []string{"X-Test: Test"}, valid: true},
+		"removal":             {headers: []string{"-Server"}, valid: true},
+		"scoped":              {headers: []string{"[https, custom] X-Test: Test"}, valid: true},
+		"scoped_removal":      {headers: []string{"[proxy] -X-Test"}, valid: true},
+		"unknown_scope":       {headers: []string{"[ftp] X-Test: Test"}},
+		"empty_scope":         {headers: []string{"[] X-Test: Test"}},
+		"unterminated_scope":  {headers: []string{"[https X-Test: Test"}},
+		"removal_with_value":  {headers: []string{"-X-Test: Test"}},
+		"removal_without_key": {headers: []string{"-"}},
+		"missing_value":       {headers: []string{"[https] X-Test"}},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := customheaders.New(tt.headers, "gitlab.io")
+			if tt.valid {
+				require.NoError(t, err)
+				return
+			}
+
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestNewMiddleware(t *testing.T) {
+	headers, err := customheaders.New([]string{
+		"X-Instance: all",
+		"X-Listener: all",
+		"[https] X-Listener: https",
+		"[https] X-Listener: secure",
+		"[custom] X-Class: custom",
+		"[https,pages] X-Class: https-pages",
+		"[http, https, pages] X-Class: pages",
+		"-X-Powered-By",
+		"[proxy] -X-Instance",
+	}, "gitlab.io")
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		url             string
+		listener        string
+		expectedHeaders http.Header
+	}{
+		"http_custom_domain": {
+			url:      "http://example.com/",
+			listener: request.SchemeHTTP,
+			expectedHeaders: http.Header{
+				"X-Instance": {"all"},
+				"X-Listener": {"all"},
+				"X-Class":    {"custom"},
+			},
+		},
+		"https_custom_domain": {
+			url:      "https://example.com/",
+			listener: request.SchemeHTTPS,
+			expectedHeaders: http.Header{
+				"X-Instance": {"all"},
+				"X-Listener": {"https", "secure"},
+				"X-Class":    {"custom"},
+			},
+		},
+		"http_pages_domain": {
+			url:      "http://group.gitlab.io/",
+			listener: request.SchemeHTTP,
+			expectedHeaders: http.Header{
+				"X-Instance": {"all"},
+				"X-Listener": {"all"},
+				"X-Class":    {"pages"},
+			},
+		},
+		"https_pages_domain": {
+			url:      "https://group.gitlab.io:443/",
+			listener: request.SchemeHTTPS,
+			expectedHeaders: http.Header{
+				"X-Instance": {"all"},
+				"X-Listener": {"https", "secure"},
+				"X-Class":    {"https-pages", "pages"},
+			},
+		},
+		"proxy_pages_domain": {
+			url:      "https://gitlab.io/",
+			listener: request.ListenerProxy,
+			expectedHeaders: http.Header{
+				"X-Listener": {"all"},
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			handler := customheaders.NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Powered-By", "handler")
+				w.Write([]byte("body"))
+			}), headers)
+
+			r := request.WithListener(httptest.NewRequest(http.MethodGet, tt.url, nil), tt.listener)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			got := w.Result().Header
+			for _, name := range []string{"X-Instance", "X-Listener", "X-Class", "X-Powered-By"} {
+				require.Equal(t, tt.expectedHeaders[name], got[name], name)
+			}
+			require.Equal(t, "body", w.Body.String())
+		})
+	}
+}
+
+func TestNewMiddlewareHandlersReplaceHeaders(t *testing.T) {
+	headers, err := customheaders.New([]string{"Cache-Control: no-cache"}, "gitlab.io")
+	require.NoError(t, err)
+
+	handler := customheaders.NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.WriteHeader(http.StatusNoContent)
+	}), headers)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+
+	require.Equal(t, []string{"max-age=60"}, w.Result().Header["Cache-Control"])
+}
+
+func TestNewNoIndexMiddleware(t *testing.T) {
+	tests := map[string]struct {
+		handler        http.HandlerFunc
+		expectedValues []string
+	}{
+		"no_content": {
+			handler:        func(w http.ResponseWriter, r *http.Request) {},
+			expectedValues: []string{"noindex, nofollow"},
+		},
+		"content": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("content"))
+			},
+			expectedValues: []string{"noindex, nofollow"},
+		},
+		"replaced_by_handler": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Robots-Tag", "noarchive")
+				w.WriteHeader(http.StatusNotFound)
+			},
+			expectedValues: []string{"noarchive", "noindex, nofollow"},
+		},
+		"added_by_handler": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("X-Robots-Tag", "noarchive")
+				w.Write([]byte("content"))
+			},
+			expectedValues: []string{"noindex, nofollow", "noarchive"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			customheaders.NewNoIndexMiddleware(tt.handler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+
+			require.Equal(t, tt.expectedValues, w.Result().Header.Values("X-Robots-Tag"))
+		})
+	}
+}
+
+func TestNewServerMiddleware(t *testing.T) {
+	tests := map[string]struct {
+		server         string
+		write          bool
+		expectedServer []string
+	}{
+		"removed":           {server: "", expectedServer: nil},
+		"removed_on_write":  {server: "", write: true, expectedServer: nil},
+		"replaced":          {server: "Pages", expectedServer: []string{"Pages"}},
+		"replaced_on_write": {server: "Pages", write: true, expectedServer: []string{"Pages"}},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			handler := customheaders.NewServerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Server", "backend")
+				w.Header().Set("X-Powered-By", "backend")
+
+				if tt.write {
+					_, err := w.Write([]byte("content"))
+					require.NoError(t, err)
+					return
+				}
+
+				w.WriteHeader(http.StatusNotFound)
+			}), tt.server)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+
+			require.Equal(t, tt.expectedServer, w.Result().Header["Server"])
+			require.Empty(t, w.Result().Header.Get("X-Powered-By"))
+		})
+	}
+}
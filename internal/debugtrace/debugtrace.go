@@ -0,0 +1,156 @@
+// Package debugtrace records the steps taken to serve a request and exposes
+// them in a response header to debug how GitLab Pages resolved it
+package debugtrace
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// RequestHeader holds a JWT token signed with the GitLab API secret that
+	// enables the trace for a single request
+	RequestHeader = "Gitlab-Pages-Debug"
+	// ResponseHeader holds the summary of the trace
+	ResponseHeader = "X-Pages-Debug"
+)
+
+// maxSteps is the number of steps a trace records without allocating
+const maxSteps = 8
+
+type ctxKey struct{}
+
+// step is a resolution step of a request, with either a value or the
+// duration observed for it, which is only formatted when the trace is sent
+type step struct {
+	name     string
+	value    string
+	duration time.Duration
+	observed bool
+}
+
+// Trace records the resolution steps of a request. It is safe to use a nil
+// *Trace, in which case nothing is recorded.
+type Trace struct {
+	mu      sync.Mutex
+	start   time.Time
+	enabled bool
+	steps   []step
+	buf     [maxSteps]step
+}
+
+// New returns a trace for a request started at start
+func New(start time.Time) *Trace {
+	t := &Trace{}
+	t.reset(start)
+
+	return t
+}
+
+// reset prepares t for a request started at start, t must not be copied
+// afterwards as its steps are recorded in its own buffer
+func (t *Trace) reset(start time.Time) {
+	t.start = start
+	t.steps = t.buf[:0]
+}
+
+// WithTrace returns a copy of ctx holding t
+func WithTrace(ctx context.Context, t *Trace) context.Context {
+	return context.WithValue(ctx, ctxKey{}, t)
+}
+
+// FromContext returns the trace of the request or nil
+func FromContext(ctx context.Context) *Trace {
+	t, _ := ctx.Value(ctxKey{}).(*Trace)
+	return t
+}
+
+// Enable makes the trace to be sent in the response
+func (t *Trace) Enable() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.enabled = true
+}
+
+// Enabled returns true when the trace should be sent in the response
+func (t *Trace) Enabled() bool {
+	if t == nil {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.enabled
+}
+
+// Add records the value of a step. Only the first value of a step is kept
+// as, e.g. the domain is resolved again by several handlers after it has
+// been looked up.
+func (t *Trace) Add(name, value string) {
+	t.add(step{name: name, value: value})
+}
+
+// Observe records the time elapsed since start as the duration of a step
+func (t *Trace) Observe(name string, start time.Time) {
+	if t == nil {
+		return
+	}
+
+	t.add(step{name: name, duration: time.Since(start), observed: true})
+}
+
+func (t *Trace) add(s step) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, recorded := range t.steps {
+		if recorded.name == s.name {
+			return
+		}
+	}
+
+	t.steps = append(t.steps, s)
+}
+
+// String summarizes the recorded steps in order followed by the total
+// duration of the request so far, e.g.
+// `source=gitlab; domain_cache=hit; lookup=0.12ms; total=1.05ms`
+func (t *Trace) String() string {
+	if t == nil {
+		return ""
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	parts := make([]string, 0, len(t.steps)+1)
+	for _, s := range t.steps {
+		value := s.value
+		if s.observed {
+			value = formatDuration(s.duration)
+		}
+
+		parts = append(parts, s.name+"="+value)
+	}
+
+	parts = append(parts, "total="+formatDuration(time.Since(t.start)))
+
+	return strings.Join(parts, "; ")
+}
+
+func formatDuration(d time.Duration) string {
+	return fmt.Sprintf("%.2fms", float64(d)/float64(time.Millisecond))
+}
@@ -0,0 +1,56 @@
+package debugtrace
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrace(t *testing.T) {
+	trace := New(time.Now())
+	require.False(t, trace.Enabled())
+
+	trace.Add("source", "gitlab")
+	trace.Add("domain_cache", "miss")
+	trace.Add("domain_cache", "hit")
+	trace.Observe("lookup", time.Now())
+	trace.Enable()
+
+	require.True(t, trace.Enabled())
+	require.Regexp(t, `^source=gitlab; domain_cache=miss; lookup=\d+\.\d{2}ms; total=\d+\.\d{2}ms$`, trace.String())
+}
+
+func TestNilTrace(t *testing.T) {
+	trace := FromContext(context.Background())
+	require.Nil(t, trace)
+
+	require.NotPanics(t, func() {
+		trace.Add("source", "gitlab")
+		trace.Observe("lookup", time.Now())
+		trace.Enable()
+	})
+
+	require.False(t, trace.Enabled())
+	require.Empty(t, trace.String())
+}
+
+func TestFromContext(t *testing.T) {
+	trace := New(time.Now())
+
+	require.Same(t, trace, FromContext(WithTrace(context.Background(), trace)))
+}
+
+func TestTraceAllocations(t *testing.T) {
+	trace := New(time.Now())
+
+	allocs := testing.AllocsPerRun(100, func() {
+		trace.reset(time.Now())
+		trace.Add("source", "gitlab")
+		trace.Add("domain_cache", "hit")
+		trace.Observe("lookup", time.Now())
+	})
+
+	require.Zero(t, allocs, "the steps are recorded without allocating until the trace is sent")
+}
@@ -0,0 +1,60 @@
+package debugtrace
+
+import (
+	"net/http"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/security"
+)
+
+// NewMiddleware returns middleware which traces the requests and sends the
+// trace in the ResponseHeader when it is enabled, either for the request with
+// a RequestHeader token signed with secret or for the domain by the handlers.
+// The trace is allocated with the response writer and its values are only
+// formatted when it is sent.
+func NewMiddleware(handler http.Handler, secret []byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := &responseWriter{ResponseWriter: w}
+		tw.trace.reset(time.Now())
+
+		if token := r.Header.Get(RequestHeader); token != "" && len(secret) > 0 {
+			if security.VerifyAPIToken(token, secret) == nil {
+				tw.trace.Enable()
+			}
+		}
+
+		handler.ServeHTTP(tw, r.WithContext(WithTrace(r.Context(), &tw.trace)))
+	})
+}
+
+// responseWriter adds the trace to the response headers before they are sent
+type responseWriter struct {
+	http.ResponseWriter
+	trace         Trace
+	headerWritten bool
+}
+
+func (w *responseWriter) WriteHeader(statusCode int) {
+	if !w.headerWritten {
+		w.headerWritten = true
+
+		if w.trace.Enabled() {
+			w.Header().Set(ResponseHeader, w.trace.String())
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *responseWriter) Write(data []byte) (int, error) {
+	if !w.headerWritten {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return w.ResponseWriter.Write(data)
+}
+
+// Unwrap returns the http.ResponseWriter the trace header is added to
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,88 @@
+package debugtrace_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/debugtrace"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/testhelpers"
+)
+
+func TestNewMiddleware(t *testing.T) {
+	expiresAt := jwt.NewNumericDate(time.Now().Add(time.Minute))
+
+	tests := map[string]struct {
+		secret         string
+		token          string
+		enableInDomain bool
+		expectedTrace  bool
+	}{
+		"no_token": {
+			secret: "secret",
+		},
+		"valid_token": {
+			secret:        "secret",
+			token:         testhelpers.SignAPIToken(t, []byte("secret"), expiresAt),
+			expectedTrace: true,
+		},
+		"wrong_secret": {
+			secret: "secret",
+			token:  testhelpers.SignAPIToken(t, []byte("other"), expiresAt),
+		},
+		"expired_token": {
+			secret: "secret",
+			token:  testhelpers.SignAPIToken(t, []byte("secret"), jwt.NewNumericDate(time.Now().Add(-time.Minute))),
+		},
+		"token_without_expiration": {
+			secret: "secret",
+			token:  testhelpers.SignAPIToken(t, []byte("secret"), nil),
+		},
+		"no_secret": {
+			token: testhelpers.SignAPIToken(t, []byte(""), expiresAt),
+		},
+		"enabled_for_domain": {
+			secret:         "secret",
+			enableInDomain: true,
+			expectedTrace:  true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				trace := debugtrace.FromContext(r.Context())
+				require.NotNil(t, trace)
+
+				trace.Add("source", "gitlab")
+				if tt.enableInDomain {
+					trace.Enable()
+				}
+
+				w.Write([]byte("content"))
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.token != "" {
+				r.Header.Set(debugtrace.RequestHeader, tt.token)
+			}
+
+			w := httptest.NewRecorder()
+			debugtrace.NewMiddleware(next, []byte(tt.secret)).ServeHTTP(w, r)
+
+			require.Equal(t, http.StatusOK, w.Code)
+			require.Equal(t, "content", w.Body.String())
+
+			if !tt.expectedTrace {
+				require.Empty(t, w.Header().Get(debugtrace.ResponseHeader))
+				return
+			}
+
+			require.Regexp(t, `^source=gitlab; total=\d+\.\d{2}ms$`, w.Header().Get(debugtrace.ResponseHeader))
+		})
+	}
+}
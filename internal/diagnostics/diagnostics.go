@@ -0,0 +1,281 @@
+// Package diagnostics checks the DNS and TLS setup of custom domains so
+// operators and the GitLab UI can help users struggling with domain setup
+package diagnostics
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source"
+)
+
+const (
+	// DefaultTimeout is the maximum time spent running all the checks for a domain
+	DefaultTimeout = 10 * time.Second
+
+	// certificateExpiryWarning is how long before the certificate expiry the
+	// TLS check starts to warn
+	certificateExpiryWarning = 14 * 24 * time.Hour
+
+	httpsPort = "443"
+)
+
+// Status of a single check
+type Status string
+
+const (
+	StatusOK      Status = "ok"
+	StatusWarning Status = "warning"
+	StatusFailed  Status = "failed"
+	StatusSkipped Status = "skipped"
+)
+
+// Report holds the results of all the checks run for a domain
+type Report struct {
+	Domain string  `json:"domain"`
+	Checks []Check `json:"checks"`
+}
+
+// Check is the result of a single diagnostic check
+type Check struct {
+	Name    string            `json:"name"`
+	Status  Status            `json:"status"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// Resolver looks up DNS records, it is implemented by *net.Resolver
+type Resolver interface {
+	LookupCNAME(ctx context.Context, host string) (string, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// DialContextFunc opens a network connection to addr
+type DialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Option function to configure Diagnostics
+type Option func(*Diagnostics)
+
+// Diagnostics runs DNS and TLS checks for custom domains
+type Diagnostics struct {
+	source      source.Source
+	pagesDomain string
+	secret      []byte
+	resolver    Resolver
+	dial        DialContextFunc
+	rootCAs     *x509.CertPool
+	timeout     time.Duration
+	now         func() time.Time
+}
+
+// New creates a new Diagnostics with default values that can be configured
+// via Option functions. secret is used to authenticate diagnostic requests and
+// pagesDomain is the domain of this GitLab Pages instance.
+func New(src source.Source, pagesDomain string, secret []byte, opts ...Option) *Diagnostics {
+	dialer := &net.Dialer{}
+
+	d := &Diagnostics{
+		source:      src,
+		pagesDomain: strings.ToLower(pagesDomain),
+		secret:      secret,
+		resolver:    net.DefaultResolver,
+		dial:        dialer.DialContext,
+		timeout:     DefaultTimeout,
+		now:         time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d
+}
+
+// WithResolver replaces the DNS resolver used by the checks
+func WithResolver(resolver Resolver) Option {
+	return func(d *Diagnostics) {
+		d.resolver = resolver
+	}
+}
+
+// WithDialContext replaces the function used to connect to the domain
+// during the TLS check
+func WithDialContext(dial DialContextFunc) Option {
+	return func(d *Diagnostics) {
+		d.dial = dial
+	}
+}
+
+// WithRootCAs configures the root CAs used to verify certificates, the
+// system pool is used by default
+func WithRootCAs(rootCAs *x509.CertPool) Option {
+	return func(d *Diagnostics) {
+		d.rootCAs = rootCAs
+	}
+}
+
+// WithTimeout configures the maximum time spent checking a domain
+func WithTimeout(timeout time.Duration) Option {
+	return func(d *Diagnostics) {
+		d.timeout = timeout
+	}
+}
+
+// WithNow replaces the Diagnostics now function
+func WithNow(now func() time.Time) Option {
+	return func(d *Diagnostics) {
+		d.now = now
+	}
+}
+
+// Check runs all the diagnostic checks for the given domain
+func (d *Diagnostics) Check(ctx context.Context, domain string) *Report {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	dnsCheck, cname, addrs := d.checkDNS(ctx, domain)
+
+	return &Report{
+		Domain: domain,
+		Checks: []Check{
+			d.checkConfiguration(ctx, domain),
+			dnsCheck,
+			d.checkInstance(ctx, dnsCheck, cname, addrs),
+			d.checkTLS(ctx, domain),
+		},
+	}
+}
+
+func (d *Diagnostics) checkConfiguration(ctx context.Context, domain string) Check {
+	check := Check{Name: "configuration"}
+
+	if dom, err := d.source.GetDomain(ctx, domain); err != nil || dom == nil {
+		check.Status = StatusFailed
+		check.Message = "domain is not configured in GitLab Pages"
+		return check
+	}
+
+	check.Status = StatusOK
+	check.Message = "domain is configured in GitLab Pages"
+
+	return check
+}
+
+func (d *Diagnostics) checkDNS(ctx context.Context, domain string) (Check, string, []string) {
+	check := Check{Name: "dns", Details: map[string]string{}}
+
+	// a missing CNAME record is not an error, apex domains use A/AAAA or ALIAS records
+	cname, _ := d.resolver.LookupCNAME(ctx, domain)
+	cname = strings.ToLower(strings.TrimSuffix(cname, "."))
+	if cname == domain {
+		cname = ""
+	}
+	if cname != "" {
+		check.Details["cname"] = cname
+	}
+
+	addrs, err := d.resolver.LookupHost(ctx, domain)
+	if err != nil || len(addrs) == 0 {
+		check.Status = StatusFailed
+		check.Message = "domain does not resolve to any address"
+		if err != nil {
+			check.Details["error"] = err.Error()
+		}
+
+		return check, cname, nil
+	}
+
+	check.Status = StatusOK
+	check.Message = "domain resolves"
+	check.Details["addresses"] = strings.Join(addrs, ",")
+
+	return check, cname, addrs
+}
+
+func (d *Diagnostics) checkInstance(ctx context.Context, dnsCheck Check, cname string, addrs []string) Check {
+	check := Check{Name: "instance"}
+
+	if dnsCheck.Status != StatusOK {
+		check.Status = StatusSkipped
+		check.Message = "domain does not resolve"
+		return check
+	}
+
+	if cname == d.pagesDomain || strings.HasSuffix(cname, "."+d.pagesDomain) {
+		check.Status = StatusOK
+		check.Message = fmt.Sprintf("domain is a CNAME to %s", d.pagesDomain)
+		return check
+	}
+
+	instanceAddrs, err := d.resolver.LookupHost(ctx, d.pagesDomain)
+	if err != nil {
+		check.Status = StatusWarning
+		check.Message = fmt.Sprintf("failed to resolve %s: %v", d.pagesDomain, err)
+		return check
+	}
+
+	for _, addr := range addrs {
+		for _, instanceAddr := range instanceAddrs {
+			if net.ParseIP(addr).Equal(net.ParseIP(instanceAddr)) {
+				check.Status = StatusOK
+				check.Message = fmt.Sprintf("domain resolves to the same address as %s", d.pagesDomain)
+				return check
+			}
+		}
+	}
+
+	check.Status = StatusFailed
+	check.Message = fmt.Sprintf("domain does not point to %s, add a CNAME record to %s or an A/AAAA record with its address", d.pagesDomain, d.pagesDomain)
+
+	return check
+}
+
+func (d *Diagnostics) checkTLS(ctx context.Context, domain string) Check {
+	check := Check{Name: "tls", Details: map[string]string{}}
+
+	rawConn, err := d.dial(ctx, "tcp", net.JoinHostPort(domain, httpsPort))
+	if err != nil {
+		check.Status = StatusFailed
+		check.Message = "failed to connect to the HTTPS port"
+		check.Details["error"] = err.Error()
+		return check
+	}
+	defer rawConn.Close()
+
+	conn := tls.Client(rawConn, &tls.Config{
+		ServerName: domain,
+		RootCAs:    d.rootCAs,
+		MinVersion: tls.VersionTLS12,
+	})
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if err := conn.Handshake(); err != nil {
+		check.Status = StatusFailed
+		check.Message = "TLS handshake failed, the certificate may be missing, expired or not valid for the domain"
+		check.Details["error"] = err.Error()
+		return check
+	}
+
+	cert := conn.ConnectionState().PeerCertificates[0]
+	check.Details["issuer"] = cert.Issuer.String()
+	check.Details["not_after"] = cert.NotAfter.UTC().Format(time.RFC3339)
+
+	if cert.NotAfter.Sub(d.now()) < certificateExpiryWarning {
+		check.Status = StatusWarning
+		check.Message = "certificate expires soon"
+		return check
+	}
+
+	check.Status = StatusOK
+	check.Message = "domain serves a valid certificate"
+
+	return check
+}
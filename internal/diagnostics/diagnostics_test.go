@@ -0,0 +1,173 @@
+package diagnostics
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/mocks"
+)
+
+type stubResolver struct {
+	cnames map[string]string
+	hosts  map[string][]string
+}
+
+func (r *stubResolver) LookupCNAME(_ context.Context, host string) (string, error) {
+	if cname, ok := r.cnames[host]; ok {
+		return cname, nil
+	}
+
+	return "", errors.New("no such host")
+}
+
+func (r *stubResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	if addrs, ok := r.hosts[host]; ok {
+		return addrs, nil
+	}
+
+	return nil, errors.New("no such host")
+}
+
+// newTestDiagnostics returns Diagnostics connecting to a TLS server with a
+// certificate valid for example.com and its subdomains
+func newTestDiagnostics(t *testing.T, resolver Resolver, opts ...Option) (*Diagnostics, *x509.Certificate) {
+	t.Helper()
+
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(server.Close)
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(server.Certificate())
+
+	mockCtrl := gomock.NewController(t)
+	mockSource := mocks.NewMockSource(mockCtrl)
+	mockSource.EXPECT().GetDomain(gomock.Any(), "example.com").Return(&domain.Domain{Name: "example.com"}, nil).AnyTimes()
+	mockSource.EXPECT().GetDomain(gomock.Any(), gomock.Any()).Return(nil, domain.ErrDomainDoesNotExist).AnyTimes()
+
+	dialer := &net.Dialer{}
+	dial := func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, server.Listener.Addr().String())
+	}
+
+	opts = append([]Option{WithResolver(resolver), WithDialContext(dial), WithRootCAs(rootCAs)}, opts...)
+
+	return New(mockSource, "gitlab-example.com", []byte("secret"), opts...), server.Certificate()
+}
+
+func TestCheck(t *testing.T) {
+	resolver := &stubResolver{
+		cnames: map[string]string{
+			"example.com":       "example.com.",
+			"www.example.test":  "group.gitlab-example.com.",
+			"blog.example.test": "elsewhere.example.net.",
+		},
+		hosts: map[string][]string{
+			"gitlab-example.com": {"10.0.0.1"},
+			"example.com":        {"10.0.0.1"},
+			"www.example.test":   {"10.0.0.1"},
+			"blog.example.test":  {"10.0.0.2"},
+		},
+	}
+
+	tests := map[string]struct {
+		domain   string
+		expected map[string]Status
+	}{
+		"apex_domain_with_a_record": {
+			domain: "example.com",
+			expected: map[string]Status{
+				"configuration": StatusOK,
+				"dns":           StatusOK,
+				"instance":      StatusOK,
+				"tls":           StatusOK,
+			},
+		},
+		"cname_to_pages_domain": {
+			domain: "www.example.test",
+			expected: map[string]Status{
+				"configuration": StatusFailed,
+				"dns":           StatusOK,
+				"instance":      StatusOK,
+				"tls":           StatusFailed,
+			},
+		},
+		"pointing_elsewhere": {
+			domain: "blog.example.test",
+			expected: map[string]Status{
+				"configuration": StatusFailed,
+				"dns":           StatusOK,
+				"instance":      StatusFailed,
+				"tls":           StatusFailed,
+			},
+		},
+		"not_resolving": {
+			domain: "missing.example.test",
+			expected: map[string]Status{
+				"configuration": StatusFailed,
+				"dns":           StatusFailed,
+				"instance":      StatusSkipped,
+				"tls":           StatusFailed,
+			},
+		},
+	}
+
+	d, _ := newTestDiagnostics(t, resolver)
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			report := d.Check(context.Background(), tt.domain)
+			require.Equal(t, tt.domain, report.Domain)
+
+			statuses := map[string]Status{}
+			for _, check := range report.Checks {
+				statuses[check.Name] = check.Status
+			}
+
+			require.Equal(t, tt.expected, statuses)
+		})
+	}
+}
+
+func TestCheckCertificateExpiresSoon(t *testing.T) {
+	resolver := &stubResolver{hosts: map[string][]string{"example.com": {"10.0.0.1"}}}
+
+	var now time.Time
+	d, cert := newTestDiagnostics(t, resolver, WithNow(func() time.Time { return now }))
+
+	tests := map[string]struct {
+		now      time.Time
+		expected Status
+	}{
+		"valid_for_long": {
+			now:      cert.NotAfter.Add(-30 * 24 * time.Hour),
+			expected: StatusOK,
+		},
+		"expires_soon": {
+			now:      cert.NotAfter.Add(-24 * time.Hour),
+			expected: StatusWarning,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			now = tt.now
+
+			report := d.Check(context.Background(), "example.com")
+			tlsCheck := report.Checks[len(report.Checks)-1]
+
+			require.Equal(t, "tls", tlsCheck.Name)
+			require.Equal(t, tt.expected, tlsCheck.Status)
+			require.Equal(t, cert.NotAfter.UTC().Format(time.RFC3339), tlsCheck.Details["not_after"])
+		})
+	}
+}
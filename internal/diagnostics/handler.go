@@ -0,0 +1,60 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/security"
+)
+
+// NewMiddleware returns middleware which serves the diagnostics report for
+// the domain given in the `domain` query parameter on path. Requests must be
+// authenticated with a JWT token signed with the GitLab API secret.
+func NewMiddleware(handler http.Handler, path string, d *Diagnostics) http.Handler {
+	if path == "" {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		d.ServeHTTP(w, r)
+	})
+}
+
+// ServeHTTP authenticates the request and writes the JSON diagnostics report
+func (d *Diagnostics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := d.authenticate(r); err != nil {
+		log.WithError(err).Warn("unauthorized domain diagnostics request")
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	domain := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("domain")))
+	if domain == "" || len(domain) > security.MaxDomainLength || strings.ContainsAny(domain, "/:") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "a valid domain parameter is required"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, d.Check(r.Context(), domain))
+}
+
+func (d *Diagnostics) authenticate(r *http.Request) error {
+	return security.VerifyAPIToken(r.Header.Get(security.APIRequestHeader), d.secret)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.WithError(err).Error("failed to write domain diagnostics response")
+	}
+}
@@ -0,0 +1,111 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/security"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/testhelpers"
+)
+
+func TestNewMiddleware(t *testing.T) {
+	d, _ := newTestDiagnostics(t, &stubResolver{hosts: map[string][]string{"example.com": {"10.0.0.1"}}})
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := NewMiddleware(next, "/@diagnostics", d)
+
+	validToken := testhelpers.SignAPIToken(t, []byte("secret"), jwt.NewNumericDate(time.Now().Add(time.Minute)))
+
+	tests := map[string]struct {
+		url            string
+		token          string
+		expectedStatus int
+	}{
+		"other_path": {
+			url:            "/index.html",
+			expectedStatus: http.StatusTeapot,
+		},
+		"missing_token": {
+			url:            "/@diagnostics?domain=example.com",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"wrong_secret": {
+			url:            "/@diagnostics?domain=example.com",
+			token:          testhelpers.SignAPIToken(t, []byte("other"), jwt.NewNumericDate(time.Now().Add(time.Minute))),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"expired_token": {
+			url:            "/@diagnostics?domain=example.com",
+			token:          testhelpers.SignAPIToken(t, []byte("secret"), jwt.NewNumericDate(time.Now().Add(-time.Minute))),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"token_without_expiration": {
+			url:            "/@diagnostics?domain=example.com",
+			token:          testhelpers.SignAPIToken(t, []byte("secret"), nil),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"missing_domain": {
+			url:            "/@diagnostics",
+			token:          validToken,
+			expectedStatus: http.StatusBadRequest,
+		},
+		"invalid_domain": {
+			url:            "/@diagnostics?domain=example.com:8080",
+			token:          validToken,
+			expectedStatus: http.StatusBadRequest,
+		},
+		"valid_request": {
+			url:            "/@diagnostics?domain=Example.com",
+			token:          validToken,
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.token != "" {
+				req.Header.Set(security.APIRequestHeader, tt.token)
+			}
+
+			ww := httptest.NewRecorder()
+			handler.ServeHTTP(ww, req)
+
+			require.Equal(t, tt.expectedStatus, ww.Code)
+		})
+	}
+
+	t.Run("report", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/@diagnostics?domain=example.com", nil)
+		req.Header.Set(security.APIRequestHeader, validToken)
+
+		ww := httptest.NewRecorder()
+		handler.ServeHTTP(ww, req)
+
+		require.Equal(t, "application/json", ww.Header().Get("Content-Type"))
+
+		var report Report
+		require.NoError(t, json.NewDecoder(ww.Body).Decode(&report))
+		require.Equal(t, "example.com", report.Domain)
+		require.Len(t, report.Checks, 4)
+	})
+}
+
+func TestNewMiddlewareDisabled(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	handler := NewMiddleware(next, "", nil)
+	require.NotNil(t, handler)
+
+	ww := httptest.NewRecorder()
+	handler.ServeHTTP(ww, httptest.NewRequest(http.MethodGet, "/@diagnostics", nil))
+	require.Equal(t, http.StatusOK, ww.Code)
+}
@@ -0,0 +1,319 @@
+// Package doctor runs a set of checks against a running GitLab Pages daemon
+// to verify it is ready to serve requests after a deployment.
+package doctor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/diagnostics"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/security"
+)
+
+const (
+	tokenExpiry    = time.Minute
+	defaultTimeout = 5 * time.Second
+
+	// diagnosticsConfigurationCheck is the name of the diagnostics check
+	// resolving the domain configuration from the domains source
+	diagnosticsConfigurationCheck = "configuration"
+)
+
+// Status of a check
+type Status string
+
+const (
+	// StatusOK means the check succeeded
+	StatusOK Status = "ok"
+	// StatusFailed means the check failed
+	StatusFailed Status = "failed"
+	// StatusSkipped means the check was not configured
+	StatusSkipped Status = "skipped"
+)
+
+// Result of a single check
+type Result struct {
+	Name      string  `json:"name"`
+	Status    Status  `json:"status"`
+	Message   string  `json:"message,omitempty"`
+	LatencyMs float64 `json:"latency_ms,omitempty"`
+}
+
+// Report is the readiness report of a Pages instance
+type Report struct {
+	URL     string   `json:"url"`
+	Ready   bool     `json:"ready"`
+	Results []Result `json:"results"`
+}
+
+// Option to configure the checks
+type Option func(*Doctor)
+
+// Doctor checks a running GitLab Pages instance
+type Doctor struct {
+	baseURL         *url.URL
+	client          *http.Client
+	timeout         time.Duration
+	statusPath      string
+	domain          string
+	path            string
+	expectedStatus  int
+	metricsURL      string
+	diagnosticsPath string
+	apiSecret       []byte
+}
+
+// New returns a Doctor for the Pages instance listening on baseURL
+func New(baseURL string, opts ...Option) (*Doctor, error) {
+	parsedURL, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL scheme %q", parsedURL.Scheme)
+	}
+
+	d := &Doctor{
+		baseURL:        parsedURL,
+		client:         &http.Client{},
+		timeout:        defaultTimeout,
+		path:           "/",
+		expectedStatus: http.StatusOK,
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	// do not follow redirects so the synthetic request reports what the
+	// instance actually served
+	client := *d.client
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	d.client = &client
+
+	return d, nil
+}
+
+// WithHTTPClient sets the HTTP client used for all the requests
+func WithHTTPClient(client *http.Client) Option {
+	return func(d *Doctor) {
+		d.client = client
+	}
+}
+
+// WithTimeout sets the timeout of each check
+func WithTimeout(timeout time.Duration) Option {
+	return func(d *Doctor) {
+		d.timeout = timeout
+	}
+}
+
+// WithStatusPath checks the status page served on path
+func WithStatusPath(path string) Option {
+	return func(d *Doctor) {
+		d.statusPath = path
+	}
+}
+
+// WithSyntheticRequest requests path for domain and expects expectedStatus
+func WithSyntheticRequest(domain, path string, expectedStatus int) Option {
+	return func(d *Doctor) {
+		d.domain = domain
+		d.path = path
+		d.expectedStatus = expectedStatus
+	}
+}
+
+// WithMetricsURL checks the Prometheus metrics endpoint at metricsURL
+func WithMetricsURL(metricsURL string) Option {
+	return func(d *Doctor) {
+		d.metricsURL = metricsURL
+	}
+}
+
+// WithDiagnostics requests the domain diagnostics served on path,
+// authenticated with the GitLab API secret
+func WithDiagnostics(path string, apiSecret []byte) Option {
+	return func(d *Doctor) {
+		d.diagnosticsPath = path
+		d.apiSecret = apiSecret
+	}
+}
+
+// Run runs all the checks and returns the readiness report. The instance is
+// ready when none of the checks failed.
+func (d *Doctor) Run(ctx context.Context) *Report {
+	report := &Report{
+		URL: d.baseURL.String(),
+		Results: []Result{
+			d.checkStatus(ctx),
+			d.checkSyntheticRequest(ctx),
+			d.checkDiagnostics(ctx),
+			d.checkMetrics(ctx),
+		},
+	}
+
+	report.Ready = true
+	for _, result := range report.Results {
+		if result.Status == StatusFailed {
+			report.Ready = false
+		}
+	}
+
+	return report
+}
+
+func (d *Doctor) checkStatus(ctx context.Context) Result {
+	if d.statusPath == "" {
+		return skipped("status", "no status path given")
+	}
+
+	return d.request(ctx, "status", d.resolve(d.statusPath, nil), http.StatusOK, nil, nil)
+}
+
+func (d *Doctor) checkSyntheticRequest(ctx context.Context) Result {
+	if d.domain == "" {
+		return skipped("synthetic_request", "no domain given")
+	}
+
+	setHost := func(r *http.Request) {
+		r.Host = d.domain
+	}
+
+	return d.request(ctx, "synthetic_request", d.resolve(d.path, nil), d.expectedStatus, setHost, nil)
+}
+
+// checkDiagnostics requests the domain diagnostics report. Only a failing
+// configuration check fails the readiness, DNS and TLS problems of the domain
+// are reported in the message.
+func (d *Doctor) checkDiagnostics(ctx context.Context) Result {
+	const name = "diagnostics"
+
+	if d.diagnosticsPath == "" || d.domain == "" {
+		return skipped(name, "no diagnostics path or domain given")
+	}
+
+	token, err := security.NewAPIToken(d.apiSecret, tokenExpiry)
+	if err != nil {
+		return failed(name, 0, "signing API token: %v", err)
+	}
+
+	setToken := func(r *http.Request) {
+		r.Header.Set(security.APIRequestHeader, token)
+	}
+
+	var diagnosticsReport diagnostics.Report
+	decodeReport := func(body io.Reader) error {
+		return json.NewDecoder(body).Decode(&diagnosticsReport)
+	}
+
+	diagnosticsURL := d.resolve(d.diagnosticsPath, url.Values{"domain": {d.domain}})
+
+	result := d.request(ctx, name, diagnosticsURL, http.StatusOK, setToken, decodeReport)
+	if result.Status != StatusOK {
+		return result
+	}
+
+	var problems []string
+	for _, check := range diagnosticsReport.Checks {
+		if check.Status != diagnostics.StatusFailed {
+			continue
+		}
+
+		problems = append(problems, fmt.Sprintf("%s: %s", check.Name, check.Message))
+		if check.Name == diagnosticsConfigurationCheck {
+			result.Status = StatusFailed
+		}
+	}
+
+	if len(problems) > 0 {
+		result.Message = strings.Join(problems, "; ")
+	}
+
+	return result
+}
+
+func (d *Doctor) checkMetrics(ctx context.Context) Result {
+	if d.metricsURL == "" {
+		return skipped("metrics", "no metrics URL given")
+	}
+
+	return d.request(ctx, "metrics", d.metricsURL, http.StatusOK, nil, nil)
+}
+
+// request sends a GET request to rawURL and checks the response status.
+// The optional prepare function can modify the request before it is sent and
+// the optional decode function is called with the body of expected responses.
+func (d *Doctor) request(ctx context.Context, name, rawURL string, expectedStatus int,
+	prepare func(*http.Request), decode func(io.Reader) error) Result {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return failed(name, 0, "creating request: %v", err)
+	}
+
+	if prepare != nil {
+		prepare(req)
+	}
+
+	start := time.Now()
+	resp, err := d.client.Do(req)
+	latency := time.Since(start)
+	if err != nil {
+		return failed(name, latency, "request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != expectedStatus {
+		return failed(name, latency, "expected status %d but got %d", expectedStatus, resp.StatusCode)
+	}
+
+	if decode != nil {
+		if err := decode(resp.Body); err != nil {
+			return failed(name, latency, "invalid response: %v", err)
+		}
+	}
+
+	return Result{
+		Name:      name,
+		Status:    StatusOK,
+		Message:   fmt.Sprintf("GET %s returned %d", req.URL.Path, resp.StatusCode),
+		LatencyMs: milliseconds(latency),
+	}
+}
+
+func (d *Doctor) resolve(path string, query url.Values) string {
+	u := *d.baseURL
+	u.Path = path
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+func skipped(name, message string) Result {
+	return Result{Name: name, Status: StatusSkipped, Message: message}
+}
+
+func failed(name string, latency time.Duration, format string, args ...interface{}) Result {
+	return Result{
+		Name:      name,
+		Status:    StatusFailed,
+		Message:   fmt.Sprintf(format, args...),
+		LatencyMs: milliseconds(latency),
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
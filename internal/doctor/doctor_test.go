@@ -0,0 +1,205 @@
+package doctor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/diagnostics"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/security"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func newTestServer(t *testing.T, configurationStatus diagnostics.Status) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/@status", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/@diagnostics", func(w http.ResponseWriter, r *http.Request) {
+		if err := security.VerifyAPIToken(r.Header.Get(security.APIRequestHeader), testSecret); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		json.NewEncoder(w).Encode(diagnostics.Report{
+			Domain: r.URL.Query().Get("domain"),
+			Checks: []diagnostics.Check{
+				{Name: "configuration", Status: configurationStatus, Message: "configuration message"},
+				{Name: "dns", Status: diagnostics.StatusFailed, Message: "no DNS records"},
+			},
+		})
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Host != "group.example.com":
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Path == "/redirect":
+			http.Redirect(w, r, "/", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func statuses(report *Report) map[string]Status {
+	result := make(map[string]Status, len(report.Results))
+	for _, r := range report.Results {
+		result[r.Name] = r.Status
+	}
+
+	return result
+}
+
+func TestRun(t *testing.T) {
+	tests := map[string]struct {
+		configurationStatus diagnostics.Status
+		opts                func(serverURL string) []Option
+		expectedStatuses    map[string]Status
+		expectedReady       bool
+	}{
+		"all_checks_skipped": {
+			opts: func(string) []Option { return nil },
+			expectedStatuses: map[string]Status{
+				"status":            StatusSkipped,
+				"synthetic_request": StatusSkipped,
+				"diagnostics":       StatusSkipped,
+				"metrics":           StatusSkipped,
+			},
+			expectedReady: true,
+		},
+		"all_checks_ok": {
+			configurationStatus: diagnostics.StatusOK,
+			opts: func(serverURL string) []Option {
+				return []Option{
+					WithStatusPath("/@status"),
+					WithSyntheticRequest("group.example.com", "/index.html", http.StatusOK),
+					WithDiagnostics("/@diagnostics", testSecret),
+					WithMetricsURL(serverURL + "/@status"),
+				}
+			},
+			expectedStatuses: map[string]Status{
+				"status":            StatusOK,
+				"synthetic_request": StatusOK,
+				"diagnostics":       StatusOK,
+				"metrics":           StatusOK,
+			},
+			expectedReady: true,
+		},
+		"unexpected_synthetic_status": {
+			opts: func(string) []Option {
+				return []Option{WithSyntheticRequest("unknown.example.com", "/", http.StatusOK)}
+			},
+			expectedStatuses: map[string]Status{
+				"status":            StatusSkipped,
+				"synthetic_request": StatusFailed,
+				"diagnostics":       StatusSkipped,
+				"metrics":           StatusSkipped,
+			},
+		},
+		"redirects_are_not_followed": {
+			opts: func(string) []Option {
+				return []Option{WithSyntheticRequest("group.example.com", "/redirect", http.StatusFound)}
+			},
+			expectedStatuses: map[string]Status{
+				"status":            StatusSkipped,
+				"synthetic_request": StatusOK,
+				"diagnostics":       StatusSkipped,
+				"metrics":           StatusSkipped,
+			},
+			expectedReady: true,
+		},
+		"diagnostics_wrong_secret": {
+			opts: func(string) []Option {
+				return []Option{
+					WithSyntheticRequest("group.example.com", "/", http.StatusOK),
+					WithDiagnostics("/@diagnostics", []byte("wrong")),
+				}
+			},
+			expectedStatuses: map[string]Status{
+				"status":            StatusSkipped,
+				"synthetic_request": StatusOK,
+				"diagnostics":       StatusFailed,
+				"metrics":           StatusSkipped,
+			},
+		},
+		"diagnostics_configuration_failed": {
+			configurationStatus: diagnostics.StatusFailed,
+			opts: func(string) []Option {
+				return []Option{
+					WithSyntheticRequest("group.example.com", "/", http.StatusOK),
+					WithDiagnostics("/@diagnostics", testSecret),
+				}
+			},
+			expectedStatuses: map[string]Status{
+				"status":            StatusSkipped,
+				"synthetic_request": StatusOK,
+				"diagnostics":       StatusFailed,
+				"metrics":           StatusSkipped,
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := newTestServer(t, tt.configurationStatus)
+
+			d, err := New(server.URL, tt.opts(server.URL)...)
+			require.NoError(t, err)
+
+			report := d.Run(context.Background())
+			require.Equal(t, server.URL, report.URL)
+			require.Equal(t, tt.expectedStatuses, statuses(report))
+			require.Equal(t, tt.expectedReady, report.Ready)
+		})
+	}
+}
+
+func TestRunDiagnosticsMessage(t *testing.T) {
+	server := newTestServer(t, diagnostics.StatusOK)
+
+	d, err := New(server.URL,
+		WithSyntheticRequest("group.example.com", "/", http.StatusOK),
+		WithDiagnostics("/@diagnostics", testSecret),
+	)
+	require.NoError(t, err)
+
+	report := d.Run(context.Background())
+	require.Equal(t, "dns: no DNS records", report.Results[2].Message)
+	require.Equal(t, StatusOK, report.Results[2].Status)
+}
+
+func TestRunTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	d, err := New(server.URL, WithStatusPath("/@status"), WithTimeout(10*time.Millisecond))
+	require.NoError(t, err)
+
+	report := d.Run(context.Background())
+	require.False(t, report.Ready)
+	require.Equal(t, StatusFailed, report.Results[0].Status)
+	require.Contains(t, report.Results[0].Message, "context deadline exceeded")
+}
+
+func TestNewInvalidURL(t *testing.T) {
+	_, err := New("127.0.0.1:8080")
+	require.Error(t, err)
+
+	_, err = New("ftp://127.0.0.1")
+	require.EqualError(t, err, `unsupported URL scheme "ftp"`)
+}
@@ -1,15 +1,20 @@
 package domain
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
-
-	"gitlab.com/gitlab-org/labkit/errortracking"
+	"time"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httperrors"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/pageserrors"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
 )
 
@@ -17,6 +22,24 @@ import (
 // for a domain could not be resolved
 var ErrDomainDoesNotExist = errors.New("domain does not exist")
 
+// ErrDomainDeleted is returned when GitLab reports that a domain was deleted,
+// rather than never existed. It wraps ErrDomainDoesNotExist so the domain is
+// not served and cached like the domains which do not exist.
+var ErrDomainDeleted = fmt.Errorf("%w: it was deleted", ErrDomainDoesNotExist)
+
+// ErrDomainUnverified is returned when resolving the projects of a custom
+// domain whose ownership is not verified. It wraps ErrDomainDoesNotExist so
+// nothing of the projects is served.
+var ErrDomainUnverified = fmt.Errorf("%w: it is not verified", ErrDomainDoesNotExist)
+
+var (
+	// ErrCertificateSelfSigned is returned by VerifyCertificate for self-signed certificates
+	ErrCertificateSelfSigned = errors.New("certificate is self-signed")
+	// ErrCertificateExpired is returned by VerifyCertificate for certificates
+	// which are expired or not valid yet
+	ErrCertificateExpired = errors.New("certificate is expired or not valid yet")
+)
+
 // Domain is a domain that gitlab-pages can serve.
 type Domain struct {
 	Name            string
@@ -25,9 +48,51 @@ type Domain struct {
 
 	Resolver Resolver
 
-	certificate      *tls.Certificate
-	certificateError error
-	certificateOnce  sync.Once
+	// TLSPolicy overrides the instance TLS settings for this domain, it is
+	// nil when the domain uses the instance defaults
+	TLSPolicy *TLSPolicy
+
+	// EmbeddingPolicy allows the domain to be embedded in frames of other
+	// sites, it is nil when embedding is not enabled for the domain
+	EmbeddingPolicy *EmbeddingPolicy
+
+	// PagesURL is the HTTPS URL of the project on the pages domain, which
+	// HTTP requests are redirected to when the domain has no certificate.
+	// It is empty when the domain should not be redirected.
+	PagesURL string
+
+	// PrimaryDomain is the hostname requests are redirected to when the
+	// domain is an alias of another one, it is empty otherwise
+	PrimaryDomain string
+
+	// Unverified is true for the custom domains whose ownership is not
+	// verified, which only serve a placeholder page
+	Unverified bool
+
+	certificate           *tls.Certificate
+	certificateError      error
+	certificateSelfSigned bool
+	certificateOnce       sync.Once
+	certificateReported   sync.Once
+}
+
+// TLSPolicy holds TLS settings that override the instance defaults for a
+// domain
+type TLSPolicy struct {
+	MinVersion   uint16
+	DisableHTTP2 bool
+}
+
+// EmbeddingPolicy holds the sites allowed to embed the pages of a domain in
+// frames, authenticated pages included
+type EmbeddingPolicy struct {
+	FrameAncestors []string
+}
+
+// ContentSecurityPolicy returns the frame-ancestors directive allowing the
+// domain itself and the frame ancestors of the policy to embed its pages
+func (p *EmbeddingPolicy) ContentSecurityPolicy() string {
+	return "frame-ancestors " + strings.Join(append([]string{"'self'"}, p.FrameAncestors...), " ")
 }
 
 // New creates a new domain with a resolver and existing certificates
@@ -50,7 +115,11 @@ func (d *Domain) resolve(r *http.Request) (*serving.Request, error) {
 		return nil, ErrDomainDoesNotExist
 	}
 
-	return d.Resolver.Resolve(r)
+	if d.Unverified {
+		return nil, ErrDomainUnverified
+	}
+
+	return resolveOnce(d, r, d.Resolver.Resolve)
 }
 
 // GetLookupPath returns a project details based on the request. It returns nil
@@ -101,6 +170,67 @@ func (d *Domain) GetProjectID(r *http.Request) uint64 {
 	return 0
 }
 
+// HasCertificate returns true when the domain has a custom certificate
+func (d *Domain) HasCertificate() bool {
+	return d != nil && len(d.CertificateKey) > 0 && len(d.CertificateCert) > 0
+}
+
+// PagesDomainURL returns the URL on the pages domain serving the request
+// when the domain has no certificate, or an empty string
+func (d *Domain) PagesDomainURL(r *http.Request) string {
+	if d == nil || d.PagesURL == "" || d.HasCertificate() {
+		return ""
+	}
+
+	lookupPath, err := d.GetLookupPath(r)
+	if err != nil {
+		return ""
+	}
+
+	u, err := url.Parse(d.PagesURL)
+	if err != nil {
+		return ""
+	}
+
+	subPath := strings.TrimPrefix(r.URL.Path, lookupPath.Prefix)
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(subPath, "/")
+	u.RawQuery = r.URL.RawQuery
+
+	return u.String()
+}
+
+// VerifyCertificate checks that the leaf certificate is valid at now and
+// is not self-signed. The chain of trust is left to clients to verify.
+func VerifyCertificate(cert *tls.Certificate, now time.Time) error {
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+
+		leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	if !validAt(leaf, now) {
+		return ErrCertificateExpired
+	}
+
+	if selfSigned(leaf) {
+		return ErrCertificateSelfSigned
+	}
+
+	return nil
+}
+
+func validAt(leaf *x509.Certificate, now time.Time) bool {
+	return !now.Before(leaf.NotBefore) && !now.After(leaf.NotAfter)
+}
+
+func selfSigned(leaf *x509.Certificate) bool {
+	return bytes.Equal(leaf.RawIssuer, leaf.RawSubject) && leaf.CheckSignature(leaf.SignatureAlgorithm, leaf.RawTBSCertificate, leaf.Signature) == nil
+}
+
 // EnsureCertificate parses the PEM-encoded certificate for the domain
 func (d *Domain) EnsureCertificate() (*tls.Certificate, error) {
 	if d == nil || len(d.CertificateKey) == 0 || len(d.CertificateCert) == 0 {
@@ -113,14 +243,50 @@ func (d *Domain) EnsureCertificate() (*tls.Certificate, error) {
 			[]byte(d.CertificateCert),
 			[]byte(d.CertificateKey),
 		)
-		if d.certificateError == nil {
-			d.certificate = &cert
+		if d.certificateError != nil {
+			return
+		}
+
+		cert.Leaf, d.certificateError = x509.ParseCertificate(cert.Certificate[0])
+		if d.certificateError != nil {
+			return
 		}
+
+		d.certificate = &cert
+		d.certificateSelfSigned = selfSigned(cert.Leaf)
 	})
 
 	return d.certificate, d.certificateError
 }
 
+// VerifyCertificate returns the certificate of the domain like
+// EnsureCertificate and verifies it like VerifyCertificate. The certificate
+// is parsed and checked for being self-signed once per domain, only its
+// validity period is checked on each call.
+func (d *Domain) VerifyCertificate(now time.Time) (*tls.Certificate, error) {
+	cert, err := d.EnsureCertificate()
+	if err != nil {
+		return nil, err
+	}
+
+	if !validAt(cert.Leaf, now) {
+		return cert, ErrCertificateExpired
+	}
+
+	if d.certificateSelfSigned {
+		return cert, ErrCertificateSelfSigned
+	}
+
+	return cert, nil
+}
+
+// ReportCertificateError calls report the first time it is called for the
+// domain, so an invalid certificate is reported once per domain rather than
+// on each TLS handshake
+func (d *Domain) ReportCertificateError(report func()) {
+	d.certificateReported.Do(report)
+}
+
 // ServeFileHTTP returns true if something was served, false if not.
 func (d *Domain) ServeFileHTTP(w http.ResponseWriter, r *http.Request) bool {
 	request, err := d.resolve(r)
@@ -131,8 +297,7 @@ func (d *Domain) ServeFileHTTP(w http.ResponseWriter, r *http.Request) bool {
 			return true
 		}
 
-		errortracking.Capture(err, errortracking.WithRequest(r), errortracking.WithStackTrace())
-		httperrors.Serve503(w)
+		httperrors.ServeError(w, r, "could not resolve domain", pageserrors.Wrap(pageserrors.SourceUnavailable, err))
 		return true
 	}
 
@@ -149,14 +314,21 @@ func (d *Domain) ServeNotFoundHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		errortracking.Capture(err, errortracking.WithRequest(r), errortracking.WithStackTrace())
-		httperrors.Serve503(w)
+		httperrors.ServeError(w, r, "could not resolve domain", pageserrors.Wrap(pageserrors.SourceUnavailable, err))
 		return
 	}
 
 	request.ServeNotFoundHTTP(w, r)
 }
 
+// ServeUnverifiedHTTP serves the placeholder page of an unverified domain on
+// all paths, so a domain pointed to Pages by someone who does not own it
+// serves no project. The ownership is proven by the owner of the domain
+// publishing its verification code, Pages never serves it.
+func (d *Domain) ServeUnverifiedHTTP(w http.ResponseWriter, r *http.Request) {
+	httperrors.ServeUnverified(w)
+}
+
 // serveNamespaceNotFound will try to find a parent namespace domain for a request
 // that failed authentication so that we serve the custom namespace error page for
 // public namespace domains
@@ -173,8 +345,7 @@ func (d *Domain) serveNamespaceNotFound(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
-		errortracking.Capture(err, errortracking.WithRequest(r), errortracking.WithStackTrace())
-		httperrors.Serve503(w)
+		httperrors.ServeError(w, r, "could not resolve domain", pageserrors.Wrap(pageserrors.SourceUnavailable, err))
 		return
 	}
 
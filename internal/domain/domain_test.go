@@ -1,15 +1,18 @@
 package domain
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/fixture"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/pageserrors"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/serving/disk/local"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/testhelpers"
@@ -95,6 +98,42 @@ func TestPredefined404ServeHTTP(t *testing.T) {
 	testhelpers.AssertHTTP404(t, serveFileOrNotFound(testDomain), "GET", "http://group.test.io/not-existing-file", nil, "The page you're looking for could not be found")
 }
 
+func TestServeFileHTTPLookupError(t *testing.T) {
+	testDomain := New("group.test.io", "", "", &stubbedResolver{err: errors.New("API is down")})
+
+	for name, serve := range map[string]http.HandlerFunc{
+		"file":      func(w http.ResponseWriter, r *http.Request) { testDomain.ServeFileHTTP(w, r) },
+		"not_found": testDomain.ServeNotFoundHTTP,
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			serve(w, httptest.NewRequest(http.MethodGet, "http://group.test.io/index.html", nil))
+
+			require.Equal(t, http.StatusServiceUnavailable, w.Code, "the project of the request could not be resolved")
+			require.Contains(t, w.Body.String(), pageserrors.SourceUnavailable.Code)
+		})
+	}
+}
+
+func TestServeUnverifiedHTTP(t *testing.T) {
+	testDomain := New("custom.com", "", "", &stubbedResolver{
+		project: &serving.LookupPath{Path: "group/project/public"},
+	})
+	testDomain.Unverified = true
+
+	r := httptest.NewRequest(http.MethodGet, "http://custom.com/index.html", nil)
+	_, err := testDomain.GetLookupPath(r)
+	require.ErrorIs(t, err, ErrDomainUnverified)
+	require.ErrorIs(t, err, ErrDomainDoesNotExist, "the projects of unverified domains are not served")
+
+	for _, path := range []string{"/index.html", "/.well-known/gitlab-pages-verification"} {
+		w := httptest.NewRecorder()
+		testDomain.ServeUnverifiedHTTP(w, httptest.NewRequest(http.MethodGet, "http://custom.com"+path, nil))
+		require.Equal(t, http.StatusNotFound, w.Code, path)
+		require.Contains(t, w.Body.String(), "This domain is not verified yet.", path)
+	}
+}
+
 func TestGroupCertificate(t *testing.T) {
 	testGroup := &Domain{}
 
@@ -117,6 +156,114 @@ func TestDomainNoCertificate(t *testing.T) {
 	require.Equal(t, err, err2)
 }
 
+func TestPagesDomainURL(t *testing.T) {
+	tests := map[string]struct {
+		domain   *Domain
+		prefix   string
+		url      string
+		expected string
+	}{
+		"custom_domain": {
+			domain:   &Domain{PagesURL: "https://group.gitlab.io/project"},
+			prefix:   "/",
+			url:      "http://example.com/path/index.html?q=1",
+			expected: "https://group.gitlab.io/project/path/index.html?q=1",
+		},
+		"namespace_project": {
+			domain:   &Domain{PagesURL: "https://group.gitlab.io/"},
+			prefix:   "/",
+			url:      "http://example.com/",
+			expected: "https://group.gitlab.io/",
+		},
+		"no_pages_url": {
+			domain: &Domain{},
+			prefix: "/",
+			url:    "http://example.com/",
+		},
+		"with_certificate": {
+			domain: &Domain{
+				PagesURL:        "https://group.gitlab.io/project",
+				CertificateCert: fixture.Certificate,
+				CertificateKey:  fixture.Key,
+			},
+			prefix: "/",
+			url:    "http://example.com/",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tt.domain.Name = "example.com"
+			tt.domain.Resolver = &stubbedResolver{project: &serving.LookupPath{Prefix: tt.prefix}}
+
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+
+			require.Equal(t, tt.expected, tt.domain.PagesDomainURL(r))
+		})
+	}
+}
+
+func TestVerifyCertificate(t *testing.T) {
+	now := time.Now()
+
+	tests := map[string]struct {
+		notBefore   time.Time
+		notAfter    time.Time
+		selfSigned  bool
+		expectedErr error
+	}{
+		"valid": {
+			notBefore: now.Add(-time.Hour),
+			notAfter:  now.Add(time.Hour),
+		},
+		"self_signed": {
+			notBefore:   now.Add(-time.Hour),
+			notAfter:    now.Add(time.Hour),
+			selfSigned:  true,
+			expectedErr: ErrCertificateSelfSigned,
+		},
+		"expired": {
+			notBefore:   now.Add(-2 * time.Hour),
+			notAfter:    now.Add(-time.Hour),
+			expectedErr: ErrCertificateExpired,
+		},
+		"not_valid_yet": {
+			notBefore:   now.Add(time.Hour),
+			notAfter:    now.Add(2 * time.Hour),
+			expectedErr: ErrCertificateExpired,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			cert, key := testhelpers.GenerateCertificate(t, "test.domain.com", tt.notBefore, tt.notAfter, tt.selfSigned)
+
+			d := &Domain{Name: "test.domain.com", CertificateCert: cert, CertificateKey: key}
+			require.True(t, d.HasCertificate())
+
+			tls, err := d.EnsureCertificate()
+			require.NoError(t, err)
+
+			require.ErrorIs(t, VerifyCertificate(tls, now), tt.expectedErr)
+
+			verified, err := d.VerifyCertificate(now)
+			require.ErrorIs(t, err, tt.expectedErr)
+			require.Same(t, tls, verified)
+		})
+	}
+}
+
+func TestReportCertificateError(t *testing.T) {
+	d := &Domain{Name: "test.domain.com"}
+
+	reports := 0
+	for i := 0; i < 3; i++ {
+		d.ReportCertificateError(func() { reports++ })
+	}
+
+	require.Equal(t, 1, reports)
+}
+
 func BenchmarkEnsureCertificate(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		testDomain := &Domain{
@@ -0,0 +1,33 @@
+package domain
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Headers of the project serving a response, for the logging and WAF layers in
+// front of Pages
+const (
+	ProjectIDHeader = "X-Gitlab-Pages-Project-Id"
+	NamespaceHeader = "X-Gitlab-Pages-Namespace"
+)
+
+// NewProjectHeadersMiddleware returns middleware which adds the ID and the
+// namespace of the project serving the request to its response, when they are
+// known. The headers disclose private projects too, so they must be removed
+// before the responses leave the trusted network.
+func NewProjectHeadersMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if lp, err := FromRequest(r).GetLookupPath(r); err == nil {
+			if lp.ProjectID != 0 {
+				w.Header().Set(ProjectIDHeader, strconv.FormatUint(lp.ProjectID, 10))
+			}
+
+			if lp.Namespace != "" {
+				w.Header().Set(NamespaceHeader, lp.Namespace)
+			}
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,56 @@
+package domain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
+)
+
+func TestProjectHeadersMiddleware(t *testing.T) {
+	tests := map[string]struct {
+		domain            *Domain
+		expectedProjectID string
+		expectedNamespace string
+	}{
+		"nil_domain": {
+			domain: nil,
+		},
+		"unresolved_domain": {
+			domain: New("githost.io", "", "", &resolver{err: ErrDomainDoesNotExist}),
+		},
+		"project": {
+			domain: New("group.gitlab.io", "", "", &resolver{f: func(*http.Request) *serving.LookupPath {
+				return &serving.LookupPath{ProjectID: 100, Namespace: "group/subgroup"}
+			}}),
+			expectedProjectID: "100",
+			expectedNamespace: "group/subgroup",
+		},
+		"unknown_namespace": {
+			domain: New("group.gitlab.io", "", "", &resolver{f: func(*http.Request) *serving.LookupPath {
+				return &serving.LookupPath{ProjectID: 100}
+			}}),
+			expectedProjectID: "100",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			handler := NewProjectHeadersMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "http://group.gitlab.io/", nil)
+			r = ReqWithHostAndDomain(r, "group.gitlab.io", tt.domain)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			require.Equal(t, tt.expectedProjectID, w.Header().Get(ProjectIDHeader))
+			require.Equal(t, tt.expectedNamespace, w.Header().Get(NamespaceHeader))
+		})
+	}
+}
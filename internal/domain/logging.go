@@ -29,3 +29,14 @@ func LogFields(r *http.Request) log.Fields {
 
 	return logFields
 }
+
+// ServingType returns the serving type of the project serving the request,
+// e.g. zip, or an empty string when the request has no project
+func ServingType(r *http.Request) string {
+	lp, err := FromRequest(r).GetLookupPath(r)
+	if err != nil {
+		return ""
+	}
+
+	return lp.ServingType
+}
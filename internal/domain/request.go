@@ -3,30 +3,101 @@ package domain
 import (
 	"context"
 	"net/http"
+	"sync"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
 )
 
 type ctxKey string
 
 const (
-	ctxHostKey   ctxKey = "host"
-	ctxDomainKey ctxKey = "domain"
+	ctxHostKey    ctxKey = "host"
+	ctxDomainKey  ctxKey = "domain"
+	ctxLookupKey  ctxKey = "lookup"
+	ctxDeletedKey ctxKey = "deleted"
 )
 
+// lookup is the serving request resolved for a domain the first time it is
+// needed by a request. The domains source can be updated while the request is
+// served, lookup makes sure every middleware sees the same lookup path.
+type lookup struct {
+	mu       sync.Mutex
+	domain   *Domain
+	path     string
+	resolved bool
+	request  *serving.Request
+	err      error
+}
+
 // ReqWithHostAndDomain saves host name and domain in the request's context
 func ReqWithHostAndDomain(r *http.Request, host string, domain *Domain) *http.Request {
 	ctx := r.Context()
 	ctx = context.WithValue(ctx, ctxHostKey, host)
 	ctx = context.WithValue(ctx, ctxDomainKey, domain)
+	ctx = context.WithValue(ctx, ctxLookupKey, &lookup{domain: domain})
 
 	return r.WithContext(ctx)
 }
 
-// GetHost extracts the host from request's context
+// ReqWithDeletedDomain marks the domain of the request as deleted from GitLab
+func ReqWithDeletedDomain(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), ctxDeletedKey, true))
+}
+
+// IsDeleted returns true when the domain of the request was deleted from
+// GitLab, rather than never existed
+func IsDeleted(r *http.Request) bool {
+	deleted, _ := r.Context().Value(ctxDeletedKey).(bool)
+
+	return deleted
+}
+
+// GetHost extracts the host from request's context, it returns an empty
+// string when the request has no host
 func GetHost(r *http.Request) string {
-	return r.Context().Value(ctxHostKey).(string)
+	host, _ := r.Context().Value(ctxHostKey).(string)
+
+	return host
 }
 
-// FromRequest extracts the domain from request's context
+// FromRequest extracts the domain from request's context, it returns nil when
+// the request has no domain
 func FromRequest(r *http.Request) *Domain {
-	return r.Context().Value(ctxDomainKey).(*Domain)
+	d, _ := r.Context().Value(ctxDomainKey).(*Domain)
+
+	return d
+}
+
+// resolveOnce resolves the serving request of d with resolve the first time
+// it is called for the request, and returns the same result afterwards. The
+// request is resolved every time when it was not routed to d or its path was
+// rewritten since.
+func resolveOnce(d *Domain, r *http.Request, resolve func(*http.Request) (*serving.Request, error)) (*serving.Request, error) {
+	l, _ := r.Context().Value(ctxLookupKey).(*lookup)
+	if l == nil || l.domain != d {
+		return resolve(r)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.resolved || l.path != r.URL.Path {
+		l.request, l.err = resolve(r)
+		l.path = r.URL.Path
+		l.resolved = true
+	}
+
+	return l.request, l.err
+}
+
+// EmbeddingPolicyFromRequest returns the embedding policy of the domain saved
+// in request's context. It returns nil when the request has no domain or the
+// domain cannot be embedded.
+func EmbeddingPolicyFromRequest(r *http.Request) *EmbeddingPolicy {
+	d, _ := r.Context().Value(ctxDomainKey).(*Domain)
+	if d == nil {
+		return nil
+	}
+
+	return d.EmbeddingPolicy
 }
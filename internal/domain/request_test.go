@@ -2,21 +2,21 @@ package domain
 
 import (
 	"net/http"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
 )
 
-func TestPanics(t *testing.T) {
+func TestWithoutHostAndDomain(t *testing.T) {
 	r, err := http.NewRequest("GET", "/", nil)
 	require.NoError(t, err)
 
-	require.Panics(t, func() {
-		GetHost(r)
-	})
-
-	require.Panics(t, func() {
-		FromRequest(r)
+	require.NotPanics(t, func() {
+		require.Empty(t, GetHost(r))
+		require.Nil(t, FromRequest(r))
 	})
 }
 
@@ -48,3 +48,95 @@ func TestWithHostAndDomain(t *testing.T) {
 		})
 	}
 }
+
+func TestEmbeddingPolicyFromRequest(t *testing.T) {
+	r, err := http.NewRequest("GET", "/", nil)
+	require.NoError(t, err)
+
+	require.Nil(t, EmbeddingPolicyFromRequest(r), "request without domain")
+	require.Nil(t, EmbeddingPolicyFromRequest(ReqWithHostAndDomain(r, "example.com", nil)))
+	require.Nil(t, EmbeddingPolicyFromRequest(ReqWithHostAndDomain(r, "example.com", &Domain{})))
+
+	policy := &EmbeddingPolicy{FrameAncestors: []string{"https://tool.example.com", "*.example.org"}}
+	r = ReqWithHostAndDomain(r, "example.com", &Domain{EmbeddingPolicy: policy})
+
+	require.Same(t, policy, EmbeddingPolicyFromRequest(r))
+	require.Equal(t, "frame-ancestors 'self' https://tool.example.com *.example.org", policy.ContentSecurityPolicy())
+}
+
+// updatingResolver returns a new lookup path every time it resolves a
+// request, like a domains source updated while requests are served
+type updatingResolver struct {
+	mu      sync.Mutex
+	updates int
+}
+
+func (u *updatingResolver) Resolve(*http.Request) (*serving.Request, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.updates++
+
+	return &serving.Request{LookupPath: &serving.LookupPath{
+		Path:        "group/project/public",
+		ProjectID:   uint64(u.updates),
+		IsHTTPSOnly: u.updates%2 == 1,
+	}}, nil
+}
+
+func (u *updatingResolver) update() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.updates++
+}
+
+func TestRequestSnapshotsLookupPath(t *testing.T) {
+	resolver := &updatingResolver{}
+	d := New("example.com", "", "", resolver)
+
+	r, err := http.NewRequest("GET", "http://example.com/index.html", nil)
+	require.NoError(t, err)
+	r = ReqWithHostAndDomain(r, "example.com", d)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				resolver.update()
+			}
+		}
+	}()
+
+	lookupPath, err := d.GetLookupPath(r)
+	require.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		require.Equal(t, lookupPath.IsHTTPSOnly, d.IsHTTPSOnly(r))
+		require.Equal(t, lookupPath.ProjectID, d.GetProjectID(r))
+	}
+
+	close(done)
+	wg.Wait()
+
+	t.Run("rewritten_path", func(t *testing.T) {
+		r.URL.Path = "/other.html"
+
+		require.NotEqual(t, lookupPath.ProjectID, d.GetProjectID(r))
+	})
+
+	t.Run("request_without_snapshot", func(t *testing.T) {
+		r, err := http.NewRequest("GET", "http://example.com/index.html", nil)
+		require.NoError(t, err)
+
+		require.NotEqual(t, d.GetProjectID(r), d.GetProjectID(r))
+	})
+}
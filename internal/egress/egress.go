@@ -0,0 +1,220 @@
+// Package egress restricts the outbound connections Pages makes to URLs it
+// does not control, e.g. the artifacts server and the object storage URLs
+// returned by the GitLab API, to protect against server-side request forgery.
+package egress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// defaultDialTimeout matches the timeout and keep-alive of the net/http
+// default transport
+const defaultDialTimeout = 30 * time.Second
+
+var (
+	// ErrBlockedAddress is returned when connecting to a link-local or cloud
+	// metadata address, which is never allowed
+	ErrBlockedAddress = errors.New("connections to link-local and metadata addresses are not allowed")
+	// ErrHostNotAllowed is returned when connecting to a host which is not
+	// in the egress allowlist
+	ErrHostNotAllowed = errors.New("host is not in the egress allowlist")
+	// ErrInvalidEntry is returned when an egress allowlist entry cannot be parsed
+	ErrInvalidEntry = errors.New("invalid egress allowlist entry")
+)
+
+// metadataIPs are the cloud metadata service addresses outside of the
+// link-local ranges
+var metadataIPs = []net.IP{
+	net.ParseIP("fd00:ec2::254"),   // AWS IPv6
+	net.ParseIP("100.100.100.200"), // Alibaba Cloud
+}
+
+// DialContextFunc opens a network connection to addr
+type DialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Policy decides which hosts outbound connections can be opened to.
+// Link-local and metadata addresses are always blocked. When the allowlist is
+// not empty only the hosts matching one of its entries are allowed. The zero
+// value allows all the other hosts.
+type Policy struct {
+	hosts    []string
+	suffixes []string
+	networks []*net.IPNet
+}
+
+// NewPolicy parses the allowlist entries, which can be host names like
+// example.com, wildcards like *.example.com matching all the subdomains,
+// IP addresses and CIDR ranges like 10.0.0.0/8
+func NewPolicy(allowlist []string) (*Policy, error) {
+	p := &Policy{}
+
+	for _, entry := range allowlist {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+
+		switch {
+		case entry == "":
+			continue
+		case strings.HasPrefix(entry, "*."):
+			p.suffixes = append(p.suffixes, entry[1:])
+		case strings.Contains(entry, "/"):
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("%w %q: %v", ErrInvalidEntry, entry, err)
+			}
+			p.networks = append(p.networks, network)
+		case strings.Contains(entry, "*"):
+			return nil, fmt.Errorf("%w %q: wildcards are only supported as the first label", ErrInvalidEntry, entry)
+		default:
+			if ip := net.ParseIP(entry); ip != nil {
+				p.networks = append(p.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+				continue
+			}
+			p.hosts = append(p.hosts, entry)
+		}
+	}
+
+	return p, nil
+}
+
+// CheckURL returns an error when the host of rawURL is not allowed. Host names
+// are only resolved when connecting so only IP addresses are checked against
+// the blocked ranges.
+func (p *Policy) CheckURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		return p.checkIP(host, ip, p.allowsHost(host))
+	}
+
+	if !p.allowsHost(host) {
+		return fmt.Errorf("%w: %s", ErrHostNotAllowed, host)
+	}
+
+	return nil
+}
+
+// Proxy wraps the function selecting the forward proxy of the requests of a
+// transport, so the URLs of the requests are checked against the policy. The
+// connections through a proxy are only opened to the proxy address, and the
+// host names are resolved by the proxy, so only their allowlist entries and IP
+// addresses are checked.
+func (p *Policy) Proxy(proxy func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(r *http.Request) (*url.URL, error) {
+		if err := p.CheckURL(r.URL.String()); err != nil {
+			return nil, err
+		}
+
+		return proxy(r)
+	}
+}
+
+// DialContext wraps dialer so connections are only opened when the policy
+// allows them. The resolved IP addresses are checked right before connecting,
+// so host names resolving to blocked addresses are rejected too. A default
+// dialer is used when dialer is nil.
+func (p *Policy) DialContext(dialer *net.Dialer) DialContextFunc {
+	if dialer == nil {
+		dialer = &net.Dialer{Timeout: defaultDialTimeout, KeepAlive: defaultDialTimeout}
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		hostAllowed := p.allowsHost(host)
+
+		d := *dialer
+		d.Control = func(network, address string, c syscall.RawConn) error {
+			ip, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			if err := p.checkIP(host, net.ParseIP(ip), hostAllowed); err != nil {
+				return err
+			}
+
+			if dialer.Control != nil {
+				return dialer.Control(network, address, c)
+			}
+
+			return nil
+		}
+
+		return d.DialContext(ctx, network, addr)
+	}
+}
+
+func (p *Policy) checkIP(host string, ip net.IP, hostAllowed bool) error {
+	if ip == nil {
+		return fmt.Errorf("%w: %s", ErrHostNotAllowed, host)
+	}
+
+	if isBlocked(ip) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, ip)
+	}
+
+	if hostAllowed {
+		return nil
+	}
+
+	for _, network := range p.networks {
+		if network.Contains(ip) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrHostNotAllowed, host)
+}
+
+// allowsHost returns true when the allowlist is empty or host matches one of
+// the host name entries
+func (p *Policy) allowsHost(host string) bool {
+	if len(p.hosts) == 0 && len(p.suffixes) == 0 && len(p.networks) == 0 {
+		return true
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	for _, h := range p.hosts {
+		if host == h {
+			return true
+		}
+	}
+
+	for _, suffix := range p.suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isBlocked(ip net.IP) bool {
+	if ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+		return true
+	}
+
+	for _, metadataIP := range metadataIPs {
+		if ip.Equal(metadataIP) {
+			return true
+		}
+	}
+
+	return false
+}
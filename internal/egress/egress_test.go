@@ -0,0 +1,181 @@
+package egress
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPolicyInvalidEntries(t *testing.T) {
+	_, err := NewPolicy([]string{"10.0.0.0/33"})
+	require.EqualError(t, err, `invalid egress allowlist entry "10.0.0.0/33": invalid CIDR address: 10.0.0.0/33`)
+
+	_, err = NewPolicy([]string{"storage.*.example.com"})
+	require.EqualError(t, err, `invalid egress allowlist entry "storage.*.example.com": wildcards are only supported as the first label`)
+}
+
+func TestCheckURL(t *testing.T) {
+	tests := map[string]struct {
+		allowlist   []string
+		url         string
+		expectedErr error
+	}{
+		"empty_allowlist": {
+			url: "https://gitlab.example.com/api/v4",
+		},
+		"link_local": {
+			url:         "http://169.254.169.254/latest/meta-data",
+			expectedErr: ErrBlockedAddress,
+		},
+		"link_local_ipv6": {
+			url:         "http://[fe80::1]/",
+			expectedErr: ErrBlockedAddress,
+		},
+		"metadata": {
+			url:         "http://[fd00:ec2::254]/",
+			expectedErr: ErrBlockedAddress,
+		},
+		"link_local_allowed_by_cidr": {
+			allowlist:   []string{"169.254.0.0/16"},
+			url:         "http://169.254.169.254/",
+			expectedErr: ErrBlockedAddress,
+		},
+		"host_allowed": {
+			allowlist: []string{"gitlab.example.com"},
+			url:       "https://GitLab.example.com/api/v4",
+		},
+		"wildcard_allowed": {
+			allowlist: []string{"*.storage.example.com"},
+			url:       "https://bucket.storage.example.com/public.zip",
+		},
+		"wildcard_does_not_match_domain": {
+			allowlist:   []string{"*.storage.example.com"},
+			url:         "https://storage.example.com/public.zip",
+			expectedErr: ErrHostNotAllowed,
+		},
+		"host_not_allowed": {
+			allowlist:   []string{"gitlab.example.com"},
+			url:         "https://attacker.example.com/",
+			expectedErr: ErrHostNotAllowed,
+		},
+		"ip_allowed_by_cidr": {
+			allowlist: []string{"10.0.0.0/8"},
+			url:       "http://10.1.2.3:9000/bucket",
+		},
+		"ip_allowed": {
+			allowlist: []string{"10.1.2.3"},
+			url:       "http://10.1.2.3:9000/bucket",
+		},
+		"ip_not_allowed": {
+			allowlist:   []string{"10.1.2.3"},
+			url:         "http://10.1.2.4:9000/bucket",
+			expectedErr: ErrHostNotAllowed,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			policy, err := NewPolicy(tt.allowlist)
+			require.NoError(t, err)
+
+			err = policy.CheckURL(tt.url)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestProxy(t *testing.T) {
+	policy, err := NewPolicy([]string{"*.storage.example.com"})
+	require.NoError(t, err)
+
+	proxyURL := &url.URL{Scheme: "http", Host: "proxy.example.com:3128"}
+	proxy := policy.Proxy(func(*http.Request) (*url.URL, error) {
+		return proxyURL, nil
+	})
+
+	u, err := proxy(httptest.NewRequest(http.MethodGet, "https://bucket.storage.example.com/public.zip", nil))
+	require.NoError(t, err)
+	require.Equal(t, proxyURL, u)
+
+	_, err = proxy(httptest.NewRequest(http.MethodGet, "http://169.254.169.254/latest/meta-data", nil))
+	require.ErrorIs(t, err, ErrBlockedAddress)
+
+	_, err = proxy(httptest.NewRequest(http.MethodGet, "https://attacker.example.com/", nil))
+	require.ErrorIs(t, err, ErrHostNotAllowed)
+}
+
+func TestDialContext(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		allowlist   []string
+		addr        string
+		expectedErr error
+	}{
+		"empty_allowlist": {
+			addr: net.JoinHostPort("127.0.0.1", port),
+		},
+		"allowed_by_cidr": {
+			allowlist: []string{"127.0.0.0/8"},
+			addr:      net.JoinHostPort("127.0.0.1", port),
+		},
+		"allowed_by_host_name": {
+			allowlist: []string{"localhost"},
+			addr:      net.JoinHostPort("localhost", port),
+		},
+		"resolved_address_allowed_by_cidr": {
+			allowlist: []string{"127.0.0.1/32"},
+			addr:      net.JoinHostPort("localhost", port),
+		},
+		"not_allowed": {
+			allowlist:   []string{"storage.example.com"},
+			addr:        net.JoinHostPort("127.0.0.1", port),
+			expectedErr: ErrHostNotAllowed,
+		},
+		"link_local": {
+			addr:        "169.254.169.254:80",
+			expectedErr: ErrBlockedAddress,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			policy, err := NewPolicy(tt.allowlist)
+			require.NoError(t, err)
+
+			conn, err := policy.DialContext(nil)(context.Background(), "tcp4", tt.addr)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.NoError(t, conn.Close())
+		})
+	}
+}
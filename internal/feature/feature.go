@@ -1,12 +1,25 @@
 package feature
 
-import "os"
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strings"
+	"sync/atomic"
+)
 
 type Feature struct {
-	EnvVariable    string
+	EnvVariable string
+	// Name is the key of the feature in the domain feature flags sent by the
+	// GitLab API, features without a name can only be toggled per instance
+	Name           string
 	defaultEnabled bool
 }
 
+// Flags holds the feature flags values sent by the GitLab API for a domain,
+// keyed by Feature.Name
+type Flags map[string]bool
+
 // EnforceIPRateLimits enforces IP rate limiter to drop requests
 // TODO: remove https://gitlab.com/gitlab-org/gitlab-pages/-/issues/629
 var EnforceIPRateLimits = Feature{
@@ -23,6 +36,87 @@ var EnforceDomainRateLimits = Feature{
 // TODO: remove https://gitlab.com/gitlab-org/gitlab-pages/-/issues/620
 var RedirectsPlaceholders = Feature{
 	EnvVariable: "FF_ENABLE_PLACEHOLDERS",
+	Name:        "redirects_placeholders",
+}
+
+// DebugHeader sends the X-Pages-Debug header describing how requests were
+// served, it is meant to be enabled for single domains while debugging them
+var DebugHeader = Feature{
+	EnvVariable: "FF_DEBUG_HEADER",
+	Name:        "debug_header",
+}
+
+// named are the features which can be toggled per domain and rolled out to a
+// percentage of the domains
+var named = []Feature{DebugHeader, RedirectsPlaceholders}
+
+// rollouts holds the percentage of the domains for which features are
+// enabled, keyed by Feature.Name
+var rollouts atomic.Value
+
+// Lookup returns the feature toggled per domain with name
+func Lookup(name string) (Feature, bool) {
+	for _, f := range named {
+		if f.Name == name {
+			return f, true
+		}
+	}
+
+	return Feature{}, false
+}
+
+// SetRollouts enables features for a percentage, between 0 and 100, of the
+// domains, keyed by Feature.Name
+func SetRollouts(percentages map[string]int) error {
+	for name := range percentages {
+		if _, ok := Lookup(name); !ok {
+			return fmt.Errorf("unknown feature %q", name)
+		}
+	}
+
+	rollouts.Store(percentages)
+
+	return nil
+}
+
+// FlagsFor returns flags with the features being rolled out enabled when
+// domain falls within their percentage. Features set in flags, e.g. by the
+// GitLab API, or with their environment variable are left unchanged.
+func FlagsFor(domain string, flags Flags) Flags {
+	percentages, _ := rollouts.Load().(map[string]int)
+	if len(percentages) == 0 {
+		return flags
+	}
+
+	result := make(Flags, len(flags)+len(percentages))
+	for name, enabled := range flags {
+		result[name] = enabled
+	}
+
+	for name, percentage := range percentages {
+		f, ok := Lookup(name)
+		if !ok || os.Getenv(f.EnvVariable) != "" {
+			continue
+		}
+
+		if _, ok := result[name]; !ok {
+			result[name] = bucket(name, domain) < percentage
+		}
+	}
+
+	return result
+}
+
+// bucket consistently maps domain to one of 100 buckets. The feature name is
+// part of the hash so each feature is rolled out to different domains first,
+// and domains stay enabled when the percentage increases.
+func bucket(name, domain string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(strings.ToLower(domain)))
+
+	return int(h.Sum32() % 100)
 }
 
 // Enabled reads the environment variable responsible for the feature flag
@@ -37,3 +131,15 @@ func (f Feature) Enabled() bool {
 
 	return env == "true"
 }
+
+// EnabledFor reads the feature flag value from the domain flags and falls back
+// to Enabled when the feature is not set for the domain
+func (f Feature) EnabledFor(flags Flags) bool {
+	if f.Name != "" {
+		if enabled, ok := flags[f.Name]; ok {
+			return enabled
+		}
+	}
+
+	return f.Enabled()
+}
@@ -1,6 +1,7 @@
 package feature
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -46,3 +47,147 @@ func TestEnabled(t *testing.T) {
 		})
 	}
 }
+
+func TestEnabledFor(t *testing.T) {
+	cases := map[string]struct {
+		name     string
+		envVal   string
+		flags    Flags
+		expected bool
+	}{
+		"no_flags_falls_back_to_default": {
+			name:     "test_feature",
+			expected: false,
+		},
+		"no_flags_falls_back_to_env_variable": {
+			name:     "test_feature",
+			envVal:   "true",
+			expected: true,
+		},
+		"other_flag_falls_back_to_env_variable": {
+			name:     "test_feature",
+			envVal:   "true",
+			flags:    Flags{"other_feature": false},
+			expected: true,
+		},
+		"enabled_for_domain": {
+			name:     "test_feature",
+			flags:    Flags{"test_feature": true},
+			expected: true,
+		},
+		"disabled_for_domain": {
+			name:     "test_feature",
+			envVal:   "true",
+			flags:    Flags{"test_feature": false},
+			expected: false,
+		},
+		"unnamed_feature_ignores_domain_flags": {
+			flags:    Flags{"": true},
+			expected: false,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			feature := Feature{
+				EnvVariable: "testFeatureFlag",
+				Name:        tt.name,
+			}
+			testhelpers.SetEnvironmentVariable(t, feature.EnvVariable, tt.envVal)
+			require.Equal(t, tt.expected, feature.EnabledFor(tt.flags))
+		})
+	}
+}
+
+func TestFlagsFor(t *testing.T) {
+	feature := RedirectsPlaceholders
+
+	cases := map[string]struct {
+		percentage int
+		envVal     string
+		flags      Flags
+		expected   Flags
+	}{
+		"no_rollout": {
+			percentage: -1,
+			flags:      Flags{"other_feature": true},
+			expected:   Flags{"other_feature": true},
+		},
+		"rolled_out_to_all_domains": {
+			percentage: 100,
+			expected:   Flags{feature.Name: true},
+		},
+		"rolled_out_to_no_domains": {
+			percentage: 0,
+			flags:      Flags{"other_feature": true},
+			expected:   Flags{feature.Name: false, "other_feature": true},
+		},
+		"domain_flag_takes_precedence": {
+			percentage: 100,
+			flags:      Flags{feature.Name: false},
+			expected:   Flags{feature.Name: false},
+		},
+		"env_variable_takes_precedence": {
+			percentage: 100,
+			envVal:     "false",
+			expected:   Flags{},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			testhelpers.SetEnvironmentVariable(t, feature.EnvVariable, tt.envVal)
+
+			if tt.percentage >= 0 {
+				require.NoError(t, SetRollouts(map[string]int{feature.Name: tt.percentage}))
+				t.Cleanup(func() { SetRollouts(nil) })
+			}
+
+			flags := FlagsFor("group.gitlab.io", tt.flags)
+			if len(tt.expected) == 0 {
+				require.Empty(t, flags)
+			} else {
+				require.Equal(t, tt.expected, flags)
+			}
+
+			require.Equal(t, tt.expected[feature.Name] || tt.envVal == "true", feature.EnabledFor(flags))
+		})
+	}
+}
+
+func TestFlagsForPercentage(t *testing.T) {
+	feature := RedirectsPlaceholders
+	t.Cleanup(func() { SetRollouts(nil) })
+
+	enabledDomains := func(percentage int) map[string]bool {
+		require.NoError(t, SetRollouts(map[string]int{feature.Name: percentage}))
+
+		enabled := make(map[string]bool)
+		for i := 0; i < 1000; i++ {
+			domain := fmt.Sprintf("group-%d.gitlab.io", i)
+			if feature.EnabledFor(FlagsFor(domain, nil)) {
+				enabled[domain] = true
+			}
+		}
+
+		return enabled
+	}
+
+	enabled10 := enabledDomains(10)
+	require.InDelta(t, 100, len(enabled10), 40)
+
+	// domains stay enabled when the percentage increases
+	enabled50 := enabledDomains(50)
+	require.InDelta(t, 500, len(enabled50), 80)
+
+	for domain := range enabled10 {
+		require.True(t, enabled50[domain], domain)
+	}
+
+	require.Equal(t, enabled50["group-1.gitlab.io"], feature.EnabledFor(FlagsFor("GROUP-1.gitlab.io", nil)), "domains are case-insensitive")
+}
+
+func TestSetRolloutsUnknownFeature(t *testing.T) {
+	require.EqualError(t, SetRollouts(map[string]int{"unknown": 10}), `unknown feature "unknown"`)
+	require.Equal(t, Flags{}, FlagsFor("group.gitlab.io", Flags{}))
+}
@@ -0,0 +1,204 @@
+// Package forwarded reads the original host and client of requests going
+// through reverse proxies from the X-Forwarded-Host, X-Forwarded-For and
+// RFC 7239 Forwarded headers.
+package forwarded
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	headerForwarded      = "Forwarded"
+	headerXForwardedHost = "X-Forwarded-Host"
+	headerXForwardedFor  = "X-Forwarded-For"
+)
+
+// ErrInvalidProxy is returned when a trusted proxy entry cannot be parsed
+var ErrInvalidProxy = errors.New("invalid trusted proxy")
+
+// Proxies is a list of networks of reverse proxies whose forwarded headers
+// are trusted
+type Proxies []*net.IPNet
+
+// NewProxies parses the entries, which can be IP addresses or CIDR ranges
+func NewProxies(entries []string) (Proxies, error) {
+	var proxies Proxies
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("%w %q", ErrInvalidProxy, entry)
+			}
+
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalidProxy, entry, err)
+		}
+
+		proxies = append(proxies, network)
+	}
+
+	return proxies, nil
+}
+
+// Trusts returns true when the remote address of r belongs to one of the
+// proxies
+func (p Proxies) Trusts(r *http.Request) bool {
+	return p.Contains(remoteIP(r))
+}
+
+// Contains returns true when ip belongs to one of the proxies
+func (p Proxies) Contains(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ClientIP returns the IP address of the client of r. It is the remote
+// address of r, unless it belongs to the trusted proxies which forward the
+// address of their client in the X-Forwarded-For header.
+func ClientIP(r *http.Request, trusted Proxies) net.IP {
+	ip := remoteIP(r)
+	if !trusted.Contains(ip) {
+		return ip
+	}
+
+	return forwardedFor(r, trusted, ip)
+}
+
+// ProxiedClientIP returns the IP address of the client of r like ClientIP,
+// for the listeners only reachable through a reverse proxy whose remote
+// address is always trusted
+func ProxiedClientIP(r *http.Request, trusted Proxies) net.IP {
+	return forwardedFor(r, trusted, remoteIP(r))
+}
+
+// forwardedFor reads the X-Forwarded-For addresses from the right, which were
+// appended by the proxies, and returns the first one which is not a trusted
+// proxy. The leftmost addresses are sent by the client and can be spoofed,
+// so they are only used when all the addresses on their right are trusted.
+func forwardedFor(r *http.Request, trusted Proxies, ip net.IP) net.IP {
+	hops := strings.Split(strings.Join(r.Header.Values(headerXForwardedFor), ","), ",")
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			return ip
+		}
+
+		ip = hop
+		if !trusted.Contains(ip) {
+			return ip
+		}
+	}
+
+	return ip
+}
+
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return net.ParseIP(host)
+}
+
+// Host returns the normalized host the client requested, taken from the
+// host= parameter of the first Forwarded element or the first X-Forwarded-Host
+// value. It returns an empty string when none of them holds a valid host.
+func Host(r *http.Request) string {
+	if host := hostFromForwarded(r.Header.Get(headerForwarded)); host != "" {
+		return host
+	}
+
+	value := r.Header.Get(headerXForwardedHost)
+	if i := strings.IndexByte(value, ','); i >= 0 {
+		value = value[:i]
+	}
+
+	return normalizeHost(value)
+}
+
+// hostFromForwarded returns the host parameter of the first element of a
+// Forwarded header, e.g. `for=192.0.2.60;proto=https;host="example.com"`
+func hostFromForwarded(value string) string {
+	if i := strings.IndexByte(value, ','); i >= 0 {
+		value = value[:i]
+	}
+
+	for _, pair := range strings.Split(value, ";") {
+		name, param, ok := cut(strings.TrimSpace(pair), "=")
+		if ok && strings.EqualFold(name, "host") {
+			return normalizeHost(strings.Trim(param, `"`))
+		}
+	}
+
+	return ""
+}
+
+// normalizeHost lowercases host, removes the trailing dot of its name and
+// returns an empty string when it contains characters which are not allowed
+// in a host
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if host == "" {
+		return ""
+	}
+
+	for _, c := range host {
+		if !isHostChar(c) {
+			return ""
+		}
+	}
+
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return strings.TrimSuffix(host, ".")
+	}
+
+	return net.JoinHostPort(strings.TrimSuffix(name, "."), port)
+}
+
+func isHostChar(c rune) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		return true
+	case c == '-', c == '.', c == '_', c == ':', c == '[', c == ']':
+		return true
+	}
+
+	return false
+}
+
+// cut slices s around the first instance of sep, like strings.Cut which is
+// not available in Go 1.16
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+
+	return s, "", false
+}
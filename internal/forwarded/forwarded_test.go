@@ -0,0 +1,200 @@
+package forwarded
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewProxies(t *testing.T) {
+	proxies, err := NewProxies([]string{"10.0.0.0/8", " 192.168.1.1 ", "", "::1"})
+	require.NoError(t, err)
+	require.Len(t, proxies, 3)
+
+	_, err = NewProxies([]string{"10.0.0.0/33"})
+	require.ErrorIs(t, err, ErrInvalidProxy)
+
+	_, err = NewProxies([]string{"proxy.example.com"})
+	require.ErrorIs(t, err, ErrInvalidProxy)
+}
+
+func TestProxiesTrusts(t *testing.T) {
+	proxies, err := NewProxies([]string{"10.0.0.0/8", "192.168.1.1", "::1"})
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		remoteAddr string
+		expected   bool
+	}{
+		"in_range":      {remoteAddr: "10.1.2.3:1234", expected: true},
+		"ip":            {remoteAddr: "192.168.1.1:1234", expected: true},
+		"ipv6":          {remoteAddr: "[::1]:1234", expected: true},
+		"without_port":  {remoteAddr: "192.168.1.1", expected: true},
+		"not_trusted":   {remoteAddr: "192.168.1.2:1234"},
+		"invalid_addr":  {remoteAddr: "invalid"},
+		"empty_address": {},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+
+			require.Equal(t, tt.expected, proxies.Trusts(r))
+		})
+	}
+
+	var none Proxies
+	require.False(t, none.Trusts(httptest.NewRequest("GET", "/", nil)))
+}
+
+func TestHost(t *testing.T) {
+	tests := map[string]struct {
+		forwarded      string
+		xForwardedHost string
+		expected       string
+	}{
+		"no_headers": {},
+		"x_forwarded_host": {
+			xForwardedHost: "Group.Example.com",
+			expected:       "group.example.com",
+		},
+		"x_forwarded_host_with_port": {
+			xForwardedHost: "group.example.com.:8443",
+			expected:       "group.example.com:8443",
+		},
+		"x_forwarded_host_list": {
+			xForwardedHost: "group.example.com, proxy.internal",
+			expected:       "group.example.com",
+		},
+		"x_forwarded_host_invalid": {
+			xForwardedHost: "group.example.com/path",
+		},
+		"forwarded": {
+			forwarded: `for=192.0.2.60;proto=https;host="group.example.com:8443"`,
+			expected:  "group.example.com:8443",
+		},
+		"forwarded_first_element": {
+			forwarded: "host=group.example.com, host=proxy.internal",
+			expected:  "group.example.com",
+		},
+		"forwarded_takes_precedence": {
+			forwarded:      "Host=group.example.com",
+			xForwardedHost: "other.example.com",
+			expected:       "group.example.com",
+		},
+		"forwarded_without_host": {
+			forwarded:      "for=192.0.2.60;proto=https",
+			xForwardedHost: "other.example.com",
+			expected:       "other.example.com",
+		},
+		"ipv6": {
+			xForwardedHost: "[2001:db8::1]:8080",
+			expected:       "[2001:db8::1]:8080",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.forwarded != "" {
+				r.Header.Set("Forwarded", tt.forwarded)
+			}
+			if tt.xForwardedHost != "" {
+				r.Header.Set("X-Forwarded-Host", tt.xForwardedHost)
+			}
+
+			require.Equal(t, tt.expected, Host(r))
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := NewProxies([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		remoteAddr    string
+		forwardedFor  []string
+		proxied       bool
+		expectedIP    string
+		expectedNilIP bool
+	}{
+		"remote_address": {
+			remoteAddr: "192.168.1.1:1234",
+			expectedIP: "192.168.1.1",
+		},
+		"untrusted_remote_address": {
+			remoteAddr:   "192.168.1.1:1234",
+			forwardedFor: []string{"10.1.1.1"},
+			expectedIP:   "192.168.1.1",
+		},
+		"trusted_proxy": {
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"192.168.1.1"},
+			expectedIP:   "192.168.1.1",
+		},
+		"spoofed_address": {
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"10.1.1.1, 192.168.1.1"},
+			expectedIP:   "192.168.1.1",
+		},
+		"chain_of_trusted_proxies": {
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"172.16.1.1, 192.168.1.1", "10.0.0.2"},
+			expectedIP:   "192.168.1.1",
+		},
+		"only_trusted_proxies": {
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"10.0.0.3, 10.0.0.2"},
+			expectedIP:   "10.0.0.3",
+		},
+		"invalid_forwarded_address": {
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"192.168.1.1, invalid, 10.0.0.2"},
+			expectedIP:   "10.0.0.2",
+		},
+		"trusted_proxy_without_header": {
+			remoteAddr: "10.0.0.1:1234",
+			expectedIP: "10.0.0.1",
+		},
+		"proxied": {
+			remoteAddr:   "127.0.0.1:1234",
+			forwardedFor: []string{"10.1.1.1, 192.168.1.1"},
+			proxied:      true,
+			expectedIP:   "192.168.1.1",
+		},
+		"proxied_without_header": {
+			remoteAddr: "127.0.0.1:1234",
+			proxied:    true,
+			expectedIP: "127.0.0.1",
+		},
+		"invalid_remote_address": {
+			remoteAddr:    "invalid",
+			expectedNilIP: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+
+			ip := ClientIP(r, proxies)
+			if tt.proxied {
+				ip = ProxiedClientIP(r, proxies)
+			}
+
+			if tt.expectedNilIP {
+				require.Nil(t, ip)
+				return
+			}
+
+			require.Equal(t, tt.expectedIP, ip.String())
+		})
+	}
+}
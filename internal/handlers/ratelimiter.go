@@ -3,6 +3,8 @@ package handlers
 import (
 	"net/http"
 
+	"gitlab.com/gitlab-org/labkit/log"
+
 	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/feature"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/ratelimiter"
@@ -10,33 +12,68 @@ import (
 	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
-// Ratelimiter configures the ratelimiter middleware
+// Ratelimiter configures the ratelimiter middleware. The rate limiters of a
+// virtual instance are named after its pages domain in instance, so their
+// caches, Redis keys and metrics are not shared with the main instance.
 // TODO: make this unexported once https://gitlab.com/gitlab-org/gitlab-pages/-/issues/670 is done
-func Ratelimiter(handler http.Handler, config *config.RateLimit) http.Handler {
+func Ratelimiter(handler http.Handler, instance string, config *config.RateLimit) http.Handler {
+	backendOpts := backendOptions(config)
+
 	sourceIPLimiter := ratelimiter.New(
-		"source_ip",
-		ratelimiter.WithCacheMaxSize(ratelimiter.DefaultSourceIPCacheSize),
-		ratelimiter.WithCachedEntriesMetric(metrics.RateLimitSourceIPCachedEntries),
-		ratelimiter.WithCachedRequestsMetric(metrics.RateLimitSourceIPCacheRequests),
-		ratelimiter.WithBlockedCountMetric(metrics.RateLimitSourceIPBlockedCount),
-		ratelimiter.WithLimitPerSecond(config.SourceIPLimitPerSecond),
-		ratelimiter.WithBurstSize(config.SourceIPBurst),
-		ratelimiter.WithEnforce(feature.EnforceIPRateLimits.Enabled()),
+		ratelimiterName(instance, "source_ip"),
+		append(backendOpts,
+			ratelimiter.WithCacheMaxSize(ratelimiter.DefaultSourceIPCacheSize),
+			ratelimiter.WithCachedEntriesMetric(metrics.RateLimitSourceIPCachedEntries),
+			ratelimiter.WithCachedRequestsMetric(metrics.RateLimitSourceIPCacheRequests),
+			ratelimiter.WithBlockedCountMetric(metrics.RateLimitSourceIPBlockedCount),
+			ratelimiter.WithLimitPerSecond(config.SourceIPLimitPerSecond),
+			ratelimiter.WithBurstSize(config.SourceIPBurst),
+			ratelimiter.WithEnforce(feature.EnforceIPRateLimits.Enabled()),
+		)...,
 	)
 
 	handler = sourceIPLimiter.Middleware(handler)
 
 	domainLimiter := ratelimiter.New(
-		"domain",
-		ratelimiter.WithCacheMaxSize(ratelimiter.DefaultDomainCacheSize),
-		ratelimiter.WithKeyFunc(request.GetHostWithoutPort),
-		ratelimiter.WithCachedEntriesMetric(metrics.RateLimitDomainCachedEntries),
-		ratelimiter.WithCachedRequestsMetric(metrics.RateLimitDomainCacheRequests),
-		ratelimiter.WithBlockedCountMetric(metrics.RateLimitDomainBlockedCount),
-		ratelimiter.WithLimitPerSecond(config.DomainLimitPerSecond),
-		ratelimiter.WithBurstSize(config.DomainBurst),
-		ratelimiter.WithEnforce(feature.EnforceDomainRateLimits.Enabled()),
+		ratelimiterName(instance, "domain"),
+		append(backendOpts,
+			ratelimiter.WithCacheMaxSize(ratelimiter.DefaultDomainCacheSize),
+			ratelimiter.WithKeyFunc(request.GetHostWithoutPort),
+			ratelimiter.WithCachedEntriesMetric(metrics.RateLimitDomainCachedEntries),
+			ratelimiter.WithCachedRequestsMetric(metrics.RateLimitDomainCacheRequests),
+			ratelimiter.WithBlockedCountMetric(metrics.RateLimitDomainBlockedCount),
+			ratelimiter.WithLimitPerSecond(config.DomainLimitPerSecond),
+			ratelimiter.WithBurstSize(config.DomainBurst),
+			ratelimiter.WithEnforce(feature.EnforceDomainRateLimits.Enabled()),
+		)...,
 	)
 
 	return domainLimiter.Middleware(handler)
 }
+
+func ratelimiterName(instance, name string) string {
+	if instance == "" {
+		return name
+	}
+
+	return instance + "/" + name
+}
+
+// backendOptions shares a single Redis backend between the rate limiters when
+// it is configured, otherwise the rate limits are kept in memory
+func backendOptions(config *config.RateLimit) []ratelimiter.Option {
+	if config.RedisURL == "" {
+		return nil
+	}
+
+	backend, err := ratelimiter.NewRedisBackend(config.RedisURL, config.RedisTimeout)
+	if err != nil {
+		log.WithError(err).Error("failed to configure the rate limit redis backend, using local rate limits")
+		return nil
+	}
+
+	return []ratelimiter.Option{
+		ratelimiter.WithBackend(backend),
+		ratelimiter.WithBackendFailuresMetric(metrics.RateLimitBackendFailures),
+	}
+}
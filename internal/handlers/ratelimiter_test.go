@@ -85,7 +85,7 @@ func TestRatelimiter(t *testing.T) {
 				DomainBurst:            1,
 			}
 
-			handler := Ratelimiter(next, &conf)
+			handler := Ratelimiter(next, "", &conf)
 
 			r1 := httptest.NewRequest(http.MethodGet, tc.firstTarget, nil)
 			r1.RemoteAddr = tc.firstRemoteAddr
@@ -100,3 +100,8 @@ func TestRatelimiter(t *testing.T) {
 		})
 	}
 }
+
+func TestRatelimiterName(t *testing.T) {
+	require.Equal(t, "source_ip", ratelimiterName("", "source_ip"))
+	require.Equal(t, "staging.gitlab.io/domain", ratelimiterName("staging.gitlab.io", "domain"))
+}
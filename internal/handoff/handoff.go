@@ -0,0 +1,148 @@
+// Package handoff hands the in-memory caches of a stopping process off to the
+// process replacing it, so the replacement does not retrieve the configuration
+// of every domain from the GitLab API again right after a restart. The state
+// is written to a file when the process stops and read once at startup.
+package handoff
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
+)
+
+// version is incremented when the state changes incompatibly, the state of
+// other versions is discarded
+const version = 1
+
+// ErrVersion is returned when reading the state written by an incompatible
+// version of Pages
+var ErrVersion = errors.New("unsupported handoff state version")
+
+// State is the content of the caches handed off
+type State struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	// Instances are the caches of each instance, the main one and the
+	// virtual instances serving other pages domains
+	Instances []Instance `json:"instances"`
+	// Archives are the cache keys of the zip archives opened by the process,
+	// the SHA256 of their deployments. The next process opens them again
+	// from the lookup paths of the domains handed off.
+	Archives []string `json:"archives,omitempty"`
+}
+
+// Instance holds the caches of the instance serving a pages domain
+type Instance struct {
+	PagesDomain string   `json:"pages_domain"`
+	Domains     []Domain `json:"domains,omitempty"`
+	Nonces      []Nonce  `json:"nonces,omitempty"`
+}
+
+// Domain is a domain configuration retrieved from the GitLab API. Only the
+// configurations of existing domains and of the domains known not to exist
+// are handed off, retrieval errors are retried by the next process.
+type Domain struct {
+	Name string `json:"name"`
+	// Aliases are the hostnames sharing the configuration of the domain
+	Aliases []string `json:"aliases,omitempty"`
+	// Created is when the configuration was retrieved, it is refreshed and
+	// expires as if it was never handed off
+	Created time.Time `json:"created"`
+	// Domain is nil when the domain does not exist
+	Domain *api.VirtualDomain `json:"domain,omitempty"`
+	// Deleted is true when the domain does not exist as it was deleted
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// Nonce is the nonce of an authentication code already exchanged, it is
+// handed off so the code can not be replayed against the next process
+type Nonce struct {
+	Value     []byte    `json:"value"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// DomainsCache is implemented by the domains sources caching domains
+type DomainsCache interface {
+	ExportDomains() []Domain
+	ImportDomains(domains []Domain) int
+}
+
+// ArchivesPreloader is implemented by the domains sources which can open the
+// archives of the lookup paths of domains whose cache key is in keys, it
+// returns the number of archives opened
+type ArchivesPreloader interface {
+	PreloadArchives(ctx context.Context, domains []Domain, keys []string) int
+}
+
+// Instance returns the caches of the instance serving pagesDomain, or nil
+func (s *State) Instance(pagesDomain string) *Instance {
+	for i := range s.Instances {
+		if s.Instances[i].PagesDomain == pagesDomain {
+			return &s.Instances[i]
+		}
+	}
+
+	return nil
+}
+
+// Write writes state to the file at path, replacing it atomically. The file
+// is only readable by the user running Pages as it contains the private keys
+// of custom domains.
+func Write(path string, state *State) error {
+	state.Version = version
+	state.Created = time.Now()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Read reads the state from the file at path and removes the file, so a state
+// is only handed off once and a process restarting after a crash does not
+// load an outdated state. It returns an error wrapping os.ErrNotExist when
+// there is no state to read.
+func Read(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Remove(path); err != nil {
+		return nil, err
+	}
+
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("parsing handoff state: %w", err)
+	}
+
+	if state.Version != version {
+		return nil, ErrVersion
+	}
+
+	return &state, nil
+}
@@ -0,0 +1,59 @@
+package handoff
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
+)
+
+func TestWriteAndRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "handoff.json")
+
+	state := &State{Instances: []Instance{{
+		PagesDomain: "gitlab.io",
+		Domains: []Domain{
+			{Name: "example.com", Aliases: []string{"www.example.com"}, Domain: &api.VirtualDomain{Key: "private"}},
+			{Name: "missing.com"},
+		},
+		Nonces: []Nonce{{Value: []byte{0xff, 0x00}, ExpiresAt: time.Now().Add(time.Minute)}},
+	}}}
+	require.NoError(t, Write(path, state))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm(), "the state contains private keys")
+
+	read, err := Read(path)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now(), read.Created, time.Minute)
+
+	caches := read.Instance("gitlab.io")
+	require.NotNil(t, caches)
+	require.Equal(t, "private", caches.Domains[0].Domain.Key)
+	require.Nil(t, caches.Domains[1].Domain)
+	require.Equal(t, []byte{0xff, 0x00}, caches.Nonces[0].Value, "nonces are binary")
+	require.Nil(t, read.Instance("other.io"))
+
+	_, err = Read(path)
+	require.ErrorIs(t, err, os.ErrNotExist, "the state is only read once")
+}
+
+func TestReadVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "handoff.json")
+
+	data, err := json.Marshal(State{Version: version + 1})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0600))
+
+	_, err = Read(path)
+	require.ErrorIs(t, err, ErrVersion)
+
+	_, err = os.Stat(path)
+	require.ErrorIs(t, err, os.ErrNotExist, "the state of other versions is removed")
+}
@@ -0,0 +1,46 @@
+//go:build go1.18
+// +build go1.18
+
+package headers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// FuzzHeaders parses the fuzzed rules and applies them to the fuzzed path,
+// the seed corpus is in testdata/fuzz/FuzzHeaders
+func FuzzHeaders(f *testing.F) {
+	f.Add("/*\n  X-Frame-Options: DENY", "/index.html")
+	f.Add("/blog/:slug/*\n  Cache-Control: max-age=60\n  Link: </style.css>; rel=preload", "/blog/post/img.png")
+	f.Add("# comment\n  X-Orphan: 1\nno-slash\n  X-Skipped: 1\n/a\n  Set-Cookie: a=b\n  Bad Name: v", "/a")
+	f.Add("/*\n\tX-Tab: a\tb\n/**/:x:\n  X-Empty:", "//")
+
+	f.Fuzz(func(t *testing.T, rules, path string) {
+		h := parseHeaders(strings.NewReader(rules))
+		h.Status()
+		h.Errors()
+
+		for _, accessControl := range []bool{false, true} {
+			header := http.Header{}
+			h.Apply(header, path, accessControl)
+
+			for name, values := range header {
+				if _, ok := forbiddenHeaders[name]; ok {
+					t.Fatalf("forbidden header %q was applied", name)
+				}
+
+				if _, ok := cacheHeaders[name]; ok && accessControl {
+					t.Fatalf("cache header %q was applied with access control", name)
+				}
+
+				for _, value := range values {
+					if !validHeaderName(name) || !validHeaderValue(value) {
+						t.Fatalf("invalid header %q: %q was applied", name, value)
+					}
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,338 @@
+// Package headers provides functions for parsing and applying the response
+// headers of the paths of projects according to Netlify style _headers syntax
+package headers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+)
+
+const (
+	// ConfigFile is the name of the file containing the header rules, see
+	// https://docs.netlify.com/routing/headers/#syntax-for-the-headers-file
+	ConfigFile = "_headers"
+
+	// maxConfigSize limits the size of the _headers file like the size of the
+	// _redirects file is
+	maxConfigSize = 64 * 1024
+
+	// maxRuleCount limits the number of path rules of the _headers file
+	maxRuleCount = 1000
+)
+
+var (
+	errConfigNotFound     = errors.New("_headers file not found")
+	errNeedRegularFile    = errors.New("_headers needs to be a regular file (not a directory)")
+	errFileTooLarge       = fmt.Errorf("_headers file too large, the maximum size is %d bytes", maxConfigSize)
+	errFailedToOpenConfig = errors.New("unable to open _headers file")
+	errFailedToReadConfig = errors.New("failed to read _headers file")
+	errTooManyRules       = fmt.Errorf("only the first %d rules will be applied", maxRuleCount)
+	errPathMustStartSlash = errors.New("path must start with forward slash /")
+	errPathInvalidUTF8    = errors.New("path must be valid UTF-8")
+	errHeaderWithoutPath  = errors.New("header must follow a path")
+	errInvalidHeader      = errors.New("header must be written as Name: value")
+	errInvalidHeaderName  = errors.New("invalid header name")
+	errInvalidHeaderValue = errors.New("invalid header value")
+	errForbiddenHeader    = errors.New("header cannot be set by projects")
+)
+
+// forbiddenHeaders are the headers handled by Pages or net/http, which cannot
+// be set by projects. Access-Control-Allow-Credentials would let other sites
+// read the pages of private projects with the session of their users, and the
+// project headers of -project-headers are trusted by the layers in front of
+// Pages.
+var forbiddenHeaders = map[string]struct{}{
+	"Access-Control-Allow-Credentials": {},
+	"Connection":                       {},
+	"Content-Encoding":                 {},
+	"Content-Length":                   {},
+	"Content-Range":                    {},
+	"Date":                             {},
+	"Keep-Alive":                       {},
+	"Location":                         {},
+	"Set-Cookie":                       {},
+	"Trailer":                          {},
+	"Transfer-Encoding":                {},
+	"Upgrade":                          {},
+	"X-Gitlab-Pages-Namespace":         {},
+	"X-Gitlab-Pages-Project-Id":        {},
+}
+
+// cacheHeaders are not applied to the pages of projects with access control,
+// so shared caches never store them
+var cacheHeaders = map[string]struct{}{
+	"Cache-Control": {},
+	"Expires":       {},
+}
+
+type header struct {
+	name  string
+	value string
+}
+
+type rule struct {
+	path    string
+	pattern *regexp.Regexp
+	headers []header
+}
+
+// Headers are the rules of a _headers file
+type Headers struct {
+	rules []rule
+	// error is the error which prevented reading the file
+	error error
+	// errs are the errors of the lines which were skipped
+	errs []error
+	// truncated is true when the file has more than maxRuleCount rules
+	truncated bool
+}
+
+// Status returns the number of rules and the errors of the _headers file
+func (h *Headers) Status() string {
+	if h.error != nil {
+		return fmt.Sprintf("parse error: %s", h.error.Error())
+	}
+
+	messages := make([]string, 0, len(h.errs)+1)
+	messages = append(messages, fmt.Sprintf("%d rules", len(h.rules)))
+
+	for _, err := range h.errs {
+		messages = append(messages, fmt.Sprintf("error: %s", err.Error()))
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+// Errors returns the error found while reading the _headers file or the
+// errors of the lines which were skipped
+func (h *Headers) Errors() []error {
+	if h.error != nil {
+		return []error{h.error}
+	}
+
+	return h.errs
+}
+
+// Apply sets the headers of the rules matching path on header, and the values
+// of a header set by several rules are all sent, in the order of the rules.
+// The headers already set on header, e.g. by the operator with -header, are
+// kept, apart from the cache headers, which replace the ones set by Pages.
+// Cache headers are not applied to the pages of projects with access control.
+func (h *Headers) Apply(header http.Header, path string, accessControl bool) {
+	upstream := make(map[string]bool, len(header))
+	for name := range header {
+		if _, ok := cacheHeaders[name]; !ok {
+			upstream[name] = true
+		}
+	}
+
+	replaced := make(map[string]bool)
+
+	for _, rule := range h.rules {
+		if !rule.pattern.MatchString(path) {
+			continue
+		}
+
+		for _, hdr := range rule.headers {
+			if upstream[hdr.name] {
+				continue
+			}
+
+			if _, ok := cacheHeaders[hdr.name]; ok && accessControl {
+				continue
+			}
+
+			if !replaced[hdr.name] {
+				header.Del(hdr.name)
+				replaced[hdr.name] = true
+			}
+
+			header.Add(hdr.name, hdr.value)
+		}
+	}
+}
+
+// ParseHeaders decodes Netlify style headers from the projects `.../public/_headers`
+// https://docs.netlify.com/routing/headers/#syntax-for-the-headers-file
+func ParseHeaders(ctx context.Context, root vfs.Root) *Headers {
+	fi, err := root.Lstat(ctx, ConfigFile)
+	if err != nil {
+		return &Headers{error: errConfigNotFound}
+	}
+
+	if !fi.Mode().IsRegular() {
+		return &Headers{error: errNeedRegularFile}
+	}
+
+	if fi.Size() > maxConfigSize {
+		return &Headers{error: errFileTooLarge}
+	}
+
+	reader, err := root.Open(ctx, ConfigFile)
+	if err != nil {
+		return &Headers{error: errFailedToOpenConfig}
+	}
+	defer reader.Close()
+
+	return parseHeaders(io.LimitReader(reader, maxConfigSize))
+}
+
+// parseHeaders decodes the rules read from reader. Paths start at the
+// beginning of a line and are followed by indented `Name: value` lines, lines
+// starting with # are comments. Invalid lines are skipped and reported.
+func parseHeaders(reader io.Reader) *Headers {
+	h := &Headers{}
+
+	// current is the index of the rule of the header lines, -1 before the
+	// first path and after invalid or ignored paths, whose header lines are
+	// skipped without reporting them
+	current := -1
+	skipping := false
+
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		trimmed := strings.TrimSpace(text)
+
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if text[0] != ' ' && text[0] != '\t' {
+			current, skipping = h.addRule(line, trimmed), true
+			continue
+		}
+
+		if current < 0 {
+			if !skipping {
+				h.errs = append(h.errs, fmt.Errorf("line %d: %w", line, errHeaderWithoutPath))
+			}
+			continue
+		}
+
+		hdr, err := parseHeader(trimmed)
+		if err != nil {
+			h.errs = append(h.errs, fmt.Errorf("line %d: %w", line, err))
+			continue
+		}
+
+		h.rules[current].headers = append(h.rules[current].headers, hdr)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return &Headers{error: errFailedToReadConfig}
+	}
+
+	return h
+}
+
+// addRule adds the rule of path and returns its index, or -1 when path is
+// not valid or there are too many rules already
+func (h *Headers) addRule(line int, path string) int {
+	if len(h.rules) >= maxRuleCount {
+		if !h.truncated {
+			h.truncated = true
+			h.errs = append(h.errs, errTooManyRules)
+		}
+		return -1
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		h.errs = append(h.errs, fmt.Errorf("line %d: %w", line, errPathMustStartSlash))
+		return -1
+	}
+
+	// the regular expressions of the rules can only be compiled from UTF-8
+	if !utf8.ValidString(path) {
+		h.errs = append(h.errs, fmt.Errorf("line %d: %w", line, errPathInvalidUTF8))
+		return -1
+	}
+
+	h.rules = append(h.rules, rule{path: path, pattern: compilePath(path)})
+
+	return len(h.rules) - 1
+}
+
+// parseHeader parses a `Name: value` line
+func parseHeader(text string) (header, error) {
+	i := strings.Index(text, ":")
+	if i < 0 {
+		return header{}, errInvalidHeader
+	}
+
+	name := strings.TrimSpace(text[:i])
+	value := strings.TrimSpace(text[i+1:])
+
+	if !validHeaderName(name) {
+		return header{}, errInvalidHeaderName
+	}
+
+	if !validHeaderValue(value) {
+		return header{}, errInvalidHeaderValue
+	}
+
+	name = textproto.CanonicalMIMEHeaderKey(name)
+	if _, ok := forbiddenHeaders[name]; ok {
+		return header{}, fmt.Errorf("%s: %w", name, errForbiddenHeader)
+	}
+
+	return header{name: name, value: value}, nil
+}
+
+// compilePath returns the regular expression matching the request paths of
+// the path of a rule. `*` matches any characters, including slashes, and
+// `:placeholder` segments match a whole segment.
+func compilePath(path string) *regexp.Regexp {
+	segments := strings.Split(path, "/")
+
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") && len(segment) > 1 {
+			segments[i] = `[^/]+`
+			continue
+		}
+
+		parts := strings.Split(segment, "*")
+		for j, part := range parts {
+			parts[j] = regexp.QuoteMeta(part)
+		}
+		segments[i] = strings.Join(parts, ".*")
+	}
+
+	return regexp.MustCompile("^" + strings.Join(segments, "/") + "$")
+}
+
+// validHeaderName checks name is a token as defined by RFC 7230
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, c := range name {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune(`()<>@,;:\"/[]?={}`, c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validHeaderValue checks value has no control characters
+func validHeaderValue(value string) bool {
+	for _, c := range value {
+		if (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,215 @@
+package headers
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/testhelpers"
+)
+
+const testHeaders = `# headers of every page
+/*
+  X-Frame-Options: DENY
+  X-Content-Type-Options: nosniff
+
+/assets/*
+  Cache-Control: public, max-age=31536000, immutable
+
+/api/:version/data.json
+  Access-Control-Allow-Origin: *
+
+/embed/*
+  X-Frame-Options: SAMEORIGIN
+`
+
+func TestApply(t *testing.T) {
+	h := parseHeaders(strings.NewReader(testHeaders))
+	require.Empty(t, h.Errors())
+
+	tests := map[string]struct {
+		path          string
+		accessControl bool
+		expected      http.Header
+	}{
+		"root": {
+			path: "/",
+			expected: http.Header{
+				"Cache-Control":          {"max-age=600"},
+				"X-Frame-Options":        {"DENY"},
+				"X-Content-Type-Options": {"nosniff"},
+			},
+		},
+		"splat": {
+			path: "/assets/js/app.js",
+			expected: http.Header{
+				"Cache-Control":          {"public, max-age=31536000, immutable"},
+				"X-Frame-Options":        {"DENY"},
+				"X-Content-Type-Options": {"nosniff"},
+			},
+		},
+		"placeholder": {
+			path: "/api/v1/data.json",
+			expected: http.Header{
+				"Cache-Control":               {"max-age=600"},
+				"X-Frame-Options":             {"DENY"},
+				"X-Content-Type-Options":      {"nosniff"},
+				"Access-Control-Allow-Origin": {"*"},
+			},
+		},
+		"placeholder_matches_one_segment": {
+			path: "/api/v1/beta/data.json",
+			expected: http.Header{
+				"Cache-Control":          {"max-age=600"},
+				"X-Frame-Options":        {"DENY"},
+				"X-Content-Type-Options": {"nosniff"},
+			},
+		},
+		"values_of_several_rules": {
+			path: "/embed/video.html",
+			expected: http.Header{
+				"Cache-Control":          {"max-age=600"},
+				"X-Frame-Options":        {"DENY", "SAMEORIGIN"},
+				"X-Content-Type-Options": {"nosniff"},
+			},
+		},
+		"access_control": {
+			path:          "/assets/js/app.js",
+			accessControl: true,
+			expected: http.Header{
+				"Cache-Control":          {"max-age=600"},
+				"X-Frame-Options":        {"DENY"},
+				"X-Content-Type-Options": {"nosniff"},
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			header := http.Header{"Cache-Control": {"max-age=600"}}
+
+			h.Apply(header, tt.path, tt.accessControl)
+			require.Equal(t, tt.expected, header)
+		})
+	}
+}
+
+func TestApplyKeepsUpstreamHeaders(t *testing.T) {
+	h := parseHeaders(strings.NewReader(testHeaders))
+	require.Empty(t, h.Errors())
+
+	header := http.Header{
+		"Cache-Control":   {"max-age=600"},
+		"X-Frame-Options": {"SAMEORIGIN"},
+	}
+
+	h.Apply(header, "/assets/js/app.js", false)
+	require.Equal(t, http.Header{
+		"Cache-Control":          {"public, max-age=31536000, immutable"},
+		"X-Frame-Options":        {"SAMEORIGIN"},
+		"X-Content-Type-Options": {"nosniff"},
+	}, header, "only the cache headers set by Pages are replaced")
+}
+
+func TestParseHeadersErrors(t *testing.T) {
+	tests := map[string]struct {
+		content        string
+		expectedRules  int
+		expectedStatus string
+	}{
+		"header_without_path": {
+			content:        "  X-Frame-Options: DENY\n/*\n  X-Test: value\n",
+			expectedRules:  1,
+			expectedStatus: "1 rules\nerror: line 1: header must follow a path",
+		},
+		"path_without_slash": {
+			content:        "*.html\n  X-Frame-Options: DENY\n/*\n  X-Test: value\n",
+			expectedRules:  1,
+			expectedStatus: "1 rules\nerror: line 1: path must start with forward slash /",
+		},
+		"path_invalid_utf8": {
+			content:        "/\xff\n  X-Frame-Options: DENY\n/*\n  X-Test: value\n",
+			expectedRules:  1,
+			expectedStatus: "1 rules\nerror: line 1: path must be valid UTF-8",
+		},
+		"invalid_header": {
+			content:        "/*\n  X-Frame-Options DENY\n  X-Test: value\n",
+			expectedRules:  1,
+			expectedStatus: "1 rules\nerror: line 2: header must be written as Name: value",
+		},
+		"invalid_header_name": {
+			content:        "/*\n  X Frame: DENY\n",
+			expectedRules:  1,
+			expectedStatus: "1 rules\nerror: line 2: invalid header name",
+		},
+		"invalid_header_value": {
+			content:        "/*\n  X-Test: value\x00\n",
+			expectedRules:  1,
+			expectedStatus: "1 rules\nerror: line 2: invalid header value",
+		},
+		"forbidden_header": {
+			content:        "/*\n  set-cookie: session=1\n",
+			expectedRules:  1,
+			expectedStatus: "1 rules\nerror: line 2: Set-Cookie: header cannot be set by projects",
+		},
+		"forbidden_project_header": {
+			content:        "/*\n  X-Gitlab-Pages-Project-Id: 1\n",
+			expectedRules:  1,
+			expectedStatus: "1 rules\nerror: line 2: X-Gitlab-Pages-Project-Id: header cannot be set by projects",
+		},
+		"too_many_rules": {
+			content:        strings.Repeat("/*\n  X-Test: value\n", maxRuleCount+2),
+			expectedRules:  maxRuleCount,
+			expectedStatus: "1000 rules\nerror: only the first 1000 rules will be applied",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := parseHeaders(strings.NewReader(tt.content))
+
+			require.Len(t, h.rules, tt.expectedRules)
+			require.Equal(t, tt.expectedStatus, h.Status())
+		})
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	tests := map[string]struct {
+		files          map[string]string
+		expectedStatus string
+	}{
+		"no_file": {
+			expectedStatus: "parse error: _headers file not found",
+		},
+		"directory": {
+			files:          map[string]string{"_headers/index.html": ""},
+			expectedStatus: "parse error: _headers needs to be a regular file (not a directory)",
+		},
+		"too_large": {
+			files:          map[string]string{ConfigFile: strings.Repeat("#", maxConfigSize+1)},
+			expectedStatus: "parse error: _headers file too large, the maximum size is 65536 bytes",
+		},
+		"valid": {
+			files:          map[string]string{ConfigFile: testHeaders},
+			expectedStatus: "4 rules",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			root, tmpDir := testhelpers.TmpDir(t, "ParseHeaders_tests")
+			for name, content := range tt.files {
+				require.NoError(t, os.MkdirAll(filepath.Join(tmpDir, filepath.Dir(name)), 0755))
+				require.NoError(t, os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0600))
+			}
+
+			require.Equal(t, tt.expectedStatus, ParseHeaders(context.Background(), root).Status())
+		})
+	}
+}
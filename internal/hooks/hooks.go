@@ -0,0 +1,194 @@
+// Package hooks receives the webhooks GitLab sends after Pages deployments,
+// so the new content is served right away instead of after the domain cache
+// refreshes
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/security"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+const (
+	// DeploymentPath is the path GitLab posts deployment webhooks to
+	DeploymentPath = "/-/hooks/deployment"
+
+	// DefaultTimeout is the maximum time spent refreshing the domains of a webhook
+	DefaultTimeout = 30 * time.Second
+
+	// maxDomains limits the number of domains refreshed by a single webhook
+	maxDomains = 100
+	// maxBodySize limits the size of the webhook payload
+	maxBodySize = 64 * 1024
+)
+
+// results of a domain refresh reported by metrics.DeploymentHooks
+const (
+	resultRefreshed = "refreshed"
+	resultNotFound  = "not_found"
+	resultFailed    = "failed"
+)
+
+var errInvalidPayload = errors.New("invalid deployment payload")
+
+// Refresher evicts the cached configuration of a domain and retrieves the
+// latest one, it is implemented by the GitLab domains source
+type Refresher interface {
+	RefreshDomain(ctx context.Context, name string, preload bool) error
+}
+
+// Payload is the body of a deployment webhook
+type Payload struct {
+	// Domains served by the deployed project
+	Domains []string `json:"domains"`
+	// Preload opens the archive of the new deployment before responding
+	Preload bool `json:"preload"`
+}
+
+// Result of the refresh of a domain
+type Result struct {
+	Domain string `json:"domain"`
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Hooks handles deployment webhooks
+type Hooks struct {
+	refresher Refresher
+	secret    []byte
+	timeout   time.Duration
+}
+
+// New creates Hooks refreshing domains with refresher. Webhooks must be
+// authenticated with a JWT token signed with the GitLab API secret.
+func New(refresher Refresher, secret []byte, timeout time.Duration) *Hooks {
+	return &Hooks{
+		refresher: refresher,
+		secret:    secret,
+		timeout:   timeout,
+	}
+}
+
+// NewMiddleware returns middleware which handles the deployment webhooks sent
+// to DeploymentPath with h, other requests are passed to handler. Webhooks are
+// not handled when h is nil.
+func NewMiddleware(handler http.Handler, h *Hooks) http.Handler {
+	if h == nil {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != DeploymentPath {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// ServeHTTP authenticates the webhook, refreshes its domains and writes the
+// JSON results
+func (h *Hooks) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	if err := security.VerifyAPIToken(r.Header.Get(security.APIRequestHeader), h.secret); err != nil {
+		log.WithError(err).Warn("unauthorized deployment webhook")
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	payload, err := readPayload(r)
+	if err != nil {
+		log.WithError(err).Warn("invalid deployment webhook")
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	status := http.StatusOK
+	results := make([]Result, 0, len(payload.Domains))
+
+	for _, name := range payload.Domains {
+		result := h.refresh(ctx, name, payload.Preload)
+		if result.Result == resultFailed {
+			status = http.StatusBadGateway
+		}
+
+		results = append(results, result)
+	}
+
+	writeJSON(w, status, results)
+}
+
+func (h *Hooks) refresh(ctx context.Context, name string, preload bool) Result {
+	result := Result{Domain: name, Result: resultRefreshed}
+
+	err := h.refresher.RefreshDomain(ctx, name, preload)
+	switch {
+	case errors.Is(err, domain.ErrDomainDoesNotExist):
+		result.Result = resultNotFound
+	case err != nil:
+		log.WithFields(logrus.Fields{
+			"domain":  name,
+			"preload": preload,
+		}).WithError(err).Error("failed to refresh domain after deployment")
+
+		result.Result = resultFailed
+		result.Error = err.Error()
+	}
+
+	metrics.DeploymentHooks.WithLabelValues(result.Result).Inc()
+
+	return result
+}
+
+func readPayload(r *http.Request) (*Payload, error) {
+	payload := &Payload{}
+
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBodySize)).Decode(payload); err != nil {
+		return nil, errInvalidPayload
+	}
+
+	if len(payload.Domains) == 0 || len(payload.Domains) > maxDomains {
+		return nil, errInvalidPayload
+	}
+
+	for i, name := range payload.Domains {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || len(name) > security.MaxDomainLength || strings.ContainsAny(name, "/:") {
+			return nil, errInvalidPayload
+		}
+
+		payload.Domains[i] = name
+	}
+
+	return payload, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.WithError(err).Error("failed to write deployment webhook response")
+	}
+}
@@ -0,0 +1,211 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/security"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/testhelpers"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+type refresherMock struct {
+	mu        sync.Mutex
+	errs      map[string]error
+	refreshed []string
+	preload   bool
+}
+
+func (m *refresherMock) RefreshDomain(ctx context.Context, name string, preload bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.refreshed = append(m.refreshed, name)
+	m.preload = preload
+
+	return m.errs[name]
+}
+
+func TestNewMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	validToken := testhelpers.SignAPIToken(t, []byte("secret"), jwt.NewNumericDate(time.Now().Add(time.Minute)))
+
+	tests := map[string]struct {
+		method          string
+		url             string
+		token           string
+		body            string
+		errs            map[string]error
+		expectedStatus  int
+		expectedResults []Result
+		expectedRefresh []string
+		expectedPreload bool
+	}{
+		"other_path": {
+			method:         http.MethodPost,
+			url:            "/index.html",
+			expectedStatus: http.StatusTeapot,
+		},
+		"wrong_method": {
+			method:         http.MethodGet,
+			url:            DeploymentPath,
+			token:          validToken,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		"missing_token": {
+			method:         http.MethodPost,
+			url:            DeploymentPath,
+			body:           `{"domains":["group.example.com"]}`,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"wrong_secret": {
+			method:         http.MethodPost,
+			url:            DeploymentPath,
+			token:          testhelpers.SignAPIToken(t, []byte("other"), jwt.NewNumericDate(time.Now().Add(time.Minute))),
+			body:           `{"domains":["group.example.com"]}`,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"token_without_expiration": {
+			method:         http.MethodPost,
+			url:            DeploymentPath,
+			token:          testhelpers.SignAPIToken(t, []byte("secret"), nil),
+			body:           `{"domains":["group.example.com"]}`,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"invalid_json": {
+			method:         http.MethodPost,
+			url:            DeploymentPath,
+			token:          validToken,
+			body:           `{"domains":`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		"no_domains": {
+			method:         http.MethodPost,
+			url:            DeploymentPath,
+			token:          validToken,
+			body:           `{"domains":[]}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		"invalid_domain": {
+			method:         http.MethodPost,
+			url:            DeploymentPath,
+			token:          validToken,
+			body:           `{"domains":["group.example.com/path"]}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		"refresh": {
+			method:          http.MethodPost,
+			url:             DeploymentPath,
+			token:           validToken,
+			body:            `{"domains":["Group.Example.com","custom.example.com"]}`,
+			expectedStatus:  http.StatusOK,
+			expectedRefresh: []string{"group.example.com", "custom.example.com"},
+			expectedResults: []Result{
+				{Domain: "group.example.com", Result: resultRefreshed},
+				{Domain: "custom.example.com", Result: resultRefreshed},
+			},
+		},
+		"preload": {
+			method:          http.MethodPost,
+			url:             DeploymentPath,
+			token:           validToken,
+			body:            `{"domains":["group.example.com"],"preload":true}`,
+			expectedStatus:  http.StatusOK,
+			expectedRefresh: []string{"group.example.com"},
+			expectedPreload: true,
+			expectedResults: []Result{{Domain: "group.example.com", Result: resultRefreshed}},
+		},
+		"domain_does_not_exist": {
+			method:          http.MethodPost,
+			url:             DeploymentPath,
+			token:           validToken,
+			body:            `{"domains":["deleted.example.com"]}`,
+			errs:            map[string]error{"deleted.example.com": domain.ErrDomainDoesNotExist},
+			expectedStatus:  http.StatusOK,
+			expectedRefresh: []string{"deleted.example.com"},
+			expectedResults: []Result{{Domain: "deleted.example.com", Result: resultNotFound}},
+		},
+		"refresh_failed": {
+			method:          http.MethodPost,
+			url:             DeploymentPath,
+			token:           validToken,
+			body:            `{"domains":["group.example.com","custom.example.com"],"preload":true}`,
+			errs:            map[string]error{"custom.example.com": errors.New("archive not found")},
+			expectedStatus:  http.StatusBadGateway,
+			expectedRefresh: []string{"group.example.com", "custom.example.com"},
+			expectedPreload: true,
+			expectedResults: []Result{
+				{Domain: "group.example.com", Result: resultRefreshed},
+				{Domain: "custom.example.com", Result: resultFailed, Error: "archive not found"},
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			refresher := &refresherMock{errs: tt.errs}
+			handler := NewMiddleware(next, New(refresher, []byte("secret"), time.Second))
+
+			req := httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set(security.APIRequestHeader, tt.token)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			require.Equal(t, tt.expectedStatus, w.Code)
+			require.Equal(t, tt.expectedRefresh, refresher.refreshed)
+			require.Equal(t, tt.expectedPreload, refresher.preload)
+
+			if tt.expectedResults == nil {
+				return
+			}
+
+			require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+			var results []Result
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&results))
+			require.Equal(t, tt.expectedResults, results)
+		})
+	}
+}
+
+func TestNewMiddlewareDisabled(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	w := httptest.NewRecorder()
+	NewMiddleware(next, nil).ServeHTTP(w, httptest.NewRequest(http.MethodPost, DeploymentPath, nil))
+
+	require.Equal(t, http.StatusTeapot, w.Code)
+}
+
+func TestRefreshMetrics(t *testing.T) {
+	refreshed := metrics.DeploymentHooks.WithLabelValues(resultRefreshed)
+	failed := metrics.DeploymentHooks.WithLabelValues(resultFailed)
+	refreshedBefore, failedBefore := testutil.ToFloat64(refreshed), testutil.ToFloat64(failed)
+
+	h := New(&refresherMock{errs: map[string]error{"b.example.com": errors.New("failed")}}, []byte("secret"), time.Second)
+	h.refresh(context.Background(), "a.example.com", false)
+	h.refresh(context.Background(), "b.example.com", false)
+
+	require.Equal(t, refreshedBefore+1, testutil.ToFloat64(refreshed))
+	require.Equal(t, failedBefore+1, testutil.ToFloat64(failed))
+}
@@ -2,11 +2,18 @@ package httperrors
 
 import (
 	"fmt"
+	"html"
 	"net/http"
+	"strings"
+	"sync"
 
 	"gitlab.com/gitlab-org/labkit/correlation"
 	"gitlab.com/gitlab-org/labkit/errortracking"
 	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/pageserrors"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/request"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
 type content struct {
@@ -18,6 +25,14 @@ type content struct {
 }
 
 var (
+	content400 = content{
+		http.StatusBadRequest,
+		"Bad Request (400)",
+		"400",
+		"The request could not be understood.",
+		`<p>The address of the page you are attempting to access is malformed.</p>
+     <p>Make sure the address is correct.</p>`,
+	}
 	content401 = content{
 		http.StatusUnauthorized,
 		"Unauthorized (401)",
@@ -25,6 +40,13 @@ var (
 		"You don't have permission to access the resource.",
 		`<p>The resource that you are attempting to access is protected and you don't have the necessary permissions to view it.</p>`,
 	}
+	content403 = content{
+		http.StatusForbidden,
+		"Forbidden (403)",
+		"403",
+		"You don't have permission to access the resource.",
+		`<p>The resource that you are attempting to access is not served.</p>`,
+	}
 	content404 = content{
 		http.StatusNotFound,
 		"The page you're looking for could not be found (404)",
@@ -33,6 +55,22 @@ var (
 		`<p>The resource that you are attempting to access does not exist or you don't have the necessary permissions to view it.</p>
      <p>Make sure the address is correct and that the page hasn't moved.</p>
      <p>Please contact your GitLab administrator if you think this is a mistake.</p>`,
+	}
+	content410 = content{
+		http.StatusGone,
+		"The page you're looking for is gone (410)",
+		"410",
+		"The page you're looking for is gone.",
+		`<p>The project serving this page was deleted and its pages are not available anymore.</p>
+     <p>Please contact the owner of the project if you think this is a mistake.</p>`,
+	}
+	contentUnverified = content{
+		http.StatusNotFound,
+		"This domain is not verified (404)",
+		"404",
+		"This domain is not verified yet.",
+		`<p>The owner of this domain has not verified it yet, so it does not serve any content.</p>
+     <p>If you own this domain, verify it in the Pages settings of your project.</p>`,
 	}
 	content414 = content{
 		status:       http.StatusRequestURITooLong,
@@ -76,6 +114,18 @@ var (
 		`<p>Try refreshing the page, or going back and attempting the action again.</p>
      <p>Please contact your GitLab administrator if this problem persists.</p>`,
 	}
+
+	contentByStatus = map[int]content{
+		http.StatusBadRequest:          content400,
+		http.StatusUnauthorized:        content401,
+		http.StatusForbidden:           content403,
+		http.StatusNotFound:            content404,
+		http.StatusRequestURITooLong:   content414,
+		http.StatusTooManyRequests:     content429,
+		http.StatusInternalServerError: content500,
+		http.StatusBadGateway:          content502,
+		http.StatusServiceUnavailable:  content503,
+	}
 )
 
 const predefinedErrorPage = `
@@ -175,11 +225,65 @@ func generateErrorHTML(c content) string {
 	return fmt.Sprintf(predefinedErrorPage, c.title, c.statusString, c.header, c.subHeader)
 }
 
+// withCode returns a copy of c that displays the error code below the sub header
+func (c content) withCode(code string) content {
+	c.subHeader += fmt.Sprintf("\n    <p>Error code: <code>%s</code></p>", code)
+	return c
+}
+
+// renderedPages caches the pages of the predefined contents and of the error
+// categories, so they are only rendered once and not for every error served
+var renderedPages sync.Map
+
+// renderedPage returns the page of c from renderedPages, rendering it when it
+// is served for the first time
+func renderedPage(c content) []byte {
+	if page, ok := renderedPages.Load(c); ok {
+		return page.([]byte)
+	}
+
+	page, _ := renderedPages.LoadOrStore(c, renderPage(c))
+
+	return page.([]byte)
+}
+
+func renderPage(c content) []byte {
+	return []byte(generateErrorHTML(c) + "\n")
+}
+
 func serveErrorPage(w http.ResponseWriter, c content) {
+	writeErrorPage(w, c.status, renderedPage(c))
+}
+
+func writeErrorPage(w http.ResponseWriter, status int, page []byte) {
+	DisableCaching(w)
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.Header().Set("X-Content-Type-Options", "nosniff")
-	w.WriteHeader(c.status)
-	fmt.Fprintln(w, generateErrorHTML(c))
+	w.WriteHeader(status)
+	w.Write(page)
+}
+
+// DisableCaching keeps caches, like CDNs in front of Pages, from storing the
+// response. Error pages and authentication redirects depend on the session
+// cookie, so a response served to one user is never served to another one.
+func DisableCaching(w http.ResponseWriter) {
+	h := w.Header()
+	h.Set("Cache-Control", "no-store")
+
+	for _, value := range h.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(name), "Cookie") {
+				return
+			}
+		}
+	}
+
+	h.Add("Vary", "Cookie")
+}
+
+// Serve400 returns a 400 error response / HTML page to the http.ResponseWriter
+func Serve400(w http.ResponseWriter) {
+	serveErrorPage(w, content400)
 }
 
 // Serve401 returns a 401 error response / HTML page to the http.ResponseWriter
@@ -187,11 +291,59 @@ func Serve401(w http.ResponseWriter) {
 	serveErrorPage(w, content401)
 }
 
+// Serve401SignIn returns a 401 error response / HTML page with a link opening
+// signInURL in the top-level window, for pages embedded in frames which cannot
+// be redirected to the sign in page
+func Serve401SignIn(w http.ResponseWriter, signInURL string) {
+	c := content401
+	c.subHeader = fmt.Sprintf(`<p>You need to sign in to view this page.</p>
+     <p><a href="%s" target="_top">Sign in</a></p>`, html.EscapeString(signInURL))
+
+	// the page differs for every sign in URL so it is not cached
+	writeErrorPage(w, c.status, renderPage(c))
+}
+
+// Serve401RedirectLoop returns a 401 error response / HTML page to the
+// http.ResponseWriter when the authentication kept redirecting the user,
+// with a link to retry at retryURL
+func Serve401RedirectLoop(w http.ResponseWriter, retryURL string) {
+	c := content401
+	c.header = "The sign in could not be completed."
+	c.subHeader = fmt.Sprintf(`<p>You were redirected to sign in several times without being signed in.</p>
+     <p><a href="%s" target="_top">Try again</a></p>
+     <p>Please contact your GitLab administrator if this problem persists.</p>`, html.EscapeString(retryURL))
+
+	// the page differs for every retry URL so it is not cached
+	writeErrorPage(w, c.status, renderPage(c))
+}
+
+// Serve403 returns a 403 error response / HTML page to the http.ResponseWriter
+func Serve403(w http.ResponseWriter) {
+	serveErrorPage(w, content403)
+}
+
 // Serve404 returns a 404 error response / HTML page to the http.ResponseWriter
 func Serve404(w http.ResponseWriter) {
 	serveErrorPage(w, content404)
 }
 
+// Serve410 returns a 410 error response to the http.ResponseWriter for the
+// pages of deleted domains, with page as HTML page or the default one when
+// page is empty
+func Serve410(w http.ResponseWriter, page []byte) {
+	if len(page) == 0 {
+		page = renderedPage(content410)
+	}
+
+	writeErrorPage(w, http.StatusGone, page)
+}
+
+// ServeUnverified returns the 404 placeholder page of the custom domains
+// which are not verified to the http.ResponseWriter
+func ServeUnverified(w http.ResponseWriter) {
+	serveErrorPage(w, contentUnverified)
+}
+
 // Serve414 returns a 414 error response / HTML page to the http.ResponseWriter
 func Serve414(w http.ResponseWriter) {
 	serveErrorPage(w, content414)
@@ -207,15 +359,65 @@ func Serve500(w http.ResponseWriter) {
 	serveErrorPage(w, content500)
 }
 
-// Serve500WithRequest returns a 500 error response / HTML page to the http.ResponseWriter
-func Serve500WithRequest(w http.ResponseWriter, r *http.Request, reason string, err error) {
-	log.WithFields(log.Fields{
+// ServeError returns an error response / HTML page to the http.ResponseWriter
+// using the status code and short error code of the error category.
+// Uncategorized errors are served as pageserrors.Internal.
+func ServeError(w http.ResponseWriter, r *http.Request, reason string, err error) {
+	ServeErrorCategory(w, LogError(r, reason, err))
+}
+
+// LogError logs err with the reason it could not be served and returns the
+// category of the error, so callers can serve their own error page
+func LogError(r *http.Request, reason string, err error) pageserrors.Category {
+	category := pageserrors.CategoryOf(err)
+
+	logger := log.WithFields(log.Fields{
 		"correlation_id": correlation.ExtractFromContext(r.Context()),
 		"host":           r.Host,
 		"path":           r.URL.Path,
-	}).WithError(err).Error(reason)
-	errortracking.Capture(err, errortracking.WithRequest(r), errortracking.WithStackTrace())
-	serveErrorPage(w, content500)
+		"error_category": category.Name,
+		"error_code":     category.Code,
+	}).WithError(err)
+
+	// errors caused by clients going away are not failures of Pages
+	if request.IsClientDisconnect(r, err) {
+		logger.WithField("client_disconnect", true).Info(reason)
+	} else {
+		logger.Error(reason)
+
+		if category.Status >= http.StatusInternalServerError {
+			errortracking.Capture(err, errortracking.WithRequest(r), errortracking.WithStackTrace())
+		}
+	}
+
+	return category
+}
+
+// ServeErrorCategory returns the error response / HTML page of the category
+// to the http.ResponseWriter without logging it
+func ServeErrorCategory(w http.ResponseWriter, category pageserrors.Category) {
+	metrics.ErrorsServed.WithLabelValues(category.Name).Inc()
+
+	c, ok := contentByStatus[category.Status]
+	if !ok {
+		c = content500
+	}
+
+	serveErrorPage(w, c.withCode(category.Code))
+}
+
+// ServeErrorCategoryPage returns page, e.g. a custom error page of a project,
+// with the status of the category to the http.ResponseWriter, falling back to
+// the error page of the category when page is nil
+func ServeErrorCategoryPage(w http.ResponseWriter, category pageserrors.Category, page []byte) {
+	if page == nil {
+		ServeErrorCategory(w, category)
+		return
+	}
+
+	metrics.ErrorsServed.WithLabelValues(category.Name).Inc()
+
+	writeErrorPage(w, category.Status, page)
 }
 
 // Serve502 returns a 502 error response / HTML page to the http.ResponseWriter
@@ -1,11 +1,17 @@
 package httperrors
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/pageserrors"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
 // creates a new implementation of http.ResponseWriter that allows the
@@ -65,9 +71,72 @@ func TestServeErrorPage(t *testing.T) {
 	serveErrorPage(w, testingContent)
 	require.Equal(t, w.Header().Get("Content-Type"), "text/html; charset=utf-8")
 	require.Equal(t, w.Header().Get("X-Content-Type-Options"), "nosniff")
+	require.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	require.Equal(t, "Cookie", w.Header().Get("Vary"))
 	require.Equal(t, w.Status(), testingContent.status)
 }
 
+func TestDisableCaching(t *testing.T) {
+	tests := map[string]struct {
+		vary         []string
+		expectedVary []string
+	}{
+		"no_vary": {
+			expectedVary: []string{"Cookie"},
+		},
+		"other_vary": {
+			vary:         []string{"Accept-Encoding"},
+			expectedVary: []string{"Accept-Encoding", "Cookie"},
+		},
+		"already_varies_with_cookie": {
+			vary:         []string{"Accept-Encoding, cookie"},
+			expectedVary: []string{"Accept-Encoding, cookie"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			w.Header().Set("Cache-Control", "max-age=600")
+			for _, value := range tt.vary {
+				w.Header().Add("Vary", value)
+			}
+
+			DisableCaching(w)
+
+			require.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+			require.Equal(t, tt.expectedVary, w.Header().Values("Vary"))
+		})
+	}
+}
+
+func TestServeErrorPageCached(t *testing.T) {
+	first := httptest.NewRecorder()
+	serveErrorPage(first, testingContent)
+
+	second := httptest.NewRecorder()
+	serveErrorPage(second, testingContent)
+
+	require.Equal(t, renderPage(testingContent), first.Body.Bytes())
+	require.Equal(t, first.Body.String(), second.Body.String())
+
+	page, ok := renderedPages.Load(testingContent)
+	require.True(t, ok)
+	require.Equal(t, first.Body.Bytes(), page)
+}
+
+func TestServe400(t *testing.T) {
+	w := newTestResponseWriter(httptest.NewRecorder())
+	Serve400(w)
+	require.Equal(t, w.Header().Get("Content-Type"), "text/html; charset=utf-8")
+	require.Equal(t, w.Header().Get("X-Content-Type-Options"), "nosniff")
+	require.Equal(t, w.Status(), content400.status)
+	require.Contains(t, w.Content(), content400.title)
+	require.Contains(t, w.Content(), content400.statusString)
+	require.Contains(t, w.Content(), content400.header)
+	require.Contains(t, w.Content(), content400.subHeader)
+}
+
 func TestServe401(t *testing.T) {
 	w := newTestResponseWriter(httptest.NewRecorder())
 	Serve401(w)
@@ -92,6 +161,23 @@ func TestServe404(t *testing.T) {
 	require.Contains(t, w.Content(), content404.subHeader)
 }
 
+func TestServe410(t *testing.T) {
+	w := newTestResponseWriter(httptest.NewRecorder())
+	Serve410(w, nil)
+	require.Equal(t, w.Header().Get("Content-Type"), "text/html; charset=utf-8")
+	require.Equal(t, w.Header().Get("X-Content-Type-Options"), "nosniff")
+	require.Equal(t, w.Status(), content410.status)
+	require.Contains(t, w.Content(), content410.title)
+	require.Contains(t, w.Content(), content410.statusString)
+	require.Contains(t, w.Content(), content410.header)
+	require.Contains(t, w.Content(), content410.subHeader)
+
+	w = newTestResponseWriter(httptest.NewRecorder())
+	Serve410(w, []byte("custom 410"))
+	require.Equal(t, http.StatusGone, w.Status())
+	require.Equal(t, "custom 410", w.Content())
+}
+
 func TestServe414(t *testing.T) {
 	w := newTestResponseWriter(httptest.NewRecorder())
 	Serve414(w)
@@ -127,3 +213,92 @@ func TestServe502(t *testing.T) {
 	require.Contains(t, w.Content(), content502.header)
 	require.Contains(t, w.Content(), content502.subHeader)
 }
+
+func TestServeError(t *testing.T) {
+	tests := map[string]struct {
+		err             error
+		expectedContent content
+		expectedCode    string
+	}{
+		"uncategorized_error": {
+			err:             errors.New("something went wrong"),
+			expectedContent: content500,
+			expectedCode:    pageserrors.Internal.Code,
+		},
+		"source_unavailable": {
+			err:             pageserrors.New(pageserrors.SourceUnavailable, "API is down"),
+			expectedContent: content503,
+			expectedCode:    pageserrors.SourceUnavailable.Code,
+		},
+		"source_failed": {
+			err:             pageserrors.Wrap(pageserrors.SourceFailed, pageserrors.New(pageserrors.SourceUnavailable, "API is down")),
+			expectedContent: content502,
+			expectedCode:    pageserrors.SourceFailed.Code,
+		},
+		"wrapped_archive_invalid": {
+			err:             fmt.Errorf("open: %w", pageserrors.New(pageserrors.ArchiveInvalid, "not a zip file")),
+			expectedContent: content500,
+			expectedCode:    pageserrors.ArchiveInvalid.Code,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := newTestResponseWriter(httptest.NewRecorder())
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			ServeError(w, r, "test", tt.err)
+			require.Equal(t, tt.expectedContent.status, w.Status())
+			require.Contains(t, w.Content(), tt.expectedContent.title)
+			require.Contains(t, w.Content(), "Error code: <code>"+tt.expectedCode+"</code>")
+		})
+	}
+}
+
+func TestServeErrorCategory(t *testing.T) {
+	counter := metrics.ErrorsServed.WithLabelValues(pageserrors.QuotaExceeded.Name)
+	before := testutil.ToFloat64(counter)
+
+	w := newTestResponseWriter(httptest.NewRecorder())
+	ServeErrorCategory(w, pageserrors.QuotaExceeded)
+
+	require.Equal(t, http.StatusTooManyRequests, w.Status())
+	require.Contains(t, w.Content(), content429.header)
+	require.Contains(t, w.Content(), pageserrors.QuotaExceeded.Code)
+	require.Equal(t, before+1, testutil.ToFloat64(counter))
+}
+
+func TestServeErrorCategoryPage(t *testing.T) {
+	counter := metrics.ErrorsServed.WithLabelValues(pageserrors.ArchiveInvalid.Name)
+	before := testutil.ToFloat64(counter)
+
+	w := newTestResponseWriter(httptest.NewRecorder())
+	ServeErrorCategoryPage(w, pageserrors.ArchiveInvalid, []byte("custom 500"))
+
+	require.Equal(t, http.StatusInternalServerError, w.Status())
+	require.Equal(t, "custom 500", w.Content())
+	require.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	require.Equal(t, before+1, testutil.ToFloat64(counter))
+
+	w = newTestResponseWriter(httptest.NewRecorder())
+	ServeErrorCategoryPage(w, pageserrors.ArchiveInvalid, nil)
+
+	require.Equal(t, http.StatusInternalServerError, w.Status())
+	require.Contains(t, w.Content(), content500.header)
+}
+
+func BenchmarkServeErrorPage(b *testing.B) {
+	b.Run("rendered", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			writeErrorPage(httptest.NewRecorder(), content404.status, renderPage(content404))
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			serveErrorPage(httptest.NewRecorder(), content404)
+		}
+	})
+}
@@ -3,12 +3,15 @@ package httprange
 import (
 	"context"
 	"io"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
 )
 
 // RangedReader for a resource.
 // Implements the io.ReaderAt interface that can be used with Go's archive/zip package.
 type RangedReader struct {
 	Resource     *Resource
+	ReadAhead    ReadAhead
 	cachedReader *Reader
 }
 
@@ -29,8 +32,14 @@ func (rr *RangedReader) ephemeralRead(buf []byte, offset int64) (n int, err erro
 	return io.ReadFull(reader, buf)
 }
 
-// SectionReader partitions a resource from `offset` with a specified `size`
-func (rr *RangedReader) SectionReader(ctx context.Context, offset, size int64) *Reader {
+// SectionReader partitions a resource from `offset` with a specified `size`.
+// Sections larger than a chunk and ReadAhead.MinSize are read with a
+// PrefetchReader when ReadAhead is enabled.
+func (rr *RangedReader) SectionReader(ctx context.Context, offset, size int64) vfs.SeekableFile {
+	if rr.ReadAhead.EnabledFor(size) {
+		return NewPrefetchReader(ctx, rr.Resource, offset, size, rr.ReadAhead)
+	}
+
 	return NewReader(ctx, rr.Resource, offset, size)
 }
 
@@ -22,6 +22,10 @@ var (
 	// ErrInvalidRange is returned by Read when trying to read past the end of the file
 	ErrInvalidRange = errors.New("invalid range")
 
+	// ErrAccessDenied is wrapped by the errors returned by Read when the server
+	// denies access to the resource, e.g. once its pre-signed URL expired
+	ErrAccessDenied = errors.New("access denied")
+
 	// seek errors no need to export them
 	errSeekInvalidWhence = errors.New("invalid whence")
 	errSeekOutsideRange  = errors.New("outside of range")
@@ -54,17 +58,23 @@ func (r *Reader) ensureResponse() error {
 		return nil
 	}
 
-	req, err := r.prepareRequest()
+	url := r.Resource.URL()
+
+	res, err := r.do()
 	if err != nil {
 		return err
 	}
 
-	metrics.HTTPRangeOpenRequests.Inc()
-
-	res, err := r.Resource.httpClient.Do(req)
-	if err != nil {
+	// pre-signed URLs expire while archives are cached, the request is
+	// retried once with a new URL
+	if isAccessDenied(res.StatusCode) && r.Resource.RefreshURL(r.ctx, url) == nil {
 		metrics.HTTPRangeOpenRequests.Dec()
-		return err
+		res.Body.Close()
+
+		res, err = r.do()
+		if err != nil {
+			return err
+		}
 	}
 
 	err = r.setResponse(res)
@@ -78,6 +88,25 @@ func (r *Reader) ensureResponse() error {
 	return err
 }
 
+// do sends the request of the current range, the open requests are counted
+// until the response is closed
+func (r *Reader) do() (*http.Response, error) {
+	req, err := r.prepareRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.HTTPRangeOpenRequests.Inc()
+
+	res, err := r.Resource.httpClient.Do(req)
+	if err != nil {
+		metrics.HTTPRangeOpenRequests.Dec()
+		return nil, err
+	}
+
+	return res, nil
+}
+
 func (r *Reader) prepareRequest() (*http.Request, error) {
 	if r.rangeStart < 0 || r.rangeSize < 0 || r.rangeStart+r.rangeSize > r.Resource.Size {
 		return nil, ErrInvalidRange
@@ -118,6 +147,10 @@ func (r *Reader) setResponse(res *http.Response) error {
 		r.Resource.setError(ErrRangeRequestsNotSupported)
 		return ErrRangeRequestsNotSupported
 	default:
+		if isAccessDenied(res.StatusCode) {
+			return fmt.Errorf("httprange: read response %d: %q: %w", res.StatusCode, res.Status, ErrAccessDenied)
+		}
+
 		return fmt.Errorf("httprange: read response %d: %q", res.StatusCode, res.Status)
 	}
 
@@ -2,11 +2,17 @@ package httprange
 
 import (
 	"context"
+	"errors"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
 )
 
 func TestSeekAndRead(t *testing.T) {
@@ -339,3 +345,81 @@ func TestReaderSeek(t *testing.T) {
 		})
 	}
 }
+
+func TestReaderRefreshesDeniedURL(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/expired" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		http.ServeContent(w, r, r.URL.Path, time.Time{}, strings.NewReader(testData))
+	}))
+	defer testServer.Close()
+
+	tests := map[string]struct {
+		refresh         vfs.PathRefresher
+		expectedURL     string
+		expectedRefresh int
+		expectedErr     string
+	}{
+		"refreshed": {
+			refresh: func(context.Context) (string, error) {
+				return testServer.URL + "/data", nil
+			},
+			expectedURL:     testServer.URL + "/data",
+			expectedRefresh: 1,
+		},
+		"no_refresher": {
+			expectedURL: testServer.URL + "/expired",
+			expectedErr: "httprange: read response 403",
+		},
+		"refresh_error": {
+			refresh: func(context.Context) (string, error) {
+				return "", errors.New("deployment changed")
+			},
+			expectedURL:     testServer.URL + "/expired",
+			expectedRefresh: 1,
+			expectedErr:     "httprange: read response 403",
+		},
+		"refreshed_url_denied": {
+			refresh: func(context.Context) (string, error) {
+				return testServer.URL + "/expired", nil
+			},
+			expectedURL:     testServer.URL + "/expired",
+			expectedRefresh: 1,
+			expectedErr:     "httprange: read response 403",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			resource, err := NewResource(context.Background(), testServer.URL+"/data", testClient)
+			require.NoError(t, err)
+
+			resource.SetURL(testServer.URL + "/expired")
+
+			ctx := context.Background()
+			refreshes := 0
+			if tt.refresh != nil {
+				ctx = vfs.WithPathRefresher(ctx, func(ctx context.Context) (string, error) {
+					refreshes++
+					return tt.refresh(ctx)
+				})
+			}
+
+			data, err := io.ReadAll(NewReader(ctx, resource, 0, resource.Size))
+			if tt.expectedErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tt.expectedErr)
+				require.ErrorIs(t, err, ErrAccessDenied)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, testData, string(data))
+			}
+
+			require.Equal(t, tt.expectedURL, resource.URL())
+			require.Equal(t, tt.expectedRefresh, refreshes)
+		})
+	}
+}
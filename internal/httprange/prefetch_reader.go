@@ -0,0 +1,238 @@
+package httprange
+
+import (
+	"context"
+	"io"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+)
+
+// ReadAhead configures the chunks fetched ahead of the client when reading a
+// section of a resource sequentially
+type ReadAhead struct {
+	// ChunkSize is the size in bytes of each range request
+	ChunkSize int64
+	// MaxPrefetch is the number of chunks fetched ahead of the chunk being read
+	MaxPrefetch int
+	// MinSize is the size in bytes from which sections are read ahead,
+	// sections not larger than ChunkSize are never read ahead
+	MinSize int64
+	// Limiter caps the number of chunks fetched concurrently by all the
+	// readers sharing it, there is no cap when nil
+	Limiter *FetchLimiter
+}
+
+// Enabled returns true when chunks should be fetched ahead
+func (ra ReadAhead) Enabled() bool {
+	return ra.ChunkSize > 0 && ra.MaxPrefetch > 0
+}
+
+// EnabledFor returns true when the chunks of a section of size bytes should be
+// fetched ahead
+func (ra ReadAhead) EnabledFor(size int64) bool {
+	return ra.Enabled() && size > ra.ChunkSize && size >= ra.MinSize
+}
+
+// FetchLimiter caps the number of chunks fetched concurrently
+type FetchLimiter struct {
+	sem chan struct{}
+}
+
+// NewFetchLimiter returns a FetchLimiter allowing max concurrent fetches, or
+// nil when max is not positive
+func NewFetchLimiter(max int) *FetchLimiter {
+	if max <= 0 {
+		return nil
+	}
+
+	return &FetchLimiter{sem: make(chan struct{}, max)}
+}
+
+// acquire waits until a fetch is allowed or ctx is done
+func (l *FetchLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	select {
+	case l.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *FetchLimiter) release() {
+	if l == nil {
+		return
+	}
+
+	<-l.sem
+}
+
+// chunk is a range of a resource fetched in the background
+type chunk struct {
+	offset int64
+	data   []byte
+	err    error
+	done   chan struct{}
+}
+
+// PrefetchReader reads a section of a resource in chunks, fetching up to
+// MaxPrefetch chunks concurrently while the previous ones are being read.
+// Implements the io.Reader, io.Seeker and io.Closer interfaces.
+type PrefetchReader struct {
+	// ctx for read requests
+	ctx context.Context
+	// fetchCtx is canceled to stop fetching the chunks of the current offset
+	fetchCtx context.Context
+	cancel   context.CancelFunc
+	// Resource to read from
+	Resource *Resource
+	// readAhead configures the chunks fetched ahead
+	readAhead ReadAhead
+	// rangeStart defines a starting range
+	rangeStart int64
+	// rangeSize defines a size of range
+	rangeSize int64
+	// offset defines a current place where data is being read from
+	offset int64
+	// next is the offset of the next chunk to fetch
+	next int64
+	// chunks being fetched or read, in order
+	chunks []*chunk
+}
+
+// ensure that PrefetchReader is seekable
+var _ vfs.SeekableFile = &PrefetchReader{}
+
+// NewPrefetchReader creates a PrefetchReader on a given resource for a given range
+func NewPrefetchReader(ctx context.Context, resource *Resource, offset, size int64, readAhead ReadAhead) *PrefetchReader {
+	return &PrefetchReader{
+		ctx:        ctx,
+		Resource:   resource,
+		readAhead:  readAhead,
+		rangeStart: offset,
+		rangeSize:  size,
+		offset:     offset,
+		next:       offset,
+	}
+}
+
+// prefetch starts fetching chunks until MaxPrefetch chunks are ahead of the
+// one being read or the end of the range is reached
+func (r *PrefetchReader) prefetch() {
+	if r.cancel == nil {
+		r.fetchCtx, r.cancel = context.WithCancel(r.ctx)
+	}
+
+	end := r.rangeStart + r.rangeSize
+
+	for len(r.chunks) <= r.readAhead.MaxPrefetch && r.next < end {
+		size := r.readAhead.ChunkSize
+		if r.next+size > end {
+			size = end - r.next
+		}
+
+		c := &chunk{offset: r.next, done: make(chan struct{})}
+		go fetch(r.fetchCtx, r.Resource, c, size, r.readAhead.Limiter)
+
+		r.chunks = append(r.chunks, c)
+		r.next += size
+	}
+}
+
+func fetch(ctx context.Context, resource *Resource, c *chunk, size int64, limiter *FetchLimiter) {
+	defer close(c.done)
+
+	if c.err = limiter.acquire(ctx); c.err != nil {
+		return
+	}
+	defer limiter.release()
+
+	reader := NewReader(ctx, resource, c.offset, size)
+	defer reader.Close()
+
+	c.data = make([]byte, size)
+	_, c.err = io.ReadFull(reader, c.data)
+}
+
+// Read data into a given buffer.
+func (r *PrefetchReader) Read(buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+
+	if r.offset >= r.rangeStart+r.rangeSize {
+		return 0, io.EOF
+	}
+
+	r.prefetch()
+
+	c := r.chunks[0]
+	select {
+	case <-c.done:
+	case <-r.ctx.Done():
+		return 0, r.ctx.Err()
+	}
+
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	n := copy(buf, c.data[r.offset-c.offset:])
+	r.offset += int64(n)
+
+	if r.offset >= c.offset+int64(len(c.data)) {
+		r.chunks[0] = nil
+		r.chunks = r.chunks[1:]
+		r.prefetch()
+	}
+
+	return n, nil
+}
+
+// Seek returns the new offset relative to the start of the section and an
+// error, if any. Chunks fetched for the previous offset are discarded.
+func (r *PrefetchReader) Seek(offset int64, whence int) (int64, error) {
+	var newOffset int64
+
+	switch whence {
+	case io.SeekStart:
+		newOffset = r.rangeStart + offset
+
+	case io.SeekCurrent:
+		newOffset = r.offset + offset
+
+	case io.SeekEnd:
+		newOffset = r.rangeStart + r.rangeSize + offset
+
+	default:
+		return 0, errSeekInvalidWhence
+	}
+
+	if newOffset < r.rangeStart || newOffset > r.rangeStart+r.rangeSize {
+		return 0, errSeekOutsideRange
+	}
+
+	if newOffset != r.offset {
+		// discard the chunks fetched ahead of the previous offset
+		r.Close()
+		r.next = newOffset
+	}
+
+	r.offset = newOffset
+	return newOffset - r.rangeStart, nil
+}
+
+// Close stops fetching chunks and releases the fetched ones
+func (r *PrefetchReader) Close() error {
+	if r.cancel != nil {
+		r.cancel()
+		r.cancel = nil
+	}
+
+	r.chunks = nil
+
+	return nil
+}
@@ -0,0 +1,237 @@
+package httprange
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefetchReaderRead(t *testing.T) {
+	tests := map[string]struct {
+		sectionOffset    int
+		sectionSize      int
+		readAhead        ReadAhead
+		expectedContent  string
+		expectedRequests int64
+	}{
+		"whole_resource": {
+			sectionSize:      testDataLen,
+			readAhead:        ReadAhead{ChunkSize: 10, MaxPrefetch: 1},
+			expectedContent:  testData,
+			expectedRequests: 3,
+		},
+		"last_chunk_is_smaller": {
+			sectionSize:      testDataLen,
+			readAhead:        ReadAhead{ChunkSize: 8, MaxPrefetch: 2},
+			expectedContent:  testData,
+			expectedRequests: 4,
+		},
+		"section": {
+			sectionOffset:    5,
+			sectionSize:      20,
+			readAhead:        ReadAhead{ChunkSize: 6, MaxPrefetch: 10},
+			expectedContent:  testData[5:25],
+			expectedRequests: 4,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var requests int64
+			testServer := newTestServer(t, func() { atomic.AddInt64(&requests, 1) })
+			defer testServer.Close()
+
+			resource, err := NewResource(context.Background(), testServer.URL+"/resource", testClient)
+			require.NoError(t, err)
+
+			atomic.StoreInt64(&requests, 0)
+
+			r := NewPrefetchReader(context.Background(), resource, int64(tt.sectionOffset), int64(tt.sectionSize), tt.readAhead)
+			defer r.Close()
+
+			// read with a buffer smaller than the chunks
+			content, err := io.ReadAll(io.LimitReader(r, int64(testDataLen)))
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedContent, string(content))
+			require.Equal(t, tt.expectedRequests, atomic.LoadInt64(&requests))
+		})
+	}
+}
+
+func TestPrefetchReaderFetchesAhead(t *testing.T) {
+	var requests int64
+	release := make(chan struct{})
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&requests, 1) > 1 && r.Header.Get("Range") != "bytes=0-9" {
+			<-release
+		}
+
+		http.ServeContent(w, r, r.URL.Path, time.Time{}, strings.NewReader(testData))
+	}))
+	defer testServer.Close()
+	defer close(release)
+
+	resource, err := NewResource(context.Background(), testServer.URL+"/resource", testClient)
+	require.NoError(t, err)
+
+	r := NewPrefetchReader(context.Background(), resource, 0, resource.Size, ReadAhead{ChunkSize: 10, MaxPrefetch: 2})
+	defer r.Close()
+
+	buf := make([]byte, 10)
+	n, err := r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, testData[:10], string(buf[:n]))
+
+	// the following chunks are requested while the first one is being read
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&requests) == 4
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestPrefetchReaderSeek(t *testing.T) {
+	testServer := newTestServer(t, nil)
+	defer testServer.Close()
+
+	resource, err := NewResource(context.Background(), testServer.URL+"/resource", testClient)
+	require.NoError(t, err)
+
+	r := NewPrefetchReader(context.Background(), resource, 0, resource.Size, ReadAhead{ChunkSize: 4, MaxPrefetch: 2})
+	defer r.Close()
+
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(r, buf)
+	require.NoError(t, err)
+	require.Equal(t, "123", string(buf))
+
+	offset, err := r.Seek(10, io.SeekStart)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), offset)
+
+	_, err = io.ReadFull(r, buf)
+	require.NoError(t, err)
+	require.Equal(t, "abc", string(buf))
+
+	offset, err = r.Seek(-5, io.SeekEnd)
+	require.NoError(t, err)
+	require.Equal(t, int64(testDataLen-5), offset)
+
+	rest, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "54321", string(rest))
+
+	_, err = r.Seek(1, io.SeekEnd)
+	require.EqualError(t, err, errSeekOutsideRange.Error())
+}
+
+func TestPrefetchReaderContextCanceled(t *testing.T) {
+	testServer := newTestServer(t, nil)
+	defer testServer.Close()
+
+	resource, err := NewResource(context.Background(), testServer.URL+"/resource", testClient)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	r := NewPrefetchReader(ctx, resource, 0, resource.Size, ReadAhead{ChunkSize: 10, MaxPrefetch: 1})
+	defer r.Close()
+
+	buf := make([]byte, resource.Size)
+	n, err := r.Read(buf)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "context canceled")
+	require.Zero(t, n)
+}
+
+func TestSectionReaderReadAhead(t *testing.T) {
+	testServer := newTestServer(t, nil)
+	defer testServer.Close()
+
+	resource, err := NewResource(context.Background(), testServer.URL+"/resource", testClient)
+	require.NoError(t, err)
+
+	rr := NewRangedReader(resource)
+	require.IsType(t, &Reader{}, rr.SectionReader(context.Background(), 0, resource.Size))
+
+	rr.ReadAhead = ReadAhead{ChunkSize: 10, MaxPrefetch: 1}
+	require.IsType(t, &PrefetchReader{}, rr.SectionReader(context.Background(), 0, resource.Size))
+	require.IsType(t, &Reader{}, rr.SectionReader(context.Background(), 0, 10), "sections fitting in a chunk are read directly")
+
+	rr.ReadAhead.MinSize = resource.Size + 1
+	require.IsType(t, &Reader{}, rr.SectionReader(context.Background(), 0, resource.Size), "sections smaller than MinSize are read directly")
+}
+
+func TestPrefetchReaderLimiter(t *testing.T) {
+	var inflight, maxInflight int64
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&inflight, 1)
+		defer atomic.AddInt64(&inflight, -1)
+
+		for {
+			max := atomic.LoadInt64(&maxInflight)
+			if n <= max || atomic.CompareAndSwapInt64(&maxInflight, max, n) {
+				break
+			}
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		http.ServeContent(w, r, r.URL.Path, time.Time{}, strings.NewReader(testData))
+	}))
+	defer testServer.Close()
+
+	resource, err := NewResource(context.Background(), testServer.URL+"/resource", testClient)
+	require.NoError(t, err)
+
+	readAhead := ReadAhead{ChunkSize: 3, MaxPrefetch: 5, Limiter: NewFetchLimiter(2)}
+
+	// concurrent readers share the limiter
+	contents := make([]string, 3)
+	var wg sync.WaitGroup
+
+	for i := range contents {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			r := NewPrefetchReader(context.Background(), resource, 0, resource.Size, readAhead)
+			defer r.Close()
+
+			content, _ := io.ReadAll(r)
+			contents[i] = string(content)
+		}(i)
+	}
+
+	wg.Wait()
+
+	for _, content := range contents {
+		require.Equal(t, testData, content)
+	}
+
+	require.LessOrEqual(t, atomic.LoadInt64(&maxInflight), int64(2))
+}
+
+func TestNewFetchLimiter(t *testing.T) {
+	require.Nil(t, NewFetchLimiter(0))
+	require.Nil(t, NewFetchLimiter(-1))
+
+	l := NewFetchLimiter(1)
+	require.NoError(t, l.acquire(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, l.acquire(ctx), context.Canceled)
+
+	l.release()
+	require.NoError(t, l.acquire(context.Background()))
+}
@@ -2,12 +2,33 @@ package httprange
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+var (
+	errNoRefresher     = errors.New("no path refresher")
+	errURLNotRefreshed = errors.New("the refreshed URL did not change")
+	errRefreshBackoff  = errors.New("the refresh of the URL failed recently")
+)
+
+const (
+	// minRefreshBackoff is the time a URL is not refreshed again after its
+	// refresh failed, it doubles with each failure up to maxRefreshBackoff
+	minRefreshBackoff = time.Second
+	maxRefreshBackoff = time.Minute
 )
 
 // Resource represents any HTTP resource that can be read by a GET operation.
@@ -20,6 +41,15 @@ type Resource struct {
 	url atomic.Value
 	err atomic.Value
 
+	// refreshMu serializes the refreshes of the URL
+	refreshMu sync.Mutex
+	// failedURL is the URL whose refresh failed last, it is not refreshed
+	// again before retryAt so the reads of an archive whose deployment
+	// changed do not all call the GitLab API
+	failedURL string
+	backoff   time.Duration
+	retryAt   time.Time
+
 	httpClient *http.Client
 }
 
@@ -38,6 +68,73 @@ func (r *Resource) SetURL(url string) {
 	r.url.Store(url)
 }
 
+// RefreshURL replaces the URL of the resource, which was denied access, with
+// the one returned by the vfs.PathRefresher of ctx. The URL is only refreshed
+// once when concurrent reads are denied access with the same URL. It is called
+// by the Reader, and by the reads which can not pass ctx to the Reader, like
+// the ones of archive/zip through RangedReader.ReadAt, once they returned
+// ErrAccessDenied. When the refresh of a URL fails, it is not refreshed again
+// for a backoff doubling with each failure.
+func (r *Resource) RefreshURL(ctx context.Context, deniedURL string) error {
+	refresh := vfs.PathRefresherFromContext(ctx)
+	if refresh == nil {
+		return errNoRefresher
+	}
+
+	r.refreshMu.Lock()
+	defer r.refreshMu.Unlock()
+
+	if r.URL() != deniedURL {
+		// refreshed by a concurrent read
+		return nil
+	}
+
+	if r.failedURL == deniedURL && time.Now().Before(r.retryAt) {
+		metrics.HTTPRangeURLRefreshes.WithLabelValues("backoff").Inc()
+		return errRefreshBackoff
+	}
+
+	url, err := refresh(ctx)
+	if err == nil && url == deniedURL {
+		err = errURLNotRefreshed
+	}
+
+	if err != nil {
+		r.refreshFailed(deniedURL)
+		metrics.HTTPRangeURLRefreshes.WithLabelValues("error").Inc()
+		log.ContextLogger(ctx).WithError(err).WithField("retry_in", r.backoff).Warn("failed to refresh the URL of the resource")
+		return err
+	}
+
+	metrics.HTTPRangeURLRefreshes.WithLabelValues("ok").Inc()
+	r.failedURL = ""
+	r.SetURL(url)
+
+	return nil
+}
+
+// refreshFailed doubles the backoff of url, which is reset for a new URL
+func (r *Resource) refreshFailed(url string) {
+	switch {
+	case r.failedURL != url:
+		r.backoff = minRefreshBackoff
+	case r.backoff < maxRefreshBackoff:
+		r.backoff *= 2
+		if r.backoff > maxRefreshBackoff {
+			r.backoff = maxRefreshBackoff
+		}
+	}
+
+	r.failedURL = url
+	r.retryAt = time.Now().Add(r.backoff)
+}
+
+// isAccessDenied returns true for the statuses of the responses to expired
+// pre-signed URLs
+func isAccessDenied(statusCode int) bool {
+	return statusCode == http.StatusForbidden || statusCode == http.StatusUnauthorized
+}
+
 func (r *Resource) Err() error {
 	err, _ := r.err.Load().(error)
 	return err
@@ -2,12 +2,16 @@ package httprange
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
 )
 
 func urlValue(url string) atomic.Value {
@@ -106,3 +110,90 @@ func TestNewResource(t *testing.T) {
 		})
 	}
 }
+
+func TestResourceRefreshURL(t *testing.T) {
+	tests := map[string]struct {
+		refresh         vfs.PathRefresher
+		deniedURL       string
+		expectedURL     string
+		expectedRefresh int
+		expectedErr     error
+	}{
+		"refreshed": {
+			refresh:         func(context.Context) (string, error) { return "/new", nil },
+			deniedURL:       "/expired",
+			expectedURL:     "/new",
+			expectedRefresh: 1,
+		},
+		"refreshed_by_a_concurrent_read": {
+			refresh:     func(context.Context) (string, error) { return "/new", nil },
+			deniedURL:   "/older",
+			expectedURL: "/expired",
+		},
+		"no_refresher": {
+			deniedURL:   "/expired",
+			expectedURL: "/expired",
+			expectedErr: errNoRefresher,
+		},
+		"not_changed": {
+			refresh:         func(context.Context) (string, error) { return "/expired", nil },
+			deniedURL:       "/expired",
+			expectedURL:     "/expired",
+			expectedRefresh: 1,
+			expectedErr:     errURLNotRefreshed,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			resource := &Resource{url: urlValue("/expired")}
+
+			ctx := context.Background()
+			refreshes := 0
+			if tt.refresh != nil {
+				ctx = vfs.WithPathRefresher(ctx, func(ctx context.Context) (string, error) {
+					refreshes++
+					return tt.refresh(ctx)
+				})
+			}
+
+			err := resource.RefreshURL(ctx, tt.deniedURL)
+			require.ErrorIs(t, err, tt.expectedErr)
+			require.Equal(t, tt.expectedURL, resource.URL())
+			require.Equal(t, tt.expectedRefresh, refreshes)
+		})
+	}
+}
+
+func TestResourceRefreshURLBackoff(t *testing.T) {
+	refreshErr := errors.New("deployment changed")
+	refreshes := 0
+	ctx := vfs.WithPathRefresher(context.Background(), func(context.Context) (string, error) {
+		refreshes++
+		return "", refreshErr
+	})
+
+	resource := &Resource{url: urlValue("/expired")}
+
+	require.ErrorIs(t, resource.RefreshURL(ctx, "/expired"), refreshErr)
+	require.Equal(t, 1, refreshes)
+	require.Equal(t, minRefreshBackoff, resource.backoff)
+
+	require.ErrorIs(t, resource.RefreshURL(ctx, "/expired"), errRefreshBackoff)
+	require.Equal(t, 1, refreshes, "the URL is not refreshed again during the backoff")
+
+	resource.retryAt = time.Now()
+	require.ErrorIs(t, resource.RefreshURL(ctx, "/expired"), refreshErr)
+	require.Equal(t, 2, refreshes)
+	require.Equal(t, 2*minRefreshBackoff, resource.backoff, "the backoff doubles with each failure")
+
+	resource.backoff = maxRefreshBackoff
+	resource.retryAt = time.Now()
+	require.ErrorIs(t, resource.RefreshURL(ctx, "/expired"), refreshErr)
+	require.Equal(t, maxRefreshBackoff, resource.backoff)
+
+	resource.SetURL("/other")
+	require.ErrorIs(t, resource.RefreshURL(ctx, "/other"), refreshErr)
+	require.Equal(t, 4, refreshes, "other URLs are refreshed right away")
+	require.Equal(t, minRefreshBackoff, resource.backoff)
+}
@@ -0,0 +1,76 @@
+package httptransport
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// routes of the requests reported by InstrumentProxy
+const (
+	routeDirect  = "direct"
+	routeProxied = "proxied"
+)
+
+// NewProxyFunc returns the function selecting the proxy of the requests of a
+// transport. Requests are sent through proxyURL, except the ones to the hosts
+// listed by the NO_PROXY environment variable. When proxyURL is empty, the
+// HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables are used.
+func NewProxyFunc(proxyURL string) (func(*http.Request) (*url.URL, error), error) {
+	if proxyURL == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+
+	proxy := (&httpproxy.Config{
+		HTTPProxy:  proxyURL,
+		HTTPSProxy: proxyURL,
+		NoProxy:    getEnvAny("NO_PROXY", "no_proxy"),
+	}).ProxyFunc()
+
+	return func(r *http.Request) (*url.URL, error) {
+		return proxy(r.URL)
+	}, nil
+}
+
+// InstrumentProxy counts the requests sent directly or through a proxy by
+// proxy, counter must have a single route label
+func InstrumentProxy(proxy func(*http.Request) (*url.URL, error), counter *prometheus.CounterVec) func(*http.Request) (*url.URL, error) {
+	return func(r *http.Request) (*url.URL, error) {
+		u, err := proxy(r)
+		if err == nil {
+			route := routeDirect
+			if u != nil {
+				route = routeProxied
+			}
+
+			counter.WithLabelValues(route).Inc()
+		}
+
+		return u, err
+	}
+}
+
+func getEnvAny(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
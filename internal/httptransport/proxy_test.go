@@ -0,0 +1,87 @@
+package httptransport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewProxyFunc(t *testing.T) {
+	previous, ok := os.LookupEnv("NO_PROXY")
+	require.NoError(t, os.Setenv("NO_PROXY", "internal.example.com"))
+	defer func() {
+		if ok {
+			os.Setenv("NO_PROXY", previous)
+		} else {
+			os.Unsetenv("NO_PROXY")
+		}
+	}()
+
+	tests := map[string]struct {
+		proxyURL      string
+		url           string
+		expectedProxy string
+		expectedErr   bool
+	}{
+		"proxied": {
+			proxyURL:      "http://proxy.example.com:3128",
+			url:           "https://storage.example.com/archive.zip",
+			expectedProxy: "http://proxy.example.com:3128",
+		},
+		"socks5_proxy": {
+			proxyURL:      "socks5://proxy.example.com:1080",
+			url:           "https://storage.example.com/archive.zip",
+			expectedProxy: "socks5://proxy.example.com:1080",
+		},
+		"no_proxy_host": {
+			proxyURL: "http://proxy.example.com:3128",
+			url:      "https://internal.example.com/archive.zip",
+		},
+		"unsupported_scheme": {
+			proxyURL:    "ftp://proxy.example.com",
+			expectedErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proxy, err := NewProxyFunc(tt.proxyURL)
+			if tt.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			u, err := proxy(httptest.NewRequest(http.MethodGet, tt.url, nil))
+			require.NoError(t, err)
+
+			if tt.expectedProxy == "" {
+				require.Nil(t, u)
+				return
+			}
+
+			require.Equal(t, tt.expectedProxy, u.String())
+		})
+	}
+}
+
+func TestInstrumentProxy(t *testing.T) {
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "proxy_requests"}, []string{"route"})
+
+	proxy, err := NewProxyFunc("http://proxy.example.com:3128")
+	require.NoError(t, err)
+	proxy = InstrumentProxy(proxy, counter)
+
+	for _, url := range []string{"https://storage.example.com/archive.zip", "http://127.0.0.1/archive.zip"} {
+		_, err := proxy(httptest.NewRequest(http.MethodGet, url, nil))
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, float64(1), testutil.ToFloat64(counter.WithLabelValues(routeProxied)))
+	require.Equal(t, float64(1), testutil.ToFloat64(counter.WithLabelValues(routeDirect)), "loopback requests are not proxied")
+}
@@ -1,8 +1,10 @@
 package httptransport
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"net"
 	"net/http"
 	"sync"
@@ -19,11 +21,15 @@ const (
 )
 
 var (
+	// ErrInvalidCACertificates is returned when CA certificates are not PEM
+	// encoded
+	ErrInvalidCACertificates = errors.New("no PEM encoded certificates found")
+
 	sysPoolOnce = &sync.Once{}
 	sysPool     *x509.CertPool
 
 	// only overridden by transport_darwin.go
-	loadExtraCerts = func() {}
+	loadExtraCerts = func(*x509.CertPool) {}
 	// DefaultTransport can be used with http.Client with TLS and certificates
 	DefaultTransport = NewTransport()
 )
@@ -53,6 +59,57 @@ func NewTransport() *http.Transport {
 	}
 }
 
+// NewTLSConfig returns a TLS client configuration trusting the system
+// certificate pool and the PEM encoded certificates of caCerts, e.g. the CA of
+// a private object storage endpoint. insecureSkipVerify disables the
+// verification of the server certificates and must only be used for testing.
+func NewTLSConfig(caCerts []byte, insecureSkipVerify bool) (*tls.Config, error) {
+	cfg := &tls.Config{
+		RootCAs:            pool(),
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+
+	if len(caCerts) == 0 {
+		return cfg, nil
+	}
+
+	// the shared pool must not be modified
+	rootCAs, err := newSystemPool()
+	if err != nil {
+		log.WithError(err).Error("failed to load system cert pool for custom CA certificates")
+		rootCAs = x509.NewCertPool()
+	}
+
+	cfg.RootCAs = rootCAs
+
+	if !cfg.RootCAs.AppendCertsFromPEM(caCerts) {
+		return nil, ErrInvalidCACertificates
+	}
+
+	return cfg, nil
+}
+
+// NewTransportWithDialContext initializes an http.Transport like NewTransport
+// which opens all the connections, including TLS ones, with dial. The TLS
+// connections use tlsConfig, or trust the system certificate pool when nil.
+func NewTransportWithDialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error), tlsConfig *tls.Config) *http.Transport {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{RootCAs: pool(), MinVersion: tls.VersionTLS12}
+	}
+
+	t := NewTransport()
+	// the transport wraps the connections of dial with TLS itself, so the
+	// handshakes are bounded by TLSHandshakeTimeout and negotiate HTTP/2
+	t.DialTLS = nil
+	t.DialContext = dial
+	// the transport adds the HTTP/2 protocols to its TLS configuration
+	t.TLSClientConfig = tlsConfig.Clone()
+	t.ForceAttemptHTTP2 = true
+
+	return t
+}
+
 // This is here because macOS does not support the SSL_CERT_FILE and
 // SSL_CERT_DIR environment variables. We have arranged things to read
 // SSL_CERT_FILE and SSL_CERT_DIR  as late as possible to avoid conflicts
@@ -66,13 +123,22 @@ func loadPool() {
 	var err error
 
 	// Always load the system cert pool
-	sysPool, err = x509.SystemCertPool()
+	sysPool, err = newSystemPool()
 	if err != nil {
 		log.WithError(err).Error("failed to load system cert pool for http client")
-		return
+	}
+}
+
+// newSystemPool returns a new pool of the system certificates
+func newSystemPool() (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		return nil, err
 	}
 
 	// Go does not load SSL_CERT_FILE and SSL_CERT_DIR on darwin systems so we need to
 	// load them manually in OSX. See https://golang.org/src/crypto/x509/root_unix.go
-	loadExtraCerts()
+	loadExtraCerts(pool)
+
+	return pool, nil
 }
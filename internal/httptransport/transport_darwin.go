@@ -6,6 +6,7 @@
 package httptransport
 
 import (
+	"crypto/x509"
 	"os"
 	"path/filepath"
 	"strings"
@@ -27,18 +28,18 @@ const (
 
 func init() {
 	// override and load SSL_CERT_FILE and SSL_CERT_DIR in OSX.
-	loadExtraCerts = func() {
-		if err := loadCertFile(); err != nil {
+	loadExtraCerts = func(pool *x509.CertPool) {
+		if err := loadCertFile(pool); err != nil {
 			log.WithError(err).Error("failed to read SSL_CERT_FILE")
 		}
 
-		if err := loadCertDir(); err != nil {
+		if err := loadCertDir(pool); err != nil {
 			log.WithError(err).Error("failed to load SSL_CERT_DIR")
 		}
 	}
 }
 
-func loadCertFile() error {
+func loadCertFile(pool *x509.CertPool) error {
 	sslCertFile := os.Getenv(certFileEnv)
 	if sslCertFile == "" {
 		return nil
@@ -49,12 +50,12 @@ func loadCertFile() error {
 		return err
 	}
 
-	sysPool.AppendCertsFromPEM(data)
+	pool.AppendCertsFromPEM(data)
 
 	return nil
 }
 
-func loadCertDir() error {
+func loadCertDir(pool *x509.CertPool) error {
 	var firstErr error
 	var dirs []string
 	if d := os.Getenv(certDirEnv); d != "" {
@@ -77,7 +78,7 @@ func loadCertDir() error {
 		rootsAdded := false
 		for _, fi := range fis {
 			data, err := os.ReadFile(directory + "/" + fi.Name())
-			if err == nil && sysPool.AppendCertsFromPEM(data) {
+			if err == nil && pool.AppendCertsFromPEM(data) {
 				rootsAdded = true
 			}
 		}
@@ -2,8 +2,10 @@ package httptransport
 
 import (
 	"context"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
@@ -133,3 +135,57 @@ func TestInternalTransportShouldHaveCustomConnectionPoolSettings(t *testing.T) {
 	require.EqualValues(t, 15*time.Second, DefaultTransport.ResponseHeaderTimeout)
 	require.EqualValues(t, 15*time.Second, DefaultTransport.ExpectContinueTimeout)
 }
+
+func TestNewTransportWithDialContextTLSConfig(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	tests := map[string]struct {
+		caCerts            []byte
+		insecureSkipVerify bool
+		expectedErr        string
+	}{
+		"system_pool": {
+			expectedErr: "certificate signed by unknown authority",
+		},
+		"ca_certificates": {
+			caCerts: caCert,
+		},
+		"insecure_skip_verify": {
+			insecureSkipVerify: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tlsConfig, err := NewTLSConfig(tt.caCerts, tt.insecureSkipVerify)
+			require.NoError(t, err)
+
+			dialer := &net.Dialer{}
+			client := &http.Client{Transport: NewTransportWithDialContext(dialer.DialContext, tlsConfig)}
+
+			res, err := client.Get(server.URL)
+			if tt.expectedErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tt.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			defer res.Body.Close()
+			require.Equal(t, http.StatusNoContent, res.StatusCode)
+			require.Equal(t, 2, res.ProtoMajor, "HTTP/2 is negotiated")
+		})
+	}
+}
+
+func TestNewTLSConfigInvalidCACertificates(t *testing.T) {
+	_, err := NewTLSConfig([]byte("not a certificate"), false)
+	require.ErrorIs(t, err, ErrInvalidCACertificates)
+}
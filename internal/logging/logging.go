@@ -2,12 +2,14 @@ package logging
 
 import (
 	"net/http"
+	"strconv"
 
 	"github.com/sirupsen/logrus"
 	"gitlab.com/gitlab-org/labkit/correlation"
 	"gitlab.com/gitlab-org/labkit/log"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/request"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
 // ConfigureLogging will initialize the system logger.
@@ -58,27 +60,50 @@ func BasicAccessLogger(handler http.Handler, format string, extraFields log.Extr
 		return nil, err
 	}
 
-	return log.AccessLogger(handler,
+	logged := log.AccessLogger(handler,
 		log.WithExtraFields(enrichExtraFields(extraFields)),
 		log.WithAccessLogger(accessLogger),
 		log.WithXFFAllowed(func(sip string) bool { return false }),
-	), nil
+	)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w, r, resp := TrackResponse(w, r)
+
+		logged.ServeHTTP(w, r)
+
+		if incomplete, clientDisconnect := resp.incomplete(r); incomplete {
+			metrics.IncompleteResponses.WithLabelValues(strconv.FormatBool(clientDisconnect)).Inc()
+		}
+	}), nil
 }
 
 func enrichExtraFields(extraFields log.ExtraFieldsGeneratorFunc) log.ExtraFieldsGeneratorFunc {
 	return func(r *http.Request) log.Fields {
-		enrichedFields := log.Fields{
-			"correlation_id": correlation.ExtractFromContext(r.Context()),
-			"pages_https":    request.IsHTTPS(r),
-			"pages_host":     r.Host,
+		var fields log.Fields
+		if extraFields != nil {
+			fields = extraFields(r)
 		}
 
-		if extraFields != nil {
-			for field, value := range extraFields(r) {
-				enrichedFields[field] = value
+		// sized for all the fields to not grow the map for every request
+		enrichedFields := make(log.Fields, len(fields)+3)
+		enrichedFields["correlation_id"] = correlation.ExtractFromContext(r.Context())
+		enrichedFields["pages_https"] = request.IsHTTPS(r)
+		enrichedFields["pages_host"] = r.Host
+
+		if resp := ResponseFromRequest(r); resp != nil {
+			if incomplete, clientDisconnect := resp.incomplete(r); incomplete {
+				enrichedFields["client_disconnect"] = clientDisconnect
+			}
+
+			if resp.err != nil {
+				enrichedFields["write_error"] = resp.err.Error()
 			}
 		}
 
+		for field, value := range fields {
+			enrichedFields[field] = value
+		}
+
 		return enrichedFields
 	}
 }
@@ -1,14 +1,22 @@
 package logging
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"syscall"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/require"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/request"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
 type resolver struct {
@@ -85,3 +93,72 @@ func TestGetExtraLogFields(t *testing.T) {
 		})
 	}
 }
+
+// failingWriter fails every write with err
+type failingWriter struct {
+	http.ResponseWriter
+	err error
+}
+
+func (w *failingWriter) Write([]byte) (int, error) {
+	return 0, w.err
+}
+
+func TestBasicAccessLoggerWriteErrors(t *testing.T) {
+	tests := map[string]struct {
+		writeErr                 error
+		expectedClientDisconnect interface{}
+		expectedWriteError       interface{}
+	}{
+		"no_error": {},
+		"broken_pipe": {
+			writeErr:                 fmt.Errorf("write: %w", syscall.EPIPE),
+			expectedClientDisconnect: true,
+			expectedWriteError:       "write: broken pipe",
+		},
+		"server_error": {
+			writeErr:                 errors.New("write timeout"),
+			expectedClientDisconnect: false,
+			expectedWriteError:       "write timeout",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			hook := test.NewGlobal()
+			defer hook.Reset()
+
+			handler, err := BasicAccessLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("content"))
+			}), "json", nil)
+			require.NoError(t, err)
+
+			clientDisconnects := testutil.ToFloat64(metrics.IncompleteResponses.WithLabelValues("true"))
+			serverErrors := testutil.ToFloat64(metrics.IncompleteResponses.WithLabelValues("false"))
+
+			w := &failingWriter{ResponseWriter: httptest.NewRecorder(), err: tt.writeErr}
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+			var entry *logrus.Entry
+			for _, e := range hook.AllEntries() {
+				if e.Message == "access" {
+					entry = e
+				}
+			}
+
+			require.NotNil(t, entry)
+			require.Equal(t, tt.expectedClientDisconnect, entry.Data["client_disconnect"])
+			require.Equal(t, tt.expectedWriteError, entry.Data["write_error"])
+
+			expectedClientDisconnects, expectedServerErrors := clientDisconnects, serverErrors
+			if tt.expectedClientDisconnect == true {
+				expectedClientDisconnects++
+			} else if tt.expectedClientDisconnect == false {
+				expectedServerErrors++
+			}
+
+			require.Equal(t, expectedClientDisconnects, testutil.ToFloat64(metrics.IncompleteResponses.WithLabelValues("true")))
+			require.Equal(t, expectedServerErrors, testutil.ToFloat64(metrics.IncompleteResponses.WithLabelValues("false")))
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package logging
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/request"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+// backendNone is the backend of the responses which were not served from a
+// project or proxied, e.g. redirects and error pages of unknown domains
+const backendNone = "none"
+
+type responseKey struct{}
+
+// Response is the status code, the number of bytes written and the first
+// write error of the response to a request, tracked while it is served so
+// every middleware can use them, e.g. for logging, rate limiting or analytics
+type Response struct {
+	status      int
+	wroteHeader bool
+	bytes       int64
+	err         error
+	backend     string
+}
+
+// Status returns the status code of the response, http.StatusOK when it was
+// not written yet
+func (resp *Response) Status() int {
+	if !resp.wroteHeader {
+		return http.StatusOK
+	}
+
+	return resp.status
+}
+
+// BytesWritten returns the number of bytes of the response body written so far
+func (resp *Response) BytesWritten() int64 {
+	return resp.bytes
+}
+
+// WriteError returns the first error writing the response, e.g. broken pipes
+// when clients close the connection
+func (resp *Response) WriteError() error {
+	return resp.err
+}
+
+// incomplete returns whether the response was not sent completely and if so,
+// whether it is because the client disconnected rather than a server error
+func (resp *Response) incomplete(r *http.Request) (incomplete bool, clientDisconnect bool) {
+	clientDisconnect = request.IsClientDisconnect(r, resp.err)
+
+	return resp.err != nil || clientDisconnect, clientDisconnect
+}
+
+// TrackResponse returns w and r tracking the response, which is returned too.
+// When the response is already tracked by an outer middleware, w and r are
+// returned unchanged with the tracked response.
+func TrackResponse(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request, *Response) {
+	if resp := ResponseFromRequest(r); resp != nil {
+		return w, r, resp
+	}
+
+	resp := &Response{}
+	r = r.WithContext(context.WithValue(r.Context(), responseKey{}, resp))
+
+	return &responseWriter{ResponseWriter: w, resp: resp}, r, resp
+}
+
+// ResponseFromRequest returns the response tracked for r, it returns nil when
+// the response is not tracked
+func ResponseFromRequest(r *http.Request) *Response {
+	resp, _ := r.Context().Value(responseKey{}).(*Response)
+
+	return resp
+}
+
+// SetBackend records the backend serving r, e.g. artifacts, which replaces
+// the backend of the project reported by NewBytesServedMiddleware
+func SetBackend(r *http.Request, backend string) {
+	if resp := ResponseFromRequest(r); resp != nil {
+		resp.backend = backend
+	}
+}
+
+// NewBytesServedMiddleware returns middleware tracking the responses and
+// counting the bytes served by backend and status class. backend returns the
+// backend serving the request when it was not set with SetBackend, or an
+// empty string for the responses which were not served by a backend.
+func NewBytesServedMiddleware(handler http.Handler, backend func(*http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w, r, resp := TrackResponse(w, r)
+
+		handler.ServeHTTP(w, r)
+
+		name := resp.backend
+		if name == "" {
+			name = backend(r)
+		}
+
+		if name == "" {
+			name = backendNone
+		}
+
+		metrics.BytesServed.WithLabelValues(name, statusClass(resp.Status())).Add(float64(resp.bytes))
+	})
+}
+
+// statusClass returns the class of status, e.g. 2xx
+func statusClass(status int) string {
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// responseWriter records the status code, the bytes written and the first
+// write error of the response in resp
+type responseWriter struct {
+	http.ResponseWriter
+	resp *Response
+}
+
+func (w *responseWriter) WriteHeader(statusCode int) {
+	if !w.resp.wroteHeader {
+		w.resp.wroteHeader = true
+		w.resp.status = statusCode
+	}
+
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *responseWriter) Write(data []byte) (int, error) {
+	if !w.resp.wroteHeader {
+		w.resp.wroteHeader = true
+		w.resp.status = http.StatusOK
+	}
+
+	n, err := w.ResponseWriter.Write(data)
+	w.resp.bytes += int64(n)
+
+	if err != nil && w.resp.err == nil {
+		w.resp.err = err
+	}
+
+	return n, err
+}
+
+// Unwrap returns the http.ResponseWriter whose response is logged
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
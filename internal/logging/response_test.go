@@ -0,0 +1,90 @@
+package logging
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+func TestTrackResponse(t *testing.T) {
+	var inner *Response
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var resp *Response
+
+		w, r, resp = TrackResponse(w, r)
+		inner = resp
+
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("not "))
+		w.Write([]byte("found"))
+
+		require.Same(t, resp, ResponseFromRequest(r))
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	require.Nil(t, ResponseFromRequest(r))
+
+	w, r, resp := TrackResponse(httptest.NewRecorder(), r)
+	require.Equal(t, http.StatusOK, resp.Status(), "status of responses not written yet")
+
+	handler.ServeHTTP(w, r)
+
+	require.Same(t, resp, inner, "the response tracked by outer middleware is reused")
+	require.Equal(t, http.StatusNotFound, resp.Status())
+	require.Equal(t, int64(len("not found")), resp.BytesWritten())
+	require.NoError(t, resp.WriteError())
+}
+
+func TestNewBytesServedMiddleware(t *testing.T) {
+	tests := map[string]struct {
+		backend         string
+		setBackend      string
+		status          int
+		expectedBackend string
+		expectedClass   string
+	}{
+		"project": {
+			backend:         "zip",
+			status:          http.StatusOK,
+			expectedBackend: "zip",
+			expectedClass:   "2xx",
+		},
+		"set_backend": {
+			backend:         "zip",
+			setBackend:      "artifacts",
+			status:          http.StatusBadGateway,
+			expectedBackend: "artifacts",
+			expectedClass:   "5xx",
+		},
+		"no_backend": {
+			status:          http.StatusNotFound,
+			expectedBackend: "none",
+			expectedClass:   "4xx",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			handler := NewBytesServedMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.setBackend != "" {
+					SetBackend(r, tt.setBackend)
+				}
+
+				w.WriteHeader(tt.status)
+				w.Write([]byte("content"))
+			}), func(*http.Request) string { return tt.backend })
+
+			before := testutil.ToFloat64(metrics.BytesServed.WithLabelValues(tt.expectedBackend, tt.expectedClass))
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			require.Equal(t, before+float64(len("content")), testutil.ToFloat64(metrics.BytesServed.WithLabelValues(tt.expectedBackend, tt.expectedClass)))
+		})
+	}
+}
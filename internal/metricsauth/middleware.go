@@ -0,0 +1,69 @@
+// Package metricsauth restricts the access to the metrics listener, as
+// metrics expose information about the domains served by Pages
+package metricsauth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/forwarded"
+)
+
+const realm = `Basic realm="GitLab Pages metrics"`
+
+// NewMiddleware returns middleware which rejects the requests from clients
+// outside of the allowed IPs with a 403 Forbidden response, and the requests
+// without the bearer token or basic auth credentials, when any of them is
+// configured, with a 401 Unauthorized response
+func NewMiddleware(handler http.Handler, cfg *config.Metrics) (http.Handler, error) {
+	allowed, err := forwarded.NewProxies(cfg.AllowedIPs)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(allowed) > 0 && !allowed.Trusts(r) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		if cfg.AuthEnabled() && !authenticated(r, cfg) {
+			if cfg.Username != "" {
+				w.Header().Set("WWW-Authenticate", realm)
+			} else {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+			}
+
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	}), nil
+}
+
+func authenticated(r *http.Request, cfg *config.Metrics) bool {
+	if len(cfg.Token) > 0 {
+		auth := r.Header.Get("Authorization")
+		if token := strings.TrimPrefix(auth, "Bearer "); token != auth && equal([]byte(token), cfg.Token) {
+			return true
+		}
+	}
+
+	if cfg.Username != "" {
+		username, password, ok := r.BasicAuth()
+		// evaluate both to not leak which one is wrong through timing
+		validUsername := equal([]byte(username), []byte(cfg.Username))
+		validPassword := equal([]byte(password), cfg.Password)
+
+		return ok && validUsername && validPassword
+	}
+
+	return false
+}
+
+func equal(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
@@ -0,0 +1,108 @@
+package metricsauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+)
+
+func TestNewMiddleware(t *testing.T) {
+	tests := map[string]struct {
+		cfg                     config.Metrics
+		remoteAddr              string
+		token                   string
+		username                string
+		password                string
+		expectedStatus          int
+		expectedWWWAuthenticate string
+	}{
+		"no_protection": {
+			expectedStatus: http.StatusOK,
+		},
+		"allowed_ip": {
+			cfg:            config.Metrics{AllowedIPs: []string{"10.0.0.0/8"}},
+			remoteAddr:     "10.1.2.3:1234",
+			expectedStatus: http.StatusOK,
+		},
+		"not_allowed_ip": {
+			cfg:            config.Metrics{AllowedIPs: []string{"10.0.0.0/8", "127.0.0.1"}},
+			remoteAddr:     "192.168.1.1:1234",
+			expectedStatus: http.StatusForbidden,
+		},
+		"valid_token": {
+			cfg:            config.Metrics{Token: []byte("secret")},
+			token:          "secret",
+			expectedStatus: http.StatusOK,
+		},
+		"invalid_token": {
+			cfg:                     config.Metrics{Token: []byte("secret")},
+			token:                   "guess",
+			expectedStatus:          http.StatusUnauthorized,
+			expectedWWWAuthenticate: "Bearer",
+		},
+		"missing_token": {
+			cfg:                     config.Metrics{Token: []byte("secret")},
+			expectedStatus:          http.StatusUnauthorized,
+			expectedWWWAuthenticate: "Bearer",
+		},
+		"valid_basic_auth": {
+			cfg:            config.Metrics{Username: "prometheus", Password: []byte("secret")},
+			username:       "prometheus",
+			password:       "secret",
+			expectedStatus: http.StatusOK,
+		},
+		"invalid_basic_auth": {
+			cfg:                     config.Metrics{Username: "prometheus", Password: []byte("secret")},
+			username:                "prometheus",
+			password:                "guess",
+			expectedStatus:          http.StatusUnauthorized,
+			expectedWWWAuthenticate: realm,
+		},
+		"token_or_basic_auth": {
+			cfg:            config.Metrics{Token: []byte("token"), Username: "prometheus", Password: []byte("secret")},
+			token:          "token",
+			expectedStatus: http.StatusOK,
+		},
+		"valid_token_from_not_allowed_ip": {
+			cfg:            config.Metrics{Token: []byte("secret"), AllowedIPs: []string{"10.0.0.0/8"}},
+			remoteAddr:     "192.168.1.1:1234",
+			token:          "secret",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			handler, err := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("metrics"))
+			}), &tt.cfg)
+			require.NoError(t, err)
+
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.remoteAddr != "" {
+				r.RemoteAddr = tt.remoteAddr
+			}
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.username != "" {
+				r.SetBasicAuth(tt.username, tt.password)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			require.Equal(t, tt.expectedStatus, w.Code)
+			require.Equal(t, tt.expectedWWWAuthenticate, w.Header().Get("WWW-Authenticate"))
+		})
+	}
+}
+
+func TestNewMiddlewareInvalidAllowedIPs(t *testing.T) {
+	_, err := NewMiddleware(http.NotFoundHandler(), &config.Metrics{AllowedIPs: []string{"not an ip"}})
+	require.Error(t, err)
+}
@@ -0,0 +1,483 @@
+// Package microcache caches small HTML responses in memory for a few seconds,
+// so spikes of requests to a single page are served without reading it from
+// disk or object storage for each of them
+package microcache
+
+import (
+	"bytes"
+	"context"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/karlseguin/ccache/v2"
+
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+// results of the requests reported by metrics.MicroCacheRequests
+const (
+	resultHit    = "hit"
+	resultMiss   = "miss"
+	resultBypass = "bypass"
+	resultStale  = "stale"
+)
+
+type withoutCacheKey struct{}
+
+// staleWarning is the Warning header of the stale responses served when
+// serving the request again failed
+const staleWarning = `111 - "Revalidation Failed"`
+
+// entry is a cached response, which is fresh until expires and can be served
+// stale after that when serving the request again fails
+type entry struct {
+	status  int
+	header  http.Header
+	body    []byte
+	stored  time.Time
+	expires time.Time
+}
+
+// Cache stores the responses of at most maxEntries requests for ttl, when the
+// responses are HTML and their body is at most maxSize bytes long. Expired
+// responses are kept for staleIfError more, and served when serving the
+// request again fails with a server error, e.g. while object storage is down.
+type Cache struct {
+	ttl          time.Duration
+	staleIfError time.Duration
+	maxSize      int64
+	cache        *ccache.Cache
+
+	mu       sync.Mutex
+	inflight map[string]chan struct{}
+	now      func() time.Time
+}
+
+// New returns a cache of the responses, it returns nil when ttl is 0
+func New(ttl, staleIfError time.Duration, maxSize, maxEntries int64) *Cache {
+	if ttl <= 0 {
+		return nil
+	}
+
+	return &Cache{
+		ttl:          ttl,
+		staleIfError: staleIfError,
+		maxSize:      maxSize,
+		cache:        ccache.New(ccache.Configure().MaxSize(maxEntries).ItemsToPrune(uint32(maxEntries/16) + 1)),
+		inflight:     make(map[string]chan struct{}),
+		now:          time.Now,
+	}
+}
+
+// NewMiddleware returns middleware serving the cached responses from c, and
+// caching the responses of handler which can be. It returns handler when c is
+// nil.
+func NewMiddleware(handler http.Handler, c *Cache) http.Handler {
+	if c == nil {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cacheableRequest(r) {
+			metrics.MicroCacheRequests.WithLabelValues(resultBypass).Inc()
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		key := cacheKey(r)
+
+		stale, fresh := c.get(key)
+		if fresh {
+			c.serveEntry(w, r, stale, resultHit)
+			return
+		}
+
+		// HEAD requests are served from the responses to GET requests only
+		if r.Method != http.MethodGet {
+			metrics.MicroCacheRequests.WithLabelValues(resultMiss).Inc()
+			c.serveOrStale(w, r, handler, stale)
+			return
+		}
+
+		done, leader := c.lock(key)
+		if !leader {
+			// wait for the request serving the same page to cache it
+			select {
+			case <-done:
+			case <-r.Context().Done():
+				return
+			}
+
+			if e, fresh := c.get(key); fresh {
+				c.serveEntry(w, r, e, resultHit)
+				return
+			}
+
+			metrics.MicroCacheRequests.WithLabelValues(resultMiss).Inc()
+			c.serveOrStale(w, r, handler, stale)
+			return
+		}
+
+		// the waiting requests are released as soon as the response is known
+		// not to be cacheable, rather than once it was sent
+		var once sync.Once
+		release := func() { once.Do(func() { c.unlock(key) }) }
+		defer release()
+
+		metrics.MicroCacheRequests.WithLabelValues(resultMiss).Inc()
+
+		rw := c.newRecordingWriter(w, stale, release)
+		handler.ServeHTTP(rw, r)
+
+		if rw.failed {
+			c.serveStale(w, r, rw.before, stale)
+			return
+		}
+
+		if rw.cacheFor > 0 {
+			now := c.now()
+
+			c.cache.Set(key, &entry{
+				status:  rw.status,
+				header:  rw.header,
+				body:    rw.body.Bytes(),
+				stored:  now,
+				expires: now.Add(rw.cacheFor),
+			}, rw.cacheFor+c.staleIfError)
+		}
+	})
+}
+
+// NewStaleIfErrorMiddleware returns middleware serving the stale responses of
+// c when handler fails with a server error before reaching the middleware of
+// NewMiddleware, e.g. when the domain of the request cannot be looked up. It
+// returns handler when c is nil or does not keep stale responses.
+func NewStaleIfErrorMiddleware(handler http.Handler, c *Cache) http.Handler {
+	if c == nil || c.staleIfError <= 0 {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cacheableRequest(r) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		stale, _ := c.get(cacheKey(r))
+		c.serveOrStale(w, r, handler, stale)
+	})
+}
+
+// WithoutCache returns a copy of r whose response is neither served from nor
+// stored in the cache, e.g. the response of a project only some clients are
+// allowed to view
+func WithoutCache(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), withoutCacheKey{}, true))
+}
+
+// newRecordingWriter returns a writer recording the response written to w,
+// which discards server errors when the stale entry can be served instead
+func (c *Cache) newRecordingWriter(w http.ResponseWriter, stale *entry, release func()) *recordingWriter {
+	return &recordingWriter{
+		ResponseWriter: w,
+		before:         w.Header().Clone(),
+		maxSize:        c.maxSize,
+		ttl:            c.responseTTL,
+		release:        release,
+		stale:          stale,
+	}
+}
+
+// serveOrStale serves the request with handler without caching the response,
+// or the stale entry when handler fails with a server error
+func (c *Cache) serveOrStale(w http.ResponseWriter, r *http.Request, handler http.Handler, stale *entry) {
+	if stale == nil {
+		handler.ServeHTTP(w, r)
+		return
+	}
+
+	rw := c.newRecordingWriter(w, stale, func() {})
+	rw.ttl = func(int, http.Header) time.Duration { return 0 }
+	handler.ServeHTTP(rw, r)
+
+	if rw.failed {
+		c.serveStale(w, r, rw.before, stale)
+	}
+}
+
+// serveStale serves the stale entry instead of a server error, the headers
+// set by the handler which failed are removed
+func (c *Cache) serveStale(w http.ResponseWriter, r *http.Request, before http.Header, stale *entry) {
+	h := w.Header()
+	for name := range h {
+		delete(h, name)
+	}
+
+	for name, values := range before {
+		h[name] = values
+	}
+
+	h.Set("Warning", staleWarning)
+	c.serveEntry(w, r, stale, resultStale)
+}
+
+// lock returns a channel closed once the request serving key is done, and
+// whether the caller is the one serving it
+func (c *Cache) lock(key string) (chan struct{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if done, ok := c.inflight[key]; ok {
+		return done, false
+	}
+
+	done := make(chan struct{})
+	c.inflight[key] = done
+
+	return done, true
+}
+
+func (c *Cache) unlock(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	close(c.inflight[key])
+	delete(c.inflight, key)
+}
+
+// get returns the entry cached for key, if any, and whether it is fresh.
+// Stale entries are returned while they can be served on errors.
+func (c *Cache) get(key string) (*entry, bool) {
+	item := c.cache.Get(key)
+	if item == nil || item.Expired() {
+		return nil, false
+	}
+
+	e := item.Value().(*entry)
+
+	now := c.now()
+	if !now.Before(e.expires.Add(c.staleIfError)) {
+		return nil, false
+	}
+
+	return e, now.Before(e.expires)
+}
+
+// serveEntry writes the cached response e, result is reported by
+// metrics.MicroCacheRequests
+func (c *Cache) serveEntry(w http.ResponseWriter, r *http.Request, e *entry, result string) {
+	for name, values := range e.header {
+		w.Header()[name] = append([]string(nil), values...)
+	}
+
+	w.Header().Set("Age", strconv.Itoa(int(c.now().Sub(e.stored).Seconds())))
+	w.WriteHeader(e.status)
+
+	if r.Method != http.MethodHead {
+		w.Write(e.body)
+	}
+
+	metrics.MicroCacheRequests.WithLabelValues(result).Inc()
+}
+
+// responseTTL returns the time a response with status and the headers h can
+// be cached for, 0 when it cannot be cached
+func (c *Cache) responseTTL(status int, h http.Header) time.Duration {
+	if status != http.StatusOK || h.Get("Set-Cookie") != "" {
+		return 0
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type")); err != nil || mediaType != "text/html" {
+		return 0
+	}
+
+	// the key only varies with the accepted encodings
+	for _, value := range h.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if !strings.EqualFold(strings.TrimSpace(name), "Accept-Encoding") {
+				return 0
+			}
+		}
+	}
+
+	ttl := c.ttl
+	for _, directive := range cacheControl(h) {
+		switch {
+		case directive == "no-store" || directive == "no-cache" || directive == "private":
+			return 0
+		case strings.HasPrefix(directive, "max-age="):
+			maxAge, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err != nil || maxAge <= 0 {
+				return 0
+			}
+
+			if d := time.Duration(maxAge) * time.Second; d < ttl {
+				ttl = d
+			}
+		}
+	}
+
+	return ttl
+}
+
+// cacheableRequest returns true for the requests which can be served from
+// the cache: anonymous requests for the whole response which accept cached
+// responses
+func cacheableRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if withoutCache, _ := r.Context().Value(withoutCacheKey{}).(bool); withoutCache {
+		return false
+	}
+
+	for _, name := range []string{"Authorization", "Cookie", "Range", "If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since", "If-Range"} {
+		if r.Header.Get(name) != "" {
+			return false
+		}
+	}
+
+	if strings.Contains(strings.ToLower(r.Header.Get("Pragma")), "no-cache") {
+		return false
+	}
+
+	for _, directive := range cacheControl(r.Header) {
+		if directive == "no-store" || directive == "no-cache" || directive == "max-age=0" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// cacheControl returns the lowercase directives of the Cache-Control header
+func cacheControl(h http.Header) []string {
+	var directives []string
+
+	for _, value := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			if directive = strings.ToLower(strings.TrimSpace(directive)); directive != "" {
+				directives = append(directives, directive)
+			}
+		}
+	}
+
+	return directives
+}
+
+// cacheKey returns the key of the response to r, the accepted encodings
+// are part of it as they select the encoding of the response
+func cacheKey(r *http.Request) string {
+	return strings.ToLower(r.Host) + "\x00" + r.URL.RequestURI() + "\x00" + strings.Join(r.Header.Values("Accept-Encoding"), ",")
+}
+
+// recordingWriter records the status code, the headers set by the handler
+// and the body of the response. cacheFor is the time the response can be
+// cached for, it is reset to 0 and release is called as soon as the response
+// cannot be cached, e.g. when its body is longer than maxSize bytes. Server
+// errors are discarded and failed is set when the stale entry can be served
+// instead.
+type recordingWriter struct {
+	http.ResponseWriter
+	before      http.Header
+	maxSize     int64
+	ttl         func(status int, h http.Header) time.Duration
+	release     func()
+	stale       *entry
+	status      int
+	header      http.Header
+	body        bytes.Buffer
+	cacheFor    time.Duration
+	wroteHeader bool
+	failed      bool
+}
+
+func (w *recordingWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.status = statusCode
+		w.header = changedHeaders(w.before, w.Header())
+		w.failed = w.stale != nil && statusCode >= http.StatusInternalServerError
+
+		if w.cacheFor = w.ttl(statusCode, w.header); w.cacheFor == 0 {
+			w.release()
+		}
+	}
+
+	if w.failed {
+		return
+	}
+
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.failed {
+		return len(data), nil
+	}
+
+	if w.cacheFor > 0 && int64(w.body.Len()+len(data)) > w.maxSize {
+		w.uncacheable()
+	}
+
+	if w.cacheFor > 0 {
+		w.body.Write(data)
+	}
+
+	n, err := w.ResponseWriter.Write(data)
+	if err != nil {
+		// the handler can stop before writing the whole response
+		w.uncacheable()
+	}
+
+	return n, err
+}
+
+func (w *recordingWriter) uncacheable() {
+	w.cacheFor = 0
+	w.body = bytes.Buffer{}
+	w.release()
+}
+
+// Unwrap returns the http.ResponseWriter the response is written to while it
+// is recorded for the cache
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// changedHeaders returns the headers of after which are not in before, e.g.
+// the headers set by a handler and not by the middlewares before it
+func changedHeaders(before, after http.Header) http.Header {
+	changed := make(http.Header)
+
+	for name, values := range after {
+		if !equal(before[name], values) {
+			changed[name] = append([]string(nil), values...)
+		}
+	}
+
+	return changed
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,345 @@
+package microcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// countingHandler serves an HTML page with the headers set by header and
+// counts the requests it served
+type countingHandler struct {
+	served int32
+	header func(http.Header)
+	body   string
+}
+
+func (h *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&h.served, 1)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if h.header != nil {
+		h.header(w.Header())
+	}
+
+	body := h.body
+	if body == "" {
+		body = "<html>page</html>"
+	}
+
+	w.Write([]byte(body))
+}
+
+func get(t *testing.T, handler http.Handler, method string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(method, "http://group.gitlab.io/page.html", nil)
+	for name, values := range header {
+		r.Header[name] = values
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestMiddlewareCachesHTML(t *testing.T) {
+	h := &countingHandler{}
+	handler := NewMiddleware(h, New(time.Minute, 0, 1024, 10))
+
+	for i := 0; i < 3; i++ {
+		w := get(t, handler, http.MethodGet, nil)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "<html>page</html>", w.Body.String())
+		require.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	}
+
+	require.Equal(t, int32(1), h.served)
+
+	w := get(t, handler, http.MethodHead, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Empty(t, w.Body.String())
+	require.Equal(t, "0", w.Header().Get("Age"))
+	require.Equal(t, int32(1), h.served, "HEAD requests are served from the cache")
+
+	get(t, handler, http.MethodGet, http.Header{"Accept-Encoding": {"gzip"}})
+	require.Equal(t, int32(2), h.served, "the accepted encodings are part of the key")
+}
+
+func TestMiddlewareNotCached(t *testing.T) {
+	tests := map[string]struct {
+		header        func(http.Header)
+		body          string
+		requestHeader http.Header
+	}{
+		"not_html": {
+			header: func(h http.Header) { h.Set("Content-Type", "image/png") },
+		},
+		"no_store": {
+			header: func(h http.Header) { h.Set("Cache-Control", "no-store") },
+		},
+		"private": {
+			header: func(h http.Header) { h.Set("Cache-Control", "private, max-age=60") },
+		},
+		"max_age_zero": {
+			header: func(h http.Header) { h.Set("Cache-Control", "max-age=0") },
+		},
+		"cookie_set": {
+			header: func(h http.Header) { h.Set("Set-Cookie", "session=value") },
+		},
+		"varies_with_language": {
+			header: func(h http.Header) { h.Add("Vary", "Accept-Language") },
+		},
+		"too_large": {
+			body: strings.Repeat("a", 2048),
+		},
+		"request_with_cookie": {
+			requestHeader: http.Header{"Cookie": {"gitlab-pages=session"}},
+		},
+		"request_without_cache": {
+			requestHeader: http.Header{"Cache-Control": {"no-cache"}},
+		},
+		"range_request": {
+			requestHeader: http.Header{"Range": {"bytes=0-1"}},
+		},
+		"conditional_request": {
+			requestHeader: http.Header{"If-None-Match": {`"etag"`}},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &countingHandler{header: tt.header, body: tt.body}
+			handler := NewMiddleware(h, New(time.Minute, 0, 1024, 10))
+
+			for i := 0; i < 2; i++ {
+				w := get(t, handler, http.MethodGet, tt.requestHeader)
+				require.Equal(t, http.StatusOK, w.Code)
+			}
+
+			require.Equal(t, int32(2), h.served)
+		})
+	}
+}
+
+func TestMiddlewareNotFound(t *testing.T) {
+	served := 0
+	handler := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusNotFound)
+	}), New(time.Minute, 0, 1024, 10))
+
+	get(t, handler, http.MethodGet, nil)
+	get(t, handler, http.MethodGet, nil)
+
+	require.Equal(t, 2, served)
+}
+
+func TestMiddlewareOnlyCachesHandlerHeaders(t *testing.T) {
+	h := &countingHandler{header: func(h http.Header) { h.Set("X-Handler", "value") }}
+	cached := NewMiddleware(h, New(time.Minute, 0, 1024, 10))
+
+	// the headers of the middlewares before the cache are set for every request
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-First") != "" {
+			w.Header().Set("X-Outer", "first")
+		}
+
+		cached.ServeHTTP(w, r)
+	})
+
+	w := get(t, handler, http.MethodGet, http.Header{"X-First": {"true"}})
+	require.Equal(t, "first", w.Header().Get("X-Outer"))
+
+	w = get(t, handler, http.MethodGet, nil)
+	require.Equal(t, int32(1), h.served)
+	require.Equal(t, "value", w.Header().Get("X-Handler"))
+	require.Empty(t, w.Header().Get("X-Outer"))
+}
+
+func TestMiddlewareStampede(t *testing.T) {
+	release := make(chan struct{})
+	h := &countingHandler{}
+	handler := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		h.ServeHTTP(w, r)
+	}), New(time.Minute, 0, 1024, 10))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			w := get(t, handler, http.MethodGet, nil)
+			require.Equal(t, "<html>page</html>", w.Body.String())
+		}()
+	}
+
+	// let the requests queue up behind the first one
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	require.Equal(t, int32(1), h.served)
+}
+
+func TestMiddlewareStaleIfError(t *testing.T) {
+	now := time.Now()
+	c := New(time.Minute, time.Hour, 1024, 10)
+	c.now = func() time.Time { return now }
+
+	status := http.StatusOK
+	h := &countingHandler{header: func(h http.Header) { h.Set("X-Status", http.StatusText(status)) }}
+	handler := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(status)
+			w.Write([]byte("storage unavailable"))
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	}), c)
+
+	get(t, handler, http.MethodGet, nil)
+
+	now = now.Add(2 * time.Minute)
+	status = http.StatusBadGateway
+
+	w := get(t, handler, http.MethodGet, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "<html>page</html>", w.Body.String())
+	require.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	require.Equal(t, staleWarning, w.Header().Get("Warning"))
+	require.Equal(t, "120", w.Header().Get("Age"))
+
+	status = http.StatusNotFound
+
+	w = get(t, handler, http.MethodGet, nil)
+	require.Equal(t, http.StatusNotFound, w.Code, "only server errors are replaced")
+	require.Empty(t, w.Header().Get("Warning"))
+
+	now = now.Add(time.Hour)
+	status = http.StatusBadGateway
+
+	w = get(t, handler, http.MethodGet, nil)
+	require.Equal(t, http.StatusBadGateway, w.Code, "stale responses are served for staleIfError only")
+	require.Equal(t, "storage unavailable", w.Body.String())
+}
+
+func TestMiddlewareNoStaleIfError(t *testing.T) {
+	now := time.Now()
+	c := New(time.Minute, 0, 1024, 10)
+	c.now = func() time.Time { return now }
+
+	failing := false
+	handler := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		(&countingHandler{}).ServeHTTP(w, r)
+	}), c)
+
+	get(t, handler, http.MethodGet, nil)
+
+	now = now.Add(2 * time.Minute)
+	failing = true
+
+	w := get(t, handler, http.MethodGet, nil)
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestStaleIfErrorMiddleware(t *testing.T) {
+	now := time.Now()
+	c := New(time.Minute, time.Hour, 1024, 10)
+	c.now = func() time.Time { return now }
+
+	lookupFailed := false
+	h := &countingHandler{}
+	cached := NewMiddleware(h, c)
+	handler := NewStaleIfErrorMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if lookupFailed {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		cached.ServeHTTP(w, r)
+	}), c)
+
+	w := get(t, handler, http.MethodGet, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Empty(t, w.Header().Get("Warning"))
+
+	now = now.Add(2 * time.Minute)
+	lookupFailed = true
+
+	w = get(t, handler, http.MethodGet, nil)
+	require.Equal(t, http.StatusOK, w.Code, "the stale response is served when the request fails before the cache")
+	require.Equal(t, "<html>page</html>", w.Body.String())
+	require.Equal(t, staleWarning, w.Header().Get("Warning"))
+	require.Equal(t, int32(1), h.served)
+
+	w = get(t, handler, http.MethodGet, http.Header{"Cookie": {"session=1"}})
+	require.Equal(t, http.StatusBadGateway, w.Code, "uncacheable requests are not served stale responses")
+}
+
+func TestMiddlewareWithoutCache(t *testing.T) {
+	h := &countingHandler{}
+	handler := NewMiddleware(h, New(time.Minute, 0, 1024, 10))
+
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest(http.MethodGet, "http://group.gitlab.io/page.html", nil)
+		handler.ServeHTTP(httptest.NewRecorder(), WithoutCache(r))
+	}
+
+	require.Equal(t, int32(2), h.served)
+
+	get(t, handler, http.MethodGet, nil)
+	require.Equal(t, int32(3), h.served, "the responses of requests without cache are not stored")
+}
+
+func TestResponseTTL(t *testing.T) {
+	c := New(10*time.Second, 0, 1024, 10)
+
+	tests := map[string]struct {
+		cacheControl string
+		expected     time.Duration
+	}{
+		"no_cache_control": {expected: 10 * time.Second},
+		"longer_max_age":   {cacheControl: "public, max-age=3600", expected: 10 * time.Second},
+		"shorter_max_age":  {cacheControl: "max-age=2", expected: 2 * time.Second},
+		"invalid_max_age":  {cacheControl: "max-age=soon", expected: 0},
+		"no_cache":         {cacheControl: "No-Cache", expected: 0},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := http.Header{"Content-Type": {"text/html"}}
+			if tt.cacheControl != "" {
+				h.Set("Cache-Control", tt.cacheControl)
+			}
+
+			require.Equal(t, tt.expected, c.responseTTL(http.StatusOK, h))
+		})
+	}
+}
+
+func TestNewDisabled(t *testing.T) {
+	require.Nil(t, New(0, 0, 1024, 10))
+
+	h := &countingHandler{}
+	require.Equal(t, http.Handler(h), NewMiddleware(h, nil))
+}
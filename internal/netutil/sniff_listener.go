@@ -0,0 +1,145 @@
+package netutil
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// tlsRecordTypeHandshake is the first byte sent by TLS clients, the record
+// type of the ClientHello
+const tlsRecordTypeHandshake = 0x16
+
+// SniffTimeout is the maximum time to wait for the first byte of a connection
+const SniffTimeout = 10 * time.Second
+
+// SniffTLSListener returns a Listener serving both TLS and plain text
+// connections on the same port. Connections starting with a TLS handshake
+// record are wrapped with tls.Server using tlsConfig, the others are returned
+// as they are, so http.Server serves HTTPS and HTTP requests respectively.
+//
+// The first byte of the connections is read in the background, so slow
+// clients do not block accepting other connections. Connections which do not
+// send anything for sniffTimeout are closed.
+func SniffTLSListener(listener net.Listener, tlsConfig *tls.Config, sniffTimeout time.Duration) net.Listener {
+	l := &sniffListener{
+		Listener:     listener,
+		tlsConfig:    tlsConfig,
+		sniffTimeout: sniffTimeout,
+		conns:        make(chan net.Conn),
+		errs:         make(chan error),
+		done:         make(chan struct{}),
+	}
+
+	go l.acceptLoop()
+
+	return l
+}
+
+type sniffListener struct {
+	net.Listener
+	tlsConfig    *tls.Config
+	sniffTimeout time.Duration
+
+	conns     chan net.Conn
+	errs      chan error
+	closeOnce sync.Once
+	done      chan struct{} // no values sent; closed when Close is called
+}
+
+func (l *sniffListener) acceptLoop() {
+	var delay time.Duration
+
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.done:
+				return
+			}
+
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			// back off like http.Server does on temporary errors
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay *= 2; delay > time.Second {
+				delay = time.Second
+			}
+			time.Sleep(delay)
+
+			continue
+		}
+
+		delay = 0
+
+		go l.sniff(conn)
+	}
+}
+
+func (l *sniffListener) sniff(conn net.Conn) {
+	first := make([]byte, 1)
+
+	conn.SetReadDeadline(time.Now().Add(l.sniffTimeout))
+	_, err := io.ReadFull(conn, first)
+	conn.SetReadDeadline(time.Time{})
+
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	var sniffed net.Conn = &sniffedConn{Conn: conn, first: first}
+	if first[0] == tlsRecordTypeHandshake {
+		sniffed = tls.Server(sniffed, l.tlsConfig)
+	}
+
+	select {
+	case l.conns <- sniffed:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// Accept waits for and returns the next sniffed connection
+func (l *sniffListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the underlying listener and the connections being sniffed
+// once their first byte is read
+func (l *sniffListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+
+	return l.Listener.Close()
+}
+
+// sniffedConn replays the first byte read from the connection
+type sniffedConn struct {
+	net.Conn
+	first []byte
+}
+
+func (c *sniffedConn) Read(b []byte) (int, error) {
+	if len(c.first) == 0 {
+		return c.Conn.Read(b)
+	}
+
+	n := copy(b, c.first)
+	c.first = c.first[n:]
+
+	return n, nil
+}
@@ -0,0 +1,79 @@
+package netutil
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/fixture"
+)
+
+func serveSniffTLS(t *testing.T, sniffTimeout time.Duration) string {
+	t.Helper()
+
+	cert, err := tls.X509KeyPair([]byte(fixture.Certificate), []byte(fixture.Key))
+	require.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(strconv.FormatBool(r.TLS != nil)))
+		}),
+		TLSConfig: tlsConfig,
+	}
+
+	go server.Serve(SniffTLSListener(ln, tlsConfig, sniffTimeout))
+	t.Cleanup(func() { server.Close() })
+
+	return ln.Addr().String()
+}
+
+func get(t *testing.T, url string) string {
+	t.Helper()
+
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		Timeout:   5 * time.Second,
+	}
+
+	rsp, err := client.Get(url)
+	require.NoError(t, err)
+	defer rsp.Body.Close()
+
+	body, err := io.ReadAll(rsp.Body)
+	require.NoError(t, err)
+
+	return string(body)
+}
+
+func TestSniffTLSListener(t *testing.T) {
+	addr := serveSniffTLS(t, time.Second)
+
+	require.Equal(t, "false", get(t, "http://"+addr+"/"))
+	require.Equal(t, "true", get(t, "https://"+addr+"/"))
+}
+
+func TestSniffTLSListenerSlowClient(t *testing.T) {
+	addr := serveSniffTLS(t, 100*time.Millisecond)
+
+	// a client which does not send anything does not block the others
+	idle, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer idle.Close()
+
+	require.Equal(t, "false", get(t, "http://"+addr+"/"))
+
+	// and it is disconnected after the sniff timeout
+	idle.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = idle.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+}
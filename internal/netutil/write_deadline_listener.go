@@ -0,0 +1,59 @@
+package netutil
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WriteDeadlineListener returns a Listener whose connections set a deadline
+// of timeout before each write, rather than a deadline for the whole
+// response like http.Server.WriteTimeout. Clients reading too slowly are
+// disconnected, while large files are still served to the clients reading
+// them fast enough. The writes exceeding their deadline are counted by
+// timeouts.
+func WriteDeadlineListener(listener net.Listener, timeout time.Duration, timeouts prometheus.Counter) net.Listener {
+	return &writeDeadlineListener{
+		Listener: listener,
+		timeout:  timeout,
+		timeouts: timeouts,
+	}
+}
+
+type writeDeadlineListener struct {
+	net.Listener
+	timeout  time.Duration
+	timeouts prometheus.Counter
+}
+
+func (l *writeDeadlineListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &writeDeadlineConn{Conn: c, timeout: l.timeout, timeouts: l.timeouts}, nil
+}
+
+type writeDeadlineConn struct {
+	net.Conn
+	timeout  time.Duration
+	timeouts prometheus.Counter
+}
+
+func (c *writeDeadlineConn) Write(b []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+
+	n, err := c.Conn.Write(b)
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		c.timeouts.Inc()
+	}
+
+	return n, err
+}
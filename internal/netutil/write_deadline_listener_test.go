@@ -0,0 +1,80 @@
+package netutil
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// connListener accepts conn
+type connListener struct {
+	net.Listener
+	conn net.Conn
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	return l.conn, nil
+}
+
+func acceptWithWriteDeadline(t *testing.T, timeout time.Duration) (server, client net.Conn, timeouts prometheus.Counter) {
+	t.Helper()
+
+	server, client = net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+
+	timeouts = prometheus.NewCounter(prometheus.CounterOpts{Name: "write_timeouts"})
+
+	conn, err := WriteDeadlineListener(&connListener{conn: server}, timeout, timeouts).Accept()
+	require.NoError(t, err)
+
+	return conn, client, timeouts
+}
+
+func TestWriteDeadlineListenerFastClient(t *testing.T) {
+	conn, client, timeouts := acceptWithWriteDeadline(t, 50*time.Millisecond)
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			time.Sleep(10 * time.Millisecond)
+			if _, err := client.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		_, err := conn.Write(make([]byte, 1024))
+		require.NoError(t, err)
+	}
+
+	require.Greater(t, time.Since(start), 50*time.Millisecond, "the response takes longer than a write")
+	require.Zero(t, testutil.ToFloat64(timeouts))
+}
+
+func TestWriteDeadlineListenerSlowClient(t *testing.T) {
+	conn, client, timeouts := acceptWithWriteDeadline(t, 50*time.Millisecond)
+
+	go func() {
+		// the first write is read, the client stops reading afterwards
+		io.ReadFull(client, make([]byte, 1024))
+	}()
+
+	_, err := conn.Write(make([]byte, 1024))
+	require.NoError(t, err)
+
+	_, err = conn.Write(make([]byte, 1024))
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	require.True(t, netErr.Timeout())
+	require.Equal(t, float64(1), testutil.ToFloat64(timeouts))
+}
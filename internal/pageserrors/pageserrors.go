@@ -0,0 +1,82 @@
+// Package pageserrors defines the categories of errors that can be surfaced to
+// users. Each category maps to an HTTP status code, a metrics label and a short
+// code that is shown on the error page so users can report it to administrators.
+package pageserrors
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Category of an error
+type Category struct {
+	// Name is used as the metrics label value
+	Name string
+	// Code is the short user-facing code displayed on error pages
+	Code string
+	// Status is the HTTP status code served for this category
+	Status int
+}
+
+var (
+	// SourceUnavailable is used when the domain configuration source
+	// could not be reached or returned an unexpected response while
+	// resolving the project of a request
+	SourceUnavailable = Category{Name: "source_unavailable", Code: "PAGES-SRC", Status: http.StatusServiceUnavailable}
+
+	// SourceFailed is used when the domain of a request could not be fetched
+	// from the domain configuration source
+	SourceFailed = Category{Name: "source_failed", Code: "PAGES-SRC", Status: http.StatusBadGateway}
+
+	// ArchiveInvalid is used when a deployment archive cannot be opened or read
+	ArchiveInvalid = Category{Name: "archive_invalid", Code: "PAGES-ARC", Status: http.StatusInternalServerError}
+
+	// AuthFailed is used when the authentication flow with GitLab could not be completed
+	AuthFailed = Category{Name: "auth_failed", Code: "PAGES-AUTH", Status: http.StatusServiceUnavailable}
+
+	// QuotaExceeded is used when a request is rejected by a limit
+	QuotaExceeded = Category{Name: "quota_exceeded", Code: "PAGES-QUOTA", Status: http.StatusTooManyRequests}
+
+	// Internal is used for any error that does not have a category
+	Internal = Category{Name: "internal", Code: "PAGES-INT", Status: http.StatusInternalServerError}
+)
+
+// Error is an error with a category
+type Error struct {
+	Category Category
+	Err      error
+}
+
+// New returns a categorized error with the given message
+func New(category Category, msg string) error {
+	return &Error{Category: category, Err: errors.New(msg)}
+}
+
+// Wrap returns err with the given category. It returns nil if err is nil.
+func Wrap(category Category, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &Error{Category: category, Err: err}
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// CategoryOf returns the category of the first categorized error in err's
+// chain, or Internal if there is none
+func CategoryOf(err error) Category {
+	var categorized *Error
+	if errors.As(err, &categorized) {
+		return categorized.Category
+	}
+
+	return Internal
+}
@@ -0,0 +1,56 @@
+package pageserrors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCategoryOf(t *testing.T) {
+	sentinel := New(ArchiveInvalid, "broken archive")
+
+	tests := map[string]struct {
+		err              error
+		expectedCategory Category
+	}{
+		"nil_error": {
+			expectedCategory: Internal,
+		},
+		"uncategorized_error": {
+			err:              errors.New("something"),
+			expectedCategory: Internal,
+		},
+		"categorized_error": {
+			err:              New(QuotaExceeded, "too many requests"),
+			expectedCategory: QuotaExceeded,
+		},
+		"wrapped_categorized_error": {
+			err:              fmt.Errorf("opening: %w", sentinel),
+			expectedCategory: ArchiveInvalid,
+		},
+		"outermost_category_wins": {
+			err:              Wrap(SourceUnavailable, sentinel),
+			expectedCategory: SourceUnavailable,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tt.expectedCategory, CategoryOf(tt.err))
+		})
+	}
+}
+
+func TestWrap(t *testing.T) {
+	require.NoError(t, Wrap(AuthFailed, nil))
+
+	cause := errors.New("token exchange failed")
+	err := Wrap(AuthFailed, cause)
+
+	require.EqualError(t, err, cause.Error())
+	require.ErrorIs(t, err, cause)
+	require.Equal(t, http.StatusServiceUnavailable, CategoryOf(err).Status)
+}
@@ -1,20 +1,23 @@
 package ratelimiter
 
 import (
+	"math"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"gitlab.com/gitlab-org/labkit/correlation"
 	"gitlab.com/gitlab-org/labkit/log"
 
-	"gitlab.com/gitlab-org/gitlab-pages/internal/feature"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httperrors"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/pageserrors"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/request"
 )
 
 const (
 	headerGitLabRealIP    = "GitLab-Real-IP"
+	headerRetryAfter      = "Retry-After"
 	headerXForwardedFor   = "X-Forwarded-For"
 	headerXForwardedProto = "X-Forwarded-Proto"
 )
@@ -26,19 +29,21 @@ func (rl *RateLimiter) Middleware(handler http.Handler) http.Handler {
 	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if rl.requestAllowed(r) {
+		allowed, retryAfter := rl.reserve(r)
+		if allowed {
 			handler.ServeHTTP(w, r)
 			return
 		}
 
-		rl.logRateLimitedRequest(r)
+		rl.logRateLimitedRequest(r, retryAfter)
 
 		if rl.blockedCount != nil {
-			rl.blockedCount.WithLabelValues(strconv.FormatBool(feature.EnforceIPRateLimits.Enabled())).Inc()
+			rl.blockedCount.WithLabelValues(strconv.FormatBool(rl.enforce)).Inc()
 		}
 
 		if rl.enforce {
-			httperrors.Serve429(w)
+			w.Header().Set(headerRetryAfter, retryAfterSeconds(retryAfter))
+			httperrors.ServeErrorCategory(w, pageserrors.QuotaExceeded)
 			return
 		}
 
@@ -46,7 +51,18 @@ func (rl *RateLimiter) Middleware(handler http.Handler) http.Handler {
 	})
 }
 
-func (rl *RateLimiter) logRateLimitedRequest(r *http.Request) {
+// retryAfterSeconds rounds the delay up to whole seconds as required
+// by the Retry-After header
+func retryAfterSeconds(delay time.Duration) string {
+	seconds := int64(math.Ceil(delay.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	return strconv.FormatInt(seconds, 10)
+}
+
+func (rl *RateLimiter) logRateLimitedRequest(r *http.Request, retryAfter time.Duration) {
 	log.WithFields(logrus.Fields{
 		"rate_limiter_name":             rl.name,
 		"correlation_id":                correlation.ExtractFromContext(r.Context()),
@@ -59,9 +75,10 @@ func (rl *RateLimiter) logRateLimitedRequest(r *http.Request) {
 		"x_forwarded_proto":             r.Header.Get(headerXForwardedProto),
 		"x_forwarded_for":               r.Header.Get(headerXForwardedFor),
 		"gitlab_real_ip":                r.Header.Get(headerGitLabRealIP),
-		"rate_limiter_enabled":          feature.EnforceIPRateLimits.Enabled(),
+		"rate_limiter_enabled":          rl.enforce,
 		"rate_limiter_limit_per_second": rl.limitPerSecond,
 		"rate_limiter_burst_size":       rl.burstSize,
+		"rate_limiter_retry_after":      retryAfter.String(),
 	}). // TODO: change to Debug with https://gitlab.com/gitlab-org/gitlab-pages/-/issues/629
 		Info("request hit rate limit")
 }
@@ -239,3 +239,64 @@ func newTestMetrics(t *testing.T) (*prometheus.GaugeVec, *prometheus.GaugeVec, *
 
 	return blockedGauge, cachedEntries, cacheReqs
 }
+
+func TestMiddlewareRetryAfter(t *testing.T) {
+	tcs := map[string]struct {
+		limit              float64
+		burstSize          int
+		enforce            bool
+		expectedStatus     int
+		expectedRetryAfter string
+	}{
+		"one_request_per_second": {
+			limit:              1,
+			burstSize:          1,
+			enforce:            true,
+			expectedStatus:     http.StatusTooManyRequests,
+			expectedRetryAfter: "1",
+		},
+		"one_request_every_ten_seconds": {
+			limit:              0.1,
+			burstSize:          1,
+			enforce:            true,
+			expectedStatus:     http.StatusTooManyRequests,
+			expectedRetryAfter: "10",
+		},
+		"fast_refill_rounds_up": {
+			limit:              100,
+			burstSize:          1,
+			enforce:            true,
+			expectedStatus:     http.StatusTooManyRequests,
+			expectedRetryAfter: "1",
+		},
+		"report_only": {
+			limit:          1,
+			burstSize:      1,
+			enforce:        false,
+			expectedStatus: http.StatusNoContent,
+		},
+	}
+
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			handler := New(
+				"rate_limiter",
+				WithNow(mockNow),
+				WithLimitPerSecond(tc.limit),
+				WithBurstSize(tc.burstSize),
+				WithEnforce(tc.enforce),
+			).Middleware(next)
+
+			// the first request takes the only token
+			ww := httptest.NewRecorder()
+			handler.ServeHTTP(ww, requestFor(remoteAddr, "http://gitlab.com"))
+			require.Equal(t, http.StatusNoContent, ww.Code)
+			require.Empty(t, ww.Header().Get("Retry-After"))
+
+			ww = httptest.NewRecorder()
+			handler.ServeHTTP(ww, requestFor(remoteAddr, "http://gitlab.com"))
+			require.Equal(t, tc.expectedStatus, ww.Code)
+			require.Equal(t, tc.expectedRetryAfter, ww.Header().Get("Retry-After"))
+		})
+	}
+}
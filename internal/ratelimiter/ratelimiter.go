@@ -41,6 +41,9 @@ type RateLimiter struct {
 	cache          *lru.Cache
 	enforce        bool
 
+	backend         Backend
+	backendFailures *prometheus.CounterVec
+
 	cacheOptions []lru.Option
 }
 
@@ -127,6 +130,22 @@ func WithEnforce(enforce bool) Option {
 	}
 }
 
+// WithBackend stores the token buckets in backend so the limits are shared by
+// all the instances using it. The local limits are used when backend fails.
+func WithBackend(backend Backend) Option {
+	return func(rl *RateLimiter) {
+		rl.backend = backend
+	}
+}
+
+// WithBackendFailuresMetric configures metric reporting how many times the
+// backend failed and the local limits were used instead
+func WithBackendFailuresMetric(m *prometheus.CounterVec) Option {
+	return func(rl *RateLimiter) {
+		rl.backendFailures = m
+	}
+}
+
 func (rl *RateLimiter) limiter(key string) *rate.Limiter {
 	limiterI, _ := rl.cache.FindOrFetch(key, key, func() (interface{}, error) {
 		return rate.NewLimiter(rate.Limit(rl.limitPerSecond), rl.burstSize), nil
@@ -137,9 +156,48 @@ func (rl *RateLimiter) limiter(key string) *rate.Limiter {
 
 // requestAllowed checks if request is within the rate-limit
 func (rl *RateLimiter) requestAllowed(r *http.Request) bool {
+	allowed, _ := rl.reserve(r)
+
+	return allowed
+}
+
+// reserve takes a token for the request if it is within the rate-limit.
+// Otherwise, it returns how long the client should wait before retrying.
+func (rl *RateLimiter) reserve(r *http.Request) (bool, time.Duration) {
 	rateLimitedKey := rl.keyFunc(r)
-	limiter := rl.limiter(rateLimitedKey)
+	now := rl.now()
+
+	if rl.backend != nil {
+		allowed, retryAfter, err := rl.backend.Reserve(r.Context(), rl.name+":"+rateLimitedKey, now, rl.limitPerSecond, rl.burstSize)
+		if err == nil {
+			return allowed, retryAfter
+		}
+
+		if rl.backendFailures != nil {
+			rl.backendFailures.WithLabelValues(rl.name).Inc()
+		}
+	}
+
+	return rl.reserveLocal(rateLimitedKey, now)
+}
+
+func (rl *RateLimiter) reserveLocal(key string, now time.Time) (bool, time.Duration) {
+	limiter := rl.limiter(key)
+
+	// ReserveN allows us to use the rl.now function, so we can test this more easily.
+	reservation := limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		// the burst size does not allow any request, wait for a token to be generated
+		return false, time.Duration(float64(time.Second) / rl.limitPerSecond)
+	}
+
+	delay := reservation.DelayFrom(now)
+	if delay == 0 {
+		return true, 0
+	}
+
+	// give the token back, the request is rejected instead of delayed
+	reservation.CancelAt(now)
 
-	// AllowN allows us to use the rl.now function, so we can test this more easily.
-	return limiter.AllowN(rl.now(), 1)
+	return false, delay
 }
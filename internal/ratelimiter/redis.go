@@ -0,0 +1,370 @@
+package ratelimiter
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1" // nolint: gosec
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+const (
+	// DefaultRedisTimeout is the default timeout of a Redis command, the
+	// local limits are used when it is exceeded
+	DefaultRedisTimeout = 100 * time.Millisecond
+
+	// redisRetryInterval is how long the local limits are used after Redis
+	// failed before trying Redis again
+	redisRetryInterval = 5 * time.Second
+
+	redisKeyPrefix   = "gitlab-pages:ratelimit:"
+	redisMaxIdleConn = 16
+)
+
+// tokenBucketScript takes a token from the bucket stored in KEYS[1].
+// ARGV holds the limit per second, the burst size and the key expiry in
+// milliseconds. It returns whether the token was taken and otherwise how many
+// microseconds to wait for the next one. The buckets are refilled according to
+// the clock of Redis, so they do not depend on the clocks of the instances
+// sharing them. As the script calls TIME, its writes are replicated instead of
+// the script itself, which is the default since Redis 5.
+const tokenBucketScript = `
+redis.replicate_commands()
+local limit = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1]) or burst
+local ts = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * limit / 1000000)
+local wait = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+else
+  wait = math.ceil((1 - tokens) * 1000000 / limit)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+if wait > 0 then
+  return {0, wait}
+end
+return {1, 0}
+`
+
+// tokenBucketScriptSHA identifies tokenBucketScript in the Redis script
+// cache, see EVALSHA
+var tokenBucketScriptSHA = func() string {
+	sum := sha1.Sum([]byte(tokenBucketScript)) // nolint: gosec
+	return hex.EncodeToString(sum[:])
+}()
+
+var (
+	errRedisUnavailable   = errors.New("redis is unavailable")
+	errUnexpectedResponse = errors.New("unexpected redis response")
+)
+
+// Backend stores the token buckets of rate limiters so they can be shared by
+// multiple instances
+type Backend interface {
+	// Reserve takes a token from the bucket identified by key. When the bucket
+	// is empty it returns false and how long to wait for the next token.
+	Reserve(ctx context.Context, key string, now time.Time, limitPerSecond float64, burst int) (bool, time.Duration, error)
+}
+
+// RedisBackend is a Backend storing token buckets in Redis
+type RedisBackend struct {
+	addr     string
+	useTLS   bool
+	password string
+	db       int
+	timeout  time.Duration
+
+	idle chan *redisConn
+
+	// unavailableUntil is the unix nano time until which Redis is not used
+	// after a failure
+	unavailableUntil int64
+	unavailableMu    sync.Mutex
+}
+
+// NewRedisBackend returns a RedisBackend connecting to the Redis server at
+// rawURL, e.g. redis://:password@localhost:6379/0. Use the rediss scheme to
+// connect with TLS.
+func NewRedisBackend(rawURL string, timeout time.Duration) (*RedisBackend, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("unsupported redis URL scheme %q", u.Scheme)
+	}
+
+	b := &RedisBackend{
+		addr:    u.Host,
+		useTLS:  u.Scheme == "rediss",
+		timeout: timeout,
+		idle:    make(chan *redisConn, redisMaxIdleConn),
+	}
+
+	if u.Port() == "" {
+		b.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+
+	if password, ok := u.User.Password(); ok {
+		b.password = password
+	}
+
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if b.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid redis database %q", db)
+		}
+	}
+
+	return b, nil
+}
+
+// Reserve runs the token bucket script on Redis, which refills the bucket
+// according to its own clock, now only decides when Redis is tried again after
+// a failure. It fails fast while Redis is considered unavailable so callers can
+// fall back to local limits.
+func (b *RedisBackend) Reserve(ctx context.Context, key string, now time.Time, limitPerSecond float64, burst int) (bool, time.Duration, error) {
+	if now.UnixNano() < atomic.LoadInt64(&b.unavailableUntil) {
+		return false, 0, errRedisUnavailable
+	}
+
+	// keep the bucket until it would be full again
+	expiry := int64(math.Ceil(float64(burst)/limitPerSecond*1000)) + 1000
+
+	args := []string{
+		"1", redisKeyPrefix + key,
+		strconv.FormatFloat(limitPerSecond, 'f', -1, 64),
+		strconv.Itoa(burst),
+		strconv.FormatInt(expiry, 10),
+	}
+
+	// only send the script when Redis does not have it cached yet, e.g. after
+	// a restart or a SCRIPT FLUSH. EVAL adds it to the cache.
+	reply, err := b.do(ctx, append([]string{"EVALSHA", tokenBucketScriptSHA}, args...)...)
+	if isNoScript(err) {
+		reply, err = b.do(ctx, append([]string{"EVAL", tokenBucketScript}, args...)...)
+	}
+	if err != nil {
+		b.markUnavailable(now, err)
+		return false, 0, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return false, 0, errUnexpectedResponse
+	}
+
+	allowed, ok1 := values[0].(int64)
+	waitMicroseconds, ok2 := values[1].(int64)
+	if !ok1 || !ok2 {
+		return false, 0, errUnexpectedResponse
+	}
+
+	return allowed == 1, time.Duration(waitMicroseconds) * time.Microsecond, nil
+}
+
+func isNoScript(err error) bool {
+	var redisErr redisError
+	return errors.As(err, &redisErr) && strings.HasPrefix(string(redisErr), "NOSCRIPT")
+}
+
+func (b *RedisBackend) markUnavailable(now time.Time, err error) {
+	b.unavailableMu.Lock()
+	defer b.unavailableMu.Unlock()
+
+	if now.UnixNano() < atomic.LoadInt64(&b.unavailableUntil) {
+		return
+	}
+
+	log.WithError(err).WithField("retry_interval", redisRetryInterval.String()).
+		Warn("rate limit redis backend is unavailable, falling back to local rate limits")
+
+	atomic.StoreInt64(&b.unavailableUntil, now.Add(redisRetryInterval).UnixNano())
+}
+
+func (b *RedisBackend) do(ctx context.Context, args ...string) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+
+	conn, err := b.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.do(ctx, args...)
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) {
+		conn.Close()
+		return nil, err
+	}
+
+	b.release(conn)
+
+	return reply, err
+}
+
+func (b *RedisBackend) conn(ctx context.Context) (*redisConn, error) {
+	select {
+	case conn := <-b.idle:
+		return conn, nil
+	default:
+	}
+
+	dialer := &net.Dialer{}
+
+	var netConn net.Conn
+	var err error
+	if b.useTLS {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{MinVersion: tls.VersionTLS12}}
+		netConn, err = tlsDialer.DialContext(ctx, "tcp", b.addr)
+	} else {
+		netConn, err = dialer.DialContext(ctx, "tcp", b.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conn := newRedisConn(netConn)
+
+	if b.password != "" {
+		if _, err := conn.do(ctx, "AUTH", b.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if b.db != 0 {
+		if _, err := conn.do(ctx, "SELECT", strconv.Itoa(b.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+func (b *RedisBackend) release(conn *redisConn) {
+	select {
+	case b.idle <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// redisError is an error reply sent by Redis
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// redisConn implements the parts of the Redis serialization protocol (RESP)
+// needed to run commands. Every command waits for its reply, which is bounded
+// by the timeout of the backend, the connections are pooled so a rate limited
+// request costs a single round trip to Redis.
+type redisConn struct {
+	net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+func newRedisConn(conn net.Conn) *redisConn {
+	return &redisConn{
+		Conn:   conn,
+		reader: bufio.NewReader(conn),
+		writer: bufio.NewWriter(conn),
+	}
+}
+
+func (c *redisConn) do(ctx context.Context, args ...string) (interface{}, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := c.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Fprintf(c.writer, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.writer, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	if err := c.writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	return readReply(c.reader)
+}
+
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	line = strings.TrimSuffix(line, "\r\n")
+	if len(line) == 0 {
+		return nil, errUnexpectedResponse
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+
+		return string(buf[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+
+		values := make([]interface{}, size)
+		for i := range values {
+			if values[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+
+		return values, nil
+	default:
+		return nil, errUnexpectedResponse
+	}
+}
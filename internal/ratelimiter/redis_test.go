@@ -0,0 +1,470 @@
+package ratelimiter
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1" // nolint: gosec
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+type bucket struct {
+	tokens float64
+	ts     int64
+}
+
+// fakeRedis implements the commands used by RedisBackend, evaluating the token
+// bucket script in Go with the time of its own clock
+type fakeRedis struct {
+	listener net.Listener
+	password string
+
+	mu       sync.Mutex
+	now      time.Time
+	conns    []net.Conn
+	buckets  map[string]*bucket
+	scripts  map[string]bool
+	commands []string
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+
+	return startFakeRedis(t, "127.0.0.1:0", password)
+}
+
+func startFakeRedis(t *testing.T, addr, password string) *fakeRedis {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+
+	f := &fakeRedis{listener: listener, password: password, now: validTime, buckets: make(map[string]*bucket), scripts: make(map[string]bool)}
+	t.Cleanup(f.kill)
+
+	go f.serve()
+
+	return f
+}
+
+// restart kills f and returns a new server listening on its address, which
+// has lost the buckets and the scripts
+func (f *fakeRedis) restart(t *testing.T) *fakeRedis {
+	t.Helper()
+
+	f.kill()
+
+	return startFakeRedis(t, f.listener.Addr().String(), f.password)
+}
+
+// kill closes the listener and the connections of the clients
+func (f *fakeRedis) kill() {
+	f.listener.Close()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+	f.conns = nil
+}
+
+func (f *fakeRedis) advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+}
+
+func (f *fakeRedis) url() string {
+	return "redis://:" + f.password + "@" + f.listener.Addr().String() + "/2"
+}
+
+func (f *fakeRedis) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+
+	f.mu.Lock()
+	f.conns = append(f.conns, conn)
+	f.mu.Unlock()
+
+	reader := bufio.NewReader(conn)
+	authenticated := f.password == ""
+
+	for {
+		reply, err := readReply(reader)
+		if err != nil {
+			return
+		}
+
+		var args []string
+		for _, arg := range reply.([]interface{}) {
+			args = append(args, arg.(string))
+		}
+
+		f.mu.Lock()
+		f.commands = append(f.commands, args[0])
+		f.mu.Unlock()
+
+		switch {
+		case args[0] == "AUTH" && args[1] == f.password:
+			authenticated = true
+			fmt.Fprint(conn, "+OK\r\n")
+		case args[0] == "AUTH":
+			fmt.Fprint(conn, "-WRONGPASS invalid password\r\n")
+		case !authenticated:
+			fmt.Fprint(conn, "-NOAUTH Authentication required.\r\n")
+		case args[0] == "SELECT":
+			fmt.Fprint(conn, "+OK\r\n")
+		case args[0] == "EVAL":
+			f.loadScript(args[1])
+			allowed, wait := f.eval(args[3:])
+			fmt.Fprintf(conn, "*2\r\n:%d\r\n:%d\r\n", allowed, wait)
+		case args[0] == "EVALSHA" && f.hasScript(args[1]):
+			allowed, wait := f.eval(args[3:])
+			fmt.Fprintf(conn, "*2\r\n:%d\r\n:%d\r\n", allowed, wait)
+		case args[0] == "EVALSHA":
+			fmt.Fprint(conn, "-NOSCRIPT No matching script. Please use EVAL.\r\n")
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+	}
+}
+
+func (f *fakeRedis) loadScript(script string) {
+	sum := sha1.Sum([]byte(script))
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.scripts[hex.EncodeToString(sum[:])] = true
+}
+
+func (f *fakeRedis) flushScripts() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.scripts = make(map[string]bool)
+	f.commands = nil
+}
+
+func (f *fakeRedis) hasScript(sha string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.scripts[sha]
+}
+
+func (f *fakeRedis) eval(args []string) (int64, int64) {
+	limit, _ := strconv.ParseFloat(args[1], 64)
+	burst, _ := strconv.ParseFloat(args[2], 64)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.now.UnixNano() / int64(time.Microsecond)
+
+	b, ok := f.buckets[args[0]]
+	if !ok {
+		b = &bucket{tokens: burst, ts: now}
+		f.buckets[args[0]] = b
+	}
+
+	b.tokens = math.Min(burst, b.tokens+math.Max(0, float64(now-b.ts))*limit/1000000)
+	b.ts = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 1, 0
+	}
+
+	return 0, int64(math.Ceil((1 - b.tokens) * 1000000 / limit))
+}
+
+func (f *fakeRedis) receivedCommands() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.commands
+}
+
+func TestRedisBackendReserve(t *testing.T) {
+	server := newFakeRedis(t, "secret")
+
+	backend, err := NewRedisBackend(server.url(), time.Second)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		allowed, retryAfter, err := backend.Reserve(ctx, "domain:example.com", validTime, 2, 2)
+		require.NoError(t, err)
+		require.True(t, allowed)
+		require.Zero(t, retryAfter)
+	}
+
+	allowed, retryAfter, err := backend.Reserve(ctx, "domain:example.com", validTime, 2, 2)
+	require.NoError(t, err)
+	require.False(t, allowed)
+	require.Equal(t, 500*time.Millisecond, retryAfter)
+
+	// other keys have their own bucket
+	allowed, _, err = backend.Reserve(ctx, "domain:other.com", validTime, 2, 2)
+	require.NoError(t, err)
+	require.True(t, allowed)
+
+	// the bucket is refilled according to the clock of redis
+	allowed, _, err = backend.Reserve(ctx, "domain:example.com", validTime.Add(time.Hour), 2, 2)
+	require.NoError(t, err)
+	require.False(t, allowed)
+
+	server.advance(500 * time.Millisecond)
+
+	allowed, _, err = backend.Reserve(ctx, "domain:example.com", validTime, 2, 2)
+	require.NoError(t, err)
+	require.True(t, allowed)
+
+	// the connection is reused between requests and the script is only sent
+	// when Redis does not have it cached
+	require.Equal(t, []string{"AUTH", "SELECT", "EVALSHA", "EVAL", "EVALSHA", "EVALSHA", "EVALSHA", "EVALSHA", "EVALSHA"}, server.receivedCommands())
+	require.Contains(t, server.buckets, redisKeyPrefix+"domain:example.com")
+}
+
+func TestRedisBackendReloadsFlushedScript(t *testing.T) {
+	server := newFakeRedis(t, "")
+
+	backend, err := NewRedisBackend(server.url(), time.Second)
+	require.NoError(t, err)
+
+	_, _, err = backend.Reserve(context.Background(), "key", validTime, 1, 2)
+	require.NoError(t, err)
+
+	server.flushScripts()
+
+	allowed, _, err := backend.Reserve(context.Background(), "key", validTime, 1, 2)
+	require.NoError(t, err)
+	require.True(t, allowed)
+	require.Equal(t, []string{"EVALSHA", "EVAL"}, server.receivedCommands())
+
+	_, _, err = backend.Reserve(context.Background(), "key", validTime, 1, 2)
+	require.NoError(t, err, "NOSCRIPT does not mark redis as unavailable")
+}
+
+func TestRedisBackendShared(t *testing.T) {
+	server := newFakeRedis(t, "")
+
+	first, err := NewRedisBackend(server.url(), time.Second)
+	require.NoError(t, err)
+	second, err := NewRedisBackend(server.url(), time.Second)
+	require.NoError(t, err)
+
+	firstLimiter := New("domain", WithNow(mockNow), WithLimitPerSecond(1), WithBurstSize(1), WithBackend(first))
+	secondLimiter := New("domain", WithNow(mockNow), WithLimitPerSecond(1), WithBurstSize(1), WithBackend(second))
+
+	require.True(t, firstLimiter.requestAllowed(requestFor("10.0.0.1:1234", "http://example.com")))
+	require.False(t, secondLimiter.requestAllowed(requestFor("10.0.0.1:1234", "http://example.com")),
+		"the limit is shared between rate limiters using the same backend")
+}
+
+func TestRedisBackendUnavailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	backend, err := NewRedisBackend("redis://"+addr, 50*time.Millisecond)
+	require.NoError(t, err)
+
+	_, _, err = backend.Reserve(context.Background(), "key", validTime, 1, 1)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, errRedisUnavailable)
+
+	_, _, err = backend.Reserve(context.Background(), "key", validTime.Add(time.Second), 1, 1)
+	require.ErrorIs(t, err, errRedisUnavailable, "redis is not retried before the retry interval")
+
+	_, _, err = backend.Reserve(context.Background(), "key", validTime.Add(redisRetryInterval), 1, 1)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, errRedisUnavailable)
+}
+
+func TestRedisBackendFallsBackToLocalLimits(t *testing.T) {
+	server := newFakeRedis(t, "secret")
+
+	backend, err := NewRedisBackend(strings.Replace(server.url(), "secret", "wrong", 1), time.Second)
+	require.NoError(t, err)
+
+	failures := prometheus.NewCounterVec(prometheus.CounterOpts{Name: t.Name()}, []string{"limiter"})
+
+	rl := New(
+		"source_ip",
+		WithNow(mockNow),
+		WithLimitPerSecond(1),
+		WithBurstSize(1),
+		WithBackend(backend),
+		WithBackendFailuresMetric(failures),
+	)
+
+	allowed, _ := rl.reserve(requestFor("10.0.0.1:1234", "http://example.com"))
+	require.True(t, allowed)
+
+	allowed, retryAfter := rl.reserve(requestFor("10.0.0.1:1234", "http://example.com"))
+	require.False(t, allowed)
+	require.Equal(t, time.Second, retryAfter)
+
+	require.Equal(t, float64(2), testutil.ToFloat64(failures.WithLabelValues("source_ip")))
+	require.Equal(t, []string{"AUTH"}, server.receivedCommands())
+}
+
+func TestRedisBackendKilled(t *testing.T) {
+	server := newFakeRedis(t, "")
+
+	backend, err := NewRedisBackend(server.url(), time.Second)
+	require.NoError(t, err)
+
+	failures := prometheus.NewCounterVec(prometheus.CounterOpts{Name: t.Name()}, []string{"limiter"})
+
+	now := validTime
+	rl := New(
+		"domain",
+		WithNow(func() time.Time { return now }),
+		WithLimitPerSecond(1),
+		WithBurstSize(1),
+		WithBackend(backend),
+		WithBackendFailuresMetric(failures),
+	)
+
+	r := requestFor("10.0.0.1:1234", "http://example.com")
+
+	allowed, _ := rl.reserve(r)
+	require.True(t, allowed)
+	allowed, _ = rl.reserve(r)
+	require.False(t, allowed, "the bucket in redis is empty")
+	require.Zero(t, testutil.ToFloat64(failures.WithLabelValues("domain")))
+
+	// the pooled connection is closed by the server
+	server.kill()
+
+	allowed, _ = rl.reserve(r)
+	require.True(t, allowed, "the local limits are used once redis is killed")
+	allowed, _ = rl.reserve(r)
+	require.False(t, allowed)
+	require.Equal(t, float64(2), testutil.ToFloat64(failures.WithLabelValues("domain")))
+
+	restarted := server.restart(t)
+
+	now = now.Add(redisRetryInterval - time.Millisecond)
+	allowed, _ = rl.reserve(r)
+	require.True(t, allowed, "the local bucket was refilled")
+	require.Empty(t, restarted.receivedCommands(), "redis is not retried before the retry interval")
+
+	now = now.Add(time.Millisecond)
+	allowed, _ = rl.reserve(r)
+	require.True(t, allowed)
+	allowed, _ = rl.reserve(r)
+	require.False(t, allowed)
+	require.Equal(t, []string{"SELECT", "EVALSHA", "EVAL", "EVALSHA"}, restarted.receivedCommands(), "redis is used again after the retry interval")
+	require.Equal(t, float64(3), testutil.ToFloat64(failures.WithLabelValues("domain")))
+}
+
+func TestNewRedisBackend(t *testing.T) {
+	tests := map[string]struct {
+		url           string
+		expectedAddr  string
+		expectedTLS   bool
+		expectedPass  string
+		expectedDB    int
+		expectedError string
+	}{
+		"default_port": {
+			url:          "redis://localhost",
+			expectedAddr: "localhost:6379",
+		},
+		"password_and_db": {
+			url:          "redis://:secret@redis.example.com:6380/3",
+			expectedAddr: "redis.example.com:6380",
+			expectedPass: "secret",
+			expectedDB:   3,
+		},
+		"tls": {
+			url:          "rediss://redis.example.com",
+			expectedAddr: "redis.example.com:6379",
+			expectedTLS:  true,
+		},
+		"unsupported_scheme": {
+			url:           "http://redis.example.com",
+			expectedError: `unsupported redis URL scheme "http"`,
+		},
+		"invalid_db": {
+			url:           "redis://localhost/db",
+			expectedError: `invalid redis database "db"`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			backend, err := NewRedisBackend(tt.url, DefaultRedisTimeout)
+			if tt.expectedError != "" {
+				require.EqualError(t, err, tt.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedAddr, backend.addr)
+			require.Equal(t, tt.expectedTLS, backend.useTLS)
+			require.Equal(t, tt.expectedPass, backend.password)
+			require.Equal(t, tt.expectedDB, backend.db)
+		})
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	tests := map[string]struct {
+		input         string
+		expected      interface{}
+		expectedError string
+	}{
+		"simple_string": {input: "+OK\r\n", expected: "OK"},
+		"error":         {input: "-ERR failed\r\n", expectedError: "ERR failed"},
+		"integer":       {input: ":42\r\n", expected: int64(42)},
+		"bulk_string":   {input: "$5\r\nhello\r\n", expected: "hello"},
+		"nil":           {input: "$-1\r\n", expected: nil},
+		"array":         {input: "*2\r\n:1\r\n$1\r\na\r\n", expected: []interface{}{int64(1), "a"}},
+		"unknown_type":  {input: "?\r\n", expectedError: errUnexpectedResponse.Error()},
+		"truncated":     {input: "$5\r\nhel", expectedError: "unexpected EOF"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			reply, err := readReply(bufio.NewReader(strings.NewReader(tt.input)))
+			if tt.expectedError != "" {
+				require.EqualError(t, err, tt.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, reply)
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package redirects
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	netlifyRedirects "github.com/tj/go-redirects"
+)
+
+// isDomainRule returns true if the rule's "from" is an absolute URL including
+// the host it applies to, e.g. `http://old.example.com/*`
+func isDomainRule(rule netlifyRedirects.Rule) bool {
+	return isAbsoluteURL(rule.From)
+}
+
+func isAbsoluteURL(urlText string) bool {
+	urlText = strings.ToLower(urlText)
+
+	return strings.HasPrefix(urlText, "http://") || strings.HasPrefix(urlText, "https://")
+}
+
+// parseAbsoluteURL parses an http(s) URL with a host, defaulting its path to /
+func parseAbsoluteURL(urlText string) (*url.URL, error) {
+	u, err := url.Parse(urlText)
+	if err != nil || u.Host == "" {
+		return nil, errFailedToParseURL
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errUnsupportedDomainScheme
+	}
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	return u, nil
+}
+
+// validateDomainRule runs validations against a domain-level rule. Unlike
+// path rules, they can redirect to other sites so domains can be migrated.
+// Returns `nil` if the rule is valid.
+func validateDomainRule(r netlifyRedirects.Rule, placeholders bool) error {
+	from, err := parseAbsoluteURL(r.From)
+	if err != nil {
+		return err
+	}
+
+	if err := validateURL(from.Path, placeholders); err != nil {
+		return err
+	}
+
+	if countPlaceholders(from.Path) > maxPlaceholdersPerRule {
+		return errTooManyPlaceholders
+	}
+
+	if r.Params != nil {
+		return errNoParams
+	}
+
+	if !isAbsoluteURL(r.To) {
+		if err := validateURL(r.To, placeholders); err != nil {
+			return err
+		}
+
+		return validateStatus(r.Status)
+	}
+
+	to, err := parseAbsoluteURL(r.To)
+	if err != nil {
+		return err
+	}
+
+	if err := validateURL(to.Path, placeholders); err != nil {
+		return err
+	}
+
+	// content of other sites can only be redirected to, not rewritten
+	switch r.Status {
+	case http.StatusMovedPermanently, http.StatusFound:
+		return nil
+	default:
+		return errUnsupportedStatus
+	}
+}
+
+// matchesDomainRule returns `true` if the domain-level rule's "from" URL
+// matches the scheme, host and path of the request. The second return value
+// is the URL this rule should redirect/rewrite to, see `matchesRule`.
+func matchesDomainRule(rule *netlifyRedirects.Rule, scheme, host, path string, placeholders bool) (bool, string) {
+	from, err := parseAbsoluteURL(rule.From)
+	if err != nil {
+		return false, ""
+	}
+
+	if from.Scheme != scheme || !strings.EqualFold(from.Hostname(), host) {
+		return false, ""
+	}
+
+	if !isAbsoluteURL(rule.To) {
+		// the rule rewrites or redirects to a path of the same site
+		return matchesRule(&netlifyRedirects.Rule{From: from.Path, To: rule.To}, path, placeholders)
+	}
+
+	to, err := parseAbsoluteURL(rule.To)
+	if err != nil {
+		return false, ""
+	}
+
+	isMatch, toPath := matchesRule(&netlifyRedirects.Rule{From: from.Path, To: to.Path}, path, placeholders)
+	if !isMatch {
+		return false, ""
+	}
+
+	// toPath is escaped, see `matchesRule`
+	to.Path, err = url.PathUnescape(toPath)
+	if err != nil {
+		return false, ""
+	}
+	to.RawPath = toPath
+
+	return true, to.String()
+}
@@ -0,0 +1,35 @@
+//go:build go1.18
+// +build go1.18
+
+package redirects
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// FuzzRedirects parses the fuzzed rules and rewrites the fuzzed path with
+// them, the seed corpus is in testdata/fuzz/FuzzRedirects
+func FuzzRedirects(f *testing.F) {
+	f.Add("/from /to 301", "/from")
+	f.Add("/blog/* /news/:splat 301\n/:year/:month /archive/:year/:month 302", "/blog/a%3Fb/c")
+	f.Add("http://old.example.com/* https://new.example.com/:splat 301!", "/docs/")
+	f.Add("/* /index.html 200", "//a//b/")
+
+	enablePlaceholders(f)
+
+	f.Fuzz(func(t *testing.T, rules, path string) {
+		r := parseRedirects(strings.NewReader(rules))
+		r.Status()
+
+		u := &url.URL{Path: path}
+
+		if newURL, _, err := r.Rewrite(u); err == nil && newURL == nil {
+			t.Fatalf("Rewrite(%q) returned a nil URL without error", path)
+		}
+
+		r.RewriteDomain("http", "old.example.com", u)
+		r.RewriteForced(u, func() bool { return true })
+	})
+}
@@ -1,7 +1,10 @@
 package redirects
 
 import (
+	"net/http"
 	"strings"
+
+	netlifyRedirects "github.com/tj/go-redirects"
 )
 
 // normalizePath ensures that the provided path ends with at least one trailing slash.
@@ -9,3 +12,28 @@ import (
 func normalizePath(path string) string {
 	return strings.TrimSuffix(path, "/") + "/"
 }
+
+// countPlaceholders returns the number of placeholder and splat segments in the path
+func countPlaceholders(path string) int {
+	count := 0
+	for _, segment := range strings.Split(path, "/") {
+		if regexSplat.MatchString(segment) || regexPlaceholder.MatchString(segment) {
+			count++
+		}
+	}
+
+	return count
+}
+
+// isFallbackRule returns true if the rule rewrites every path under a
+// directory to a single page, e.g. `/* /index.html 200` for single-page
+// applications. Fallback rules are supported even without placeholders.
+func isFallbackRule(rule netlifyRedirects.Rule) bool {
+	return rule.Status == http.StatusOK &&
+		!rule.Force &&
+		rule.Params == nil &&
+		strings.HasSuffix(rule.From, "/*") &&
+		countPlaceholders(rule.From) == 1 &&
+		strings.Count(rule.From, "*") == 1 &&
+		!regexPlaceholderOrSplats.MatchString(rule.To)
+}
@@ -33,3 +33,29 @@ func Test_normalizePath(t *testing.T) {
 		})
 	}
 }
+
+func Test_countPlaceholders(t *testing.T) {
+	tests := map[string]struct {
+		path     string
+		expected int
+	}{
+		"no_placeholders": {
+			path:     "/foo/bar.html",
+			expected: 0,
+		},
+		"placeholders_and_splat": {
+			path:     "/news/:year/:month/*",
+			expected: 3,
+		},
+		"placeholder_like_text": {
+			path:     "/foo:bar/a*b",
+			expected: 0,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tt.expected, countPlaceholders(tt.path))
+		})
+	}
+}
@@ -2,13 +2,15 @@ package redirects
 
 import (
 	"fmt"
+	"net/url"
 	"regexp"
 	"strings"
+	"time"
 
 	netlifyRedirects "github.com/tj/go-redirects"
 	"gitlab.com/gitlab-org/labkit/log"
 
-	"gitlab.com/gitlab-org/gitlab-pages/internal/feature"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
 var (
@@ -31,9 +33,8 @@ var (
 // rule should redirect/rewrite to. This path is effectively the rule's "to" path that
 // has been templated with all the placeholders (if any) from the originally requested URL.
 //
-// TODO: Likely these should include host comparison once we have domain-level redirects
-// https://gitlab.com/gitlab-org/gitlab-pages/-/issues/601
-func matchesRule(rule *netlifyRedirects.Rule, path string) (bool, string) {
+// Domain-level rules, which include the host, are matched by `matchesDomainRule`.
+func matchesRule(rule *netlifyRedirects.Rule, path string, placeholders bool) (bool, string) {
 	// If the requested URL exactly matches this rule's "from" path,
 	// exit early and return the rule's "to" path to avoid building
 	// and compiling the regex below.
@@ -44,8 +45,13 @@ func matchesRule(rule *netlifyRedirects.Rule, path string) (bool, string) {
 	}
 
 	// Any logic beyond this point handles placeholders and splats.
-	// If the FF_ENABLE_PLACEHOLDERS feature flag isn't enabled, exit now.
-	if !feature.RedirectsPlaceholders.Enabled() {
+	// If placeholders aren't enabled for the domain, only fallback rules
+	// like `/* /index.html 200` can match.
+	if !placeholders {
+		if isFallbackRule(*rule) && matchesFallbackRule(rule, path) {
+			return true, rule.To
+		}
+
 		return false, ""
 	}
 
@@ -84,8 +90,12 @@ func matchesRule(rule *netlifyRedirects.Rule, path string) (bool, string) {
 		return false, ""
 	}
 
+	// The values of placeholders and splats come from the decoded path, so
+	// they are escaped to keep characters like `?` or `#` part of the path
+	escapedPath, escapedIndex := escapeSubmatches(path, submatchIndex)
+
 	templatedToPath := []byte{}
-	templatedToPath = fromRegex.ExpandString(templatedToPath, template, path, submatchIndex)
+	templatedToPath = fromRegex.ExpandString(templatedToPath, template, escapedPath, escapedIndex)
 
 	// Some replacements result in subsequent slashes. For example, a rule with a "to"
 	// like `foo/:splat/bar` will result in a path like `foo//bar` if the splat
@@ -96,29 +106,168 @@ func matchesRule(rule *netlifyRedirects.Rule, path string) (bool, string) {
 	return true, string(templatedToPath)
 }
 
+// matchesFallbackRule returns true if path is under the directory of the
+// fallback rule, e.g. /app/ for `/app/* /app/index.html 200`, ignoring case
+// and repeated slashes like the rules with placeholders do
+func matchesFallbackRule(rule *netlifyRedirects.Rule, path string) bool {
+	dir := strings.ToLower(strings.TrimSuffix(rule.From, "*"))
+	path = strings.ToLower(regexMultipleSlashes.ReplaceAllString(path, "/"))
+
+	return strings.HasPrefix(normalizePath(path), dir)
+}
+
+// escapeSubmatches returns path with the values of the subexpressions matched
+// at submatchIndex escaped, along with their index in the escaped path.
+// The subexpressions of the rules don't overlap.
+func escapeSubmatches(path string, submatchIndex []int) (string, []int) {
+	var b strings.Builder
+	escapedIndex := make([]int, len(submatchIndex))
+
+	last := 0
+	for i := 2; i+1 < len(submatchIndex); i += 2 {
+		start, end := submatchIndex[i], submatchIndex[i+1]
+		if start < 0 {
+			escapedIndex[i], escapedIndex[i+1] = -1, -1
+			continue
+		}
+
+		b.WriteString(path[last:start])
+		escapedIndex[i] = b.Len()
+		b.WriteString(escapePath(path[start:end]))
+		escapedIndex[i+1] = b.Len()
+		last = end
+	}
+	b.WriteString(path[last:])
+
+	escapedIndex[0], escapedIndex[1] = 0, b.Len()
+
+	return b.String(), escapedIndex
+}
+
+// escapePath escapes each segment of p
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	return strings.Join(segments, "/")
+}
+
 // `match` returns:
 // 1. The first valid redirect or rewrite rule that matches the requested URL
 // 2. The URL to redirect/rewrite to
 //
 // If no rule matches, this function returns `nil` and an empty string
 func (r *Redirects) match(path string) (*netlifyRedirects.Rule, string) {
+	return r.matchFunc(path, matchesPathRule(path, r.placeholdersEnabled()), func(*netlifyRedirects.Rule) (bool, bool) {
+		return true, true
+	})
+}
+
+// `matchDomain` returns the first valid domain-level rule that matches the
+// requested scheme, host and path, and the URL to redirect/rewrite to.
+//
+// If no rule matches, this function returns `nil` and an empty string
+func (r *Redirects) matchDomain(scheme, host, path string) (*netlifyRedirects.Rule, string) {
+	placeholders := r.placeholdersEnabled()
+
+	matches := func(rule *netlifyRedirects.Rule) (bool, string) {
+		if !isDomainRule(*rule) {
+			return false, ""
+		}
+
+		return matchesDomainRule(rule, scheme, host, path, placeholders)
+	}
+
+	return r.matchFunc(path, matches, func(*netlifyRedirects.Rule) (bool, bool) {
+		return true, true
+	})
+}
+
+// matchesPathRule returns a function matching path against the rules which
+// are not domain-level rules
+func matchesPathRule(path string, placeholders bool) func(*netlifyRedirects.Rule) (bool, string) {
+	return func(rule *netlifyRedirects.Rule) (bool, string) {
+		if isDomainRule(*rule) {
+			return false, ""
+		}
+
+		return matchesRule(rule, path, placeholders)
+	}
+}
+
+// `matchForced` returns the first valid forced rule that matches the requested
+// URL and the URL to redirect/rewrite to.
+//
+// Regular rules are shadowed by existing files, so when a regular rule matches
+// before any forced rule, the following forced rules are only considered if
+// `fileExists` returns true. Otherwise the regular rule takes precedence and
+// this function returns `nil` and an empty string.
+func (r *Redirects) matchForced(path string, fileExists func() bool) (*netlifyRedirects.Rule, string) {
+	var checked, exists bool
+
+	return r.matchFunc(path, matchesPathRule(path, r.placeholdersEnabled()), func(rule *netlifyRedirects.Rule) (bool, bool) {
+		if rule.Force {
+			return true, true
+		}
+
+		if !checked {
+			exists = fileExists()
+			checked = true
+		}
+
+		// keep looking for a forced rule only if the regular rule is shadowed
+		return false, !exists
+	})
+}
+
+// `matchFunc` calls `accept` for every valid rule for which `matches` returns
+// true, in order. The first rule accepted is returned along with the URL to
+// redirect/rewrite to. The search is aborted when `accept` returns `stop`.
+//
+// If no rule is accepted, this function returns `nil` and an empty string
+func (r *Redirects) matchFunc(path string, matches func(*netlifyRedirects.Rule) (bool, string), accept func(*netlifyRedirects.Rule) (accepted, stop bool)) (*netlifyRedirects.Rule, string) {
+	start := time.Now()
+	placeholders := r.placeholdersEnabled()
+
 	for i := range r.rules {
 		if i >= maxRuleCount {
 			// do not process any more rules
 			return nil, ""
 		}
 
+		if time.Since(start) > maxEvaluationTime {
+			metrics.RedirectsLimitReached.WithLabelValues(limitEvaluationTime).Inc()
+			log.WithFields(log.Fields{
+				"path":       path,
+				"rule_index": i,
+			}).Warn("matching _redirects rules took too long, skipping the remaining rules")
+
+			return nil, ""
+		}
+
 		// assign rule to a new var to prevent the following gosec error
 		// G601: Implicit memory aliasing in for loop
 		rule := r.rules[i]
 
-		if validateRule(rule) != nil {
+		if validateRule(rule, placeholders) != nil {
 			continue
 		}
 
-		if isMatch, path := matchesRule(&rule, path); isMatch {
+		isMatch, path := matches(&rule)
+		if !isMatch {
+			continue
+		}
+
+		accepted, stop := accept(&rule)
+		if accepted {
 			return &rule, path
 		}
+
+		if stop {
+			return nil, ""
+		}
 	}
 
 	return nil, ""
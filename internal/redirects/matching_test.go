@@ -55,8 +55,6 @@ var testsWithoutPlaceholders = map[string]testCaseData{
 }
 
 func Test_matchesRule(t *testing.T) {
-	enablePlaceholders(t)
-
 	tests := mergeTestSuites(testsWithoutPlaceholders, map[string]testCaseData{
 		// Note: the following 3 cases behave differently when
 		// placeholders are disabled. See the similar test cases below.
@@ -109,6 +107,18 @@ func Test_matchesRule(t *testing.T) {
 			expectMatch:  true,
 			expectedPath: "/qux/baz/bar/",
 		},
+		"splat_match_escaped": {
+			rule:         "/foo/* /qux/:splat",
+			path:         "/foo/a?b#c/d e",
+			expectMatch:  true,
+			expectedPath: "/qux/a%3Fb%23c/d%20e",
+		},
+		"placeholder_match_escaped": {
+			rule:         "/foo/:name /qux/:name",
+			path:         "/foo/été",
+			expectMatch:  true,
+			expectedPath: "/qux/%C3%A9t%C3%A9",
+		},
 		"splat_match_beginning": {
 			rule:         "/*/baz/bar /qux/:splat",
 			path:         "/foo/baz/bar",
@@ -299,7 +309,7 @@ func Test_matchesRule(t *testing.T) {
 			rules, err := netlifyRedirects.ParseString(tt.rule)
 			require.NoError(t, err)
 
-			isMatch, path := matchesRule(&rules[0], tt.path)
+			isMatch, path := matchesRule(&rules[0], tt.path, true)
 			require.Equal(t, tt.expectMatch, isMatch)
 			require.Equal(t, tt.expectedPath, path)
 		})
@@ -331,6 +341,36 @@ func Test_matchesRule_NoPlaceholders(t *testing.T) {
 			expectMatch:  false,
 			expectedPath: "",
 		},
+		"fallback_rule": {
+			rule:         "/* /index.html 200",
+			path:         "/app/users/1",
+			expectMatch:  true,
+			expectedPath: "/index.html",
+		},
+		"fallback_rule_in_directory": {
+			rule:         "/app/* /app/index.html 200",
+			path:         "/App//users",
+			expectMatch:  true,
+			expectedPath: "/app/index.html",
+		},
+		"fallback_rule_directory_without_slash": {
+			rule:         "/app/* /app/index.html 200",
+			path:         "/app",
+			expectMatch:  true,
+			expectedPath: "/app/index.html",
+		},
+		"fallback_rule_other_directory": {
+			rule:         "/app/* /app/index.html 200",
+			path:         "/application/users",
+			expectMatch:  false,
+			expectedPath: "",
+		},
+		"splat_redirect": {
+			rule:         "/* /index.html 301",
+			path:         "/app/users/1",
+			expectMatch:  false,
+			expectedPath: "",
+		},
 	})
 
 	for name, tt := range tests {
@@ -338,7 +378,7 @@ func Test_matchesRule_NoPlaceholders(t *testing.T) {
 			rules, err := netlifyRedirects.ParseString(tt.rule)
 			require.NoError(t, err)
 
-			isMatch, path := matchesRule(&rules[0], tt.path)
+			isMatch, path := matchesRule(&rules[0], tt.path, false)
 			require.Equal(t, tt.expectMatch, isMatch)
 			require.Equal(t, tt.expectedPath, path)
 		})
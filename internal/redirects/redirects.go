@@ -6,15 +6,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/url"
 	"regexp"
 	"strings"
+	"time"
 
 	netlifyRedirects "github.com/tj/go-redirects"
 
 	"gitlab.com/gitlab-org/labkit/log"
 
+	"gitlab.com/gitlab-org/gitlab-pages/internal/feature"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
 const (
@@ -32,15 +36,29 @@ const (
 
 	// maxRuleCount is used to limit the total number of rules allowed in _redirects
 	maxRuleCount = 1000
+
+	// maxPlaceholdersPerRule is used to limit the number of placeholders and splats
+	// in rules URLs, as each one of them makes the generated regular expression more expensive
+	maxPlaceholdersPerRule = 10
+
+	// Values of the `limit` label of the RedirectsLimitReached metric
+	limitFileSize       = "file_size"
+	limitRuleCount      = "rules"
+	limitPlaceholders   = "placeholders"
+	limitEvaluationTime = "evaluation_time"
 )
 
+// maxEvaluationTime is used to limit the time spent matching a request against
+// the rules, the remaining rules are skipped once it is exceeded
+var maxEvaluationTime = 100 * time.Millisecond
+
 var (
 	// ErrNoRedirect is the error thrown when a no redirect rule matches while trying to Rewrite URL.
 	// This means that no redirect applies to the URL and you can fallback to serving actual content instead.
 	ErrNoRedirect                      = errors.New("no redirect found")
 	errConfigNotFound                  = errors.New("_redirects file not found")
 	errNeedRegularFile                 = errors.New("_redirects needs to be a regular file (not a directory)")
-	errFileTooLarge                    = errors.New("_redirects file too large")
+	errFileTooLarge                    = fmt.Errorf("_redirects file too large, the maximum size is %d bytes", maxConfigSize)
 	errFailedToOpenConfig              = errors.New("unable to open _redirects file")
 	errFailedToParseConfig             = errors.New("failed to parse _redirects file")
 	errFailedToParseURL                = errors.New("unable to parse URL")
@@ -50,14 +68,32 @@ var (
 	errNoPlaceholders                  = errors.New("placeholders are not supported")
 	errNoParams                        = errors.New("params not supported")
 	errUnsupportedStatus               = errors.New("status not supported")
-	errNoForce                         = errors.New("force! not supported")
+	errUnsupportedDomainScheme         = errors.New("domain-level rules must use http:// or https:// URLs")
 	errTooManyPathSegments             = fmt.Errorf("url path cannot contain more than %d forward slashes", maxPathSegments)
+	errTooManyPlaceholders             = fmt.Errorf("url path cannot contain more than %d placeholders or splats", maxPlaceholdersPerRule)
 	regexpPlaceholder                  = regexp.MustCompile(`(?i)/:[a-z]+`)
 )
 
 type Redirects struct {
 	rules []netlifyRedirects.Rule
 	error error
+	// flags are the feature flags of the domain, e.g. enabling placeholders
+	flags feature.Flags
+}
+
+// WithFlags returns a copy of r sharing its rules, matched with the feature
+// flags of the domain, so the rules parsed for a deployment can be cached
+func (r *Redirects) WithFlags(flags feature.Flags) *Redirects {
+	withFlags := *r
+	withFlags.flags = flags
+
+	return &withFlags
+}
+
+// placeholdersEnabled returns true when splats and placeholders are supported
+// for the domain of the rules
+func (r *Redirects) placeholdersEnabled() bool {
+	return feature.RedirectsPlaceholders.EnabledFor(r.flags)
 }
 
 // Status maps over each redirect rule and returns any error message
@@ -66,6 +102,7 @@ func (r *Redirects) Status() string {
 		return fmt.Sprintf("parse error: %s", r.error.Error())
 	}
 
+	placeholders := r.placeholdersEnabled()
 	messages := make([]string, 0, len(r.rules)+1)
 	messages = append(messages, fmt.Sprintf("%d rules", len(r.rules)))
 
@@ -84,7 +121,7 @@ func (r *Redirects) Status() string {
 			break
 		}
 
-		if err := validateRule(rule); err != nil {
+		if err := validateRule(rule, placeholders); err != nil {
 			messages = append(messages, fmt.Sprintf("rule %d: error: %s", i+1, err.Error()))
 		} else {
 			messages = append(messages, fmt.Sprintf("rule %d: valid", i+1))
@@ -94,10 +131,62 @@ func (r *Redirects) Status() string {
 	return strings.Join(messages, "\n")
 }
 
+// Errors returns the error found while parsing the _redirects file or the
+// validation errors of the rules that will be processed
+func (r *Redirects) Errors() []error {
+	if r.error != nil {
+		return []error{r.error}
+	}
+
+	placeholders := r.placeholdersEnabled()
+
+	var errs []error
+	for i, rule := range r.rules {
+		if i >= maxRuleCount {
+			errs = append(errs, fmt.Errorf("only the first %d of %d rules will be processed", maxRuleCount, len(r.rules)))
+			break
+		}
+
+		if err := validateRule(rule, placeholders); err != nil {
+			errs = append(errs, fmt.Errorf("rule %d: %w", i+1, err))
+		}
+	}
+
+	return errs
+}
+
 // Rewrite takes in a URL and uses the parsed Netlify rules to rewrite
 // the URL to the new location if it matches any rule
 func (r *Redirects) Rewrite(originalURL *url.URL) (*url.URL, int, error) {
 	rule, newPath := r.match(originalURL.Path)
+
+	return rewrite(originalURL, rule, newPath)
+}
+
+// RewriteDomain takes in the scheme and host of a request along with its URL
+// and applies the first domain-level rule matching them, e.g.
+// `http://old.example.com/* https://new.example.com/:splat 301`. Domain-level
+// rules are evaluated before path rules and take precedence over existing files.
+func (r *Redirects) RewriteDomain(scheme, host string, originalURL *url.URL) (*url.URL, int, error) {
+	rule, newPath := r.matchDomain(scheme, host, originalURL.Path)
+
+	return rewrite(originalURL, rule, newPath)
+}
+
+// RewriteForced is like Rewrite but only applies forced rules (e.g. `301!`),
+// which take precedence over existing files. When a regular rule matches
+// before a forced one, the forced rule is only applied if fileExists reports
+// that the regular rule is shadowed by an existing file, see
+// https://docs.netlify.com/routing/redirects/rewrites-proxies/#shadowing
+//
+// fileExists is called lazily, at most once.
+func (r *Redirects) RewriteForced(originalURL *url.URL, fileExists func() bool) (*url.URL, int, error) {
+	rule, newPath := r.matchForced(originalURL.Path, fileExists)
+
+	return rewrite(originalURL, rule, newPath)
+}
+
+func rewrite(originalURL *url.URL, rule *netlifyRedirects.Rule, newPath string) (*url.URL, int, error) {
 	if rule == nil {
 		return nil, 0, ErrNoRedirect
 	}
@@ -111,13 +200,15 @@ func (r *Redirects) Rewrite(originalURL *url.URL) (*url.URL, int, error) {
 		"rule.From":   rule.From,
 		"rule.To":     rule.To,
 		"rule.Status": rule.Status,
+		"rule.Force":  rule.Force,
 	}).Debug("Rewrite")
 	return newURL, rule.Status, err
 }
 
 // ParseRedirects decodes Netlify style redirects from the projects `.../public/_redirects`
 // https://docs.netlify.com/routing/redirects/#syntax-for-the-redirects-file
-func ParseRedirects(ctx context.Context, root vfs.Root) *Redirects {
+// The rules are validated and matched with the feature flags of the domain.
+func ParseRedirects(ctx context.Context, root vfs.Root, flags feature.Flags) *Redirects {
 	fi, err := root.Lstat(ctx, ConfigFile)
 	if err != nil {
 		return &Redirects{error: errConfigNotFound}
@@ -128,6 +219,7 @@ func ParseRedirects(ctx context.Context, root vfs.Root) *Redirects {
 	}
 
 	if fi.Size() > maxConfigSize {
+		metrics.RedirectsLimitReached.WithLabelValues(limitFileSize).Inc()
 		return &Redirects{error: errFileTooLarge}
 	}
 
@@ -137,10 +229,36 @@ func ParseRedirects(ctx context.Context, root vfs.Root) *Redirects {
 	}
 	defer reader.Close()
 
+	r := parseRedirects(reader)
+	r.flags = flags
+
+	return r
+}
+
+// parseRedirects decodes the rules read from reader
+func parseRedirects(reader io.Reader) *Redirects {
 	redirectRules, err := netlifyRedirects.Parse(reader)
 	if err != nil {
 		return &Redirects{error: errFailedToParseConfig}
 	}
 
+	recordLimitsReached(redirectRules)
+
 	return &Redirects{rules: redirectRules}
 }
+
+// recordLimitsReached increases the RedirectsLimitReached metric for each
+// limit on the number of rules or placeholders reached by the rules
+func recordLimitsReached(rules []netlifyRedirects.Rule) {
+	if len(rules) > maxRuleCount {
+		metrics.RedirectsLimitReached.WithLabelValues(limitRuleCount).Inc()
+		rules = rules[:maxRuleCount]
+	}
+
+	for _, rule := range rules {
+		if countPlaceholders(rule.From) > maxPlaceholdersPerRule {
+			metrics.RedirectsLimitReached.WithLabelValues(limitPlaceholders).Inc()
+			return
+		}
+	}
+}
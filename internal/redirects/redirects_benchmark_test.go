@@ -39,7 +39,7 @@ func benchmarkRedirectsRewrite(b *testing.B, redirectsCount int) {
 	url, err := url.Parse("/entrance.html")
 	require.NoError(b, err)
 
-	redirects := ParseRedirects(ctx, root)
+	redirects := ParseRedirects(ctx, root, nil)
 	require.NoError(b, redirects.error)
 
 	for i := 0; i < b.N; i++ {
@@ -71,7 +71,7 @@ func benchmarkRedirectsParseRedirects(b *testing.B, redirectsCount int) {
 	require.NoError(b, err)
 
 	for i := 0; i < b.N; i++ {
-		redirects := ParseRedirects(ctx, root)
+		redirects := ParseRedirects(ctx, root, nil)
 		require.NoError(b, redirects.error)
 	}
 }
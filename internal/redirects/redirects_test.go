@@ -9,11 +9,13 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	netlifyRedirects "github.com/tj/go-redirects"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/feature"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/testhelpers"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
 // enablePlaceholders enables redirect placeholders in tests
@@ -154,6 +156,206 @@ func TestRedirectsRewrite(t *testing.T) {
 	}
 }
 
+func TestRedirectsRewriteForced(t *testing.T) {
+	tests := map[string]struct {
+		url                string
+		rules              string
+		fileExists         bool
+		expectedURL        string
+		expectedStatus     int
+		expectedFileChecks int
+	}{
+		"no_matching_rules": {
+			url:   "/cake-portal.html",
+			rules: "/other.html /still-alive.html 301!",
+		},
+		"regular_rule_is_ignored": {
+			url:                "/cake-portal.html",
+			rules:              "/cake-portal.html /still-alive.html 301",
+			fileExists:         true,
+			expectedFileChecks: 1,
+		},
+		"forced_rule": {
+			url:            "/cake-portal.html",
+			rules:          "/cake-portal.html /still-alive.html 301!",
+			expectedURL:    "/still-alive.html",
+			expectedStatus: http.StatusMovedPermanently,
+		},
+		"forced_rewrite": {
+			url:            "/cake-portal.html",
+			rules:          "/cake-portal.html /still-alive.html 200!",
+			expectedURL:    "/still-alive.html",
+			expectedStatus: http.StatusOK,
+		},
+		"forced_rule_after_regular_rule_without_file": {
+			url: "/cake-portal.html",
+			rules: `/cake-portal.html /still-alive.html 302
+/cake-portal.html /forced.html 301!`,
+			expectedFileChecks: 1,
+		},
+		"forced_rule_after_regular_rule_shadowed_by_file": {
+			url: "/cake-portal.html",
+			rules: `/cake-portal.html /still-alive.html 302
+/cake-portal.html /regular.html 302
+/cake-portal.html /forced.html 301!`,
+			fileExists:         true,
+			expectedURL:        "/forced.html",
+			expectedStatus:     http.StatusMovedPermanently,
+			expectedFileChecks: 1,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			rules, err := netlifyRedirects.ParseString(tt.rules)
+			require.NoError(t, err)
+			r := Redirects{rules: rules}
+
+			url, err := url.Parse(tt.url)
+			require.NoError(t, err)
+
+			fileChecks := 0
+			toURL, status, err := r.RewriteForced(url, func() bool {
+				fileChecks++
+				return tt.fileExists
+			})
+
+			require.Equal(t, tt.expectedFileChecks, fileChecks)
+			require.Equal(t, tt.expectedStatus, status)
+
+			if tt.expectedURL == "" {
+				require.ErrorIs(t, err, ErrNoRedirect)
+				require.Nil(t, toURL)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedURL, toURL.String())
+		})
+	}
+}
+
+func TestRedirectsRewriteDomain(t *testing.T) {
+	enablePlaceholders(t)
+
+	tests := map[string]struct {
+		scheme         string
+		host           string
+		url            string
+		rules          string
+		expectedURL    string
+		expectedStatus int
+	}{
+		"no_domain_rules": {
+			scheme: "http",
+			host:   "old.example.com",
+			url:    "/blog/post.html",
+			rules:  "/blog/* /news/:splat 301",
+		},
+		"other_host": {
+			scheme: "http",
+			host:   "other.example.com",
+			url:    "/blog/post.html",
+			rules:  "http://old.example.com/* https://new.example.com/:splat 301",
+		},
+		"other_scheme": {
+			scheme: "https",
+			host:   "old.example.com",
+			url:    "/blog/post.html",
+			rules:  "http://old.example.com/* https://new.example.com/:splat 301",
+		},
+		"redirect_to_other_domain": {
+			scheme:         "http",
+			host:           "old.example.com",
+			url:            "/blog/post.html",
+			rules:          "http://old.example.com/* https://new.example.com/:splat 301",
+			expectedURL:    "https://new.example.com/blog/post.html",
+			expectedStatus: http.StatusMovedPermanently,
+		},
+		"redirect_to_other_domain_escaped": {
+			scheme:         "http",
+			host:           "old.example.com",
+			url:            "/blog/a%3Fb%20c.html",
+			rules:          "http://old.example.com/* https://new.example.com/:splat 301",
+			expectedURL:    "https://new.example.com/blog/a%3Fb%20c.html",
+			expectedStatus: http.StatusMovedPermanently,
+		},
+		"host_is_case_insensitive": {
+			scheme:         "https",
+			host:           "OLD.example.com",
+			url:            "/",
+			rules:          "https://old.example.com/ https://new.example.com/home.html 302",
+			expectedURL:    "https://new.example.com/home.html",
+			expectedStatus: http.StatusFound,
+		},
+		"first_matching_rule": {
+			scheme: "https",
+			host:   "old.example.com",
+			url:    "/docs/index.html",
+			rules: `/docs/* /documentation/:splat 301
+http://old.example.com/* https://new.example.com/:splat 301
+https://old.example.com/docs/* https://docs.example.com/:splat 301
+https://old.example.com/* https://new.example.com/:splat 301`,
+			expectedURL:    "https://docs.example.com/index.html",
+			expectedStatus: http.StatusMovedPermanently,
+		},
+		"rewrite_on_same_site": {
+			scheme:         "https",
+			host:           "alias.example.com",
+			url:            "/",
+			rules:          "https://alias.example.com/ /alias/index.html 200",
+			expectedURL:    "/alias/index.html",
+			expectedStatus: http.StatusOK,
+		},
+		"rewrite_to_other_domain_is_invalid": {
+			scheme: "https",
+			host:   "old.example.com",
+			url:    "/",
+			rules:  "https://old.example.com/* https://new.example.com/:splat 200",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			rules, err := netlifyRedirects.ParseString(tt.rules)
+			require.NoError(t, err)
+			r := Redirects{rules: rules}
+
+			url, err := url.Parse(tt.url)
+			require.NoError(t, err)
+
+			toURL, status, err := r.RewriteDomain(tt.scheme, tt.host, url)
+			require.Equal(t, tt.expectedStatus, status)
+
+			if tt.expectedURL == "" {
+				require.ErrorIs(t, err, ErrNoRedirect)
+				require.Nil(t, toURL)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedURL, toURL.String())
+		})
+	}
+}
+
+func TestRedirectsRewriteIgnoresDomainRules(t *testing.T) {
+	enablePlaceholders(t)
+
+	rules, err := netlifyRedirects.ParseString("http://old.example.com/* https://new.example.com/:splat 301!")
+	require.NoError(t, err)
+	r := Redirects{rules: rules}
+
+	url, err := url.Parse("/http:/old.example.com/index.html")
+	require.NoError(t, err)
+
+	_, _, err = r.Rewrite(url)
+	require.ErrorIs(t, err, ErrNoRedirect)
+
+	_, _, err = r.RewriteForced(url, func() bool { return false })
+	require.ErrorIs(t, err, ErrNoRedirect)
+}
+
 func TestRedirectsParseRedirects(t *testing.T) {
 	ctx := context.Background()
 
@@ -206,7 +408,7 @@ func TestRedirectsParseRedirects(t *testing.T) {
 				require.NoError(t, err)
 			}
 
-			redirects := ParseRedirects(ctx, root)
+			redirects := ParseRedirects(ctx, root, nil)
 
 			if tt.expectedErr != "" {
 				require.EqualError(t, redirects.error, tt.expectedErr)
@@ -219,6 +421,41 @@ func TestRedirectsParseRedirects(t *testing.T) {
 	}
 }
 
+func TestRedirectsErrors(t *testing.T) {
+	tests := map[string]struct {
+		redirects      *Redirects
+		expectedErrors []string
+	}{
+		"parse_error": {
+			redirects:      &Redirects{error: errFileTooLarge},
+			expectedErrors: []string{errFileTooLarge.Error()},
+		},
+		"valid_rules": {
+			redirects: &Redirects{rules: []netlifyRedirects.Rule{
+				{From: "/goto.html", To: "/target.html", Status: http.StatusMovedPermanently},
+			}},
+		},
+		"invalid_rules": {
+			redirects: &Redirects{rules: []netlifyRedirects.Rule{
+				{From: "/goto.html", To: "/target.html", Status: http.StatusMovedPermanently},
+				{From: "/goto.html", To: "/target.html", Status: http.StatusTeapot},
+			}},
+			expectedErrors: []string{"rule 2: " + errUnsupportedStatus.Error()},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var errs []string
+			for _, err := range tt.redirects.Errors() {
+				errs = append(errs, err.Error())
+			}
+
+			require.Equal(t, tt.expectedErrors, errs)
+		})
+	}
+}
+
 func TestMaxRuleCount(t *testing.T) {
 	root, tmpDir := testhelpers.TmpDir(t, "TooManyRules_tests")
 
@@ -228,7 +465,12 @@ func TestMaxRuleCount(t *testing.T) {
 	), 0600)
 	require.NoError(t, err)
 
-	redirects := ParseRedirects(context.Background(), root)
+	limitReached := metrics.RedirectsLimitReached.WithLabelValues(limitRuleCount)
+	before := testutil.ToFloat64(limitReached)
+
+	redirects := ParseRedirects(context.Background(), root, nil)
+
+	require.Equal(t, before+1, testutil.ToFloat64(limitReached))
 
 	testFn := func(path, expectedToURL string, expectedStatus int, expectedErr string) func(t *testing.T) {
 		return func(t *testing.T) {
@@ -251,3 +493,109 @@ func TestMaxRuleCount(t *testing.T) {
 	t.Run("maxRuleCount matches", testFn("/1000.html", "/target1000", http.StatusMovedPermanently, ""))
 	t.Run("maxRuleCount+1 does not match", testFn("/1001.html", "", 0, ErrNoRedirect.Error()))
 }
+
+func TestMaxPlaceholdersPerRuleMetric(t *testing.T) {
+	enablePlaceholders(t)
+
+	root, tmpDir := testhelpers.TmpDir(t, "TooManyPlaceholders_tests")
+
+	err := os.WriteFile(path.Join(tmpDir, ConfigFile), []byte(
+		"/"+strings.Repeat(":a/", maxPlaceholdersPerRule+1)+" /target.html 301\n"+
+			"/goto.html /target.html 301\n",
+	), 0600)
+	require.NoError(t, err)
+
+	limitReached := metrics.RedirectsLimitReached.WithLabelValues(limitPlaceholders)
+	before := testutil.ToFloat64(limitReached)
+
+	redirects := ParseRedirects(context.Background(), root, nil)
+	require.Equal(t, before+1, testutil.ToFloat64(limitReached))
+	require.Contains(t, redirects.Status(), "rule 1: error: "+errTooManyPlaceholders.Error())
+	require.Contains(t, redirects.Status(), "rule 2: valid")
+}
+
+func TestRedirectsPlaceholdersPerDomain(t *testing.T) {
+	testhelpers.StubFeatureFlagValue(t, feature.RedirectsPlaceholders.EnvVariable, false)
+
+	root, tmpDir := testhelpers.TmpDir(t, "PlaceholdersPerDomain_tests")
+
+	err := os.WriteFile(path.Join(tmpDir, ConfigFile), []byte("/news/:year /archive/:year 301\n"), 0600)
+	require.NoError(t, err)
+
+	originalURL, err := url.Parse("/news/2021")
+	require.NoError(t, err)
+
+	redirects := ParseRedirects(context.Background(), root, nil)
+	require.Contains(t, redirects.Status(), "rule 1: error: "+errNoPlaceholders.Error())
+
+	_, _, err = redirects.Rewrite(originalURL)
+	require.ErrorIs(t, err, ErrNoRedirect)
+
+	redirects = ParseRedirects(context.Background(), root, feature.Flags{feature.RedirectsPlaceholders.Name: true})
+	require.Contains(t, redirects.Status(), "rule 1: valid")
+
+	toURL, status, err := redirects.Rewrite(originalURL)
+	require.NoError(t, err)
+	require.Equal(t, "/archive/2021", toURL.String())
+	require.Equal(t, http.StatusMovedPermanently, status)
+}
+
+func TestRedirectsWithFlags(t *testing.T) {
+	testhelpers.StubFeatureFlagValue(t, feature.RedirectsPlaceholders.EnvVariable, false)
+
+	rules, err := netlifyRedirects.ParseString("/news/:year /archive/:year 301")
+	require.NoError(t, err)
+	redirects := &Redirects{rules: rules}
+
+	withFlags := redirects.WithFlags(feature.Flags{feature.RedirectsPlaceholders.Name: true})
+	require.Contains(t, withFlags.Status(), "rule 1: valid")
+	require.Contains(t, redirects.Status(), "rule 1: error: "+errNoPlaceholders.Error(), "the rules are not modified")
+}
+
+func TestRedirectsFallbackRuleWithoutPlaceholders(t *testing.T) {
+	testhelpers.StubFeatureFlagValue(t, feature.RedirectsPlaceholders.EnvVariable, false)
+
+	root, tmpDir := testhelpers.TmpDir(t, "FallbackRule_tests")
+
+	err := os.WriteFile(path.Join(tmpDir, ConfigFile), []byte("/* /:splat.html 200\n/* /index.html 301!\n/* /index.html 200\n"), 0600)
+	require.NoError(t, err)
+
+	redirects := ParseRedirects(context.Background(), root, nil)
+	require.Equal(t, "3 rules\nrule 1: error: "+errNoSplats.Error()+"\nrule 2: error: "+errNoSplats.Error()+"\nrule 3: valid", redirects.Status())
+
+	originalURL, err := url.Parse("/app/users/1")
+	require.NoError(t, err)
+
+	toURL, status, err := redirects.Rewrite(originalURL)
+	require.NoError(t, err)
+	require.Equal(t, "/index.html", toURL.String())
+	require.Equal(t, http.StatusOK, status)
+}
+
+func TestMaxEvaluationTime(t *testing.T) {
+	rules, err := netlifyRedirects.ParseString("/goto.html /target.html 301")
+	require.NoError(t, err)
+	redirects := Redirects{rules: rules}
+
+	originalURL, err := url.Parse("/goto.html")
+	require.NoError(t, err)
+
+	t.Run("within_evaluation_time", func(t *testing.T) {
+		toURL, _, err := redirects.Rewrite(originalURL)
+		require.NoError(t, err)
+		require.Equal(t, "/target.html", toURL.String())
+	})
+
+	t.Run("evaluation_time_exceeded", func(t *testing.T) {
+		orig := maxEvaluationTime
+		maxEvaluationTime = -1
+		t.Cleanup(func() { maxEvaluationTime = orig })
+
+		limitReached := metrics.RedirectsLimitReached.WithLabelValues(limitEvaluationTime)
+		before := testutil.ToFloat64(limitReached)
+
+		_, _, err := redirects.Rewrite(originalURL)
+		require.ErrorIs(t, err, ErrNoRedirect)
+		require.Equal(t, before+1, testutil.ToFloat64(limitReached))
+	})
+}
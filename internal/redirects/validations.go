@@ -7,8 +7,6 @@ import (
 	"strings"
 
 	netlifyRedirects "github.com/tj/go-redirects"
-
-	"gitlab.com/gitlab-org/gitlab-pages/internal/feature"
 )
 
 var (
@@ -17,9 +15,10 @@ var (
 	regexPlaceholderReplacement = regexp.MustCompile(`(?i):(?P<placeholder>[a-z]+)`)
 )
 
-// validateURL runs validations against a rule URL.
+// validateURL runs validations against a rule URL, splats and placeholders
+// are only valid when placeholders are enabled.
 // Returns `nil` if the URL is valid.
-func validateURL(urlText string) error {
+func validateURL(urlText string, placeholders bool) error {
 	url, err := url.Parse(urlText)
 	if err != nil {
 		return errFailedToParseURL
@@ -38,7 +37,7 @@ func validateURL(urlText string) error {
 		return errNoStartingForwardSlashInURLPath
 	}
 
-	if feature.RedirectsPlaceholders.Enabled() {
+	if placeholders {
 		// Limit the number of path segments a rule can contain.
 		// This prevents the matching logic from generating regular
 		// expressions that are too large/complex.
@@ -62,12 +61,20 @@ func validateURL(urlText string) error {
 
 // validateRule runs all validation rules on the provided rule.
 // Returns `nil` if the rule is valid
-func validateRule(r netlifyRedirects.Rule) error {
-	if err := validateURL(r.From); err != nil {
+func validateRule(r netlifyRedirects.Rule, placeholders bool) error {
+	if isDomainRule(r) {
+		return validateDomainRule(r, placeholders)
+	}
+
+	if err := validateURL(r.From, placeholders || isFallbackRule(r)); err != nil {
 		return err
 	}
 
-	if err := validateURL(r.To); err != nil {
+	if countPlaceholders(r.From) > maxPlaceholdersPerRule {
+		return errTooManyPlaceholders
+	}
+
+	if err := validateURL(r.To, placeholders); err != nil {
 		return err
 	}
 
@@ -76,18 +83,15 @@ func validateRule(r netlifyRedirects.Rule) error {
 		return errNoParams
 	}
 
-	// We strictly validate return status codes
-	switch r.Status {
+	return validateStatus(r.Status)
+}
+
+// validateStatus strictly validates the return status codes
+func validateStatus(status int) error {
+	switch status {
 	case http.StatusOK, http.StatusMovedPermanently, http.StatusFound:
-		// noop
+		return nil
 	default:
 		return errUnsupportedStatus
 	}
-
-	// No support for rules that use ! force
-	if r.Force {
-		return errNoForce
-	}
-
-	return nil
 }
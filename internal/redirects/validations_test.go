@@ -9,8 +9,6 @@ import (
 )
 
 func TestRedirectsValidateUrl(t *testing.T) {
-	enablePlaceholders(t)
-
 	tests := map[string]struct {
 		url         string
 		expectedErr string
@@ -55,7 +53,7 @@ func TestRedirectsValidateUrl(t *testing.T) {
 
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
-			err := validateURL(tt.url)
+			err := validateURL(tt.url, true)
 			if tt.expectedErr != "" {
 				require.EqualError(t, err, tt.expectedErr)
 				return
@@ -86,7 +84,7 @@ func TestRedirectsValidateUrlNoPlaceholders(t *testing.T) {
 
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
-			err := validateURL(tt.url)
+			err := validateURL(tt.url, false)
 			if tt.expectedErr != "" {
 				require.EqualError(t, err, tt.expectedErr)
 				return
@@ -98,8 +96,6 @@ func TestRedirectsValidateUrlNoPlaceholders(t *testing.T) {
 }
 
 func TestRedirectsValidateRule(t *testing.T) {
-	enablePlaceholders(t)
-
 	tests := map[string]struct {
 		rule        string
 		expectedErr string
@@ -124,9 +120,41 @@ func TestRedirectsValidateRule(t *testing.T) {
 			rule:        "/goto.html /target.html 418",
 			expectedErr: errUnsupportedStatus.Error(),
 		},
-		"force_not_supported": {
+		"too_many_placeholders": {
+			rule:        "/" + strings.Repeat(":a/", maxPlaceholdersPerRule+1) + " /target.html 301",
+			expectedErr: errTooManyPlaceholders.Error(),
+		},
+		"valid_forced_rule": {
 			rule:        "/goto.html /target.html 302!",
-			expectedErr: errNoForce.Error(),
+			expectedErr: "",
+		},
+		"valid_domain_rule": {
+			rule:        "http://old.example.com/* https://new.example.com/:splat 301",
+			expectedErr: "",
+		},
+		"valid_domain_rewrite": {
+			rule:        "https://alias.example.com/ /alias/index.html 200",
+			expectedErr: "",
+		},
+		"domain_rule_without_scheme": {
+			rule:        "//old.example.com/* https://new.example.com/:splat 301",
+			expectedErr: errNoDomainLevelRedirects.Error(),
+		},
+		"domain_rule_to_relative_url": {
+			rule:        "http://old.example.com/* new.example.com 301",
+			expectedErr: errNoStartingForwardSlashInURLPath.Error(),
+		},
+		"domain_rule_rewrite_to_other_site": {
+			rule:        "http://old.example.com/* https://new.example.com/:splat 200",
+			expectedErr: errUnsupportedStatus.Error(),
+		},
+		"domain_rule_too_many_placeholders": {
+			rule:        "http://old.example.com/" + strings.Repeat(":a/", maxPlaceholdersPerRule+1) + " https://new.example.com/ 301",
+			expectedErr: errTooManyPlaceholders.Error(),
+		},
+		"path_rule_to_other_site": {
+			rule:        "/goto.html https://new.example.com/ 301",
+			expectedErr: errNoDomainLevelRedirects.Error(),
 		},
 	}
 
@@ -135,7 +163,7 @@ func TestRedirectsValidateRule(t *testing.T) {
 			rules, err := netlifyRedirects.ParseString(tt.rule)
 			require.NoError(t, err)
 
-			err = validateRule(rules[0])
+			err = validateRule(rules[0], true)
 			if tt.expectedErr != "" {
 				require.EqualError(t, err, tt.expectedErr)
 				return
@@ -2,10 +2,16 @@ package rejectmethods
 
 import (
 	"net/http"
+	"strconv"
+	"strings"
 
 	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
+// unknownMethodLabel is used as the method label value for unknown methods
+// to avoid an unbounded number of metric labels
+const unknownMethodLabel = "unknown"
+
 var acceptedMethods = map[string]bool{
 	http.MethodGet:     true,
 	http.MethodHead:    true,
@@ -18,14 +24,45 @@ var acceptedMethods = map[string]bool{
 	http.MethodTrace:   true,
 }
 
+// Route is a method allowed for the requests to a path, even when it is not
+// one of the allowed methods, e.g. a form handled by Pages itself
+type Route struct {
+	Method string
+	Path   string
+}
+
 // NewMiddleware returns middleware which rejects all unknown http methods
-func NewMiddleware(handler http.Handler) http.Handler {
+// and all the methods that are not part of allowedMethods, except for the
+// routes, with a 405 Method Not Allowed response
+func NewMiddleware(handler http.Handler, allowedMethods []string, routes ...Route) http.Handler {
+	allowed := make(map[string]bool, len(allowedMethods))
+	for _, method := range allowedMethods {
+		allowed[method] = true
+	}
+
+	allowedRoutes := make(map[Route]bool, len(routes))
+	for _, route := range routes {
+		allowedRoutes[route] = true
+	}
+
+	allowHeader := strings.Join(allowedMethods, ", ")
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if acceptedMethods[r.Method] {
-			handler.ServeHTTP(w, r)
-		} else {
+		methodLabel := r.Method
+		if !acceptedMethods[r.Method] {
+			methodLabel = unknownMethodLabel
 			metrics.RejectedRequestsCount.Inc()
-			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 		}
+
+		isAllowed := allowed[r.Method] || allowedRoutes[Route{Method: r.Method, Path: r.URL.Path}]
+		metrics.HTTPMethodRequests.WithLabelValues(methodLabel, strconv.FormatBool(isAllowed)).Inc()
+
+		if isAllowed {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Allow", allowHeader)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 	})
 }
@@ -14,10 +14,10 @@ func TestNewMiddleware(t *testing.T) {
 		io.WriteString(w, "OK\n")
 	})
 
-	middleware := NewMiddleware(handler)
+	middleware := NewMiddleware(handler, []string{"GET", "HEAD", "OPTIONS"})
 
-	acceptedMethods := []string{"GET", "HEAD", "POST", "PUT", "PATCH", "CONNECT", "OPTIONS", "TRACE"}
-	for _, method := range acceptedMethods {
+	allowedMethods := []string{"GET", "HEAD", "OPTIONS"}
+	for _, method := range allowedMethods {
 		t.Run(method, func(t *testing.T) {
 			tmpRequest, _ := http.NewRequest(method, "/", nil)
 			recorder := httptest.NewRecorder()
@@ -31,15 +31,81 @@ func TestNewMiddleware(t *testing.T) {
 		})
 	}
 
-	t.Run("UNKNOWN", func(t *testing.T) {
-		tmpRequest, _ := http.NewRequest("UNKNOWN", "/", nil)
-		recorder := httptest.NewRecorder()
+	notAllowedMethods := []string{"POST", "PUT", "PATCH", "DELETE", "CONNECT", "TRACE", "UNKNOWN"}
+	for _, method := range notAllowedMethods {
+		t.Run(method, func(t *testing.T) {
+			tmpRequest, _ := http.NewRequest(method, "/", nil)
+			recorder := httptest.NewRecorder()
 
-		middleware.ServeHTTP(recorder, tmpRequest)
+			middleware.ServeHTTP(recorder, tmpRequest)
+
+			result := recorder.Result()
+			defer result.Body.Close()
 
-		result := recorder.Result()
-		defer result.Body.Close()
+			require.Equal(t, http.StatusMethodNotAllowed, result.StatusCode)
+			require.Equal(t, "GET, HEAD, OPTIONS", result.Header.Get("Allow"))
+		})
+	}
+}
 
-		require.Equal(t, http.StatusMethodNotAllowed, result.StatusCode)
+func TestNewMiddlewareWithConfiguredMethods(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "OK\n")
 	})
+
+	middleware := NewMiddleware(handler, []string{"GET", "POST"})
+
+	tests := map[string]int{
+		"GET":     http.StatusOK,
+		"POST":    http.StatusOK,
+		"HEAD":    http.StatusMethodNotAllowed,
+		"OPTIONS": http.StatusMethodNotAllowed,
+	}
+
+	for method, expectedStatus := range tests {
+		t.Run(method, func(t *testing.T) {
+			tmpRequest, _ := http.NewRequest(method, "/", nil)
+			recorder := httptest.NewRecorder()
+
+			middleware.ServeHTTP(recorder, tmpRequest)
+
+			result := recorder.Result()
+			defer result.Body.Close()
+
+			require.Equal(t, expectedStatus, result.StatusCode)
+		})
+	}
+}
+
+func TestNewMiddlewareWithRoutes(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "OK\n")
+	})
+
+	middleware := NewMiddleware(handler, []string{"GET"}, Route{Method: "POST", Path: "/auth/handoff"})
+
+	tests := map[string]struct {
+		method         string
+		path           string
+		expectedStatus int
+	}{
+		"allowed_method":       {method: "GET", path: "/", expectedStatus: http.StatusOK},
+		"route":                {method: "POST", path: "/auth/handoff", expectedStatus: http.StatusOK},
+		"route_of_other_path":  {method: "POST", path: "/", expectedStatus: http.StatusMethodNotAllowed},
+		"other_method_of_path": {method: "PUT", path: "/auth/handoff", expectedStatus: http.StatusMethodNotAllowed},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tmpRequest, _ := http.NewRequest(tt.method, tt.path, nil)
+			recorder := httptest.NewRecorder()
+
+			middleware.ServeHTTP(recorder, tmpRequest)
+
+			result := recorder.Result()
+			defer result.Body.Close()
+
+			require.Equal(t, tt.expectedStatus, result.StatusCode)
+		})
+	}
 }
@@ -1,8 +1,19 @@
 package request
 
 import (
+	"context"
+	"errors"
 	"net"
 	"net/http"
+	"syscall"
+)
+
+type ctxKey string
+
+const (
+	ctxCanonicalHostKey ctxKey = "canonical_host"
+	ctxListenerKey      ctxKey = "listener"
+	ctxClientIPKey      ctxKey = "client_ip"
 )
 
 const (
@@ -12,6 +23,10 @@ const (
 	SchemeHTTPS = "https"
 )
 
+// ListenerProxy is the listener of the requests received by the listen-proxy
+// listeners, the other requests are received by the http or https listeners
+const ListenerProxy = "proxy"
+
 // IsHTTPS checks whether the request originated from HTTP or HTTPS.
 // It checks the value from r.URL.Scheme
 func IsHTTPS(r *http.Request) bool {
@@ -29,6 +44,60 @@ func GetHostWithoutPort(r *http.Request) string {
 	return host
 }
 
+// WithCanonicalHost saves the host clients used to reach Pages, when it
+// differs from r.Host, in the request's context
+func WithCanonicalHost(r *http.Request, host string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), ctxCanonicalHostKey, host))
+}
+
+// GetCanonicalHost returns the host(:port) to use when building URLs pointing
+// back to Pages, e.g. redirects. It is the host forwarded by a trusted reverse
+// proxy if there is one, otherwise r.Host.
+func GetCanonicalHost(r *http.Request) string {
+	if host, ok := r.Context().Value(ctxCanonicalHostKey).(string); ok {
+		return host
+	}
+
+	return r.Host
+}
+
+// WithListener saves the kind of listener which received the request in the
+// request's context, SchemeHTTP, SchemeHTTPS or ListenerProxy
+func WithListener(r *http.Request, listener string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), ctxListenerKey, listener))
+}
+
+// GetListener returns the kind of listener which received the request, it
+// falls back to the scheme of the request when it is unknown
+func GetListener(r *http.Request) string {
+	if listener, ok := r.Context().Value(ctxListenerKey).(string); ok {
+		return listener
+	}
+
+	if r.TLS != nil || IsHTTPS(r) {
+		return SchemeHTTPS
+	}
+
+	return SchemeHTTP
+}
+
+// WithClientIP saves the IP address of the client, resolved from the headers
+// of the trusted reverse proxies, in the request's context
+func WithClientIP(r *http.Request, ip net.IP) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), ctxClientIPKey, ip))
+}
+
+// GetClientIP returns the IP address of the client saved by WithClientIP, it
+// falls back to the remote address of r. It returns nil when the address is
+// not valid.
+func GetClientIP(r *http.Request) net.IP {
+	if ip, ok := r.Context().Value(ctxClientIPKey).(net.IP); ok {
+		return ip
+	}
+
+	return net.ParseIP(GetRemoteAddrWithoutPort(r))
+}
+
 // GetRemoteAddrWithoutPort strips the port from the r.RemoteAddr
 func GetRemoteAddrWithoutPort(r *http.Request) string {
 	remoteAddr, _, err := net.SplitHostPort(r.RemoteAddr)
@@ -38,3 +107,14 @@ func GetRemoteAddrWithoutPort(r *http.Request) string {
 
 	return remoteAddr
 }
+
+// IsClientDisconnect returns true when err was caused by the client closing
+// the connection before the response was sent, e.g. broken pipes or the
+// request's context being canceled
+func IsClientDisconnect(r *http.Request, err error) bool {
+	if errors.Is(r.Context().Err(), context.Canceled) {
+		return true
+	}
+
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)
+}
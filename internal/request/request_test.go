@@ -1,8 +1,12 @@
 package request
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"syscall"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -43,3 +47,39 @@ func TestGetHostWithoutPort(t *testing.T) {
 		require.Equal(t, "my.example.com", host)
 	})
 }
+
+func TestGetCanonicalHost(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com", nil)
+	r.Host = "pages.internal:8090"
+
+	require.Equal(t, "pages.internal:8090", GetCanonicalHost(r))
+
+	r = WithCanonicalHost(r, "group.example.com")
+	require.Equal(t, "group.example.com", GetCanonicalHost(r))
+	require.Equal(t, "pages.internal:8090", r.Host, "the Host header is not changed")
+}
+
+func TestGetListener(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com", nil)
+	require.Equal(t, SchemeHTTP, GetListener(r))
+
+	r = httptest.NewRequest("GET", "https://example.com", nil)
+	require.Equal(t, SchemeHTTPS, GetListener(r), "falls back to the scheme")
+
+	r = WithListener(r, ListenerProxy)
+	require.Equal(t, ListenerProxy, GetListener(r))
+}
+
+func TestIsClientDisconnect(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com", nil)
+
+	require.False(t, IsClientDisconnect(r, nil))
+	require.False(t, IsClientDisconnect(r, errors.New("server error")))
+	require.True(t, IsClientDisconnect(r, fmt.Errorf("write: %w", syscall.EPIPE)))
+	require.True(t, IsClientDisconnect(r, fmt.Errorf("write: %w", syscall.ECONNRESET)))
+
+	ctx, cancel := context.WithCancel(r.Context())
+	cancel()
+
+	require.True(t, IsClientDisconnect(r.WithContext(ctx), errors.New("context canceled")))
+}
@@ -0,0 +1,60 @@
+package requestfilter
+
+import (
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/httperrors"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/logging"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/request"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+// results of the requests matched by a rule counted by
+// metrics.RequestFilterMatches
+const (
+	resultBlocked         = "blocked"
+	resultAllowed         = "allowed"
+	resultRateLimited     = "rate_limited"
+	resultWithinRateLimit = "within_rate_limit"
+)
+
+// NewMiddleware returns middleware applying the action of the first rule of
+// f matching each request. It returns handler when f is nil.
+func NewMiddleware(handler http.Handler, f *Filter) http.Handler {
+	if f == nil {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rl := f.match(r)
+		if rl == nil {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		switch rl.Action {
+		case ActionBlock:
+			metrics.RequestFilterMatches.WithLabelValues(rl.Name, resultBlocked).Inc()
+			logging.LogRequest(r).WithFields(logrus.Fields{
+				"request_filter_rule": rl.Name,
+				"source_ip":           request.GetRemoteAddrWithoutPort(r),
+			}).Info("request blocked by request filter rule")
+
+			httperrors.Serve403(w)
+		case ActionRateLimit:
+			result := resultRateLimited
+
+			rl.limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				result = resultWithinRateLimit
+				handler.ServeHTTP(w, r)
+			})).ServeHTTP(w, r)
+
+			metrics.RequestFilterMatches.WithLabelValues(rl.Name, result).Inc()
+		default:
+			metrics.RequestFilterMatches.WithLabelValues(rl.Name, resultAllowed).Inc()
+			handler.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package requestfilter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+func TestMiddleware(t *testing.T) {
+	f := newTestFilter(t, testRules)
+
+	handler := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), f)
+
+	// the requests are rate limited in order
+	tests := []struct {
+		name           string
+		url            string
+		userAgent      string
+		expectedStatus int
+		expectedRule   string
+		expectedResult string
+	}{
+		{
+			name:           "no_match",
+			url:            "http://group.gitlab.io/",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "allow",
+			url:            "http://group.gitlab.io/",
+			userAgent:      "uptime-checker/2.0 badbot",
+			expectedStatus: http.StatusOK,
+			expectedRule:   "monitoring",
+			expectedResult: resultAllowed,
+		},
+		{
+			name:           "block",
+			url:            "http://group.gitlab.io/",
+			userAgent:      "badbot",
+			expectedStatus: http.StatusForbidden,
+			expectedRule:   "bad-bot",
+			expectedResult: resultBlocked,
+		},
+		{
+			name:           "within_rate_limit",
+			url:            "http://victim.gitlab.io/",
+			expectedStatus: http.StatusOK,
+			expectedRule:   "targeted-domain",
+			expectedResult: resultWithinRateLimit,
+		},
+		{
+			name:           "rate_limited",
+			url:            "http://victim.gitlab.io/",
+			expectedStatus: http.StatusTooManyRequests,
+			expectedRule:   "targeted-domain",
+			expectedResult: resultRateLimited,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var before float64
+			if tt.expectedRule != "" {
+				before = testutil.ToFloat64(metrics.RequestFilterMatches.WithLabelValues(tt.expectedRule, tt.expectedResult))
+			}
+
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			r.Header.Set("User-Agent", tt.userAgent)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			require.Equal(t, tt.expectedStatus, w.Code)
+
+			if tt.expectedRule != "" {
+				after := testutil.ToFloat64(metrics.RequestFilterMatches.WithLabelValues(tt.expectedRule, tt.expectedResult))
+				require.Equal(t, before+1, after)
+			}
+		})
+	}
+}
@@ -0,0 +1,333 @@
+// Package requestfilter blocks, allows or rate limits the requests matching
+// the rules of a JSON file, which is reloaded when it changes, so operators
+// can mitigate targeted abuse without an external WAF
+package requestfilter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/ratelimiter"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/request"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+// Actions of the rules
+const (
+	// ActionBlock rejects the requests with a 403
+	ActionBlock = "block"
+	// ActionAllow serves the requests without evaluating the next rules
+	ActionAllow = "allow"
+	// ActionRateLimit rejects the requests of a client IP address exceeding
+	// the limit of the rule with a 429
+	ActionRateLimit = "ratelimit"
+)
+
+// results of a reload counted by metrics.RequestFilterReloads
+const (
+	resultReloaded = "reloaded"
+	resultFailed   = "failed"
+)
+
+var (
+	errNoName           = errors.New("rules must have a name")
+	errDuplicateName    = errors.New("rule names must be unique")
+	errInvalidAction    = errors.New("action must be one of block, allow or ratelimit")
+	errInvalidRateLimit = errors.New("ratelimit rules must have a limit_per_second and a burst greater than 0")
+	errInvalidMethod    = errors.New("methods must be HTTP methods, like GET")
+)
+
+// Rule is a rule of the rules file. A request matches the rule when it
+// matches all of its matchers, rules without matchers match every request.
+type Rule struct {
+	// Name identifies the rule in logs and metrics
+	Name string `json:"name"`
+	// Methods are the HTTP methods matching the rule
+	Methods []string `json:"methods,omitempty"`
+	// Host is a regular expression matched against the host of the request,
+	// without the port
+	Host string `json:"host,omitempty"`
+	// Path is a regular expression matched against the path of the request
+	Path string `json:"path,omitempty"`
+	// Headers are regular expressions matched against the value of the
+	// request headers, missing headers have an empty value
+	Headers map[string]string `json:"headers,omitempty"`
+	// Action is one of ActionBlock, ActionAllow or ActionRateLimit
+	Action string `json:"action"`
+	// LimitPerSecond and Burst are the rate limit of each client IP address
+	// applied by ActionRateLimit
+	LimitPerSecond float64 `json:"limit_per_second,omitempty"`
+	Burst          int     `json:"burst,omitempty"`
+}
+
+type rulesFile struct {
+	Rules []Rule `json:"rules"`
+}
+
+type rule struct {
+	Rule
+
+	methods map[string]struct{}
+	host    *regexp.Regexp
+	path    *regexp.Regexp
+	headers map[string]*regexp.Regexp
+	limiter *ratelimiter.RateLimiter
+}
+
+// matches returns true if r matches all the matchers of the rule
+func (rl *rule) matches(r *http.Request) bool {
+	if len(rl.methods) > 0 {
+		if _, ok := rl.methods[r.Method]; !ok {
+			return false
+		}
+	}
+
+	if rl.host != nil && !rl.host.MatchString(request.GetHostWithoutPort(r)) {
+		return false
+	}
+
+	if rl.path != nil && !rl.path.MatchString(r.URL.Path) {
+		return false
+	}
+
+	for name, value := range rl.headers {
+		if !value.MatchString(r.Header.Get(name)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sameLimit returns true if the rules rate limit the same requests the same
+// way, so the limiter of one can be used for the other
+func (rl *rule) sameLimit(other *rule) bool {
+	return rl.Action == ActionRateLimit && other.Action == ActionRateLimit &&
+		rl.LimitPerSecond == other.LimitPerSecond && rl.Burst == other.Burst
+}
+
+// Filter holds the rules of a rules file
+type Filter struct {
+	path  string
+	rules atomic.Value // []*rule
+
+	// modTime and size of the rules file when it was loaded, they are only
+	// used by the goroutine reloading it
+	modTime time.Time
+	size    int64
+}
+
+// New returns a Filter with the rules of the file at path
+func New(path string) (*Filter, error) {
+	f := &Filter{path: path}
+
+	if _, err := f.reload(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Run reloads the rules file every interval when it changed, until ctx is
+// done. The previous rules are kept when the file is not valid.
+func (f *Filter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := f.reload()
+			if err != nil {
+				metrics.RequestFilterReloads.WithLabelValues(resultFailed).Inc()
+				log.WithError(err).WithField("path", f.path).Error("failed to reload the request filter rules, keeping the previous rules")
+				continue
+			}
+
+			if reloaded {
+				metrics.RequestFilterReloads.WithLabelValues(resultReloaded).Inc()
+				log.WithField("path", f.path).Info("reloaded the request filter rules")
+			}
+		}
+	}
+}
+
+// reload loads the rules file when it changed since it was loaded and
+// returns true if it did
+func (f *Filter) reload() (bool, error) {
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return false, err
+	}
+
+	if fi.ModTime().Equal(f.modTime) && fi.Size() == f.size && f.rules.Load() != nil {
+		return false, nil
+	}
+
+	file, err := os.Open(f.path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	rules, err := parseRules(file)
+	if err != nil {
+		return false, fmt.Errorf("parsing %s: %w", f.path, err)
+	}
+
+	f.setLimiters(rules)
+	f.rules.Store(rules)
+	f.modTime, f.size = fi.ModTime(), fi.Size()
+
+	return true, nil
+}
+
+// setLimiters sets the limiters of the rules limiting requests. The limiters
+// of the previous rules limiting requests the same way are reused, so
+// reloading the file does not reset the limits of clients, and only the new
+// limits create a limiter.
+func (f *Filter) setLimiters(rules []*rule) {
+	previous, _ := f.rules.Load().([]*rule)
+
+	byName := make(map[string]*rule, len(previous))
+	for _, rl := range previous {
+		byName[rl.Name] = rl
+	}
+
+	for _, rl := range rules {
+		if rl.Action != ActionRateLimit {
+			continue
+		}
+
+		if old, ok := byName[rl.Name]; ok && rl.sameLimit(old) {
+			rl.limiter = old.limiter
+			continue
+		}
+
+		rl.limiter = ratelimiter.New(
+			"request_filter_"+rl.Name,
+			ratelimiter.WithLimitPerSecond(rl.LimitPerSecond),
+			ratelimiter.WithBurstSize(rl.Burst),
+			ratelimiter.WithCacheMaxSize(ratelimiter.DefaultSourceIPCacheSize),
+			ratelimiter.WithEnforce(true),
+		)
+	}
+}
+
+// match returns the first rule matching r, or nil when none does
+func (f *Filter) match(r *http.Request) *rule {
+	rules, _ := f.rules.Load().([]*rule)
+
+	for _, rl := range rules {
+		if rl.matches(r) {
+			return rl
+		}
+	}
+
+	return nil
+}
+
+// parseRules decodes and compiles the rules read from reader
+func parseRules(reader io.Reader) ([]*rule, error) {
+	var file rulesFile
+
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]struct{}, len(file.Rules))
+	rules := make([]*rule, 0, len(file.Rules))
+
+	for i, r := range file.Rules {
+		if _, ok := names[r.Name]; ok {
+			return nil, fmt.Errorf("rule %d: %w", i+1, errDuplicateName)
+		}
+		names[r.Name] = struct{}{}
+
+		compiled, err := compileRule(r)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+
+		rules = append(rules, compiled)
+	}
+
+	return rules, nil
+}
+
+func compileRule(r Rule) (*rule, error) {
+	if r.Name == "" {
+		return nil, errNoName
+	}
+
+	compiled := &rule{Rule: r}
+
+	switch r.Action {
+	case ActionBlock, ActionAllow:
+	case ActionRateLimit:
+		if r.LimitPerSecond <= 0 || r.Burst <= 0 {
+			return nil, errInvalidRateLimit
+		}
+	default:
+		return nil, errInvalidAction
+	}
+
+	if len(r.Methods) > 0 {
+		compiled.methods = make(map[string]struct{}, len(r.Methods))
+		for _, method := range r.Methods {
+			if method == "" || strings.ToUpper(method) != method {
+				return nil, errInvalidMethod
+			}
+
+			compiled.methods[method] = struct{}{}
+		}
+	}
+
+	var err error
+	if compiled.host, err = compileRegexp(r.Host); err != nil {
+		return nil, fmt.Errorf("host: %w", err)
+	}
+
+	if compiled.path, err = compileRegexp(r.Path); err != nil {
+		return nil, fmt.Errorf("path: %w", err)
+	}
+
+	if len(r.Headers) > 0 {
+		compiled.headers = make(map[string]*regexp.Regexp, len(r.Headers))
+		for name, value := range r.Headers {
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return nil, fmt.Errorf("header %s: %w", name, err)
+			}
+
+			compiled.headers[http.CanonicalHeaderKey(name)] = re
+		}
+	}
+
+	return compiled, nil
+}
+
+// compileRegexp compiles expr, it returns nil when expr is empty so it
+// matches everything
+func compileRegexp(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+
+	return regexp.Compile(expr)
+}
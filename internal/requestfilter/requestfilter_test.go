@@ -0,0 +1,181 @@
+package requestfilter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testRules = `{
+  "rules": [
+    {"name": "monitoring", "headers": {"User-Agent": "^uptime-checker/"}, "action": "allow"},
+    {"name": "bad-bot", "headers": {"user-agent": "(?i)badbot"}, "action": "block"},
+    {"name": "wp-login", "methods": ["GET", "POST"], "path": "^/wp-login\\.php$", "action": "block"},
+    {"name": "targeted-domain", "host": "^victim\\.gitlab\\.io$", "action": "ratelimit", "limit_per_second": 1, "burst": 1}
+  ]
+}`
+
+func TestMatch(t *testing.T) {
+	f := newTestFilter(t, testRules)
+
+	tests := map[string]struct {
+		method       string
+		url          string
+		userAgent    string
+		expectedRule string
+	}{
+		"no_match": {
+			url: "http://group.gitlab.io/index.html",
+		},
+		"header": {
+			url:          "http://group.gitlab.io/index.html",
+			userAgent:    "Mozilla/5.0 (compatible; BadBot/1.0)",
+			expectedRule: "bad-bot",
+		},
+		"first_matching_rule": {
+			url:          "http://group.gitlab.io/wp-login.php",
+			userAgent:    "uptime-checker/2.0",
+			expectedRule: "monitoring",
+		},
+		"method_and_path": {
+			method:       http.MethodPost,
+			url:          "http://group.gitlab.io/wp-login.php",
+			expectedRule: "wp-login",
+		},
+		"other_method": {
+			method: http.MethodOptions,
+			url:    "http://group.gitlab.io/wp-login.php",
+		},
+		"host_without_port": {
+			url:          "http://victim.gitlab.io:8080/",
+			expectedRule: "targeted-domain",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+
+			r := httptest.NewRequest(method, tt.url, nil)
+			if tt.userAgent != "" {
+				r.Header.Set("User-Agent", tt.userAgent)
+			}
+
+			rl := f.match(r)
+			if tt.expectedRule == "" {
+				require.Nil(t, rl)
+				return
+			}
+
+			require.NotNil(t, rl)
+			require.Equal(t, tt.expectedRule, rl.Name)
+		})
+	}
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	tests := map[string]struct {
+		rules       string
+		expectedErr string
+	}{
+		"invalid_json": {
+			rules:       `{"rules": [`,
+			expectedErr: "unexpected EOF",
+		},
+		"unknown_field": {
+			rules:       `{"rules": [{"name": "a", "action": "block", "paths": "^/"}]}`,
+			expectedErr: `json: unknown field "paths"`,
+		},
+		"no_name": {
+			rules:       `{"rules": [{"action": "block"}]}`,
+			expectedErr: "rule 1: " + errNoName.Error(),
+		},
+		"duplicate_name": {
+			rules:       `{"rules": [{"name": "a", "action": "block"}, {"name": "a", "action": "allow"}]}`,
+			expectedErr: "rule 2: " + errDuplicateName.Error(),
+		},
+		"invalid_action": {
+			rules:       `{"rules": [{"name": "a", "action": "drop"}]}`,
+			expectedErr: "rule 1: " + errInvalidAction.Error(),
+		},
+		"ratelimit_without_limit": {
+			rules:       `{"rules": [{"name": "a", "action": "ratelimit", "burst": 1}]}`,
+			expectedErr: "rule 1: " + errInvalidRateLimit.Error(),
+		},
+		"lowercase_method": {
+			rules:       `{"rules": [{"name": "a", "action": "block", "methods": ["post"]}]}`,
+			expectedErr: "rule 1: " + errInvalidMethod.Error(),
+		},
+		"invalid_path": {
+			rules:       `{"rules": [{"name": "a", "action": "block", "path": "("}]}`,
+			expectedErr: "rule 1: path: error parsing regexp: missing closing ): `(`",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseRules(strings.NewReader(tt.rules))
+			require.EqualError(t, err, tt.expectedErr)
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	f := newTestFilter(t, testRules)
+	r := httptest.NewRequest(http.MethodGet, "http://victim.gitlab.io/", nil)
+
+	limiter := f.match(r).limiter
+
+	reloaded, err := f.reload()
+	require.NoError(t, err)
+	require.False(t, reloaded, "the file did not change")
+
+	writeRules(t, f.path, strings.Replace(testRules, "bad-bot", "other-bot", 1), time.Now().Add(time.Second))
+
+	reloaded, err = f.reload()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	require.Equal(t, "targeted-domain", f.match(r).Name)
+	require.Same(t, limiter, f.match(r).limiter, "the limits of clients are kept")
+
+	writeRules(t, f.path, strings.Replace(testRules, `"burst": 1}`, `"burst": 2}`, 1), time.Now().Add(2*time.Second))
+
+	reloaded, err = f.reload()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	require.NotSame(t, limiter, f.match(r).limiter, "changed limits get a new limiter")
+
+	writeRules(t, f.path, `{"rules": [{"name": "a"}]}`, time.Now().Add(3*time.Second))
+
+	_, err = f.reload()
+	require.Error(t, err)
+	require.Equal(t, "targeted-domain", f.match(r).Name, "the previous rules are kept")
+}
+
+func newTestFilter(t *testing.T, rules string) *Filter {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "rules.json")
+	writeRules(t, path, rules, time.Now())
+
+	f, err := New(path)
+	require.NoError(t, err)
+
+	return f
+}
+
+func writeRules(t *testing.T, path, rules string, modTime time.Time) {
+	t.Helper()
+
+	require.NoError(t, os.WriteFile(path, []byte(rules), 0600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
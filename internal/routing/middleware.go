@@ -3,10 +3,12 @@ package routing
 import (
 	"errors"
 	"net/http"
+	"time"
 
+	"gitlab.com/gitlab-org/gitlab-pages/internal/debugtrace"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httperrors"
-	"gitlab.com/gitlab-org/gitlab-pages/internal/logging"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/pageserrors"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/request"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source"
 	"gitlab.com/gitlab-org/gitlab-pages/metrics"
@@ -18,16 +20,20 @@ func NewMiddleware(handler http.Handler, s source.Source) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// if we could not retrieve a domain from domains source we break the
 		// middleware chain and simply respond with 502 after logging this
+		start := time.Now()
 		host, d, err := getHostAndDomain(r, s)
+		debugtrace.FromContext(r.Context()).Observe("lookup", start)
 		if err != nil && !errors.Is(err, domain.ErrDomainDoesNotExist) {
 			metrics.DomainsSourceFailures.Inc()
-			logging.LogRequest(r).WithError(err).Error("could not fetch domain information from a source")
 
-			httperrors.Serve502(w)
+			httperrors.ServeError(w, r, "could not fetch domain information from a source", pageserrors.Wrap(pageserrors.SourceFailed, err))
 			return
 		}
 
 		r = domain.ReqWithHostAndDomain(r, host, d)
+		if errors.Is(err, domain.ErrDomainDeleted) {
+			r = domain.ReqWithDeletedDomain(r)
+		}
 
 		handler.ServeHTTP(w, r)
 	})
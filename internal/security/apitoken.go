@@ -0,0 +1,83 @@
+package security
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	// APIRequestHeader holds the JWT token signed with the GitLab API secret,
+	// it is the same header GitLab Pages uses to authenticate with GitLab API
+	APIRequestHeader = "Gitlab-Pages-Api-Request"
+
+	// APITokenIssuer is the issuer of the tokens authenticating the requests
+	// to the administrative endpoints of Pages, e.g. the deployment webhook
+	APITokenIssuer = "gitlab"
+	// APITokenAudience is the audience of the tokens authenticating the
+	// requests to the administrative endpoints of Pages
+	APITokenAudience = "gitlab-pages"
+	// PagesTokenIssuer is the issuer of the tokens Pages authenticates its
+	// requests to the GitLab API with, which Pages never accepts
+	PagesTokenIssuer = "gitlab-pages"
+)
+
+var (
+	// ErrUnexpectedSigningMethod is returned when a token is not signed with HMAC
+	ErrUnexpectedSigningMethod = errors.New("unexpected signing method")
+	// ErrMissingExpiration is returned for long lived tokens without expiration
+	ErrMissingExpiration = errors.New("token has no expiration")
+	// ErrInvalidIssuer is returned when a token is not issued for the
+	// administrative endpoints, e.g. a token Pages sent to the GitLab API
+	ErrInvalidIssuer = errors.New("token has an invalid issuer")
+	// ErrInvalidAudience is returned when a token is not intended for Pages
+	ErrInvalidAudience = errors.New("token has an invalid audience")
+)
+
+// NewAPIToken returns a token authenticating the requests to the
+// administrative endpoints of Pages, signed with the GitLab API secret, which
+// expires after expiry
+func NewAPIToken(secret []byte, expiry time.Duration) (string, error) {
+	claims := jwt.RegisteredClaims{
+		Issuer:    APITokenIssuer,
+		Audience:  jwt.ClaimStrings{APITokenAudience},
+		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiry)),
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+}
+
+// VerifyAPIToken checks that token is a JWT token signed with the GitLab API
+// secret which has not expired. Tokens without expiration are not accepted.
+// The tokens must be issued by APITokenIssuer for APITokenAudience, so the
+// tokens Pages signs with the same secret for the GitLab API can not be
+// replayed against Pages.
+func VerifyAPIToken(token string, secret []byte) error {
+	claims := &jwt.RegisteredClaims{}
+
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, ErrUnexpectedSigningMethod
+		}
+
+		return secret, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if claims.ExpiresAt == nil {
+		return ErrMissingExpiration
+	}
+
+	if claims.Issuer != APITokenIssuer {
+		return ErrInvalidIssuer
+	}
+
+	if !claims.VerifyAudience(APITokenAudience, true) {
+		return ErrInvalidAudience
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package security
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyAPIToken(t *testing.T) {
+	secret := []byte("secret")
+	expiresAt := jwt.NewNumericDate(time.Now().Add(time.Minute))
+
+	tests := map[string]struct {
+		claims      jwt.RegisteredClaims
+		expectedErr error
+	}{
+		"valid": {
+			claims: jwt.RegisteredClaims{Issuer: APITokenIssuer, Audience: jwt.ClaimStrings{APITokenAudience}, ExpiresAt: expiresAt},
+		},
+		"no_expiration": {
+			claims:      jwt.RegisteredClaims{Issuer: APITokenIssuer, Audience: jwt.ClaimStrings{APITokenAudience}},
+			expectedErr: ErrMissingExpiration,
+		},
+		"pages_token": {
+			claims:      jwt.RegisteredClaims{Issuer: PagesTokenIssuer, ExpiresAt: expiresAt},
+			expectedErr: ErrInvalidIssuer,
+		},
+		"no_audience": {
+			claims:      jwt.RegisteredClaims{Issuer: APITokenIssuer, ExpiresAt: expiresAt},
+			expectedErr: ErrInvalidAudience,
+		},
+		"other_audience": {
+			claims:      jwt.RegisteredClaims{Issuer: APITokenIssuer, Audience: jwt.ClaimStrings{"gitlab"}, ExpiresAt: expiresAt},
+			expectedErr: ErrInvalidAudience,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, tt.claims).SignedString(secret)
+			require.NoError(t, err)
+
+			err = VerifyAPIToken(token, secret)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestNewAPIToken(t *testing.T) {
+	token, err := NewAPIToken([]byte("secret"), time.Minute)
+	require.NoError(t, err)
+
+	require.NoError(t, VerifyAPIToken(token, []byte("secret")))
+	require.Error(t, VerifyAPIToken(token, []byte("other")))
+}
@@ -0,0 +1,62 @@
+// Package security provides helpers to safely handle secrets and
+// attacker-controlled values
+package security
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/url"
+)
+
+const (
+	// MaxStateLength is the maximum length of the OAuth state parameter.
+	// Pages generates a base64 encoded 16 bytes random state, so anything
+	// bigger than this is not issued by us
+	MaxStateLength = 128
+
+	// MaxCodeLength is the maximum length of the OAuth code parameter. The code
+	// is proxied to custom domains as an encrypted and signed JWT token
+	MaxCodeLength = 4096
+
+	// MaxDomainLength is the maximum length of the domain parameter used while
+	// proxying the OAuth flow to custom domains
+	MaxDomainLength = 2048
+
+	// MaxErrorLength is the maximum length of the error parameter returned by
+	// the OAuth provider
+	MaxErrorLength = 256
+)
+
+// ParamTooLongError is returned when a parameter exceeds its maximum length
+type ParamTooLongError struct {
+	Name      string
+	MaxLength int
+}
+
+func (e *ParamTooLongError) Error() string {
+	return fmt.Sprintf("parameter %q exceeds the maximum length of %d", e.Name, e.MaxLength)
+}
+
+// SecureCompare compares two strings in constant time. Both values are hashed
+// first so the comparison does not leak the length of the expected value.
+func SecureCompare(given, expected string) bool {
+	givenSum := sha256.Sum256([]byte(given))
+	expectedSum := sha256.Sum256([]byte(expected))
+
+	return subtle.ConstantTimeCompare(givenSum[:], expectedSum[:]) == 1
+}
+
+// ValidateParamLengths returns a *ParamTooLongError if any parameter in values
+// is longer than its limit. Parameters without a limit are ignored.
+func ValidateParamLengths(values url.Values, limits map[string]int) error {
+	for name, maxLength := range limits {
+		for _, value := range values[name] {
+			if len(value) > maxLength {
+				return &ParamTooLongError{Name: name, MaxLength: maxLength}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package security
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecureCompare(t *testing.T) {
+	tests := map[string]struct {
+		given    string
+		expected string
+		equal    bool
+	}{
+		"equal_values": {
+			given:    "state",
+			expected: "state",
+			equal:    true,
+		},
+		"different_values": {
+			given:    "state",
+			expected: "other",
+		},
+		"different_lengths": {
+			given:    "state",
+			expected: "stateful",
+		},
+		"empty_given": {
+			given:    "",
+			expected: "state",
+		},
+		"both_empty": {
+			equal: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tt.equal, SecureCompare(tt.given, tt.expected))
+		})
+	}
+}
+
+func TestValidateParamLengths(t *testing.T) {
+	limits := map[string]int{"state": 5, "code": 10}
+
+	tests := map[string]struct {
+		values      url.Values
+		expectedErr string
+	}{
+		"within_limits": {
+			values: url.Values{"state": {"abcde"}, "code": {"1234567890"}},
+		},
+		"param_without_limit": {
+			values: url.Values{"other": {strings.Repeat("a", 100)}},
+		},
+		"param_too_long": {
+			values:      url.Values{"state": {"abcdef"}},
+			expectedErr: `parameter "state" exceeds the maximum length of 5`,
+		},
+		"repeated_param_too_long": {
+			values:      url.Values{"code": {"1", strings.Repeat("1", 11)}},
+			expectedErr: `parameter "code" exceeds the maximum length of 10`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateParamLengths(tt.values, limits)
+			if tt.expectedErr == "" {
+				require.NoError(t, err)
+				return
+			}
+
+			var paramErr *ParamTooLongError
+			require.ErrorAs(t, err, &paramErr)
+			require.EqualError(t, err, tt.expectedErr)
+		})
+	}
+}
@@ -0,0 +1,6 @@
+// Package service integrates Pages with the service managers running it.
+// With systemd, a Type=notify unit is notified once Pages is ready to serve
+// requests and its watchdog is pinged while Pages is healthy. On Windows,
+// Pages runs under the service control manager and stops gracefully on its
+// requests.
+package service
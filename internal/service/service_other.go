@@ -0,0 +1,27 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package service
+
+import "context"
+
+// Run runs main, Pages only runs as a service on Windows
+func Run(main func()) error {
+	main()
+
+	return nil
+}
+
+// Stopping returns nil, only the Windows service control manager asks Pages
+// to stop, a nil channel is never ready
+func Stopping() <-chan struct{} {
+	return nil
+}
+
+// Ready does nothing, only systemd and Windows are notified
+func Ready() error {
+	return nil
+}
+
+// Watchdog does nothing, only systemd has a watchdog
+func Watchdog(ctx context.Context, healthy func() bool) {}
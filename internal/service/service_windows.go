@@ -0,0 +1,120 @@
+//go:build windows
+// +build windows
+
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// name of the service, it is ignored by the service control manager for the
+// services running in their own process
+const name = "gitlab-pages"
+
+// stopWaitHint is the time the service control manager is told to wait for
+// Pages to stop, it is reported again until Pages exits
+const stopWaitHint = 10 * time.Second
+
+var (
+	ready     = make(chan struct{})
+	readyOnce sync.Once
+
+	stopping     = make(chan struct{})
+	stoppingOnce sync.Once
+)
+
+// Run runs main as a Windows service when Pages is started by the service
+// control manager, and directly otherwise. The service is reported as running
+// once Ready is called. When it is asked to stop, Stopping is closed and Run
+// returns once main returned, so Pages stops gracefully.
+func Run(main func()) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+
+	if !isService {
+		main()
+		return nil
+	}
+
+	return svc.Run(name, &handler{main: main})
+}
+
+// Ready reports the service as running to the service control manager
+func Ready() error {
+	readyOnce.Do(func() { close(ready) })
+
+	return nil
+}
+
+// Stopping returns a channel closed when the service control manager asks
+// Pages to stop
+func Stopping() <-chan struct{} {
+	return stopping
+}
+
+// Watchdog does nothing, only systemd has a watchdog
+func Watchdog(ctx context.Context, healthy func() bool) {}
+
+// handler runs main and handles the requests of the service control manager
+type handler struct {
+	main func()
+}
+
+func (h *handler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	const accepts = svc.AcceptStop | svc.AcceptShutdown
+
+	status <- svc.Status{State: svc.StartPending}
+
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		h.main()
+	}()
+
+	running := ready
+	for {
+		select {
+		case <-running:
+			// a nil channel is never selected again
+			running = nil
+			status <- svc.Status{State: svc.Running, Accepts: accepts}
+		case <-exited:
+			// Pages stopped on its own, e.g. as it failed to start
+			return false, 1
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				stoppingOnce.Do(func() { close(stopping) })
+				waitExited(exited, status)
+
+				return false, 0
+			}
+		}
+	}
+}
+
+// waitExited reports the service as stopping until main returned
+func waitExited(exited <-chan struct{}, status chan<- svc.Status) {
+	ticker := time.NewTicker(stopWaitHint / 2)
+	defer ticker.Stop()
+
+	checkpoint := uint32(1)
+	for {
+		status <- svc.Status{State: svc.StopPending, CheckPoint: checkpoint, WaitHint: uint32(stopWaitHint / time.Millisecond)}
+
+		select {
+		case <-exited:
+			return
+		case <-ticker.C:
+			checkpoint++
+		}
+	}
+}
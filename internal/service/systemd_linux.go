@@ -0,0 +1,95 @@
+//go:build linux
+// +build linux
+
+package service
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+// Run runs main, Pages only runs as a service on Windows
+func Run(main func()) error {
+	main()
+
+	return nil
+}
+
+// Stopping returns nil, only the Windows service control manager asks Pages
+// to stop, a nil channel is never ready
+func Stopping() <-chan struct{} {
+	return nil
+}
+
+// Ready notifies systemd that Pages is ready to serve requests, it does
+// nothing when Pages is not started by a Type=notify unit
+func Ready() error {
+	return notify("READY=1")
+}
+
+// Watchdog pings the watchdog of systemd at half its interval while healthy
+// returns true, until ctx is done, so systemd restarts Pages when it hangs. It
+// returns right away when the watchdog of the unit is disabled.
+func Watchdog(ctx context.Context, healthy func() bool) {
+	interval := watchdogInterval()
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !healthy() {
+				continue
+			}
+
+			if err := notify("WATCHDOG=1"); err != nil {
+				log.WithError(err).Error("failed to ping the systemd watchdog")
+			}
+		}
+	}
+}
+
+// notify sends state to the socket of systemd, which can be an abstract
+// socket starting with @
+func notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+
+	return err
+}
+
+// watchdogInterval returns the interval of the watchdog of systemd, or 0
+// when it is disabled or meant for another process
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
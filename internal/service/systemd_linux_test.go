@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func setenv(t *testing.T, key, value string) {
+	t.Helper()
+
+	previous, ok := os.LookupEnv(key)
+	require.NoError(t, os.Setenv(key, value))
+
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, previous)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func listenNotifySocket(t *testing.T) *net.UnixConn {
+	t.Helper()
+
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	setenv(t, "NOTIFY_SOCKET", socket)
+
+	return conn
+}
+
+func readState(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+
+	return string(buf[:n])
+}
+
+func TestReady(t *testing.T) {
+	conn := listenNotifySocket(t)
+
+	require.NoError(t, Ready())
+	require.Equal(t, "READY=1", readState(t, conn))
+}
+
+func TestReadyWithoutSystemd(t *testing.T) {
+	setenv(t, "NOTIFY_SOCKET", "")
+
+	require.NoError(t, Ready())
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	tests := map[string]struct {
+		usec     string
+		pid      string
+		expected time.Duration
+	}{
+		"disabled":      {},
+		"enabled":       {usec: "30000000", expected: 30 * time.Second},
+		"this_process":  {usec: "30000000", pid: strconv.Itoa(os.Getpid()), expected: 30 * time.Second},
+		"other_process": {usec: "30000000", pid: "1"},
+		"invalid":       {usec: "soon"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			setenv(t, "WATCHDOG_USEC", tt.usec)
+			setenv(t, "WATCHDOG_PID", tt.pid)
+
+			require.Equal(t, tt.expected, watchdogInterval())
+		})
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	conn := listenNotifySocket(t)
+	setenv(t, "WATCHDOG_USEC", "20000")
+	setenv(t, "WATCHDOG_PID", "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go Watchdog(ctx, func() bool { return true })
+
+	require.Equal(t, "WATCHDOG=1", readState(t, conn))
+	require.Equal(t, "WATCHDOG=1", readState(t, conn))
+}
+
+func TestWatchdogUnhealthy(t *testing.T) {
+	conn := listenNotifySocket(t)
+	setenv(t, "WATCHDOG_USEC", "20000")
+	setenv(t, "WATCHDOG_PID", "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	Watchdog(ctx, func() bool { return false })
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(10*time.Millisecond)))
+	_, err := conn.Read(make([]byte, 64))
+	require.Error(t, err, "the watchdog is not pinged while Pages is unhealthy")
+}
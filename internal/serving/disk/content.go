@@ -0,0 +1,71 @@
+package disk
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/bufferpool"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/logging"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+	vfsServing "gitlab.com/gitlab-org/gitlab-pages/internal/vfs/serving"
+)
+
+// serveRangedFile serves content with http.ServeContent, so ranges of it can
+// be requested, and logs the reads of content which is not of its size
+func serveRangedFile(w http.ResponseWriter, r *http.Request, name string, modtime time.Time, content vfs.SeekableFile) {
+	checked := &sizeCheckedFile{SeekableFile: content}
+
+	http.ServeContent(w, r, name, modtime, checked)
+
+	logSizeMismatch(r, checked.err)
+}
+
+// serveFile serves content of the Content-Length set in the headers of w,
+// the response is cut short when the content is not of its Content-Length,
+// so the client does not take it as complete
+func serveFile(w http.ResponseWriter, r *http.Request, modtime time.Time, content vfs.File) {
+	pooled := &pooledFile{File: content}
+
+	vfsServing.ServeCompressedFile(w, r, modtime, pooled)
+
+	logSizeMismatch(r, pooled.err)
+}
+
+func logSizeMismatch(r *http.Request, err error) {
+	if errors.Is(err, vfs.ErrSizeMismatch) {
+		logging.LogRequest(r).WithError(err).Error("could not serve content")
+	}
+}
+
+// sizeCheckedFile keeps the error of the reads of the file, which are not
+// returned by http.ServeContent
+type sizeCheckedFile struct {
+	vfs.SeekableFile
+	err error
+}
+
+func (f *sizeCheckedFile) Read(p []byte) (int, error) {
+	n, err := f.SeekableFile.Read(p)
+	if err != nil && err != io.EOF {
+		f.err = err
+	}
+
+	return n, err
+}
+
+// pooledFile is copied to the response with the pooled buffers, as the
+// middleware wrapping the response writer hides its io.ReaderFrom, and keeps
+// the error of the copy
+type pooledFile struct {
+	vfs.File
+	err error
+}
+
+func (f *pooledFile) WriteTo(w io.Writer) (int64, error) {
+	n, err := bufferpool.Copy(w, f.File)
+	f.err = err
+
+	return n, err
+}
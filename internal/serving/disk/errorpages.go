@@ -0,0 +1,189 @@
+package disk
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/httperrors"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+)
+
+// maxErrorPageSize is the size limit of the custom error pages of projects,
+// larger pages are not served
+const maxErrorPageSize = 64 * 1024
+
+// customErrorPageStatuses are the statuses of the custom error pages which
+// projects deploy as <status>.html, the 500.html page is served for the
+// other server errors
+var customErrorPageStatuses = []int{http.StatusInternalServerError, http.StatusServiceUnavailable}
+
+// errorPages are the custom error pages of a deployment
+type errorPages struct {
+	sha256 string
+	pages  map[int][]byte
+}
+
+// page returns the custom error page for the status, or nil when the project
+// has none
+func (ep *errorPages) page(status int) []byte {
+	if ep == nil || status < http.StatusInternalServerError {
+		return nil
+	}
+
+	if page, ok := ep.pages[status]; ok {
+		return page
+	}
+
+	return ep.pages[http.StatusInternalServerError]
+}
+
+// errorPagesLoad loads the custom error pages of a deployment, the concurrent
+// requests to the deployment wait for it to be done
+type errorPagesLoad struct {
+	done chan struct{}
+	ep   *errorPages
+}
+
+func errorPagesKey(lookupPath *serving.LookupPath) string {
+	if lookupPath.ProjectID != 0 {
+		return strconv.FormatUint(lookupPath.ProjectID, 10)
+	}
+
+	return lookupPath.Path
+}
+
+// loadErrorPages returns the custom error pages of the deployment of the
+// lookup path. They are loaded once per deployment and cached by project, so
+// the pages of the last deployment are served when the root of the lookup path
+// can not be opened.
+func (reader *Reader) loadErrorPages(ctx context.Context, root vfs.Root, lookupPath *serving.LookupPath) *errorPages {
+	if reader.errorPagesCache == nil {
+		return nil
+	}
+
+	key := errorPagesKey(lookupPath)
+	if ep := reader.deploymentErrorPages(key, lookupPath.SHA256); ep != nil {
+		return ep
+	}
+
+	loadKey := key + "@" + lookupPath.SHA256
+
+	reader.errorPagesLoadsMu.Lock()
+	if load, ok := reader.errorPagesLoads[loadKey]; ok {
+		reader.errorPagesLoadsMu.Unlock()
+
+		select {
+		case <-load.done:
+			return load.ep
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	load := &errorPagesLoad{done: make(chan struct{})}
+	if reader.errorPagesLoads == nil {
+		reader.errorPagesLoads = make(map[string]*errorPagesLoad)
+	}
+	reader.errorPagesLoads[loadKey] = load
+	reader.errorPagesLoadsMu.Unlock()
+
+	load.ep = &errorPages{sha256: lookupPath.SHA256}
+	for _, status := range customErrorPageStatuses {
+		if page := reader.readErrorPage(ctx, root, status); page != nil {
+			if load.ep.pages == nil {
+				load.ep.pages = make(map[int][]byte, len(customErrorPageStatuses))
+			}
+
+			load.ep.pages[status] = page
+		}
+	}
+
+	// the pages could not be read because the request was canceled
+	if ctx.Err() == nil {
+		reader.errorPagesCache.SetDefault(key, load.ep)
+	}
+
+	reader.errorPagesLoadsMu.Lock()
+	delete(reader.errorPagesLoads, loadKey)
+	reader.errorPagesLoadsMu.Unlock()
+	close(load.done)
+
+	return load.ep
+}
+
+// deploymentErrorPages returns the cached custom error pages of the project
+// with key when they are the pages of the deployment with sha256, or nil. The
+// pages are kept while the deployment is requested instead of being loaded
+// again when they expire.
+func (reader *Reader) deploymentErrorPages(key, sha256 string) *errorPages {
+	cached, expiration, ok := reader.errorPagesCache.GetWithExpiration(key)
+	if !ok {
+		return nil
+	}
+
+	ep := cached.(*errorPages)
+	if ep.sha256 != sha256 {
+		return nil
+	}
+
+	if time.Until(expiration) < defaultErrorPagesExpirationInterval/2 {
+		reader.errorPagesCache.SetDefault(key, ep)
+	}
+
+	return ep
+}
+
+// cachedErrorPages returns the custom error pages of the last deployment of
+// the lookup path which were loaded, or nil when none were
+func (reader *Reader) cachedErrorPages(lookupPath *serving.LookupPath) *errorPages {
+	if reader.errorPagesCache == nil {
+		return nil
+	}
+
+	cached, ok := reader.errorPagesCache.Get(errorPagesKey(lookupPath))
+	if !ok {
+		return nil
+	}
+
+	return cached.(*errorPages)
+}
+
+func (reader *Reader) readErrorPage(ctx context.Context, root vfs.Root, status int) []byte {
+	fullPath, err := reader.resolvePath(ctx, root, strconv.Itoa(status)+".html")
+	if err != nil {
+		return nil
+	}
+
+	file, err := root.Open(ctx, fullPath)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	page, err := io.ReadAll(io.LimitReader(file, maxErrorPageSize+1))
+	if err != nil || len(page) > maxErrorPageSize {
+		return nil
+	}
+
+	return page
+}
+
+// serveError serves the custom error page of the project for the status of
+// err, falling back to the error pages of Pages. The cached pages of the
+// project are served when root is nil.
+func (reader *Reader) serveError(w http.ResponseWriter, r *http.Request, root vfs.Root, lookupPath *serving.LookupPath, reason string, err error) {
+	category := httperrors.LogError(r, reason, err)
+
+	var ep *errorPages
+	if root != nil {
+		ep = reader.loadErrorPages(r.Context(), root, lookupPath)
+	} else {
+		ep = reader.cachedErrorPages(lookupPath)
+	}
+
+	httperrors.ServeErrorCategoryPage(w, category, ep.page(category.Status))
+}
@@ -0,0 +1,188 @@
+package disk
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/pageserrors"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs/local"
+)
+
+var errTestArchiveInvalid = pageserrors.New(pageserrors.ArchiveInvalid, "zip: not a valid zip file")
+
+// failingVFS fails to open the roots when rootErr is set, and the files of
+// its roots starting with failing
+type failingVFS struct {
+	local.VFS
+	rootErr error
+}
+
+func (f *failingVFS) Root(ctx context.Context, path string, cacheKey string) (vfs.Root, error) {
+	if f.rootErr != nil {
+		return nil, f.rootErr
+	}
+
+	root, err := f.VFS.Root(ctx, path, cacheKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &failingRoot{Root: root}, nil
+}
+
+type failingRoot struct {
+	vfs.Root
+}
+
+func (f *failingRoot) Open(ctx context.Context, name string) (vfs.File, error) {
+	if strings.HasPrefix(name, "failing") {
+		return nil, errors.New("read error")
+	}
+
+	return f.Root.Open(ctx, name)
+}
+
+func TestServeFileHTTPCustomErrorPages(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"500.html":     "custom 500",
+		"503.html":     "custom 503",
+		"failing.html": "",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	withoutPages := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(withoutPages, "failing.html"), nil, 0644))
+
+	large := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(large, "500.html"), make([]byte, maxErrorPageSize+1), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(large, "failing.html"), nil, 0644))
+
+	fs := &failingVFS{}
+	s := New(fs)
+
+	serve := func(projectID uint64, path string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://group.gitlab-example.com/project/failing.html", nil)
+
+		require.True(t, s.ServeFileHTTP(serving.Handler{
+			Writer:  w,
+			Request: r,
+			LookupPath: &serving.LookupPath{
+				Prefix:    "/project/",
+				Path:      path,
+				ProjectID: projectID,
+			},
+			SubPath: "failing.html",
+		}))
+
+		return w
+	}
+
+	t.Run("internal_error", func(t *testing.T) {
+		w := serve(1, dir)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Equal(t, "custom 500", w.Body.String())
+		require.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	})
+
+	t.Run("without_custom_pages", func(t *testing.T) {
+		w := serve(2, withoutPages)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "Whoops, something went wrong on our end.")
+	})
+
+	t.Run("too_large", func(t *testing.T) {
+		w := serve(3, large)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "Whoops, something went wrong on our end.")
+	})
+
+	t.Run("root_unavailable", func(t *testing.T) {
+		fs.rootErr = errTestArchiveInvalid
+		defer func() { fs.rootErr = nil }()
+
+		w := serve(1, dir)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Equal(t, "custom 500", w.Body.String(), "the pages of the last deployment are served")
+
+		w = serve(4, dir)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "Whoops, something went wrong on our end.", "the pages of the project were never loaded")
+	})
+}
+
+func TestErrorPagesPage(t *testing.T) {
+	ep := &errorPages{pages: map[int][]byte{
+		http.StatusInternalServerError: []byte("500"),
+		http.StatusServiceUnavailable:  []byte("503"),
+	}}
+
+	require.Equal(t, []byte("500"), ep.page(http.StatusInternalServerError))
+	require.Equal(t, []byte("503"), ep.page(http.StatusServiceUnavailable))
+	require.Equal(t, []byte("500"), ep.page(http.StatusBadGateway), "500.html is served for other server errors")
+	require.Nil(t, ep.page(http.StatusNotFound))
+	require.Nil(t, (*errorPages)(nil).page(http.StatusInternalServerError))
+}
+
+// countingRoot counts the opened error pages
+type countingRoot struct {
+	vfs.Root
+	opened *int32
+}
+
+func (c *countingRoot) Open(ctx context.Context, name string) (vfs.File, error) {
+	if name == "500.html" {
+		atomic.AddInt32(c.opened, 1)
+	}
+
+	return c.Root.Open(ctx, name)
+}
+
+func TestLoadErrorPagesOncePerDeployment(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "500.html"), []byte("custom 500"), 0644))
+
+	reader := &New(&local.VFS{}).(*Disk).reader
+
+	localRoot, err := (&local.VFS{}).Root(context.Background(), dir, "")
+	require.NoError(t, err)
+
+	var opened int32
+	root := &countingRoot{Root: localRoot, opened: &opened}
+
+	load := func(sha256 string) *errorPages {
+		return reader.loadErrorPages(context.Background(), root, &serving.LookupPath{ProjectID: 1, Path: dir, SHA256: sha256})
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.Equal(t, []byte("custom 500"), load("first").page(http.StatusInternalServerError))
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&opened), "the pages are loaded once for the deployment")
+
+	load("second")
+	require.Equal(t, int32(2), atomic.LoadInt32(&opened), "the pages of a new deployment are loaded")
+}
@@ -31,6 +31,24 @@ func endsWithSlash(path string) bool {
 	return strings.HasSuffix(path, "/")
 }
 
+// isHiddenPath returns true when a file or directory of subPath starts with a
+// dot, e.g. .git/config, the .well-known directory at the root is not hidden
+func isHiddenPath(subPath string) bool {
+	for i, name := range strings.Split(strings.TrimPrefix(subPath, "/"), "/") {
+		if name == "." || name == ".." || !strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		if i == 0 && name == ".well-known" {
+			continue
+		}
+
+		return true
+	}
+
+	return false
+}
+
 func endsWithoutHTMLExtension(path string) bool {
 	return !strings.HasSuffix(path, ".html")
 }
@@ -78,6 +96,11 @@ func (reader *Reader) handleContentEncoding(ctx context.Context, w http.Response
 		}
 	}
 
+	// caches must not send a compressed variant to clients not accepting it
+	if len(files) > 0 {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+
 	offers := make([]string, 0, len(files)+1)
 	for _, encoding := range compressedEncodingsPriority {
 		if _, ok := files[encoding]; ok {
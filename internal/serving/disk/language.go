@@ -0,0 +1,123 @@
+package disk
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+)
+
+const (
+	// maxLanguageCandidates limits the number of index files looked up for a
+	// single request
+	maxLanguageCandidates = 10
+	// maxLanguageTagLength is the longest language tag accepted, see RFC 5646
+	maxLanguageTagLength = 35
+)
+
+type weightedLanguage struct {
+	tag     string
+	quality float64
+}
+
+// acceptedLanguages parses the Accept-Language header and returns the
+// language tags to look up, sorted by preference. Each tag is followed by
+// its primary language, so `pt-BR` falls back to `pt`. Wildcards, tags with
+// a quality of 0 and malformed tags are ignored.
+func acceptedLanguages(header string) []string {
+	var weighted []weightedLanguage
+
+	for _, part := range strings.Split(header, ",") {
+		tag, params := part, ""
+		if i := strings.Index(part, ";"); i >= 0 {
+			tag, params = part[:i], part[i+1:]
+		}
+
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !validLanguageTag(tag) {
+			continue
+		}
+
+		quality := languageQuality(params)
+		if quality <= 0 {
+			continue
+		}
+
+		weighted = append(weighted, weightedLanguage{tag: tag, quality: quality})
+	}
+
+	sort.SliceStable(weighted, func(i, j int) bool {
+		return weighted[i].quality > weighted[j].quality
+	})
+
+	var languages []string
+	seen := make(map[string]bool)
+
+	add := func(tag string) {
+		if !seen[tag] && len(languages) < maxLanguageCandidates {
+			seen[tag] = true
+			languages = append(languages, tag)
+		}
+	}
+
+	for _, l := range weighted {
+		add(l.tag)
+
+		if i := strings.Index(l.tag, "-"); i > 0 {
+			add(l.tag[:i])
+		}
+	}
+
+	return languages
+}
+
+func languageQuality(params string) float64 {
+	for _, param := range strings.Split(params, ";") {
+		param = strings.TrimSpace(param)
+		if !strings.HasPrefix(param, "q=") {
+			continue
+		}
+
+		quality, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+		if err != nil || quality > 1 {
+			return 0
+		}
+
+		return quality
+	}
+
+	return 1
+}
+
+// validLanguageTag only accepts letters, digits and dashes so that tags
+// can safely be used as part of a file name
+func validLanguageTag(tag string) bool {
+	if tag == "" || len(tag) > maxLanguageTagLength || tag[0] == '-' {
+		return false
+	}
+
+	for _, c := range tag {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// resolveLanguageIndex returns the path to the `index.<lang>.html` file that
+// best matches the Accept-Language of the request, and its language. It
+// returns an empty language when none of the variants exist.
+func (reader *Reader) resolveLanguageIndex(ctx context.Context, root vfs.Root, r *http.Request, subPath string) (string, string) {
+	for _, lang := range acceptedLanguages(r.Header.Get("Accept-Language")) {
+		fullPath, err := reader.resolvePath(ctx, root, subPath, "index."+lang+".html")
+		if err == nil {
+			return fullPath, lang
+		}
+	}
+
+	return "", ""
+}
@@ -0,0 +1,60 @@
+package disk
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcceptedLanguages(t *testing.T) {
+	tests := map[string]struct {
+		header   string
+		expected []string
+	}{
+		"empty": {
+			header: "",
+		},
+		"single_language": {
+			header:   "fr",
+			expected: []string{"fr"},
+		},
+		"region_falls_back_to_language": {
+			header:   "pt-BR",
+			expected: []string{"pt-br", "pt"},
+		},
+		"sorted_by_quality": {
+			header:   "en;q=0.5, de-CH, fr;q=0.8",
+			expected: []string{"de-ch", "de", "fr", "en"},
+		},
+		"same_quality_keeps_order": {
+			header:   "es;q=0.7, it;q=0.7",
+			expected: []string{"es", "it"},
+		},
+		"ignores_wildcard_and_zero_quality": {
+			header:   "*, de;q=0, nl",
+			expected: []string{"nl"},
+		},
+		"ignores_invalid_quality": {
+			header:   "de;q=abc, nl;q=2, en",
+			expected: []string{"en"},
+		},
+		"ignores_path_traversal": {
+			header:   "../../secret, en/../x, fr",
+			expected: []string{"fr"},
+		},
+		"duplicates": {
+			header:   "en-US, en-GB, en",
+			expected: []string{"en-us", "en", "en-gb"},
+		},
+		"limits_candidates": {
+			header:   "a1-b,a2-b,a3-b,a4-b,a5-b,a6-b",
+			expected: []string{"a1-b", "a1", "a2-b", "a2", "a3-b", "a3", "a4-b", "a4", "a5-b", "a5"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tt.expected, acceptedLanguages(tt.header))
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package disk
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+)
+
+var listingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Index of {{.Path}}</title>
+</head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<ul>
+{{- if .Parent}}
+<li><a href="../">../</a></li>
+{{- end}}
+{{- range .Entries}}
+<li><a href="{{.Href}}">{{.Name}}</a>{{if .Size}} ({{.Size}} bytes){{end}}</li>
+{{- end}}
+</ul>
+</body>
+</html>
+`))
+
+type listing struct {
+	Path    string
+	Parent  bool
+	Entries []listingEntry
+}
+
+type listingEntry struct {
+	Name string
+	Href string
+	// Size is empty for directories
+	Size string
+}
+
+// listingEnabled returns true when the directories without an index.html of
+// the lookup path are served with a generated index
+func (reader *Reader) listingEnabled(lookupPath *serving.LookupPath) bool {
+	return reader.directoryListing || lookupPath.DirectoryListing
+}
+
+// serveListing serves the generated index of the files of the directory
+// fullPath. The files which are not served, like dotfiles or files with a
+// blocked extension, are not listed.
+func (reader *Reader) serveListing(ctx context.Context, root vfs.Root, h serving.Handler, fullPath string) bool {
+	infos, err := root.Readdir(ctx, fullPath)
+	if err != nil {
+		reader.serveError(h.Writer, h.Request, root, h.LookupPath, "root.Readdir", err)
+		return true
+	}
+
+	l := listing{
+		Path:   h.Request.URL.Path,
+		Parent: strings.Trim(h.SubPath, "/") != "",
+	}
+
+	hideDotfiles := reader.dotfiles == config.DotfilesIgnore || reader.dotfiles == config.DotfilesDeny
+
+	for _, fi := range infos {
+		name := fi.Name()
+
+		if hideDotfiles && isHiddenPath(name) {
+			continue
+		}
+
+		entry := listingEntry{Name: name, Href: url.PathEscape(name)}
+		if fi.IsDir() {
+			entry.Name += "/"
+			entry.Href += "/"
+		} else if reader.isBlocked(h.LookupPath, name) {
+			continue
+		} else {
+			entry.Size = strconv.FormatInt(fi.Size(), 10)
+		}
+
+		l.Entries = append(l.Entries, entry)
+	}
+
+	var body bytes.Buffer
+	if err := listingTemplate.Execute(&body, l); err != nil {
+		reader.serveError(h.Writer, h.Request, root, h.LookupPath, "listingTemplate.Execute", err)
+		return true
+	}
+
+	h.Writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	h.Writer.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+	h.Writer.WriteHeader(http.StatusOK)
+
+	if h.Request.Method != http.MethodHead {
+		h.Writer.Write(body.Bytes())
+	}
+
+	return true
+}
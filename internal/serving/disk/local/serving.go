@@ -1,16 +1,62 @@
 package local
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/serving/disk"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving/shadow"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs/local"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs/zip"
 )
 
-var instance = disk.New(vfs.Instrumented(&local.VFS{}))
+// instance serves a sample of the requests again from the public.zip archive
+// next to the public directory they are served from, to compare the disk and
+// zip servings before moving the deployments to archives
+var instance = shadow.New(
+	disk.New(vfs.Instrumented(&local.VFS{})),
+	func() serving.Serving {
+		return disk.New(vfs.Instrumented(zip.New(&config.ZipServing{})))
+	},
+	archiveLookupPath,
+	func(cfg *config.Config) float64 { return cfg.Zip.ShadowSampleRate },
+)
 
 // Instance returns a serving instance that is capable of reading files
 // from the disk
 func Instance() serving.Serving {
 	return instance
 }
+
+// archiveLookupPath returns the lookup path of the public.zip archive next to
+// the public directory of lookupPath, keyed by its modification time so a
+// new archive is not served from the cache of the previous one
+func archiveLookupPath(lookupPath *serving.LookupPath) (*serving.LookupPath, bool) {
+	dir := strings.TrimSuffix(lookupPath.Path, "/")
+	if filepath.Base(dir) != "public" {
+		return nil, false
+	}
+
+	archive, err := filepath.Abs(dir + ".zip")
+	if err != nil {
+		return nil, false
+	}
+
+	fi, err := os.Stat(archive)
+	if err != nil || !fi.Mode().IsRegular() {
+		return nil, false
+	}
+
+	url := "file://" + filepath.ToSlash(archive)
+
+	archiveLookupPath := *lookupPath
+	archiveLookupPath.Path = url
+	archiveLookupPath.SHA256 = fmt.Sprintf("%s@%d", url, fi.ModTime().UnixNano())
+
+	return &archiveLookupPath, true
+}
@@ -4,19 +4,27 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"io/fs"
 	"net/http"
+	"net/url"
+	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/patrickmn/go-cache"
 	"github.com/prometheus/client_golang/prometheus"
 	"gitlab.com/gitlab-org/labkit/errortracking"
 
+	"gitlab.com/gitlab-org/gitlab-pages/internal/bufferpool"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/headers"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httperrors"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/logging"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/lru"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/redirects"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/request"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/serving/disk/symlink"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
@@ -27,8 +35,57 @@ import (
 type Reader struct {
 	fileSizeMetric *prometheus.HistogramVec
 	vfs            vfs.VFS
+	// directoryRedirectStatus is the status of the redirects of directories
+	// requested without a trailing slash
+	directoryRedirectStatus int
+	// dotfiles is the policy applied to the paths with a file or directory
+	// starting with a dot, see config.DotfilesAllow
+	dotfiles string
+	// trailingSlash is the policy applied to the trailing slashes of the
+	// paths of directories and extensionless pages, see
+	// config.TrailingSlashPreserve
+	trailingSlash string
+	// cleanURLs serves the directories requested without a trailing slash
+	// from their page with the .html extension, then from their index
+	cleanURLs bool
+	// blockedExtensions are the lowercase extensions of the files which are
+	// not served, unless the domain opted out
+	blockedExtensions map[string]struct{}
+	// headersCache keeps the parsed _headers files of deployments by their
+	// SHA256
+	headersCache *lru.Cache
+	// redirectsCache keeps the parsed _redirects files of deployments by
+	// their SHA256
+	redirectsCache *lru.Cache
+	// directoryListing serves a generated index of the directories without
+	// an index.html for all lookup paths
+	directoryListing bool
+	// indexFiles are the names of the files served for directories, unless
+	// the lookup path overrides them
+	indexFiles []string
+	// errorPagesCache keeps the custom error pages of the last deployment
+	// of projects by project
+	errorPagesCache *cache.Cache
+	// errorPagesLoads are the deployments whose custom error pages are being
+	// loaded, so they are loaded once for the concurrent requests
+	errorPagesLoads   map[string]*errorPagesLoad
+	errorPagesLoadsMu sync.Mutex
+	// cacheControl are the Cache-Control values of the public files by
+	// pattern, in order of precedence
+	cacheControl []config.CacheControlRule
+	// allowHeader is the Allow header of the responses to OPTIONS requests,
+	// the methods allowed by the instance
+	allowHeader string
 }
 
+// defaultAllowHeader is the Allow header of the responses to OPTIONS requests
+// when the allowed methods are not configured
+const defaultAllowHeader = "GET, HEAD, OPTIONS"
+
+// defaultIndexFiles are served for directories when neither the instance nor
+// the lookup path configured index files
+var defaultIndexFiles = []string{"index.html"}
+
 // Show the user some validation messages for their _redirects file
 func (reader *Reader) serveRedirectsStatus(h serving.Handler, redirects *redirects.Redirects) {
 	h.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
@@ -37,6 +94,92 @@ func (reader *Reader) serveRedirectsStatus(h serving.Handler, redirects *redirec
 	fmt.Fprintln(h.Writer, redirects.Status())
 }
 
+// Show the user the rules and errors of their _headers file
+func (reader *Reader) serveHeadersStatus(h serving.Handler, headers *headers.Headers) {
+	h.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	h.Writer.Header().Set("X-Content-Type-Options", "nosniff")
+	h.Writer.WriteHeader(http.StatusOK)
+	fmt.Fprintln(h.Writer, headers.Status())
+}
+
+// headers returns the rules of the _headers file of the deployment, they are
+// cached when the deployment has a SHA256
+func (reader *Reader) headers(ctx context.Context, root vfs.Root, lookupPath *serving.LookupPath) *headers.Headers {
+	if lookupPath.SHA256 == "" || reader.headersCache == nil {
+		return headers.ParseHeaders(ctx, root)
+	}
+
+	h, err := reader.headersCache.FindOrFetch("", lookupPath.SHA256, func() (interface{}, error) {
+		parsed := headers.ParseHeaders(ctx, root)
+
+		// the file could not be read because the request was canceled
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		return parsed, nil
+	})
+	if err != nil {
+		return &headers.Headers{}
+	}
+
+	return h.(*headers.Headers)
+}
+
+// redirects returns the rules of the _redirects file of the deployment, they
+// are cached when the deployment has a SHA256
+func (reader *Reader) redirects(ctx context.Context, root vfs.Root, lookupPath *serving.LookupPath) *redirects.Redirects {
+	if lookupPath.SHA256 == "" || reader.redirectsCache == nil {
+		return redirects.ParseRedirects(ctx, root, lookupPath.FeatureFlags)
+	}
+
+	r, err := reader.redirectsCache.FindOrFetch("", lookupPath.SHA256, func() (interface{}, error) {
+		parsed := redirects.ParseRedirects(ctx, root, nil)
+
+		// the file could not be read because the request was canceled
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		return parsed, nil
+	})
+	if err != nil {
+		return &redirects.Redirects{}
+	}
+
+	return r.(*redirects.Redirects).WithFlags(lookupPath.FeatureFlags)
+}
+
+// tryForcedRedirects returns true if it successfully handled request with a
+// forced rule, forced rules are applied even if the requested file exists
+func (reader *Reader) tryForcedRedirects(h serving.Handler) bool {
+	ctx := h.Request.Context()
+
+	root, served := reader.root(h)
+	if root == nil {
+		return served
+	}
+
+	r := reader.redirects(ctx, root, h.LookupPath)
+
+	scheme := request.SchemeHTTP
+	if request.IsHTTPS(h.Request) {
+		scheme = request.SchemeHTTPS
+	}
+
+	// domain-level rules are evaluated before path rules
+	rewrittenURL, status, err := r.RewriteDomain(scheme, request.GetHostWithoutPort(h.Request), h.Request.URL)
+	if err != redirects.ErrNoRedirect {
+		return reader.applyRewrite(h, rewrittenURL, status, err)
+	}
+
+	rewrittenURL, status, err = r.RewriteForced(h.Request.URL, func() bool {
+		return reader.fileExists(ctx, root, h.LookupPath, h.SubPath)
+	})
+
+	return reader.applyRewrite(h, rewrittenURL, status, err)
+}
+
 // tryRedirects returns true if it successfully handled request
 func (reader *Reader) tryRedirects(h serving.Handler) bool {
 	ctx := h.Request.Context()
@@ -46,9 +189,14 @@ func (reader *Reader) tryRedirects(h serving.Handler) bool {
 		return served
 	}
 
-	r := redirects.ParseRedirects(ctx, root)
+	r := reader.redirects(ctx, root, h.LookupPath)
 
 	rewrittenURL, status, err := r.Rewrite(h.Request.URL)
+
+	return reader.applyRewrite(h, rewrittenURL, status, err)
+}
+
+func (reader *Reader) applyRewrite(h serving.Handler, rewrittenURL *url.URL, status int, err error) bool {
 	if err != nil {
 		if err != redirects.ErrNoRedirect {
 			// We assume that rewrite failure is not fatal
@@ -63,14 +211,46 @@ func (reader *Reader) tryRedirects(h serving.Handler) bool {
 		return reader.tryFile(h)
 	}
 
-	http.Redirect(h.Writer, h.Request, rewrittenURL.Path, status)
+	// domain-level rules can redirect to other sites
+	if rewrittenURL.Host != "" {
+		http.Redirect(h.Writer, h.Request, rewrittenURL.String(), status)
+		return true
+	}
+
+	http.Redirect(h.Writer, h.Request, rewrittenURL.EscapedPath(), status)
 	return true
 }
 
+// fileExists returns true if tryFile would serve a file for subPath
+func (reader *Reader) fileExists(ctx context.Context, root vfs.Root, lookupPath *serving.LookupPath, subPath string) bool {
+	_, err := reader.resolvePath(ctx, root, subPath)
+
+	if locationError, _ := err.(*locationDirectoryError); locationError != nil {
+		_, err = reader.resolveIndexFile(ctx, root, lookupPath, subPath)
+	}
+
+	if locationError, _ := err.(*locationFileNoExtensionError); locationError != nil {
+		_, err = reader.resolvePath(ctx, root, strings.TrimSuffix(subPath, "/")+".html")
+	}
+
+	return err == nil
+}
+
 // tryFile returns true if it successfully handled request
 func (reader *Reader) tryFile(h serving.Handler) bool {
 	ctx := h.Request.Context()
 
+	if isHiddenPath(h.SubPath) {
+		switch reader.dotfiles {
+		case config.DotfilesIgnore:
+			// served as if the file did not exist
+			return false
+		case config.DotfilesDeny:
+			httperrors.Serve403(h.Writer)
+			return true
+		}
+	}
+
 	root, served := reader.root(h)
 	if root == nil {
 		return served
@@ -81,34 +261,259 @@ func (reader *Reader) tryFile(h serving.Handler) bool {
 	request := h.Request
 	urlPath := request.URL.Path
 
+	// the root of the lookup path keeps its trailing slash, whatever the policy
+	isRoot := strings.Trim(h.SubPath, "/") == ""
+	trailingSlash := reader.trailingSlash
+	if isRoot {
+		trailingSlash = config.TrailingSlashPreserve
+	}
+
+	if _, ok := err.(*locationDirectoryError); ok && reader.cleanURLs && !isRoot && !endsWithSlash(urlPath) {
+		if cleanPath, cleanErr := reader.resolveCleanURL(ctx, root, h); cleanErr == nil {
+			fullPath, err = cleanPath, nil
+		}
+	}
+
 	if locationError, _ := err.(*locationDirectoryError); locationError != nil {
-		if endsWithSlash(urlPath) {
-			fullPath, err = reader.resolvePath(ctx, root, h.SubPath, "index.html")
-		} else {
-			http.Redirect(h.Writer, h.Request, redirectPath(h.Request), http.StatusFound)
-			return true
+		if !endsWithSlash(urlPath) && trailingSlash != config.TrailingSlashNever {
+			return reader.redirectDirectory(ctx, root, h)
+		}
+
+		fullPath, err = reader.resolveIndex(ctx, root, h)
+		if err != nil && reader.listingEnabled(h.LookupPath) {
+			// the relative links of listings need the trailing slash
+			if !endsWithSlash(urlPath) {
+				return reader.redirectDirectory(ctx, root, h)
+			}
+
+			return reader.serveListing(ctx, root, h, locationError.FullPath)
+		}
+
+		if err == nil && endsWithSlash(urlPath) && trailingSlash == config.TrailingSlashNever {
+			return reader.redirectTrailingSlash(h, redirectPathWithoutSlash(request))
 		}
 	}
 
 	if locationError, _ := err.(*locationFileNoExtensionError); locationError != nil {
 		fullPath, err = reader.resolvePath(ctx, root, strings.TrimSuffix(h.SubPath, "/")+".html")
+
+		if err == nil {
+			switch {
+			case trailingSlash == config.TrailingSlashAlways && !endsWithSlash(urlPath):
+				return reader.redirectTrailingSlash(h, redirectPath(request))
+			case trailingSlash == config.TrailingSlashNever && endsWithSlash(urlPath):
+				return reader.redirectTrailingSlash(h, redirectPathWithoutSlash(request))
+			}
+		}
 	}
 
 	if err != nil {
 		// We assume that this is mostly missing file type of the error
 		// and additional handlers should try to process the request
+		return reader.redirectCanonicalCase(ctx, root, h)
+	}
+
+	// the resolved path is checked, so blocked files are not served through
+	// symlinks either
+	if reader.isBlocked(h.LookupPath, fullPath) {
 		return false
 	}
 
 	// Serve status of `_redirects` under `_redirects`
 	// We check if the final resolved path is `_redirects` after symlink traversal
 	if fullPath == redirects.ConfigFile {
-		r := redirects.ParseRedirects(ctx, root)
+		r := reader.redirects(ctx, root, h.LookupPath)
 		reader.serveRedirectsStatus(h, r)
 		return true
 	}
 
-	return reader.serveFile(ctx, h.Writer, h.Request, root, fullPath, h.LookupPath.SHA256, h.LookupPath.HasAccessControl)
+	// Serve status of `_headers` under `_headers`
+	if fullPath == headers.ConfigFile {
+		reader.serveHeadersStatus(h, reader.headers(ctx, root, h.LookupPath))
+		return true
+	}
+
+	return reader.serveFile(ctx, h.Writer, h.Request, root, fullPath, h.LookupPath)
+}
+
+// tryFallbackPrefix returns true if it served the requested path from the
+// fallback prefix of the lookup path instead of its top-level directory, so
+// the pages of versioned documentation missing from older versions are
+// served from e.g. /latest/
+func (reader *Reader) tryFallbackPrefix(h serving.Handler) bool {
+	fallback := h.LookupPath.FallbackPrefix
+	if fallback == "" {
+		return false
+	}
+
+	subPath := strings.TrimPrefix(h.SubPath, "/")
+
+	i := strings.Index(subPath, "/")
+	if i <= 0 || subPath[:i] == fallback {
+		return false
+	}
+
+	h.SubPath = fallback + subPath[i:]
+
+	return reader.tryFile(h)
+}
+
+// trySPAFallback returns true if it served the root index file of the lookup
+// path for a path not found, when the lookup path opted in, so single-page
+// applications can route their paths on the client
+func (reader *Reader) trySPAFallback(h serving.Handler) bool {
+	if !h.LookupPath.SPAFallback {
+		return false
+	}
+
+	for _, name := range reader.indexFileNames(h.LookupPath) {
+		h.SubPath = name
+
+		if reader.tryFile(h) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redirectCanonicalCase returns true if it redirected the request to the file
+// or directory matching its path ignoring case, when the lookup path opted
+// in, so sites migrated from case-insensitive hosts keep their links working
+func (reader *Reader) redirectCanonicalCase(ctx context.Context, root vfs.Root, h serving.Handler) bool {
+	if !h.LookupPath.CaseInsensitive {
+		return false
+	}
+
+	ciRoot, ok := root.(vfs.CaseInsensitiveRoot)
+	if !ok {
+		return false
+	}
+
+	subPath := strings.Trim(h.SubPath, "/")
+	urlPath := strings.TrimSuffix(h.Request.URL.Path, "/")
+
+	// paths rewritten by _redirects or the fallback prefix are not redirected
+	if subPath == "" || !strings.HasSuffix(urlPath, "/"+subPath) {
+		return false
+	}
+
+	canonical, err := ciRoot.CanonicalName(ctx, subPath)
+	if err != nil || canonical == subPath {
+		return false
+	}
+
+	// path.Clean collapses the leading slashes of e.g. //evil.com/Index.html
+	// so the location can not be read as a network-path reference to
+	// another host
+	location := url.URL{
+		Path:     path.Clean(strings.TrimSuffix(urlPath, subPath) + canonical),
+		RawQuery: h.Request.URL.RawQuery,
+	}
+	if endsWithSlash(h.Request.URL.Path) {
+		location.Path += "/"
+	}
+
+	http.Redirect(h.Writer, h.Request, location.String(), http.StatusMovedPermanently)
+	return true
+}
+
+// isBlocked returns true when the extension of fullPath is blocked for the
+// lookup path
+func (reader *Reader) isBlocked(lookupPath *serving.LookupPath, fullPath string) bool {
+	if len(reader.blockedExtensions) == 0 || lookupPath.ServeBlockedExtensions {
+		return false
+	}
+
+	_, blocked := reader.blockedExtensions[strings.ToLower(path.Ext(fullPath))]
+
+	return blocked
+}
+
+// resolveIndex returns the path to the index file of a directory. Projects
+// with language negotiation enabled get the `index.<lang>.html` matching the
+// Accept-Language of the request, falling back to the index files.
+func (reader *Reader) resolveIndex(ctx context.Context, root vfs.Root, h serving.Handler) (string, error) {
+	if h.LookupPath.LanguageNegotiation {
+		h.Writer.Header().Add("Vary", "Accept-Language")
+
+		if fullPath, lang := reader.resolveLanguageIndex(ctx, root, h.Request, h.SubPath); lang != "" {
+			h.Writer.Header().Set("Content-Language", lang)
+			return fullPath, nil
+		}
+	}
+
+	return reader.resolveIndexFile(ctx, root, h.LookupPath, h.SubPath)
+}
+
+// resolveCleanURL returns the path to the file serving the directory of the
+// request with clean URLs: the page with its name and the .html extension,
+// otherwise its index
+func (reader *Reader) resolveCleanURL(ctx context.Context, root vfs.Root, h serving.Handler) (string, error) {
+	if fullPath, err := reader.resolvePath(ctx, root, h.SubPath+".html"); err == nil {
+		return fullPath, nil
+	}
+
+	return reader.resolveIndex(ctx, root, h)
+}
+
+// indexFileNames returns the names of the files served for the directories
+// of the lookup path, in order of preference
+func (reader *Reader) indexFileNames(lookupPath *serving.LookupPath) []string {
+	if len(lookupPath.IndexFiles) > 0 {
+		return lookupPath.IndexFiles
+	}
+
+	if len(reader.indexFiles) > 0 {
+		return reader.indexFiles
+	}
+
+	return defaultIndexFiles
+}
+
+// resolveIndexFile returns the path to the first index file of the lookup
+// path found in the directory subPath
+func (reader *Reader) resolveIndexFile(ctx context.Context, root vfs.Root, lookupPath *serving.LookupPath, subPath string) (string, error) {
+	var err error
+
+	for _, name := range reader.indexFileNames(lookupPath) {
+		var fullPath string
+		if fullPath, err = reader.resolvePath(ctx, root, subPath, name); err == nil {
+			return fullPath, nil
+		}
+	}
+
+	return "", err
+}
+
+// redirectDirectory redirects a directory requested without a trailing slash
+// to its canonical form with a slash, so the relative links of its index
+// resolve to the directory. Directories without an index are not served,
+// unless their listing is enabled.
+func (reader *Reader) redirectDirectory(ctx context.Context, root vfs.Root, h serving.Handler) bool {
+	if _, err := reader.resolveIndexFile(ctx, root, h.LookupPath, h.SubPath); err != nil && !reader.listingEnabled(h.LookupPath) {
+		if !h.LookupPath.LanguageNegotiation {
+			return false
+		}
+
+		if _, lang := reader.resolveLanguageIndex(ctx, root, h.Request, h.SubPath); lang == "" {
+			return false
+		}
+	}
+
+	return reader.redirectTrailingSlash(h, redirectPath(h.Request))
+}
+
+// redirectTrailingSlash redirects the request to location, its path with or
+// without a trailing slash, with the status of the directory redirects
+func (reader *Reader) redirectTrailingSlash(h serving.Handler, location string) bool {
+	status := reader.directoryRedirectStatus
+	if status == 0 {
+		status = http.StatusMovedPermanently
+	}
+
+	http.Redirect(h.Writer, h.Request, location, status)
+	return true
 }
 
 func redirectPath(request *http.Request) string {
@@ -118,6 +523,27 @@ func redirectPath(request *http.Request) string {
 	url.Scheme = ""
 	url.Host = request.Host
 	url.Path = strings.TrimPrefix(url.Path, "/") + "/"
+	// keep the original encoding of the path, e.g. `%2F`
+	if url.RawPath != "" {
+		url.RawPath = strings.TrimPrefix(url.RawPath, "/") + "/"
+	}
+
+	return strings.TrimSuffix(url.String(), "?")
+}
+
+// redirectPathWithoutSlash is redirectPath without the trailing slashes of the
+// path of request
+func redirectPathWithoutSlash(request *http.Request) string {
+	url := *request.URL
+
+	// This ensures that path starts with `//<host>/`
+	url.Scheme = ""
+	url.Host = request.Host
+	url.Path = strings.TrimPrefix(strings.TrimRight(url.Path, "/"), "/")
+	// keep the original encoding of the path, e.g. `%2F`
+	if url.RawPath != "" {
+		url.RawPath = strings.TrimPrefix(strings.TrimRight(url.RawPath, "/"), "/")
+	}
 
 	return strings.TrimSuffix(url.String(), "?")
 }
@@ -145,7 +571,7 @@ func (reader *Reader) tryNotFound(h serving.Handler) bool {
 			return false
 		}
 
-		httperrors.Serve500WithRequest(h.Writer, h.Request, "serveCustomFile", err)
+		reader.serveError(h.Writer, h.Request, root, h.LookupPath, "serveCustomFile", err)
 		return true
 	}
 
@@ -153,7 +579,7 @@ func (reader *Reader) tryNotFound(h serving.Handler) bool {
 }
 
 // Resolve the HTTP request to a path on disk, converting requests for
-// directories to requests for index.html inside the directory if appropriate.
+// directories to requests for index files inside the directory if appropriate.
 func (reader *Reader) resolvePath(ctx context.Context, root vfs.Root, subPath ...string) (string, error) {
 	// Don't use filepath.Join as cleans the path,
 	// where we want to traverse full path as supplied by user
@@ -193,58 +619,205 @@ func (reader *Reader) resolvePath(ctx context.Context, root vfs.Root, subPath ..
 	return fullPath, nil
 }
 
-func (reader *Reader) serveFile(ctx context.Context, w http.ResponseWriter, r *http.Request, root vfs.Root, origPath, sha string, accessControl bool) bool {
+// setCacheControl sets the caching headers of the public file at filePath,
+// from the first matching rule or caching it for 10 minutes
+func (reader *Reader) setCacheControl(header http.Header, filePath string) {
+	for _, rule := range reader.cacheControl {
+		if rule.Match(filePath) {
+			header.Set("Cache-Control", rule.Value)
+			return
+		}
+	}
+
+	header.Set("Cache-Control", "max-age=600")
+	header.Set("Expires", time.Now().Add(10*time.Minute).Format(time.RFC1123))
+}
+
+func (reader *Reader) serveFile(ctx context.Context, w http.ResponseWriter, r *http.Request, root vfs.Root, origPath string, lookupPath *serving.LookupPath) bool {
 	fullPath := reader.handleContentEncoding(ctx, w, r, root, origPath)
 
-	file, err := root.Open(ctx, fullPath)
+	fi, err := root.Lstat(ctx, fullPath)
 	if err != nil {
-		httperrors.Serve500WithRequest(w, r, "root.Open", err)
+		reader.serveError(w, r, root, lookupPath, "root.Lstat", err)
 		return true
 	}
 
-	defer file.Close()
-
-	fi, err := root.Lstat(ctx, fullPath)
-	if err != nil {
-		httperrors.Serve500WithRequest(w, r, "root.Lstat", err)
+	// OPTIONS requests are answered with the allowed methods, without
+	// opening the file
+	if r.Method == http.MethodOptions {
+		reader.headers(ctx, root, lookupPath).Apply(w.Header(), r.URL.Path, lookupPath.HasAccessControl)
+		w.Header().Set("Allow", reader.allowHeaderValue())
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(http.StatusOK)
 		return true
 	}
 
 	ce := w.Header().Get("Content-Encoding")
-	w.Header().Set("ETag", fmt.Sprintf("%q", etag(ce, sha)))
+	sha := languageETag(w.Header().Get("Content-Language"), fileETag(fi, lookupPath))
+	w.Header().Set("ETag", `"`+etag(ce, sha)+`"`)
 
-	if !accessControl {
-		// Set caching headers
-		w.Header().Set("Cache-Control", "max-age=600")
-		w.Header().Set("Expires", time.Now().Add(10*time.Minute).Format(time.RFC1123))
+	if !lookupPath.HasAccessControl {
+		reader.setCacheControl(w.Header(), origPath)
 	}
 
-	contentType, err := reader.detectContentType(ctx, root, origPath)
+	reader.headers(ctx, root, lookupPath).Apply(w.Header(), r.URL.Path, lookupPath.HasAccessControl)
+
+	modTime := lastModified(fi, lookupPath)
+
+	// revalidations are answered before the file is opened, which reads
+	// from object storage for zip archives
+	if vfsServing.CheckPreconditions(w, r, modTime) {
+		return true
+	}
+
+	if r.Method == http.MethodHead && r.Header.Get("Range") == "" {
+		reader.serveFileHead(ctx, w, r, root, origPath, lookupPath, fi)
+		return true
+	}
+
+	file, err := root.Open(ctx, fullPath)
 	if err != nil {
-		httperrors.Serve500WithRequest(w, r, "detectContentType", err)
+		reader.serveError(w, r, root, lookupPath, "root.Open", err)
 		return true
 	}
 
-	w.Header().Set("Content-Type", contentType)
+	defer file.Close()
+
+	if !reader.setContentType(ctx, w, r, root, origPath, lookupPath) {
+		return true
+	}
 
 	reader.fileSizeMetric.WithLabelValues(reader.vfs.Name()).Observe(float64(fi.Size()))
 
-	// Support vfs.SeekableFile if available (uncompressed files)
-	if rs, ok := file.(vfs.SeekableFile); ok {
-		http.ServeContent(w, r, origPath, fi.ModTime(), rs)
+	// Support vfs.SeekableFile if available, so ranges of the file can be served
+	if rs, ok := seekableFile(r, file); ok && rangesServed(w.Header()) {
+		serveRangedFile(w, r, origPath, modTime, rs)
 	} else {
+		// single ranges are still served for the next requests
+		if _, ok := file.(vfs.SingleRangeFile); ok && rangesServed(w.Header()) {
+			w.Header().Set("Accept-Ranges", "bytes")
+		}
+
 		w.Header().Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
-		vfsServing.ServeCompressedFile(w, r, fi.ModTime(), file)
+		serveFile(w, r, modTime, file)
 	}
 
 	return true
 }
 
+// seekableFile returns file when ranges of it can be served for r, only the
+// requests of a single range are served from a vfs.SingleRangeFile
+func seekableFile(r *http.Request, file vfs.File) (vfs.SeekableFile, bool) {
+	rs, ok := file.(vfs.SeekableFile)
+	if !ok {
+		return nil, false
+	}
+
+	if _, singleRange := file.(vfs.SingleRangeFile); singleRange {
+		ranges := r.Header.Get("Range")
+		if ranges == "" || strings.Contains(ranges, ",") {
+			return nil, false
+		}
+	}
+
+	return rs, true
+}
+
+func (reader *Reader) allowHeaderValue() string {
+	if reader.allowHeader == "" {
+		return defaultAllowHeader
+	}
+
+	return reader.allowHeader
+}
+
+// setContentType sets the Content-Type of the file at origPath, unless it is
+// set by the _headers file. It returns false when it served an error.
+func (reader *Reader) setContentType(ctx context.Context, w http.ResponseWriter, r *http.Request, root vfs.Root, origPath string, lookupPath *serving.LookupPath) bool {
+	if _, ok := w.Header()["Content-Type"]; ok {
+		return true
+	}
+
+	contentType, err := reader.detectContentType(ctx, root, origPath)
+	if err != nil {
+		reader.serveError(w, r, root, lookupPath, "detectContentType", err)
+		return false
+	}
+
+	w.Header().Set("Content-Type", contentType)
+
+	return true
+}
+
+// serveFileHead answers HEAD requests from the metadata of the file of fi,
+// which is only opened to sniff its content type as opening it reads from
+// object storage for zip archives
+func (reader *Reader) serveFileHead(ctx context.Context, w http.ResponseWriter, r *http.Request, root vfs.Root, origPath string, lookupPath *serving.LookupPath, fi fs.FileInfo) {
+	if !reader.setContentType(ctx, w, r, root, origPath, lookupPath) {
+		return
+	}
+
+	if rangesServed(w.Header()) {
+		w.Header().Set("Accept-Ranges", "bytes")
+	}
+
+	w.Header().Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+// rangesServed returns true when ranges of the file whose response has header
+// are served. The files opened by every vfs.Root are seekable, but the ranges
+// of precompressed files are not served as the requests of ranges are served
+// the identity.
+func rangesServed(header http.Header) bool {
+	return header.Get("Content-Encoding") == ""
+}
+
+// lastModified returns the creation time of the deployment when it is known,
+// as modification times of zip entries are set by the build and change on
+// every rebuild even when the content does not
+func lastModified(fi fs.FileInfo, lookupPath *serving.LookupPath) time.Time {
+	if !lookupPath.DeployedAt.IsZero() {
+		return lookupPath.DeployedAt
+	}
+
+	return fi.ModTime()
+}
+
+// fileETag returns the strong ETag of the file of fi, before the content
+// language and encoding are appended. The files of zip archives are
+// identified by the archive and their CRC32, other files by the deployment or
+// their modification time and size.
+func fileETag(fi fs.FileInfo, lookupPath *serving.LookupPath) string {
+	if checksum, ok := fi.(vfs.ChecksumFileInfo); ok {
+		crc := strconv.FormatUint(uint64(checksum.CRC32()), 16)
+		if lookupPath.SHA256 == "" {
+			return crc + "-" + strconv.FormatInt(fi.Size(), 16)
+		}
+
+		return lookupPath.SHA256 + "-" + crc
+	}
+
+	if lookupPath.SHA256 != "" {
+		return lookupPath.SHA256
+	}
+
+	return strconv.FormatInt(fi.ModTime().Unix(), 16) + "-" + strconv.FormatInt(fi.Size(), 16)
+}
+
 func etag(contentEncoding, sha string) string {
 	if contentEncoding == "" {
 		return sha
 	}
-	return fmt.Sprintf("%s-%s", sha, contentEncoding)
+	return sha + "-" + contentEncoding
+}
+
+// languageETag differentiates the ETags of the language variants of a file
+func languageETag(contentLanguage, sha string) string {
+	if contentLanguage == "" {
+		return sha
+	}
+	return sha + "-" + contentLanguage
 }
 
 func (reader *Reader) serveCustomFile(ctx context.Context, w http.ResponseWriter, r *http.Request, code int, root vfs.Root, origPath string) error {
@@ -274,7 +847,7 @@ func (reader *Reader) serveCustomFile(ctx context.Context, w http.ResponseWriter
 	w.WriteHeader(code)
 
 	if r.Method != "HEAD" {
-		_, err := io.CopyN(w, file, fi.Size())
+		_, err := bufferpool.CopyN(w, file, fi.Size())
 		return err
 	}
 
@@ -286,6 +859,7 @@ func (reader *Reader) serveCustomFile(ctx context.Context, w http.ResponseWriter
 func (reader *Reader) root(h serving.Handler) (vfs.Root, bool) {
 	root, err := reader.vfs.Root(h.Request.Context(), h.LookupPath.Path, h.LookupPath.SHA256)
 	if err == nil {
+		reader.loadErrorPages(h.Request.Context(), root, h.LookupPath)
 		return root, false
 	}
 
@@ -299,6 +873,6 @@ func (reader *Reader) root(h serving.Handler) (vfs.Root, bool) {
 		return nil, true
 	}
 
-	httperrors.Serve500WithRequest(h.Writer, h.Request, "vfs.Root", err)
+	reader.serveError(h.Writer, h.Request, nil, h.LookupPath, "vfs.Root", err)
 	return nil, true
 }
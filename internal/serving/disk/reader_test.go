@@ -2,9 +2,17 @@ package disk
 
 import (
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/feature"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/testhelpers"
 )
 
 func Test_redirectPath(t *testing.T) {
@@ -20,6 +28,10 @@ func Test_redirectPath(t *testing.T) {
 			request:      newRequest(t, "https://domain.gitlab.io/index.html"),
 			expectedPath: "//domain.gitlab.io/index.html/",
 		},
+		"encoded_path": {
+			request:      newRequest(t, "https://domain.gitlab.io/sub%2Fdir?query=test"),
+			expectedPath: "//domain.gitlab.io/sub%2Fdir/?query=test",
+		},
 		"query_only": {
 			request:      newRequest(t, "https://domain.gitlab.io?query=test"),
 			expectedPath: "//domain.gitlab.io/?query=test",
@@ -58,6 +70,174 @@ func Test_redirectPath(t *testing.T) {
 	}
 }
 
+func Test_redirectPathWithoutSlash(t *testing.T) {
+	tests := map[string]struct {
+		request      *http.Request
+		expectedPath string
+	}{
+		"path_only": {
+			request:      newRequest(t, "https://domain.gitlab.io/about/"),
+			expectedPath: "//domain.gitlab.io/about",
+		},
+		"multiple_slashes": {
+			request:      newRequest(t, "https://domain.gitlab.io/docs/about//"),
+			expectedPath: "//domain.gitlab.io/docs/about",
+		},
+		"encoded_path": {
+			request:      newRequest(t, "https://domain.gitlab.io/sub%2Fdir/?query=test"),
+			expectedPath: "//domain.gitlab.io/sub%2Fdir?query=test",
+		},
+		"path_query_and_fragment": {
+			request:      newRequest(t, "https://domain.gitlab.io/about/?query=test#fragment"),
+			expectedPath: "//domain.gitlab.io/about?query=test#fragment",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := redirectPathWithoutSlash(test.request)
+			require.Equal(t, test.expectedPath, got)
+		})
+	}
+}
+
+type testFileInfo struct {
+	os.FileInfo
+	size    int64
+	modTime time.Time
+}
+
+func (fi *testFileInfo) Size() int64        { return fi.size }
+func (fi *testFileInfo) ModTime() time.Time { return fi.modTime }
+
+type testChecksumFileInfo struct {
+	testFileInfo
+	crc32 uint32
+}
+
+func (fi *testChecksumFileInfo) CRC32() uint32 { return fi.crc32 }
+
+func Test_fileETag(t *testing.T) {
+	modTime := time.Unix(0x60410000, 0)
+
+	tests := map[string]struct {
+		fi           os.FileInfo
+		sha          string
+		expectedETag string
+	}{
+		"zip_file": {
+			fi:           &testChecksumFileInfo{testFileInfo: testFileInfo{size: 32}, crc32: 0xe3fecfc2},
+			sha:          "sha",
+			expectedETag: "sha-e3fecfc2",
+		},
+		"zip_file_without_sha": {
+			fi:           &testChecksumFileInfo{testFileInfo: testFileInfo{size: 32}, crc32: 0xe3fecfc2},
+			expectedETag: "e3fecfc2-20",
+		},
+		"disk_file": {
+			fi:           &testFileInfo{size: 32, modTime: modTime},
+			sha:          "sha",
+			expectedETag: "sha",
+		},
+		"disk_file_without_sha": {
+			fi:           &testFileInfo{size: 32, modTime: modTime},
+			expectedETag: "60410000-20",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := fileETag(test.fi, &serving.LookupPath{SHA256: test.sha})
+			require.Equal(t, test.expectedETag, got)
+		})
+	}
+}
+
+func TestServeFileHTTPIfNoneMatch(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "failing.html"), []byte("content"), 0644))
+
+	s := New(&failingVFS{})
+
+	serve := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://group.gitlab-example.com/project/failing.html", nil)
+		r.Header.Set("If-None-Match", ifNoneMatch)
+
+		require.True(t, s.ServeFileHTTP(serving.Handler{
+			Writer:  w,
+			Request: r,
+			LookupPath: &serving.LookupPath{
+				Prefix: "/project/",
+				Path:   dir,
+				SHA256: "sha",
+			},
+			SubPath: "failing.html",
+		}))
+
+		return w
+	}
+
+	w := serve(`"sha"`)
+	require.Equal(t, http.StatusNotModified, w.Code, "the file is not opened")
+	require.Equal(t, `"sha"`, w.Header().Get("ETag"))
+
+	w = serve(`"other"`)
+	require.Equal(t, http.StatusInternalServerError, w.Code, "the file is opened")
+}
+
+func TestRedirectsCache(t *testing.T) {
+	testhelpers.StubFeatureFlagValue(t, feature.RedirectsPlaceholders.EnvVariable, false)
+
+	dir := t.TempDir()
+	writeRedirects := func(content string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "_redirects"), []byte(content), 0644))
+	}
+	writeRedirects("/project/news/:year /project/archive/:year 301\n")
+
+	s := New(&failingVFS{})
+
+	serve := func(sha string, flags feature.Flags) *httptest.ResponseRecorder {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://group.gitlab-example.com/project/news/2021", nil)
+
+		s.ServeFileHTTP(serving.Handler{
+			Writer:  w,
+			Request: r,
+			LookupPath: &serving.LookupPath{
+				Prefix:       "/project/",
+				Path:         dir,
+				SHA256:       sha,
+				FeatureFlags: flags,
+			},
+			SubPath: "news/2021",
+		})
+
+		return w
+	}
+
+	placeholders := feature.Flags{feature.RedirectsPlaceholders.Name: true}
+
+	w := serve("sha", placeholders)
+	require.Equal(t, http.StatusMovedPermanently, w.Code)
+	require.Equal(t, "/project/archive/2021", w.Header().Get("Location"))
+
+	writeRedirects("/project/news/:year /project/blog/:year 301\n")
+
+	w = serve("sha", placeholders)
+	require.Equal(t, "/project/archive/2021", w.Header().Get("Location"), "the rules of the deployment are cached")
+
+	w = serve("sha", nil)
+	require.Empty(t, w.Header().Get("Location"), "the cached rules are matched with the flags of the domain")
+
+	w = serve("other", placeholders)
+	require.Equal(t, "/project/blog/2021", w.Header().Get("Location"), "the rules of other deployments are parsed")
+}
+
 func newRequest(t *testing.T, url string) *http.Request {
 	t.Helper()
 
@@ -1,13 +1,39 @@
 package disk
 
 import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/cachedump"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httperrors"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/lru"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
 	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
+const (
+	// defaultHeadersItems is the number of deployments whose _headers file
+	// is cached
+	defaultHeadersItems = 1000
+	// defaultHeadersExpirationInterval is the time the _headers file of a
+	// deployment is cached for, deployments never change
+	defaultHeadersExpirationInterval = 10 * time.Minute
+	// defaultRedirectsItems is the number of deployments whose _redirects
+	// rules are cached
+	defaultRedirectsItems = 1000
+	// defaultRedirectsExpirationInterval is the time the _redirects rules of
+	// a deployment are cached for, deployments never change
+	defaultRedirectsExpirationInterval = 10 * time.Minute
+	// defaultErrorPagesExpirationInterval is the time the custom error pages
+	// of a project are cached for after they were last requested
+	defaultErrorPagesExpirationInterval = 10 * time.Minute
+)
+
 // Disk describes a disk access serving
 type Disk struct {
 	reader Reader
@@ -16,6 +42,12 @@ type Disk struct {
 // ServeFileHTTP serves a file from disk and returns true. It returns false
 // when a file could not been found.
 func (s *Disk) ServeFileHTTP(h serving.Handler) bool {
+	h = withPathRefresher(h)
+
+	if s.reader.tryForcedRedirects(h) {
+		return true
+	}
+
 	if s.reader.tryFile(h) {
 		return true
 	}
@@ -24,11 +56,21 @@ func (s *Disk) ServeFileHTTP(h serving.Handler) bool {
 		return true
 	}
 
+	if s.reader.tryFallbackPrefix(h) {
+		return true
+	}
+
+	if s.reader.trySPAFallback(h) {
+		return true
+	}
+
 	return false
 }
 
 // ServeNotFoundHTTP tries to read a custom 404 page
 func (s *Disk) ServeNotFoundHTTP(h serving.Handler) {
+	h = withPathRefresher(h)
+
 	if s.reader.tryNotFound(h) {
 		return
 	}
@@ -37,11 +79,60 @@ func (s *Disk) ServeNotFoundHTTP(h serving.Handler) {
 	httperrors.Serve404(h.Writer)
 }
 
-// Reconfigure VFS
+// withPathRefresher makes the path refresher of the lookup path available to
+// the VFS reading the files of the request
+func withPathRefresher(h serving.Handler) serving.Handler {
+	if h.LookupPath.RefreshPath == nil {
+		return h
+	}
+
+	ctx := vfs.WithPathRefresher(h.Request.Context(), h.LookupPath.RefreshPath)
+	h.Request = h.Request.WithContext(ctx)
+
+	return h
+}
+
+// Reconfigure the serving and its VFS
 func (s *Disk) Reconfigure(cfg *config.Config) error {
+	s.reader.directoryRedirectStatus = cfg.General.DirectoryRedirectStatus
+	s.reader.dotfiles = cfg.General.Dotfiles
+	s.reader.trailingSlash = cfg.General.TrailingSlash
+	s.reader.cleanURLs = cfg.General.CleanURLs
+	s.reader.directoryListing = cfg.General.DirectoryListing
+	s.reader.indexFiles = cfg.General.IndexFiles
+	s.reader.allowHeader = strings.Join(cfg.General.AllowedHTTPMethods, ", ")
+
+	cacheControl, err := cfg.General.CacheControlRules()
+	if err != nil {
+		return err
+	}
+	s.reader.cacheControl = cacheControl
+
+	blockedExtensions := make(map[string]struct{}, len(cfg.General.BlockedExtensions))
+	for _, ext := range cfg.General.BlockedExtensions {
+		blockedExtensions[ext] = struct{}{}
+	}
+	s.reader.blockedExtensions = blockedExtensions
+
 	return s.reader.vfs.Reconfigure(cfg)
 }
 
+// Preload opens the VFS root of the lookup path, so archives are opened and
+// cached before the first request
+func (s *Disk) Preload(ctx context.Context, lookupPath *serving.LookupPath) error {
+	_, err := s.reader.vfs.Root(ctx, lookupPath.Path, lookupPath.SHA256)
+	return err
+}
+
+// DumpArchives returns the archives cached by the VFS
+func (s *Disk) DumpArchives() []cachedump.Archive {
+	if d, ok := s.reader.vfs.(cachedump.ArchivesDumper); ok {
+		return d.DumpArchives()
+	}
+
+	return nil
+}
+
 // New returns a serving instance that is capable of reading files
 // from the VFS
 func New(vfs vfs.VFS) serving.Serving {
@@ -49,6 +140,17 @@ func New(vfs vfs.VFS) serving.Serving {
 		reader: Reader{
 			fileSizeMetric: metrics.DiskServingFileSize,
 			vfs:            vfs,
+			headersCache: lru.New(
+				"headers",
+				lru.WithMaxSize(defaultHeadersItems),
+				lru.WithExpirationInterval(defaultHeadersExpirationInterval),
+			),
+			redirectsCache: lru.New(
+				"redirects",
+				lru.WithMaxSize(defaultRedirectsItems),
+				lru.WithExpirationInterval(defaultRedirectsExpirationInterval),
+			),
+			errorPagesCache: cache.New(defaultErrorPagesExpirationInterval, defaultErrorPagesExpirationInterval),
 		},
 	}
 }
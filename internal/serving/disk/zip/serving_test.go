@@ -4,10 +4,14 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -53,6 +57,42 @@ func TestZip_ServeFileHTTP(t *testing.T) {
 			expectedStatus: http.StatusOK,
 			expectedBody:   "zip.gitlab.io/project/index.html\n",
 		},
+		"accessing /index.html Range": {
+			vfsPath:        httpURL,
+			path:           "/index.html",
+			expectedStatus: http.StatusPartialContent,
+			expectedBody:   "gitlab.io",
+			extraHeaders: http.Header{
+				"Range": {"bytes=4-12"},
+			},
+		},
+		"accessing compressed /subdir/linked.html Range": {
+			vfsPath:        httpURL,
+			path:           "/subdir/linked.html",
+			expectedStatus: http.StatusPartialContent,
+			expectedBody:   "subdir/linked.html\n",
+			extraHeaders: http.Header{
+				"Range": {"bytes=14-"},
+			},
+		},
+		"accessing compressed /subdir/linked.html multiple Ranges": {
+			vfsPath:        httpURL,
+			path:           "/subdir/linked.html",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "symlink.html->subdir/linked.html\n",
+			extraHeaders: http.Header{
+				"Range": {"bytes=14-20,0-5"},
+			},
+		},
+		"accessing compressed /subdir/linked.html Range not satisfiable": {
+			vfsPath:        httpURL,
+			path:           "/subdir/linked.html",
+			expectedStatus: http.StatusRequestedRangeNotSatisfiable,
+			expectedBody:   "invalid range: failed to overlap\n",
+			extraHeaders: http.Header{
+				"Range": {"bytes=100-"},
+			},
+		},
 		"accessing / If-Modified-Since": {
 			vfsPath:        httpURL,
 			path:           "/",
@@ -96,14 +136,20 @@ func TestZip_ServeFileHTTP(t *testing.T) {
 		"accessing without /": {
 			vfsPath:        httpURL,
 			path:           "",
-			expectedStatus: http.StatusFound,
-			expectedBody:   "<a href=\"//zip.gitlab.io/zip/\">Found</a>.\n\n",
+			expectedStatus: http.StatusMovedPermanently,
+			expectedBody:   "<a href=\"//zip.gitlab.io/zip/\">Moved Permanently</a>.\n\n",
 		},
 		"accessing without / from disk": {
 			vfsPath:        fileURL,
 			path:           "",
-			expectedStatus: http.StatusFound,
-			expectedBody:   "<a href=\"//zip.gitlab.io/zip/\">Found</a>.\n\n",
+			expectedStatus: http.StatusMovedPermanently,
+			expectedBody:   "<a href=\"//zip.gitlab.io/zip/\">Moved Permanently</a>.\n\n",
+		},
+		"accessing directory without index and without /": {
+			vfsPath: httpURL,
+			path:    "/subdir",
+			// directories without an index are not redirected
+			expectedStatus: 0,
 		},
 		"accessing archive that is 404": {
 			vfsPath: testServerURL + "/invalid.zip",
@@ -126,7 +172,7 @@ func TestZip_ServeFileHTTP(t *testing.T) {
 			path:           "/",
 			expectedStatus: http.StatusNotModified,
 			extraHeaders: http.Header{
-				"If-None-Match": {fmt.Sprintf("%q", sha(httpURL))},
+				"If-None-Match": {zipETag(sha(httpURL), "zip.gitlab.io/project/index.html\n")},
 			},
 		},
 		"accessing / If-None-Match fails": {
@@ -144,7 +190,7 @@ func TestZip_ServeFileHTTP(t *testing.T) {
 			expectedStatus: http.StatusOK,
 			expectedBody:   "zip.gitlab.io/project/index.html\n",
 			extraHeaders: http.Header{
-				"If-Match": {fmt.Sprintf("%q", sha(httpURL))},
+				"If-Match": {zipETag(sha(httpURL), "zip.gitlab.io/project/index.html\n")},
 			},
 		},
 		"accessing / If-Match fails": {
@@ -227,12 +273,297 @@ func TestZip_ServeFileHTTP(t *testing.T) {
 	}
 }
 
+func TestZip_ServeFileHTTPDeployedAt(t *testing.T) {
+	testServerURL, cleanup := newZipFileServerURL(t, "group/zip.gitlab.io/public-without-dirs.zip")
+	defer cleanup()
+
+	httpURL := testServerURL + "/public.zip"
+	deployedAt := time.Date(2021, time.March, 4, 10, 30, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		sha                  string
+		extraHeaders         http.Header
+		expectedStatus       int
+		expectedETag         string
+		expectedLastModified string
+	}{
+		"If-None-Match the file": {
+			sha:            sha(httpURL),
+			expectedStatus: http.StatusNotModified,
+			extraHeaders: http.Header{
+				"If-None-Match": {zipETag(sha(httpURL), "zip.gitlab.io/project/index.html\n")},
+			},
+		},
+		"If-None-Match the deployment": {
+			sha:                  sha(httpURL),
+			expectedStatus:       http.StatusOK,
+			expectedETag:         zipETag(sha(httpURL), "zip.gitlab.io/project/index.html\n"),
+			expectedLastModified: deployedAt.Format(http.TimeFormat),
+			extraHeaders: http.Header{
+				"If-None-Match": {fmt.Sprintf("%q", sha(httpURL))},
+			},
+		},
+		"last modified is the deployment time": {
+			sha:                  sha(httpURL),
+			expectedStatus:       http.StatusOK,
+			expectedETag:         zipETag(sha(httpURL), "zip.gitlab.io/project/index.html\n"),
+			expectedLastModified: deployedAt.Format(http.TimeFormat),
+		},
+		"If-Modified-Since the deployment": {
+			sha:            sha(httpURL),
+			expectedStatus: http.StatusNotModified,
+			extraHeaders: http.Header{
+				"If-Modified-Since": {deployedAt.Format(http.TimeFormat)},
+			},
+		},
+		"If-Modified-Since before the deployment": {
+			sha:                  sha(httpURL),
+			expectedStatus:       http.StatusOK,
+			expectedETag:         zipETag(sha(httpURL), "zip.gitlab.io/project/index.html\n"),
+			expectedLastModified: deployedAt.Format(http.TimeFormat),
+			extraHeaders: http.Header{
+				"If-Modified-Since": {deployedAt.Add(-time.Second).Format(http.TimeFormat)},
+			},
+		},
+	}
+
+	s := Instance()
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "http://zip.gitlab.io/zip/index.html", nil)
+
+			if test.extraHeaders != nil {
+				r.Header = test.extraHeaders
+			}
+
+			handler := serving.Handler{
+				Writer:  w,
+				Request: r,
+				LookupPath: &serving.LookupPath{
+					Prefix:     "/zip/",
+					Path:       httpURL,
+					SHA256:     test.sha,
+					DeployedAt: deployedAt,
+				},
+				SubPath: "/index.html",
+			}
+
+			require.True(t, s.ServeFileHTTP(handler))
+
+			resp := w.Result()
+			defer resp.Body.Close()
+
+			require.Equal(t, test.expectedStatus, resp.StatusCode)
+
+			if test.expectedStatus == http.StatusOK {
+				require.Equal(t, test.expectedETag, resp.Header.Get("ETag"))
+				require.Equal(t, test.expectedLastModified, resp.Header.Get("Last-Modified"))
+			}
+		})
+	}
+}
+
+func TestZip_ServeFileHTTPCaseInsensitive(t *testing.T) {
+	testServerURL, cleanup := newZipFileServerURL(t, "group/zip.gitlab.io/public-without-dirs.zip")
+	defer cleanup()
+
+	httpURL := testServerURL + "/public.zip"
+
+	tests := map[string]struct {
+		prefix           string
+		path             string
+		caseInsensitive  bool
+		expectedStatus   int
+		expectedLocation string
+	}{
+		"exact_case": {
+			path:            "/subdir/hello.html?page=1",
+			caseInsensitive: true,
+			expectedStatus:  http.StatusOK,
+		},
+		"file": {
+			path:             "/SubDir/Hello.HTML?page=1",
+			caseInsensitive:  true,
+			expectedStatus:   http.StatusMovedPermanently,
+			expectedLocation: "/zip/subdir/hello.html?page=1",
+		},
+		"directory": {
+			path:             "/SUBDIR/",
+			caseInsensitive:  true,
+			expectedStatus:   http.StatusMovedPermanently,
+			expectedLocation: "/zip/subdir/",
+		},
+		"network_path_reference": {
+			prefix:           "/",
+			path:             "//subdir/Hello.HTML",
+			caseInsensitive:  true,
+			expectedStatus:   http.StatusMovedPermanently,
+			expectedLocation: "/subdir/hello.html",
+		},
+		"missing": {
+			path:            "/SubDir/Missing.html",
+			caseInsensitive: true,
+		},
+		"not_enabled": {
+			path: "/SubDir/Hello.HTML",
+		},
+	}
+
+	s := Instance()
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			w.Code = 0 // ensure that code is not set, and it is being set by handler
+
+			prefix := test.prefix
+			if prefix == "" {
+				prefix = "/zip/"
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "http://zip.gitlab.io"+strings.TrimSuffix(prefix, "/")+test.path, nil)
+
+			handler := serving.Handler{
+				Writer:  w,
+				Request: r,
+				LookupPath: &serving.LookupPath{
+					Prefix:          prefix,
+					Path:            httpURL,
+					SHA256:          sha(httpURL),
+					CaseInsensitive: test.caseInsensitive,
+				},
+				SubPath: strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(prefix, "/")),
+			}
+
+			if test.expectedStatus == 0 {
+				require.False(t, s.ServeFileHTTP(handler))
+				require.Zero(t, w.Code, "we expect status to not be set")
+				return
+			}
+
+			require.True(t, s.ServeFileHTTP(handler))
+			require.Equal(t, test.expectedStatus, w.Code)
+			require.Equal(t, test.expectedLocation, w.Header().Get("Location"))
+		})
+	}
+}
+
+func TestZip_ServeFileHTTPContentLength(t *testing.T) {
+	testServerURL, cleanup := newZipFileServerURL(t, "group/zip.gitlab.io/public-without-dirs.zip")
+	defer cleanup()
+
+	httpURL := testServerURL + "/public.zip"
+
+	s := Instance()
+
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		t.Run(method, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			// subdir/linked.html is deflated in the archive
+			r := httptest.NewRequest(method, "http://zip.gitlab.io/zip/subdir/linked.html", nil)
+
+			handler := serving.Handler{
+				Writer:  w,
+				Request: r,
+				LookupPath: &serving.LookupPath{
+					Prefix: "/zip/",
+					Path:   httpURL,
+					SHA256: sha(httpURL),
+				},
+				SubPath: "/subdir/linked.html",
+			}
+
+			require.True(t, s.ServeFileHTTP(handler))
+			require.Equal(t, http.StatusOK, w.Code)
+			require.Equal(t, "33", w.Header().Get("Content-Length"), "the uncompressed size of the file")
+
+			if method == http.MethodGet {
+				require.Len(t, w.Body.String(), 33)
+			} else {
+				require.Empty(t, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestZip_ServeFileHTTPHeadAndOptions(t *testing.T) {
+	chdir := testhelpers.ChdirInPath(t, "../../../../shared/pages", &chdirSet)
+	defer chdir()
+
+	var requests int64
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		http.ServeFile(w, r, "group/zip.gitlab.io/public-without-dirs.zip")
+	}))
+	defer testServer.Close()
+
+	httpURL := testServer.URL + "/public.zip"
+
+	s := Instance()
+	require.NoError(t, s.Reconfigure(&config.Config{
+		Zip: config.ZipServing{
+			ExpirationInterval: 10 * time.Second,
+			CleanupInterval:    5 * time.Second,
+			RefreshInterval:    5 * time.Second,
+			OpenTimeout:        5 * time.Second,
+		},
+	}))
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		handler := serving.Handler{
+			Writer:  w,
+			Request: httptest.NewRequest(method, "http://zip.gitlab.io/zip"+path, nil),
+			LookupPath: &serving.LookupPath{
+				Prefix: "/zip/",
+				Path:   httpURL,
+				SHA256: sha(httpURL),
+			},
+			SubPath: path,
+		}
+
+		require.True(t, s.ServeFileHTTP(handler))
+
+		return w
+	}
+
+	// the archive is read once
+	serve(http.MethodGet, "/index.html")
+	before := atomic.LoadInt64(&requests)
+
+	w := serve(http.MethodHead, "/subdir/linked.html")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "33", w.Header().Get("Content-Length"))
+	require.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+	require.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	require.NotEmpty(t, w.Header().Get("ETag"))
+	require.Empty(t, w.Body.String())
+
+	w = serve(http.MethodOptions, "/subdir/linked.html")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "GET, HEAD, OPTIONS", w.Header().Get("Allow"))
+	require.Empty(t, w.Body.String())
+
+	require.Equal(t, before, atomic.LoadInt64(&requests), "the file is not read from the archive")
+}
+
 func sha(path string) string {
 	sha := sha256.Sum256([]byte(path))
 	s := hex.EncodeToString(sha[:])
 	return s
 }
 
+// zipETag returns the ETag of a file of an archive with the content
+func zipETag(sha, content string) string {
+	crc := strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(content))), 16)
+
+	return fmt.Sprintf("%q", sha+"-"+crc)
+}
+
 var chdirSet = false
 
 func newZipFileServerURL(t *testing.T, zipFilePath string) (string, func()) {
@@ -1,13 +1,83 @@
 package serving
 
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/feature"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/forwarded"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/request"
+)
+
 // LookupPath holds a domain project configuration needed to handle a request
 type LookupPath struct {
 	ServingType        string // Serving type being used, like `zip`
 	Prefix             string // Project prefix, for example, /my/project in group.gitlab.io/my/project/index.html
 	Path               string // Path is an internal and serving-specific location of a document
 	SHA256             string
-	IsNamespaceProject bool // IsNamespaceProject is DEPRECATED, see https://gitlab.com/gitlab-org/gitlab-pages/issues/272
+	DeployedAt         time.Time // DeployedAt is the creation time of the deployment, zero when unknown
+	IsNamespaceProject bool      // IsNamespaceProject is DEPRECATED, see https://gitlab.com/gitlab-org/gitlab-pages/issues/272
 	IsHTTPSOnly        bool
 	HasAccessControl   bool
 	ProjectID          uint64
+	// LanguageNegotiation serves `index.<lang>.html` matching Accept-Language
+	LanguageNegotiation bool
+	FeatureFlags        feature.Flags // FeatureFlags are the feature flags values for the domain
+	// RefreshPath returns the latest Path of the deployment, e.g. a new
+	// pre-signed URL once Path expired, it is nil when Path does not expire
+	RefreshPath func(ctx context.Context) (string, error)
+	// ServeBlockedExtensions serves the files with an extension blocked by
+	// the instance, e.g. .pem
+	ServeBlockedExtensions bool
+	// FallbackPrefix is the top-level directory, without slashes, serving the
+	// files not found under another top-level directory, e.g. /latest/page.html
+	// for /v2/page.html
+	FallbackPrefix string
+	// CaseInsensitive redirects the paths of missing files to the file
+	// matching them ignoring case, e.g. /About.HTML to /about.html
+	CaseInsensitive bool
+	// Namespace is the full path of the namespace of the project, e.g.
+	// group/subgroup, it is empty when unknown
+	Namespace string
+	// SPAFallback serves the root index.html with a 200 status instead of
+	// the 404 page, for single-page applications routing on the client
+	SPAFallback bool
+	// DirectoryListing serves a generated HTML index of the files of the
+	// directories without an index.html
+	DirectoryListing bool
+	// IndexFiles are the names of the files served for directories, in order
+	// of preference, the ones of the instance are served when empty
+	IndexFiles []string
+	// CanonicalDomain is the custom domain the requests to the project on
+	// the pages domain are permanently redirected to, e.g. www.example.com
+	CanonicalDomain string
+	// IPAccess restricts the clients allowed to view the project, all the
+	// clients are allowed when nil
+	IPAccess *IPAccess
+}
+
+// IPAccess restricts the clients allowed to view a project by the IP address
+// of the request
+type IPAccess struct {
+	// Allow are the networks of the clients allowed, all the clients which
+	// are not denied are allowed when nil
+	Allow forwarded.Proxies
+	// Deny are the networks of the clients denied, even when they are allowed
+	Deny forwarded.Proxies
+}
+
+// Allows returns true when the client of r is allowed to view the project
+func (a *IPAccess) Allows(r *http.Request) bool {
+	if a == nil {
+		return true
+	}
+
+	ip := request.GetClientIP(r)
+
+	if a.Deny.Contains(ip) {
+		return false
+	}
+
+	return a.Allow == nil || a.Allow.Contains(ip)
 }
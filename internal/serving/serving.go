@@ -1,6 +1,10 @@
 package serving
 
-import "gitlab.com/gitlab-org/gitlab-pages/internal/config"
+import (
+	"context"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+)
 
 // Serving is an interface used to define a serving driver
 type Serving interface {
@@ -8,3 +12,9 @@ type Serving interface {
 	ServeNotFoundHTTP(Handler)
 	Reconfigure(config *config.Config) error
 }
+
+// Preloader is implemented by serving drivers which can prepare the content
+// of a lookup path before it is requested, e.g. by opening its archive
+type Preloader interface {
+	Preload(ctx context.Context, lookupPath *LookupPath) error
+}
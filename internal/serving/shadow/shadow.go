@@ -0,0 +1,329 @@
+// Package shadow serves a sample of the requests with a secondary serving in
+// the background and compares its responses with the ones of the primary
+// serving, so a new serving backend can be validated with production traffic
+// before switching to it
+package shadow
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/cachedump"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+const (
+	// maxConcurrent is the maximum number of requests served by the secondary
+	// serving at the same time, sampled requests are dropped above it
+	maxConcurrent = 16
+	// timeout of the requests served by the secondary serving
+	timeout = 30 * time.Second
+)
+
+// comparedHeaders are the headers which must be the same in the responses of
+// both servings, other headers can depend on the time they were served
+var comparedHeaders = []string{
+	"Content-Type",
+	"Content-Encoding",
+	"Content-Language",
+	"Content-Length",
+	"ETag",
+	"Location",
+}
+
+// LookupPathFunc returns the lookup path the secondary serving serves the
+// request of a lookup path of the primary serving from, false when the
+// secondary serving can't serve it
+type LookupPathFunc func(*serving.LookupPath) (*serving.LookupPath, bool)
+
+// Serving serves the requests with the primary serving and a sample of them
+// with the secondary serving too
+type Serving struct {
+	primary      serving.Serving
+	newSecondary func() serving.Serving
+	lookupPath   LookupPathFunc
+	sampleRate   func(*config.Config) float64
+
+	mu        sync.RWMutex
+	secondary serving.Serving
+	rate      float64
+
+	sem chan struct{}
+	// wg tracks the requests served by the secondary serving, for tests
+	wg sync.WaitGroup
+}
+
+// New returns a serving comparing a secondary serving with primary for the
+// fraction of the requests returned by sampleRate when it is reconfigured.
+// The secondary serving is only created with newSecondary once the rate is
+// above 0, and serves the requests from the lookup paths returned by lookupPath
+func New(primary serving.Serving, newSecondary func() serving.Serving, lookupPath LookupPathFunc, sampleRate func(*config.Config) float64) *Serving {
+	return &Serving{
+		primary:      primary,
+		newSecondary: newSecondary,
+		lookupPath:   lookupPath,
+		sampleRate:   sampleRate,
+		sem:          make(chan struct{}, maxConcurrent),
+	}
+}
+
+// ServeFileHTTP serves the request with the primary serving, the sampled
+// requests it serves are served again with the secondary serving in the
+// background to compare their responses
+func (s *Serving) ServeFileHTTP(h serving.Handler) bool {
+	if !s.sample(h.Request) {
+		return s.primary.ServeFileHTTP(h)
+	}
+
+	primary := newRecorder(h.Writer)
+	served := s.primary.ServeFileHTTP(serving.Handler{
+		Writer:     primary,
+		Request:    h.Request,
+		LookupPath: h.LookupPath,
+		SubPath:    h.SubPath,
+	})
+
+	if !served {
+		metrics.ShadowComparisons.WithLabelValues("skipped").Inc()
+		return false
+	}
+
+	lookupPath, ok := s.lookupPath(h.LookupPath)
+	if !ok {
+		metrics.ShadowComparisons.WithLabelValues("skipped").Inc()
+		return true
+	}
+
+	s.shadow(h, lookupPath, primary.response())
+
+	return true
+}
+
+// ServeNotFoundHTTP serves the not found page of the primary serving
+func (s *Serving) ServeNotFoundHTTP(h serving.Handler) {
+	s.primary.ServeNotFoundHTTP(h)
+}
+
+// Reconfigure the primary serving and the sample rate, the secondary serving
+// is created on the first reconfiguration sampling requests and reconfigured
+// as long as they are sampled
+func (s *Serving) Reconfigure(cfg *config.Config) error {
+	if err := s.primary.Reconfigure(cfg); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rate := s.sampleRate(cfg)
+	if rate > 0 {
+		if s.secondary == nil {
+			s.secondary = s.newSecondary()
+		}
+
+		if err := s.secondary.Reconfigure(cfg); err != nil {
+			return err
+		}
+	}
+
+	s.rate = rate
+
+	return nil
+}
+
+// Preload prepares the lookup path with the primary serving
+func (s *Serving) Preload(ctx context.Context, lookupPath *serving.LookupPath) error {
+	if p, ok := s.primary.(serving.Preloader); ok {
+		return p.Preload(ctx, lookupPath)
+	}
+
+	return nil
+}
+
+// DumpArchives returns the archives cached by the primary serving
+func (s *Serving) DumpArchives() []cachedump.Archive {
+	if d, ok := s.primary.(cachedump.ArchivesDumper); ok {
+		return d.DumpArchives()
+	}
+
+	return nil
+}
+
+func (s *Serving) sample(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	s.mu.RLock()
+	rate := s.rate
+	s.mu.RUnlock()
+
+	// #nosec G404 sampling does not need a secure random number
+	return rate > 0 && rand.Float64() < rate
+}
+
+// shadow serves a copy of the request from lookupPath with the secondary
+// serving in the background and compares its response with the one of the
+// primary serving
+func (s *Serving) shadow(h serving.Handler, lookupPath *serving.LookupPath, primary response) {
+	s.mu.RLock()
+	secondary := s.secondary
+	s.mu.RUnlock()
+
+	select {
+	case s.sem <- struct{}{}:
+	default:
+		metrics.ShadowComparisons.WithLabelValues("dropped").Inc()
+		return
+	}
+
+	// the request is served after the response of the primary serving was
+	// sent, when the context of the original request is canceled
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	r := h.Request.Clone(ctx)
+
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+		defer func() { <-s.sem }()
+		defer cancel()
+
+		rec := newRecorder(nil)
+		if !secondary.ServeFileHTTP(serving.Handler{
+			Writer:     rec,
+			Request:    r,
+			LookupPath: lookupPath,
+			SubPath:    h.SubPath,
+		}) {
+			rec.WriteHeader(http.StatusNotFound)
+		}
+
+		compare(r, primary, rec.response())
+	}()
+}
+
+// compare reports the differences between the responses of both servings
+func compare(r *http.Request, primary, secondary response) {
+	result := "match"
+	fields := log.Fields{
+		"host":             r.Host,
+		"path":             r.URL.Path,
+		"primary_status":   primary.status,
+		"secondary_status": secondary.status,
+	}
+
+	for _, name := range comparedHeaders {
+		if primary.header.Get(name) != secondary.header.Get(name) {
+			result = "header_mismatch"
+			fields["header"] = name
+			fields["primary_header"] = primary.header.Get(name)
+			fields["secondary_header"] = secondary.header.Get(name)
+			break
+		}
+	}
+
+	if primary.sum != secondary.sum {
+		result = "content_mismatch"
+		fields["primary_sha256"] = primary.sum
+		fields["secondary_sha256"] = secondary.sum
+	}
+
+	if primary.status != secondary.status {
+		result = "status_mismatch"
+	}
+
+	metrics.ShadowComparisons.WithLabelValues(result).Inc()
+
+	if result != "match" {
+		log.WithFields(fields).WithField("result", result).Warn("the responses of the primary and secondary servings differ")
+	}
+}
+
+// response is the status, headers and content hash of a response
+type response struct {
+	status int
+	header http.Header
+	sum    string
+}
+
+// recorder records the response written to the http.ResponseWriter it wraps,
+// if any, and hashes its content
+type recorder struct {
+	http.ResponseWriter
+	header      http.Header
+	status      int
+	wroteHeader bool
+	hash        hash.Hash
+}
+
+func newRecorder(w http.ResponseWriter) *recorder {
+	rec := &recorder{ResponseWriter: w, hash: sha256.New()}
+	if w == nil {
+		rec.header = make(http.Header)
+	}
+
+	return rec
+}
+
+func (w *recorder) Header() http.Header {
+	if w.ResponseWriter == nil {
+		return w.header
+	}
+
+	return w.ResponseWriter.Header()
+}
+
+func (w *recorder) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.status = statusCode
+	}
+
+	if w.ResponseWriter != nil {
+		w.ResponseWriter.WriteHeader(statusCode)
+	}
+}
+
+func (w *recorder) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	w.hash.Write(data)
+
+	if w.ResponseWriter == nil {
+		return len(data), nil
+	}
+
+	return w.ResponseWriter.Write(data)
+}
+
+// Unwrap returns the http.ResponseWriter the recorded response is written to,
+// it is nil for the responses which are only compared
+func (w *recorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *recorder) response() response {
+	status := w.status
+	if !w.wroteHeader {
+		status = http.StatusOK
+	}
+
+	return response{
+		status: status,
+		header: w.Header().Clone(),
+		sum:    hex.EncodeToString(w.hash.Sum(nil)),
+	}
+}
@@ -0,0 +1,265 @@
+package shadow
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+type fakeServing struct {
+	served      bool
+	status      int
+	contentType string
+	body        string
+	preloaded   bool
+}
+
+func (f *fakeServing) ServeFileHTTP(h serving.Handler) bool {
+	if !f.served {
+		return false
+	}
+
+	h.Writer.Header().Set("Content-Type", f.contentType)
+	h.Writer.WriteHeader(f.status)
+	h.Writer.Write([]byte(f.body))
+
+	return true
+}
+
+func (f *fakeServing) ServeNotFoundHTTP(h serving.Handler) {
+	h.Writer.WriteHeader(http.StatusNotFound)
+}
+
+func (f *fakeServing) Reconfigure(*config.Config) error {
+	return nil
+}
+
+func (f *fakeServing) Preload(context.Context, *serving.LookupPath) error {
+	f.preloaded = true
+	return nil
+}
+
+func newServing(t *testing.T, primary, secondary serving.Serving, rate float64) *Serving {
+	t.Helper()
+
+	s := New(
+		primary,
+		func() serving.Serving { return secondary },
+		func(lookupPath *serving.LookupPath) (*serving.LookupPath, bool) { return lookupPath, true },
+		func(*config.Config) float64 { return rate },
+	)
+	require.NoError(t, s.Reconfigure(&config.Config{}))
+
+	return s
+}
+
+func serve(t *testing.T, s *Serving, method string) (*httptest.ResponseRecorder, bool) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	served := s.ServeFileHTTP(serving.Handler{
+		Writer:     w,
+		Request:    httptest.NewRequest(method, "http://group.gitlab-example.com/index.html", nil),
+		LookupPath: &serving.LookupPath{Path: "group/public/"},
+		SubPath:    "index.html",
+	})
+	s.wg.Wait()
+
+	return w, served
+}
+
+func TestServeFileHTTPComparesResponses(t *testing.T) {
+	primary := fakeServing{served: true, status: http.StatusOK, contentType: "text/html", body: "hello"}
+
+	tests := map[string]struct {
+		secondary      fakeServing
+		expectedResult string
+	}{
+		"match": {
+			secondary:      primary,
+			expectedResult: "match",
+		},
+		"status_mismatch": {
+			secondary:      fakeServing{served: true, status: http.StatusInternalServerError, contentType: "text/html", body: "hello"},
+			expectedResult: "status_mismatch",
+		},
+		"not_served_by_secondary": {
+			secondary:      fakeServing{},
+			expectedResult: "status_mismatch",
+		},
+		"header_mismatch": {
+			secondary:      fakeServing{served: true, status: http.StatusOK, contentType: "text/plain", body: "hello"},
+			expectedResult: "header_mismatch",
+		},
+		"content_mismatch": {
+			secondary:      fakeServing{served: true, status: http.StatusOK, contentType: "text/html", body: "bye"},
+			expectedResult: "content_mismatch",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			primary := primary
+			secondary := tt.secondary
+			s := newServing(t, &primary, &secondary, 1)
+
+			before := testutil.ToFloat64(metrics.ShadowComparisons.WithLabelValues(tt.expectedResult))
+
+			w, served := serve(t, s, http.MethodGet)
+			require.True(t, served)
+
+			// the client always gets the response of the primary serving
+			require.Equal(t, http.StatusOK, w.Code)
+			require.Equal(t, "text/html", w.Header().Get("Content-Type"))
+			require.Equal(t, "hello", w.Body.String())
+
+			require.Equal(t, before+1, testutil.ToFloat64(metrics.ShadowComparisons.WithLabelValues(tt.expectedResult)))
+		})
+	}
+}
+
+func TestServeFileHTTPNotSampled(t *testing.T) {
+	tests := map[string]struct {
+		rate   float64
+		method string
+	}{
+		"disabled": {
+			rate:   0,
+			method: http.MethodGet,
+		},
+		"post_request": {
+			rate:   1,
+			method: http.MethodPost,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			primary := &fakeServing{served: true, status: http.StatusOK, body: "hello"}
+			s := newServing(t, primary, &fakeServing{}, tt.rate)
+
+			before := testutil.ToFloat64(metrics.ShadowComparisons.WithLabelValues("status_mismatch"))
+
+			w, served := serve(t, s, tt.method)
+			require.True(t, served)
+			require.Equal(t, "hello", w.Body.String())
+
+			require.Equal(t, before, testutil.ToFloat64(metrics.ShadowComparisons.WithLabelValues("status_mismatch")))
+		})
+	}
+}
+
+func TestServeFileHTTPNotServedByPrimary(t *testing.T) {
+	s := newServing(t, &fakeServing{}, &fakeServing{served: true, status: http.StatusOK}, 1)
+
+	before := testutil.ToFloat64(metrics.ShadowComparisons.WithLabelValues("skipped"))
+
+	_, served := serve(t, s, http.MethodGet)
+	require.False(t, served)
+
+	require.Equal(t, before+1, testutil.ToFloat64(metrics.ShadowComparisons.WithLabelValues("skipped")))
+}
+
+func TestPreloadUsesPrimary(t *testing.T) {
+	primary := &fakeServing{}
+	secondary := &fakeServing{}
+	s := newServing(t, primary, secondary, 1)
+
+	require.NoError(t, s.Preload(context.Background(), &serving.LookupPath{}))
+	require.True(t, primary.preloaded)
+	require.False(t, secondary.preloaded)
+}
+
+func TestReconfigureCreatesSecondaryWhenSampling(t *testing.T) {
+	rate := 0.0
+	created := 0
+
+	s := New(
+		&fakeServing{},
+		func() serving.Serving {
+			created++
+			return &fakeServing{}
+		},
+		func(lookupPath *serving.LookupPath) (*serving.LookupPath, bool) { return lookupPath, true },
+		func(*config.Config) float64 { return rate },
+	)
+
+	require.NoError(t, s.Reconfigure(&config.Config{}))
+	require.Nil(t, s.secondary)
+	require.Zero(t, created)
+
+	rate = 0.5
+	require.NoError(t, s.Reconfigure(&config.Config{}))
+	require.NotNil(t, s.secondary)
+
+	rate = 1
+	require.NoError(t, s.Reconfigure(&config.Config{}))
+	require.Equal(t, 1, created)
+}
+
+func TestServeFileHTTPUsesSecondaryLookupPath(t *testing.T) {
+	primary := &fakeServing{served: true, status: http.StatusOK, body: "hello"}
+
+	tests := map[string]struct {
+		mapped         bool
+		expectedResult string
+	}{
+		"mapped": {
+			mapped:         true,
+			expectedResult: "match",
+		},
+		"not_mapped": {
+			mapped:         false,
+			expectedResult: "skipped",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var secondaryPath string
+
+			s := New(
+				primary,
+				func() serving.Serving { return &lookupPathServing{fakeServing: *primary, path: &secondaryPath} },
+				func(lookupPath *serving.LookupPath) (*serving.LookupPath, bool) {
+					return &serving.LookupPath{Path: "file:///" + lookupPath.Path}, tt.mapped
+				},
+				func(*config.Config) float64 { return 1 },
+			)
+			require.NoError(t, s.Reconfigure(&config.Config{}))
+
+			before := testutil.ToFloat64(metrics.ShadowComparisons.WithLabelValues(tt.expectedResult))
+
+			w, served := serve(t, s, http.MethodGet)
+			require.True(t, served)
+			require.Equal(t, "hello", w.Body.String())
+
+			require.Equal(t, before+1, testutil.ToFloat64(metrics.ShadowComparisons.WithLabelValues(tt.expectedResult)))
+
+			if tt.mapped {
+				require.Equal(t, "file:///group/public/", secondaryPath)
+			} else {
+				require.Empty(t, secondaryPath)
+			}
+		})
+	}
+}
+
+// lookupPathServing records the path of the lookup path it serves
+type lookupPathServing struct {
+	fakeServing
+	path *string
+}
+
+func (l *lookupPathServing) ServeFileHTTP(h serving.Handler) bool {
+	*l.path = h.LookupPath.Path
+	return l.fakeServing.ServeFileHTTP(h)
+}
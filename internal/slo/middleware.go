@@ -0,0 +1,46 @@
+package slo
+
+import (
+	"net/http"
+	"time"
+)
+
+// NewMiddleware returns middleware observing the status and duration of the
+// requests in window
+func NewMiddleware(handler http.Handler, window *Window) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+		handler.ServeHTTP(sw, r)
+
+		window.Observe(sw.status, time.Since(start))
+	})
+}
+
+// statusWriter records the status code of the response
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.status = statusCode
+	}
+
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusWriter) Write(data []byte) (int, error) {
+	w.wroteHeader = true
+
+	return w.ResponseWriter.Write(data)
+}
+
+// Unwrap returns the http.ResponseWriter whose status code is recorded
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,169 @@
+// Package slo measures the requests against the availability and latency
+// service level objectives of Pages. Besides the counters used by Prometheus
+// recording rules, it exposes the ratios of the last minutes computed
+// in-process, so simple deployments can alert on them directly.
+package slo
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+const (
+	// WindowSize is the duration the ratios are computed over
+	WindowSize = 5 * time.Minute
+	// windowBuckets is the number of buckets the window is split into, the
+	// oldest one is dropped as the window moves
+	windowBuckets = 5
+)
+
+// Thresholds are the latency objectives requests are measured against
+var Thresholds = []time.Duration{100 * time.Millisecond, 500 * time.Millisecond}
+
+var (
+	successRatioDesc = prometheus.NewDesc(
+		"gitlab_pages_slo_success_ratio",
+		"The ratio of requests served without a 5xx error over the last 5 minutes",
+		nil, nil,
+	)
+	latencyRatioDesc = prometheus.NewDesc(
+		"gitlab_pages_slo_latency_ratio",
+		"The ratio of requests served within the latency threshold over the last 5 minutes",
+		[]string{"threshold"}, nil,
+	)
+)
+
+type bucket struct {
+	start  time.Time
+	total  uint64
+	errors uint64
+	within []uint64
+}
+
+// Window counts the requests of the last WindowSize. It implements
+// prometheus.Collector to expose the success and latency ratios.
+type Window struct {
+	mu      sync.Mutex
+	buckets []bucket
+	now     func() time.Time
+}
+
+// NewWindow returns an empty window
+func NewWindow() *Window {
+	w := &Window{
+		buckets: make([]bucket, windowBuckets),
+		now:     time.Now,
+	}
+
+	for i := range w.buckets {
+		w.buckets[i].within = make([]uint64, len(Thresholds))
+	}
+
+	return w
+}
+
+// Observe records a request served with status in duration
+func (w *Window) Observe(status int, duration time.Duration) {
+	serverError := status >= http.StatusInternalServerError
+
+	metrics.SLORequests.Inc()
+	if serverError {
+		metrics.SLOServerErrors.Inc()
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	b := w.bucket(w.now())
+	b.total++
+	if serverError {
+		b.errors++
+	}
+
+	for i, threshold := range Thresholds {
+		if duration <= threshold {
+			b.within[i]++
+			metrics.SLORequestsWithinThreshold.WithLabelValues(threshold.String()).Inc()
+		}
+	}
+}
+
+// Ratios returns the ratio of requests without a 5xx error, and the ratios
+// of requests within each of the Thresholds. They are 1 without requests.
+func (w *Window) Ratios() (float64, []float64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := w.now()
+
+	var total, errors uint64
+	within := make([]uint64, len(Thresholds))
+
+	for i := range w.buckets {
+		b := &w.buckets[i]
+		if now.Sub(b.start) >= WindowSize {
+			continue
+		}
+
+		total += b.total
+		errors += b.errors
+		for j := range within {
+			within[j] += b.within[j]
+		}
+	}
+
+	latency := make([]float64, len(Thresholds))
+	if total == 0 {
+		for i := range latency {
+			latency[i] = 1
+		}
+
+		return 1, latency
+	}
+
+	for i := range latency {
+		latency[i] = float64(within[i]) / float64(total)
+	}
+
+	return 1 - float64(errors)/float64(total), latency
+}
+
+// bucket returns the bucket of now, resetting it when it was last used
+// during a previous window
+func (w *Window) bucket(now time.Time) *bucket {
+	width := WindowSize / windowBuckets
+	start := now.Truncate(width)
+
+	b := &w.buckets[int(start.UnixNano()/int64(width))%len(w.buckets)]
+	if !b.start.Equal(start) {
+		b.start = start
+		b.total = 0
+		b.errors = 0
+		for i := range b.within {
+			b.within[i] = 0
+		}
+	}
+
+	return b
+}
+
+// Describe implements prometheus.Collector
+func (w *Window) Describe(ch chan<- *prometheus.Desc) {
+	ch <- successRatioDesc
+	ch <- latencyRatioDesc
+}
+
+// Collect implements prometheus.Collector
+func (w *Window) Collect(ch chan<- prometheus.Metric) {
+	success, latency := w.Ratios()
+
+	ch <- prometheus.MustNewConstMetric(successRatioDesc, prometheus.GaugeValue, success)
+	for i, threshold := range Thresholds {
+		ch <- prometheus.MustNewConstMetric(latencyRatioDesc, prometheus.GaugeValue, latency[i], threshold.String())
+	}
+}
@@ -0,0 +1,90 @@
+package slo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+func TestWindowRatios(t *testing.T) {
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	w := NewWindow()
+	w.now = func() time.Time { return now }
+
+	success, latency := w.Ratios()
+	require.Equal(t, float64(1), success, "without requests")
+	require.Equal(t, []float64{1, 1}, latency)
+
+	w.Observe(http.StatusOK, 50*time.Millisecond)
+	w.Observe(http.StatusNotFound, 200*time.Millisecond)
+	w.Observe(http.StatusInternalServerError, time.Second)
+	w.Observe(http.StatusServiceUnavailable, 10*time.Millisecond)
+
+	success, latency = w.Ratios()
+	require.Equal(t, 0.5, success)
+	require.Equal(t, []float64{0.5, 0.75}, latency)
+
+	// the requests are still part of the window
+	now = now.Add(WindowSize - time.Minute)
+	w.Observe(http.StatusOK, time.Millisecond)
+
+	success, latency = w.Ratios()
+	require.Equal(t, 0.6, success)
+	require.Equal(t, []float64{0.6, 0.8}, latency)
+
+	// the first requests left the window
+	now = now.Add(time.Minute)
+
+	success, latency = w.Ratios()
+	require.Equal(t, float64(1), success)
+	require.Equal(t, []float64{1, 1}, latency)
+}
+
+func TestWindowCollect(t *testing.T) {
+	w := NewWindow()
+	w.Observe(http.StatusInternalServerError, time.Second)
+	w.Observe(http.StatusOK, 300*time.Millisecond)
+
+	expected := `
+# HELP gitlab_pages_slo_latency_ratio The ratio of requests served within the latency threshold over the last 5 minutes
+# TYPE gitlab_pages_slo_latency_ratio gauge
+gitlab_pages_slo_latency_ratio{threshold="100ms"} 0
+gitlab_pages_slo_latency_ratio{threshold="500ms"} 0.5
+# HELP gitlab_pages_slo_success_ratio The ratio of requests served without a 5xx error over the last 5 minutes
+# TYPE gitlab_pages_slo_success_ratio gauge
+gitlab_pages_slo_success_ratio 0.5
+`
+
+	require.NoError(t, testutil.CollectAndCompare(w, strings.NewReader(expected)))
+}
+
+func TestMiddleware(t *testing.T) {
+	requests := testutil.ToFloat64(metrics.SLORequests)
+	serverErrors := testutil.ToFloat64(metrics.SLOServerErrors)
+
+	w := NewWindow()
+
+	for _, status := range []int{http.StatusOK, http.StatusBadGateway} {
+		handler := NewMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			rw.WriteHeader(status)
+			rw.Write([]byte("body"))
+		}), w)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Equal(t, status, rr.Code)
+	}
+
+	success, _ := w.Ratios()
+	require.Equal(t, 0.5, success)
+	require.Equal(t, requests+2, testutil.ToFloat64(metrics.SLORequests))
+	require.Equal(t, serverErrors+1, testutil.ToFloat64(metrics.SLOServerErrors))
+}
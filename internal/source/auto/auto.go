@@ -0,0 +1,260 @@
+// Package auto implements a domains source using the GitLab API, which falls
+// back to serving the domains from disk while the API is unhealthy
+package auto
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/cachedump"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/handoff"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/hooks"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/pageserrors"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+// names of the sources reported by metrics.DomainsSourceRequests
+const (
+	sourceGitLab   = "gitlab"
+	sourceHostname = "hostname"
+)
+
+// DefaultCheckInterval is the interval between the checks of the GitLab API
+// status
+const DefaultCheckInterval = 10 * time.Second
+
+// FailureThreshold is the number of consecutive failures of the GitLab source,
+// to retrieve a domain or check its status, after which it is unhealthy
+const FailureThreshold = 3
+
+var (
+	// ErrNoRefresh is returned when refreshing a domain of a source which
+	// does not cache domains
+	ErrNoRefresh = errors.New("the GitLab source cannot refresh domains")
+	// ErrRestricted is returned, as an unavailable source error, for the
+	// domains which can not be served by the hostname source because they
+	// are not known to be unrestricted
+	ErrRestricted = errors.New("the domain may restrict its visitors and can only be served by the GitLab source")
+)
+
+// StatusChecker checks the connectivity with the GitLab API
+type StatusChecker interface {
+	Status(ctx context.Context) error
+}
+
+// Restrictor is implemented by the GitLab source, to report the domains which
+// do not restrict their visitors, with access control, IP access lists or an
+// ownership to verify
+type Restrictor interface {
+	Unrestricted(name string) bool
+}
+
+// Auto source retrieves the domains from the GitLab source while it is
+// healthy, and from the hostname source otherwise. The GitLab source becomes
+// unhealthy after FailureThreshold consecutive failures to retrieve a domain
+// or check its status, and healthy again once its status check succeeds.
+//
+// The hostname source does not know about access control, so it only serves
+// the domains whose last configuration retrieved from GitLab is unrestricted.
+// The other domains fail as unavailable until GitLab is healthy again.
+type Auto struct {
+	gitlab   source.Source
+	hostname source.Source
+	checker  StatusChecker
+
+	mu      sync.RWMutex
+	healthy bool
+
+	failures int32
+}
+
+// New returns a source retrieving the domains from gitlab, and from hostname
+// while checker reports the GitLab API as unhealthy
+func New(gitlab, hostname source.Source, checker StatusChecker) *Auto {
+	a := &Auto{
+		gitlab:   gitlab,
+		hostname: hostname,
+		checker:  checker,
+	}
+	a.setHealthy(true)
+
+	return a
+}
+
+// GetDomain returns the domain from the active source. Once the GitLab source
+// is unhealthy, the unrestricted domains are retrieved from the hostname
+// source and the GitLab source is not used until it is healthy again.
+func (a *Auto) GetDomain(ctx context.Context, name string) (*domain.Domain, error) {
+	if a.isHealthy() {
+		d, err := a.gitlab.GetDomain(ctx, name)
+		if err == nil || pageserrors.CategoryOf(err) != pageserrors.SourceUnavailable {
+			a.resetFailures()
+			metrics.DomainsSourceRequests.WithLabelValues(sourceGitLab).Inc()
+			return d, err
+		}
+
+		if !a.fail(err) {
+			metrics.DomainsSourceRequests.WithLabelValues(sourceGitLab).Inc()
+			return nil, err
+		}
+	}
+
+	if !a.unrestricted(name) {
+		return nil, pageserrors.Wrap(pageserrors.SourceUnavailable, ErrRestricted)
+	}
+
+	metrics.DomainsSourceRequests.WithLabelValues(sourceHostname).Inc()
+
+	return a.hostname.GetDomain(ctx, name)
+}
+
+// unrestricted returns true when the GitLab source reports that the domain
+// does not restrict its visitors
+func (a *Auto) unrestricted(name string) bool {
+	restrictor, ok := a.gitlab.(Restrictor)
+
+	return ok && restrictor.Unrestricted(name)
+}
+
+// Run checks the status of the GitLab API every interval until ctx is done
+func (a *Auto) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.check(ctx, interval)
+		}
+	}
+}
+
+// check updates the health of the GitLab source with its status
+func (a *Auto) check(ctx context.Context, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := a.checker.Status(ctx); err != nil {
+		a.fail(err)
+		return
+	}
+
+	a.resetFailures()
+
+	if !a.isHealthy() {
+		log.Info("the GitLab API is available again, switching back to the GitLab source")
+		a.setHealthy(true)
+	}
+}
+
+// fail counts a failure of the GitLab source, it returns true when the
+// source is unhealthy
+func (a *Auto) fail(err error) bool {
+	failures := atomic.AddInt32(&a.failures, 1)
+	if failures < FailureThreshold {
+		return !a.isHealthy()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.healthy {
+		log.WithError(err).WithField("failures", failures).Error("the GitLab API is unavailable, falling back to the hostname source")
+		a.setHealthyLocked(false)
+	}
+
+	return true
+}
+
+// resetFailures is called on each success of the GitLab source, it only
+// writes the counter after failures
+func (a *Auto) resetFailures() {
+	if atomic.LoadInt32(&a.failures) != 0 {
+		atomic.StoreInt32(&a.failures, 0)
+	}
+}
+
+func (a *Auto) isHealthy() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.healthy
+}
+
+func (a *Auto) setHealthy(healthy bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.setHealthyLocked(healthy)
+}
+
+func (a *Auto) setHealthyLocked(healthy bool) {
+	a.healthy = healthy
+
+	active, inactive := sourceGitLab, sourceHostname
+	if !healthy {
+		active, inactive = inactive, active
+	}
+
+	metrics.DomainsSourceActive.WithLabelValues(active).Set(1)
+	metrics.DomainsSourceActive.WithLabelValues(inactive).Set(0)
+}
+
+// DumpDomains returns the domains cached by the GitLab source
+func (a *Auto) DumpDomains(match func(name string) bool) []cachedump.Domain {
+	if dumper, ok := a.gitlab.(cachedump.DomainsDumper); ok {
+		return dumper.DumpDomains(match)
+	}
+
+	return nil
+}
+
+// ExportDomains returns the domains cached by the GitLab source to hand them
+// off to the next process
+func (a *Auto) ExportDomains() []handoff.Domain {
+	if c, ok := a.gitlab.(handoff.DomainsCache); ok {
+		return c.ExportDomains()
+	}
+
+	return nil
+}
+
+// ImportDomains caches the domains handed off by the previous process in the
+// GitLab source
+func (a *Auto) ImportDomains(domains []handoff.Domain) int {
+	if c, ok := a.gitlab.(handoff.DomainsCache); ok {
+		return c.ImportDomains(domains)
+	}
+
+	return 0
+}
+
+// PreloadArchives opens the archives handed off by the previous process with
+// the GitLab source
+func (a *Auto) PreloadArchives(ctx context.Context, domains []handoff.Domain, keys []string) int {
+	if p, ok := a.gitlab.(handoff.ArchivesPreloader); ok {
+		return p.PreloadArchives(ctx, domains, keys)
+	}
+
+	return 0
+}
+
+// RefreshDomain refreshes the domain cached by the GitLab source, even while
+// it is unhealthy so the domain is up to date once it is healthy again
+func (a *Auto) RefreshDomain(ctx context.Context, name string, preload bool) error {
+	refresher, ok := a.gitlab.(hooks.Refresher)
+	if !ok {
+		return ErrNoRefresh
+	}
+
+	return refresher.RefreshDomain(ctx, name, preload)
+}
@@ -0,0 +1,162 @@
+package auto
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/pageserrors"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+var errUnavailable = pageserrors.New(pageserrors.SourceUnavailable, "connection refused")
+
+type stubSource struct {
+	name       string
+	err        error
+	requests   int
+	refreshed  []string
+	restricted bool
+}
+
+func (s *stubSource) GetDomain(ctx context.Context, name string) (*domain.Domain, error) {
+	s.requests++
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	return domain.New(s.name, "", "", nil), nil
+}
+
+func (s *stubSource) Unrestricted(name string) bool {
+	return !s.restricted
+}
+
+func (s *stubSource) RefreshDomain(ctx context.Context, name string, preload bool) error {
+	s.refreshed = append(s.refreshed, name)
+	return nil
+}
+
+type stubChecker struct {
+	err error
+}
+
+func (c *stubChecker) Status(context.Context) error {
+	return c.err
+}
+
+func TestGetDomainFallsBackWhenGitLabIsUnavailable(t *testing.T) {
+	gitlab := &stubSource{name: "gitlab"}
+	hostname := &stubSource{name: "hostname"}
+	checker := &stubChecker{}
+	a := New(gitlab, hostname, checker)
+
+	d, err := a.GetDomain(context.Background(), "group.gitlab.io")
+	require.NoError(t, err)
+	require.Equal(t, "gitlab", d.Name)
+
+	gitlab.err = errUnavailable
+	checker.err = errUnavailable
+
+	for i := 1; i < FailureThreshold; i++ {
+		_, err = a.GetDomain(context.Background(), "group.gitlab.io")
+		require.ErrorIs(t, err, errUnavailable, "GitLab is healthy until the failure threshold")
+	}
+
+	d, err = a.GetDomain(context.Background(), "group.gitlab.io")
+	require.NoError(t, err)
+	require.Equal(t, "hostname", d.Name, "the request reaching the failure threshold falls back")
+
+	d, err = a.GetDomain(context.Background(), "group.gitlab.io")
+	require.NoError(t, err)
+	require.Equal(t, "hostname", d.Name)
+	require.Equal(t, FailureThreshold+1, gitlab.requests, "GitLab is not used while it is unhealthy")
+	require.Equal(t, float64(1), testutil.ToFloat64(metrics.DomainsSourceActive.WithLabelValues("hostname")))
+
+	a.check(context.Background(), DefaultCheckInterval)
+	d, _ = a.GetDomain(context.Background(), "group.gitlab.io")
+	require.Equal(t, "hostname", d.Name, "GitLab is unhealthy while its status check fails")
+
+	gitlab.err = nil
+	checker.err = nil
+	a.check(context.Background(), DefaultCheckInterval)
+
+	before := testutil.ToFloat64(metrics.DomainsSourceRequests.WithLabelValues("gitlab"))
+
+	d, err = a.GetDomain(context.Background(), "group.gitlab.io")
+	require.NoError(t, err)
+	require.Equal(t, "gitlab", d.Name)
+	require.Equal(t, before+1, testutil.ToFloat64(metrics.DomainsSourceRequests.WithLabelValues("gitlab")))
+	require.Equal(t, float64(1), testutil.ToFloat64(metrics.DomainsSourceActive.WithLabelValues("gitlab")))
+}
+
+func TestGetDomainFailuresMustBeConsecutive(t *testing.T) {
+	gitlab := &stubSource{name: "gitlab"}
+	a := New(gitlab, &stubSource{name: "hostname"}, &stubChecker{})
+
+	for i := 0; i < 2*FailureThreshold; i++ {
+		gitlab.err = errUnavailable
+		if i%2 == 1 {
+			gitlab.err = nil
+		}
+
+		_, _ = a.GetDomain(context.Background(), "group.gitlab.io")
+	}
+
+	require.True(t, a.isHealthy())
+}
+
+func TestGetDomainFailsClosedForRestrictedDomains(t *testing.T) {
+	gitlab := &stubSource{name: "gitlab", restricted: true}
+	hostname := &stubSource{name: "hostname"}
+	a := New(gitlab, hostname, &stubChecker{err: errUnavailable})
+
+	for i := 0; i < FailureThreshold; i++ {
+		a.check(context.Background(), DefaultCheckInterval)
+	}
+
+	_, err := a.GetDomain(context.Background(), "private.gitlab.io")
+	require.ErrorIs(t, err, ErrRestricted)
+	require.Equal(t, pageserrors.SourceUnavailable, pageserrors.CategoryOf(err))
+	require.Zero(t, hostname.requests)
+}
+
+func TestGetDomainDoesNotFallBackForOtherErrors(t *testing.T) {
+	gitlab := &stubSource{err: domain.ErrDomainDoesNotExist}
+	hostname := &stubSource{name: "hostname"}
+	a := New(gitlab, hostname, &stubChecker{})
+
+	_, err := a.GetDomain(context.Background(), "missing.gitlab.io")
+	require.True(t, errors.Is(err, domain.ErrDomainDoesNotExist))
+	require.Zero(t, hostname.requests)
+	require.True(t, a.isHealthy())
+}
+
+func TestCheckMarksGitLabUnhealthy(t *testing.T) {
+	gitlab := &stubSource{name: "gitlab"}
+	a := New(gitlab, &stubSource{name: "hostname"}, &stubChecker{err: errUnavailable})
+
+	for i := 0; i < FailureThreshold; i++ {
+		a.check(context.Background(), DefaultCheckInterval)
+	}
+
+	d, err := a.GetDomain(context.Background(), "group.gitlab.io")
+	require.NoError(t, err)
+	require.Equal(t, "hostname", d.Name)
+	require.Zero(t, gitlab.requests)
+}
+
+func TestRefreshDomainUsesGitLab(t *testing.T) {
+	gitlab := &stubSource{}
+	a := New(gitlab, &stubSource{}, &stubChecker{err: errUnavailable})
+	for i := 0; i < FailureThreshold; i++ {
+		a.check(context.Background(), DefaultCheckInterval)
+	}
+
+	require.NoError(t, a.RefreshDomain(context.Background(), "group.gitlab.io", false))
+	require.Equal(t, []string{"group.gitlab.io"}, gitlab.refreshed)
+}
@@ -0,0 +1,26 @@
+package api
+
+import "time"
+
+// AccessSummary aggregates the requests served for a domain during a
+// reporting interval, it is sent to GitLab to show Pages analytics to the
+// owners of the project
+type AccessSummary struct {
+	Domain string    `json:"domain"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+
+	Requests uint64 `json:"requests"`
+	// Bytes is the size of the response bodies served
+	Bytes uint64 `json:"bytes"`
+	// StatusCodes counts the requests by response status code
+	StatusCodes map[int]uint64 `json:"status_codes"`
+	// TopPaths are the most requested paths, by descending number of requests
+	TopPaths []PathCount `json:"top_paths"`
+}
+
+// PathCount is the number of requests to a path
+type PathCount struct {
+	Path     string `json:"path"`
+	Requests uint64 `json:"requests"`
+}
@@ -1,5 +1,10 @@
 package api
 
+import (
+	"encoding/json"
+	"time"
+)
+
 // LookupPath represents a lookup path for a virtual domain
 type LookupPath struct {
 	ProjectID     int    `json:"project_id,omitempty"`
@@ -7,6 +12,40 @@ type LookupPath struct {
 	HTTPSOnly     bool   `json:"https_only,omitempty"`
 	Prefix        string `json:"prefix,omitempty"`
 	Source        Source `json:"source,omitempty"`
+	// LanguageNegotiation enables serving `index.<lang>.html` variants
+	// based on the Accept-Language header
+	LanguageNegotiation bool `json:"language_negotiation,omitempty"`
+	// FallbackPrefix is the directory, e.g. `latest`, serving the pages not
+	// found under another top-level directory of versioned documentation
+	FallbackPrefix string `json:"fallback_prefix,omitempty"`
+	// CaseInsensitive redirects the paths matching a file ignoring case to
+	// the file, for sites migrated from case-insensitive hosts
+	CaseInsensitive bool `json:"case_insensitive_paths,omitempty"`
+	// Namespace is the full path of the namespace of the project, e.g.
+	// `group/subgroup`
+	Namespace string `json:"namespace,omitempty"`
+	// SPAFallback serves the index.html of the project for the paths not
+	// found, for single-page applications routing on the client
+	SPAFallback bool `json:"spa_fallback,omitempty"`
+	// DirectoryListing serves a generated index of the files of the
+	// directories without an index.html
+	DirectoryListing bool `json:"listing,omitempty"`
+	// IndexFiles are the names of the files served for directories,
+	// overriding the ones of the instance
+	IndexFiles []string `json:"index_files,omitempty"`
+	// CanonicalDomain is the primary custom domain of the project, the
+	// requests to the project on the pages domain are redirected to it
+	CanonicalDomain string `json:"canonical_domain,omitempty"`
+	// IPAccess restricts the clients allowed to view the project by IP
+	// address
+	IPAccess *IPAccess `json:"ip_access,omitempty"`
+}
+
+// IPAccess describes the IP addresses and CIDR ranges of the clients allowed
+// and denied to view a project
+type IPAccess struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
 }
 
 // Source describes GitLab Page serving variant
@@ -16,4 +55,28 @@ type Source struct {
 	SHA256 string `json:"sha256,omitempty"`
 	Count  int    `json:"file_count,omitempty"`
 	Size   int    `json:"file_size,omitempty"`
+	// CreatedAt is the time the deployment was created
+	CreatedAt time.Time `json:"created_at,omitempty"`
+}
+
+// UnmarshalJSON decodes the source, an empty created_at is left as the zero
+// time like a missing one as the creation time of the deployment is unknown
+func (s *Source) UnmarshalJSON(data []byte) error {
+	type source Source
+
+	aux := struct {
+		*source
+		CreatedAt string `json:"created_at,omitempty"`
+	}{source: (*source)(s)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	if aux.CreatedAt == "" {
+		s.CreatedAt = time.Time{}
+		return nil
+	}
+
+	return s.CreatedAt.UnmarshalText([]byte(aux.CreatedAt))
 }
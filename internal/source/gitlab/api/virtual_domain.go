@@ -3,8 +3,54 @@ package api
 // VirtualDomain represents a GitLab Pages virtual domain that is being sent
 // from GitLab API
 type VirtualDomain struct {
-	Certificate string `json:"certificate,omitempty"`
-	Key         string `json:"key,omitempty"`
+	Certificate string     `json:"certificate,omitempty"`
+	Key         string     `json:"key,omitempty"`
+	TLS         *TLSPolicy `json:"tls,omitempty"`
+
+	// Embedding allows the pages of the domain, including the ones with
+	// access control, to be embedded in frames of the listed sites
+	Embedding *EmbeddingPolicy `json:"embedding,omitempty"`
+
+	// FeatureFlags enables or disables features for this domain, overriding
+	// the instance defaults
+	FeatureFlags map[string]bool `json:"feature_flags,omitempty"`
+
+	// PagesURL is the URL of the project of a custom domain on the pages
+	// domain, e.g. https://group.gitlab.io/project
+	PagesURL string `json:"pages_url,omitempty"`
+	// PagesDomainRedirect opts the custom domain out of the redirect to the
+	// PagesURL of HTTP requests when it is false
+	PagesDomainRedirect *bool `json:"pages_domain_redirect,omitempty"`
+
+	// PrimaryDomain and Aliases are the hostnames sharing this configuration,
+	// GitLab returns the same response for each of them
+	PrimaryDomain string   `json:"primary_domain,omitempty"`
+	Aliases       []string `json:"aliases,omitempty"`
+	// RedirectAliases redirects the requests to the aliases to PrimaryDomain
+	// instead of serving them
+	RedirectAliases bool `json:"redirect_aliases,omitempty"`
+
+	// ServeBlockedExtensions opts the domain out of the extensions blocked
+	// by the instance, e.g. for sites publishing public keys as .pem files
+	ServeBlockedExtensions bool `json:"serve_blocked_extensions,omitempty"`
+
+	// Verified is false for custom domains whose ownership is not verified,
+	// or was not verified again in time, which must not serve their project.
+	// Domains are verified when it is not set, like with older GitLab versions.
+	Verified *bool `json:"verified,omitempty"`
 
 	LookupPaths []LookupPath `json:"lookup_paths"`
 }
+
+// TLSPolicy describes TLS settings that override the instance defaults for
+// a virtual domain. Unset values fall back to the instance configuration.
+type TLSPolicy struct {
+	MinVersion string `json:"min_version,omitempty"`
+	HTTP2      *bool  `json:"http2,omitempty"`
+}
+
+// EmbeddingPolicy describes the sites allowed to embed the pages of a virtual
+// domain in frames, as sources of the frame-ancestors CSP directive
+type EmbeddingPolicy struct {
+	FrameAncestors []string `json:"frame_ancestors"`
+}
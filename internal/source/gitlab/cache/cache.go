@@ -4,7 +4,10 @@ import (
 	"context"
 	"fmt"
 
+	"gitlab.com/gitlab-org/gitlab-pages/internal/cachedump"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/debugtrace"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/handoff"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
 	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
@@ -76,8 +79,11 @@ func NewCache(client api.Client, cc *config.Cache) *Cache {
 func (c *Cache) Resolve(ctx context.Context, domain string) *api.Lookup {
 	entry := c.store.LoadOrCreate(domain)
 
+	trace := debugtrace.FromContext(ctx)
+
 	if entry.IsUpToDate() {
 		metrics.DomainsSourceCacheHit.Inc()
+		trace.Add("domain_cache", "hit")
 		return entry.Lookup()
 	}
 
@@ -85,17 +91,59 @@ func (c *Cache) Resolve(ctx context.Context, domain string) *api.Lookup {
 		c.Refresh(entry)
 
 		metrics.DomainsSourceCacheHit.Inc()
+		trace.Add("domain_cache", "hit-refresh")
 		return entry.Lookup()
 	}
 
 	metrics.DomainsSourceCacheMiss.Inc()
+	trace.Add("domain_cache", "miss")
 	return c.retrieve(ctx, entry)
 }
 
+// Peek returns the cached lookup of the domain without retrieving it from
+// GitLab, it returns nil when the domain is not cached or not retrieved yet
+func (c *Cache) Peek(domain string) *api.Lookup {
+	entry, ok := c.store.Load(domain)
+	if !ok {
+		return nil
+	}
+
+	return entry.Lookup()
+}
+
+// Evict removes the domain from the cache so the next Resolve retrieves its
+// latest configuration from the GitLab API, e.g. after a new deployment
+func (c *Cache) Evict(domain string) {
+	c.store.Delete(domain)
+}
+
+// DumpDomains returns the cached domains for which match returns true
+func (c *Cache) DumpDomains(match func(name string) bool) []cachedump.Domain {
+	return c.store.Dump(match)
+}
+
+// ExportDomains returns the retrieved domains to hand them off to the next
+// process
+func (c *Cache) ExportDomains() []handoff.Domain {
+	return c.store.Export()
+}
+
+// ImportDomains caches the domains handed off by the previous process, it
+// returns the number of domains cached
+func (c *Cache) ImportDomains(domains []handoff.Domain) int {
+	return c.store.Import(domains)
+}
+
 func (c *Cache) retrieve(ctx context.Context, entry *Entry) *api.Lookup {
 	// We run the code within an additional func() to run both `e.setResponse`
 	// and `c.retriever.Retrieve` asynchronously.
-	entry.retrieve.Do(func() { go func() { entry.setResponse(c.retriever.Retrieve(ctx, entry.domain)) }() })
+	entry.retrieve.Do(func() {
+		go func() {
+			lookup := c.retriever.Retrieve(ctx, entry.domain)
+			c.setAliases(entry.domain, lookup)
+			entry.setResponse(lookup)
+		}()
+	})
 
 	var lookup *api.Lookup
 	select {
@@ -108,6 +156,23 @@ func (c *Cache) retrieve(ctx context.Context, entry *Entry) *api.Lookup {
 	return lookup
 }
 
+// setAliases makes the other hostnames of the retrieved domain share its
+// entry, instead of retrieving and caching the same configuration for each
+func (c *Cache) setAliases(domain string, lookup api.Lookup) {
+	if lookup.Error != nil || lookup.Domain == nil {
+		return
+	}
+
+	names := lookup.Domain.Aliases
+	if primary := lookup.Domain.PrimaryDomain; primary != "" {
+		names = append([]string{primary}, names...)
+	}
+
+	if len(names) > 0 {
+		c.store.SetAliases(domain, names)
+	}
+}
+
 // Refresh will update the entry in the store only when it gets resolved successfully.
 // If an existing successful entry exists, it will only be replaced if the new resolved
 // entry is successful too.
@@ -3,15 +3,22 @@ package cache
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 
+	"gitlab.com/gitlab-org/gitlab-pages/internal/cachedump"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/fixture"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/handoff"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
 var testCacheConfig = config.Cache{
@@ -240,3 +247,260 @@ func TestResolve(t *testing.T) {
 		})
 	})
 }
+
+func TestEvict(t *testing.T) {
+	withTestCache(resolverConfig{buffered: true}, nil, func(cache *Cache, resolver *clientMock) {
+		cache.withTestEntry(entryConfig{retrieved: true}, func(entry *Entry) {
+			lookup := cache.Resolve(context.Background(), "my.gitlab.com")
+			require.NoError(t, lookup.Error)
+			require.Empty(t, resolver.lookups, "the entry is up to date")
+
+			cache.Evict("my.gitlab.com")
+
+			resolver.domain <- "my.gitlab.com"
+			lookup = cache.Resolve(context.Background(), "my.gitlab.com")
+
+			require.NoError(t, lookup.Error)
+			require.Equal(t, uint64(1), <-resolver.lookups, "the lookup is retrieved again")
+		})
+	})
+}
+
+type aliasesClientMock struct {
+	lookups uint64
+}
+
+func (c *aliasesClientMock) GetLookup(_ context.Context, name string) api.Lookup {
+	atomic.AddUint64(&c.lookups, 1)
+
+	return api.Lookup{
+		Name: name,
+		Domain: &api.VirtualDomain{
+			PrimaryDomain: "primary.com",
+			Aliases:       []string{"alias.com", "www.primary.com"},
+		},
+	}
+}
+
+func (c *aliasesClientMock) Status() error {
+	return nil
+}
+
+func TestResolveAliases(t *testing.T) {
+	client := &aliasesClientMock{}
+	cache := NewCache(client, &testCacheConfig)
+
+	lookup := cache.Resolve(context.Background(), "alias.com")
+	require.NoError(t, lookup.Error)
+	require.Equal(t, "primary.com", lookup.Domain.PrimaryDomain)
+
+	for _, name := range []string{"primary.com", "www.primary.com", "alias.com"} {
+		require.Same(t, lookup, cache.Resolve(context.Background(), name), name)
+	}
+
+	require.Equal(t, uint64(1), atomic.LoadUint64(&client.lookups), "the aliases share the entry")
+
+	cache.Evict("www.primary.com")
+
+	require.NoError(t, cache.Resolve(context.Background(), "primary.com").Error)
+	require.Equal(t, uint64(2), atomic.LoadUint64(&client.lookups), "evicting an alias evicts its domain")
+}
+
+func TestPeek(t *testing.T) {
+	client := &aliasesClientMock{}
+	cache := NewCache(client, &testCacheConfig)
+
+	require.Nil(t, cache.Peek("primary.com"), "domains are not retrieved")
+	require.Zero(t, atomic.LoadUint64(&client.lookups))
+
+	lookup := cache.Resolve(context.Background(), "primary.com")
+	require.Same(t, lookup, cache.Peek("primary.com"))
+	require.Same(t, lookup, cache.Peek("www.primary.com"), "aliases share the lookup of their domain")
+}
+
+func TestSetAliasesKeepsEntries(t *testing.T) {
+	store := newMemStore(&testCacheConfig)
+
+	primary := store.LoadOrCreate("primary.com")
+	alias := store.LoadOrCreate("alias.com")
+
+	store.SetAliases("primary.com", []string{"primary.com", "alias.com", "www.primary.com"})
+
+	require.Same(t, alias, store.LoadOrCreate("alias.com"), "existing entries are not replaced")
+	require.Same(t, primary, store.LoadOrCreate("www.primary.com"))
+}
+
+func TestMaxEntries(t *testing.T) {
+	evictions := testutil.ToFloat64(metrics.DomainsSourceCacheEvictions)
+	entries := testutil.ToFloat64(metrics.DomainsSourceCacheEntries)
+
+	cc := testCacheConfig
+	cc.MaxEntries = 2
+	store := newMemStore(&cc)
+
+	a := store.LoadOrCreate("a.com")
+	b := store.LoadOrCreate("b.com")
+
+	// a.com becomes the most recently used, so b.com is evicted first
+	require.Same(t, a, store.LoadOrCreate("a.com"))
+	store.LoadOrCreate("c.com")
+
+	require.Same(t, a, store.LoadOrCreate("a.com"))
+	require.NotSame(t, b, store.LoadOrCreate("b.com"), "b.com was evicted")
+	require.Equal(t, evictions+2, testutil.ToFloat64(metrics.DomainsSourceCacheEvictions), "c.com was evicted by b.com")
+	require.Equal(t, entries+2, testutil.ToFloat64(metrics.DomainsSourceCacheEntries))
+
+	store.Delete("a.com")
+	require.Equal(t, entries+1, testutil.ToFloat64(metrics.DomainsSourceCacheEntries))
+}
+
+func TestMaxEntriesAliases(t *testing.T) {
+	cc := testCacheConfig
+	cc.MaxEntries = 3
+	store := newMemStore(&cc)
+
+	primary := store.LoadOrCreate("primary.com")
+	store.SetAliases("primary.com", []string{"alias.com"})
+	store.LoadOrCreate("other.com")
+
+	// resolving the alias keeps the entry of its domain
+	require.Same(t, primary, store.LoadOrCreate("alias.com"))
+	store.LoadOrCreate("new.com")
+
+	require.Same(t, primary, store.LoadOrCreate("alias.com"))
+	require.Same(t, primary, store.LoadOrCreate("primary.com"))
+}
+
+func TestMaxEntriesDeletesExpired(t *testing.T) {
+	cc := testCacheConfig
+	cc.MaxEntries = 10
+	cc.CacheExpiry = 10 * time.Millisecond
+	cc.CacheCleanupInterval = 10 * time.Millisecond
+	store := newMemStore(&cc).(*memstore)
+
+	store.LoadOrCreate("expired.com")
+	time.Sleep(2 * cc.CacheExpiry)
+	store.LoadOrCreate("new.com")
+
+	require.Equal(t, 1, store.lru.order.Len(), "the expired entries are deleted from the index")
+	_, exists := store.Load("expired.com")
+	require.False(t, exists)
+}
+
+func TestMaxEntriesConcurrentAccess(t *testing.T) {
+	cc := testCacheConfig
+	cc.MaxEntries = 8
+	store := newMemStore(&cc)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				store.LoadOrCreate("shared.com")
+				store.LoadOrCreate(fmt.Sprintf("%d-%d.com", i, j%10))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	require.LessOrEqual(t, store.(*memstore).lru.order.Len(), cc.MaxEntries)
+}
+
+type dumpClientMock struct{}
+
+func (c *dumpClientMock) GetLookup(_ context.Context, name string) api.Lookup {
+	if name == "missing.com" {
+		return api.Lookup{Name: name, Error: domain.ErrDomainDoesNotExist}
+	}
+
+	if name == "deleted.com" {
+		return api.Lookup{Name: name, Error: domain.ErrDomainDeleted}
+	}
+
+	return api.Lookup{
+		Name: name,
+		Domain: &api.VirtualDomain{
+			Certificate: fixture.Certificate,
+			Aliases:     []string{"www.primary.com"},
+			LookupPaths: []api.LookupPath{{
+				ProjectID: 123,
+				Prefix:    "/",
+				Source:    api.Source{Type: "zip", Path: "https://storage.example.com/signed", SHA256: "sha"},
+			}},
+		},
+	}
+}
+
+func (c *dumpClientMock) Status() error {
+	return nil
+}
+
+func TestDumpDomains(t *testing.T) {
+	cache := NewCache(&dumpClientMock{}, &testCacheConfig)
+
+	require.NoError(t, cache.Resolve(context.Background(), "primary.com").Error)
+	require.Error(t, cache.Resolve(context.Background(), "missing.com").Error)
+	require.Error(t, cache.Resolve(context.Background(), "deleted.com").Error)
+
+	domains := cache.DumpDomains(func(string) bool { return true })
+	require.Len(t, domains, 3)
+
+	deleted := domains[0]
+	require.Equal(t, "deleted.com", deleted.Name)
+	require.False(t, deleted.Exists)
+	require.True(t, deleted.Deleted)
+
+	missing := domains[1]
+	require.Equal(t, "missing.com", missing.Name)
+	require.True(t, missing.Retrieved)
+	require.False(t, missing.Exists)
+	require.False(t, missing.Deleted)
+	require.Equal(t, domain.ErrDomainDoesNotExist.Error(), missing.Error)
+
+	primary := domains[2]
+	require.Equal(t, "primary.com", primary.Name)
+	require.Equal(t, []string{"www.primary.com"}, primary.Aliases)
+	require.True(t, primary.Exists)
+	require.Equal(t, primary.Created.Add(testCacheConfig.EntryRefreshTimeout), primary.RefreshAt)
+	require.WithinDuration(t, primary.Created.Add(testCacheConfig.CacheExpiry), primary.ExpiresAt, 100*time.Millisecond)
+	require.Len(t, primary.Certificate.SHA256Fingerprint, 64)
+	require.Equal(t, []cachedump.LookupPath{{Prefix: "/", ProjectID: 123, SourceType: "zip", ArchiveKey: "sha"}}, primary.LookupPaths)
+
+	aliased := cache.DumpDomains(func(name string) bool { return name == "www.primary.com" })
+	require.Len(t, aliased, 1, "domains are matched by their aliases")
+	require.Equal(t, "primary.com", aliased[0].Name)
+}
+
+func TestHandoffDomains(t *testing.T) {
+	cache := NewCache(&dumpClientMock{}, &testCacheConfig)
+
+	require.NoError(t, cache.Resolve(context.Background(), "primary.com").Error)
+	require.Error(t, cache.Resolve(context.Background(), "missing.com").Error)
+	require.Error(t, cache.Resolve(context.Background(), "deleted.com").Error)
+	cache.store.LoadOrCreate("pending.com")
+
+	domains := cache.ExportDomains()
+	require.Len(t, domains, 3, "the domains being retrieved are not handed off")
+
+	next := NewCache(&clientMock{}, &testCacheConfig)
+	expired := handoff.Domain{Name: "expired.com", Created: time.Now().Add(-testCacheConfig.CacheExpiry)}
+	require.Equal(t, 3, next.ImportDomains(append(domains, expired)))
+
+	primary := next.Resolve(context.Background(), "www.primary.com")
+	require.NoError(t, primary.Error, "the aliases are handed off")
+	require.Equal(t, 123, primary.Domain.LookupPaths[0].ProjectID)
+
+	missing := next.Resolve(context.Background(), "missing.com")
+	require.ErrorIs(t, missing.Error, domain.ErrDomainDoesNotExist)
+	require.NotErrorIs(t, missing.Error, domain.ErrDomainDeleted)
+
+	deleted := next.Resolve(context.Background(), "deleted.com")
+	require.ErrorIs(t, deleted.Error, domain.ErrDomainDeleted, "the deleted domains are handed off")
+
+	entry := next.store.LoadOrCreate("primary.com")
+	require.True(t, entry.IsUpToDate(), "the domains are refreshed as if they were not handed off")
+	require.False(t, next.store.LoadOrCreate("expired.com").IsUpToDate(), "expired domains are not imported")
+}
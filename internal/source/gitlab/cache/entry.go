@@ -1,12 +1,18 @@
 package cache
 
 import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
 	"errors"
 	"os"
 	"sync"
 	"time"
 
+	"gitlab.com/gitlab-org/gitlab-pages/internal/cachedump"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/handoff"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
 )
 
@@ -109,3 +115,93 @@ func (e *Entry) hasTemporaryError() bool {
 		e.response.Error != nil &&
 		e.domainExists()
 }
+
+// dump returns the state of the entry for the cache dump
+func (e *Entry) dump() cachedump.Domain {
+	e.mux.RLock()
+	defer e.mux.RUnlock()
+
+	created := e.created
+	if !e.refreshedOriginalTimestamp.IsZero() {
+		created = e.refreshedOriginalTimestamp
+	}
+
+	d := cachedump.Domain{
+		Name:      e.domain,
+		Created:   created,
+		RefreshAt: created.Add(e.refreshTimeout),
+		Retrieved: e.isResolved(),
+	}
+
+	if !d.Retrieved {
+		return d
+	}
+
+	if e.response.Error != nil {
+		d.Error = e.response.Error.Error()
+		d.Deleted = errors.Is(e.response.Error, domain.ErrDomainDeleted)
+	}
+
+	if e.response.Domain == nil {
+		return d
+	}
+
+	d.Exists = true
+	if e.response.Domain.Certificate != "" {
+		d.Certificate = dumpCertificate(e.response.Domain.Certificate)
+	}
+
+	for _, lp := range e.response.Domain.LookupPaths {
+		d.LookupPaths = append(d.LookupPaths, cachedump.LookupPath{
+			Prefix:     lp.Prefix,
+			ProjectID:  lp.ProjectID,
+			SourceType: lp.Source.Type,
+			ArchiveKey: lp.Source.SHA256,
+			DeployedAt: lp.Source.CreatedAt,
+		})
+	}
+
+	return d
+}
+
+// export returns the configuration of the entry to hand it off, ok is false
+// when the entry is not retrieved or its retrieval failed
+func (e *Entry) export() (d handoff.Domain, ok bool) {
+	e.mux.RLock()
+	defer e.mux.RUnlock()
+
+	if !e.isResolved() || (e.response.Error != nil && e.domainExists()) {
+		return d, false
+	}
+
+	created := e.created
+	if !e.refreshedOriginalTimestamp.IsZero() {
+		created = e.refreshedOriginalTimestamp
+	}
+
+	return handoff.Domain{
+		Name:    e.domain,
+		Created: created,
+		Domain:  e.response.Domain,
+		Deleted: errors.Is(e.response.Error, domain.ErrDomainDeleted),
+	}, true
+}
+
+func dumpCertificate(certPEM string) *cachedump.Certificate {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		return &cachedump.Certificate{Error: "no PEM encoded certificate"}
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return &cachedump.Certificate{Error: err.Error()}
+	}
+
+	fingerprint := sha256.Sum256(cert.Raw)
+
+	return &cachedump.Certificate{
+		SHA256Fingerprint: hex.EncodeToString(fingerprint[:]),
+		NotAfter:          cert.NotAfter,
+	}
+}
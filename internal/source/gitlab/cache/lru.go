@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"container/list"
+	"sync/atomic"
+
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+// lruIndex orders the keys of the memstore by their use, so the least
+// recently used domains can be evicted once the cache is full. It is guarded
+// by the lock of the memstore: the lookups holding its read lock only mark
+// the keys they use, which are given a second chance when they would be
+// evicted, and the keys are only added, moved and removed holding its write
+// lock. It counts its keys in metrics.DomainsSourceCacheEntries, which adds
+// up the keys of the memstores of all the virtual instances.
+type lruIndex struct {
+	max int
+	// order holds the *lruKey from the most recently added or given a
+	// second chance at the front to the next evicted at the back
+	order    *list.List
+	elements map[string]*list.Element
+}
+
+type lruKey struct {
+	name string
+	used int32
+}
+
+func newLRUIndex(max int) *lruIndex {
+	return &lruIndex{
+		max:      max,
+		order:    list.New(),
+		elements: make(map[string]*list.Element),
+	}
+}
+
+// add indexes key and returns the least recently used keys exceeding the
+// maximum, which must be removed from the store. It must be called holding
+// the write lock of the memstore.
+func (l *lruIndex) add(key string) []string {
+	if e, ok := l.elements[key]; ok {
+		l.order.MoveToFront(e)
+		return nil
+	}
+
+	l.elements[key] = l.order.PushFront(&lruKey{name: key})
+	metrics.DomainsSourceCacheEntries.Inc()
+
+	var evicted []string
+	for l.order.Len() > l.max {
+		e := l.order.Back()
+		if k := e.Value.(*lruKey); atomic.LoadInt32(&k.used) == 1 {
+			atomic.StoreInt32(&k.used, 0)
+			l.order.MoveToFront(e)
+			continue
+		}
+
+		evicted = append(evicted, l.remove(e))
+	}
+
+	return evicted
+}
+
+// promote marks key as used, it only needs the read lock of the memstore
+func (l *lruIndex) promote(key string) {
+	e, ok := l.elements[key]
+	if !ok {
+		return
+	}
+
+	// the key is only written once until it is given a second chance
+	if k := e.Value.(*lruKey); atomic.LoadInt32(&k.used) == 0 {
+		atomic.StoreInt32(&k.used, 1)
+	}
+}
+
+// delete removes key from the index. It must be called holding the write
+// lock of the memstore.
+func (l *lruIndex) delete(key string) {
+	if e, ok := l.elements[key]; ok {
+		l.remove(e)
+	}
+}
+
+func (l *lruIndex) remove(e *list.Element) string {
+	key := l.order.Remove(e).(*lruKey).name
+	delete(l.elements, key)
+	metrics.DomainsSourceCacheEntries.Dec()
+
+	return key
+}
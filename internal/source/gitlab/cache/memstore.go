@@ -1,60 +1,308 @@
 package cache
 
 import (
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/patrickmn/go-cache"
 
+	"gitlab.com/gitlab-org/gitlab-pages/internal/cachedump"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/handoff"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
+// alias is stored instead of an entry for the alias hostnames of a domain,
+// it is the hostname the entry of the domain is stored under
+type alias string
+
 type memstore struct {
 	store                  *cache.Cache
 	mux                    *sync.RWMutex
 	entryRefreshTimeout    time.Duration
 	entryExpirationTimeout time.Duration
+	// lru is nil when the number of entries is not limited
+	lru             *lruIndex
+	cleanupInterval time.Duration
+	cleaned         time.Time
 }
 
 func newMemStore(cc *config.Cache) Store {
-	return &memstore{
-		store:                  cache.New(cc.CacheExpiry, cc.CacheCleanupInterval),
+	m := &memstore{
 		mux:                    &sync.RWMutex{},
 		entryRefreshTimeout:    cc.EntryRefreshTimeout,
 		entryExpirationTimeout: cc.CacheExpiry,
 	}
+
+	if cc.MaxEntries <= 0 {
+		m.store = cache.New(cc.CacheExpiry, cc.CacheCleanupInterval)
+		return m
+	}
+
+	// the expired entries are deleted by m.set instead of the janitor of
+	// the store, as deleting them changes m.lru which is guarded by m.mux
+	m.store = cache.New(cc.CacheExpiry, 0)
+	m.cleanupInterval = cc.CacheCleanupInterval
+	m.lru = newLRUIndex(cc.MaxEntries)
+	// called when entries are deleted, including by m.set
+	m.store.OnEvicted(func(key string, _ interface{}) {
+		m.lru.delete(key)
+	})
+
+	return m
 }
 
 // LoadOrCreate writes or retrieves a domain entry from the cache in a
 // thread-safe way, trying to make this read-preferring RW locking.
 func (m *memstore) LoadOrCreate(domain string) *Entry {
 	m.mux.RLock()
-	entry, exists := m.store.Get(domain)
+	entry, exists := m.load(domain)
 	m.mux.RUnlock()
 
 	if exists {
-		return entry.(*Entry)
+		return entry
 	}
 
 	m.mux.Lock()
 	defer m.mux.Unlock()
 
-	if entry, exists = m.store.Get(domain); exists {
-		return entry.(*Entry)
+	if entry, exists = m.load(domain); exists {
+		return entry
 	}
 
 	newEntry := newCacheEntry(domain, m.entryRefreshTimeout, m.entryExpirationTimeout)
-	m.store.SetDefault(domain, newEntry)
+	m.set(domain, newEntry)
 
 	return newEntry
 }
 
+// Load retrieves a domain entry from the cache without creating it when it
+// does not exist
+func (m *memstore) Load(domain string) (*Entry, bool) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+
+	return m.load(domain)
+}
+
 func (m *memstore) ReplaceOrCreate(domain string, entry *Entry) *Entry {
 	m.mux.Lock()
 	defer m.mux.Unlock()
 
 	m.store.Delete(domain)
-	m.store.SetDefault(domain, entry)
+	m.set(domain, entry)
 
 	return entry
 }
+
+// Delete removes a domain entry from the cache, the next lookup of the domain
+// retrieves it again. Deleting an alias removes the entry of its domain too.
+func (m *memstore) Delete(domain string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if item, exists := m.store.Get(domain); exists {
+		if name, ok := item.(alias); ok {
+			m.store.Delete(string(name))
+		}
+	}
+
+	m.store.Delete(domain)
+}
+
+// SetAliases makes the aliases resolve to the entry of the domain. Existing
+// entries of the aliases are left untouched, so aliases never point to other
+// aliases.
+func (m *memstore) SetAliases(domain string, aliases []string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	for _, name := range aliases {
+		if name == domain {
+			continue
+		}
+
+		if item, exists := m.store.Get(name); exists {
+			if _, ok := item.(*Entry); ok {
+				continue
+			}
+		}
+
+		m.set(name, alias(domain))
+	}
+}
+
+// Dump returns the entries of the domains for which match returns true for
+// their name or the name of any of their aliases
+func (m *memstore) Dump(match func(name string) bool) []cachedump.Domain {
+	m.mux.RLock()
+	items := m.store.Items()
+	m.mux.RUnlock()
+
+	aliases := make(map[string][]string)
+	for name, item := range items {
+		if target, ok := item.Object.(alias); ok {
+			aliases[string(target)] = append(aliases[string(target)], name)
+		}
+	}
+
+	var domains []cachedump.Domain
+	for name, item := range items {
+		entry, ok := item.Object.(*Entry)
+		if !ok || !matchAny(match, name, aliases[name]) {
+			continue
+		}
+
+		d := entry.dump()
+		d.Aliases = aliases[name]
+		sort.Strings(d.Aliases)
+		if item.Expiration > 0 {
+			d.ExpiresAt = time.Unix(0, item.Expiration)
+		}
+
+		domains = append(domains, d)
+	}
+
+	sort.Slice(domains, func(i, j int) bool {
+		return domains[i].Name < domains[j].Name
+	})
+
+	return domains
+}
+
+// Export returns the retrieved configurations of the domains to hand them
+// off, see handoff.Domain
+func (m *memstore) Export() []handoff.Domain {
+	m.mux.RLock()
+	items := m.store.Items()
+	m.mux.RUnlock()
+
+	aliases := make(map[string][]string)
+	for name, item := range items {
+		if target, ok := item.Object.(alias); ok {
+			aliases[string(target)] = append(aliases[string(target)], name)
+		}
+	}
+
+	var domains []handoff.Domain
+	for name, item := range items {
+		entry, ok := item.Object.(*Entry)
+		if !ok {
+			continue
+		}
+
+		if d, ok := entry.export(); ok {
+			d.Aliases = aliases[name]
+			domains = append(domains, d)
+		}
+	}
+
+	return domains
+}
+
+// Import stores the configurations of the domains handed off, unless they
+// expired or are already cached. It returns the number of domains stored.
+func (m *memstore) Import(domains []handoff.Domain) int {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	imported := 0
+	for _, d := range domains {
+		expiration := m.entryExpirationTimeout - time.Since(d.Created)
+		if expiration <= 0 {
+			continue
+		}
+
+		if _, exists := m.store.Get(d.Name); exists {
+			continue
+		}
+
+		lookup := api.Lookup{Name: d.Name, Domain: d.Domain}
+		if d.Deleted {
+			lookup.Error = domain.ErrDomainDeleted
+		} else if d.Domain == nil {
+			lookup.Error = domain.ErrDomainDoesNotExist
+		}
+
+		entry := newCacheEntry(d.Name, m.entryRefreshTimeout, m.entryExpirationTimeout)
+		entry.created = d.Created
+		entry.setResponse(lookup)
+		m.setWithExpiration(d.Name, entry, expiration)
+
+		for _, name := range d.Aliases {
+			if _, exists := m.store.Get(name); !exists {
+				m.setWithExpiration(name, alias(d.Name), expiration)
+			}
+		}
+
+		imported++
+	}
+
+	return imported
+}
+
+func matchAny(match func(name string) bool, name string, aliases []string) bool {
+	if match(name) {
+		return true
+	}
+
+	for _, alias := range aliases {
+		if match(alias) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// set stores item under key and evicts the least recently used keys when
+// the number of entries is limited. It must be called holding m.mux.
+func (m *memstore) set(key string, item interface{}) {
+	m.setWithExpiration(key, item, cache.DefaultExpiration)
+}
+
+// setWithExpiration is set with the expiration of the item instead of the
+// default one. It must be called holding m.mux.
+func (m *memstore) setWithExpiration(key string, item interface{}, expiration time.Duration) {
+	m.store.Set(key, item, expiration)
+
+	if m.lru == nil {
+		return
+	}
+
+	if m.cleanupInterval > 0 && time.Since(m.cleaned) >= m.cleanupInterval {
+		m.store.DeleteExpired()
+		m.cleaned = time.Now()
+	}
+
+	for _, evicted := range m.lru.add(key) {
+		m.store.Delete(evicted)
+		metrics.DomainsSourceCacheEvictions.Inc()
+	}
+}
+
+// load returns the entry stored under domain or the entry its alias points to
+func (m *memstore) load(domain string) (*Entry, bool) {
+	item, exists := m.store.Get(domain)
+	if exists && m.lru != nil {
+		m.lru.promote(domain)
+	}
+
+	if name, ok := item.(alias); ok {
+		item, exists = m.store.Get(string(name))
+		if exists && m.lru != nil {
+			m.lru.promote(string(name))
+		}
+	}
+
+	if !exists {
+		return nil, false
+	}
+
+	entry, ok := item.(*Entry)
+
+	return entry, ok
+}
@@ -1,7 +1,18 @@
 package cache
 
+import (
+	"gitlab.com/gitlab-org/gitlab-pages/internal/cachedump"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/handoff"
+)
+
 // Store defines an interface describing an abstract cache store
 type Store interface {
 	LoadOrCreate(domain string) *Entry
+	Load(domain string) (*Entry, bool)
 	ReplaceOrCreate(domain string, entry *Entry) *Entry
+	Delete(domain string)
+	SetAliases(domain string, aliases []string)
+	Dump(match func(name string) bool) []cachedump.Domain
+	Export() []handoff.Domain
+	Import(domains []handoff.Domain) int
 }
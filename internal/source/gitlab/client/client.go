@@ -1,11 +1,13 @@
 package client
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"path"
@@ -18,6 +20,8 @@ import (
 	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httptransport"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/pageserrors"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/security"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
 	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
@@ -33,7 +37,7 @@ const transportClientName = "gitlab_internal_api"
 // returns a http.StatusUnauthorized. This happens if the common secret file
 // is not synced between gitlab-pages and gitlab-rails servers.
 // See https://gitlab.com/gitlab-org/gitlab-pages/-/issues/535 for more details.
-var ErrUnauthorizedAPI = errors.New("pages endpoint unauthorized")
+var ErrUnauthorizedAPI = pageserrors.New(pageserrors.SourceUnavailable, "pages endpoint unauthorized")
 
 // Client is a HTTP client to access Pages internal API
 type Client struct {
@@ -41,6 +45,10 @@ type Client struct {
 	baseURL        *url.URL
 	httpClient     *http.Client
 	jwtTokenExpiry time.Duration
+	throttle       *throttle
+	// discovery picks the server of each request when the API is served by
+	// the targets of a DNS SRV record, it is nil otherwise
+	discovery *srvDiscovery
 }
 
 // NewClient initializes and returns new Client baseUrl is
@@ -67,26 +75,74 @@ func NewClient(baseURL string, secretKey []byte, connectionTimeout, jwtTokenExpi
 		secretKey: secretKey,
 		baseURL:   parsedURL,
 		httpClient: &http.Client{
-			Timeout: connectionTimeout,
-			Transport: httptransport.NewMeteredRoundTripper(
-				correlation.NewInstrumentedRoundTripper(
-					httptransport.DefaultTransport,
-					correlation.WithClientName(transportClientName),
-				),
-				transportClientName,
-				metrics.DomainsSourceAPITraceDuration,
-				metrics.DomainsSourceAPICallDuration,
-				metrics.DomainsSourceAPIReqTotal,
-				httptransport.DefaultTTFBTimeout,
-			),
+			Timeout:   connectionTimeout,
+			Transport: newRoundTripper(httptransport.DefaultTransport),
 		},
 		jwtTokenExpiry: jwtTokenExpiry,
+		throttle:       newThrottle(),
 	}, nil
 }
 
-// NewFromConfig creates a new client from Config struct
-func NewFromConfig(cfg *config.GitLab) (*Client, error) {
-	return NewClient(cfg.InternalServer, cfg.APISecretKey, cfg.ClientHTTPTimeout, cfg.JWTTokenExpiration)
+// NewFromConfig creates a new client from Config struct. When the API is
+// served by the targets of a DNS SRV record, the record is resolved before
+// returning and then in the background until ctx is done.
+func NewFromConfig(ctx context.Context, cfg *config.GitLab) (*Client, error) {
+	client, err := NewClient(cfg.InternalServer, cfg.APISecretKey, cfg.ClientHTTPTimeout, cfg.JWTTokenExpiration)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy, err := httptransport.NewProxyFunc(cfg.ProxyURL)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := httptransport.NewTransport()
+	if cfg.InternalServerSRV != "" {
+		transport = newDiscoveryTransport(client.baseURL.Hostname())
+	}
+
+	transport.Proxy = httptransport.InstrumentProxy(proxy, metrics.DomainsSourceAPIProxyRequests)
+	client.httpClient.Transport = newRoundTripper(transport)
+
+	if cfg.InternalServerSRV != "" {
+		client.discovery = newSRVDiscovery(cfg.InternalServerSRV, cfg.InternalServerSRVInterval)
+		client.discovery.resolve(ctx)
+
+		go client.discovery.run(ctx)
+	}
+
+	return client, nil
+}
+
+// newDiscoveryTransport returns a transport for the requests sent to the
+// targets of a DNS SRV record. Their URL is the address of the target, so the
+// connections are pooled by target, while the certificates of the targets are
+// verified for serverName, the host of the internal server.
+func newDiscoveryTransport(serverName string) *http.Transport {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+
+	// the tls.Config without CA certificates cannot fail
+	tlsConfig, _ := httptransport.NewTLSConfig(nil, false)
+	tlsConfig.ServerName = serverName
+
+	return httptransport.NewTransportWithDialContext(dialer.DialContext, tlsConfig)
+}
+
+// newRoundTripper instruments the requests to the GitLab API sent with
+// transport
+func newRoundTripper(transport http.RoundTripper) http.RoundTripper {
+	return httptransport.NewMeteredRoundTripper(
+		correlation.NewInstrumentedRoundTripper(
+			transport,
+			correlation.WithClientName(transportClientName),
+		),
+		transportClientName,
+		metrics.DomainsSourceAPITraceDuration,
+		metrics.DomainsSourceAPICallDuration,
+		metrics.DomainsSourceAPIReqTotal,
+		httptransport.DefaultTTFBTimeout,
+	)
 }
 
 // Resolve returns a VirtualDomain configuration wrapped into a Lookup for a
@@ -127,25 +183,38 @@ func (gc *Client) GetLookup(ctx context.Context, host string) api.Lookup {
 	return lookup
 }
 
+// Status checks the connectivity with the GitLab API, it returns an error
+// when the API cannot be reached or rejects the credentials of Pages
+func (gc *Client) Status(ctx context.Context) error {
+	resp, err := gc.get(ctx, "/api/v4/internal/pages/status", url.Values{})
+	if err != nil {
+		return fmt.Errorf("%s: %w", ConnectionErrorMsg, err)
+	}
+
+	if resp != nil {
+		// nolint: errcheck
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	return nil
+}
+
 func (gc *Client) get(ctx context.Context, path string, params url.Values) (*http.Response, error) {
 	endpoint, err := gc.endpoint(path, params)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := gc.request(ctx, "GET", endpoint)
+	req, err := gc.request(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
-	resp, err := gc.httpClient.Do(req)
+	resp, err := gc.do(req)
 	if err != nil {
 		return nil, err
 	}
 
-	if resp == nil {
-		return nil, errors.New("unknown response")
-	}
-
 	// StatusOK means we should return the API response
 	if resp.StatusCode == http.StatusOK {
 		return resp, nil
@@ -159,11 +228,89 @@ func (gc *Client) get(ctx context.Context, path string, params url.Values) (*htt
 	// StatusNoContent means that a domain does not exist, it is not an error
 	if resp.StatusCode == http.StatusNoContent {
 		return nil, nil
+	} else if resp.StatusCode == http.StatusGone {
+		// StatusGone means that the domain existed and was deleted
+		return nil, domain.ErrDomainDeleted
 	} else if resp.StatusCode == http.StatusUnauthorized {
 		return nil, ErrUnauthorizedAPI
 	}
 
-	return nil, fmt.Errorf("HTTP status: %d", resp.StatusCode)
+	return nil, pageserrors.Wrap(pageserrors.SourceUnavailable, fmt.Errorf("HTTP status: %d", resp.StatusCode))
+}
+
+// ReportAccessSummaries posts the access summaries of the domains served
+// by Pages to GitLab
+func (gc *Client) ReportAccessSummaries(ctx context.Context, summaries []api.AccessSummary) error {
+	body, err := json.Marshal(struct {
+		Summaries []api.AccessSummary `json:"summaries"`
+	}{summaries})
+	if err != nil {
+		return err
+	}
+
+	endpoint, err := gc.endpoint("/api/v4/internal/pages/analytics", nil)
+	if err != nil {
+		return err
+	}
+
+	req, err := gc.request(ctx, "POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := gc.do(req)
+	if err != nil {
+		return err
+	}
+
+	// nolint: errcheck
+	// best effort to discard and close the response body
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorizedAPI
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return pageserrors.Wrap(pageserrors.SourceUnavailable, fmt.Errorf("HTTP status: %d", resp.StatusCode))
+	}
+
+	return nil
+}
+
+// do sends req and records the rate limit of the GitLab API from the response.
+// With a DNS SRV record, req is sent to one of its targets and keeps the Host
+// of the internal server, which the certificate of the target is verified for.
+func (gc *Client) do(req *http.Request) (*http.Response, error) {
+	var server string
+
+	if gc.discovery != nil {
+		var err error
+		if server, err = gc.discovery.pick(); err != nil {
+			return nil, err
+		}
+
+		req.Host = req.URL.Host
+		req.URL.Host = server
+	}
+
+	resp, err := gc.httpClient.Do(req)
+	if gc.discovery != nil {
+		gc.discovery.report(server, err == nil && resp.StatusCode < http.StatusInternalServerError)
+	}
+
+	if err != nil {
+		return nil, pageserrors.Wrap(pageserrors.SourceUnavailable, err)
+	}
+
+	if resp == nil {
+		return nil, pageserrors.New(pageserrors.SourceUnavailable, "unknown response")
+	}
+
+	gc.throttle.update(resp)
+
+	return resp, nil
 }
 
 func (gc *Client) endpoint(urlPath string, params url.Values) (*url.URL, error) {
@@ -185,8 +332,14 @@ func (gc *Client) endpoint(urlPath string, params url.Values) (*url.URL, error)
 	return endpoint, nil
 }
 
-func (gc *Client) request(ctx context.Context, method string, endpoint *url.URL) (*http.Request, error) {
-	req, err := http.NewRequestWithContext(ctx, method, endpoint.String(), nil)
+// request returns a request to the GitLab API, once the API is not rate
+// limiting Pages so its token is fresh
+func (gc *Client) request(ctx context.Context, method string, endpoint *url.URL, body io.Reader) (*http.Request, error) {
+	if err := gc.throttle.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint.String(), body)
 	if err != nil {
 		return nil, err
 	}
@@ -195,14 +348,14 @@ func (gc *Client) request(ctx context.Context, method string, endpoint *url.URL)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Gitlab-Pages-Api-Request", token)
+	req.Header.Set(security.APIRequestHeader, token)
 
 	return req, nil
 }
 
 func (gc *Client) token() (string, error) {
 	claims := jwt.RegisteredClaims{
-		Issuer:    "gitlab-pages",
+		Issuer:    security.PagesTokenIssuer,
 		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(gc.jwtTokenExpiry)),
 	}
 
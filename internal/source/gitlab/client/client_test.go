@@ -3,6 +3,7 @@ package client
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -13,10 +14,15 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/fixture"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/security"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
 const (
@@ -183,6 +189,70 @@ func TestMissingDomain(t *testing.T) {
 	require.Nil(t, lookup.Domain)
 }
 
+func TestGetLookupDeploymentCreatedAt(t *testing.T) {
+	tests := map[string]struct {
+		createdAt   string
+		expected    time.Time
+		expectedErr bool
+	}{
+		"time": {
+			createdAt: `"2021-06-01T10:00:00Z"`,
+			expected:  time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC),
+		},
+		"empty": {
+			createdAt: `""`,
+		},
+		"null": {
+			createdAt: `null`,
+		},
+		"invalid": {
+			createdAt:   `"yesterday"`,
+			expectedErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/api/v4/internal/pages", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"lookup_paths":[{"project_id":1,"prefix":"/","source":{"type":"zip","path":"https://example.com/public.zip","created_at":%s}}]}`, test.createdAt)
+			})
+
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			lookup := defaultClient(t, server.URL).GetLookup(context.Background(), "group.gitlab.io")
+			if test.expectedErr {
+				require.Error(t, lookup.Error)
+				return
+			}
+
+			require.NoError(t, lookup.Error)
+			require.True(t, test.expected.Equal(lookup.Domain.LookupPaths[0].Source.CreatedAt))
+			require.Equal(t, "https://example.com/public.zip", lookup.Domain.LookupPaths[0].Source.Path)
+		})
+	}
+}
+
+func TestDeletedDomain(t *testing.T) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/v4/internal/pages", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := defaultClient(t, server.URL)
+
+	lookup := client.GetLookup(context.Background(), "group.gitlab.io")
+
+	require.True(t, errors.Is(lookup.Error, domain.ErrDomainDeleted))
+	require.True(t, errors.Is(lookup.Error, domain.ErrDomainDoesNotExist), "deleted domains do not exist")
+	require.Nil(t, lookup.Domain)
+}
+
 func TestGetVirtualDomainAuthenticatedRequest(t *testing.T) {
 	mux := http.NewServeMux()
 
@@ -190,7 +260,7 @@ func TestGetVirtualDomainAuthenticatedRequest(t *testing.T) {
 		require.Equal(t, "GET", r.Method)
 		require.Equal(t, "group.gitlab.io", r.FormValue("host"))
 
-		validateToken(t, r.Header.Get("Gitlab-Pages-Api-Request"))
+		validateToken(t, r.Header.Get(security.APIRequestHeader))
 
 		response := `{
 			"certificate": "foo",
@@ -340,3 +410,125 @@ func Test_endpoint(t *testing.T) {
 		})
 	}
 }
+
+func TestReportAccessSummaries(t *testing.T) {
+	tests := map[string]struct {
+		status      int
+		expectedErr error
+	}{
+		"accepted": {
+			status: http.StatusNoContent,
+		},
+		"unauthorized": {
+			status:      http.StatusUnauthorized,
+			expectedErr: ErrUnauthorizedAPI,
+		},
+		"server_error": {
+			status:      http.StatusInternalServerError,
+			expectedErr: errors.New("HTTP status: 500"),
+		},
+	}
+
+	summaries := []api.AccessSummary{{
+		Domain:      "group.gitlab.io",
+		Requests:    2,
+		Bytes:       10,
+		StatusCodes: map[int]uint64{http.StatusOK: 2},
+		TopPaths:    []api.PathCount{{Path: "/", Requests: 2}},
+	}}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/api/v4/internal/pages/analytics", func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, http.MethodPost, r.Method)
+				require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+				validateToken(t, r.Header.Get(security.APIRequestHeader))
+
+				var body struct {
+					Summaries []api.AccessSummary `json:"summaries"`
+				}
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+				require.Equal(t, summaries, body.Summaries)
+
+				w.WriteHeader(tt.status)
+			})
+
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			err := defaultClient(t, server.URL).ReportAccessSummaries(context.Background(), summaries)
+			if tt.expectedErr == nil {
+				require.NoError(t, err)
+				return
+			}
+
+			require.EqualError(t, err, tt.expectedErr.Error())
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	tests := map[string]struct {
+		status      int
+		expectedErr error
+	}{
+		"healthy": {
+			status: http.StatusNoContent,
+		},
+		"unauthorized": {
+			status:      http.StatusUnauthorized,
+			expectedErr: ErrUnauthorizedAPI,
+		},
+		"unavailable": {
+			status:      http.StatusServiceUnavailable,
+			expectedErr: errors.New("HTTP status: 503"),
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/api/v4/internal/pages/status", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			})
+
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			err := defaultClient(t, server.URL).Status(context.Background())
+			if tt.expectedErr == nil {
+				require.NoError(t, err)
+				return
+			}
+
+			require.Error(t, err)
+			require.Contains(t, err.Error(), ConnectionErrorMsg)
+			require.Contains(t, err.Error(), tt.expectedErr.Error())
+		})
+	}
+}
+
+func TestNewFromConfigProxy(t *testing.T) {
+	var proxiedHost string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxiedHost = r.URL.Host
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer proxy.Close()
+
+	client, err := NewFromConfig(context.Background(), &config.GitLab{
+		InternalServer:     "http://gitlab.example.com",
+		APISecretKey:       secretKey(t),
+		ClientHTTPTimeout:  defaultClientConnTimeout,
+		JWTTokenExpiration: defaultJWTTokenExpiry,
+		ProxyURL:           proxy.URL,
+	})
+	require.NoError(t, err)
+
+	proxied := testutil.ToFloat64(metrics.DomainsSourceAPIProxyRequests.WithLabelValues("proxied"))
+
+	require.NoError(t, client.Status(context.Background()))
+	require.Equal(t, "gitlab.example.com", proxiedHost)
+	require.Equal(t, proxied+1, testutil.ToFloat64(metrics.DomainsSourceAPIProxyRequests.WithLabelValues("proxied")))
+}
@@ -0,0 +1,190 @@
+package client
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/pageserrors"
+)
+
+// ErrNoServers is returned when the DNS SRV record of the GitLab API has not
+// been resolved to any server
+var ErrNoServers = pageserrors.New(pageserrors.SourceUnavailable, "no GitLab API server discovered")
+
+// srvDiscovery resolves the servers of the GitLab API from a DNS SRV record
+// every interval. The server of each request is picked by the priority and the
+// weight of the records, among the servers which did not fail for interval.
+type srvDiscovery struct {
+	name      string
+	interval  time.Duration
+	lookupSRV func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	now       func() time.Time
+	intn      func(n int) int
+
+	mu        sync.Mutex
+	records   []*net.SRV
+	unhealthy map[string]time.Time
+}
+
+func newSRVDiscovery(name string, interval time.Duration) *srvDiscovery {
+	return &srvDiscovery{
+		name:      name,
+		interval:  interval,
+		lookupSRV: net.DefaultResolver.LookupSRV,
+		now:       time.Now,
+		intn:      rand.Intn,
+		unhealthy: make(map[string]time.Time),
+	}
+}
+
+// run resolves the record every interval until ctx is done
+func (d *srvDiscovery) run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.resolve(ctx)
+		}
+	}
+}
+
+// resolve updates the servers with the targets of the record. The servers
+// resolved previously are kept when the record cannot be resolved, so a DNS
+// outage does not stop Pages from reaching the API.
+func (d *srvDiscovery) resolve(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, d.interval)
+	defer cancel()
+
+	_, records, err := d.lookupSRV(ctx, "", "", d.name)
+	if err == nil && len(records) == 0 {
+		err = ErrNoServers
+	}
+
+	logger := log.WithField("internal_gitlab_server_srv", d.name)
+	if err != nil {
+		logger.WithError(err).Error("failed to resolve the GitLab API servers")
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.records = records
+
+	// forget the failures of servers which are not targets anymore
+	for server := range d.unhealthy {
+		if !hasTarget(records, server) {
+			delete(d.unhealthy, server)
+		}
+	}
+
+	logger.WithField("servers_count", len(records)).Debug("resolved the GitLab API servers")
+}
+
+// pick returns the address of the server to send a request to. Servers which
+// failed recently are only picked when all of them did.
+func (d *srvDiscovery) pick() (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.records) == 0 {
+		return "", ErrNoServers
+	}
+
+	now := d.now()
+
+	var healthy []*net.SRV
+	for _, record := range d.records {
+		if until, ok := d.unhealthy[address(record)]; !ok || now.After(until) {
+			healthy = append(healthy, record)
+		}
+	}
+
+	if len(healthy) == 0 {
+		healthy = d.records
+	}
+
+	return address(d.weighted(lowestPriority(healthy))), nil
+}
+
+// weighted picks one of records randomly in proportion to their weight, as
+// described in RFC 2782. Records with a weight of 0 are only picked when all
+// of them have a weight of 0.
+func (d *srvDiscovery) weighted(records []*net.SRV) *net.SRV {
+	total := 0
+	for _, record := range records {
+		total += int(record.Weight)
+	}
+
+	if total == 0 {
+		return records[d.intn(len(records))]
+	}
+
+	n := d.intn(total)
+	for _, record := range records {
+		if n < int(record.Weight) {
+			return record
+		}
+
+		n -= int(record.Weight)
+	}
+
+	return records[len(records)-1]
+}
+
+// report records whether the request sent to server succeeded, servers whose
+// requests failed are not picked for interval
+func (d *srvDiscovery) report(server string, healthy bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if healthy {
+		delete(d.unhealthy, server)
+		return
+	}
+
+	d.unhealthy[server] = d.now().Add(d.interval)
+}
+
+// lowestPriority returns the records with the lowest priority value, which
+// are preferred
+func lowestPriority(records []*net.SRV) []*net.SRV {
+	var result []*net.SRV
+
+	for _, record := range records {
+		switch {
+		case len(result) == 0 || record.Priority < result[0].Priority:
+			result = []*net.SRV{record}
+		case record.Priority == result[0].Priority:
+			result = append(result, record)
+		}
+	}
+
+	return result
+}
+
+func hasTarget(records []*net.SRV, server string) bool {
+	for _, record := range records {
+		if address(record) == server {
+			return true
+		}
+	}
+
+	return false
+}
+
+// address returns the host:port of the target of record
+func address(record *net.SRV) string {
+	return net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port)))
+}
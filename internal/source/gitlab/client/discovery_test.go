@@ -0,0 +1,229 @@
+package client
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDiscovery(records []*net.SRV, err error) *srvDiscovery {
+	d := newSRVDiscovery("_gitlab._tcp.gitlab.service.consul", time.Minute)
+	d.lookupSRV = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		return "", records, err
+	}
+	d.intn = func(n int) int { return n - 1 }
+	d.resolve(context.Background())
+
+	return d
+}
+
+func TestSRVDiscoveryPick(t *testing.T) {
+	tests := map[string]struct {
+		records   []*net.SRV
+		unhealthy []string
+		expected  string
+	}{
+		"single_target": {
+			records:  []*net.SRV{{Target: "api-1.gitlab.", Port: 8080}},
+			expected: "api-1.gitlab:8080",
+		},
+		"lowest_priority": {
+			records: []*net.SRV{
+				{Target: "api-1.gitlab.", Port: 8080, Priority: 20, Weight: 10},
+				{Target: "api-2.gitlab.", Port: 8080, Priority: 10, Weight: 10},
+			},
+			expected: "api-2.gitlab:8080",
+		},
+		"weighted": {
+			records: []*net.SRV{
+				{Target: "api-1.gitlab.", Port: 8080, Weight: 0},
+				{Target: "api-2.gitlab.", Port: 8080, Weight: 10},
+				{Target: "api-3.gitlab.", Port: 8080, Weight: 5},
+			},
+			expected: "api-3.gitlab:8080",
+		},
+		"skips_unhealthy": {
+			records: []*net.SRV{
+				{Target: "api-1.gitlab.", Port: 8080, Priority: 10},
+				{Target: "api-2.gitlab.", Port: 8080, Priority: 20},
+			},
+			unhealthy: []string{"api-1.gitlab:8080"},
+			expected:  "api-2.gitlab:8080",
+		},
+		"all_unhealthy": {
+			records: []*net.SRV{
+				{Target: "api-1.gitlab.", Port: 8080, Priority: 10},
+				{Target: "api-2.gitlab.", Port: 8080, Priority: 20},
+			},
+			unhealthy: []string{"api-1.gitlab:8080", "api-2.gitlab:8080"},
+			expected:  "api-1.gitlab:8080",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			d := newTestDiscovery(tt.records, nil)
+			for _, server := range tt.unhealthy {
+				d.report(server, false)
+			}
+
+			server, err := d.pick()
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, server)
+		})
+	}
+}
+
+func TestSRVDiscoveryRecovers(t *testing.T) {
+	d := newTestDiscovery([]*net.SRV{
+		{Target: "api-1.gitlab.", Port: 8080, Priority: 10},
+		{Target: "api-2.gitlab.", Port: 8080, Priority: 20},
+	}, nil)
+
+	now := time.Now()
+	d.now = func() time.Time { return now }
+
+	d.report("api-1.gitlab:8080", false)
+	server, err := d.pick()
+	require.NoError(t, err)
+	require.Equal(t, "api-2.gitlab:8080", server)
+
+	now = now.Add(2 * time.Minute)
+	server, err = d.pick()
+	require.NoError(t, err)
+	require.Equal(t, "api-1.gitlab:8080", server, "failing servers are picked again after the interval")
+}
+
+func TestSRVDiscoveryResolveFails(t *testing.T) {
+	d := newTestDiscovery(nil, errors.New("no such host"))
+
+	_, err := d.pick()
+	require.Equal(t, ErrNoServers, err)
+
+	d.lookupSRV = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		return "", []*net.SRV{{Target: "api-1.gitlab.", Port: 8080}}, nil
+	}
+	d.resolve(context.Background())
+
+	d.lookupSRV = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		return "", nil, errors.New("i/o timeout")
+	}
+	d.resolve(context.Background())
+
+	server, err := d.pick()
+	require.NoError(t, err)
+	require.Equal(t, "api-1.gitlab:8080", server, "the servers are kept while the record cannot be resolved")
+}
+
+func TestGetLookupUsesDiscoveredServer(t *testing.T) {
+	var host string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v4/internal/pages", func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	port, err := strconv.Atoi(serverURL.Port())
+	require.NoError(t, err)
+
+	client := defaultClient(t, "http://gitlab.example.internal")
+	client.discovery = newTestDiscovery([]*net.SRV{
+		{Target: "127.0.0.1.", Port: uint16(port), Priority: 10},
+		{Target: "127.0.0.1.", Port: 1, Priority: 20},
+	}, nil)
+
+	lookup := client.GetLookup(context.Background(), "group.gitlab.io")
+	require.Equal(t, "gitlab.example.internal", host, "requests keep the host of the internal server")
+	require.Error(t, lookup.Error, "the domain does not exist")
+
+	client.discovery.report(serverURL.Host, false)
+	lookup = client.GetLookup(context.Background(), "group.gitlab.io")
+	require.Error(t, lookup.Error, "the request is sent to the other server")
+
+	require.Contains(t, client.discovery.unhealthy, "127.0.0.1:1")
+}
+
+func TestGetLookupVerifiesDiscoveredServerForInternalServer(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	port, err := strconv.Atoi(serverURL.Port())
+	require.NoError(t, err)
+
+	// the certificate of the test server is valid for example.com
+	tests := map[string]struct {
+		internalServer string
+		expectedErr    bool
+	}{
+		"certificate_of_internal_server": {
+			internalServer: "https://example.com",
+		},
+		"certificate_of_another_server": {
+			internalServer: "https://gitlab.example.internal",
+			expectedErr:    true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			client := defaultClient(t, tt.internalServer)
+
+			transport := newDiscoveryTransport(client.baseURL.Hostname())
+			transport.TLSClientConfig.RootCAs = x509.NewCertPool()
+			transport.TLSClientConfig.RootCAs.AddCert(server.Certificate())
+			client.httpClient.Transport = transport
+
+			client.discovery = newTestDiscovery([]*net.SRV{{Target: "127.0.0.1.", Port: uint16(port)}}, nil)
+
+			err := client.Status(context.Background())
+			if tt.expectedErr {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "certificate")
+				return
+			}
+
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestSRVDiscoveryRunStops(t *testing.T) {
+	d := newTestDiscovery([]*net.SRV{{Target: "api-1.gitlab.", Port: 8080}}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		d.run(ctx)
+		close(done)
+	}()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the discovery did not stop once its context was canceled")
+	}
+}
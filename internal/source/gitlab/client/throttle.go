@@ -0,0 +1,171 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/pageserrors"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+// events reported by metrics.DomainsSourceAPIThrottled
+const (
+	throttleEventThrottled = "throttled"
+	throttleEventDelayed   = "delayed"
+	throttleEventRejected  = "rejected"
+)
+
+// defaultThrottleDelay is the time requests are delayed after a response
+// with a http.StatusTooManyRequests which does not say when to retry
+const defaultThrottleDelay = time.Second
+
+// maxThrottleDelay caps the time requests are delayed, so a wrong header
+// cannot block the requests to the API for hours
+const maxThrottleDelay = time.Minute
+
+// unixTimeThreshold separates the RateLimit-Reset values which are a Unix
+// time, like GitLab sends, from the ones which are a number of seconds
+const unixTimeThreshold = 1000000000
+
+// ErrThrottled is returned when a request is not sent because the GitLab API
+// rate limits Pages and the request cannot wait until the limit is reset
+var ErrThrottled = pageserrors.New(pageserrors.SourceUnavailable, "GitLab API rate limit exceeded")
+
+// throttle delays the requests to the GitLab API once it reported Pages is
+// rate limited, with the Retry-After or RateLimit-* headers of its responses
+type throttle struct {
+	mu    sync.Mutex
+	until time.Time
+	now   func() time.Time
+}
+
+func newThrottle() *throttle {
+	return &throttle{now: time.Now}
+}
+
+// wait blocks until the API accepts requests again. It returns ErrThrottled
+// right away when ctx is done before then, instead of sending a request bound
+// to be rejected.
+func (t *throttle) wait(ctx context.Context) error {
+	t.mu.Lock()
+	delay := t.until.Sub(t.now())
+	t.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && deadline.Sub(t.now()) < delay {
+		metrics.DomainsSourceAPIThrottled.WithLabelValues(throttleEventRejected).Inc()
+		return ErrThrottled
+	}
+
+	metrics.DomainsSourceAPIThrottled.WithLabelValues(throttleEventDelayed).Inc()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ErrThrottled
+	}
+}
+
+// update records the time the API accepts requests again from resp, when it
+// is rate limiting Pages
+func (t *throttle) update(resp *http.Response) {
+	now := t.now()
+
+	delay, ok := throttleDelay(resp, now)
+	if !ok {
+		return
+	}
+
+	if delay > maxThrottleDelay {
+		delay = maxThrottleDelay
+	}
+
+	metrics.DomainsSourceAPIThrottled.WithLabelValues(throttleEventThrottled).Inc()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if until := now.Add(delay); until.After(t.until) {
+		t.until = until
+	}
+}
+
+// throttleDelay returns the time to wait before sending the next request
+// after resp, and false when resp does not ask to wait. Responses which are
+// rate limited ask to wait for their Retry-After or RateLimit-Reset, and the
+// other ones ask to wait for their RateLimit-Reset when no request is left.
+func throttleDelay(resp *http.Response, now time.Time) (time.Duration, bool) {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now); ok {
+			return delay, true
+		}
+
+		if delay, ok := parseRateLimitReset(resp.Header.Get("RateLimit-Reset"), now); ok {
+			return delay, true
+		}
+
+		return defaultThrottleDelay, true
+	case http.StatusServiceUnavailable:
+		return parseRetryAfter(resp.Header.Get("Retry-After"), now)
+	}
+
+	if resp.Header.Get("RateLimit-Remaining") == "0" {
+		return parseRateLimitReset(resp.Header.Get("RateLimit-Reset"), now)
+	}
+
+	return 0, false
+}
+
+// parseRetryAfter parses a Retry-After header, either a number of seconds or
+// an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return positive(time.Duration(seconds) * time.Second)
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+
+	return positive(date.Sub(now))
+}
+
+// parseRateLimitReset parses a RateLimit-Reset header, either a Unix time or
+// a number of seconds
+func parseRateLimitReset(value string, now time.Time) (time.Duration, bool) {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	if seconds >= unixTimeThreshold {
+		return positive(time.Unix(seconds, 0).Sub(now))
+	}
+
+	return positive(time.Duration(seconds) * time.Second)
+}
+
+// positive returns d and true when d is positive, 0 and false otherwise
+func positive(d time.Duration) (time.Duration, bool) {
+	if d <= 0 {
+		return 0, false
+	}
+
+	return d, true
+}
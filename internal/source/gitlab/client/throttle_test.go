@@ -0,0 +1,133 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+func TestThrottleDelay(t *testing.T) {
+	now := time.Date(2021, time.March, 4, 10, 30, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		status        int
+		header        http.Header
+		expectedDelay time.Duration
+		expectedOK    bool
+	}{
+		"ok": {
+			status: http.StatusOK,
+			header: http.Header{"Ratelimit-Remaining": {"10"}, "Ratelimit-Reset": {"30"}},
+		},
+		"no_request_left": {
+			status:        http.StatusOK,
+			header:        http.Header{"Ratelimit-Remaining": {"0"}, "Ratelimit-Reset": {strconv.FormatInt(now.Add(20*time.Second).Unix(), 10)}},
+			expectedDelay: 20 * time.Second,
+			expectedOK:    true,
+		},
+		"reset_in_the_past": {
+			status: http.StatusOK,
+			header: http.Header{"Ratelimit-Remaining": {"0"}, "Ratelimit-Reset": {strconv.FormatInt(now.Add(-time.Second).Unix(), 10)}},
+		},
+		"retry_after_seconds": {
+			status:        http.StatusTooManyRequests,
+			header:        http.Header{"Retry-After": {"5"}, "Ratelimit-Reset": {"60"}},
+			expectedDelay: 5 * time.Second,
+			expectedOK:    true,
+		},
+		"retry_after_date": {
+			status:        http.StatusTooManyRequests,
+			header:        http.Header{"Retry-After": {now.Add(time.Minute).Format(http.TimeFormat)}},
+			expectedDelay: time.Minute,
+			expectedOK:    true,
+		},
+		"too_many_requests_with_reset": {
+			status:        http.StatusTooManyRequests,
+			header:        http.Header{"Ratelimit-Reset": {"15"}},
+			expectedDelay: 15 * time.Second,
+			expectedOK:    true,
+		},
+		"too_many_requests_without_headers": {
+			status:        http.StatusTooManyRequests,
+			header:        http.Header{},
+			expectedDelay: defaultThrottleDelay,
+			expectedOK:    true,
+		},
+		"unavailable_with_retry_after": {
+			status:        http.StatusServiceUnavailable,
+			header:        http.Header{"Retry-After": {"3"}},
+			expectedDelay: 3 * time.Second,
+			expectedOK:    true,
+		},
+		"unavailable": {
+			status: http.StatusServiceUnavailable,
+			header: http.Header{},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			delay, ok := throttleDelay(&http.Response{StatusCode: tt.status, Header: tt.header}, now)
+			require.Equal(t, tt.expectedOK, ok)
+			require.Equal(t, tt.expectedDelay, delay)
+		})
+	}
+}
+
+func TestThrottleWait(t *testing.T) {
+	th := newThrottle()
+	require.NoError(t, th.wait(context.Background()))
+
+	th.update(&http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"3600"}}})
+	require.WithinDuration(t, time.Now().Add(maxThrottleDelay), th.until, time.Second, "the delay is capped")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	rejected := testutil.ToFloat64(metrics.DomainsSourceAPIThrottled.WithLabelValues(throttleEventRejected))
+	require.Equal(t, ErrThrottled, th.wait(ctx))
+	require.Equal(t, rejected+1, testutil.ToFloat64(metrics.DomainsSourceAPIThrottled.WithLabelValues(throttleEventRejected)))
+
+	th.until = time.Now().Add(50 * time.Millisecond)
+
+	delayed := testutil.ToFloat64(metrics.DomainsSourceAPIThrottled.WithLabelValues(throttleEventDelayed))
+	start := time.Now()
+	require.NoError(t, th.wait(ctx))
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(40*time.Millisecond))
+	require.Equal(t, delayed+1, testutil.ToFloat64(metrics.DomainsSourceAPIThrottled.WithLabelValues(throttleEventDelayed)))
+}
+
+func TestGetLookupBacksOffWhenThrottled(t *testing.T) {
+	var requests int32
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v4/internal/pages", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := defaultClient(t, server.URL)
+
+	lookup := client.GetLookup(context.Background(), "group.gitlab.io")
+	require.Error(t, lookup.Error)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	lookup = client.GetLookup(ctx, "group.gitlab.io")
+	require.Equal(t, ErrThrottled, lookup.Error)
+	require.Equal(t, int32(1), atomic.LoadInt32(&requests), "the API is not called while it is rate limiting Pages")
+}
@@ -3,11 +3,16 @@ package gitlab
 import (
 	"errors"
 	"fmt"
+	"net/url"
 	"strings"
 
 	"github.com/sirupsen/logrus"
 	"gitlab.com/gitlab-org/labkit/log"
 
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config/tls"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/forwarded"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/serving/disk/local"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/serving/disk/zip"
@@ -23,17 +28,152 @@ var (
 // https://gitlab.com/gitlab-org/gitlab-pages/issues/272
 func fabricateLookupPath(size int, lookup api.LookupPath) *serving.LookupPath {
 	return &serving.LookupPath{
-		ServingType:        lookup.Source.Type,
-		Path:               lookup.Source.Path,
-		SHA256:             lookup.Source.SHA256,
-		Prefix:             lookup.Prefix,
-		IsNamespaceProject: (lookup.Prefix == "/" && size > 1),
-		IsHTTPSOnly:        lookup.HTTPSOnly,
-		HasAccessControl:   lookup.AccessControl,
-		ProjectID:          uint64(lookup.ProjectID),
+		ServingType:         lookup.Source.Type,
+		Path:                lookup.Source.Path,
+		SHA256:              lookup.Source.SHA256,
+		DeployedAt:          lookup.Source.CreatedAt,
+		Prefix:              lookup.Prefix,
+		IsNamespaceProject:  (lookup.Prefix == "/" && size > 1),
+		IsHTTPSOnly:         lookup.HTTPSOnly,
+		HasAccessControl:    lookup.AccessControl,
+		ProjectID:           uint64(lookup.ProjectID),
+		LanguageNegotiation: lookup.LanguageNegotiation,
+		FallbackPrefix:      strings.Trim(lookup.FallbackPrefix, "/"),
+		CaseInsensitive:     lookup.CaseInsensitive,
+		Namespace:           strings.Trim(lookup.Namespace, "/"),
+		SPAFallback:         lookup.SPAFallback,
+		DirectoryListing:    lookup.DirectoryListing,
+		IndexFiles:          fabricateIndexFiles(lookup.IndexFiles),
+		CanonicalDomain:     strings.ToLower(lookup.CanonicalDomain),
+		IPAccess:            fabricateIPAccess(lookup.ProjectID, lookup.IPAccess),
 	}
 }
 
+// fabricateIPAccess fabricates a serving IPAccess based on the API IPAccess.
+// Invalid allowed entries are ignored and invalid denied entries deny every
+// client, so a typo never grants access to more clients.
+func fabricateIPAccess(projectID int, access *api.IPAccess) *serving.IPAccess {
+	if access == nil || (len(access.Allow) == 0 && len(access.Deny) == 0) {
+		return nil
+	}
+
+	result := &serving.IPAccess{}
+
+	if len(access.Allow) > 0 {
+		result.Allow = forwarded.Proxies{}
+
+		for _, entry := range access.Allow {
+			allowed, err := forwarded.NewProxies([]string{entry})
+			if err != nil {
+				log.WithError(err).WithField("project_id", projectID).Warn("ignoring invalid allowed IP range of project")
+				continue
+			}
+
+			result.Allow = append(result.Allow, allowed...)
+		}
+	}
+
+	denied, err := forwarded.NewProxies(access.Deny)
+	if err != nil {
+		log.WithError(err).WithField("project_id", projectID).Warn("denying every client of project with an invalid denied IP range")
+
+		return &serving.IPAccess{Allow: forwarded.Proxies{}}
+	}
+
+	result.Deny = denied
+
+	return result
+}
+
+// fabricateIndexFiles returns the valid index file names of the API, so a
+// name can not serve a file outside of the requested directory
+func fabricateIndexFiles(names []string) []string {
+	var result []string
+
+	for _, name := range names {
+		if config.ValidIndexFile(name) {
+			result = append(result, name)
+		}
+	}
+
+	return result
+}
+
+// fabricateTLSPolicy fabricates a domain TLSPolicy based on the API TLSPolicy.
+// It returns nil when the API does not override any of the instance defaults.
+func fabricateTLSPolicy(name string, policy *api.TLSPolicy) *domain.TLSPolicy {
+	if policy == nil {
+		return nil
+	}
+
+	minVersion, ok := tls.AllTLSVersions[policy.MinVersion]
+	if !ok {
+		log.WithFields(logrus.Fields{
+			"domain":          name,
+			"tls_min_version": policy.MinVersion,
+		}).Warn("ignoring unsupported TLS minimum version for domain")
+	}
+
+	disableHTTP2 := policy.HTTP2 != nil && !*policy.HTTP2
+	if minVersion == 0 && !disableHTTP2 {
+		return nil
+	}
+
+	return &domain.TLSPolicy{
+		MinVersion:   minVersion,
+		DisableHTTP2: disableHTTP2,
+	}
+}
+
+// fabricateEmbeddingPolicy fabricates a domain EmbeddingPolicy based on the API
+// EmbeddingPolicy. Frame ancestors which are not valid CSP sources are ignored
+// and it returns nil when none of them is left.
+func fabricateEmbeddingPolicy(name string, policy *api.EmbeddingPolicy) *domain.EmbeddingPolicy {
+	if policy == nil {
+		return nil
+	}
+
+	var ancestors []string
+	for _, ancestor := range policy.FrameAncestors {
+		if ancestor == "" || strings.ContainsAny(ancestor, " \t\r\n;,'\"") {
+			log.WithFields(logrus.Fields{
+				"domain":         name,
+				"frame_ancestor": ancestor,
+			}).Warn("ignoring invalid frame ancestor for domain")
+			continue
+		}
+
+		ancestors = append(ancestors, ancestor)
+	}
+
+	if len(ancestors) == 0 {
+		return nil
+	}
+
+	return &domain.EmbeddingPolicy{FrameAncestors: ancestors}
+}
+
+// fabricatePagesURL returns the URL on the pages domain HTTP requests to the
+// custom domain are redirected to when it has no certificate. It returns an
+// empty string when the domain opted out or the URL is not a valid HTTPS URL.
+func fabricatePagesURL(name string, vd *api.VirtualDomain) string {
+	if vd.PagesURL == "" || (vd.PagesDomainRedirect != nil && !*vd.PagesDomainRedirect) {
+		return ""
+	}
+
+	u, err := url.Parse(vd.PagesURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" || strings.EqualFold(u.Hostname(), name) {
+		log.WithFields(logrus.Fields{
+			"domain":    name,
+			"pages_url": vd.PagesURL,
+		}).Warn("ignoring invalid pages URL for domain")
+
+		return ""
+	}
+
+	return vd.PagesURL
+}
+
 // fabricateServing fabricates serving based on the GitLab API response
 func (g *Gitlab) fabricateServing(lookup api.LookupPath) (serving.Serving, error) {
 	source := lookup.Source
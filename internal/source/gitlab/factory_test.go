@@ -1,11 +1,16 @@
 package gitlab
 
 import (
+	"crypto/tls"
+	"net"
+	"net/http"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
-	"gitlab.com/gitlab-org/gitlab-pages/internal/serving/disk"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving/disk/local"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
 )
 
@@ -27,6 +32,242 @@ func TestFabricateLookupPath(t *testing.T) {
 		require.Equal(t, path.Prefix, "/")
 		require.True(t, path.IsNamespaceProject)
 	})
+
+	t.Run("when the deployment creation time is known", func(t *testing.T) {
+		createdAt := time.Date(2021, time.March, 4, 10, 30, 0, 0, time.UTC)
+		lookup := api.LookupPath{Prefix: "/", Source: api.Source{CreatedAt: createdAt}}
+
+		path := fabricateLookupPath(1, lookup)
+
+		require.Equal(t, createdAt, path.DeployedAt)
+	})
+
+	t.Run("when language negotiation is enabled", func(t *testing.T) {
+		lookup := api.LookupPath{Prefix: "/", LanguageNegotiation: true}
+
+		path := fabricateLookupPath(1, lookup)
+
+		require.True(t, path.LanguageNegotiation)
+	})
+
+	t.Run("when a fallback prefix is set", func(t *testing.T) {
+		lookup := api.LookupPath{Prefix: "/", FallbackPrefix: "/latest/"}
+
+		path := fabricateLookupPath(1, lookup)
+
+		require.Equal(t, "latest", path.FallbackPrefix)
+	})
+
+	t.Run("when case-insensitive paths are enabled", func(t *testing.T) {
+		lookup := api.LookupPath{Prefix: "/", CaseInsensitive: true}
+
+		path := fabricateLookupPath(1, lookup)
+
+		require.True(t, path.CaseInsensitive)
+	})
+
+	t.Run("when the namespace is set", func(t *testing.T) {
+		lookup := api.LookupPath{Prefix: "/", Namespace: "group/subgroup"}
+
+		path := fabricateLookupPath(1, lookup)
+
+		require.Equal(t, "group/subgroup", path.Namespace)
+	})
+
+	t.Run("when the SPA fallback is enabled", func(t *testing.T) {
+		lookup := api.LookupPath{Prefix: "/", SPAFallback: true}
+
+		path := fabricateLookupPath(1, lookup)
+
+		require.True(t, path.SPAFallback)
+	})
+
+	t.Run("when the directory listing is enabled", func(t *testing.T) {
+		lookup := api.LookupPath{Prefix: "/", DirectoryListing: true}
+
+		path := fabricateLookupPath(1, lookup)
+
+		require.True(t, path.DirectoryListing)
+	})
+
+	t.Run("when index files are set", func(t *testing.T) {
+		lookup := api.LookupPath{Prefix: "/", IndexFiles: []string{"index.htm", "../index.html", "", "default.html"}}
+
+		path := fabricateLookupPath(1, lookup)
+
+		require.Equal(t, []string{"index.htm", "default.html"}, path.IndexFiles)
+	})
+
+	t.Run("when the canonical domain is set", func(t *testing.T) {
+		lookup := api.LookupPath{Prefix: "/project/", CanonicalDomain: "WWW.Example.com"}
+
+		path := fabricateLookupPath(1, lookup)
+
+		require.Equal(t, "www.example.com", path.CanonicalDomain)
+	})
+}
+
+func TestFabricateTLSPolicy(t *testing.T) {
+	enabled, disabled := true, false
+
+	tests := map[string]struct {
+		policy   *api.TLSPolicy
+		expected *domain.TLSPolicy
+	}{
+		"no_policy": {},
+		"empty_policy": {
+			policy: &api.TLSPolicy{},
+		},
+		"http2_enabled": {
+			policy: &api.TLSPolicy{HTTP2: &enabled},
+		},
+		"unsupported_min_version": {
+			policy: &api.TLSPolicy{MinVersion: "tls1.0"},
+		},
+		"min_version": {
+			policy:   &api.TLSPolicy{MinVersion: "tls1.3"},
+			expected: &domain.TLSPolicy{MinVersion: tls.VersionTLS13},
+		},
+		"http2_disabled": {
+			policy:   &api.TLSPolicy{MinVersion: "tls1.2", HTTP2: &disabled},
+			expected: &domain.TLSPolicy{MinVersion: tls.VersionTLS12, DisableHTTP2: true},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tt.expected, fabricateTLSPolicy("example.com", tt.policy))
+		})
+	}
+}
+
+func TestFabricateEmbeddingPolicy(t *testing.T) {
+	tests := map[string]struct {
+		policy   *api.EmbeddingPolicy
+		expected *domain.EmbeddingPolicy
+	}{
+		"no_policy": {},
+		"no_frame_ancestors": {
+			policy: &api.EmbeddingPolicy{},
+		},
+		"frame_ancestors": {
+			policy:   &api.EmbeddingPolicy{FrameAncestors: []string{"https://tool.example.com", "*.example.org"}},
+			expected: &domain.EmbeddingPolicy{FrameAncestors: []string{"https://tool.example.com", "*.example.org"}},
+		},
+		"invalid_frame_ancestors": {
+			policy: &api.EmbeddingPolicy{FrameAncestors: []string{
+				"https://tool.example.com; script-src *",
+				"'none'",
+				"",
+				"https://tool.example.com",
+			}},
+			expected: &domain.EmbeddingPolicy{FrameAncestors: []string{"https://tool.example.com"}},
+		},
+		"only_invalid_frame_ancestors": {
+			policy: &api.EmbeddingPolicy{FrameAncestors: []string{"a b"}},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tt.expected, fabricateEmbeddingPolicy("example.com", tt.policy))
+		})
+	}
+}
+
+func TestFabricateIPAccess(t *testing.T) {
+	tests := map[string]struct {
+		access  *api.IPAccess
+		allowed []string
+		denied  []string
+	}{
+		"no_access": {
+			allowed: []string{"10.0.0.1", "192.168.1.1"},
+		},
+		"empty_access": {
+			access:  &api.IPAccess{},
+			allowed: []string{"10.0.0.1", "192.168.1.1"},
+		},
+		"allow": {
+			access:  &api.IPAccess{Allow: []string{"10.0.0.0/8", "192.168.1.1"}},
+			allowed: []string{"10.0.0.1", "192.168.1.1"},
+			denied:  []string{"192.168.1.2", "2001:db8::1"},
+		},
+		"deny": {
+			access:  &api.IPAccess{Deny: []string{"10.0.0.0/8"}},
+			allowed: []string{"192.168.1.1", "2001:db8::1"},
+			denied:  []string{"10.0.0.1"},
+		},
+		"allow_and_deny": {
+			access:  &api.IPAccess{Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.0.1.0/24"}},
+			allowed: []string{"10.0.0.1"},
+			denied:  []string{"10.0.1.1", "192.168.1.1"},
+		},
+		"invalid_allow": {
+			access:  &api.IPAccess{Allow: []string{"10.0.0.0/33", "192.168.1.1"}},
+			allowed: []string{"192.168.1.1"},
+			denied:  []string{"10.0.0.1"},
+		},
+		"only_invalid_allow": {
+			access: &api.IPAccess{Allow: []string{"invalid"}},
+			denied: []string{"10.0.0.1", "192.168.1.1"},
+		},
+		"invalid_deny": {
+			access: &api.IPAccess{Deny: []string{"10.0.0.0/8", "invalid"}},
+			denied: []string{"10.0.0.1", "192.168.1.1"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			access := fabricateIPAccess(1, tt.access)
+
+			for _, ip := range tt.allowed {
+				r := &http.Request{RemoteAddr: net.JoinHostPort(ip, "1234")}
+				require.True(t, access.Allows(r), ip)
+			}
+
+			for _, ip := range tt.denied {
+				r := &http.Request{RemoteAddr: net.JoinHostPort(ip, "1234")}
+				require.False(t, access.Allows(r), ip)
+			}
+		})
+	}
+}
+
+func TestFabricatePagesURL(t *testing.T) {
+	disabled := false
+
+	tests := map[string]struct {
+		domain   *api.VirtualDomain
+		expected string
+	}{
+		"no_pages_url": {
+			domain: &api.VirtualDomain{},
+		},
+		"pages_url": {
+			domain:   &api.VirtualDomain{PagesURL: "https://group.gitlab.io/project"},
+			expected: "https://group.gitlab.io/project",
+		},
+		"opted_out": {
+			domain: &api.VirtualDomain{PagesURL: "https://group.gitlab.io/project", PagesDomainRedirect: &disabled},
+		},
+		"http_pages_url": {
+			domain: &api.VirtualDomain{PagesURL: "http://group.gitlab.io/project"},
+		},
+		"relative_pages_url": {
+			domain: &api.VirtualDomain{PagesURL: "/project"},
+		},
+		"same_domain": {
+			domain: &api.VirtualDomain{PagesURL: "https://Example.com/"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tt.expected, fabricatePagesURL("example.com", tt.domain))
+		})
+	}
 }
 
 func TestFabricateServing(t *testing.T) {
@@ -41,7 +282,7 @@ func TestFabricateServing(t *testing.T) {
 		}
 		srv, err := g.fabricateServing(lookup)
 		require.NoError(t, err)
-		require.IsType(t, &disk.Disk{}, srv)
+		require.Equal(t, local.Instance(), srv)
 	})
 
 	t.Run("when lookup path requires disk serving but disk is disabled", func(t *testing.T) {
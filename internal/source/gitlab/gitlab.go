@@ -3,15 +3,23 @@ package gitlab
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"path"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"gitlab.com/gitlab-org/labkit/log"
 
+	"gitlab.com/gitlab-org/gitlab-pages/internal/cachedump"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/debugtrace"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/feature"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/handoff"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/request"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
@@ -19,16 +27,40 @@ import (
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/client"
 )
 
+var errDeploymentChanged = errors.New("the deployment of the lookup path changed")
+
+// readinessCheckInterval is the interval at which the status of the GitLab
+// API is checked until it is reachable
+const readinessCheckInterval = time.Second
+
+// statusChecker checks the status of the GitLab API
+type statusChecker interface {
+	Status(ctx context.Context) error
+}
+
 // Gitlab source represent a new domains configuration source. We fetch all the
 // information about domains from GitLab instance.
 type Gitlab struct {
 	client     api.Resolver
 	enableDisk bool
+	// ready is set to 1 once the GitLab API was reachable
+	ready int32
+
+	// domains holds a *cachedDomain per domain name, so the domain built from
+	// a cached lookup, and its parsed certificate, is shared by the requests
+	// until the lookup is refreshed
+	domains sync.Map
+}
+
+type cachedDomain struct {
+	virtualDomain *api.VirtualDomain
+	domain        *domain.Domain
 }
 
-// New returns a new instance of gitlab domain source.
-func New(cfg *config.GitLab) (*Gitlab, error) {
-	glClient, err := client.NewFromConfig(cfg)
+// New returns a new instance of gitlab domain source, its client resolves
+// the servers of the GitLab API until ctx is done.
+func New(ctx context.Context, cfg *config.GitLab) (*Gitlab, error) {
+	glClient, err := client.NewFromConfig(ctx, cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -38,12 +70,45 @@ func New(cfg *config.GitLab) (*Gitlab, error) {
 		enableDisk: cfg.EnableDisk,
 	}
 
+	go g.poll(ctx, glClient, readinessCheckInterval)
+
 	return g, nil
 }
 
+// IsReady returns true once the GitLab API was reachable
+func (g *Gitlab) IsReady() bool {
+	return atomic.LoadInt32(&g.ready) == 1
+}
+
+// poll checks the status of the GitLab API every interval until it is
+// reachable or ctx is done
+func (g *Gitlab) poll(ctx context.Context, checker statusChecker, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := checker.Status(ctx)
+		if err == nil {
+			atomic.StoreInt32(&g.ready, 1)
+			return
+		}
+
+		log.WithError(err).Warn("the GitLab API is not reachable yet")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 // GetDomain return a representation of a domain that we have fetched from
 // GitLab
 func (g *Gitlab) GetDomain(ctx context.Context, name string) (*domain.Domain, error) {
+	trace := debugtrace.FromContext(ctx)
+	trace.Add("source", "gitlab")
+
 	lookup := g.client.Resolve(ctx, name)
 
 	if lookup.Error != nil {
@@ -51,16 +116,188 @@ func (g *Gitlab) GetDomain(ctx context.Context, name string) (*domain.Domain, er
 			log.WithError(lookup.Error).Error("Pages cannot communicate with an instance of the GitLab API. Please sync your gitlab-secrets.json file: https://docs.gitlab.com/ee/administration/pages/#pages-cannot-communicate-with-an-instance-of-the-gitlab-api")
 		}
 
+		g.domains.Delete(name)
+
 		return nil, lookup.Error
 	}
 
-	// TODO introduce a second-level cache for domains, invalidate using etags
-	// from first-level cache
-	d := domain.New(name, lookup.Domain.Certificate, lookup.Domain.Key, g)
+	if feature.DebugHeader.EnabledFor(feature.FlagsFor(name, lookup.Domain.FeatureFlags)) {
+		trace.Enable()
+	}
+
+	if cached, ok := g.domains.Load(name); ok && cached.(*cachedDomain).virtualDomain == lookup.Domain {
+		return cached.(*cachedDomain).domain, nil
+	}
+
+	d := g.newDomain(name, lookup)
+	g.domains.Store(name, &cachedDomain{virtualDomain: lookup.Domain, domain: d})
 
 	return d, nil
 }
 
+func (g *Gitlab) newDomain(name string, lookup *api.Lookup) *domain.Domain {
+	d := domain.New(name, lookup.Domain.Certificate, lookup.Domain.Key, g)
+	d.TLSPolicy = fabricateTLSPolicy(name, lookup.Domain.TLS)
+	d.EmbeddingPolicy = fabricateEmbeddingPolicy(name, lookup.Domain.Embedding)
+	d.PagesURL = fabricatePagesURL(name, lookup.Domain)
+
+	d.Unverified = lookup.Domain.Verified != nil && !*lookup.Domain.Verified
+
+	if lookup.Domain.RedirectAliases && !strings.EqualFold(name, lookup.Domain.PrimaryDomain) {
+		d.PrimaryDomain = lookup.Domain.PrimaryDomain
+	}
+
+	return d
+}
+
+// evicter is implemented by resolvers caching domains
+type evicter interface {
+	Evict(domain string)
+}
+
+// peeker is implemented by resolvers caching domains
+type peeker interface {
+	Peek(domain string) *api.Lookup
+}
+
+// Unrestricted returns true when the cached configuration of the domain is
+// verified and none of its projects restricts its visitors with access
+// control or an IP access list. Only these domains can be served without
+// GitLab, e.g. from disk while the API is unavailable.
+func (g *Gitlab) Unrestricted(name string) bool {
+	c, ok := g.client.(peeker)
+	if !ok {
+		return false
+	}
+
+	lookup := c.Peek(name)
+	if lookup == nil || lookup.Error != nil || lookup.Domain == nil {
+		return false
+	}
+
+	if lookup.Domain.Verified != nil && !*lookup.Domain.Verified {
+		return false
+	}
+
+	for _, lookupPath := range lookup.Domain.LookupPaths {
+		if lookupPath.AccessControl || lookupPath.IPAccess != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// DumpDomains returns the cached domains for which match returns true, it
+// returns nil when the domains are not cached
+func (g *Gitlab) DumpDomains(match func(name string) bool) []cachedump.Domain {
+	if c, ok := g.client.(cachedump.DomainsDumper); ok {
+		return c.DumpDomains(match)
+	}
+
+	return nil
+}
+
+// ExportDomains returns the cached domains to hand them off to the next
+// process, it returns nil when the domains are not cached
+func (g *Gitlab) ExportDomains() []handoff.Domain {
+	if c, ok := g.client.(handoff.DomainsCache); ok {
+		return c.ExportDomains()
+	}
+
+	return nil
+}
+
+// ImportDomains caches the domains handed off by the previous process
+func (g *Gitlab) ImportDomains(domains []handoff.Domain) int {
+	if c, ok := g.client.(handoff.DomainsCache); ok {
+		return c.ImportDomains(domains)
+	}
+
+	return 0
+}
+
+// PreloadArchives opens the archives of the lookup paths of domains whose
+// SHA256 is one of keys, so the archives opened by the previous process are
+// served without reading their index on their first request
+func (g *Gitlab) PreloadArchives(ctx context.Context, domains []handoff.Domain, keys []string) int {
+	pending := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		pending[key] = true
+	}
+
+	preloaded := 0
+	for _, d := range domains {
+		if d.Domain == nil {
+			continue
+		}
+
+		size := len(d.Domain.LookupPaths)
+		for _, lp := range d.Domain.LookupPaths {
+			if !pending[lp.Source.SHA256] {
+				continue
+			}
+
+			// the archives shared by several lookup paths are opened once
+			delete(pending, lp.Source.SHA256)
+
+			srv, err := g.fabricateServing(lp)
+			if err != nil {
+				continue
+			}
+
+			preloader, ok := srv.(serving.Preloader)
+			if !ok {
+				continue
+			}
+
+			if err := preloader.Preload(ctx, fabricateLookupPath(size, lp)); err != nil {
+				log.WithError(err).WithField("domain", d.Name).Debug("could not open the handed off archive")
+				continue
+			}
+
+			preloaded++
+		}
+	}
+
+	return preloaded
+}
+
+// RefreshDomain evicts the cached configuration of the domain and retrieves
+// the latest one from GitLab. When preload is true the content of every lookup
+// path is prepared too, e.g. zip archives are opened, so new deployments are
+// served right away.
+func (g *Gitlab) RefreshDomain(ctx context.Context, name string, preload bool) error {
+	if c, ok := g.client.(evicter); ok {
+		c.Evict(name)
+	}
+
+	lookup := g.client.Resolve(ctx, name)
+	if lookup.Error != nil {
+		return lookup.Error
+	}
+
+	if !preload || lookup.Domain == nil {
+		return nil
+	}
+
+	size := len(lookup.Domain.LookupPaths)
+	for _, lp := range lookup.Domain.LookupPaths {
+		srv, err := g.fabricateServing(lp)
+		if err != nil {
+			return err
+		}
+
+		if preloader, ok := srv.(serving.Preloader); ok {
+			if err := preloader.Preload(ctx, fabricateLookupPath(size, lp)); err != nil {
+				return fmt.Errorf("preloading %s%s: %w", name, lp.Prefix, err)
+			}
+		}
+	}
+
+	return nil
+}
+
 // Resolve is supposed to return the serving request containing lookup path,
 // subpath for a given lookup and the serving itself created based on a request
 // from GitLab pages domains source
@@ -72,12 +309,21 @@ func (g *Gitlab) Resolve(r *http.Request) (*serving.Request, error) {
 		return nil, response.Error
 	}
 
+	// the domain can be removed from GitLab after it was retrieved for the
+	// request, it is not served anymore
+	if response.Domain == nil {
+		return nil, domain.ErrDomainDoesNotExist
+	}
+
 	urlPath := path.Clean(r.URL.Path)
 	size := len(response.Domain.LookupPaths)
 
-	sortLookupsByPrefixLengthDesc(response.Domain.LookupPaths)
+	// the lookup paths are shared by the requests to the cached domain, they
+	// are sorted in a copy
+	lookups := append([]api.LookupPath(nil), response.Domain.LookupPaths...)
+	sortLookupsByPrefixLengthDesc(lookups)
 
-	for _, lookup := range response.Domain.LookupPaths {
+	for _, lookup := range lookups {
 		isSubPath := strings.HasPrefix(urlPath, lookup.Prefix)
 		isRootPath := urlPath == path.Clean(lookup.Prefix)
 
@@ -92,9 +338,18 @@ func (g *Gitlab) Resolve(r *http.Request) (*serving.Request, error) {
 				return nil, err
 			}
 
+			lookupPath := fabricateLookupPath(size, lookup)
+			lookupPath.FeatureFlags = feature.FlagsFor(host, response.Domain.FeatureFlags)
+			lookupPath.ServeBlockedExtensions = response.Domain.ServeBlockedExtensions
+			if lookup.Source.Type == "zip" {
+				lookupPath.RefreshPath = g.pathRefresher(host, lookup.Prefix, lookup.Source.SHA256)
+			}
+
+			debugtrace.FromContext(r.Context()).Add("prefix", lookup.Prefix)
+
 			return &serving.Request{
 				Serving:    srv,
-				LookupPath: fabricateLookupPath(size, lookup),
+				LookupPath: lookupPath,
 				SubPath:    subPath}, nil
 		}
 	}
@@ -102,6 +357,34 @@ func (g *Gitlab) Resolve(r *http.Request) (*serving.Request, error) {
 	return nil, domain.ErrDomainDoesNotExist
 }
 
+// pathRefresher returns a function retrieving the latest path of the
+// deployment from GitLab, which is called when the pre-signed URL of an
+// archive expired while it was cached
+func (g *Gitlab) pathRefresher(name, prefix, sha string) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		if c, ok := g.client.(evicter); ok {
+			c.Evict(name)
+		}
+
+		lookup := g.client.Resolve(ctx, name)
+		if lookup.Error != nil {
+			return "", lookup.Error
+		}
+
+		if lookup.Domain == nil {
+			return "", errDeploymentChanged
+		}
+
+		for _, lp := range lookup.Domain.LookupPaths {
+			if lp.Prefix == prefix && lp.Source.SHA256 == sha {
+				return lp.Source.Path, nil
+			}
+		}
+
+		return "", errDeploymentChanged
+	}
+}
+
 // Ensure lookupPaths are sorted by prefix length to ensure the group level
 // domain with prefix "/" is the last one to be checked.
 // See https://gitlab.com/gitlab-org/gitlab-pages/-/issues/576
@@ -2,11 +2,18 @@ package gitlab
 
 import (
 	"context"
+	"errors"
 	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
+	"gitlab.com/gitlab-org/gitlab-pages/internal/debugtrace"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/feature"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/handoff"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/client"
 )
@@ -22,6 +29,25 @@ func TestGetDomain(t *testing.T) {
 		require.Equal(t, "test.gitlab.io", domain.Name)
 	})
 
+	t.Run("when the lookup is cached", func(t *testing.T) {
+		lookup := &api.Lookup{Name: "test.gitlab.io", Domain: &api.VirtualDomain{}}
+		c := client.StubClient{Lookup: lookup}
+		source := Gitlab{client: c}
+
+		first, err := source.GetDomain(context.Background(), "test.gitlab.io")
+		require.NoError(t, err)
+
+		second, err := source.GetDomain(context.Background(), "test.gitlab.io")
+		require.NoError(t, err)
+		require.Same(t, first, second)
+
+		lookup.Domain = &api.VirtualDomain{}
+
+		refreshed, err := source.GetDomain(context.Background(), "test.gitlab.io")
+		require.NoError(t, err)
+		require.NotSame(t, first, refreshed)
+	})
+
 	t.Run("when the response is not valid", func(t *testing.T) {
 		client := client.StubClient{File: "/dev/null"}
 		source := Gitlab{client: client}
@@ -39,6 +65,58 @@ func TestGetDomain(t *testing.T) {
 		_, err := source.GetDomain(context.Background(), "test")
 		require.EqualError(t, err, client.ErrUnauthorizedAPI.Error())
 	})
+
+	t.Run("when the debug header is enabled for the domain", func(t *testing.T) {
+		c := client.StubClient{Lookup: &api.Lookup{
+			Name:   "test.gitlab.io",
+			Domain: &api.VirtualDomain{FeatureFlags: map[string]bool{feature.DebugHeader.Name: true}},
+		}}
+		source := Gitlab{client: c}
+
+		trace := debugtrace.New(time.Now())
+		_, err := source.GetDomain(debugtrace.WithTrace(context.Background(), trace), "test.gitlab.io")
+		require.NoError(t, err)
+
+		require.True(t, trace.Enabled())
+		require.Contains(t, trace.String(), "source=gitlab")
+	})
+
+	t.Run("when the aliases of the domain are redirected", func(t *testing.T) {
+		c := client.StubClient{Lookup: &api.Lookup{
+			Domain: &api.VirtualDomain{
+				PrimaryDomain:   "primary.com",
+				Aliases:         []string{"alias.com"},
+				RedirectAliases: true,
+			},
+		}}
+		source := Gitlab{client: c}
+
+		alias, err := source.GetDomain(context.Background(), "alias.com")
+		require.NoError(t, err)
+		require.Equal(t, "primary.com", alias.PrimaryDomain)
+
+		primary, err := source.GetDomain(context.Background(), "primary.com")
+		require.NoError(t, err)
+		require.Empty(t, primary.PrimaryDomain)
+	})
+
+	t.Run("when the domain is not verified", func(t *testing.T) {
+		verified := false
+		lookup := &api.Lookup{
+			Domain: &api.VirtualDomain{Verified: &verified},
+		}
+		source := Gitlab{client: client.StubClient{Lookup: lookup}}
+
+		d, err := source.GetDomain(context.Background(), "custom.com")
+		require.NoError(t, err)
+		require.True(t, d.Unverified)
+
+		verified = true
+		lookup.Domain = &api.VirtualDomain{Verified: &verified}
+		d, err = source.GetDomain(context.Background(), "custom.com")
+		require.NoError(t, err)
+		require.False(t, d.Unverified)
+	})
 }
 
 func TestResolve(t *testing.T) {
@@ -97,6 +175,17 @@ func TestResolve(t *testing.T) {
 		require.True(t, response.LookupPath.IsNamespaceProject)
 	})
 
+	t.Run("when the domain has feature flags", func(t *testing.T) {
+		target := "https://test.gitlab.io:443/my/pages/project/"
+		request := httptest.NewRequest("GET", target, nil)
+
+		response, err := source.Resolve(request)
+		require.NoError(t, err)
+
+		someFeature := feature.Feature{EnvVariable: "FF_SOME_FEATURE", Name: "some_feature"}
+		require.True(t, someFeature.EnabledFor(response.LookupPath.FeatureFlags))
+	})
+
 	t.Run("when request path has not been sanitized", func(t *testing.T) {
 		target := "https://test.gitlab.io:443/something/../something/../my/pages/project/index.html"
 		request := httptest.NewRequest("GET", target, nil)
@@ -151,3 +240,241 @@ func TestResolveLookupPathsOrderDoesNotMatter(t *testing.T) {
 		})
 	}
 }
+
+// evictingResolver returns a fixed lookup and records the evicted domains
+type evictingResolver struct {
+	lookup  *api.Lookup
+	evicted []string
+}
+
+func (r *evictingResolver) Resolve(ctx context.Context, domain string) *api.Lookup {
+	return r.lookup
+}
+
+func (r *evictingResolver) Evict(domain string) {
+	r.evicted = append(r.evicted, domain)
+}
+
+func TestRefreshDomain(t *testing.T) {
+	dir := t.TempDir()
+
+	lookupFor := func(path string) *api.Lookup {
+		return &api.Lookup{Domain: &api.VirtualDomain{LookupPaths: []api.LookupPath{
+			{Prefix: "/", Source: api.Source{Type: "file", Path: path}},
+		}}}
+	}
+
+	tests := map[string]struct {
+		lookup        *api.Lookup
+		preload       bool
+		expectedError string
+	}{
+		"refresh": {
+			lookup: lookupFor("missing"),
+		},
+		"preload": {
+			lookup:  lookupFor(dir),
+			preload: true,
+		},
+		"preload_error": {
+			lookup:        lookupFor(dir + "/missing"),
+			preload:       true,
+			expectedError: "preloading test.gitlab.io/: could not evaluate symlinks",
+		},
+		"domain_does_not_exist": {
+			lookup:        &api.Lookup{Error: domain.ErrDomainDoesNotExist},
+			preload:       true,
+			expectedError: domain.ErrDomainDoesNotExist.Error(),
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			resolver := &evictingResolver{lookup: tt.lookup}
+			source := Gitlab{client: resolver, enableDisk: true}
+
+			err := source.RefreshDomain(context.Background(), "test.gitlab.io", tt.preload)
+			require.Equal(t, []string{"test.gitlab.io"}, resolver.evicted)
+
+			if tt.expectedError != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tt.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestPreloadArchives(t *testing.T) {
+	dir := t.TempDir()
+
+	domains := []handoff.Domain{
+		{Name: "missing.gitlab.io"},
+		{Name: "test.gitlab.io", Domain: &api.VirtualDomain{LookupPaths: []api.LookupPath{
+			{Prefix: "/a/", Source: api.Source{Type: "file", Path: dir, SHA256: "opened"}},
+			{Prefix: "/b/", Source: api.Source{Type: "file", Path: dir, SHA256: "opened"}},
+			{Prefix: "/c/", Source: api.Source{Type: "file", Path: dir + "/missing", SHA256: "failing"}},
+			{Prefix: "/d/", Source: api.Source{Type: "file", Path: dir, SHA256: "not_handed_off"}},
+		}}},
+	}
+
+	source := Gitlab{enableDisk: true}
+
+	preloaded := source.PreloadArchives(context.Background(), domains, []string{"opened", "failing"})
+	require.Equal(t, 1, preloaded, "the archives are opened once and the failures are not counted")
+}
+
+func TestResolvePathRefresher(t *testing.T) {
+	lookupFor := func(path, sha string) *api.Lookup {
+		return &api.Lookup{Domain: &api.VirtualDomain{LookupPaths: []api.LookupPath{
+			{Prefix: "/", Source: api.Source{Type: "zip", Path: path, SHA256: sha}},
+		}}}
+	}
+
+	resolver := &evictingResolver{lookup: lookupFor("https://example.com/expired.zip", "sha")}
+	source := Gitlab{client: resolver}
+
+	response, err := source.Resolve(httptest.NewRequest("GET", "https://test.gitlab.io/index.html", nil))
+	require.NoError(t, err)
+	require.NotNil(t, response.LookupPath.RefreshPath)
+
+	resolver.lookup = lookupFor("https://example.com/refreshed.zip", "sha")
+
+	path, err := response.LookupPath.RefreshPath(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/refreshed.zip", path)
+	require.Equal(t, []string{"test.gitlab.io"}, resolver.evicted)
+
+	resolver.lookup = lookupFor("https://example.com/redeployed.zip", "new-sha")
+
+	_, err = response.LookupPath.RefreshPath(context.Background())
+	require.ErrorIs(t, err, errDeploymentChanged)
+
+	resolver.lookup = &api.Lookup{Error: domain.ErrDomainDoesNotExist}
+
+	_, err = response.LookupPath.RefreshPath(context.Background())
+	require.ErrorIs(t, err, domain.ErrDomainDoesNotExist)
+}
+
+func TestResolveRemovedDomain(t *testing.T) {
+	// the domain was removed from GitLab after it was retrieved for the request
+	source := Gitlab{client: &evictingResolver{lookup: &api.Lookup{Name: "test.gitlab.io"}}}
+
+	response, err := source.Resolve(httptest.NewRequest("GET", "https://test.gitlab.io/index.html", nil))
+	require.ErrorIs(t, err, domain.ErrDomainDoesNotExist)
+	require.Nil(t, response)
+}
+
+func TestResolveConcurrentRequests(t *testing.T) {
+	lookup := &api.Lookup{Domain: &api.VirtualDomain{LookupPaths: []api.LookupPath{
+		{Prefix: "/", Source: api.Source{Type: "file", Path: "group/group.gitlab.io/public/"}},
+		{Prefix: "/project/", Source: api.Source{Type: "file", Path: "group/project/public/"}},
+		{Prefix: "/subgroup/project/", Source: api.Source{Type: "file", Path: "group/subgroup/project/public/"}},
+	}}}
+	source := Gitlab{client: &evictingResolver{lookup: lookup}, enableDisk: true}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			response, err := source.Resolve(httptest.NewRequest("GET", "https://group.gitlab.io/project/index.html", nil))
+			require.NoError(t, err)
+			require.Equal(t, "/project/", response.LookupPath.Prefix)
+		}()
+	}
+
+	wg.Wait()
+
+	// the cached lookup paths are left untouched
+	require.Equal(t, "/", lookup.Domain.LookupPaths[0].Prefix)
+}
+
+type peekingResolver struct {
+	client.StubClient
+}
+
+func (r peekingResolver) Peek(domain string) *api.Lookup {
+	return r.Lookup
+}
+
+func TestUnrestricted(t *testing.T) {
+	verified := false
+
+	tests := map[string]struct {
+		lookup   *api.Lookup
+		expected bool
+	}{
+		"public": {
+			lookup:   &api.Lookup{Domain: &api.VirtualDomain{LookupPaths: []api.LookupPath{{Prefix: "/"}}}},
+			expected: true,
+		},
+		"not_cached": {},
+		"error": {
+			lookup: &api.Lookup{Error: errors.New("unavailable")},
+		},
+		"access_control": {
+			lookup: &api.Lookup{Domain: &api.VirtualDomain{LookupPaths: []api.LookupPath{{Prefix: "/"}, {Prefix: "/private/", AccessControl: true}}}},
+		},
+		"ip_access": {
+			lookup: &api.Lookup{Domain: &api.VirtualDomain{LookupPaths: []api.LookupPath{{Prefix: "/", IPAccess: &api.IPAccess{Allow: []string{"10.0.0.0/8"}}}}}},
+		},
+		"unverified": {
+			lookup: &api.Lookup{Domain: &api.VirtualDomain{Verified: &verified, LookupPaths: []api.LookupPath{{Prefix: "/"}}}},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			source := Gitlab{client: peekingResolver{client.StubClient{Lookup: tt.lookup}}}
+
+			require.Equal(t, tt.expected, source.Unrestricted("test.gitlab.io"))
+		})
+	}
+
+	source := Gitlab{client: client.StubClient{}}
+	require.False(t, source.Unrestricted("test.gitlab.io"), "domains are restricted when they are not cached")
+}
+
+type statusCheckerFunc func(ctx context.Context) error
+
+func (f statusCheckerFunc) Status(ctx context.Context) error {
+	return f(ctx)
+}
+
+func TestPoll(t *testing.T) {
+	t.Run("ready once the API is reachable", func(t *testing.T) {
+		checks := 0
+		checker := statusCheckerFunc(func(context.Context) error {
+			checks++
+			if checks < 3 {
+				return errors.New("connection refused")
+			}
+
+			return nil
+		})
+
+		source := Gitlab{client: client.StubClient{}}
+		require.False(t, source.IsReady())
+
+		source.poll(context.Background(), checker, time.Millisecond)
+		require.True(t, source.IsReady())
+		require.Equal(t, 3, checks)
+	})
+
+	t.Run("stops when the context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		checker := statusCheckerFunc(func(context.Context) error {
+			cancel()
+			return errors.New("connection refused")
+		})
+
+		source := Gitlab{client: client.StubClient{}}
+		source.poll(ctx, checker, time.Hour)
+		require.False(t, source.IsReady())
+	})
+}
@@ -0,0 +1,143 @@
+// Package hostname implements a domains source for simple self-hosted setups
+// and local development, which serves the projects stored in pages-root
+// without the GitLab API
+package hostname
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/debugtrace"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/feature"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving/disk/local"
+)
+
+// Hostname source derives the group and project of a domain from its hostname
+// matching a template like {project}.{group}.pages.local, and serves them from
+// the `<group>/<project>/public` directories of the working directory, which
+// is pages-root.
+//
+// When the template does not include {project}, the first segment of the URL
+// path is the project, like for the group domains of GitLab. The paths of
+// other projects are served by the project named after the hostname, e.g.
+// `<group>/group.pages.local/public`.
+type Hostname struct {
+	labels []string
+}
+
+// New returns a new instance of the hostname domains source
+func New(cfg *config.HostnameSource) (*Hostname, error) {
+	labels, err := cfg.Labels()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Hostname{labels: labels}, nil
+}
+
+// GetDomain returns the domain of the group and project matching the hostname
+func (h *Hostname) GetDomain(ctx context.Context, name string) (*domain.Domain, error) {
+	debugtrace.FromContext(ctx).Add("source", "hostname")
+
+	group, project, ok := h.match(name)
+	if !ok || !isDir(group) {
+		return nil, domain.ErrDomainDoesNotExist
+	}
+
+	return domain.New(name, "", "", &resolver{host: name, group: group, project: project}), nil
+}
+
+// match returns the group and project of the hostname, project is empty when
+// the template does not include it
+func (h *Hostname) match(name string) (string, string, bool) {
+	labels := strings.Split(strings.ToLower(name), ".")
+	if len(labels) != len(h.labels) {
+		return "", "", false
+	}
+
+	var group, project string
+
+	for i, label := range labels {
+		switch h.labels[i] {
+		case config.HostnameGroupPlaceholder:
+			group = label
+		case config.HostnameProjectPlaceholder:
+			project = label
+		default:
+			if label != h.labels[i] {
+				return "", "", false
+			}
+		}
+	}
+
+	if !validName(group) || (project != "" && !validName(project)) {
+		return "", "", false
+	}
+
+	return group, project, true
+}
+
+// resolver resolves the requests to the projects of a group
+type resolver struct {
+	host    string
+	group   string
+	project string
+}
+
+// Resolve returns the project serving the request
+func (r *resolver) Resolve(req *http.Request) (*serving.Request, error) {
+	urlPath := path.Clean(req.URL.Path)
+	subPath := strings.TrimPrefix(urlPath, "/")
+
+	if r.project != "" {
+		return r.request(r.project, "/", subPath, false)
+	}
+
+	project := strings.SplitN(subPath, "/", 2)[0]
+	if validName(project) && project != r.host && isDir(publicDir(r.group, project)) {
+		return r.request(project, "/"+project+"/", strings.TrimPrefix(strings.TrimPrefix(subPath, project), "/"), false)
+	}
+
+	return r.request(r.host, "/", subPath, true)
+}
+
+func (r *resolver) request(project, prefix, subPath string, isNamespaceProject bool) (*serving.Request, error) {
+	dir := publicDir(r.group, project)
+	if !isDir(dir) {
+		return nil, domain.ErrDomainDoesNotExist
+	}
+
+	return &serving.Request{
+		Serving: local.Instance(),
+		LookupPath: &serving.LookupPath{
+			ServingType:        "file",
+			Prefix:             prefix,
+			Path:               dir + "/",
+			IsNamespaceProject: isNamespaceProject,
+			FeatureFlags:       feature.FlagsFor(r.host, nil),
+			Namespace:          r.group,
+		},
+		SubPath: subPath,
+	}, nil
+}
+
+func publicDir(group, project string) string {
+	return path.Join(group, project, "public")
+}
+
+// validName rejects the names which could escape pages-root
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+
+	return err == nil && fi.IsDir()
+}
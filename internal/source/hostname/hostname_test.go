@@ -0,0 +1,149 @@
+package hostname
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/testhelpers"
+)
+
+var chdirSet = false
+
+func TestGetDomain(t *testing.T) {
+	defer testhelpers.ChdirInPath(t, "../../../shared/pages", &chdirSet)()
+
+	source, err := New(&config.HostnameSource{Template: "{project}.{group}.pages.local"})
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		host        string
+		expectedErr error
+	}{
+		"existing_group": {
+			host: "project.group.pages.local",
+		},
+		"case_insensitive": {
+			host: "Project.Group.pages.local",
+		},
+		"missing_group": {
+			host:        "project.missing.pages.local",
+			expectedErr: domain.ErrDomainDoesNotExist,
+		},
+		"not_matching_template": {
+			host:        "project.group.example.com",
+			expectedErr: domain.ErrDomainDoesNotExist,
+		},
+		"more_labels": {
+			host:        "sub.project.group.pages.local",
+			expectedErr: domain.ErrDomainDoesNotExist,
+		},
+		"parent_directory": {
+			host:        "project...pages.local",
+			expectedErr: domain.ErrDomainDoesNotExist,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			d, err := source.GetDomain(context.Background(), tt.host)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				require.Nil(t, d)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.host, d.Name)
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	defer testhelpers.ChdirInPath(t, "../../../shared/pages", &chdirSet)()
+
+	tests := map[string]struct {
+		template          string
+		url               string
+		expectedPrefix    string
+		expectedPath      string
+		expectedSubPath   string
+		expectedNamespace bool
+		expectedErr       error
+	}{
+		"project_from_hostname": {
+			template:        "{project}.{group}.pages.local",
+			url:             "http://project.group.pages.local/index.html",
+			expectedPrefix:  "/",
+			expectedPath:    "group/project/public/",
+			expectedSubPath: "index.html",
+		},
+		"project_from_hostname_root": {
+			template:       "{project}.{group}.pages.local",
+			url:            "http://project.group.pages.local/",
+			expectedPrefix: "/",
+			expectedPath:   "group/project/public/",
+		},
+		"missing_project_from_hostname": {
+			template:    "{project}.{group}.pages.local",
+			url:         "http://missing.group.pages.local/",
+			expectedErr: domain.ErrDomainDoesNotExist,
+		},
+		"project_from_path": {
+			template:        "{group}.test.io",
+			url:             "http://group.test.io/project2/subdir/",
+			expectedPrefix:  "/project2/",
+			expectedPath:    "group/project2/public/",
+			expectedSubPath: "subdir",
+		},
+		"project_from_path_without_slash": {
+			template:       "{group}.test.io",
+			url:            "http://group.test.io/project2",
+			expectedPrefix: "/project2/",
+			expectedPath:   "group/project2/public/",
+		},
+		"group_project": {
+			template:          "{group}.test.io",
+			url:               "http://group.test.io/index2.html",
+			expectedPrefix:    "/",
+			expectedPath:      "group/group.test.io/public/",
+			expectedSubPath:   "index2.html",
+			expectedNamespace: true,
+		},
+		"path_traversal": {
+			template:        "{group}.test.io",
+			url:             "http://group.test.io/../project2/index.html",
+			expectedPrefix:  "/project2/",
+			expectedPath:    "group/project2/public/",
+			expectedSubPath: "index.html",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			source, err := New(&config.HostnameSource{Template: tt.template})
+			require.NoError(t, err)
+
+			r := httptest.NewRequest("GET", tt.url, nil)
+
+			d, err := source.GetDomain(context.Background(), r.Host)
+			require.NoError(t, err)
+
+			request, err := d.Resolver.Resolve(r)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedPrefix, request.LookupPath.Prefix)
+			require.Equal(t, tt.expectedPath, request.LookupPath.Path)
+			require.Equal(t, tt.expectedSubPath, request.SubPath)
+			require.Equal(t, tt.expectedNamespace, request.LookupPath.IsNamespaceProject)
+		})
+	}
+}
@@ -10,3 +10,9 @@ import (
 type Source interface {
 	GetDomain(context.Context, string) (*domain.Domain, error)
 }
+
+// Readiness is implemented by the sources which cannot serve domains right
+// after they are created, the other sources are always ready
+type Readiness interface {
+	IsReady() bool
+}
@@ -0,0 +1,126 @@
+// Package tenantmetrics counts the requests served by domain and namespace,
+// bounding the number of label values derived from user data so clients
+// requesting many hostnames can not explode the cardinality of the metrics
+package tenantmetrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Other is the label value counting the values which are not exported
+const Other = "other"
+
+// candidatesPerLabel bounds the number of values counted during an interval
+// for each value which can be exported, the requests to other values are
+// counted with Other only
+const candidatesPerLabel = 10
+
+// Limiter maps the values of a label to themselves when they are exported,
+// and to Other otherwise. Allowed values are always exported and denied
+// values never are. Up to max other values are exported, the ones requested
+// first until the values requested the most during an interval are known.
+type Limiter struct {
+	max   int
+	allow map[string]struct{}
+	deny  map[string]struct{}
+
+	mu       sync.Mutex
+	exported map[string]struct{}
+	counts   map[string]uint64
+}
+
+// NewLimiter returns a Limiter exporting the allowed values and at most max
+// other values which are not denied
+func NewLimiter(max int, allow, deny []string) *Limiter {
+	return &Limiter{
+		max:      max,
+		allow:    toSet(allow),
+		deny:     toSet(deny),
+		exported: make(map[string]struct{}, max),
+		counts:   make(map[string]uint64),
+	}
+}
+
+func toSet(values []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		set[strings.ToLower(value)] = struct{}{}
+	}
+
+	return set
+}
+
+// Label returns the label value of a request to value
+func (l *Limiter) Label(value string) string {
+	value = strings.ToLower(value)
+
+	if _, ok := l.deny[value]; ok || value == "" {
+		return Other
+	}
+
+	if _, ok := l.allow[value]; ok {
+		return value
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, exported := l.exported[value]
+	if _, ok := l.counts[value]; ok || exported || len(l.counts) < l.max*candidatesPerLabel {
+		l.counts[value]++
+	}
+
+	if exported {
+		return value
+	}
+
+	if len(l.exported) < l.max {
+		l.exported[value] = struct{}{}
+		return value
+	}
+
+	return Other
+}
+
+// Rotate exports the max values requested the most since the previous
+// rotation and resets the counts. It returns the values which are not
+// exported anymore, so their series can be deleted.
+func (l *Limiter) Rotate() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	values := make([]string, 0, len(l.counts))
+	for value := range l.counts {
+		values = append(values, value)
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		if l.counts[values[i]] != l.counts[values[j]] {
+			return l.counts[values[i]] > l.counts[values[j]]
+		}
+
+		return values[i] < values[j]
+	})
+
+	if len(values) > l.max {
+		values = values[:l.max]
+	}
+
+	exported := toSet(values)
+
+	var evicted []string
+	for value := range l.exported {
+		if _, ok := exported[value]; !ok {
+			evicted = append(evicted, value)
+		}
+	}
+
+	sort.Strings(evicted)
+
+	l.exported = exported
+	l.counts = make(map[string]uint64, len(l.counts))
+
+	return evicted
+}
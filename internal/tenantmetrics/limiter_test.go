@@ -0,0 +1,71 @@
+package tenantmetrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiterLabel(t *testing.T) {
+	l := NewLimiter(2, []string{"Allowed.gitlab.io"}, []string{"denied.gitlab.io"})
+
+	require.Equal(t, "a.gitlab.io", l.Label("a.gitlab.io"))
+	require.Equal(t, "b.gitlab.io", l.Label("B.gitlab.io"), "values are case insensitive")
+	require.Equal(t, Other, l.Label("c.gitlab.io"), "values over the limit are counted as other")
+	require.Equal(t, "a.gitlab.io", l.Label("a.gitlab.io"), "exported values keep their label")
+	require.Equal(t, "allowed.gitlab.io", l.Label("allowed.gitlab.io"), "allowed values are not limited")
+	require.Equal(t, Other, l.Label("denied.gitlab.io"))
+	require.Equal(t, Other, l.Label(""))
+}
+
+func TestLimiterRotate(t *testing.T) {
+	l := NewLimiter(2, []string{"allowed"}, nil)
+
+	for _, request := range []struct {
+		value    string
+		requests int
+	}{
+		{"first", 1},
+		{"second", 2},
+		{"third", 5},
+		{"fourth", 3},
+		{"allowed", 10},
+	} {
+		for i := 0; i < request.requests; i++ {
+			l.Label(request.value)
+		}
+	}
+
+	require.Equal(t, Other, l.Label("third"))
+
+	evicted := l.Rotate()
+	require.ElementsMatch(t, []string{"first", "second"}, evicted)
+	require.Equal(t, "third", l.Label("third"), "the most requested values are exported")
+	require.Equal(t, Other, l.Label("second"))
+
+	require.Equal(t, []string{"fourth"}, l.Rotate(), "the values which were not requested are evicted")
+	require.Equal(t, "second", l.Label("second"))
+	require.Equal(t, Other, l.Label("fourth"))
+
+	require.Equal(t, []string{"third"}, l.Rotate())
+	require.ElementsMatch(t, []string{"fourth", "second"}, l.Rotate())
+	require.Equal(t, "first", l.Label("first"), "the free labels are exported to the next values")
+}
+
+func TestLimiterCandidates(t *testing.T) {
+	l := NewLimiter(1, nil, nil)
+	l.Label("exported")
+
+	for i := 0; i < candidatesPerLabel*2; i++ {
+		l.Label(string(rune('a' + i)))
+	}
+
+	require.Len(t, l.counts, candidatesPerLabel, "the values counted are bounded")
+
+	for i := 0; i < 3; i++ {
+		l.Label("exported")
+	}
+
+	require.Empty(t, l.Rotate())
+	require.Equal(t, "exported", l.Label("exported"))
+}
@@ -0,0 +1,100 @@
+package tenantmetrics
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+// CustomDomain is the namespace label value of the requests to custom
+// domains, it can not be the name of a namespace
+const CustomDomain = "custom_domain"
+
+// DefaultRotateInterval is the interval at which the most requested domains
+// and namespaces are exported
+const DefaultRotateInterval = 10 * time.Minute
+
+// Counter counts the requests served by domain and namespace with
+// metrics.HostRequests and metrics.NamespaceRequests
+type Counter struct {
+	pagesDomain string
+	hosts       *Limiter
+	namespaces  *Limiter
+}
+
+// New returns a Counter exporting the top most requested domains and
+// namespaces of pagesDomain, next to the allowed ones
+func New(pagesDomain string, top int, allow, deny []string) *Counter {
+	return &Counter{
+		pagesDomain: strings.ToLower(pagesDomain),
+		hosts:       NewLimiter(top, allow, deny),
+		namespaces:  NewLimiter(top, allow, deny),
+	}
+}
+
+// Observe counts a request to the domain host
+func (c *Counter) Observe(host string) {
+	metrics.HostRequests.WithLabelValues(c.hosts.Label(host)).Inc()
+	metrics.NamespaceRequests.WithLabelValues(c.namespace(host)).Inc()
+}
+
+func (c *Counter) namespace(host string) string {
+	host = strings.ToLower(host)
+
+	namespace := strings.TrimSuffix(host, "."+c.pagesDomain)
+	if namespace == host || strings.Contains(namespace, ".") {
+		return CustomDomain
+	}
+
+	return c.namespaces.Label(namespace)
+}
+
+// Run exports the domains and namespaces requested the most during every
+// interval, until ctx is done
+func (c *Counter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.rotate()
+		}
+	}
+}
+
+func (c *Counter) rotate() {
+	for _, host := range c.hosts.Rotate() {
+		metrics.HostRequests.DeleteLabelValues(host)
+	}
+
+	for _, namespace := range c.namespaces.Rotate() {
+		metrics.NamespaceRequests.DeleteLabelValues(namespace)
+	}
+}
+
+// NewMiddleware returns middleware counting the requests served for the
+// domain resolved by the routing middleware with c. Requests to hosts which
+// are not served are not counted. It returns handler when c is nil.
+func NewMiddleware(handler http.Handler, c *Counter) http.Handler {
+	if c == nil {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+
+		d := domain.FromRequest(r)
+		if d == nil || d.Name == "" {
+			return
+		}
+
+		c.Observe(d.Name)
+	})
+}
@@ -0,0 +1,63 @@
+package tenantmetrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+func TestMiddleware(t *testing.T) {
+	c := New("gitlab.io", 1, nil, []string{"denied"})
+
+	handler := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), c)
+
+	serve := func(host string, d *domain.Domain) {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r = domain.ReqWithHostAndDomain(r, host, d)
+
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	hostRequests := func(label string) float64 {
+		return testutil.ToFloat64(metrics.HostRequests.WithLabelValues(label))
+	}
+
+	namespaceRequests := func(label string) float64 {
+		return testutil.ToFloat64(metrics.NamespaceRequests.WithLabelValues(label))
+	}
+
+	beforeOtherHosts := hostRequests(Other)
+	beforeOtherNamespaces := namespaceRequests(Other)
+	beforeCustomDomains := namespaceRequests(CustomDomain)
+
+	serve("group.gitlab.io", &domain.Domain{Name: "group.gitlab.io"})
+	require.Equal(t, float64(1), hostRequests("group.gitlab.io"))
+	require.Equal(t, float64(1), namespaceRequests("group"))
+
+	serve("other-group.gitlab.io", &domain.Domain{Name: "other-group.gitlab.io"})
+	require.Equal(t, beforeOtherHosts+1, hostRequests(Other))
+	require.Equal(t, beforeOtherNamespaces+1, namespaceRequests(Other))
+
+	serve("denied.gitlab.io", &domain.Domain{Name: "denied.gitlab.io"})
+	require.Equal(t, beforeOtherNamespaces+2, namespaceRequests(Other))
+
+	serve("custom.example.com", &domain.Domain{Name: "custom.example.com"})
+	require.Equal(t, beforeCustomDomains+1, namespaceRequests(CustomDomain))
+
+	serve("unknown.gitlab.io", nil)
+	require.Equal(t, beforeOtherHosts+3, hostRequests(Other), "requests to unknown domains are not counted")
+
+	c.rotate()
+	c.rotate()
+	require.Equal(t, float64(0), hostRequests("group.gitlab.io"), "the series of evicted domains are deleted")
+}
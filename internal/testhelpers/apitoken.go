@@ -0,0 +1,28 @@
+package testhelpers
+
+import (
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/security"
+)
+
+// SignAPIToken returns a token authenticating the requests to the
+// administrative endpoints of Pages signed with secret, which expires at
+// expiresAt or never when it is nil
+func SignAPIToken(t testing.TB, secret []byte, expiresAt *jwt.NumericDate) string {
+	t.Helper()
+
+	claims := jwt.RegisteredClaims{
+		Issuer:    security.APITokenIssuer,
+		Audience:  jwt.ClaimStrings{security.APITokenAudience},
+		ExpiresAt: expiresAt,
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	require.NoError(t, err)
+
+	return token
+}
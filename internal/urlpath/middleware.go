@@ -0,0 +1,22 @@
+package urlpath
+
+import (
+	"net/http"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/httperrors"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/logging"
+)
+
+// NewMiddleware returns middleware which rejects the requests with paths
+// that cannot be looked up safely with a 400 Bad Request response
+func NewMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := Validate(r.URL); err != nil {
+			logging.LogRequest(r).WithError(err).Debug("rejected request with invalid path")
+			httperrors.Serve400(w)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
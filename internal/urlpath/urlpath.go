@@ -0,0 +1,99 @@
+// Package urlpath validates the paths of requests before they are used to
+// look up files.
+//
+// Files are looked up by the decoded path, so the encoded forms of separators
+// and dots, e.g. `%2F` or `%2e%2e`, behave like their decoded forms and are
+// cleaned without leaving the deployment. Double encoded forms like `%252e`
+// are decoded once and looked up literally. Redirects keep the original
+// encoding of the path.
+package urlpath
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	// ErrControlCharacter is returned for paths containing control characters,
+	// including null bytes
+	ErrControlCharacter = errors.New("path contains a control character")
+	// ErrInvalidUTF8 is returned for paths which are not valid UTF-8 once decoded
+	ErrInvalidUTF8 = errors.New("path is not valid UTF-8")
+	// ErrLookalike is returned for paths containing characters which Unicode
+	// compatibility normalization turns into separators or dot segments, e.g.
+	// `／` or `．．`, which other systems normalizing the path could follow
+	ErrLookalike = errors.New("path contains a character normalized to a separator or dot segment")
+)
+
+// compatibility forms of the characters used in path traversals, as
+// decomposed by the NFKC normalization
+var lookalikes = map[rune]string{
+	'․': ".",   // ONE DOT LEADER
+	'‥': "..",  // TWO DOT LEADER
+	'…': "...", // HORIZONTAL ELLIPSIS
+	'﹒': ".",   // SMALL FULL STOP
+	'﹨': `\`,   // SMALL REVERSE SOLIDUS
+	'﹪': "%",   // SMALL PERCENT SIGN
+}
+
+// Validate returns an error when the decoded path of u cannot be looked up
+// safely
+func Validate(u *url.URL) error {
+	p := u.Path
+
+	if !utf8.ValidString(p) {
+		return ErrInvalidUTF8
+	}
+
+	for i := 0; i < len(p); i++ {
+		if p[i] < 0x20 || p[i] == 0x7f {
+			return ErrControlCharacter
+		}
+	}
+
+	for _, segment := range strings.Split(p, "/") {
+		if isLookalikeSegment(segment) {
+			return ErrLookalike
+		}
+	}
+
+	return nil
+}
+
+// isLookalikeSegment checks if the normalized form of segment, decoded once
+// more when the normalization introduced percent signs, contains separators
+// or is a dot segment
+func isLookalikeSegment(segment string) bool {
+	normalized := normalize(segment)
+	if normalized == segment {
+		return false
+	}
+
+	if unescaped, err := url.PathUnescape(normalized); err == nil {
+		normalized = unescaped
+	}
+
+	return normalized == "." || normalized == ".." || strings.ContainsAny(normalized, `/\`)
+}
+
+// normalize replaces the fullwidth forms of ASCII characters and the
+// lookalikes of segment with the characters they are compatible with
+func normalize(segment string) string {
+	var b strings.Builder
+
+	for _, r := range segment {
+		switch {
+		case r >= '！' && r <= '～':
+			// fullwidth forms are offset from the ASCII characters
+			b.WriteRune(r - 0xFEE0)
+		case lookalikes[r] != "":
+			b.WriteString(lookalikes[r])
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,161 @@
+package urlpath
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	tests := map[string]struct {
+		path         string
+		expectedPath string
+		expectedErr  error
+	}{
+		"plain": {
+			path:         "/project/index.html",
+			expectedPath: "/project/index.html",
+		},
+		"encoded_slash": {
+			path:         "/project%2Findex.html",
+			expectedPath: "/project/index.html",
+		},
+		"lowercase_encoded_slash": {
+			path:         "/project%2findex.html",
+			expectedPath: "/project/index.html",
+		},
+		"encoded_dot_segment": {
+			path:         "/project/%2e%2e/index.html",
+			expectedPath: "/project/../index.html",
+		},
+		"partially_encoded_dot_segment": {
+			path:         "/project/.%2e/index.html",
+			expectedPath: "/project/../index.html",
+		},
+		"dots_followed_by_encoded_dot": {
+			path:         "/project/..%2e/index.html",
+			expectedPath: "/project/.../index.html",
+		},
+		"encoded_traversal": {
+			path:         "/project/..%2F..%2Fsecret",
+			expectedPath: "/project/../../secret",
+		},
+		"double_encoded_traversal": {
+			path:         "/project/%252e%252e%252fsecret",
+			expectedPath: "/project/%2e%2e%2fsecret",
+		},
+		"encoded_backslash": {
+			path:         "/project/%5C..%5Csecret",
+			expectedPath: `/project/\..\secret`,
+		},
+		"unicode": {
+			path:         "/project/%C3%A9t%C3%A9.html",
+			expectedPath: "/project/été.html",
+		},
+		"ellipsis": {
+			path:         "/project/%E2%80%A6/index.html",
+			expectedPath: "/project/…/index.html",
+		},
+		"fullwidth_letters": {
+			path:         "/project/%EF%BD%81.html",
+			expectedPath: "/project/ａ.html",
+		},
+		"null_byte": {
+			path:        "/project/index.html%00.png",
+			expectedErr: ErrControlCharacter,
+		},
+		"newline": {
+			path:        "/project/%0D%0ALocation:%20evil",
+			expectedErr: ErrControlCharacter,
+		},
+		"delete": {
+			path:        "/project/%7F",
+			expectedErr: ErrControlCharacter,
+		},
+		"invalid_utf8": {
+			path:        "/project/%C3%28",
+			expectedErr: ErrInvalidUTF8,
+		},
+		"overlong_utf8_slash": {
+			path:        "/project/%C0%AF",
+			expectedErr: ErrInvalidUTF8,
+		},
+		"fullwidth_solidus": {
+			path:        "/project/..%EF%BC%8Fsecret",
+			expectedErr: ErrLookalike,
+		},
+		"fullwidth_reverse_solidus": {
+			path:        "/project/..%EF%BC%BCsecret",
+			expectedErr: ErrLookalike,
+		},
+		"fullwidth_full_stops": {
+			path:        "/project/%EF%BC%8E%EF%BC%8E/secret",
+			expectedErr: ErrLookalike,
+		},
+		"one_dot_leaders": {
+			path:        "/project/%E2%80%A4%E2%80%A4/secret",
+			expectedErr: ErrLookalike,
+		},
+		"two_dot_leader": {
+			path:        "/project/%E2%80%A5/secret",
+			expectedErr: ErrLookalike,
+		},
+		"small_full_stop": {
+			path:        "/project/.%EF%B9%92/secret",
+			expectedErr: ErrLookalike,
+		},
+		"fullwidth_encoded_dots": {
+			path:        "/project/%EF%BC%85%EF%BC%92%EF%BD%85%EF%BC%85%EF%BC%92%EF%BD%85/secret",
+			expectedErr: ErrLookalike,
+		},
+		"fullwidth_encoded_slash": {
+			path:        "/project/..%EF%BC%85%EF%BC%92%EF%BC%A6secret",
+			expectedErr: ErrLookalike,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			u, err := url.ParseRequestURI(tt.path)
+			require.NoError(t, err)
+
+			err = Validate(u)
+			require.Equal(t, tt.expectedErr, err)
+			if tt.expectedErr == nil {
+				require.Equal(t, tt.expectedPath, u.Path)
+			}
+		})
+	}
+}
+
+func TestNewMiddleware(t *testing.T) {
+	handler := NewMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+
+	tests := map[string]struct {
+		target         string
+		expectedStatus int
+	}{
+		"valid": {
+			target:         "/project%2Findex.html",
+			expectedStatus: http.StatusOK,
+		},
+		"invalid": {
+			target:         "/project/%EF%BC%8E%EF%BC%8E/secret",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			require.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
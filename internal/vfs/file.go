@@ -1,6 +1,9 @@
 package vfs
 
-import "io"
+import (
+	"errors"
+	"io"
+)
 
 // File represents an open file, which will typically be the response body of a Pages request.
 type File interface {
@@ -13,3 +16,15 @@ type SeekableFile interface {
 	File
 	io.Seeker
 }
+
+// SingleRangeFile is a SeekableFile of which only single ranges are served,
+// as its content is read again from the start when it is seeked backwards,
+// e.g. because it is decompressed
+type SingleRangeFile interface {
+	SeekableFile
+	SingleRange()
+}
+
+// ErrSizeMismatch is returned by the reads of files whose content is not of
+// the size of the file, which is served as its Content-Length
+var ErrSizeMismatch = errors.New("vfs: the content of the file does not match its size")
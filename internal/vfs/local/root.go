@@ -105,3 +105,30 @@ func (r *Root) Open(ctx context.Context, name string) (vfs.File, error) {
 
 	return file, nil
 }
+
+// Readdir returns the FileInfo of the files and directories of the directory
+// name, sorted by name. Symlinks are described rather than followed.
+func (r *Root) Readdir(ctx context.Context, name string) ([]os.FileInfo, error) {
+	fullPath, _, err := r.validatePath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(fullPath)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		fi, err := entry.Info()
+		if err != nil {
+			// the entry was removed since the directory was read
+			continue
+		}
+
+		infos = append(infos, fi)
+	}
+
+	return infos, nil
+}
@@ -231,6 +231,25 @@ func TestLstat(t *testing.T) {
 	}
 }
 
+func TestReaddir(t *testing.T) {
+	ctx := context.Background()
+	root, err := localVFS.Root(ctx, ".", "")
+	require.NoError(t, err)
+
+	infos, err := root.Readdir(ctx, "testdata")
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	require.Equal(t, "file", infos[0].Name())
+	require.Equal(t, "link", infos[1].Name())
+	require.Equal(t, os.ModeSymlink, infos[1].Mode()&os.ModeType, "symlinks are not followed")
+
+	_, err = root.Readdir(ctx, "testdata/file")
+	require.Error(t, err)
+
+	_, err = root.Readdir(ctx, "testdata/../..")
+	require.IsType(t, &invalidPathError{}, err)
+}
+
 func TestOpen(t *testing.T) {
 	ctx := context.Background()
 	root, err := localVFS.Root(ctx, ".", "")
@@ -0,0 +1,21 @@
+package vfs
+
+import "context"
+
+type refresherCtxKey struct{}
+
+// PathRefresher returns the current path of the root being served, e.g. a new
+// pre-signed URL of an archive once the previous one expired
+type PathRefresher func(ctx context.Context) (string, error)
+
+// WithPathRefresher returns a copy of ctx with refresh, which the VFS calls
+// when the access to the path of a root is denied
+func WithPathRefresher(ctx context.Context, refresh PathRefresher) context.Context {
+	return context.WithValue(ctx, refresherCtxKey{}, refresh)
+}
+
+// PathRefresherFromContext returns the PathRefresher of ctx, or nil
+func PathRefresherFromContext(ctx context.Context) PathRefresher {
+	refresh, _ := ctx.Value(refresherCtxKey{}).(PathRefresher)
+	return refresh
+}
@@ -16,6 +16,25 @@ type Root interface {
 	Lstat(ctx context.Context, name string) (os.FileInfo, error)
 	Readlink(ctx context.Context, name string) (string, error)
 	Open(ctx context.Context, name string) (File, error)
+	// Readdir returns the FileInfo of the files and directories of the
+	// directory name, sorted by name
+	Readdir(ctx context.Context, name string) ([]os.FileInfo, error)
+}
+
+// CaseInsensitiveRoot is implemented by the roots which can find their files
+// ignoring the case of their names
+type CaseInsensitiveRoot interface {
+	// CanonicalName returns the name of the file or directory matching name
+	// ignoring case, or os.ErrNotExist when there is none
+	CanonicalName(ctx context.Context, name string) (string, error)
+}
+
+// ChecksumFileInfo is implemented by the FileInfo of the files whose CRC32
+// checksum is known without reading them, like the files of zip archives
+type ChecksumFileInfo interface {
+	os.FileInfo
+	// CRC32 returns the CRC-32 checksum of the uncompressed content
+	CRC32() uint32
 }
 
 type instrumentedRoot struct {
@@ -68,3 +87,36 @@ func (i *instrumentedRoot) Open(ctx context.Context, name string) (File, error)
 
 	return f, err
 }
+
+func (i *instrumentedRoot) Readdir(ctx context.Context, name string) ([]os.FileInfo, error) {
+	entries, err := i.root.Readdir(ctx, name)
+
+	i.increment("Readdir", err)
+	i.log(ctx).
+		WithField("name", name).
+		WithField("ret-entries", len(entries)).
+		WithError(err).
+		Traceln("Readdir call")
+
+	return entries, err
+}
+
+// CanonicalName returns the name of the file or directory matching name
+// ignoring case, roots which cannot ignore case have no file matching it
+func (i *instrumentedRoot) CanonicalName(ctx context.Context, name string) (string, error) {
+	root, ok := i.root.(CaseInsensitiveRoot)
+	if !ok {
+		return "", os.ErrNotExist
+	}
+
+	canonical, err := root.CanonicalName(ctx, name)
+
+	i.increment("CanonicalName", err)
+	i.log(ctx).
+		WithField("name", name).
+		WithField("ret-name", canonical).
+		WithError(err).
+		Traceln("CanonicalName call")
+
+	return canonical, err
+}
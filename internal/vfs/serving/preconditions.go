@@ -0,0 +1,18 @@
+package serving
+
+import (
+	"net/http"
+	"time"
+)
+
+// CheckPreconditions evaluates the preconditions of the request against the
+// headers of w, like ETag, and modtime before the content is opened. It reports
+// whether a precondition resulted in sending StatusNotModified or
+// StatusPreconditionFailed.
+//
+// It is kept out of serving.go, which is a copy of net/http synced with
+// upstream.
+func CheckPreconditions(w http.ResponseWriter, r *http.Request, modtime time.Time) bool {
+	setLastModified(w, modtime)
+	return checkPreconditions(w, r, modtime)
+}
@@ -3,11 +3,14 @@ package vfs
 import (
 	"context"
 	"strconv"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"gitlab.com/gitlab-org/labkit/log"
 
+	"gitlab.com/gitlab-org/gitlab-pages/internal/cachedump"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/debugtrace"
 	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
@@ -35,7 +38,12 @@ func (i *instrumentedVFS) log(ctx context.Context) *logrus.Entry {
 }
 
 func (i *instrumentedVFS) Root(ctx context.Context, path string, cacheKey string) (Root, error) {
+	trace := debugtrace.FromContext(ctx)
+	trace.Add("vfs", i.fs.Name())
+
+	start := time.Now()
 	root, err := i.fs.Root(ctx, path, cacheKey)
+	trace.Observe("vfs_root", start)
 
 	i.increment("Root", err)
 	i.log(ctx).
@@ -57,3 +65,12 @@ func (i *instrumentedVFS) Name() string {
 func (i *instrumentedVFS) Reconfigure(cfg *config.Config) error {
 	return i.fs.Reconfigure(cfg)
 }
+
+// DumpArchives returns the archives cached by the VFS, if it caches any
+func (i *instrumentedVFS) DumpArchives() []cachedump.Archive {
+	if d, ok := i.fs.(cachedump.ArchivesDumper); ok {
+		return d.DumpArchives()
+	}
+
+	return nil
+}
@@ -5,8 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -18,7 +20,9 @@ import (
 	zip "gitlab.com/gitlab-org/golang-archive-zip"
 	"gitlab.com/gitlab-org/labkit/log"
 
+	"gitlab.com/gitlab-org/gitlab-pages/internal/cachedump"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httprange"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/pageserrors"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
 	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
@@ -30,8 +34,9 @@ const (
 
 var (
 	errNotSymlink  = errors.New("not a symlink")
-	errSymlinkSize = errors.New("symlink too long")
+	errSymlinkSize = pageserrors.New(pageserrors.ArchiveInvalid, "symlink too long")
 	errNotFile     = errors.New("not a file")
+	errNotDir      = errors.New("not a directory")
 )
 
 type archiveStatus int
@@ -52,16 +57,32 @@ type zipArchive struct {
 	once        sync.Once
 	done        chan struct{}
 	openTimeout time.Duration
+	readAhead   httprange.ReadAhead
 
 	cacheNamespace string
 
+	// fileCache is the cache of decompressed files when the archive was
+	// created, it can be nil
+	fileCache *fileCache
+
 	resource *httprange.Resource
 	reader   *httprange.RangedReader
 	archive  *zip.Reader
+	entries  int
 	err      error
 
 	files       map[string]*zip.File
 	directories map[string]*zip.FileHeader
+
+	// lowerNames maps the lowercase names of the entries to their names, it
+	// is built by the first CanonicalName call
+	lowerNamesOnce sync.Once
+	lowerNames     map[string]string
+
+	// children maps the names of the directories to the FileInfo of their
+	// entries, it is built by the first Readdir call
+	childrenOnce sync.Once
+	children     map[string][]os.FileInfo
 }
 
 func newArchive(fs *zipVFS, openTimeout time.Duration) *zipArchive {
@@ -71,6 +92,8 @@ func newArchive(fs *zipVFS, openTimeout time.Duration) *zipArchive {
 		files:          make(map[string]*zip.File),
 		directories:    make(map[string]*zip.FileHeader),
 		openTimeout:    openTimeout,
+		readAhead:      fs.readAhead,
+		fileCache:      fs.fileCache,
 		cacheNamespace: strconv.FormatInt(atomic.AddInt64(fs.archiveCount, 1), 10) + ":",
 	}
 }
@@ -121,6 +144,8 @@ func (a *zipArchive) readArchive(url string) {
 	ctx, cancel := context.WithTimeout(context.Background(), a.openTimeout)
 	defer cancel()
 
+	defer a.observeOpen(url, time.Now())
+
 	a.resource, a.err = httprange.NewResource(ctx, url, a.fs.httpClient)
 	if a.err != nil {
 		metrics.ZipOpened.WithLabelValues("error").Inc()
@@ -129,8 +154,10 @@ func (a *zipArchive) readArchive(url string) {
 
 	// load all archive files into memory using a cached ranged reader
 	a.reader = httprange.NewRangedReader(a.resource)
+	a.reader.ReadAhead = a.readAhead
 	a.reader.WithCachedReader(ctx, func() {
 		a.archive, a.err = zip.NewReader(a.reader, a.resource.Size)
+		a.err = pageserrors.Wrap(pageserrors.ArchiveInvalid, a.err)
 	})
 
 	if a.archive == nil || a.err != nil {
@@ -138,6 +165,8 @@ func (a *zipArchive) readArchive(url string) {
 		return
 	}
 
+	a.entries = len(a.archive.File)
+
 	// TODO: Improve preprocessing of zip archives https://gitlab.com/gitlab-org/gitlab-pages/-/issues/432
 	for _, file := range a.archive.File {
 		if !strings.HasPrefix(file.Name, dirPrefix) {
@@ -162,6 +191,59 @@ func (a *zipArchive) readArchive(url string) {
 	metrics.ZipArchiveEntriesCached.Add(fileCount)
 }
 
+// observeOpen records the metrics and logs the outcome of reading the archive
+// started at start. Only the host of the url is logged as the rest of it may
+// contain a signature.
+func (a *zipArchive) observeOpen(archiveURL string, start time.Time) {
+	duration := time.Since(start)
+
+	state := "ok"
+	if a.err != nil {
+		state = "error"
+	}
+
+	metrics.ZipOpenDuration.WithLabelValues(state).Observe(duration.Seconds())
+
+	var size int64
+	if a.resource != nil {
+		size = a.resource.Size
+		metrics.ZipOpenedArchiveSize.WithLabelValues(state).Observe(float64(size))
+	}
+
+	if a.archive != nil {
+		metrics.ZipOpenedArchiveEntries.WithLabelValues(state).Observe(float64(a.entries))
+	}
+
+	logger := log.WithFields(log.Fields{
+		"archive_host":  archiveHost(archiveURL),
+		"duration_ms":   duration.Milliseconds(),
+		"archive_size":  size,
+		"entries_count": a.entries,
+	})
+
+	if a.err != nil {
+		logger.WithError(a.err).Warn("failed to open zip archive")
+		return
+	}
+
+	logger.Debug("zip archive opened")
+}
+
+// archiveHost returns the host of archiveURL, or "file" for archives read
+// from disk
+func archiveHost(archiveURL string) string {
+	u, err := url.Parse(archiveURL)
+	if err != nil {
+		return ""
+	}
+
+	if u.Scheme == "file" {
+		return u.Scheme
+	}
+
+	return u.Host
+}
+
 // addPathDirectory adds a directory for a given path
 func (a *zipArchive) addPathDirectory(pathname string) {
 	// Split dir and file from `path`
@@ -179,14 +261,36 @@ func (a *zipArchive) addPathDirectory(pathname string) {
 	}
 }
 
-func (a *zipArchive) findFile(name string) *zip.File {
+// entryName returns the name of the archive entry for name, a path relative
+// to the public directory. Names leaving the public directory once cleaned, or
+// containing null bytes, have no entry.
+func entryName(name string) (string, bool) {
+	if strings.IndexByte(name, 0) >= 0 {
+		return "", false
+	}
+
 	name = path.Clean(dirPrefix + name)
+	if name != path.Clean(dirPrefix) && !strings.HasPrefix(name, dirPrefix) {
+		return "", false
+	}
+
+	return name, true
+}
+
+func (a *zipArchive) findFile(name string) *zip.File {
+	name, ok := entryName(name)
+	if !ok {
+		return nil
+	}
 
 	return a.files[name]
 }
 
 func (a *zipArchive) findDirectory(name string) *zip.FileHeader {
-	name = path.Clean(dirPrefix + name)
+	name, ok := entryName(name)
+	if !ok {
+		return nil
+	}
 
 	return a.directories[name+"/"]
 }
@@ -205,23 +309,45 @@ func (a *zipArchive) Open(ctx context.Context, name string) (vfs.File, error) {
 		return nil, errNotFile
 	}
 
+	if file.Method == zip.Deflate && a.fileCache.cacheable(file.UncompressedSize64) {
+		return a.fileCache.findOrRead(a.cacheNamespace, name, file.UncompressedSize64, file.CRC32, func() (vfs.File, error) {
+			return a.open(ctx, name, file)
+		})
+	}
+
+	return a.open(ctx, name, file)
+}
+
+// open returns a reader of the decompressed content of file
+func (a *zipArchive) open(ctx context.Context, name string, file *zip.File) (vfs.File, error) {
 	dataOffset, err := a.fs.dataOffsetCache.FindOrFetch(a.cacheNamespace, name, func() (interface{}, error) {
-		return file.DataOffset()
+		// the header is read without ctx, so the URL is refreshed here when
+		// it expired while the archive was cached
+		url := a.resource.URL()
+
+		offset, err := file.DataOffset()
+		if errors.Is(err, httprange.ErrAccessDenied) && a.resource.RefreshURL(ctx, url) == nil {
+			offset, err = file.DataOffset()
+		}
+
+		return offset, err
 	})
 	if err != nil {
 		return nil, err
 	}
 
 	// only read from dataOffset up to the size of the compressed file
-	reader := a.reader.SectionReader(ctx, dataOffset.(int64), int64(file.CompressedSize64))
+	section := func() io.ReadCloser {
+		return a.reader.SectionReader(ctx, dataOffset.(int64), int64(file.CompressedSize64))
+	}
 
 	switch file.Method {
 	case zip.Deflate:
-		return newDeflateReader(reader), nil
+		return newSeekableDeflateReader(section, int64(file.UncompressedSize64)), nil
 	case zip.Store:
-		return reader, nil
+		return section(), nil
 	default:
-		return nil, fmt.Errorf("unsupported compression method: %x", file.Method)
+		return nil, pageserrors.Wrap(pageserrors.ArchiveInvalid, fmt.Errorf("unsupported compression method: %x", file.Method))
 	}
 }
 
@@ -229,7 +355,7 @@ func (a *zipArchive) Open(ctx context.Context, name string) (vfs.File, error) {
 func (a *zipArchive) Lstat(ctx context.Context, name string) (os.FileInfo, error) {
 	file := a.findFile(name)
 	if file != nil {
-		return file.FileInfo(), nil
+		return &fileInfo{FileInfo: file.FileInfo(), crc32: file.CRC32}, nil
 	}
 
 	directory := a.findDirectory(name)
@@ -240,6 +366,95 @@ func (a *zipArchive) Lstat(ctx context.Context, name string) (os.FileInfo, error
 	return nil, os.ErrNotExist
 }
 
+// CanonicalName returns the name of the file or directory matching name
+// ignoring case, relative to the public directory. When several entries match,
+// the first one in lexical order is returned.
+func (a *zipArchive) CanonicalName(ctx context.Context, name string) (string, error) {
+	entry, ok := entryName(name)
+	if !ok {
+		return "", os.ErrNotExist
+	}
+
+	a.lowerNamesOnce.Do(a.indexLowerNames)
+
+	canonical, ok := a.lowerNames[strings.ToLower(entry)]
+	if !ok {
+		return "", os.ErrNotExist
+	}
+
+	return strings.TrimPrefix(canonical, dirPrefix), nil
+}
+
+// indexLowerNames builds the index of the lowercase names of the entries,
+// only once the archive is opened as the entries are not written after that
+func (a *zipArchive) indexLowerNames() {
+	a.lowerNames = make(map[string]string, len(a.files)+len(a.directories))
+
+	add := func(name string) {
+		lower := strings.ToLower(name)
+		if existing, ok := a.lowerNames[lower]; !ok || name < existing {
+			a.lowerNames[lower] = name
+		}
+	}
+
+	for name := range a.files {
+		add(name)
+	}
+
+	for name := range a.directories {
+		if name != dirPrefix {
+			add(strings.TrimSuffix(name, "/"))
+		}
+	}
+}
+
+// Readdir returns the FileInfo of the files and directories of the directory
+// name, sorted by name
+func (a *zipArchive) Readdir(ctx context.Context, name string) ([]os.FileInfo, error) {
+	if a.findDirectory(name) == nil {
+		if a.findFile(name) != nil {
+			return nil, errNotDir
+		}
+		return nil, os.ErrNotExist
+	}
+
+	a.childrenOnce.Do(a.indexChildren)
+
+	entry, _ := entryName(name)
+
+	return a.children[entry+"/"], nil
+}
+
+// indexChildren builds the index of the entries of the directories, only
+// once the archive is opened as the entries are not written after that
+func (a *zipArchive) indexChildren() {
+	a.children = make(map[string][]os.FileInfo, len(a.directories))
+
+	add := func(name string, fi os.FileInfo) {
+		parent, _ := path.Split(strings.TrimSuffix(name, "/"))
+		if parent == "" {
+			// the public directory itself
+			return
+		}
+
+		a.children[parent] = append(a.children[parent], fi)
+	}
+
+	for name, file := range a.files {
+		add(name, file.FileInfo())
+	}
+
+	for name, directory := range a.directories {
+		add(name, directory.FileInfo())
+	}
+
+	for _, infos := range a.children {
+		sort.Slice(infos, func(i, j int) bool {
+			return infos[i].Name() < infos[j].Name()
+		})
+	}
+}
+
 // ReadLink finds the file by name inside the zipArchive and returns the contents of the symlink
 func (a *zipArchive) Readlink(ctx context.Context, name string) (string, error) {
 	file := a.findFile(name)
@@ -291,6 +506,32 @@ func (a *zipArchive) onEvicted() {
 	metrics.ZipArchiveEntriesCached.Sub(float64(len(a.files)))
 }
 
+// dump returns the state of the archive for the cache dump
+func (a *zipArchive) dump() cachedump.Archive {
+	status, err := a.openStatus()
+
+	archive := cachedump.Archive{}
+	switch status {
+	case archiveOpening:
+		archive.Status = "opening"
+		return archive
+	case archiveOpenError:
+		archive.Status = "open_error"
+	case archiveOpened:
+		archive.Status = "opened"
+	case archiveCorrupted:
+		archive.Status = "corrupted"
+	}
+
+	// the entries are only written while opening
+	archive.Entries = len(a.files)
+	if err != nil {
+		archive.Error = err.Error()
+	}
+
+	return archive
+}
+
 func (a *zipArchive) openStatus() (archiveStatus, error) {
 	select {
 	case <-a.done:
@@ -308,3 +549,14 @@ func (a *zipArchive) openStatus() (archiveStatus, error) {
 		return archiveOpening, nil
 	}
 }
+
+// fileInfo is the vfs.ChecksumFileInfo of a file of the archive
+type fileInfo struct {
+	os.FileInfo
+	crc32 uint32
+}
+
+// CRC32 returns the CRC-32 checksum of the file stored in the archive
+func (fi *fileInfo) CRC32() uint32 {
+	return fi.crc32
+}
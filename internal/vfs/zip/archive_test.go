@@ -17,11 +17,15 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/require"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httprange"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/testhelpers"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
 var (
@@ -68,6 +72,14 @@ func testOpen(t *testing.T, zip *zipArchive) {
 			file:        "unknown.html",
 			expectedErr: os.ErrNotExist,
 		},
+		"file_outside_of_public": {
+			file:        "../../public/index.html",
+			expectedErr: os.ErrNotExist,
+		},
+		"file_with_null_byte": {
+			file:        "index.html\x00.png",
+			expectedErr: os.ErrNotExist,
+		},
 	}
 
 	for name, tt := range tests {
@@ -282,6 +294,74 @@ func testLstat(t *testing.T, zip *zipArchive) {
 	}
 }
 
+func TestCanonicalName(t *testing.T) {
+	zip, cleanup := openZipArchive(t, nil, false)
+	defer cleanup()
+
+	tests := map[string]struct {
+		name        string
+		expected    string
+		expectedErr error
+	}{
+		"same_case":         {name: "index.html", expected: "index.html"},
+		"file":              {name: "INDEX.html", expected: "index.html"},
+		"file_in_directory": {name: "SubDir/Hello.HTML", expected: "subdir/hello.html"},
+		"directory":         {name: "SUBDIR", expected: "subdir"},
+		"missing":           {name: "Missing.html", expectedErr: os.ErrNotExist},
+		"outside_public":    {name: "../PUBLIC/index.html", expectedErr: os.ErrNotExist},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			canonical, err := zip.CanonicalName(context.Background(), tt.name)
+			require.Equal(t, tt.expectedErr, err)
+			require.Equal(t, tt.expected, canonical)
+		})
+	}
+}
+
+func TestReaddir(t *testing.T) {
+	zip, cleanup := openZipArchive(t, nil, false)
+	defer cleanup()
+
+	tests := map[string]struct {
+		name          string
+		expectedNames []string
+		expectedDirs  []string
+		expectedErr   error
+	}{
+		"root": {
+			name:          "",
+			expectedNames: []string{"404.html", "bad_symlink.html", "index.html", "subdir", "symlink.html"},
+			expectedDirs:  []string{"subdir"},
+		},
+		"directory": {
+			name:          "subdir/",
+			expectedNames: []string{"2bp3Qzs9CCW7cGnxhghdavZ2bJDTzvu2mrj6O8Yqjm3YMRozRZULxBBKzJXCK16GlsvO1GlbCyONf2LTCndJU9cIr5T3PLDN7XnfG00lEmf9DWHPXiAbbi0v8ioSjnoTqdyjELVKuhsGRGxeV9RptLMyGnbpJx1w2uECiUQSHrRVQNuq2xoHLlk30UAmis1EhGXP5kKprzHxuavsKMdT4XRP0d79tie4tjqtfRsP4y60hmNS1vSujrxzhDa", "hello.html", "linked.html"},
+		},
+		"file":    {name: "index.html", expectedErr: errNotDir},
+		"missing": {name: "missing", expectedErr: os.ErrNotExist},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			infos, err := zip.Readdir(context.Background(), tt.name)
+			require.Equal(t, tt.expectedErr, err)
+
+			var names, dirs []string
+			for _, fi := range infos {
+				names = append(names, fi.Name())
+				if fi.IsDir() {
+					dirs = append(dirs, fi.Name())
+				}
+			}
+
+			require.Equal(t, tt.expectedNames, names)
+			require.Equal(t, tt.expectedDirs, dirs)
+		})
+	}
+}
+
 func TestReadLink(t *testing.T) {
 	t.Run("read_link_from_server", runZipTest(t, testReadLink, false))
 	t.Run("read_link_from_disk", runZipTest(t, testReadLink, true))
@@ -370,6 +450,74 @@ func TestArchiveCanBeReadAfterOpenCtxCanceled(t *testing.T) {
 	require.NoError(t, file.Close())
 }
 
+func TestOpenRefreshesDeniedDataOffset(t *testing.T) {
+	chdir := testhelpers.ChdirInPath(t, "../../../shared/pages", &chdirSet)
+	defer chdir()
+
+	// only the URL with the valid token is allowed, like pre-signed URLs
+	var validToken atomic.Value
+	validToken.Store("1")
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("token") != validToken.Load().(string) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		http.ServeFile(w, r, "group/zip.gitlab.io/public.zip")
+	}))
+	defer testServer.Close()
+
+	tests := map[string]struct {
+		refresh     vfs.PathRefresher
+		expectedURL string
+		expectedErr error
+	}{
+		"refreshed": {
+			refresh: func(context.Context) (string, error) {
+				return testServer.URL + "/public.zip?token=2", nil
+			},
+			expectedURL: testServer.URL + "/public.zip?token=2",
+		},
+		"no_refresher": {
+			expectedURL: testServer.URL + "/public.zip?token=1",
+			expectedErr: httprange.ErrAccessDenied,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			validToken.Store("1")
+
+			fs := New(&zipCfg).(*zipVFS)
+			zip := newArchive(fs, time.Second)
+			require.NoError(t, zip.openArchive(context.Background(), testServer.URL+"/public.zip?token=1"))
+
+			// the URL expires while the archive is cached
+			validToken.Store("2")
+
+			ctx := context.Background()
+			if tt.refresh != nil {
+				ctx = vfs.WithPathRefresher(ctx, tt.refresh)
+			}
+
+			file, err := zip.Open(ctx, "index.html")
+			require.Equal(t, tt.expectedURL, zip.resource.URL())
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			defer file.Close()
+
+			data, err := io.ReadAll(file)
+			require.NoError(t, err)
+			require.Equal(t, "zip.gitlab.io/project/index.html\n", string(data))
+		})
+	}
+}
+
 func TestReadArchiveFails(t *testing.T) {
 	testServerURL, cleanup := newZipFileServerURL(t, "group/zip.gitlab.io/public.zip", nil)
 	defer cleanup()
@@ -385,6 +533,91 @@ func TestReadArchiveFails(t *testing.T) {
 	require.EqualError(t, err, os.ErrNotExist.Error())
 }
 
+func TestReadArchiveMetrics(t *testing.T) {
+	testServerURL, cleanup := newZipFileServerURL(t, "group/zip.gitlab.io/public.zip", nil)
+	defer cleanup()
+
+	fs := New(&zipCfg).(*zipVFS)
+
+	okDurations := histogramCount(t, metrics.ZipOpenDuration.WithLabelValues("ok"))
+	okSizes := histogramCount(t, metrics.ZipOpenedArchiveSize.WithLabelValues("ok"))
+	okEntries := histogramCount(t, metrics.ZipOpenedArchiveEntries.WithLabelValues("ok"))
+	errorDurations := histogramCount(t, metrics.ZipOpenDuration.WithLabelValues("error"))
+
+	err := newArchive(fs, time.Second).openArchive(context.Background(), testServerURL+"/public.zip")
+	require.NoError(t, err)
+
+	err = newArchive(fs, time.Second).openArchive(context.Background(), testServerURL+"/unknown.zip")
+	require.Error(t, err)
+
+	require.Equal(t, okDurations+1, histogramCount(t, metrics.ZipOpenDuration.WithLabelValues("ok")))
+	require.Equal(t, okSizes+1, histogramCount(t, metrics.ZipOpenedArchiveSize.WithLabelValues("ok")))
+	require.Equal(t, okEntries+1, histogramCount(t, metrics.ZipOpenedArchiveEntries.WithLabelValues("ok")))
+	require.Equal(t, errorDurations+1, histogramCount(t, metrics.ZipOpenDuration.WithLabelValues("error")))
+}
+
+func TestArchiveHost(t *testing.T) {
+	tests := map[string]struct {
+		url      string
+		expected string
+	}{
+		"signed_url": {
+			url:      "https://bucket.s3.eu-west-1.amazonaws.com/public.zip?X-Amz-Signature=secret",
+			expected: "bucket.s3.eu-west-1.amazonaws.com",
+		},
+		"file": {
+			url:      "file:///pages/group/project/public.zip",
+			expected: "file",
+		},
+		"invalid_url": {
+			url:      "http://%zz",
+			expected: "",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tt.expected, archiveHost(tt.url))
+		})
+	}
+}
+
+func TestEntryName(t *testing.T) {
+	tests := map[string]struct {
+		name          string
+		expectedEntry string
+		expectedOK    bool
+	}{
+		"file":                      {name: "index.html", expectedEntry: "public/index.html", expectedOK: true},
+		"root":                      {name: "", expectedEntry: "public", expectedOK: true},
+		"cleaned":                   {name: "/subdir//../index.html", expectedEntry: "public/index.html", expectedOK: true},
+		"back_into_public":          {name: "../public/index.html", expectedEntry: "public/index.html", expectedOK: true},
+		"outside_of_public":         {name: "../index.html", expectedOK: false},
+		"sibling_with_prefix":       {name: "../public2/index.html", expectedOK: false},
+		"outside_of_archive":        {name: "../../etc/passwd", expectedOK: false},
+		"null_byte":                 {name: "index.html\x00", expectedOK: false},
+		"parent_of_public":          {name: "..", expectedOK: false},
+		"public_directory_trailing": {name: "./", expectedEntry: "public", expectedOK: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			entry, ok := entryName(tt.name)
+			require.Equal(t, tt.expectedOK, ok)
+			require.Equal(t, tt.expectedEntry, entry)
+		})
+	}
+}
+
+func histogramCount(t *testing.T, observer prometheus.Observer) uint64 {
+	t.Helper()
+
+	m := &dto.Metric{}
+	require.NoError(t, observer.(prometheus.Histogram).Write(m))
+
+	return m.GetHistogram().GetSampleCount()
+}
+
 func createArchive(t *testing.T, dir string) (map[string][]byte, int64) {
 	t.Helper()
 
@@ -6,10 +6,17 @@ import (
 	"errors"
 	"io"
 	"sync"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
 )
 
 var ErrClosedReader = errors.New("deflatereader: reader is closed")
 
+var (
+	errSeekInvalidWhence  = errors.New("deflatereader: invalid whence")
+	errSeekNegativeOffset = errors.New("deflatereader: negative offset")
+)
+
 var deflateReaderPool sync.Pool
 
 // deflateReader wrapper to support reading compressed files.
@@ -18,15 +25,59 @@ type deflateReader struct {
 	reader      *bufio.Reader
 	closer      io.Closer
 	flateReader io.ReadCloser
+
+	// remaining is the number of bytes left of the uncompressed size of the
+	// file, the content is served with it as Content-Length so it must not
+	// be shorter or longer
+	remaining int64
 }
 
-// Read from flateReader
+// Read from flateReader, up to the uncompressed size of the file. It returns
+// vfs.ErrSizeMismatch when the decompressed content is shorter or longer.
 func (r *deflateReader) Read(p []byte) (n int, err error) {
 	if r.closer == nil {
 		return 0, ErrClosedReader
 	}
 
-	return r.flateReader.Read(p)
+	if r.remaining == 0 {
+		return 0, r.checkEOF()
+	}
+
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+
+	n, err = r.flateReader.Read(p)
+	r.remaining -= int64(n)
+
+	switch {
+	case err == io.EOF && r.remaining > 0:
+		err = vfs.ErrSizeMismatch
+	case err == nil && r.remaining == 0:
+		// hold back the last byte of longer content, so the response is
+		// shorter than its Content-Length and the client discards it
+		if err = r.checkEOF(); err == vfs.ErrSizeMismatch {
+			n--
+		}
+	}
+
+	return n, err
+}
+
+// checkEOF returns io.EOF when the content ends at the uncompressed size
+func (r *deflateReader) checkEOF() error {
+	var b [1]byte
+
+	for {
+		n, err := r.flateReader.Read(b[:])
+		if n > 0 {
+			return vfs.ErrSizeMismatch
+		}
+
+		if err != nil {
+			return err
+		}
+	}
 }
 
 // Close all readers
@@ -44,15 +95,18 @@ func (r *deflateReader) Close() error {
 	return r.flateReader.Close()
 }
 
-func (r *deflateReader) reset(rc io.ReadCloser) {
+func (r *deflateReader) reset(rc io.ReadCloser, size int64) {
 	r.reader.Reset(rc)
 	r.closer = rc
+	r.remaining = size
 	r.flateReader.(flate.Resetter).Reset(r.reader, nil)
 }
 
-func newDeflateReader(r io.ReadCloser) *deflateReader {
+// newDeflateReader returns a reader of the content of r decompressed, which
+// must be size bytes long
+func newDeflateReader(r io.ReadCloser, size int64) *deflateReader {
 	if dr, ok := deflateReaderPool.Get().(*deflateReader); ok {
-		dr.reset(r)
+		dr.reset(r, size)
 		return dr
 	}
 
@@ -62,5 +116,109 @@ func newDeflateReader(r io.ReadCloser) *deflateReader {
 		reader:      br,
 		closer:      r,
 		flateReader: flate.NewReader(br),
+		remaining:   size,
 	}
 }
+
+// seekableDeflateReader reads a compressed file from any offset, so ranges of
+// it can be served. The content is decompressed from the start of the file
+// until the offset, and again from the start when it is seeked backwards, so
+// only single ranges of it are served, see vfs.SingleRangeFile.
+// Seeks only change the offset of the next read, so the size of the file can
+// be found without decompressing it.
+type seekableDeflateReader struct {
+	open func() io.ReadCloser
+	size int64
+
+	offset       int64
+	reader       *deflateReader
+	readerOffset int64
+	closed       bool
+}
+
+var _ vfs.SingleRangeFile = &seekableDeflateReader{}
+
+// newSeekableDeflateReader returns a seekable reader of the content opened
+// by open decompressed, which must be size bytes long
+func newSeekableDeflateReader(open func() io.ReadCloser, size int64) *seekableDeflateReader {
+	return &seekableDeflateReader{open: open, size: size}
+}
+
+// Read decompresses the content from the offset, see deflateReader.Read
+func (r *seekableDeflateReader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, ErrClosedReader
+	}
+
+	if r.offset > r.size {
+		return 0, io.EOF
+	}
+
+	if r.reader == nil || r.readerOffset > r.offset {
+		r.closeReader()
+		r.reader = newDeflateReader(r.open(), r.size)
+		r.readerOffset = 0
+	}
+
+	if r.readerOffset < r.offset {
+		skipped, err := io.CopyN(io.Discard, r.reader, r.offset-r.readerOffset)
+		r.readerOffset += skipped
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.reader.Read(p)
+	r.offset += int64(n)
+	r.readerOffset += int64(n)
+
+	return n, err
+}
+
+// Seek sets the offset of the next read
+func (r *seekableDeflateReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errSeekInvalidWhence
+	}
+
+	if offset < 0 {
+		return 0, errSeekNegativeOffset
+	}
+
+	r.offset = offset
+
+	return offset, nil
+}
+
+// SingleRange marks the reader as a vfs.SingleRangeFile
+func (r *seekableDeflateReader) SingleRange() {}
+
+// Close the reader of the content
+func (r *seekableDeflateReader) Close() error {
+	if r.closed {
+		return ErrClosedReader
+	}
+
+	r.closed = true
+
+	return r.closeReader()
+}
+
+// closeReader closes the current reader, which is returned to the pool so it
+// must not be used anymore
+func (r *seekableDeflateReader) closeReader() error {
+	if r.reader == nil {
+		return nil
+	}
+
+	err := r.reader.Close()
+	r.reader = nil
+
+	return err
+}
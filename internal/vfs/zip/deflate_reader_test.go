@@ -0,0 +1,126 @@
+package zip
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+)
+
+func TestDeflateReaderSize(t *testing.T) {
+	content := "zip.gitlab.io/project/subdir/linked.html\n"
+
+	var compressed bytes.Buffer
+	fw, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+	require.NoError(t, err)
+	_, err = fw.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, fw.Close())
+
+	tests := map[string]struct {
+		size            int64
+		expectedContent string
+		expectedErr     error
+	}{
+		"matching_size": {
+			size:            int64(len(content)),
+			expectedContent: content,
+		},
+		"shorter_content": {
+			size:            int64(len(content)) + 10,
+			expectedContent: content,
+			expectedErr:     vfs.ErrSizeMismatch,
+		},
+		"longer_content": {
+			size:            10,
+			expectedContent: content[:9],
+			expectedErr:     vfs.ErrSizeMismatch,
+		},
+		"empty_content": {
+			size:        0,
+			expectedErr: vfs.ErrSizeMismatch,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := newDeflateReader(io.NopCloser(bytes.NewReader(compressed.Bytes())), test.size)
+			defer r.Close()
+
+			// reads of one byte check the size at every byte of the content
+			var got bytes.Buffer
+			_, err := io.CopyBuffer(&got, struct{ io.Reader }{r}, make([]byte, 1))
+			require.Equal(t, test.expectedErr, err)
+			require.Equal(t, test.expectedContent, got.String())
+		})
+	}
+}
+
+func TestSeekableDeflateReader(t *testing.T) {
+	content := strings.Repeat("zip.gitlab.io/project/subdir/linked.html\n", 100)
+
+	var compressed bytes.Buffer
+	fw, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+	require.NoError(t, err)
+	_, err = fw.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, fw.Close())
+
+	opened := 0
+	r := newSeekableDeflateReader(func() io.ReadCloser {
+		opened++
+		return io.NopCloser(bytes.NewReader(compressed.Bytes()))
+	}, int64(len(content)))
+
+	readAt := func(offset int64, n int) string {
+		t.Helper()
+
+		_, err := r.Seek(offset, io.SeekStart)
+		require.NoError(t, err)
+
+		buf := make([]byte, n)
+		_, err = io.ReadFull(r, buf)
+		require.NoError(t, err)
+
+		return string(buf)
+	}
+
+	size, err := r.Seek(0, io.SeekEnd)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(content)), size)
+	require.Zero(t, opened, "the size is known without decompressing the content")
+
+	require.Equal(t, content[100:150], readAt(100, 50))
+	require.Equal(t, content[2000:2100], readAt(2000, 100), "seeking forwards skips the content")
+	require.Equal(t, 1, opened)
+
+	require.Equal(t, content[10:20], readAt(10, 10), "seeking backwards decompresses the content again")
+	require.Equal(t, 2, opened)
+
+	offset, err := r.Seek(-10, io.SeekCurrent)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), offset)
+
+	_, err = r.Seek(-1, io.SeekStart)
+	require.Equal(t, errSeekNegativeOffset, err)
+
+	_, err = r.Seek(int64(len(content))+1, io.SeekStart)
+	require.NoError(t, err)
+	_, err = r.Read(make([]byte, 1))
+	require.Equal(t, io.EOF, err)
+
+	_, err = r.Seek(int64(len(content))-5, io.SeekStart)
+	require.NoError(t, err)
+	rest, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, content[len(content)-5:], string(rest))
+
+	require.NoError(t, r.Close())
+	_, err = r.Read(make([]byte, 1))
+	require.Equal(t, ErrClosedReader, err)
+}
@@ -0,0 +1,142 @@
+package zip
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/karlseguin/ccache/v2"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+// defaultFileCacheExpirationInterval is the time a decompressed file is kept
+// in memory, the entries of the archives which were evicted expire then
+const defaultFileCacheExpirationInterval = time.Hour
+
+// fileCacheItemsToPruneDiv prunes 1/16 of the files the cache can hold, at
+// least, when it is full
+const fileCacheItemsToPruneDiv = 16
+
+// fileCache keeps the decompressed content of small deflated files in memory,
+// so the files requested often are served without reading and inflating them
+// again. The content is keyed by the archive, the name and the CRC-32 of the
+// files, and the size of the cache is the size of the content it holds.
+type fileCache struct {
+	// maxFileSize is read atomically, 0 disables the cache
+	maxFileSize uint64
+	cache       *ccache.Cache
+}
+
+// cachedContent is the decompressed content of a file, its size is used by
+// the cache to respect its maximum size
+type cachedContent []byte
+
+func (c cachedContent) Size() int64 {
+	return int64(len(c))
+}
+
+// newFileCache returns a cache holding at most maxSize bytes of files of at
+// most maxFileSize bytes, it returns nil when either is 0
+func newFileCache(maxSize, maxFileSize int64) *fileCache {
+	if maxSize <= 0 || maxFileSize <= 0 {
+		return nil
+	}
+
+	configuration := ccache.Configure()
+	configuration.MaxSize(maxSize)
+	configuration.ItemsToPrune(uint32(maxSize/maxFileSize/fileCacheItemsToPruneDiv) + 1)
+	configuration.OnDelete(func(item *ccache.Item) {
+		metrics.ZipCachedEntries.WithLabelValues("file").Dec()
+		metrics.ZipFileCacheBytes.Sub(float64(item.Value().(cachedContent).Size()))
+	})
+
+	return &fileCache{
+		maxFileSize: uint64(maxFileSize),
+		cache:       ccache.New(configuration),
+	}
+}
+
+// reconfigure updates the limits of c in place, so the archives opened before
+// share it and no worker of a replaced cache is left running. Disabling it
+// drops the files it holds.
+func (c *fileCache) reconfigure(maxSize, maxFileSize int64) {
+	if maxSize <= 0 || maxFileSize <= 0 {
+		atomic.StoreUint64(&c.maxFileSize, 0)
+		c.cache.DeletePrefix("")
+		return
+	}
+
+	atomic.StoreUint64(&c.maxFileSize, uint64(maxFileSize))
+	c.cache.SetMaxSize(maxSize)
+}
+
+// cacheable returns true when the cache can hold the file with the given
+// uncompressed size
+func (c *fileCache) cacheable(size uint64) bool {
+	if c == nil {
+		return false
+	}
+
+	maxFileSize := atomic.LoadUint64(&c.maxFileSize)
+
+	return maxFileSize > 0 && size <= maxFileSize
+}
+
+// findOrRead returns the content of the file cached under the archive
+// namespace and name, or reads it from open and caches it when its size and
+// CRC-32 are the expected ones
+func (c *fileCache) findOrRead(namespace, name string, size uint64, crc uint32, open func() (vfs.File, error)) (vfs.File, error) {
+	key := namespace + name + ":" + strconv.FormatUint(uint64(crc), 16)
+
+	if item := c.cache.Get(key); item != nil && !item.Expired() {
+		metrics.ZipCacheRequests.WithLabelValues("file", "hit").Inc()
+		return newCachedFile(item.Value().(cachedContent)), nil
+	}
+
+	rc, err := open()
+	if err != nil {
+		metrics.ZipCacheRequests.WithLabelValues("file", "error").Inc()
+		return nil, err
+	}
+	defer rc.Close()
+
+	content, err := io.ReadAll(io.LimitReader(rc, int64(size)+1))
+	if err != nil {
+		metrics.ZipCacheRequests.WithLabelValues("file", "error").Inc()
+		return nil, err
+	}
+
+	metrics.ZipCacheRequests.WithLabelValues("file", "miss").Inc()
+
+	// corrupted entries are not cached, they are read from the archive again
+	// and served like when the cache is disabled
+	if uint64(len(content)) != size || crc32.ChecksumIEEE(content) != crc {
+		return open()
+	}
+
+	c.cache.Set(key, cachedContent(content), defaultFileCacheExpirationInterval)
+	metrics.ZipCachedEntries.WithLabelValues("file").Inc()
+	metrics.ZipFileCacheBytes.Add(float64(len(content)))
+
+	return newCachedFile(content), nil
+}
+
+// cachedFile serves the content of a cached file, it can be seeked so ranges
+// of it can be served as well
+type cachedFile struct {
+	*bytes.Reader
+}
+
+func newCachedFile(content []byte) *cachedFile {
+	return &cachedFile{Reader: bytes.NewReader(content)}
+}
+
+// Close does nothing, the content stays in the cache
+func (f *cachedFile) Close() error {
+	return nil
+}
@@ -0,0 +1,144 @@
+package zip
+
+import (
+	"context"
+	"hash/crc32"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+func TestOpenFileCache(t *testing.T) {
+	var requests int64
+	testServerURL, cleanup := newZipFileServerURL(t, "group/zip.gitlab.io/public-without-dirs.zip", &requests)
+	defer cleanup()
+
+	cfg := zipCfg
+	cfg.FileCacheSize = 1024
+	cfg.FileCacheMaxFileSize = 64
+
+	fs := New(&cfg).(*zipVFS)
+	zip := newArchive(fs, time.Second)
+	err := zip.openArchive(context.Background(), testServerURL+"/public.zip")
+	require.NoError(t, err)
+
+	hits := testutil.ToFloat64(metrics.ZipCacheRequests.WithLabelValues("file", "hit"))
+	misses := testutil.ToFloat64(metrics.ZipCacheRequests.WithLabelValues("file", "miss"))
+
+	read := func(t *testing.T) string {
+		t.Helper()
+
+		f, err := zip.Open(context.Background(), "subdir/linked.html")
+		require.NoError(t, err)
+		defer f.Close()
+
+		_, ok := f.(vfs.SeekableFile)
+		require.True(t, ok, "cached files can be seeked")
+
+		content, err := io.ReadAll(f)
+		require.NoError(t, err)
+
+		return string(content)
+	}
+
+	content := read(t)
+	require.Equal(t, misses+1, testutil.ToFloat64(metrics.ZipCacheRequests.WithLabelValues("file", "miss")))
+
+	start := atomic.LoadInt64(&requests)
+	require.Equal(t, content, read(t))
+	require.Equal(t, int64(0), atomic.LoadInt64(&requests)-start, "we expect no requests to read a cached file")
+	require.Equal(t, hits+1, testutil.ToFloat64(metrics.ZipCacheRequests.WithLabelValues("file", "hit")))
+}
+
+func TestOpenFileCacheMaxFileSize(t *testing.T) {
+	var requests int64
+	testServerURL, cleanup := newZipFileServerURL(t, "group/zip.gitlab.io/public-without-dirs.zip", &requests)
+	defer cleanup()
+
+	cfg := zipCfg
+	cfg.FileCacheSize = 1024
+	cfg.FileCacheMaxFileSize = 16
+
+	fs := New(&cfg).(*zipVFS)
+	zip := newArchive(fs, time.Second)
+	err := zip.openArchive(context.Background(), testServerURL+"/public.zip")
+	require.NoError(t, err)
+
+	misses := testutil.ToFloat64(metrics.ZipCacheRequests.WithLabelValues("file", "miss"))
+
+	for i := 0; i < 2; i++ {
+		f, err := zip.Open(context.Background(), "subdir/linked.html")
+		require.NoError(t, err)
+
+		_, ok := f.(*cachedFile)
+		require.False(t, ok, "files larger than the maximum file size are not cached")
+		require.NoError(t, f.Close())
+	}
+
+	require.Equal(t, misses, testutil.ToFloat64(metrics.ZipCacheRequests.WithLabelValues("file", "miss")))
+}
+
+func TestNewFileCacheDisabled(t *testing.T) {
+	require.Nil(t, newFileCache(0, 64))
+	require.Nil(t, newFileCache(1024, 0))
+	require.False(t, newFileCache(0, 0).cacheable(1))
+}
+
+func TestFileCacheCorruptedEntry(t *testing.T) {
+	c := newFileCache(1024, 64)
+
+	var opened int
+	open := func() (vfs.File, error) {
+		opened++
+		return io.NopCloser(strings.NewReader("corrupted")), nil
+	}
+
+	for i := 1; i <= 2; i++ {
+		f, err := c.findOrRead("archive:", "index.html", 9, 0, open)
+		require.NoError(t, err)
+
+		_, ok := f.(*cachedFile)
+		require.False(t, ok, "corrupted entries are not cached")
+
+		content, err := io.ReadAll(f)
+		require.NoError(t, err)
+		require.Equal(t, "corrupted", string(content), "the entry is served from the archive")
+		require.Equal(t, i*2, opened, "the entry is read again to be served")
+	}
+}
+
+func TestFileCacheReconfigure(t *testing.T) {
+	c := newFileCache(1024, 64)
+	content := "cached content"
+
+	open := func() (vfs.File, error) {
+		return io.NopCloser(strings.NewReader(content)), nil
+	}
+
+	before := testutil.ToFloat64(metrics.ZipFileCacheBytes)
+
+	_, err := c.findOrRead("archive:", "index.html", uint64(len(content)), crc32.ChecksumIEEE([]byte(content)), open)
+	require.NoError(t, err)
+	require.Equal(t, before+float64(len(content)), testutil.ToFloat64(metrics.ZipFileCacheBytes))
+
+	c.reconfigure(1024, 8)
+	require.False(t, c.cacheable(uint64(len(content))))
+	require.True(t, c.cacheable(8))
+
+	c.reconfigure(0, 8)
+	require.False(t, c.cacheable(1), "the cache is disabled")
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.ZipFileCacheBytes) == before
+	}, time.Second, 10*time.Millisecond, "the files of a disabled cache are dropped")
+
+	c.reconfigure(1024, 64)
+	require.True(t, c.cacheable(uint64(len(content))), "the cache is enabled again")
+}
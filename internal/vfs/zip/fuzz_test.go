@@ -0,0 +1,34 @@
+//go:build go1.18
+// +build go1.18
+
+package zip
+
+import (
+	"path"
+	"strings"
+	"testing"
+)
+
+// FuzzEntryName checks that the names looked up in archives never leave the
+// public directory, the seed corpus is in testdata/fuzz/FuzzEntryName
+func FuzzEntryName(f *testing.F) {
+	f.Add("index.html")
+	f.Add("")
+	f.Add("subdir/../index.html")
+	f.Add("../../etc/passwd")
+
+	f.Fuzz(func(t *testing.T, name string) {
+		entry, ok := entryName(name)
+		if !ok {
+			return
+		}
+
+		if entry != "public" && !strings.HasPrefix(entry, dirPrefix) {
+			t.Fatalf("entryName(%q) = %q is outside of the public directory", name, entry)
+		}
+
+		if path.Clean(entry) != entry || strings.Contains(entry, "\x00") {
+			t.Fatalf("entryName(%q) = %q is not clean", name, entry)
+		}
+	})
+}
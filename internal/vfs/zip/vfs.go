@@ -2,15 +2,23 @@ package zip
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"io/fs"
 	"net/http"
+	"net/url"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/patrickmn/go-cache"
+	"gitlab.com/gitlab-org/labkit/log"
 
+	"gitlab.com/gitlab-org/gitlab-pages/internal/cachedump"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/debugtrace"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/egress"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httpfs"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httprange"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httptransport"
@@ -49,9 +57,12 @@ type zipVFS struct {
 	cacheExpirationInterval time.Duration
 	cacheRefreshInterval    time.Duration
 	cacheCleanupInterval    time.Duration
+	readAhead               httprange.ReadAhead
 
 	dataOffsetCache lruCache
 	readlinkCache   lruCache
+	// fileCache keeps small decompressed files, it is nil when disabled
+	fileCache *fileCache
 
 	// the `int64` needs to be 64bit aligned on some 32bit systems
 	// https://gitlab.com/gitlab-org/gitlab/-/issues/337261
@@ -66,20 +77,15 @@ func New(cfg *config.ZipServing) vfs.VFS {
 		cacheRefreshInterval:    cfg.RefreshInterval,
 		cacheCleanupInterval:    cfg.CleanupInterval,
 		openTimeout:             cfg.OpenTimeout,
+		readAhead:               readAheadFromConfig(cfg),
 		httpClient: &http.Client{
 			// TODO: make this timeout configurable
 			// https://gitlab.com/gitlab-org/gitlab-pages/-/issues/457
-			Timeout: 30 * time.Minute,
-			Transport: httptransport.NewMeteredRoundTripper(
-				httptransport.NewTransport(),
-				"zip_vfs",
-				metrics.HTTPRangeTraceDuration,
-				metrics.HTTPRangeRequestDuration,
-				metrics.HTTPRangeRequestsTotal,
-				httptransport.DefaultTTFBTimeout,
-			),
+			Timeout:   30 * time.Minute,
+			Transport: newTransport(&egress.Policy{}, nil, http.ProxyFromEnvironment),
 		},
 		archiveCount: new(int64),
+		fileCache:    newFileCache(cfg.FileCacheSize, cfg.FileCacheMaxFileSize),
 	}
 
 	zipVFS.resetCache()
@@ -113,6 +119,12 @@ func (zfs *zipVFS) Reconfigure(cfg *config.Config) error {
 	zfs.cacheExpirationInterval = cfg.Zip.ExpirationInterval
 	zfs.cacheRefreshInterval = cfg.Zip.RefreshInterval
 	zfs.cacheCleanupInterval = cfg.Zip.CleanupInterval
+	zfs.readAhead = readAheadFromConfig(&cfg.Zip)
+	if zfs.fileCache == nil {
+		zfs.fileCache = newFileCache(cfg.Zip.FileCacheSize, cfg.Zip.FileCacheMaxFileSize)
+	} else {
+		zfs.fileCache.reconfigure(cfg.Zip.FileCacheSize, cfg.Zip.FileCacheMaxFileSize)
+	}
 
 	if err := zfs.reconfigureTransport(cfg); err != nil {
 		return err
@@ -123,18 +135,68 @@ func (zfs *zipVFS) Reconfigure(cfg *config.Config) error {
 	return nil
 }
 
+func readAheadFromConfig(cfg *config.ZipServing) httprange.ReadAhead {
+	return httprange.ReadAhead{
+		ChunkSize:   cfg.ReadAheadChunkSize,
+		MaxPrefetch: cfg.ReadAheadMaxPrefetch,
+		MinSize:     cfg.ReadAheadMinSize,
+		Limiter:     httprange.NewFetchLimiter(cfg.ReadAheadConcurrency),
+	}
+}
+
 func (zfs *zipVFS) reconfigureTransport(cfg *config.Config) error {
 	fsTransport, err := httpfs.NewFileSystemPath(cfg.Zip.AllowedPaths)
 	if err != nil {
 		return err
 	}
 
-	zfs.httpClient.Transport.(httptransport.Transport).
+	egressPolicy, err := egress.NewPolicy(cfg.General.EgressAllowlist)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig, err := httptransport.NewTLSConfig(cfg.Zip.CACertificates, cfg.Zip.InsecureSkipVerify)
+	if err != nil {
+		return fmt.Errorf("object storage CA certificates: %w", err)
+	}
+
+	if cfg.Zip.InsecureSkipVerify {
+		log.WithField("object-storage-insecure-skip-verify", true).Warn("the certificates of object storage are not verified, this must not be used in production")
+	}
+
+	proxy, err := httptransport.NewProxyFunc(cfg.Zip.ProxyURL)
+	if err != nil {
+		return fmt.Errorf("object storage proxy: %w", err)
+	}
+
+	transport := newTransport(egressPolicy, tlsConfig, proxy)
+	transport.(httptransport.Transport).
 		RegisterProtocol("file", http.NewFileTransport(fsTransport))
 
+	zfs.httpClient.Transport = transport
+
 	return nil
 }
 
+// newTransport returns the transport used to fetch archives from object
+// storage, which only connects to the hosts allowed by egressPolicy, verifies
+// their certificates with tlsConfig and sends the requests through the
+// forward proxy selected by proxy. The URLs of the proxied requests are
+// checked against egressPolicy too.
+func newTransport(egressPolicy *egress.Policy, tlsConfig *tls.Config, proxy func(*http.Request) (*url.URL, error)) http.RoundTripper {
+	transport := httptransport.NewTransportWithDialContext(egressPolicy.DialContext(nil), tlsConfig)
+	transport.Proxy = egressPolicy.Proxy(httptransport.InstrumentProxy(proxy, metrics.ObjectStorageProxyRequests))
+
+	return httptransport.NewMeteredRoundTripper(
+		transport,
+		"zip_vfs",
+		metrics.HTTPRangeTraceDuration,
+		metrics.HTTPRangeRequestDuration,
+		metrics.HTTPRangeRequestsTotal,
+		httptransport.DefaultTTFBTimeout,
+	)
+}
+
 func (zfs *zipVFS) resetCache() {
 	zfs.cache = cache.New(zfs.cacheExpirationInterval, zfs.cacheCleanupInterval)
 	zfs.cache.OnEvicted(func(s string, i interface{}) {
@@ -144,6 +206,28 @@ func (zfs *zipVFS) resetCache() {
 	})
 }
 
+// DumpArchives returns the archives in the cache
+func (zfs *zipVFS) DumpArchives() []cachedump.Archive {
+	zfs.cacheLock.Lock()
+	items := zfs.cache.Items()
+	zfs.cacheLock.Unlock()
+
+	archives := make([]cachedump.Archive, 0, len(items))
+	for key, item := range items {
+		archive := item.Object.(*zipArchive).dump()
+		archive.Key = key
+		archive.ExpiresAt = time.Unix(0, item.Expiration)
+
+		archives = append(archives, archive)
+	}
+
+	sort.Slice(archives, func(i, j int) bool {
+		return archives[i].Key < archives[j].Key
+	})
+
+	return archives
+}
+
 // Root opens an archive given a URL path and returns an instance of zipArchive
 // that implements the vfs.VFS interface.
 // To avoid using locks, the findOrOpenArchive function runs inside of a for
@@ -180,7 +264,7 @@ func (zfs *zipVFS) Name() string {
 // otherwise creates the archive entry in a cache and try to save it,
 // if saving fails it's because the archive has already been cached
 // (e.g. by another concurrent request)
-func (zfs *zipVFS) findOrCreateArchive(key string) (*zipArchive, error) {
+func (zfs *zipVFS) findOrCreateArchive(ctx context.Context, key string) (*zipArchive, error) {
 	// This needs to happen in lock to ensure that
 	// concurrent access will not remove it
 	// it is needed due to the bug https://github.com/patrickmn/go-cache/issues/48
@@ -192,25 +276,25 @@ func (zfs *zipVFS) findOrCreateArchive(key string) (*zipArchive, error) {
 		status, _ := archive.(*zipArchive).openStatus()
 		switch status {
 		case archiveOpening:
-			metrics.ZipCacheRequests.WithLabelValues("archive", "hit-opening").Inc()
+			observeArchiveCache(ctx, "hit-opening")
 
 		case archiveOpenError:
 			// this means that archive is likely corrupted
 			// we keep it for duration of cache entry expiry (negative cache)
-			metrics.ZipCacheRequests.WithLabelValues("archive", "hit-open-error").Inc()
+			observeArchiveCache(ctx, "hit-open-error")
 
 		case archiveOpened:
 			if time.Until(expiry) < zfs.cacheRefreshInterval {
 				zfs.cache.SetDefault(key, archive)
-				metrics.ZipCacheRequests.WithLabelValues("archive", "hit-refresh").Inc()
+				observeArchiveCache(ctx, "hit-refresh")
 			} else {
-				metrics.ZipCacheRequests.WithLabelValues("archive", "hit").Inc()
+				observeArchiveCache(ctx, "hit")
 			}
 
 		case archiveCorrupted:
 			// this means that archive is likely changed
 			// we should invalidate it immediately
-			metrics.ZipCacheRequests.WithLabelValues("archive", "corrupted").Inc()
+			observeArchiveCache(ctx, "corrupted")
 			archive = nil
 		}
 	}
@@ -230,16 +314,23 @@ func (zfs *zipVFS) findOrCreateArchive(key string) (*zipArchive, error) {
 			return nil, errAlreadyCached
 		}
 
-		metrics.ZipCacheRequests.WithLabelValues("archive", "miss").Inc()
+		observeArchiveCache(ctx, "miss")
 		metrics.ZipCachedEntries.WithLabelValues("archive").Inc()
 	}
 
 	return archive.(*zipArchive), nil
 }
 
+// observeArchiveCache counts the result of an archive cache lookup and
+// records it in the debug trace of the request
+func observeArchiveCache(ctx context.Context, result string) {
+	metrics.ZipCacheRequests.WithLabelValues("archive", result).Inc()
+	debugtrace.FromContext(ctx).Add("archive_cache", result)
+}
+
 // findOrOpenArchive gets archive from cache and tries to open it
 func (zfs *zipVFS) findOrOpenArchive(ctx context.Context, key, path string) (*zipArchive, error) {
-	zipArchive, err := zfs.findOrCreateArchive(key)
+	zipArchive, err := zfs.findOrCreateArchive(ctx, key)
 	if err != nil {
 		return nil, err
 	}
@@ -2,12 +2,16 @@ package zip
 
 import (
 	"context"
+	"encoding/pem"
 	"io"
 	"io/fs"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/egress"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/testhelpers"
 
 	"github.com/prometheus/client_golang/prometheus/testutil"
@@ -73,6 +77,32 @@ func TestVFSRoot(t *testing.T) {
 	}
 }
 
+func TestVFSDumpArchives(t *testing.T) {
+	url, cleanup := newZipFileServerURL(t, "group/zip.gitlab.io/public.zip", nil)
+	defer cleanup()
+
+	vfs := New(&zipCfg).(*zipVFS)
+	require.Empty(t, vfs.DumpArchives())
+
+	_, err := vfs.Root(context.Background(), url+"/public.zip", "opened-key")
+	require.NoError(t, err)
+
+	_, err = vfs.Root(context.Background(), url+"/unknown", "missing-key")
+	require.Error(t, err)
+
+	archives := vfs.DumpArchives()
+	require.Len(t, archives, 2)
+
+	require.Equal(t, "missing-key", archives[0].Key)
+	require.Equal(t, "open_error", archives[0].Status)
+	require.NotEmpty(t, archives[0].Error)
+
+	require.Equal(t, "opened-key", archives[1].Key)
+	require.Equal(t, "opened", archives[1].Status)
+	require.Positive(t, archives[1].Entries)
+	require.WithinDuration(t, time.Now().Add(zipCfg.ExpirationInterval), archives[1].ExpiresAt, time.Second)
+}
+
 func TestVFSFindOrOpenArchiveConcurrentAccess(t *testing.T) {
 	testServerURL, cleanup := newZipFileServerURL(t, "group/zip.gitlab.io/public.zip", nil)
 	defer cleanup()
@@ -254,6 +284,109 @@ func TestVFSReconfigureTransport(t *testing.T) {
 	require.Equal(t, "index.html", fi.Name())
 }
 
+func TestVFSReconfigureEgressAllowlist(t *testing.T) {
+	url, cleanup := newZipFileServerURL(t, "group/zip.gitlab.io/public.zip", nil)
+	defer cleanup()
+
+	vfs := New(&zipCfg)
+
+	err := vfs.Reconfigure(&config.Config{
+		General: config.General{EgressAllowlist: []string{"storage.example.com"}},
+		Zip:     zipCfg,
+	})
+	require.NoError(t, err)
+
+	_, err = vfs.Root(context.Background(), url+"/public.zip", "egress")
+	require.ErrorIs(t, err, egress.ErrHostNotAllowed)
+
+	err = vfs.Reconfigure(&config.Config{
+		General: config.General{EgressAllowlist: []string{"127.0.0.1/32"}},
+		Zip:     zipCfg,
+	})
+	require.NoError(t, err)
+
+	_, err = vfs.Root(context.Background(), url+"/public.zip", "egress")
+	require.NoError(t, err)
+}
+
+func TestVFSReconfigureCACertificates(t *testing.T) {
+	chdir := testhelpers.ChdirInPath(t, "../../../shared/pages", &chdirSet)
+	defer chdir()
+
+	testServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "group/zip.gitlab.io/public.zip")
+	}))
+	defer testServer.Close()
+
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: testServer.Certificate().Raw})
+
+	tests := map[string]struct {
+		zipCfg      config.ZipServing
+		expectedErr string
+	}{
+		"untrusted_certificate": {
+			expectedErr: "certificate signed by unknown authority",
+		},
+		"ca_certificates": {
+			zipCfg: config.ZipServing{CACertificates: caCert},
+		},
+		"insecure_skip_verify": {
+			zipCfg: config.ZipServing{InsecureSkipVerify: true},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := zipCfg
+			cfg.CACertificates = tt.zipCfg.CACertificates
+			cfg.InsecureSkipVerify = tt.zipCfg.InsecureSkipVerify
+
+			vfs := New(&cfg)
+			require.NoError(t, vfs.Reconfigure(&config.Config{Zip: cfg}))
+
+			_, err := vfs.Root(context.Background(), testServer.URL+"/public.zip", name)
+			if tt.expectedErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tt.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestVFSReconfigureProxy(t *testing.T) {
+	chdir := testhelpers.ChdirInPath(t, "../../../shared/pages", &chdirSet)
+	defer chdir()
+
+	var proxiedHost string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxiedHost = r.URL.Host
+		http.ServeFile(w, r, "group/zip.gitlab.io/public.zip")
+	}))
+	defer proxy.Close()
+
+	cfg := zipCfg
+	cfg.ProxyURL = proxy.URL
+
+	vfs := New(&cfg)
+	require.NoError(t, vfs.Reconfigure(&config.Config{Zip: cfg}))
+
+	proxied := testutil.ToFloat64(metrics.ObjectStorageProxyRequests.WithLabelValues("proxied"))
+
+	_, err := vfs.Root(context.Background(), "http://storage.example.com/public.zip", "proxy")
+	require.NoError(t, err)
+
+	require.Equal(t, "storage.example.com", proxiedHost)
+	require.Greater(t, testutil.ToFloat64(metrics.ObjectStorageProxyRequests.WithLabelValues("proxied")), proxied)
+
+	proxiedHost = ""
+	_, err = vfs.Root(context.Background(), "http://169.254.169.254/public.zip", "metadata")
+	require.ErrorIs(t, err, egress.ErrBlockedAddress)
+	require.Empty(t, proxiedHost, "blocked addresses are not requested through the proxy")
+}
+
 func withExpectedArchiveCount(t *testing.T, archiveCount int, fn func(t *testing.T)) {
 	t.Helper()
 
@@ -0,0 +1,50 @@
+package zip
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+)
+
+var errNotZipArchive = errors.New("not a zip archive")
+
+// Walk calls fn for every file and directory of the public directory of the
+// archive in lexical order. Names are relative to the public directory.
+// root must have been returned by the zip VFS.
+func Walk(root vfs.Root, fn func(name string, fi os.FileInfo) error) error {
+	a, ok := root.(*zipArchive)
+	if !ok {
+		return errNotZipArchive
+	}
+
+	infos := make(map[string]os.FileInfo, len(a.files)+len(a.directories))
+	for name, file := range a.files {
+		infos[strings.TrimPrefix(name, dirPrefix)] = file.FileInfo()
+	}
+
+	for name, header := range a.directories {
+		name = strings.TrimSuffix(strings.TrimPrefix(name, dirPrefix), "/")
+		if name == "" {
+			continue
+		}
+
+		infos[name] = header.FileInfo()
+	}
+
+	names := make([]string, 0, len(infos))
+	for name := range infos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := fn(name, infos[name]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package zip
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalk(t *testing.T) {
+	zip, cleanup := openZipArchive(t, nil, true)
+	defer cleanup()
+
+	var names []string
+	var symlinks []string
+
+	err := Walk(zip, func(name string, fi os.FileInfo) error {
+		names = append(names, name)
+		if fi.Mode()&os.ModeSymlink != 0 {
+			symlinks = append(symlinks, name)
+		}
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.Len(t, names, 8)
+	require.Equal(t, []string{"404.html", "bad_symlink.html", "index.html", "subdir"}, names[:4])
+	require.Equal(t, []string{"bad_symlink.html", "symlink.html"}, symlinks)
+}
+
+func TestWalkStopsOnError(t *testing.T) {
+	zip, cleanup := openZipArchive(t, nil, true)
+	defer cleanup()
+
+	errStop := errors.New("stop")
+	calls := 0
+
+	err := Walk(zip, func(name string, fi os.FileInfo) error {
+		calls++
+		return errStop
+	})
+	require.ErrorIs(t, err, errStop)
+	require.Equal(t, 1, calls)
+}
+
+func TestWalkNotZipArchive(t *testing.T) {
+	err := Walk(nil, func(string, os.FileInfo) error { return nil })
+	require.ErrorIs(t, err, errNotZipArchive)
+}
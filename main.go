@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
+	"net"
 	"os"
 	"time"
 
@@ -13,6 +14,7 @@ import (
 
 	cfg "gitlab.com/gitlab-org/gitlab-pages/internal/config"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/logging"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/service"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/validateargs"
 	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
@@ -96,60 +98,24 @@ func closeAll(cs []io.Closer) {
 // implies closing) too soon.
 func createAppListeners(config *cfg.Config) []io.Closer {
 	var closers []io.Closer
-	var httpListeners []uintptr
-	var httpsListeners []uintptr
-	var proxyListeners []uintptr
-	var httpsProxyv2Listeners []uintptr
 
-	for _, addr := range config.ListenHTTPStrings.Split() {
-		l, f := createSocket(addr)
-		closers = append(closers, l, f)
-
-		log.WithFields(log.Fields{
-			"listener": addr,
-		}).Debug("Set up HTTP listener")
-
-		httpListeners = append(httpListeners, f.Fd())
-	}
-
-	for _, addr := range config.ListenHTTPSStrings.Split() {
-		l, f := createSocket(addr)
-		closers = append(closers, l, f)
-
-		log.WithFields(log.Fields{
-			"listener": addr,
-		}).Debug("Set up HTTPS listener")
-
-		httpsListeners = append(httpsListeners, f.Fd())
-	}
-
-	for _, addr := range config.ListenProxyStrings.Split() {
-		l, f := createSocket(addr)
-		closers = append(closers, l, f)
-
-		log.WithFields(log.Fields{
-			"listener": addr,
-		}).Debug("Set up proxy listener")
-
-		proxyListeners = append(proxyListeners, f.Fd())
-	}
-
-	for _, addr := range config.ListenHTTPSProxyv2Strings.Split() {
-		l, f := createSocket(addr)
-		closers = append(closers, l, f)
-
-		log.WithFields(log.Fields{
-			"listener": addr,
-		}).Debug("Set up https proxyv2 listener")
-
-		httpsProxyv2Listeners = append(httpsProxyv2Listeners, f.Fd())
-	}
+	for _, listener := range []struct {
+		name  string
+		addrs []string
+		fds   *[]uintptr
+	}{
+		{"http", config.ListenHTTPStrings.Split(), &config.Listeners.HTTP},
+		{"https", config.ListenHTTPSStrings.Split(), &config.Listeners.HTTPS},
+		{"proxy", config.ListenProxyStrings.Split(), &config.Listeners.Proxy},
+		{"https-proxyv2", config.ListenHTTPSProxyv2Strings.Split(), &config.Listeners.HTTPSProxyv2},
+		{"http-https", config.ListenHTTPAndHTTPSStrings.Split(), &config.Listeners.HTTPAndHTTPS},
+	} {
+		for _, addr := range listener.addrs {
+			l, f := createListener(config, listener.name, addr)
+			closers = append(closers, l, f)
 
-	config.Listeners = cfg.Listeners{
-		HTTP:         httpListeners,
-		HTTPS:        httpsListeners,
-		Proxy:        proxyListeners,
-		HTTPSProxyv2: httpsProxyv2Listeners,
+			*listener.fds = append(*listener.fds, f.Fd())
+		}
 	}
 
 	return closers
@@ -164,14 +130,27 @@ func createMetricsListener(config *cfg.Config) []io.Closer {
 		return nil
 	}
 
-	l, f := createSocket(addr)
+	l, f := createListener(config, "metrics", addr)
 	config.ListenMetrics = f.Fd()
 
+	return []io.Closer{l, f}
+}
+
+// createListener creates the socket of a listener of type name and records
+// the address it is bound to, which is only known once bound when addr uses
+// port 0
+func createListener(config *cfg.Config, name, addr string) (net.Listener, *os.File) {
+	l, f := createSocket(addr)
+
+	bound := cfg.ListenerAddress{Type: name, Address: l.Addr().String()}
+	config.Listeners.Addresses = append(config.Listeners.Addresses, bound)
+
 	log.WithFields(log.Fields{
-		"listener": addr,
-	}).Debug("Set up metrics listener")
+		"listener": name,
+		"address":  bound.Address,
+	}).Info("Listening")
 
-	return []io.Closer{l, f}
+	return l, f
 }
 
 func printVersion(showVersion bool, version string) {
@@ -184,9 +163,20 @@ func printVersion(showVersion bool, version string) {
 func main() {
 	logrus.SetOutput(os.Stderr)
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case validateCommand:
+			os.Exit(runValidate(os.Args[2:], os.Stdout, os.Stderr))
+		case doctorCommand:
+			os.Exit(runDoctor(os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
+
 	rand.Seed(time.Now().UnixNano())
 
 	metrics.MustRegister()
 
-	appMain()
+	if err := service.Run(appMain); err != nil {
+		log.WithError(err).Fatal("Failed to run as a service")
+	}
 }
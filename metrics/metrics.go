@@ -2,8 +2,13 @@ package metrics
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 )
 
+// namespace prefixes the Go runtime and process metrics registered by
+// MustRegister, so dashboards can select all of the Pages metrics together
+const namespace = "gitlab_pages"
+
 var (
 	// DomainsSourceCacheHit is the number of GitLab API call cache hits
 	DomainsSourceCacheHit = prometheus.NewCounter(prometheus.CounterOpts{
@@ -17,18 +22,53 @@ var (
 		Help: "The number of GitLab domains API cache misses",
 	})
 
+	// DomainsSourceCacheEvictions is the number of domains evicted from the
+	// GitLab domains cache because it reached its maximum number of entries
+	DomainsSourceCacheEvictions = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gitlab_pages_domains_source_cache_evictions_total",
+		Help: "The number of least recently used domains evicted from the GitLab domains cache when full",
+	})
+
+	// DomainsSourceCacheEntries is the number of entries in the GitLab domains
+	// cache, it is only set when the number of entries is limited
+	DomainsSourceCacheEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gitlab_pages_domains_source_cache_entries",
+		Help: "The number of domains and aliases in the GitLab domains cache when its size is limited",
+	})
+
 	// DomainsSourceFailures is the number of GitLab API calls that failed
 	DomainsSourceFailures = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "gitlab_pages_domains_source_failures_total",
 		Help: "The number of GitLab API calls that failed",
 	})
 
+	// DomainsSourceRequests is the number of domains retrieved from each
+	// source when the source is auto, i.e. gitlab or hostname
+	DomainsSourceRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_pages_domains_source_requests_total",
+		Help: "The number of domains retrieved from each source when the domain config source is auto",
+	}, []string{"source"})
+
+	// DomainsSourceActive is 1 for the source domains are retrieved from when
+	// the source is auto, and 0 for the other one
+	DomainsSourceActive = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gitlab_pages_domains_source_active",
+		Help: "Whether domains are retrieved from the source when the domain config source is auto",
+	}, []string{"source"})
+
 	// DomainsSourceAPIReqTotal is the number of calls made to the GitLab API that returned a 4XX error
 	DomainsSourceAPIReqTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "gitlab_pages_domains_source_api_requests_total",
 		Help: "The number of GitLab domains API calls with different status codes",
 	}, []string{"status_code"})
 
+	// DomainsSourceAPIThrottled is the number of times the GitLab API rate
+	// limited Pages, and requests were delayed or rejected as a result
+	DomainsSourceAPIThrottled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_pages_domains_source_api_throttled_total",
+		Help: "The number of GitLab API responses asking Pages to slow down (throttled), and of requests delayed or rejected until the rate limit is reset",
+	}, []string{"event"})
+
 	// DomainsSourceAPICallDuration is the time it takes to get a response from the GitLab API in seconds
 	DomainsSourceAPICallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Name: "gitlab_pages_domains_source_api_call_duration",
@@ -112,6 +152,46 @@ var (
 		Help: "The number of open requests made by httprange.Reader",
 	})
 
+	// HTTPRangeURLRefreshes is the number of refreshes of the URLs of
+	// httprange.Resource denied access, e.g. expired pre-signed URLs
+	HTTPRangeURLRefreshes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_httprange_url_refreshes_total",
+			Help: "The number of refreshes of the URLs of archives denied access by object storage, by result",
+		},
+		[]string{"result"},
+	)
+
+	// ObjectStorageProxyRequests is the number of requests made by the zip VFS
+	// to object storage, sent directly or through a forward proxy
+	ObjectStorageProxyRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_object_storage_proxy_requests_total",
+			Help: "The number of requests made by the zip VFS to object storage, by route: direct or proxied",
+		},
+		[]string{"route"},
+	)
+
+	// DomainsSourceAPIProxyRequests is the number of requests made to the
+	// GitLab API, sent directly or through a forward proxy
+	DomainsSourceAPIProxyRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_domains_source_api_proxy_requests_total",
+			Help: "The number of requests made to the GitLab API, by route: direct or proxied",
+		},
+		[]string{"route"},
+	)
+
+	// AuthProxyRequests is the number of authentication requests made to
+	// GitLab, sent directly or through a forward proxy
+	AuthProxyRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_auth_proxy_requests_total",
+			Help: "The number of authentication requests made to GitLab, by route: direct or proxied",
+		},
+		[]string{"route"},
+	)
+
 	// ZipOpened is the number of zip archives that have been opened
 	ZipOpened = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -139,6 +219,15 @@ var (
 		[]string{"op"},
 	)
 
+	// ZipFileCacheBytes is the size of the decompressed files of zip archives
+	// kept in memory
+	ZipFileCacheBytes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gitlab_pages_zip_file_cache_bytes",
+			Help: "The size in bytes of the decompressed files of zip archives kept in memory",
+		},
+	)
+
 	// ZipArchiveEntriesCached is the number of files per zip archive currently
 	// in the cache
 	ZipArchiveEntriesCached = prometheus.NewGauge(
@@ -157,6 +246,60 @@ var (
 		},
 	)
 
+	// ZipOpenDuration is the time it takes to fetch the central directory of a
+	// zip archive, labeled with the state of the archive once opened
+	ZipOpenDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "gitlab_pages_zip_open_duration_seconds",
+			Help: "The time (in seconds) it takes to fetch the central directory of a zip archive",
+			Buckets: []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5,
+				10, 30},
+		},
+		[]string{"state"},
+	)
+
+	// ZipOpenedArchiveSize is the total size of the zip archives opened
+	ZipOpenedArchiveSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "gitlab_pages_zip_opened_archive_size_bytes",
+			Help: "The total size in bytes of each zip archive opened",
+			// From 1KB to 10GB in *10 increments
+			Buckets: prometheus.ExponentialBuckets(1000.0, 10.0, 8),
+		},
+		[]string{"state"},
+	)
+
+	// ZipOpenedArchiveEntries is the number of entries of the zip archives opened
+	ZipOpenedArchiveEntries = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "gitlab_pages_zip_opened_archive_entries",
+			Help: "The number of entries in each zip archive opened",
+			// From 1 to 1,000,000 entries in *10 increments
+			Buckets: prometheus.ExponentialBuckets(1.0, 10.0, 7),
+		},
+		[]string{"state"},
+	)
+
+	// ArtifactsServerRequests is the number of requests proxied to each
+	// artifacts server, labeled with their result
+	ArtifactsServerRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_artifacts_server_requests_total",
+			Help: "The number of requests proxied to each artifacts server",
+		},
+		[]string{"server", "result"},
+	)
+
+	// ArtifactsServerUnhealthy is the number of times each artifacts server
+	// has been skipped after failing
+	ArtifactsServerUnhealthy = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_artifacts_server_unhealthy_total",
+			Help: "The number of times each artifacts server has been marked as unhealthy",
+		},
+		[]string{"server"},
+	)
+
 	RejectedRequestsCount = prometheus.NewCounter(
 		prometheus.CounterOpts{
 			Name: "gitlab_pages_unknown_method_rejected_requests",
@@ -164,6 +307,16 @@ var (
 		},
 	)
 
+	// HTTPMethodRequests is the number of requests per HTTP method, labeled with
+	// whether the method is allowed to be served
+	HTTPMethodRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_http_method_requests_total",
+			Help: "The number of requests per HTTP method and whether the method is allowed",
+		},
+		[]string{"method", "allowed"},
+	)
+
 	LimitListenerMaxConns = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "gitlab_pages_limit_listener_max_conns",
@@ -248,6 +401,245 @@ var (
 		},
 		[]string{"enforced"},
 	)
+
+	// RedirectsLimitReached is the number of times a _redirects file hit one of
+	// the limits on the number of rules, placeholders, file size or evaluation time
+	RedirectsLimitReached = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_redirects_limit_reached_total",
+			Help: "The number of times a _redirects file reached one of the limits",
+		},
+		[]string{"limit"},
+	)
+
+	// AuthFlow counts the requests reaching each stage of the OAuth flow by outcome
+	AuthFlow = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_auth_flow_total",
+			Help: "The number of requests reaching each stage of the OAuth flow by outcome",
+		},
+		[]string{"stage", "outcome"},
+	)
+
+	// AuthAPICallDuration is the latency of the GitLab API calls made during
+	// authentication by endpoint and response status
+	AuthAPICallDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "gitlab_pages_auth_api_call_duration_seconds",
+			Help: "The latency of the GitLab API calls made during authentication by endpoint and response status",
+		},
+		[]string{"endpoint", "status"},
+	)
+
+	// RateLimitBackendFailures is the number of times the rate limit backend
+	// failed and the local limits were used instead
+	RateLimitBackendFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_rate_limit_backend_failures_total",
+			Help: "The number of times the rate limit backend failed and the local limits were used instead",
+		},
+		[]string{"limiter"},
+	)
+
+	// BandwidthThrottledBytes is the number of bytes of the responses delayed
+	// by the bandwidth limit of their connection or domain
+	BandwidthThrottledBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_bandwidth_throttled_bytes_total",
+			Help: "The number of bytes of the responses delayed by the bandwidth limit of their connection or domain",
+		},
+		[]string{"limit"},
+	)
+
+	// DeploymentHooks counts the domains refreshed by deployment webhooks by result
+	DeploymentHooks = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_deployment_hooks_domains_total",
+			Help: "The number of domains refreshed by deployment webhooks by result",
+		},
+		[]string{"result"},
+	)
+
+	// AnalyticsReports counts the reports of access summaries sent to GitLab
+	// by result
+	AnalyticsReports = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_analytics_reports_total",
+			Help: "The number of reports of access summaries sent to GitLab by result",
+		},
+		[]string{"result"},
+	)
+
+	// AnalyticsDroppedRequests counts the requests left out of the access
+	// summaries because the limit of domains per interval was reached
+	AnalyticsDroppedRequests = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_analytics_dropped_requests_total",
+			Help: "The number of requests left out of the access summaries because the limit of domains was reached",
+		},
+	)
+
+	// TLSInvalidCertificates counts the TLS handshakes of custom domains with
+	// an invalid certificate by reason and by the policy applied
+	TLSInvalidCertificates = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_tls_invalid_certificates_total",
+			Help: "The number of TLS handshakes of custom domains with an invalid certificate by reason and policy applied",
+		},
+		[]string{"reason", "policy"},
+	)
+
+	// ErrorsServed is the number of error pages served by error category
+	ErrorsServed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_errors_served_total",
+			Help: "The number of error pages served by error category",
+		},
+		[]string{"category"},
+	)
+
+	// IncompleteResponses is the number of responses which were not sent
+	// completely, client_disconnect distinguishes clients closing the
+	// connection from server errors
+	IncompleteResponses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_incomplete_responses_total",
+			Help: "The number of responses which were not sent completely, by whether the client disconnected",
+		},
+		[]string{"client_disconnect"},
+	)
+
+	// MicroCacheRequests counts the requests to the micro-cache of HTML
+	// responses by result
+	MicroCacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_micro_cache_requests_total",
+			Help: "The number of requests to the micro-cache of HTML responses by result",
+		},
+		[]string{"result"},
+	)
+
+	// BytesServed is the number of bytes of the response bodies sent, by
+	// backend serving them and status class
+	BytesServed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_bytes_served_total",
+			Help: "The number of bytes of the response bodies sent, by backend and status class",
+		},
+		[]string{"backend", "status_class"},
+	)
+
+	// ShadowComparisons is the number of responses of the disk serving
+	// compared with the ones of the shadow zip serving, by result
+	ShadowComparisons = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_shadow_comparisons_total",
+			Help: "The number of responses compared between the disk and shadow zip servings, by result",
+		},
+		[]string{"result"},
+	)
+
+	// SLORequests is the number of requests measured against the service
+	// level objectives
+	SLORequests = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gitlab_pages_slo_requests_total",
+		Help: "The number of requests measured against the service level objectives",
+	})
+
+	// SLOServerErrors is the number of requests served with a 5xx status
+	SLOServerErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gitlab_pages_slo_server_errors_total",
+		Help: "The number of requests served with a 5xx status",
+	})
+
+	// SLORequestsWithinThreshold is the number of requests served within the
+	// latency thresholds of the service level objectives
+	SLORequestsWithinThreshold = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_slo_requests_within_threshold_total",
+			Help: "The number of requests served within the latency threshold",
+		},
+		[]string{"threshold"},
+	)
+
+	// RequestFilterMatches is the number of requests matched by the rules of
+	// the request filter, by rule and result, e.g. blocked or rate_limited
+	RequestFilterMatches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_request_filter_matches_total",
+			Help: "The number of requests matched by the request filter rules, by rule and result",
+		},
+		[]string{"rule", "result"},
+	)
+
+	// RequestFilterReloads is the number of reloads of the request filter
+	// rules by result
+	RequestFilterReloads = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_request_filter_reloads_total",
+			Help: "The number of reloads of the request filter rules by result",
+		},
+		[]string{"result"},
+	)
+
+	// BuildInfo is the version of Pages, named like the build info of the
+	// other GitLab services
+	BuildInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gitlab_build_info",
+			Help: "Current build info for this GitLab Service",
+		},
+		[]string{"version", "built"},
+	)
+
+	// PagesBuildInfo is the version and revision of Pages
+	PagesBuildInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gitlab_pages_build_info",
+			Help: "The version and revision of GitLab Pages",
+		},
+		[]string{"version", "revision"},
+	)
+
+	// HostRequests is the number of requests served by domain, the domains
+	// which are not exported are counted with the other label
+	HostRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_host_requests_total",
+			Help: "The number of requests served by domain, limited to the most requested domains",
+		},
+		[]string{"host"},
+	)
+
+	// NamespaceRequests is the number of requests served by namespace of the
+	// pages domain, the namespaces which are not exported are counted with the
+	// other label
+	NamespaceRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_namespace_requests_total",
+			Help: "The number of requests served by namespace, limited to the most requested namespaces",
+		},
+		[]string{"namespace"},
+	)
+
+	// OpenConnections is the number of client connections open by listener
+	OpenConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gitlab_pages_open_connections",
+			Help: "The number of client connections open by listener",
+		},
+		[]string{"listener"},
+	)
+
+	// WriteTimeouts is the number of writes to clients which exceeded the
+	// write timeout by listener
+	WriteTimeouts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gitlab_pages_write_timeouts_total",
+			Help: "The number of writes to clients which exceeded the write timeout by listener",
+		},
+		[]string{"listener"},
+	)
 )
 
 // MustRegister collectors with the Prometheus client
@@ -255,10 +647,15 @@ func MustRegister() {
 	prometheus.MustRegister(
 		DomainsSourceCacheHit,
 		DomainsSourceCacheMiss,
+		DomainsSourceCacheEvictions,
+		DomainsSourceCacheEntries,
 		DomainsSourceAPIReqTotal,
+		DomainsSourceAPIThrottled,
 		DomainsSourceAPICallDuration,
 		DomainsSourceAPITraceDuration,
 		DomainsSourceFailures,
+		DomainsSourceRequests,
+		DomainsSourceActive,
 		DiskServingFileSize,
 		ServingTime,
 		VFSOperations,
@@ -266,12 +663,23 @@ func MustRegister() {
 		HTTPRangeRequestDuration,
 		HTTPRangeTraceDuration,
 		HTTPRangeOpenRequests,
+		HTTPRangeURLRefreshes,
+		ObjectStorageProxyRequests,
+		DomainsSourceAPIProxyRequests,
+		AuthProxyRequests,
 		ZipOpened,
 		ZipOpenedEntriesCount,
 		ZipCacheRequests,
 		ZipArchiveEntriesCached,
 		ZipCachedEntries,
+		ZipFileCacheBytes,
+		ZipOpenDuration,
+		ArtifactsServerRequests,
+		ArtifactsServerUnhealthy,
+		ZipOpenedArchiveSize,
+		ZipOpenedArchiveEntries,
 		RejectedRequestsCount,
+		HTTPMethodRequests,
 		LimitListenerMaxConns,
 		LimitListenerConcurrentConns,
 		LimitListenerWaitingConns,
@@ -279,5 +687,36 @@ func MustRegister() {
 		RateLimitSourceIPCacheRequests,
 		RateLimitSourceIPCachedEntries,
 		RateLimitSourceIPBlockedCount,
+		RedirectsLimitReached,
+		RateLimitBackendFailures,
+		BandwidthThrottledBytes,
+		AuthFlow,
+		AuthAPICallDuration,
+		DeploymentHooks,
+		AnalyticsReports,
+		AnalyticsDroppedRequests,
+		ErrorsServed,
+		TLSInvalidCertificates,
+		BuildInfo,
+		PagesBuildInfo,
+		OpenConnections,
+		WriteTimeouts,
+		IncompleteResponses,
+		BytesServed,
+		MicroCacheRequests,
+		ShadowComparisons,
+		SLORequests,
+		SLOServerErrors,
+		SLORequestsWithinThreshold,
+		RequestFilterMatches,
+		RequestFilterReloads,
+		HostRequests,
+		NamespaceRequests,
+	)
+
+	// the default registry already has unprefixed copies of these collectors
+	prometheus.WrapRegistererWithPrefix(namespace+"_", prometheus.DefaultRegisterer).MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
 	)
 }
@@ -1,16 +1,28 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	proxyproto "github.com/pires/go-proxyproto"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/http2"
 
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/handoff"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/netutil"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/service"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
 type keepAliveListener struct {
@@ -23,11 +35,17 @@ type keepAliveSetter interface {
 }
 
 type listenerConfig struct {
+	// name is the type of the listener, like the listen-* flags, used as
+	// the label of its metrics
+	name      string
 	fd        uintptr
 	isProxyV2 bool
 	tlsConfig *tls.Config
-	limiter   *netutil.Limiter
-	handler   http.Handler
+	// sniffTLS serves both HTTP and HTTPS on the listener, TLS is only used
+	// for the connections starting with a TLS handshake
+	sniffTLS bool
+	limiter  *netutil.Limiter
+	handler  http.Handler
 }
 
 func (ln *keepAliveListener) Accept() (net.Conn, error) {
@@ -43,14 +61,40 @@ func (ln *keepAliveListener) Accept() (net.Conn, error) {
 	return conn, nil
 }
 
+// trackOpenConnections returns a http.Server ConnState hook counting the open
+// connections of a listener in gauge
+func trackOpenConnections(gauge prometheus.Gauge) func(net.Conn, http.ConnState) {
+	return func(_ net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			gauge.Inc()
+		case http.StateHijacked, http.StateClosed:
+			gauge.Dec()
+		}
+	}
+}
+
 func (a *theApp) listenAndServe(config listenerConfig) error {
 	// create server
-	server := &http.Server{Handler: config.handler, TLSConfig: config.tlsConfig}
+	server := &http.Server{
+		Handler:     config.handler,
+		TLSConfig:   config.tlsConfig,
+		ConnState:   trackOpenConnections(metrics.OpenConnections.WithLabelValues(config.name)),
+		ConnContext: a.Bandwidth.ConnContext,
+	}
 
-	// ensure http2 is enabled even if TLSConfig is not null
+	// ensure http2 is enabled even if TLSConfig is not null and apply the
+	// configured limits of HTTP/2 connections
 	// See https://github.com/golang/go/blob/97cee43c93cfccded197cd281f0a5885cdb605b4/src/net/http/server.go#L2947-L2954
 	if server.TLSConfig != nil {
-		server.TLSConfig.NextProtos = append(server.TLSConfig.NextProtos, "h2")
+		err := http2.ConfigureServer(server, &http2.Server{
+			MaxConcurrentStreams: uint32(a.config.HTTP2.MaxConcurrentStreams),
+			MaxReadFrameSize:     uint32(a.config.HTTP2.MaxReadFrameSize),
+			IdleTimeout:          a.config.HTTP2.IdleTimeout,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to configure HTTP/2: %w", err)
+		}
 	}
 
 	l, err := net.FileListener(os.NewFile(config.fd, "[socket]"))
@@ -64,6 +108,10 @@ func (a *theApp) listenAndServe(config listenerConfig) error {
 
 	l = &keepAliveListener{l}
 
+	if timeout := a.config.General.WriteTimeout; timeout > 0 {
+		l = netutil.WriteDeadlineListener(l, timeout, metrics.WriteTimeouts.WithLabelValues(config.name))
+	}
+
 	if config.isProxyV2 {
 		l = &proxyproto.Listener{
 			Listener: l,
@@ -73,9 +121,112 @@ func (a *theApp) listenAndServe(config listenerConfig) error {
 		}
 	}
 
-	if config.tlsConfig != nil {
+	switch {
+	case config.tlsConfig != nil && config.sniffTLS:
+		l = netutil.SniffTLSListener(l, server.TLSConfig, netutil.SniffTimeout)
+	case config.tlsConfig != nil:
 		l = tls.NewListener(l, server.TLSConfig)
 	}
 
-	return server.Serve(l)
+	if !a.trackServer(server) {
+		return nil
+	}
+
+	if err := server.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// trackServer registers server to be shut down by shutdown, it returns false
+// when Pages is already stopping and server must not serve
+func (a *theApp) trackServer(server *http.Server) bool {
+	a.serversMu.Lock()
+	defer a.serversMu.Unlock()
+
+	if a.stopping {
+		return false
+	}
+
+	a.servers = append(a.servers, server)
+
+	return true
+}
+
+// shutdown stops accepting connections on every listener and waits for the
+// requests in flight to complete, until ctx is done
+func (a *theApp) shutdown(ctx context.Context) error {
+	a.serversMu.Lock()
+	a.stopping = true
+	servers := a.servers
+	a.serversMu.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(servers))
+
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			errs <- server.Shutdown(ctx)
+		}(server)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stopGracefully stops Pages gracefully when the service manager asks it to
+// stop, or when it receives SIGTERM or SIGINT while its caches are handed off
+// to the file at handoffPath: the listeners stop accepting connections, the
+// requests in flight are waited for up to the shutdown timeout, then the
+// caches are written to the file, if any, before Run returns. Without a
+// handoff file, the signals stop Pages right away.
+func (a *theApp) stopGracefully(handoffPath string) {
+	signals := make(chan os.Signal, 1)
+	if handoffPath != "" {
+		signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	}
+
+	a.stopped = make(chan struct{})
+
+	go func() {
+		fields := log.Fields{}
+
+		select {
+		case sig := <-signals:
+			fields["signal"] = sig.String()
+		case <-service.Stopping():
+			fields["service"] = "stop"
+		}
+
+		logger := log.WithFields(fields)
+
+		ctx, cancel := context.WithTimeout(context.Background(), a.config.General.ShutdownTimeout)
+		defer cancel()
+
+		if err := a.shutdown(ctx); err != nil {
+			logger.WithError(err).Warn("requests were still in flight when Pages stopped")
+		}
+
+		if handoffPath != "" {
+			if err := handoff.Write(handoffPath, a.handoffState()); err != nil {
+				logger.WithError(err).Error("could not hand off the caches")
+				os.Exit(1)
+			}
+
+			logger.Info("handed off the caches")
+		}
+
+		close(a.stopped)
+	}()
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrackOpenConnections(t *testing.T) {
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_open_connections"})
+	track := trackOpenConnections(gauge)
+
+	for _, state := range []http.ConnState{http.StateNew, http.StateNew, http.StateNew, http.StateActive, http.StateIdle} {
+		track(nil, state)
+	}
+	require.Equal(t, float64(3), testutil.ToFloat64(gauge))
+
+	track(nil, http.StateClosed)
+	track(nil, http.StateHijacked)
+	require.Equal(t, float64(1), testutil.ToFloat64(gauge))
+}
+
+func TestShutdown(t *testing.T) {
+	a := &theApp{}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	started := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("served"))
+	})}
+	require.True(t, a.trackServer(server))
+
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(l) }()
+
+	responses := make(chan string, 1)
+	go func() {
+		rsp, err := http.Get("http://" + l.Addr().String())
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		defer rsp.Body.Close()
+
+		body, _ := io.ReadAll(rsp.Body)
+		responses <- string(body)
+	}()
+
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	require.NoError(t, a.shutdown(ctx))
+	require.Equal(t, "served", <-responses, "the requests in flight are completed")
+	require.ErrorIs(t, <-served, http.ErrServerClosed)
+
+	require.False(t, a.trackServer(&http.Server{}), "no server is started once stopping")
+}
@@ -19,23 +19,17 @@ const (
 var (
 	pagesBinary = flag.String("gitlab-pages-binary", "../../gitlab-pages", "Path to the gitlab-pages binary")
 
-	httpPort        = "36000"
-	httpsPort       = "37000"
-	httpProxyPort   = "38000"
-	httpProxyV2Port = "39000"
-
-	// TODO: Use TCP port 0 everywhere to avoid conflicts. The binary could output
-	// the actual port (and type of listener) for us to read in place of the
-	// hardcoded values below.
+	// the listeners shared by the tests are bound to port 0 so they do not
+	// conflict with other processes, see ListenSpec.boundPort
 	listeners = []ListenSpec{
-		{"http", "127.0.0.1", httpPort},
-		{"http", "::1", httpPort},
-		{"https", "127.0.0.1", httpsPort},
-		{"https", "::1", httpsPort},
-		{"proxy", "127.0.0.1", httpProxyPort},
-		{"proxy", "::1", httpProxyPort},
-		{"https-proxyv2", "127.0.0.1", httpProxyV2Port},
-		{"https-proxyv2", "::1", httpProxyV2Port},
+		{"http", "127.0.0.1", "0"},
+		{"http", "::1", "0"},
+		{"https", "127.0.0.1", "0"},
+		{"https", "::1", "0"},
+		{"proxy", "127.0.0.1", "0"},
+		{"proxy", "::1", "0"},
+		{"https-proxyv2", "127.0.0.1", "0"},
+		{"https-proxyv2", "::1", "0"},
 	}
 
 	ipv4Listeners = []ListenSpec{
@@ -97,3 +97,19 @@ func TestAcmeChallengesWhenItIsConfigured(t *testing.T) {
 		})
 	}
 }
+
+func TestAcmeChallengesWithRedirectHTTP(t *testing.T) {
+	RunPagesProcess(t,
+		withListeners([]ListenSpec{httpListener}),
+		withExtraArgument("redirect-http", "true"),
+	)
+
+	rsp, err := GetRedirectPage(t, httpListener, "withacmechallenge.domain.com", existingAcmeTokenPath)
+	require.NoError(t, err)
+	defer rsp.Body.Close()
+
+	require.Equal(t, http.StatusOK, rsp.StatusCode, "ACME challenges are not redirected to HTTPS")
+	body, err := io.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "this is token\n", string(body))
+}
@@ -129,6 +129,39 @@ func TestWhenLoginCallbackWithUnencryptedCode(t *testing.T) {
 	require.Equal(t, http.StatusInternalServerError, authrsp.StatusCode)
 }
 
+func TestAccessControlEmbeddedCustomDomain(t *testing.T) {
+	RunPagesProcess(t,
+		withListeners([]ListenSpec{httpsListener}),
+		withArguments([]string{
+			"-config=" + defaultAuthConfig(t),
+		}),
+	)
+
+	rsp, err := GetRedirectPageWithHeaders(t, httpsListener, "embedded.domain.com", "/",
+		http.Header{"Sec-Fetch-Dest": []string{"iframe"}})
+	require.NoError(t, err)
+	defer rsp.Body.Close()
+
+	require.Equal(t, http.StatusUnauthorized, rsp.StatusCode)
+	require.Equal(t, "frame-ancestors 'self' https://tool.example.com", rsp.Header.Get("Content-Security-Policy"))
+	require.Empty(t, rsp.Cookies())
+
+	body, err := io.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), `href="https://embedded.domain.com/" target="_top"`)
+
+	rsp, err = GetRedirectPage(t, httpsListener, "embedded.domain.com", "/")
+	require.NoError(t, err)
+	defer rsp.Body.Close()
+
+	require.Equal(t, http.StatusFound, rsp.StatusCode)
+
+	cookies := rsp.Cookies()
+	require.Len(t, cookies, 1)
+	require.Equal(t, http.SameSiteNoneMode, cookies[0].SameSite)
+	require.True(t, cookies[0].Secure)
+}
+
 func TestAccessControlUnderCustomDomain(t *testing.T) {
 	RunPagesProcess(t,
 		withListeners([]ListenSpec{httpListener}),
@@ -540,8 +573,9 @@ func testAccessControl(t *testing.T, runPages runPagesFunc) {
 
 			require.Equal(t, tt.status, rsp3.StatusCode)
 
-			// Make sure there are no cache headers
-			require.Empty(t, rsp3.Header.Values("Cache-Control"))
+			// Make sure there are no cache headers, other than the ones of the
+			// redirects and error pages keeping caches from storing them
+			require.Subset(t, []string{"no-store"}, rsp3.Header.Values("Cache-Control"))
 			require.Empty(t, rsp3.Header.Values("Expires"))
 
 			if tt.redirectBack {
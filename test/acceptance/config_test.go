@@ -46,15 +46,17 @@ func TestMixedConfigSources(t *testing.T) {
 }
 
 func TestMultipleListenersFromEnvironmentVariables(t *testing.T) {
-	listenSpecs := []ListenSpec{{"http", "127.0.0.1", "37001"}, {"http", "127.0.0.1", "37002"}}
-	envVarValue := fmt.Sprintf("LISTEN_HTTP=%s,%s", net.JoinHostPort("127.0.0.1", "37001"), net.JoinHostPort("127.0.0.1", "37002"))
+	envVarValue := fmt.Sprintf("LISTEN_HTTP=%s,%s", net.JoinHostPort("127.0.0.1", "0"), net.JoinHostPort("127.0.0.1", "0"))
 
-	RunPagesProcess(t,
+	logBuf := RunPagesProcess(t,
 		withoutWait,
 		withListeners([]ListenSpec{}), // explicitly disable listeners for this test
 		withEnv([]string{envVarValue}),
 	)
 
+	listenSpecs := boundListeners(t, logBuf, 2)
+	require.NotEqual(t, listenSpecs[0].Port, listenSpecs[1].Port)
+
 	for _, listener := range listenSpecs {
 		require.NoError(t, listener.WaitUntilRequestSucceeds(nil))
 		rsp, err := GetPageFromListener(t, listener, "group.gitlab-example.com", "project/")
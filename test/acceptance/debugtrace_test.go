@@ -0,0 +1,50 @@
+package acceptance_test
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/fixture"
+)
+
+func TestDebugTraceHeader(t *testing.T) {
+	RunPagesProcess(t,
+		withListeners([]ListenSpec{httpListener}),
+	)
+
+	t.Run("without token", func(t *testing.T) {
+		rsp, err := GetPageFromListener(t, httpListener, "group.gitlab-example.com", "project/")
+		require.NoError(t, err)
+		defer rsp.Body.Close()
+
+		require.Equal(t, http.StatusOK, rsp.StatusCode)
+		require.Empty(t, rsp.Header.Get("X-Pages-Debug"))
+	})
+
+	t.Run("with signed token", func(t *testing.T) {
+		secret, err := base64.StdEncoding.DecodeString(fixture.GitLabAPISecretKey)
+		require.NoError(t, err)
+
+		claims := jwt.RegisteredClaims{Issuer: "gitlab", Audience: jwt.ClaimStrings{"gitlab-pages"}, ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))}
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+		require.NoError(t, err)
+
+		header := http.Header{"Gitlab-Pages-Debug": []string{token}}
+		rsp, err := GetPageFromListenerWithHeaders(t, httpListener, "group.gitlab-example.com", "project/", header)
+		require.NoError(t, err)
+		defer rsp.Body.Close()
+
+		require.Equal(t, http.StatusOK, rsp.StatusCode)
+
+		trace := rsp.Header.Get("X-Pages-Debug")
+		require.Contains(t, trace, "source=gitlab")
+		require.Contains(t, trace, "prefix=/project")
+		require.Contains(t, trace, "vfs=")
+		require.Contains(t, trace, "total=")
+	})
+}
@@ -0,0 +1,55 @@
+package acceptance_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/fixture"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/testhelpers"
+)
+
+func TestDiagnosticsPage(t *testing.T) {
+	RunPagesProcess(t,
+		withListeners([]ListenSpec{httpListener}),
+		withExtraArgument("pages-diagnostics", "/@diagnostics"),
+	)
+
+	t.Run("unauthorized", func(t *testing.T) {
+		rsp, err := GetPageFromListener(t, httpListener, "group.gitlab-example.com", "@diagnostics?domain=group.gitlab-example.com")
+		require.NoError(t, err)
+		defer rsp.Body.Close()
+
+		require.Equal(t, http.StatusUnauthorized, rsp.StatusCode)
+	})
+
+	t.Run("authorized", func(t *testing.T) {
+		secret, err := base64.StdEncoding.DecodeString(fixture.GitLabAPISecretKey)
+		require.NoError(t, err)
+
+		token := testhelpers.SignAPIToken(t, secret, jwt.NewNumericDate(time.Now().Add(time.Minute)))
+		header := http.Header{"Gitlab-Pages-Api-Request": []string{token}}
+		rsp, err := GetPageFromListenerWithHeaders(t, httpListener, "group.gitlab-example.com", "@diagnostics?domain=group.gitlab-example.com", header)
+		require.NoError(t, err)
+		defer rsp.Body.Close()
+
+		require.Equal(t, http.StatusOK, rsp.StatusCode)
+
+		var report struct {
+			Domain string `json:"domain"`
+			Checks []struct {
+				Name   string `json:"name"`
+				Status string `json:"status"`
+			} `json:"checks"`
+		}
+		require.NoError(t, json.NewDecoder(rsp.Body).Decode(&report))
+		require.Equal(t, "group.gitlab-example.com", report.Domain)
+		require.Equal(t, "configuration", report.Checks[0].Name)
+		require.Equal(t, "ok", report.Checks[0].Status)
+	})
+}
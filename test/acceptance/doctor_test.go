@@ -0,0 +1,79 @@
+package acceptance_test
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/fixture"
+)
+
+func TestDoctorCommand(t *testing.T) {
+	RunPagesProcess(t,
+		withListeners([]ListenSpec{httpListener}),
+		withExtraArgument("pages-status", "/@statuscheck"),
+		withExtraArgument("pages-diagnostics", "/@diagnostics"),
+	)
+
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(secretFile, []byte(fixture.GitLabAPISecretKey), 0600))
+
+	runDoctor := func(t *testing.T, args ...string) (int, map[string]interface{}) {
+		t.Helper()
+
+		args = append([]string{"doctor", "-json", "-url", "http://" + httpListener.JoinHostPort()}, args...)
+		cmd := exec.Command(*pagesBinary, args...)
+		output, err := cmd.Output()
+
+		var report map[string]interface{}
+		require.NoError(t, json.Unmarshal(output, &report), string(output))
+
+		if err != nil {
+			var exitErr *exec.ExitError
+			require.ErrorAs(t, err, &exitErr)
+			return exitErr.ExitCode(), report
+		}
+
+		return 0, report
+	}
+
+	t.Run("ready", func(t *testing.T) {
+		exitCode, report := runDoctor(t,
+			"-status-path", "/@statuscheck",
+			"-domain", "group.gitlab-example.com",
+			"-path", "/index.html",
+			"-diagnostics-path", "/@diagnostics",
+			"-api-secret-key", secretFile,
+		)
+
+		require.Equal(t, 0, exitCode)
+		require.Equal(t, true, report["ready"])
+
+		statuses := make(map[string]interface{})
+		for _, result := range report["results"].([]interface{}) {
+			result := result.(map[string]interface{})
+			statuses[result["name"].(string)] = result["status"]
+		}
+
+		require.Equal(t, map[string]interface{}{
+			"status":            "ok",
+			"synthetic_request": "ok",
+			"diagnostics":       "ok",
+			"metrics":           "skipped",
+		}, statuses)
+	})
+
+	t.Run("not_ready", func(t *testing.T) {
+		exitCode, report := runDoctor(t,
+			"-domain", "group.gitlab-example.com",
+			"-path", "/not-existing-file",
+		)
+
+		require.Equal(t, 1, exitCode)
+		require.Equal(t, false, report["ready"])
+	})
+}
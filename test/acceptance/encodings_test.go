@@ -39,22 +39,46 @@ func TestMIMETypes(t *testing.T) {
 
 func TestCompressedEncoding(t *testing.T) {
 	tests := []struct {
-		name     string
-		host     string
-		path     string
-		encoding string
+		name             string
+		host             string
+		path             string
+		acceptEncoding   string
+		expectedEncoding string
 	}{
 		{
 			"gzip encoding",
 			"group.gitlab-example.com",
 			"index.html",
 			"gzip",
+			"gzip",
 		},
 		{
 			"brotli encoding",
 			"group.gitlab-example.com",
 			"index.html",
 			"br",
+			"br",
+		},
+		{
+			"brotli preferred over gzip",
+			"group.gitlab-example.com",
+			"index.html",
+			"gzip, deflate, br",
+			"br",
+		},
+		{
+			"gzip preferred by the client",
+			"group.gitlab-example.com",
+			"index.html",
+			"gzip;q=1.0, br;q=0.5",
+			"gzip",
+		},
+		{
+			"no accepted encoding",
+			"group.gitlab-example.com",
+			"index.html",
+			"deflate",
+			"",
 		},
 	}
 
@@ -65,14 +89,15 @@ func TestCompressedEncoding(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			header := http.Header{
-				"Accept-Encoding": []string{tt.encoding},
+				"Accept-Encoding": []string{tt.acceptEncoding},
 			}
-			rsp, err := GetPageFromListenerWithHeaders(t, httpListener, "group.gitlab-example.com", "index.html", header)
+			rsp, err := GetPageFromListenerWithHeaders(t, httpListener, tt.host, tt.path, header)
 			require.NoError(t, err)
 			defer rsp.Body.Close()
 
 			require.Equal(t, http.StatusOK, rsp.StatusCode)
-			require.Equal(t, tt.encoding, rsp.Header.Get("Content-Encoding"))
+			require.Equal(t, tt.expectedEncoding, rsp.Header.Get("Content-Encoding"))
+			require.Contains(t, rsp.Header.Values("Vary"), "Accept-Encoding")
 		})
 	}
 }
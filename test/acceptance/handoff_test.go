@@ -0,0 +1,45 @@
+package acceptance_test
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheHandoff(t *testing.T) {
+	handoffFile := filepath.Join(t.TempDir(), "handoff.json")
+
+	serve := func(t *testing.T, opts *stubOpts) {
+		RunPagesProcess(t,
+			withListeners([]ListenSpec{httpListener}),
+			withStubOptions(opts),
+			withArguments([]string{"-cache-handoff-file", handoffFile}),
+		)
+
+		rsp, err := GetPageFromListener(t, httpListener, "group.gitlab-example.com", "project/")
+		require.NoError(t, err)
+		rsp.Body.Close()
+
+		require.Equal(t, http.StatusOK, rsp.StatusCode)
+	}
+
+	// the caches are handed off when the process is stopped at the end of
+	// the subtest
+	t.Run("previous process", func(t *testing.T) {
+		opts := &stubOpts{}
+		serve(t, opts)
+
+		require.True(t, opts.getAPICalled())
+	})
+
+	require.FileExists(t, handoffFile)
+
+	t.Run("next process", func(t *testing.T) {
+		opts := &stubOpts{}
+		serve(t, opts)
+
+		require.False(t, opts.getAPICalled(), "the domain is handed off by the previous process")
+	})
+}
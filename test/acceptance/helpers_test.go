@@ -200,7 +200,7 @@ func (l ListenSpec) WaitUntilRequestSucceeds(done chan struct{}) error {
 }
 
 func (l ListenSpec) JoinHostPort() string {
-	return net.JoinHostPort(l.Host, l.Port)
+	return net.JoinHostPort(l.Host, l.boundPort())
 }
 
 func RunPagesProcess(t *testing.T, opts ...processOption) *LogCaptureBuffer {
@@ -290,6 +290,8 @@ func runPagesProcess(t *testing.T, wait bool, pagesBinary string, listeners []Li
 	require.NoError(t, cmd.Start())
 	t.Logf("Running %s %v", pagesBinary, args)
 
+	untrack := trackProcessLog(logBuf)
+
 	waitCh := make(chan struct{})
 	go func() {
 		cmd.Wait()
@@ -303,6 +305,7 @@ func runPagesProcess(t *testing.T, wait bool, pagesBinary string, listeners []Li
 		cmd.Process.Signal(os.Interrupt)
 		cmd.Process.Wait()
 		<-waitCh
+		untrack()
 	}
 
 	if wait {
@@ -322,7 +325,7 @@ func getPagesArgs(t *testing.T, listeners []ListenSpec, promPort string, extraAr
 	args = append(args, "-log-verbose=true")
 
 	for _, spec := range listeners {
-		args = append(args, "-listen-"+spec.Type, spec.JoinHostPort())
+		args = append(args, "-listen-"+spec.Type, net.JoinHostPort(spec.Host, spec.Port))
 
 		if strings.Contains(spec.Type, request.SchemeHTTPS) {
 			hasHTTPS = true
@@ -465,6 +468,10 @@ func NewGitlabDomainsSourceStub(t *testing.T, opts *stubOpts) *httptest.Server {
 	}
 
 	router.HandleFunc("/api/v4/internal/pages", pagesHandler)
+	// Pages is not ready until the status of the API was checked
+	router.HandleFunc("/api/v4/internal/pages/status", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
 
 	authHandler := defaultAuthHandler(t)
 	if opts.authHandler != nil {
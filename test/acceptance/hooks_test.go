@@ -0,0 +1,61 @@
+package acceptance_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/fixture"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/testhelpers"
+)
+
+func TestDeploymentHook(t *testing.T) {
+	opts := &stubOpts{}
+
+	RunPagesProcess(t,
+		withListeners([]ListenSpec{httpListener}),
+		withStubOptions(opts),
+		withExtraArgument("enable-deployment-hooks", "true"),
+	)
+
+	for i := 0; i < 2; i++ {
+		opts.setAPICalled(false)
+
+		rsp, err := GetPageFromListener(t, httpListener, "group.gitlab-example.com", "index.html")
+		require.NoError(t, err)
+		rsp.Body.Close()
+		require.Equal(t, http.StatusOK, rsp.StatusCode)
+	}
+	require.False(t, opts.getAPICalled(), "the domain is cached")
+
+	secret, err := base64.StdEncoding.DecodeString(fixture.GitLabAPISecretKey)
+	require.NoError(t, err)
+
+	body := strings.NewReader(`{"domains":["group.gitlab-example.com"]}`)
+	req, err := http.NewRequest(http.MethodPost, httpListener.URL("/-/hooks/deployment"), body)
+	require.NoError(t, err)
+	req.Host = "group.gitlab-example.com"
+	req.Header.Set("Gitlab-Pages-Api-Request", testhelpers.SignAPIToken(t, secret, jwt.NewNumericDate(time.Now().Add(time.Minute))))
+
+	rsp, err := DoPagesRequest(t, httpListener, req)
+	require.NoError(t, err)
+	defer rsp.Body.Close()
+
+	require.Equal(t, http.StatusOK, rsp.StatusCode, "the webhook is allowed with the default HTTP methods")
+
+	var results []struct {
+		Domain string `json:"domain"`
+		Result string `json:"result"`
+	}
+	require.NoError(t, json.NewDecoder(rsp.Body).Decode(&results))
+	require.Len(t, results, 1)
+	require.Equal(t, "group.gitlab-example.com", results[0].Domain)
+	require.Equal(t, "refreshed", results[0].Result)
+	require.True(t, opts.getAPICalled(), "the cached domain is retrieved again")
+}
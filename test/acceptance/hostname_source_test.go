@@ -0,0 +1,61 @@
+package acceptance_test
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostnameSource(t *testing.T) {
+	RunPagesProcess(t,
+		withListeners([]ListenSpec{httpListener}),
+		withExtraArgument("hostname-source-template", "{project}.{group}.pages.local"),
+	)
+
+	tests := map[string]struct {
+		host           string
+		path           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		"project": {
+			host:           "project.group.pages.local",
+			path:           "",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "project-subdir\n",
+		},
+		"missing_project": {
+			host:           "missing.group.pages.local",
+			path:           "",
+			expectedStatus: http.StatusNotFound,
+		},
+		"missing_group": {
+			host:           "project.missing.pages.local",
+			path:           "",
+			expectedStatus: http.StatusNotFound,
+		},
+		"not_matching_template": {
+			host:           "group.gitlab-example.com",
+			path:           "project/",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			rsp, err := GetPageFromListener(t, httpListener, tt.host, tt.path)
+			require.NoError(t, err)
+			defer rsp.Body.Close()
+
+			require.Equal(t, tt.expectedStatus, rsp.StatusCode)
+
+			if tt.expectedBody != "" {
+				body, err := io.ReadAll(rsp.Body)
+				require.NoError(t, err)
+				require.Equal(t, tt.expectedBody, string(body))
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package acceptance_test
+
+import (
+	"crypto/tls"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+)
+
+func TestHTTP2Settings(t *testing.T) {
+	RunPagesProcess(t,
+		withListeners([]ListenSpec{httpsListener}),
+		withExtraArgument("http2-max-concurrent-streams", "10"),
+		withExtraArgument("http2-max-read-frame-size", "32768"),
+	)
+
+	conn, err := tls.Dial("tcp", httpsListener.JoinHostPort(), &tls.Config{
+		RootCAs:    TestCertPool,
+		ServerName: "group.gitlab-example.com",
+		NextProtos: []string{http2.NextProtoTLS},
+	})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.Equal(t, http2.NextProtoTLS, conn.ConnectionState().NegotiatedProtocol)
+
+	_, err = io.WriteString(conn, http2.ClientPreface)
+	require.NoError(t, err)
+
+	framer := http2.NewFramer(conn, conn)
+	require.NoError(t, framer.WriteSettings())
+
+	frame, err := framer.ReadFrame()
+	require.NoError(t, err)
+
+	settings, ok := frame.(*http2.SettingsFrame)
+	require.True(t, ok, "the server sends its settings first")
+
+	maxConcurrentStreams, ok := settings.Value(http2.SettingMaxConcurrentStreams)
+	require.True(t, ok)
+	require.Equal(t, uint32(10), maxConcurrentStreams)
+
+	maxFrameSize, ok := settings.Value(http2.SettingMaxFrameSize)
+	require.True(t, ok)
+	require.Equal(t, uint32(32768), maxFrameSize)
+}
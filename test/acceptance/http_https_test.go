@@ -0,0 +1,39 @@
+package acceptance_test
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPAndHTTPSOnTheSamePort(t *testing.T) {
+	httpAndHTTPSListener := ListenSpec{"http-https", "127.0.0.1", "0"}
+
+	RunPagesProcess(t,
+		withListeners([]ListenSpec{httpAndHTTPSListener}),
+	)
+
+	port := httpAndHTTPSListener.boundPort()
+
+	tests := map[string]ListenSpec{
+		"http":  {"http", httpAndHTTPSListener.Host, port},
+		"https": {"https", httpAndHTTPSListener.Host, port},
+	}
+
+	for name, spec := range tests {
+		t.Run(name, func(t *testing.T) {
+			rsp, err := GetPageFromListener(t, spec, "group.gitlab-example.com", "project/")
+			require.NoError(t, err)
+			defer rsp.Body.Close()
+
+			require.Equal(t, http.StatusOK, rsp.StatusCode)
+			require.Equal(t, spec.Type == "https", rsp.TLS != nil)
+
+			body, err := io.ReadAll(rsp.Body)
+			require.NoError(t, err)
+			require.Equal(t, "project-subdir\n", string(body))
+		})
+	}
+}
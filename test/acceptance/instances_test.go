@@ -0,0 +1,46 @@
+package acceptance_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/feature"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/testhelpers"
+)
+
+func TestVirtualInstances(t *testing.T) {
+	testhelpers.StubFeatureFlagValue(t, feature.EnforceDomainRateLimits.EnvVariable, true)
+
+	instanceConfig := filepath.Join(t.TempDir(), "instance.conf")
+	require.NoError(t, os.WriteFile(instanceConfig, []byte("pages-domain=instance.example.com\nrate-limit-domain=1\nrate-limit-domain-burst=1\n"), 0644))
+
+	RunPagesProcess(t,
+		withListeners([]ListenSpec{httpListener}),
+		withArguments([]string{"-instance-config", instanceConfig}),
+	)
+
+	// the rate limits of the instance only apply to its pages domain
+	for i := 0; i < 3; i++ {
+		rsp, err := GetPageFromListener(t, httpListener, "group.gitlab-example.com", "project/")
+		require.NoError(t, err)
+		rsp.Body.Close()
+
+		require.Equal(t, http.StatusOK, rsp.StatusCode)
+	}
+
+	rsp, err := GetPageFromListener(t, httpListener, "group.instance.example.com", "project/")
+	require.NoError(t, err)
+	rsp.Body.Close()
+
+	require.Equal(t, http.StatusNotFound, rsp.StatusCode)
+
+	rsp, err = GetPageFromListener(t, httpListener, "group.instance.example.com", "project/")
+	require.NoError(t, err)
+	rsp.Body.Close()
+
+	require.Equal(t, http.StatusTooManyRequests, rsp.StatusCode)
+}
@@ -0,0 +1,173 @@
+package acceptance_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// boundListener is the address a listener is bound to, logged on startup and
+// served by the metrics listener
+type boundListener struct {
+	Type    string `json:"type"`
+	Address string `json:"address"`
+}
+
+// trackedProcess is a running pages process whose listeners resolve the
+// ports of ListenSpecs using port 0
+type trackedProcess struct {
+	logBuf *LogCaptureBuffer
+
+	// listeners are kept once logged since tests may reset logBuf
+	listeners []ListenSpec
+}
+
+var (
+	// trackedProcesses are the running pages processes, in the order they
+	// were started
+	trackedProcesses   []*trackedProcess
+	trackedProcessesMu sync.Mutex
+)
+
+// trackProcessLog makes the listeners bound by the process logging to logBuf
+// resolve the ports of ListenSpecs using port 0 until untrack is called
+func trackProcessLog(logBuf *LogCaptureBuffer) (untrack func()) {
+	trackedProcessesMu.Lock()
+	defer trackedProcessesMu.Unlock()
+
+	process := &trackedProcess{logBuf: logBuf}
+	trackedProcesses = append(trackedProcesses, process)
+
+	return func() {
+		trackedProcessesMu.Lock()
+		defer trackedProcessesMu.Unlock()
+
+		for i, p := range trackedProcesses {
+			if p == process {
+				trackedProcesses = append(trackedProcesses[:i], trackedProcesses[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// boundPort returns the port of l. When l uses port 0 it is the port the
+// most recently started process running a listener of the same type and host
+// is bound to, or 0 until that process is bound.
+func (l ListenSpec) boundPort() string {
+	if l.Port != "0" {
+		return l.Port
+	}
+
+	trackedProcessesMu.Lock()
+	defer trackedProcessesMu.Unlock()
+
+	for i := len(trackedProcesses) - 1; i >= 0; i-- {
+		process := trackedProcesses[i]
+		if specs := parseBoundListeners(process.logBuf); len(specs) > len(process.listeners) {
+			process.listeners = specs
+		}
+
+		for _, spec := range process.listeners {
+			if spec.Type == l.Type && spec.Host == l.Host {
+				return spec.Port
+			}
+		}
+	}
+
+	return l.Port
+}
+
+// parseBoundListeners returns the listeners logged by the pages process
+// logging to logBuf
+func parseBoundListeners(logBuf *LogCaptureBuffer) []ListenSpec {
+	var specs []ListenSpec
+
+	scanner := bufio.NewScanner(strings.NewReader(logBuf.String()))
+	for scanner.Scan() {
+		var line struct {
+			Msg      string `json:"msg"`
+			Listener string `json:"listener"`
+			Address  string `json:"address"`
+		}
+
+		if json.Unmarshal(scanner.Bytes(), &line) != nil || line.Msg != "Listening" {
+			continue
+		}
+
+		host, port, err := net.SplitHostPort(line.Address)
+		if err != nil {
+			continue
+		}
+
+		specs = append(specs, ListenSpec{Type: line.Listener, Host: host, Port: port})
+	}
+
+	return specs
+}
+
+// boundListeners waits until the pages process logging to logBuf is bound to
+// n listeners and returns them, so tests can listen on port 0 and run
+// processes in parallel
+func boundListeners(t *testing.T, logBuf *LogCaptureBuffer, n int) []ListenSpec {
+	t.Helper()
+
+	var specs []ListenSpec
+	require.Eventually(t, func() bool {
+		specs = parseBoundListeners(logBuf)
+
+		return len(specs) == n
+	}, 5*time.Second, 10*time.Millisecond)
+
+	return specs
+}
+
+func TestListenOnPortZero(t *testing.T) {
+	var servers [][]ListenSpec
+
+	for i := 0; i < 2; i++ {
+		logBuf := RunPagesProcess(t,
+			withoutWait,
+			withListeners([]ListenSpec{{"http", "127.0.0.1", "0"}, {"https", "127.0.0.1", "0"}}),
+			withExtraArgument("metrics-address", "127.0.0.1:0"),
+		)
+
+		specs := boundListeners(t, logBuf, 3)
+		servers = append(servers, specs)
+
+		for _, spec := range specs[:2] {
+			require.NotEqual(t, "0", spec.Port)
+			require.NoError(t, spec.WaitUntilRequestSucceeds(nil))
+
+			rsp, err := GetPageFromListener(t, spec, "group.gitlab-example.com", "index.html")
+			require.NoError(t, err)
+			rsp.Body.Close()
+			require.Equal(t, http.StatusOK, rsp.StatusCode, spec.Type)
+		}
+
+		metricsListener := specs[2]
+		require.Equal(t, "metrics", metricsListener.Type)
+
+		rsp, err := http.Get("http://" + metricsListener.JoinHostPort() + "/listeners")
+		require.NoError(t, err)
+		defer rsp.Body.Close()
+
+		var listeners []boundListener
+		require.NoError(t, json.NewDecoder(rsp.Body).Decode(&listeners))
+		require.Len(t, listeners, 3)
+
+		for i, listener := range listeners {
+			require.Equal(t, specs[i].Type, listener.Type)
+			require.Equal(t, specs[i].JoinHostPort(), listener.Address)
+		}
+	}
+
+	require.NotEqual(t, servers[0][0].Port, servers[1][0].Port, "the processes are bound to different ports")
+}
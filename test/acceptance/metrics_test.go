@@ -3,17 +3,22 @@ package acceptance_test
 import (
 	"io"
 	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 )
 
+// metricsListener is bound to port 0 like the shared listeners
+var metricsListener = ListenSpec{"metrics", "127.0.0.1", "0"}
+
 func TestPrometheusMetricsCanBeScraped(t *testing.T) {
 	runObjectStorage(t, "../../shared/pages/group/zip.gitlab.io/public.zip")
 
 	RunPagesProcess(t,
 		withExtraArgument("max-conns", "10"),
-		withExtraArgument("metrics-address", ":42345"),
+		withExtraArgument("metrics-address", "127.0.0.1:0"),
 	)
 
 	// need to call an actual resource to populate certain metrics e.g. gitlab_pages_domains_source_api_requests_total
@@ -23,7 +28,7 @@ func TestPrometheusMetricsCanBeScraped(t *testing.T) {
 	defer res.Body.Close()
 	require.Equal(t, http.StatusOK, res.StatusCode)
 
-	resp, err := http.Get("http://127.0.0.1:42345/metrics")
+	resp, err := http.Get(metricsListener.URL("metrics"))
 	require.NoError(t, err)
 
 	defer resp.Body.Close()
@@ -31,6 +36,16 @@ func TestPrometheusMetricsCanBeScraped(t *testing.T) {
 	require.NoError(t, err)
 
 	require.Contains(t, string(body), "gitlab_pages_http_in_flight_requests 0")
+	require.Contains(t, string(body), `gitlab_build_info{built="",version=`)
+	require.Contains(t, string(body), `gitlab_pages_build_info{revision=`)
+	require.Contains(t, string(body), `gitlab_pages_open_connections{listener="http"}`)
+	// service level objectives
+	require.Contains(t, string(body), "gitlab_pages_slo_requests_total")
+	require.Contains(t, string(body), `gitlab_pages_slo_requests_within_threshold_total{threshold="500ms"}`)
+	require.Contains(t, string(body), "gitlab_pages_slo_success_ratio 1")
+	// runtime and process
+	require.Contains(t, string(body), "gitlab_pages_go_goroutines")
+	require.Contains(t, string(body), "gitlab_pages_process_open_fds")
 
 	require.Contains(t, string(body), "gitlab_pages_domains_source_cache_hit")
 	require.Contains(t, string(body), "gitlab_pages_domains_source_cache_miss")
@@ -56,3 +71,48 @@ func TestPrometheusMetricsCanBeScraped(t *testing.T) {
 	require.Contains(t, string(body), "gitlab_pages_limit_listener_concurrent_conns")
 	require.Contains(t, string(body), "gitlab_pages_limit_listener_waiting_conns")
 }
+
+func TestPrometheusMetricsAuthentication(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("metrics-token\n"), 0600))
+
+	RunPagesProcess(t,
+		withExtraArgument("metrics-address", "127.0.0.1:0"),
+		withExtraArgument("metrics-auth-token-file", tokenFile),
+		withExtraArgument("metrics-allowed-ips", "127.0.0.1"),
+	)
+
+	tests := map[string]struct {
+		token          string
+		expectedStatus int
+	}{
+		"without_token": {
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"with_invalid_token": {
+			token:          "guess",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"with_token": {
+			token:          "metrics-token",
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, metricsListener.URL("metrics"), nil)
+			require.NoError(t, err)
+
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, tt.expectedStatus, resp.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package acceptance_test
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// signedParam is the query parameter of the URLs signed by the object storage
+// mock, it is the time the URL was signed at in nanoseconds
+const signedParam = "X-Signed-At"
+
+// objectStorage is a mock of the object storage serving the archives of the
+// zip deployments on objectStorageMockServer. It validates the Range headers
+// of the requests and injects latency and failures.
+type objectStorage struct {
+	zipFilePath string
+
+	latency     time.Duration
+	chunkSize   int
+	chunkDelay  time.Duration
+	failureRate float64
+
+	mu sync.Mutex
+	// notBefore rejects the URLs signed before it as expired
+	notBefore time.Time
+	received  []objectStorageRequest
+	random    *rand.Rand
+}
+
+// objectStorageRequest is a request received by the object storage mock
+type objectStorageRequest struct {
+	path   string
+	rng    string
+	status int
+	// invalidRange is why the Range header is invalid, if it is
+	invalidRange string
+}
+
+type objectStorageOption func(*objectStorage)
+
+// withLatency delays the responses of the object storage
+func withLatency(latency time.Duration) objectStorageOption {
+	return func(s *objectStorage) {
+		s.latency = latency
+	}
+}
+
+// withChunks writes the bodies of the responses in chunks of size bytes,
+// flushed every delay
+func withChunks(size int, delay time.Duration) objectStorageOption {
+	return func(s *objectStorage) {
+		s.chunkSize = size
+		s.chunkDelay = delay
+	}
+}
+
+// withFailureRate fails the given fraction of the requests with a 503, 1
+// fails all of them
+func withFailureRate(rate float64) objectStorageOption {
+	return func(s *objectStorage) {
+		s.failureRate = rate
+	}
+}
+
+func runObjectStorage(t *testing.T, zipFilePath string, opts ...objectStorageOption) *objectStorage {
+	t.Helper()
+
+	s := &objectStorage{
+		zipFilePath: zipFilePath,
+		random:      rand.New(rand.NewSource(1)),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	m := http.NewServeMux()
+	m.HandleFunc("/public.zip", s.serveArchive)
+	m.HandleFunc("/malformed.zip", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	// create a listener with the desired port.
+	l, err := net.Listen("tcp", objectStorageMockServer)
+	require.NoError(t, err)
+
+	testServer := httptest.NewUnstartedServer(m)
+
+	// NewUnstartedServer creates a listener. Close that listener and replace
+	// with the one we created.
+	testServer.Listener.Close()
+	testServer.Listener = l
+
+	// Start the server.
+	testServer.Start()
+
+	t.Cleanup(func() {
+		// Cleanup.
+		testServer.Close()
+	})
+
+	return s
+}
+
+// signURL returns the URL of path signed now, which is denied access once
+// the signatures expire
+func (s *objectStorage) signURL(path string) string {
+	return fmt.Sprintf("http://%s%s?%s=%d", objectStorageMockServer, path, signedParam, time.Now().UnixNano())
+}
+
+// expireSignatures denies access to the URLs signed until now, like object
+// storages do once the pre-signed URLs expire
+func (s *objectStorage) expireSignatures() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.notBefore = time.Now()
+}
+
+// requests returns the requests received so far
+func (s *objectStorage) requests() []objectStorageRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]objectStorageRequest(nil), s.received...)
+}
+
+// requireValidRanges checks that the archive was only read with range
+// requests of a single range within the archive
+func (s *objectStorage) requireValidRanges(t *testing.T) {
+	t.Helper()
+
+	requests := s.requests()
+	require.NotEmpty(t, requests, "the archive was not requested")
+
+	for _, r := range requests {
+		require.NotEmpty(t, r.rng, "%s was requested without a range", r.path)
+		require.Empty(t, r.invalidRange, "%s was requested with the invalid range %q", r.path, r.rng)
+	}
+}
+
+func (s *objectStorage) serveArchive(w http.ResponseWriter, r *http.Request) {
+	request := objectStorageRequest{path: r.URL.Path, rng: r.Header.Get("Range")}
+	sw := &statusWriter{ResponseWriter: w, storage: s}
+
+	defer func() {
+		request.status = sw.status
+
+		s.mu.Lock()
+		s.received = append(s.received, request)
+		s.mu.Unlock()
+	}()
+
+	time.Sleep(s.latency)
+
+	if s.fail() {
+		sw.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	if s.expired(r) {
+		sw.Header().Set("Content-Type", "application/xml")
+		sw.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(sw, "<Error><Code>AccessDenied</Code><Message>Request has expired</Message></Error>")
+		return
+	}
+
+	f, err := os.Open(s.zipFilePath)
+	if err != nil {
+		sw.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		sw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if request.rng != "" {
+		request.invalidRange = validateRange(request.rng, fi.Size())
+		if request.invalidRange != "" {
+			sw.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", fi.Size()))
+			sw.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+	}
+
+	http.ServeContent(sw, r, "", fi.ModTime(), f)
+}
+
+func (s *objectStorage) fail() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.random.Float64() < s.failureRate
+}
+
+func (s *objectStorage) expired(r *http.Request) bool {
+	signedAt := r.URL.Query().Get(signedParam)
+	if signedAt == "" {
+		return false
+	}
+
+	nanos, err := strconv.ParseInt(signedAt, 10, 64)
+	if err != nil {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return time.Unix(0, nanos).Before(s.notBefore)
+}
+
+// validateRange returns why the Range header rng is invalid for a file of
+// size bytes, Pages only requests single ranges within the archive
+func validateRange(rng string, size int64) string {
+	spec := strings.TrimPrefix(rng, "bytes=")
+	if spec == rng {
+		return "the unit is not bytes"
+	}
+
+	if strings.Contains(spec, ",") {
+		return "multiple ranges are requested"
+	}
+
+	bounds := strings.SplitN(spec, "-", 2)
+	if len(bounds) != 2 {
+		return "the range has no end"
+	}
+
+	start, err := strconv.ParseInt(bounds[0], 10, 64)
+	if err != nil {
+		return "the start is not a number"
+	}
+
+	end, err := strconv.ParseInt(bounds[1], 10, 64)
+	if err != nil {
+		return "the end is not a number"
+	}
+
+	switch {
+	case start > end:
+		return "the range ends before its start"
+	case end >= size:
+		return "the range ends after the end of the file"
+	}
+
+	return ""
+}
+
+// statusWriter records the status of the response and writes its body in
+// the chunks of the object storage
+type statusWriter struct {
+	http.ResponseWriter
+	storage *objectStorage
+	status  int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	size := w.storage.chunkSize
+	if size <= 0 {
+		return w.ResponseWriter.Write(b)
+	}
+
+	written := 0
+	for written < len(b) {
+		end := written + size
+		if end > len(b) {
+			end = len(b)
+		}
+
+		n, err := w.ResponseWriter.Write(b[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+
+		w.ResponseWriter.(http.Flusher).Flush()
+		time.Sleep(w.storage.chunkDelay)
+	}
+
+	return written, nil
+}
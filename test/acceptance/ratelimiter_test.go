@@ -68,6 +68,7 @@ func TestIPRateLimits(t *testing.T) {
 
 				if i >= rateLimit {
 					require.Equal(t, http.StatusTooManyRequests, rsp.StatusCode, "group.gitlab-example.com request: %d failed", i)
+					require.Equal(t, "1", rsp.Header.Get("Retry-After"), "request: %d failed", i)
 					assertLogFound(t, logBuf, []string{"request hit rate limit", "\"source_ip\":\"" + tc.clientIP + "\""})
 				} else {
 					require.Equal(t, http.StatusOK, rsp.StatusCode, "request: %d failed", i)
@@ -96,6 +97,7 @@ func TestDomainateLimits(t *testing.T) {
 
 				if i >= rateLimit {
 					require.Equal(t, http.StatusTooManyRequests, rsp.StatusCode, "group.gitlab-example.com request: %d failed", i)
+					require.Equal(t, "1", rsp.Header.Get("Retry-After"), "request: %d failed", i)
 					assertLogFound(t, logBuf, []string{"request hit rate limit", "\"source_ip\":\"" + tc.clientIP + "\""})
 				} else {
 					require.Equal(t, http.StatusOK, rsp.StatusCode, "request: %d failed", i)
@@ -112,6 +114,35 @@ func TestDomainateLimits(t *testing.T) {
 	}
 }
 
+func TestRateLimitsReportOnly(t *testing.T) {
+	testhelpers.StubFeatureFlagValue(t, feature.EnforceIPRateLimits.EnvVariable, false)
+	testhelpers.StubFeatureFlagValue(t, feature.EnforceDomainRateLimits.EnvVariable, false)
+
+	for name, tc := range ratelimitedListeners {
+		t.Run(name, func(t *testing.T) {
+			rateLimit := 2
+			logBuf := RunPagesProcess(t,
+				withListeners([]ListenSpec{tc.listener}),
+				withExtraArgument("rate-limit-source-ip", fmt.Sprint(rateLimit)),
+				withExtraArgument("rate-limit-source-ip-burst", fmt.Sprint(rateLimit)),
+				withExtraArgument("rate-limit-domain", fmt.Sprint(rateLimit)),
+				withExtraArgument("rate-limit-domain-burst", fmt.Sprint(rateLimit)),
+			)
+
+			for i := 0; i < 5; i++ {
+				rsp, err := GetPageFromListenerWithHeaders(t, tc.listener, "group.gitlab-example.com", "project/", tc.header)
+				require.NoError(t, err)
+				require.NoError(t, rsp.Body.Close())
+
+				require.Equal(t, http.StatusOK, rsp.StatusCode, "request: %d failed", i)
+				require.Empty(t, rsp.Header.Get("Retry-After"), "request: %d failed", i)
+			}
+
+			assertLogFound(t, logBuf, []string{"request hit rate limit", "\"rate_limiter_enabled\":false"})
+		})
+	}
+}
+
 func assertLogFound(t *testing.T, logBuf *LogCaptureBuffer, expectedLogs []string) {
 	t.Helper()
 
@@ -24,7 +24,7 @@ func TestRedirectStatusPage(t *testing.T) {
 	require.NoError(t, err)
 	defer rsp.Body.Close()
 
-	require.Contains(t, string(body), "14 rules")
+	require.Contains(t, string(body), "15 rules")
 	require.Equal(t, http.StatusOK, rsp.StatusCode)
 }
 
@@ -68,6 +68,13 @@ func TestRedirect(t *testing.T) {
 			expectedStatus:   http.StatusOK,
 			expectedLocation: "",
 		},
+		// Forced rules should override actual files on disk
+		{
+			host:             "group.redirects.gitlab-example.com",
+			path:             "/project-redirects/forced-override.html",
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "/project-redirects/magic-land.html",
+		},
 		// Group-level domain
 		{
 			host:             "group.redirects.gitlab-example.com",
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -210,7 +212,7 @@ func TestCORSAllowsMethod(t *testing.T) {
 		{
 			name:           "cors-forbids-post",
 			method:         http.MethodPost,
-			expectedStatus: http.StatusOK,
+			expectedStatus: http.StatusMethodNotAllowed,
 			expectedOrigin: "",
 		},
 	}
@@ -244,11 +246,95 @@ func TestCustomHeaders(t *testing.T) {
 	}
 }
 
+func TestCustomHeadersScopesAndRemovals(t *testing.T) {
+	RunPagesProcess(t,
+		withExtraArgument("header", "X-Scoped: all"),
+		withExtraArgument("header", "[https] X-Scoped: https"),
+		withExtraArgument("header", "-Last-Modified"),
+	)
+
+	for _, spec := range supportedListeners() {
+		rsp, err := GetPageFromListener(t, spec, "group.gitlab-example.com", "project/")
+		require.NoError(t, err)
+		defer rsp.Body.Close()
+
+		require.Equal(t, http.StatusOK, rsp.StatusCode)
+		require.Empty(t, rsp.Header.Get("Last-Modified"), "headers set by Pages are removed")
+
+		expected := "all"
+		if strings.HasPrefix(spec.URL(""), "https") {
+			expected = "https"
+		}
+		require.Equal(t, []string{expected}, rsp.Header.Values("X-Scoped"), spec.Type)
+	}
+}
+
+func TestServerHeader(t *testing.T) {
+	RunPagesProcess(t, withExtraArgument("server-header", "GitLab Pages"))
+
+	for _, spec := range supportedListeners() {
+		rsp, err := GetPageFromListener(t, spec, "group.gitlab-example.com", "project/")
+		require.NoError(t, err)
+		rsp.Body.Close()
+
+		require.Equal(t, http.StatusOK, rsp.StatusCode)
+		require.Equal(t, "GitLab Pages", rsp.Header.Get("Server"), spec.Type)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, httpListener.URL("project/"), nil)
+	require.NoError(t, err)
+	req.Host = "group.gitlab-example.com"
+
+	rsp, err := DoPagesRequest(t, httpListener, req)
+	require.NoError(t, err)
+	rsp.Body.Close()
+
+	require.Equal(t, http.StatusMethodNotAllowed, rsp.StatusCode)
+	require.Equal(t, "GitLab Pages", rsp.Header.Get("Server"), "rejected requests have the header too")
+}
+
+func TestForceNoIndex(t *testing.T) {
+	RunPagesProcess(t, withExtraArgument("force-noindex", "true"))
+
+	tests := map[string]struct {
+		host           string
+		path           string
+		expectedStatus int
+	}{
+		"page": {
+			host:           "group.gitlab-example.com",
+			path:           "project/",
+			expectedStatus: http.StatusOK,
+		},
+		"not_found": {
+			host:           "group.gitlab-example.com",
+			path:           "project/not-existing",
+			expectedStatus: http.StatusNotFound,
+		},
+		"unknown_domain": {
+			host:           "unknown.example.com",
+			path:           "",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			rsp, err := GetPageFromListener(t, httpListener, tt.host, tt.path)
+			require.NoError(t, err)
+			rsp.Body.Close()
+
+			require.Equal(t, tt.expectedStatus, rsp.StatusCode)
+			require.Equal(t, []string{"noindex, nofollow"}, rsp.Header.Values("X-Robots-Tag"))
+		})
+	}
+}
+
 func TestKnownHostWithPortReturns200(t *testing.T) {
 	RunPagesProcess(t)
 
 	for _, spec := range supportedListeners() {
-		rsp, err := GetPageFromListener(t, spec, "group.gitlab-example.com:"+spec.Port, "project/")
+		rsp, err := GetPageFromListener(t, spec, "group.gitlab-example.com:"+spec.boundPort(), "project/")
 
 		require.NoError(t, err)
 		rsp.Body.Close()
@@ -286,6 +372,145 @@ func TestHttpToHttpsRedirectEnabled(t *testing.T) {
 	require.Equal(t, http.StatusOK, rsp.StatusCode)
 }
 
+func TestHttpToHttpsRedirectExcludedPaths(t *testing.T) {
+	RunPagesProcess(t,
+		withExtraArgument("redirect-http", "true"),
+		withExtraArgument("redirect-http-exclude", "/project/subdir"),
+	)
+
+	tests := map[string]struct {
+		path           string
+		expectedStatus int
+	}{
+		"excluded_prefix":       {path: "project/subdir/", expectedStatus: http.StatusOK},
+		"excluded_subpath":      {path: "project/subdir/index.html", expectedStatus: http.StatusOK},
+		"not_excluded":          {path: "project/", expectedStatus: http.StatusTemporaryRedirect},
+		"same_prefix_not_child": {path: "project/subdirectory/", expectedStatus: http.StatusTemporaryRedirect},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			rsp, err := GetRedirectPage(t, httpListener, "group.gitlab-example.com", tt.path)
+			require.NoError(t, err)
+			defer rsp.Body.Close()
+
+			require.Equal(t, tt.expectedStatus, rsp.StatusCode)
+		})
+	}
+}
+
+func TestRedirectUncertifiedDomains(t *testing.T) {
+	RunPagesProcess(t,
+		withListeners([]ListenSpec{httpListener}),
+		withExtraArgument("redirect-uncertified-domains", "true"),
+	)
+
+	rsp, err := GetRedirectPage(t, httpListener, "uncertified.custom-domain.com", "path/index.html?q=1")
+	require.NoError(t, err)
+	defer rsp.Body.Close()
+
+	require.Equal(t, http.StatusFound, rsp.StatusCode)
+	require.Equal(t, "https://group.redirects.gitlab-example.com/custom-domain/path/index.html?q=1", rsp.Header.Get("Location"))
+
+	// domains without a pages URL are served as usual
+	rsp, err = GetRedirectPage(t, httpListener, "test2.my-domain.com", "/")
+	require.NoError(t, err)
+	defer rsp.Body.Close()
+
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+}
+
+func TestRedirectAliasToPrimaryDomain(t *testing.T) {
+	RunPagesProcess(t,
+		withListeners([]ListenSpec{httpListener}),
+	)
+
+	rsp, err := GetRedirectPage(t, httpListener, "alias.custom-domain.com", "path/index.html?q=1")
+	require.NoError(t, err)
+	defer rsp.Body.Close()
+
+	require.Equal(t, http.StatusMovedPermanently, rsp.StatusCode)
+	require.Equal(t, "http://redirects.custom-domain.com/path/index.html?q=1", rsp.Header.Get("Location"))
+}
+
+func TestRedirectToCanonicalDomain(t *testing.T) {
+	RunPagesProcess(t,
+		withListeners([]ListenSpec{httpListener}),
+	)
+
+	rsp, err := GetRedirectPage(t, httpListener, "group.redirects.gitlab-example.com", "custom-domain/path/index.html?q=1")
+	require.NoError(t, err)
+	defer rsp.Body.Close()
+
+	require.Equal(t, http.StatusMovedPermanently, rsp.StatusCode)
+	require.Equal(t, "http://redirects.custom-domain.com/path/index.html?q=1", rsp.Header.Get("Location"))
+
+	// the project is served on its canonical domain
+	rsp, err = GetRedirectPage(t, httpListener, "redirects.custom-domain.com", "/_redirects")
+	require.NoError(t, err)
+	defer rsp.Body.Close()
+
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+}
+
+func TestProjectIPAccess(t *testing.T) {
+	RunPagesProcess(t,
+		withListeners([]ListenSpec{httpListener}),
+	)
+
+	rsp, err := GetPageFromListener(t, httpListener, "ip-allowed.domain.com", "index.html")
+	require.NoError(t, err)
+	defer rsp.Body.Close()
+
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	rsp, err = GetPageFromListener(t, httpListener, "ip-denied.domain.com", "index.html")
+	require.NoError(t, err)
+	defer rsp.Body.Close()
+
+	require.Equal(t, http.StatusForbidden, rsp.StatusCode)
+}
+
+func TestProjectIPAccessSpoofedForwardedFor(t *testing.T) {
+	RunPagesProcess(t,
+		withListeners([]ListenSpec{httpListener, proxyListener}),
+	)
+
+	tests := map[string]struct {
+		spec         ListenSpec
+		forwardedFor string
+		expected     int
+	}{
+		"http_listener_ignores_the_header": {
+			spec:         httpListener,
+			forwardedFor: "10.1.1.1",
+			expected:     http.StatusForbidden,
+		},
+		"proxy_listener_ignores_the_address_sent_by_the_client": {
+			spec:         proxyListener,
+			forwardedFor: "10.1.1.1, 203.0.113.1",
+			expected:     http.StatusForbidden,
+		},
+		"proxy_listener_uses_the_address_appended_by_the_proxy": {
+			spec:         proxyListener,
+			forwardedFor: "203.0.113.1, 10.1.1.1",
+			expected:     http.StatusOK,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			header := http.Header{"X-Forwarded-For": []string{tt.forwardedFor}}
+
+			rsp, err := GetPageFromListenerWithHeaders(t, tt.spec, "ip-denied.domain.com", "index.html", header)
+			require.NoError(t, err)
+			defer rsp.Body.Close()
+
+			require.Equal(t, tt.expected, rsp.StatusCode)
+		})
+	}
+}
+
 func TestHTTPSRedirect(t *testing.T) {
 	RunPagesProcess(t,
 		withListeners([]ListenSpec{httpListener}),
@@ -422,6 +647,107 @@ func TestDomainResolverError(t *testing.T) {
 	}
 }
 
+func TestDeletedDomain(t *testing.T) {
+	domainName := "deleted.gitlab.io"
+	opts := &stubOpts{}
+	opts.pagesHandler = func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("host") != domainName {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		opts.setAPICalled(true)
+		w.WriteHeader(http.StatusGone)
+	}
+
+	gonePage := filepath.Join(t.TempDir(), "410.html")
+	require.NoError(t, os.WriteFile(gonePage, []byte("moved to a new site"), 0644))
+
+	RunPagesProcess(t,
+		withListeners([]ListenSpec{httpListener}),
+		withStubOptions(opts),
+		withArguments([]string{"-gone-page-file", gonePage}),
+	)
+
+	for i := 0; i < 2; i++ {
+		opts.setAPICalled(false)
+
+		response, err := GetPageFromListener(t, httpListener, domainName, "/index.html")
+		require.NoError(t, err)
+
+		body, err := io.ReadAll(response.Body)
+		response.Body.Close()
+		require.NoError(t, err)
+
+		require.Equal(t, http.StatusGone, response.StatusCode)
+		require.Equal(t, "moved to a new site", string(body))
+
+		if i > 0 {
+			require.False(t, opts.getAPICalled(), "the deleted domain is cached")
+		}
+	}
+
+	response, err := GetPageFromListener(t, httpListener, "unknown.gitlab.io", "/index.html")
+	require.NoError(t, err)
+	response.Body.Close()
+	require.Equal(t, http.StatusNotFound, response.StatusCode, "domains which never existed are not found")
+}
+
+func TestUnverifiedDomain(t *testing.T) {
+	runObjectStorage(t, "../../shared/pages/group/zip.gitlab.io/public.zip")
+
+	domainName := "unverified.example.com"
+	opts := &stubOpts{}
+	opts.pagesHandler = func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("host") != domainName {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		fmt.Fprint(w, `{"verified": false, "verification_code": "gitlab-pages-verification-code=abc",
+			"lookup_paths": [{"prefix": "/", "project_id": 123, "source": {"type": "zip",
+			"path": "http://127.0.0.1:38001/public.zip", "sha256": "a8085b818beaf93ad5319592acb5f8eb3fcada67f5eb025c83b5470b72e585fc"}}]}`)
+	}
+
+	RunPagesProcess(t,
+		withListeners([]ListenSpec{httpListener}),
+		withStubOptions(opts),
+	)
+
+	tests := map[string]struct {
+		path            string
+		expectedStatus  int
+		expectedContent string
+	}{
+		"project_content": {
+			path:            "/index.html",
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: "This domain is not verified yet.",
+		},
+		"verification_code": {
+			path:            "/.well-known/gitlab-pages-verification",
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: "This domain is not verified yet.",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			response, err := GetPageFromListener(t, httpListener, domainName, tt.path)
+			require.NoError(t, err)
+			defer response.Body.Close()
+
+			require.Equal(t, tt.expectedStatus, response.StatusCode)
+
+			body, err := io.ReadAll(response.Body)
+			require.NoError(t, err)
+			require.Contains(t, string(body), tt.expectedContent)
+			require.NotContains(t, string(body), "zip.gitlab.io/project")
+			require.NotContains(t, string(body), "gitlab-pages-verification-code=abc")
+		})
+	}
+}
+
 func doCrossOriginRequest(t *testing.T, spec ListenSpec, method, reqMethod, url string) *http.Response {
 	req, err := http.NewRequest(method, url, nil)
 	require.NoError(t, err)
@@ -452,7 +778,7 @@ func TestQueryStringPersistedInSlashRewrite(t *testing.T) {
 	require.NoError(t, err)
 	defer rsp.Body.Close()
 
-	require.Equal(t, http.StatusFound, rsp.StatusCode)
+	require.Equal(t, http.StatusMovedPermanently, rsp.StatusCode)
 	require.Equal(t, 1, len(rsp.Header["Location"]))
 	require.Equal(t, "//group.gitlab-example.com/project/?q=test", rsp.Header.Get("Location"))
 
@@ -462,6 +788,19 @@ func TestQueryStringPersistedInSlashRewrite(t *testing.T) {
 	require.Equal(t, http.StatusOK, rsp.StatusCode)
 }
 
+func TestDirectoryRedirectStatus(t *testing.T) {
+	RunPagesProcess(t, withExtraArgument("directory-redirect-status", "302"))
+
+	for _, spec := range supportedListeners() {
+		rsp, err := GetRedirectPage(t, spec, "group.gitlab-example.com", "project/subdir")
+		require.NoError(t, err)
+		rsp.Body.Close()
+
+		require.Equal(t, http.StatusFound, rsp.StatusCode, spec.Type)
+		require.Equal(t, "//group.gitlab-example.com/project/subdir/", rsp.Header.Get("Location"), spec.Type)
+	}
+}
+
 func TestServerRepliesWithHeaders(t *testing.T) {
 	tests := map[string]struct {
 		flags           []string
@@ -57,11 +57,37 @@ var DomainResponses = map[string]responseFn{
 		projectID:  1234,
 		pathOnDisk: "group.acme/with.acme.challenge",
 	}),
-	"group.redirects.gitlab-example.com": generateVirtualDomainFromDir("group.redirects", "group.redirects.gitlab-example.com", nil),
+	"ip-allowed.domain.com": customDomain(projectConfig{
+		projectID:  1235,
+		pathOnDisk: "group.acme/with.acme.challenge",
+		ipAccess:   &api.IPAccess{Allow: []string{"127.0.0.0/8", "::1"}},
+	}),
+	"ip-denied.domain.com": customDomain(projectConfig{
+		projectID:  1236,
+		pathOnDisk: "group.acme/with.acme.challenge",
+		ipAccess:   &api.IPAccess{Allow: []string{"10.0.0.0/8"}},
+	}),
+	"group.redirects.gitlab-example.com": generateVirtualDomainFromDir("group.redirects", "group.redirects.gitlab-example.com", map[string]projectConfig{
+		"/custom-domain": {
+			projectID:       1001,
+			canonicalDomain: "redirects.custom-domain.com",
+		},
+	}),
 	"redirects.custom-domain.com": customDomain(projectConfig{
 		projectID:  1001,
 		pathOnDisk: "group.redirects/custom-domain",
 	}),
+	"alias.custom-domain.com": customDomain(projectConfig{
+		projectID:       1001,
+		pathOnDisk:      "group.redirects/custom-domain",
+		primaryDomain:   "redirects.custom-domain.com",
+		redirectAliases: true,
+	}),
+	"uncertified.custom-domain.com": customDomain(projectConfig{
+		projectID:  1003,
+		pathOnDisk: "group.redirects/custom-domain",
+		pagesURL:   "https://group.redirects.gitlab-example.com/custom-domain",
+	}),
 	"test.my-domain.com": customDomain(projectConfig{
 		projectID:  1002,
 		https:      true,
@@ -112,6 +138,12 @@ var DomainResponses = map[string]responseFn{
 		accessControl: true,
 		pathOnDisk:    "group.auth/private.project",
 	}),
+	"embedded.domain.com": customDomain(projectConfig{
+		projectID:      1007,
+		accessControl:  true,
+		pathOnDisk:     "group.auth/private.project",
+		frameAncestors: []string{"https://tool.example.com"},
+	}),
 	// NOTE: before adding more domains here, generate the zip archive by running (per project)
 	// make zip PROJECT_SUBDIR=group/serving
 	// make zip PROJECT_SUBDIR=group/project2
@@ -169,9 +201,11 @@ func generateVirtualDomainFromDir(dir, rootDomain string, perPrefixConfig map[st
 			sha := hex.EncodeToString(sum[:])
 
 			lookupPath := api.LookupPath{
-				ProjectID:     cfg.projectID,
-				AccessControl: cfg.accessControl,
-				HTTPSOnly:     cfg.https,
+				ProjectID:       cfg.projectID,
+				AccessControl:   cfg.accessControl,
+				HTTPSOnly:       cfg.https,
+				CanonicalDomain: cfg.canonicalDomain,
+				IPAccess:        cfg.ipAccess,
 				// gitlab.Resolve logic expects prefix to have ending slash
 				Prefix: ensureEndingSlash(prefix),
 				Source: api.Source{
@@ -196,6 +230,19 @@ type projectConfig struct {
 	accessControl bool
 	https         bool
 	pathOnDisk    string
+	// frameAncestors allows custom domains to be embedded by other sites
+	frameAncestors []string
+	// pagesURL is the URL of the project of a custom domain on the pages domain
+	pagesURL string
+	// primaryDomain is the domain requests to aliases are redirected to when
+	// redirectAliases is true
+	primaryDomain   string
+	redirectAliases bool
+	// canonicalDomain is the custom domain the requests to the project on
+	// the pages domain are redirected to
+	canonicalDomain string
+	// ipAccess restricts the clients allowed to view the project
+	ipAccess *api.IPAccess
 }
 
 // customDomain with per project config
@@ -207,14 +254,25 @@ func customDomain(config projectConfig) responseFn {
 		sum := sha256.Sum256([]byte(sourcePath))
 		sha := hex.EncodeToString(sum[:])
 
+		var embedding *api.EmbeddingPolicy
+		if len(config.frameAncestors) > 0 {
+			embedding = &api.EmbeddingPolicy{FrameAncestors: config.frameAncestors}
+		}
+
 		return api.VirtualDomain{
 			Certificate: "",
 			Key:         "",
+			Embedding:   embedding,
+			PagesURL:    config.pagesURL,
+
+			PrimaryDomain:   config.primaryDomain,
+			RedirectAliases: config.redirectAliases,
 			LookupPaths: []api.LookupPath{
 				{
 					ProjectID:     config.projectID,
 					AccessControl: config.accessControl,
 					HTTPSOnly:     config.https,
+					IPAccess:      config.ipAccess,
 					// prefix should always be `/` for custom domains, otherwise `resolvePath` will try
 					// to look for files under public/prefix/ when serving content instead of just public/
 					// see internal/serving/disk/ for details
@@ -22,3 +22,54 @@ func TestUnknownHTTPMethod(t *testing.T) {
 
 	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
 }
+
+func TestNotAllowedHTTPMethod(t *testing.T) {
+	RunPagesProcess(t,
+		withListeners([]ListenSpec{httpListener}),
+	)
+
+	req, err := http.NewRequest(http.MethodPost, httpListener.URL("index.html"), nil)
+	require.NoError(t, err)
+	req.Host = "group.gitlab-example.com"
+
+	resp, err := DoPagesRequest(t, httpListener, req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	require.Equal(t, "GET, HEAD, OPTIONS", resp.Header.Get("Allow"))
+}
+
+func TestConfiguredAllowedHTTPMethods(t *testing.T) {
+	RunPagesProcess(t,
+		withListeners([]ListenSpec{httpListener}),
+		withExtraArgument("allowed-http-methods", "GET,POST"),
+	)
+
+	req, err := http.NewRequest(http.MethodPost, httpListener.URL("index.html"), nil)
+	require.NoError(t, err)
+	req.Host = "group.gitlab-example.com"
+
+	resp, err := DoPagesRequest(t, httpListener, req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestOptionsHTTPMethod(t *testing.T) {
+	RunPagesProcess(t,
+		withListeners([]ListenSpec{httpListener}),
+	)
+
+	req, err := http.NewRequest(http.MethodOptions, httpListener.URL("index.html"), nil)
+	require.NoError(t, err)
+	req.Host = "group.gitlab-example.com"
+
+	resp, err := DoPagesRequest(t, httpListener, req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "GET, HEAD, OPTIONS", resp.Header.Get("Allow"))
+}
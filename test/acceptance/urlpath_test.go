@@ -0,0 +1,71 @@
+package acceptance_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodedPaths(t *testing.T) {
+	RunPagesProcess(t, withListeners([]ListenSpec{httpListener}))
+
+	tests := map[string]struct {
+		path             string
+		expectedStatus   int
+		expectedContent  string
+		expectedLocation string
+	}{
+		"encoded_slash": {
+			path:            "project%2Fsubdir/",
+			expectedStatus:  http.StatusOK,
+			expectedContent: "project-subsubdir\n",
+		},
+		"encoded_traversal_within_the_domain": {
+			path:            "project/subdir/..%2F..%2Fproject%2Fsubdir/",
+			expectedStatus:  http.StatusOK,
+			expectedContent: "project-subsubdir\n",
+		},
+		"directory_redirect_keeps_encoding": {
+			path:             "project%2Fsubdir",
+			expectedStatus:   http.StatusMovedPermanently,
+			expectedLocation: "//group.gitlab-example.com/project%2Fsubdir/",
+		},
+		"double_encoded_dots_are_literal": {
+			path:           "project/%252e%252e/index.html",
+			expectedStatus: http.StatusNotFound,
+		},
+		"fullwidth_dot_segment": {
+			path:           "project/%EF%BC%8E%EF%BC%8E/index.html",
+			expectedStatus: http.StatusBadRequest,
+		},
+		"null_byte": {
+			path:           "project/index.html%00.png",
+			expectedStatus: http.StatusBadRequest,
+		},
+		"encoded_query_within_the_uri_limit": {
+			path:            "project%2Fsubdir/?q=" + strings.Repeat("%C3%A9", 150),
+			expectedStatus:  http.StatusOK,
+			expectedContent: "project-subsubdir\n",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			rsp, err := GetRedirectPage(t, httpListener, "group.gitlab-example.com", tt.path)
+			require.NoError(t, err)
+			defer rsp.Body.Close()
+
+			require.Equal(t, tt.expectedStatus, rsp.StatusCode)
+			require.Equal(t, tt.expectedLocation, rsp.Header.Get("Location"))
+
+			if tt.expectedContent != "" {
+				b, err := io.ReadAll(rsp.Body)
+				require.NoError(t, err)
+				require.Equal(t, tt.expectedContent, string(b))
+			}
+		})
+	}
+}
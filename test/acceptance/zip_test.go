@@ -1,15 +1,16 @@
 package acceptance_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
-	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
 )
 
 func TestZipServing(t *testing.T) {
@@ -338,33 +339,119 @@ func TestZipServingConfigShortTimeout(t *testing.T) {
 	require.Equal(t, http.StatusInternalServerError, response.StatusCode, "should fail to serve")
 }
 
-func runObjectStorage(t *testing.T, zipFilePath string) {
-	t.Helper()
+func TestZipServingRangeRequests(t *testing.T) {
+	storage := runObjectStorage(t, "../../shared/pages/group/zip.gitlab.io/public.zip")
+
+	RunPagesProcess(t,
+		withListeners([]ListenSpec{httpListener}),
+	)
 
-	m := http.NewServeMux()
-	m.HandleFunc("/public.zip", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, zipFilePath)
-	}))
-	m.HandleFunc("/malformed.zip", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusInternalServerError)
-	}))
+	for path, expectedContent := range map[string]string{
+		"/index.html":         "zip.gitlab.io/project/index.html\n",
+		"/subdir/hello.html":  "zip.gitlab.io/project/subdir/hello.html\n",
+		"/symlink.html":       "symlink.html->subdir/linked.html\n",
+		"/subdir/linked.html": "symlink.html->subdir/linked.html\n",
+	} {
+		requireZipContent(t, "zip.gitlab.io", path, expectedContent)
+	}
+
+	storage.requireValidRanges(t)
+}
 
-	// create a listener with the desired port.
-	l, err := net.Listen("tcp", objectStorageMockServer)
+func TestZipServingSlowObjectStorage(t *testing.T) {
+	storage := runObjectStorage(t, "../../shared/pages/group/zip.gitlab.io/public.zip",
+		withLatency(20*time.Millisecond),
+		withChunks(64, time.Millisecond),
+	)
+
+	RunPagesProcess(t,
+		withListeners([]ListenSpec{httpListener}),
+	)
+
+	requireZipContent(t, "zip.gitlab.io", "/subdir/hello.html", "zip.gitlab.io/project/subdir/hello.html\n")
+
+	storage.requireValidRanges(t)
+}
+
+func TestZipServingObjectStorageFailures(t *testing.T) {
+	runObjectStorage(t, "../../shared/pages/group/zip.gitlab.io/public.zip",
+		withFailureRate(1),
+	)
+
+	RunPagesProcess(t,
+		withListeners([]ListenSpec{httpListener}),
+	)
+
+	response, err := GetPageFromListener(t, httpListener, "zip.gitlab.io", "/index.html")
 	require.NoError(t, err)
+	defer response.Body.Close()
+
+	require.Equal(t, http.StatusInternalServerError, response.StatusCode)
+}
+
+func TestZipServingExpiredSignature(t *testing.T) {
+	storage := runObjectStorage(t, "../../shared/pages/group/zip.gitlab.io/public.zip")
+
+	opts := &stubOpts{}
+	opts.pagesHandler = func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("host") != "signed-zip.gitlab.io" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		opts.setAPICalled(true)
+
+		// every lookup returns a newly signed URL of the archive
+		require.NoError(t, json.NewEncoder(w).Encode(api.VirtualDomain{
+			LookupPaths: []api.LookupPath{{
+				ProjectID: 123,
+				Prefix:    "/",
+				Source: api.Source{
+					Type:   "zip",
+					Path:   storage.signURL("/public.zip"),
+					SHA256: "signed-zip",
+				},
+			}},
+		}))
+	}
+
+	RunPagesProcess(t,
+		withListeners([]ListenSpec{httpListener}),
+		withStubOptions(opts),
+	)
+
+	requireZipContent(t, "signed-zip.gitlab.io", "/index.html", "zip.gitlab.io/project/index.html\n")
 
-	testServer := httptest.NewUnstartedServer(m)
+	storage.expireSignatures()
+	opts.setAPICalled(false)
 
-	// NewUnstartedServer creates a listener. Close that listener and replace
-	// with the one we created.
-	testServer.Listener.Close()
-	testServer.Listener = l
+	// the archive is already opened, reading another file of it is denied
+	// access until its URL is refreshed
+	requireZipContent(t, "signed-zip.gitlab.io", "/subdir/hello.html", "zip.gitlab.io/project/subdir/hello.html\n")
+	require.True(t, opts.getAPICalled(), "the URL of the archive is refreshed")
 
-	// Start the server.
-	testServer.Start()
+	var denied int
+	for _, r := range storage.requests() {
+		if r.status == http.StatusForbidden {
+			denied++
+		}
+	}
+
+	require.Equal(t, 1, denied, "the refreshed URL is used")
+	storage.requireValidRanges(t)
+}
+
+func requireZipContent(t *testing.T, host, path, expectedContent string) {
+	t.Helper()
+
+	response, err := GetPageFromListener(t, httpListener, host, path)
+	require.NoError(t, err)
+	defer response.Body.Close()
+
+	require.Equal(t, http.StatusOK, response.StatusCode, path)
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
 
-	t.Cleanup(func() {
-		// Cleanup.
-		testServer.Close()
-	})
+	require.Equal(t, expectedContent, string(body), path)
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/archivecheck"
+)
+
+const validateCommand = "validate"
+
+const validateUsage = `Usage: gitlab-pages validate [options] <archive.zip>
+
+Inspects a Pages deployment archive and reports problems that affect serving it.
+The exit status is 1 when the archive contains errors.
+
+Options:
+`
+
+// runValidate runs the validate subcommand and returns the exit status
+func runValidate(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet(validateCommand, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, validateUsage)
+		flags.PrintDefaults()
+	}
+
+	jsonOutput := flags.Bool("json", false, "Print the report as JSON")
+	maxSize := flags.Int64("max-size", 0, "Maximum total uncompressed size of the public directory in bytes, 0 to disable the check")
+	openTimeout := flags.Duration("open-timeout", time.Minute, "Timeout to open the archive")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	report, err := archivecheck.Check(
+		context.Background(),
+		flags.Arg(0),
+		archivecheck.WithMaxSize(*maxSize),
+		archivecheck.WithOpenTimeout(*openTimeout),
+	)
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to open archive: %v\n", err)
+		return 1
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			fmt.Fprintf(stderr, "failed to encode report: %v\n", err)
+			return 1
+		}
+	} else {
+		printReport(stdout, report)
+	}
+
+	if !report.Valid() {
+		return 1
+	}
+
+	return 0
+}
+
+func printReport(w io.Writer, report *archivecheck.Report) {
+	fmt.Fprintf(w, "Archive: %s\n", report.Archive)
+	if report.PublicDirectory != "" {
+		fmt.Fprintf(w, "Public directory: %s\n", report.PublicDirectory)
+	}
+	fmt.Fprintf(w, "Entries: %d files, %d directories, %d symlinks\n", report.Files, report.Directories, report.Symlinks)
+	fmt.Fprintf(w, "Total size: %d bytes\n", report.TotalSize)
+
+	for _, issue := range report.Issues {
+		if issue.Path != "" {
+			fmt.Fprintf(w, "%s: %s: %s\n", issue.Severity, issue.Path, issue.Message)
+		} else {
+			fmt.Fprintf(w, "%s: %s\n", issue.Severity, issue.Message)
+		}
+	}
+
+	if report.Valid() {
+		fmt.Fprintln(w, "Archive is valid")
+	} else {
+		fmt.Fprintln(w, "Archive is invalid")
+	}
+}